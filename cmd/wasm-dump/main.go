@@ -170,6 +170,14 @@ func printHeaders(w io.Writer, fname string, m *wasm.Module) {
 			len(sec.Entries),
 		)
 	}
+	if sec := m.DataCount; sec != nil {
+		hdrfmt := "%9s start=0x%08x end=0x%08x (size=0x%08x) count: %d\n"
+		fmt.Fprintf(w, hdrfmt,
+			sec.ID.String(),
+			sec.RawSection.Start, sec.RawSection.End, len(sec.RawSection.Bytes),
+			sec.Count,
+		)
+	}
 	if sec := m.Code; sec != nil {
 		fmt.Fprintf(w, hdrfmt,
 			sec.ID.String(),
@@ -222,6 +230,9 @@ func printDis(w io.Writer, fname string, m *wasm.Module) {
 			buf := new(bytes.Buffer)
 			str := new(bytes.Buffer)
 			fmt.Fprintf(buf, "%02x", code.Op.Code)
+			if code.Op.IsPrefixed() {
+				fmt.Fprintf(buf, " %02x", code.Op.Sub)
+			}
 			fmt.Fprintf(str, "%v", code.Op.Name)
 			for _, im := range code.Immediates {
 				imbuf := new(bytes.Buffer)
@@ -322,17 +333,33 @@ func printDetails(w io.Writer, fname string, m *wasm.Module) {
 	if sec := m.Elements; sec != nil {
 		fmt.Fprintf(w, "%v:\n", sec.ID)
 		for i, e := range sec.Entries {
-			fmt.Fprintf(w, " - segment[%d] table=%d\n", i, e.Index)
-			fmt.Fprintf(w, " - init: %#v\n", e.Offset)
-			for ii, elem := range e.Elems {
-				fmt.Fprintf(w, "  - elem[%d] = func[%d]\n", ii, elem)
+			if e.Mode == wasm.SegmentActive {
+				fmt.Fprintf(w, " - segment[%d] table=%d\n", i, e.Index)
+				fmt.Fprintf(w, " - init: %#v\n", e.Offset)
+			} else {
+				fmt.Fprintf(w, " - segment[%d] %v\n", i, e.Mode)
+			}
+			for ii := 0; ii < e.Len(); ii++ {
+				elem, ok, err := e.Elem(ii)
+				switch {
+				case err != nil:
+					fmt.Fprintf(w, "  - elem[%d] = <%v>\n", ii, err)
+				case ok:
+					fmt.Fprintf(w, "  - elem[%d] = func[%d]\n", ii, elem)
+				default:
+					fmt.Fprintf(w, "  - elem[%d] = null\n", ii)
+				}
 			}
 		}
 	}
 	if sec := m.Data; sec != nil {
 		fmt.Fprintf(w, "%v:\n", sec.ID)
 		for i, e := range sec.Entries {
-			fmt.Fprintf(w, " - segment[%d] size=%d - init %#v\n", i, len(e.Data), e.Offset)
+			if e.Mode == wasm.SegmentActive {
+				fmt.Fprintf(w, " - segment[%d] size=%d - init %#v\n", i, len(e.Data), e.Offset)
+			} else {
+				fmt.Fprintf(w, " - segment[%d] size=%d - %v\n", i, len(e.Data), e.Mode)
+			}
 			fmt.Fprintf(w, "%s", hexDump(e.Data, 0))
 		}
 	}
@@ -10,18 +10,35 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/go-interpreter/wagon/emscripten"
 	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/gojs"
 	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasi"
 	"github.com/go-interpreter/wagon/wasm"
 )
 
+// stringsFlag is a flag which can be given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
 func main() {
 	log.SetPrefix("wasm-run: ")
 	log.SetFlags(0)
 
 	verbose := flag.Bool("v", false, "enable/disable verbose mode")
 	verify := flag.Bool("verify-module", false, "run module verification")
+	var cfg config
+	flag.Var(&cfg.dirs, "dir", "give WASI programs access to a `directory`, as dir or guest=host (repeatable)")
+	flag.Var(&cfg.env, "env", "set an environment variable of the program, as `key=value` (repeatable)")
 
 	flag.Parse()
 
@@ -32,17 +49,77 @@ func main() {
 
 	wasm.SetDebugMode(*verbose)
 
-	run(os.Stdout, flag.Arg(0), *verify)
+	cfg.args = flag.Args()
+	os.Exit(run(os.Stdout, flag.Arg(0), *verify, cfg))
 }
 
-func run(w io.Writer, fname string, verify bool) {
+// config holds the arguments and the environment of the programs compiled
+// for WASI or by the Go toolchain, and the directories WASI programs can
+// access.
+type config struct {
+	args []string
+	env  stringsFlag
+	dirs stringsFlag
+}
+
+// run runs the module fname, and returns the exit code of the program.
+//
+// The _start function of WASI programs, the main function of the programs
+// compiled by Emscripten, and the programs compiled by the Go toolchain
+// with GOOS=js, are run with w as their standard output. The exported
+// functions of other modules are all run, and their results are written
+// to w.
+func run(w io.Writer, fname string, verify bool, cfg config) int {
 	f, err := os.Open(fname)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
 
-	m, err := wasm.ReadModule(f, importer)
+	wasiOpts := []wasi.Option{
+		wasi.Args(cfg.args...),
+		wasi.Environ(cfg.env...),
+		wasi.Stdin(os.Stdin),
+		wasi.Stdout(w),
+		wasi.Stderr(os.Stderr),
+	}
+	for _, dir := range cfg.dirs {
+		guest, host := dir, dir
+		if i := strings.Index(dir, "="); i >= 0 {
+			guest, host = dir[:i], dir[i+1:]
+		}
+		wasiOpts = append(wasiOpts, wasi.Preopen(guest, wasi.DirFS(host)))
+	}
+	sys := wasi.New(wasiOpts...)
+	rt := gojs.New(
+		gojs.Args(cfg.args...),
+		gojs.Environ(cfg.env...),
+		gojs.Stdin(os.Stdin),
+		gojs.Stdout(w),
+		gojs.Stderr(os.Stderr),
+	)
+	env := emscripten.New(
+		emscripten.Stdin(os.Stdin),
+		emscripten.Stdout(w),
+		emscripten.Stderr(os.Stderr),
+	)
+
+	goProgram, emProgram := false, false
+	m, err := wasm.ReadModule(f, func(name string) (*wasm.Module, error) {
+		switch name {
+		case wasi.ModuleName:
+			return sys.Module(), nil
+		case gojs.ModuleName, gojs.LegacyModuleName:
+			goProgram = true
+			return rt.Module(), nil
+		case emscripten.ModuleName:
+			if _, err := os.Stat(name + ".wasm"); err != nil {
+				emProgram = true
+				return env.Module(), nil
+			}
+		}
+		return importer(name)
+	})
 	if err != nil {
 		log.Fatalf("could not read module: %v", err)
 	}
@@ -63,6 +140,31 @@ func run(w io.Writer, fname string, verify bool) {
 		log.Fatalf("could not create VM: %v", err)
 	}
 
+	if goProgram {
+		vm.RecoverPanic = true
+		if err := rt.Run(vm, m); err != nil {
+			log.Printf("err=%v", err)
+			return 1
+		}
+		code, _ := rt.ExitCode()
+		return code
+	}
+
+	if emProgram {
+		vm.RecoverPanic = true
+		return runMain(vm, m, env, sys)
+	}
+
+	if e, ok := m.Export.Entries["_start"]; ok && e.Kind == wasm.ExternalFunction {
+		vm.RecoverPanic = true
+		if _, err := vm.ExecCode(int64(e.Index)); err != nil {
+			log.Printf("err=%v", err)
+			return 1
+		}
+		code, _ := sys.ExitCode()
+		return int(code)
+	}
+
 	for name, e := range m.Export.Entries {
 		i := int64(e.Index)
 		fidx := m.Function.Types[int(i)]
@@ -92,6 +194,45 @@ func run(w io.Writer, fname string, verify bool) {
 		}
 		fmt.Fprintf(w, "%[1]v (%[1]T)\n", o)
 	}
+	return 0
+}
+
+// runMain runs the _start or main function of a program compiled by
+// Emscripten, and returns its exit code. The main function is given no
+// arguments.
+func runMain(vm *exec.VM, m *wasm.Module, env *emscripten.Env, sys *wasi.System) int {
+	var e wasm.ExportEntry
+	ok := false
+	for _, name := range []string{"_start", "main", "_main"} {
+		if e, ok = m.Export.Entries[name]; ok && e.Kind == wasm.ExternalFunction {
+			break
+		}
+	}
+	if !ok || e.Kind != wasm.ExternalFunction {
+		log.Printf("module has no main function")
+		return 1
+	}
+
+	// argc and argv are 0 if main takes them.
+	args := make([]uint64, len(m.GetFunction(int(e.Index)).Sig.ParamTypes))
+	out, err := vm.ExecCode(int64(e.Index), args...)
+	if err == nil {
+		err = env.Err()
+	}
+	if err != nil {
+		log.Printf("err=%v", err)
+		return 1
+	}
+	if code, exited := env.ExitCode(); exited {
+		return int(code)
+	}
+	if code, exited := sys.ExitCode(); exited {
+		return int(code)
+	}
+	if code, ok := out.(uint32); ok {
+		return int(int32(code))
+	}
+	return 0
 }
 
 func importer(name string) (*wasm.Module, error) {
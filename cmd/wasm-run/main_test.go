@@ -14,7 +14,9 @@ func TestRun(t *testing.T) {
 	for _, tc := range []struct {
 		name   string
 		verify bool
+		args   []string
 		want   string
+		code   int
 	}{
 		{
 			name: "../../exec/testdata/basic.wasm",
@@ -25,10 +27,24 @@ func TestRun(t *testing.T) {
 			verify: true,
 			want:   "testdata/basic.wasm.txt",
 		},
+		{
+			name: "testdata/hello-wasi.wasm",
+			args: []string{"hello-wasi", "a"},
+			want: "testdata/hello-wasi.wasm.txt",
+			code: 2,
+		},
+		{
+			name: "testdata/hello-emscripten.wasm",
+			want: "testdata/hello-emscripten.wasm.txt",
+			code: 3,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			out := new(bytes.Buffer)
-			run(out, tc.name, tc.verify)
+			code := run(out, tc.name, tc.verify, config{args: tc.args})
+			if code != tc.code {
+				t.Errorf("exit code = %d, want %d", code, tc.code)
+			}
 
 			want, err := ioutil.ReadFile(tc.want)
 			if err != nil {
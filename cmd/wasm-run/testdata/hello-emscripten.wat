@@ -0,0 +1,14 @@
+(module
+  (import "env" "___syscall146" (func $writev (param i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 16) "hello, world\n")
+  (func (export "_main") (param $argc i32) (param $argv i32) (result i32)
+    (i32.store (i32.const 0) (i32.const 16))
+    (i32.store (i32.const 4) (i32.const 13))
+    ;; writev(1, 0, 1)
+    (i32.store (i32.const 32) (i32.const 1))
+    (i32.store (i32.const 36) (i32.const 0))
+    (i32.store (i32.const 40) (i32.const 1))
+    (drop (call $writev (i32.const 146) (i32.const 32)))
+    ;; exit with 3, as main is given no arguments.
+    (i32.add (local.get $argc) (i32.const 3))))
@@ -0,0 +1,96 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// wasm-spectest runs WebAssembly scripts, such as the .wast files of the
+// specification test suite, and reports which of their commands pass.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-interpreter/wagon/internal/spectest"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: wasm-spectest [options] file1.wast|dir1 [file2.wast|dir2 [...]]
+
+ex:
+ $> wasm-spectest -v ./testsuite
+
+options:
+`,
+		)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+}
+
+var (
+	flagVerbose = flag.Bool("v", false, "print the commands which failed")
+	flagSkipped = flag.Bool("s", false, "print the commands which were skipped")
+)
+
+func main() {
+	log.SetPrefix("wasm-spectest: ")
+	log.SetFlags(0)
+
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+	}
+
+	var files []string
+	for _, arg := range flag.Args() {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		names, err := filepath.Glob(filepath.Join(arg, "*.wast"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, names...)
+	}
+
+	if !run(os.Stdout, files) {
+		os.Exit(1)
+	}
+}
+
+// run runs the given scripts, and reports whether all their commands
+// passed or were skipped.
+func run(w io.Writer, files []string) bool {
+	ok := true
+	var passed, failed, skipped int
+	for _, fname := range files {
+		report, err := spectest.RunFile(fname)
+		if err != nil {
+			log.Print(err)
+			ok = false
+			continue
+		}
+		for _, res := range report.Results {
+			if res.Status == spectest.Fail && *flagVerbose || res.Status == spectest.Skip && *flagSkipped {
+				fmt.Fprintf(w, "%s:%d: %s: %v: %s\n", fname, res.Line, res.Command, res.Status, res.Msg)
+			}
+		}
+		fmt.Fprintln(w, report)
+		passed += report.Count(spectest.Pass)
+		failed += report.Count(spectest.Fail)
+		skipped += report.Count(spectest.Skip)
+	}
+	fmt.Fprintf(w, "total: %d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return ok && failed == 0
+}
@@ -19,10 +19,13 @@ func Assemble(instr []Instr) ([]byte, error) {
 	body := new(bytes.Buffer)
 	for _, ins := range instr {
 		body.WriteByte(ins.Op.Code)
+		if ins.Op.IsPrefixed() {
+			leb128.WriteVarUint32(body, ins.Op.Sub)
+		}
 		switch op := ins.Op.Code; op {
-		case ops.Block, ops.Loop, ops.If:
+		case ops.Block, ops.Loop, ops.If, ops.Try:
 			leb128.WriteVarint64(body, int64(ins.Immediates[0].(wasm.BlockType)))
-		case ops.Br, ops.BrIf:
+		case ops.Br, ops.BrIf, ops.Rethrow, ops.Delegate:
 			leb128.WriteVarUint32(body, ins.Immediates[0].(uint32))
 		case ops.BrTable:
 			cnt := ins.Immediates[0].(uint32)
@@ -31,13 +34,21 @@ func Assemble(instr []Instr) ([]byte, error) {
 				leb128.WriteVarUint32(body, ins.Immediates[i+1].(uint32))
 			}
 			leb128.WriteVarUint32(body, ins.Immediates[1+cnt].(uint32))
-		case ops.Call, ops.CallIndirect:
+		case ops.Call, ops.CallIndirect, ops.ReturnCall, ops.ReturnCallIndirect:
 			leb128.WriteVarUint32(body, ins.Immediates[0].(uint32))
-			if op == ops.CallIndirect {
+			if op == ops.CallIndirect || op == ops.ReturnCallIndirect {
 				leb128.WriteVarUint32(body, ins.Immediates[1].(uint32))
 			}
-		case ops.GetLocal, ops.SetLocal, ops.TeeLocal, ops.GetGlobal, ops.SetGlobal:
+		case ops.GetLocal, ops.SetLocal, ops.TeeLocal, ops.GetGlobal, ops.SetGlobal, ops.RefFunc, ops.TableGet, ops.TableSet, ops.Throw, ops.Catch:
 			leb128.WriteVarUint32(body, ins.Immediates[0].(uint32))
+		case ops.RefNull:
+			leb128.WriteVarint64(body, int64(ins.Immediates[0].(wasm.ValueType)))
+		case ops.TypedSelect:
+			cnt := ins.Immediates[0].(uint32)
+			leb128.WriteVarUint32(body, cnt)
+			for i := uint32(0); i < cnt; i++ {
+				leb128.WriteVarint64(body, int64(ins.Immediates[i+1].(wasm.ValueType)))
+			}
 		case ops.I32Const:
 			leb128.WriteVarint64(body, int64(ins.Immediates[0].(int32)))
 		case ops.I64Const:
@@ -53,11 +64,50 @@ func Assemble(instr []Instr) ([]byte, error) {
 			binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
 			body.Write(b[:])
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
-			leb128.WriteVarUint32(body, ins.Immediates[0].(uint32))
-			leb128.WriteVarUint32(body, ins.Immediates[1].(uint32))
+			writeMemoryImmediate(body, ins.Immediates)
 		case ops.CurrentMemory, ops.GrowMemory:
 			leb128.WriteVarUint32(body, uint32(ins.Immediates[0].(uint8)))
+		case ops.SIMDPrefix, ops.AtomicPrefix:
+			imms := ins.Immediates
+			if (ins.Op.Code == ops.SIMDPrefix && IsSIMDMemoryOp(ins.Op.Sub)) ||
+				(ins.Op.Code == ops.AtomicPrefix && ins.Op.Sub != ops.AtomicFence) {
+				writeMemoryImmediate(body, imms)
+				imms = imms[3:]
+			}
+			for _, imm := range imms {
+				switch v := imm.(type) {
+				case uint8:
+					body.WriteByte(v)
+				case uint32:
+					leb128.WriteVarUint32(body, v)
+				case [16]byte:
+					body.Write(v[:])
+				}
+			}
+		case ops.MiscPrefix:
+			for _, imm := range ins.Immediates {
+				switch v := imm.(type) {
+				case uint8:
+					leb128.WriteVarUint32(body, uint32(v))
+				case uint32:
+					leb128.WriteVarUint32(body, v)
+				}
+			}
 		}
 	}
 	return body.Bytes(), nil
 }
+
+// writeMemoryImmediate writes the memory_immediate made of the first three
+// immediates of an operator, as read by readMemoryImmediate. The memory
+// index is only written when it isn't 0.
+func writeMemoryImmediate(body *bytes.Buffer, imms []interface{}) {
+	align, offset, index := imms[0].(uint32), imms[1].(uint64), imms[2].(uint8)
+	if index != 0 {
+		leb128.WriteVarUint32(body, align|MemoryIndexFlag)
+		leb128.WriteVarUint32(body, uint32(index))
+	} else {
+		leb128.WriteVarUint32(body, align)
+	}
+	leb128.WriteVarUint64(body, offset)
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 var testPaths = []string{
@@ -59,3 +60,32 @@ func TestAssemble(t *testing.T) {
 		}
 	}
 }
+
+func TestAssemblePrefixed(t *testing.T) {
+	code := []byte{
+		0x41, 0x00, 0x41, 0x00, 0x41, 0x04, 0xfc, 0x08, 0x01, 0x00, // memory.init 1 0
+		0xfc, 0x09, 0x01, // data.drop 1
+		0x41, 0x00, 0x41, 0x04, 0x41, 0x04, 0xfc, 0x0a, 0x00, 0x00, // memory.copy 0 0
+		0x41, 0x00, 0x41, 0x2a, 0x41, 0x04, 0xfc, 0x0b, 0x00, // memory.fill 0
+		0x41, 0x00, 0x41, 0x00, 0x41, 0x01, 0xfc, 0x0c, 0x02, 0x00, // table.init 2 0
+		0xfc, 0x0d, 0x02, // elem.drop 2
+		0x41, 0x00, 0x41, 0x01, 0x41, 0x01, 0xfc, 0x0e, 0x00, 0x00, // table.copy 0 0
+	}
+	d, err := disasm.Disassemble(code)
+	if err != nil {
+		t.Fatalf("disassemble failed: %v", err)
+	}
+	if op := d[3].Op; op.Code != ops.MiscPrefix || op.Sub != ops.MemoryInit {
+		t.Fatalf("unexpected operator %s", op.Name)
+	}
+	if imm := d[3].Immediates; len(imm) != 2 || imm[0].(uint32) != 1 || imm[1].(uint8) != 0 {
+		t.Fatalf("unexpected memory.init immediates %v", imm)
+	}
+	out, err := disasm.Assemble(d)
+	if err != nil {
+		t.Fatalf("assemble failed: %v", err)
+	}
+	if !bytes.Equal(code, out) {
+		t.Fatalf("code is different: got=%x, want=%x", out, code)
+	}
+}
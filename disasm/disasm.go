@@ -27,6 +27,8 @@ type Instr struct {
 	// Valid value types are:
 	// - (u)(int/float)(32/64)
 	// - wasm.BlockType
+	// - wasm.ValueType
+	// - [16]byte, for v128.const and i8x16.shuffle
 	Immediates  []interface{}
 	NewStack    *StackInfo // non-nil if the instruction creates or unwinds a stack.
 	Block       *BlockInfo // non-nil if the instruction starts or ends a new block.
@@ -62,6 +64,9 @@ type BlockInfo struct {
 	EndIndex int
 	// For end, it is the index to the operator that starts the block.
 	BlockStartIndex int
+	// For 'try', the height of the operand stack when the block starts,
+	// to which it is unwound before running a catch clause.
+	StackHeight int
 }
 
 // Disassembly is the result of disassembling a WebAssembly function.
@@ -76,6 +81,22 @@ func (d *Disassembly) checkMaxDepth(depth int) {
 	}
 }
 
+// isBlockEnd returns whether op ends a block, or a part of it.
+func isBlockEnd(op byte) bool {
+	switch op {
+	case ops.End, ops.Else, ops.Catch, ops.CatchAll, ops.Delegate:
+		return true
+	}
+	return false
+}
+
+// branchCarriesValue returns whether a branch to the block started by
+// instr carries its result. A branch to a loop continues at its start,
+// and carries no value whatever the signature of the loop.
+func branchCarriesValue(instr Instr) bool {
+	return instr.Op.Code != ops.Loop && instr.Block.Signature != wasm.BlockTypeEmpty
+}
+
 func pushPolymorphicOp(indexStack [][]int, index int) {
 	indexStack[len(indexStack)-1] = append(indexStack[len(indexStack)-1], index)
 }
@@ -113,7 +134,7 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 		logger.Printf("stack top is %d", stackDepths.Top())
 		opStr := instr.Op
 		op := opStr.Code
-		if op == ops.End || op == ops.Else {
+		if isBlockEnd(op) {
 			// There are two possible cases here:
 			// 1. The corresponding block/if/loop instruction
 			// *is* reachable, and an instruction somewhere in this
@@ -151,17 +172,50 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 			if !instr.Unreachable {
 				stackDepths.SetTop(stackDepths.Top() - 1)
 			}
-		case ops.Select:
+		case ops.Select, ops.TypedSelect, ops.TableSet:
 			if !instr.Unreachable {
 				stackDepths.SetTop(stackDepths.Top() - 2)
 			}
+		case ops.RefNull:
+			if !instr.Unreachable {
+				top := stackDepths.Top() + 1
+				stackDepths.SetTop(top)
+				disas.checkMaxDepth(int(top))
+			}
+		case ops.RefIsNull, ops.TableGet:
+			// the operand is replaced by the result
+		case ops.MiscPrefix:
+			if !instr.Unreachable {
+				switch opStr.Sub {
+				case ops.TableGrow:
+					stackDepths.SetTop(stackDepths.Top() - 1)
+				case ops.TableFill:
+					stackDepths.SetTop(stackDepths.Top() - 3)
+				}
+			}
 		case ops.Return:
 			if !instr.Unreachable {
 				stackDepths.SetTop(stackDepths.Top() - uint64(len(fn.Sig.ReturnTypes)))
 			}
 			pushPolymorphicOp(blockPolymorphicOps, curIndex)
 			lastOpReturn = true
-		case ops.End, ops.Else:
+		case ops.Throw:
+			if !instr.Unreachable {
+				sig := module.GetTagType(int(instr.Immediates[0].(uint32)))
+				if sig == nil {
+					return nil, wasm.InvalidTagIndexError(instr.Immediates[0].(uint32))
+				}
+				stackDepths.SetTop(stackDepths.Top() - uint64(len(sig.ParamTypes)))
+			}
+			pushPolymorphicOp(blockPolymorphicOps, curIndex)
+		case ops.Rethrow:
+			pushPolymorphicOp(blockPolymorphicOps, curIndex)
+		case ops.End, ops.Else, ops.Catch, ops.CatchAll, ops.Delegate:
+			// catch and catch_all end the previous part of a try block
+			// and start a new one, as else does, whereas delegate ends
+			// a try block, as end does.
+			ends := op == ops.End || op == ops.Delegate
+
 			// The max depth reached while execing the current block
 			curDepth := stackDepths.Top()
 			blockStartIndex := blockIndices.Pop()
@@ -170,10 +224,11 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 				Start:     false,
 				Signature: blockSig,
 			}
-			if op == ops.End {
+			switch op {
+			case ops.End, ops.Delegate:
 				instr.Block.BlockStartIndex = int(blockStartIndex)
 				disas.Code[blockStartIndex].Block.EndIndex = curIndex
-			} else { // ops.Else
+			case ops.Else:
 				instr.Block.ElseIfIndex = int(blockStartIndex)
 				disas.Code[blockStartIndex].Block.IfElseIndex = int(blockStartIndex)
 			}
@@ -187,7 +242,7 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 			prevDepthIndex := stackDepths.Len() - 2
 			prevDepth := stackDepths.Get(prevDepthIndex)
 
-			if op != ops.Else && blockSig != wasm.BlockTypeEmpty && !instr.Unreachable {
+			if ends && blockSig != wasm.BlockTypeEmpty && !instr.Unreachable {
 				stackDepths.Set(prevDepthIndex, prevDepth+1)
 				disas.checkMaxDepth(int(stackDepths.Get(prevDepthIndex)))
 			}
@@ -213,15 +268,27 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 			}
 
 			stackDepths.Pop()
-			if op == ops.Else {
-				stackDepths.Push(0)
+			if !ends {
+				// the next part of the block starts with the stack of
+				// the parent block, and the values of the exception
+				// for catch.
+				depth := stackDepths.Top()
+				if op == ops.Catch {
+					sig := module.GetTagType(int(instr.Immediates[0].(uint32)))
+					if sig == nil {
+						return nil, wasm.InvalidTagIndexError(instr.Immediates[0].(uint32))
+					}
+					depth += uint64(len(sig.ParamTypes))
+					disas.checkMaxDepth(int(depth))
+				}
+				stackDepths.Push(depth)
 				blockIndices.Push(uint64(curIndex))
 				if !instr.Unreachable {
 					blockPolymorphicOps = append(blockPolymorphicOps, []int{})
 				}
 			}
 
-		case ops.Block, ops.Loop, ops.If:
+		case ops.Block, ops.Loop, ops.If, ops.Try:
 			sig := uint32(instr.Immediates[0].(wasm.BlockType))
 			logger.Printf("if, depth is %d", stackDepths.Top())
 			stackDepths.Push(stackDepths.Top())
@@ -236,8 +303,9 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 				blockPolymorphicOps = append(blockPolymorphicOps, []int{})
 			}
 			instr.Block = &BlockInfo{
-				Start:     true,
-				Signature: wasm.BlockType(sig),
+				Start:       true,
+				Signature:   wasm.BlockType(sig),
+				StackHeight: int(stackDepths.Top()),
 			}
 
 			blockIndices.Push(uint64(curIndex))
@@ -263,7 +331,7 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 				index := blockIndices.Get(blockIndices.Len() - 1 - int(depth))
 				instr.NewStack = &StackInfo{
 					StackTopDiff: int64(elemsDiscard),
-					PreserveTop:  disas.Code[index].Block.Signature != wasm.BlockTypeEmpty,
+					PreserveTop:  branchCarriesValue(disas.Code[index]),
 				}
 			}
 			if op == ops.Br {
@@ -292,7 +360,7 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 					}
 					index := blockIndices.Get(blockIndices.Len() - 1 - int(entry))
 					info.StackTopDiff = int64(elemsDiscard)
-					info.PreserveTop = disas.Code[index].Block.Signature != wasm.BlockTypeEmpty
+					info.PreserveTop = branchCarriesValue(disas.Code[index])
 				}
 				instr.Branches = append(instr.Branches, info)
 			}
@@ -312,7 +380,7 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 				}
 				index := blockIndices.Get(blockIndices.Len() - 1 - int(defaultTarget))
 				info.StackTopDiff = int64(elemsDiscard)
-				info.PreserveTop = disas.Code[index].Block.Signature != wasm.BlockTypeEmpty
+				info.PreserveTop = branchCarriesValue(disas.Code[index])
 			}
 			instr.Branches = append(instr.Branches, info)
 			pushPolymorphicOp(blockPolymorphicOps, curIndex)
@@ -335,6 +403,27 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 				stackDepths.SetTop(uint64(top))
 				disas.checkMaxDepth(top)
 			}
+		case ops.ReturnCall, ops.ReturnCallIndirect:
+			// the arguments are consumed, and the function returns the
+			// results of the callee, as with return.
+			index := instr.Immediates[0].(uint32)
+			if !instr.Unreachable {
+				var sig *wasm.FunctionSig
+				top := int(stackDepths.Top())
+				if op == ops.ReturnCallIndirect {
+					if module.Types == nil {
+						return nil, errors.New("missing types section")
+					}
+					sig = &module.Types.Entries[index]
+					top--
+				} else {
+					sig = module.GetFunction(int(index)).Sig
+				}
+				top -= len(sig.ParamTypes)
+				stackDepths.SetTop(uint64(top))
+			}
+			pushPolymorphicOp(blockPolymorphicOps, curIndex)
+			lastOpReturn = true
 		case ops.GetLocal, ops.SetLocal, ops.TeeLocal, ops.GetGlobal, ops.SetGlobal:
 			if !instr.Unreachable {
 				top := stackDepths.Top()
@@ -352,7 +441,7 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 			}
 		}
 
-		if op != ops.Return {
+		if op != ops.Return && op != ops.ReturnCall && op != ops.ReturnCallIndirect {
 			lastOpReturn = false
 		}
 
@@ -381,22 +470,34 @@ func Disassemble(code []byte) ([]Instr, error) {
 			return nil, err
 		}
 
-		opStr, err := ops.New(op)
-		if err != nil {
-			return nil, err
+		var opStr ops.Op
+		if ops.IsPrefix(op) {
+			sub, err := leb128.ReadVarUint32(reader)
+			if err != nil {
+				return nil, err
+			}
+			opStr, err = ops.NewPrefixed(op, sub)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			opStr, err = ops.New(op)
+			if err != nil {
+				return nil, err
+			}
 		}
 		instr := Instr{
 			Op: opStr,
 		}
 
 		switch op {
-		case ops.Block, ops.Loop, ops.If:
+		case ops.Block, ops.Loop, ops.If, ops.Try:
 			sig, err := leb128.ReadVarint32(reader)
 			if err != nil {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, wasm.BlockType(sig))
-		case ops.Br, ops.BrIf:
+		case ops.Br, ops.BrIf, ops.Rethrow, ops.Delegate:
 			depth, err := leb128.ReadVarUint32(reader)
 			if err != nil {
 				return nil, err
@@ -421,25 +522,44 @@ func Disassemble(code []byte) ([]Instr, error) {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, defaultTarget)
-		case ops.Call, ops.CallIndirect:
+		case ops.Call, ops.CallIndirect, ops.ReturnCall, ops.ReturnCallIndirect:
 			index, err := leb128.ReadVarUint32(reader)
 			if err != nil {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, index)
-			if op == ops.CallIndirect {
+			if op == ops.CallIndirect || op == ops.ReturnCallIndirect {
 				reserved, err := leb128.ReadVarUint32(reader)
 				if err != nil {
 					return nil, err
 				}
 				instr.Immediates = append(instr.Immediates, reserved)
 			}
-		case ops.GetLocal, ops.SetLocal, ops.TeeLocal, ops.GetGlobal, ops.SetGlobal:
+		case ops.GetLocal, ops.SetLocal, ops.TeeLocal, ops.GetGlobal, ops.SetGlobal, ops.RefFunc, ops.TableGet, ops.TableSet, ops.Throw, ops.Catch:
 			index, err := leb128.ReadVarUint32(reader)
 			if err != nil {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, index)
+		case ops.RefNull:
+			t, err := leb128.ReadVarint32(reader)
+			if err != nil {
+				return nil, err
+			}
+			instr.Immediates = append(instr.Immediates, wasm.ValueType(t))
+		case ops.TypedSelect:
+			count, err := leb128.ReadVarUint32(reader)
+			if err != nil {
+				return nil, err
+			}
+			instr.Immediates = append(instr.Immediates, count)
+			for i := uint32(0); i < count; i++ {
+				t, err := leb128.ReadVarint32(reader)
+				if err != nil {
+					return nil, err
+				}
+				instr.Immediates = append(instr.Immediates, wasm.ValueType(t))
+			}
 		case ops.I32Const:
 			i, err := leb128.ReadVarint32(reader)
 			if err != nil {
@@ -467,26 +587,185 @@ func Disassemble(code []byte) ([]Instr, error) {
 			i := binary.LittleEndian.Uint64(b[:])
 			instr.Immediates = append(instr.Immediates, math.Float64frombits(i))
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
-			// read memory_immediate
-			flags, err := leb128.ReadVarUint32(reader)
+			imms, err := readMemoryImmediate(reader)
 			if err != nil {
 				return nil, err
 			}
-			instr.Immediates = append(instr.Immediates, flags)
-
-			offset, err := leb128.ReadVarUint32(reader)
+			instr.Immediates = append(instr.Immediates, imms...)
+		case ops.CurrentMemory, ops.GrowMemory:
+			index, err := readMemoryIndex(reader)
 			if err != nil {
 				return nil, err
 			}
-			instr.Immediates = append(instr.Immediates, offset)
-		case ops.CurrentMemory, ops.GrowMemory:
-			res, err := leb128.ReadVarUint32(reader)
+			instr.Immediates = append(instr.Immediates, index)
+		case ops.MiscPrefix:
+			imms, err := readMiscImmediates(reader, opStr.Sub)
 			if err != nil {
 				return nil, err
 			}
-			instr.Immediates = append(instr.Immediates, uint8(res))
+			instr.Immediates = append(instr.Immediates, imms...)
+		case ops.SIMDPrefix:
+			imms, err := readSIMDImmediates(reader, opStr.Sub)
+			if err != nil {
+				return nil, err
+			}
+			instr.Immediates = append(instr.Immediates, imms...)
+		case ops.AtomicPrefix:
+			if opStr.Sub == ops.AtomicFence {
+				b, err := reader.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				instr.Immediates = append(instr.Immediates, b)
+				break
+			}
+			imms, err := readMemoryImmediate(reader)
+			if err != nil {
+				return nil, err
+			}
+			instr.Immediates = append(instr.Immediates, imms...)
 		}
 		out = append(out, instr)
 	}
 	return out, nil
 }
+
+// MemoryIndexFlag is the bit of the alignment field of memory_immediate
+// which is set when the memory index of the access follows the alignment,
+// as introduced by the multi-memory proposal.
+const MemoryIndexFlag = 0x40
+
+// readMemoryImmediate reads the memory_immediate of an operator accessing
+// the linear memory, returned as three immediates: the alignment, as an
+// uint32 value, the offset, as an uint64 value, and the memory index, as an
+// uint8 value. The offset is encoded as a varuint64 by the memory64
+// proposal, while the offsets of 32-bit memories are limited to 2^32-1 by
+// the validation.
+func readMemoryImmediate(reader io.Reader) ([]interface{}, error) {
+	align, err := leb128.ReadVarUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	var index uint8
+	if align&MemoryIndexFlag != 0 {
+		align &^= MemoryIndexFlag
+		if index, err = readMemoryIndex(reader); err != nil {
+			return nil, err
+		}
+	}
+	offset, err := leb128.ReadVarUint64(reader)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{align, offset, index}, nil
+}
+
+// readMemoryIndex reads the index of a linear memory. Memory indices are
+// uint8 values, which limits the number of memories a module can access
+// to 256.
+func readMemoryIndex(reader io.Reader) (uint8, error) {
+	index, err := leb128.ReadVarUint32(reader)
+	if err != nil {
+		return 0, err
+	}
+	if index > math.MaxUint8 {
+		return 0, wasm.InvalidLinearMemoryIndexError(index)
+	}
+	return uint8(index), nil
+}
+
+// readMiscImmediates reads the immediates of the operator prefixed by
+// ops.MiscPrefix with the sub-opcode sub.
+// Segment and table indices are read as uint32 values, while memory
+// indices are read as uint8 values, like the ones of memory.size and
+// memory.grow.
+func readMiscImmediates(reader io.Reader, sub uint32) ([]interface{}, error) {
+	var kinds []bool // for each immediate, whether it is a memory index
+	switch sub {
+	case ops.MemoryInit:
+		kinds = []bool{false, true}
+	case ops.DataDrop, ops.ElemDrop:
+		kinds = []bool{false}
+	case ops.MemoryCopy:
+		kinds = []bool{true, true}
+	case ops.MemoryFill:
+		kinds = []bool{true}
+	case ops.TableInit, ops.TableCopy:
+		kinds = []bool{false, false}
+	case ops.TableGrow, ops.TableSize, ops.TableFill:
+		kinds = []bool{false}
+	}
+
+	imms := make([]interface{}, len(kinds))
+	for i, memory := range kinds {
+		var err error
+		if memory {
+			imms[i], err = readMemoryIndex(reader)
+		} else {
+			imms[i], err = leb128.ReadVarUint32(reader)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return imms, nil
+}
+
+// readSIMDImmediates reads the immediates of the operator prefixed by
+// ops.SIMDPrefix with the sub-opcode sub.
+// memory_immediate is read as by readMemoryImmediate, lane indices as
+// uint8 values, and the 16 bytes following v128.const and i8x16.shuffle
+// as a [16]byte value.
+func readSIMDImmediates(reader io.ByteReader, sub uint32) ([]interface{}, error) {
+	var imms []interface{}
+	if IsSIMDMemoryOp(sub) {
+		var err error
+		if imms, err = readMemoryImmediate(reader.(io.Reader)); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case sub == ops.V128Const || sub == ops.I8x16Shuffle:
+		var b [16]byte
+		for i := range b {
+			v, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			b[i] = v
+		}
+		imms = append(imms, b)
+	case sub >= ops.I8x16ExtractLaneS && sub <= ops.F64x2ReplaceLane,
+		sub >= ops.V128Load8Lane && sub <= ops.V128Store64Lane:
+		lane, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		imms = append(imms, lane)
+	}
+	return imms, nil
+}
+
+// IsSIMDMemoryOp returns whether the operator prefixed by ops.SIMDPrefix
+// with the sub-opcode sub accesses the linear memory, in which case its
+// first immediates are the alignment, offset and memory index of a
+// memory_immediate.
+func IsSIMDMemoryOp(sub uint32) bool {
+	return sub <= ops.V128Store || (sub >= ops.V128Load8Lane && sub <= ops.V128Load64Zero)
+}
+
+// AtomicAlignment returns the natural alignment, in log 2, of the memory
+// access of the operator prefixed by ops.AtomicPrefix with the sub-opcode
+// sub, which is the only valid alignment of atomic operators.
+func AtomicAlignment(sub uint32) uint32 {
+	switch sub {
+	case ops.MemoryAtomicNotify, ops.MemoryAtomicWait32:
+		return 2
+	case ops.MemoryAtomicWait64:
+		return 3
+	}
+	// the load, store and read-modify-write operators are grouped by
+	// seven, one per type and access size.
+	return [...]uint32{2, 3, 0, 1, 0, 1, 2}[(sub-ops.I32AtomicLoad)%7]
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func FuzzDisassemble(f *testing.F) {
+	// the seeds are the function bodies of the test modules.
+	for _, dir := range testPaths {
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, fname := range fnames {
+			raw, err := ioutil.ReadFile(fname)
+			if err != nil {
+				f.Fatal(err)
+			}
+			m, err := wasm.DecodeModule(bytes.NewReader(raw))
+			if err != nil {
+				f.Fatal(err)
+			}
+			if m.Code == nil {
+				continue
+			}
+			for _, body := range m.Code.Bodies {
+				f.Add(body.Code)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, code []byte) {
+		instrs, err := disasm.Disassemble(code)
+		if err != nil {
+			return
+		}
+		// the code assembled from the instructions, which may differ
+		// from the original one by the encoding of its immediates, is
+		// assembled back to itself.
+		code, err = disasm.Assemble(instrs)
+		if err != nil {
+			t.Fatalf("error assembling the disassembled code: %v", err)
+		}
+		instrs, err = disasm.Disassemble(code)
+		if err != nil {
+			t.Fatalf("error disassembling the assembled code: %v", err)
+		}
+		again, err := disasm.Assemble(instrs)
+		if err != nil {
+			t.Fatalf("error assembling the disassembled code: %v", err)
+		}
+		if !bytes.Equal(code, again) {
+			t.Fatalf("code %x assembled to %x", code, again)
+		}
+	})
+}
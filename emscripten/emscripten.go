@@ -0,0 +1,310 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package emscripten implements the common functions of the "env" module
+// imported by the C and C++ programs compiled with Emscripten, so that
+// they can be run without a JavaScript runtime.
+//
+// The functions are the ones of the standalone outputs of Emscripten,
+// which manage the memory, abort and exit the program, and give the time,
+// along with the system calls of the standard streams. The functions of
+// older versions of Emscripten, whose names start with an underscore and
+// whose system calls are numbered as in Linux (such as ___syscall146 for
+// writev), are provided as well.
+//
+// Recent versions of Emscripten import the functions of the standard
+// streams from wasi_snapshot_preview1 instead, which the wasi package
+// provides.
+//
+//	env := emscripten.New(emscripten.Stdout(os.Stdout))
+//	m, err := wasm.ReadModule(r, func(name string) (*wasm.Module, error) {
+//		if name == emscripten.ModuleName {
+//			return env.Module(), nil
+//		}
+//		return nil, fmt.Errorf("unknown module %q", name)
+//	})
+package emscripten
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/internal/hostio"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ModuleName is the name of the module imported by Emscripten programs.
+const ModuleName = "env"
+
+// Option configures an Env created by New.
+type Option func(e *Env)
+
+// stdio wraps an option of the standard streams or of the clock.
+func stdio(opt hostio.Option) Option {
+	return func(e *Env) {
+		opt(&e.stdio)
+	}
+}
+
+// Stdin sets the standard input of the program, which is empty by default.
+func Stdin(r io.Reader) Option {
+	return stdio(hostio.Stdin(r))
+}
+
+// Stdout sets the standard output of the program, which is discarded by
+// default.
+func Stdout(w io.Writer) Option {
+	return stdio(hostio.Stdout(w))
+}
+
+// Stderr sets the standard error of the program, which is discarded by
+// default. The messages of failed assertions are written to it.
+func Stderr(w io.Writer) Option {
+	return stdio(hostio.Stderr(w))
+}
+
+// Clock sets the function returning the current time, which is time.Now
+// by default.
+func Clock(now func() time.Time) Option {
+	return stdio(hostio.Clock(now))
+}
+
+// MaxHeap sets the size, in bytes, the memory can grow to with
+// emscripten_resize_heap. It is 2GiB by default.
+func MaxHeap(n uint32) Option {
+	return func(e *Env) {
+		e.maxHeap = n
+	}
+}
+
+// ErrAbort is the error returned by Err after the program aborted.
+var ErrAbort = errors.New("emscripten: program aborted")
+
+// AssertionError is the error returned by Err after an assertion of the
+// program failed.
+type AssertionError struct {
+	Condition string
+	File      string
+	Line      int32
+	Func      string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("emscripten: assertion failed: %s, at: %s,%d,%s", e.Condition, e.File, e.Line, e.Func)
+}
+
+// Env holds the state of an instance of the env module: the standard
+// streams of the program, and how it ended.
+type Env struct {
+	stdio   hostio.Stdio
+	start   time.Time
+	maxHeap uint32
+
+	tempRet0 int32
+
+	exited bool
+	code   int32
+	err    error
+}
+
+// New returns an Env configured by the options opts.
+func New(opts ...Option) *Env {
+	e := &Env{
+		stdio:   hostio.DefaultStdio(),
+		maxHeap: 1 << 31,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.start = e.stdio.Now()
+	return e
+}
+
+// ExitCode returns the exit code given by the program to exit, and whether
+// it has called it.
+func (e *Env) ExitCode() (code int32, exited bool) {
+	return e.code, e.exited
+}
+
+// Err returns ErrAbort or an *AssertionError if the program aborted, and
+// nil otherwise.
+func (e *Env) Err() error {
+	return e.err
+}
+
+// Module returns the host module of e, to be returned by the importer
+// given to wasm.ReadModule for ModuleName.
+func (e *Env) Module() *wasm.Module {
+	type function struct {
+		name string
+		fn   interface{}
+	}
+	fns := []function{
+		{"abort", e.abort},
+		{"_abort", e.abort},
+		{"_abort_js", e.abort},
+		{"__assert_fail", e.assertFail},
+		{"___assert_fail", e.assertFail},
+		{"exit", e.exit},
+		{"_exit", e.exit},
+		{"emscripten_memcpy_big", e.memcpy},
+		{"emscripten_memcpy_js", e.memcpy},
+		{"_emscripten_memcpy_big", e.memcpyLegacy},
+		{"emscripten_resize_heap", e.resizeHeap},
+		{"emscripten_get_heap_max", e.heapMax},
+		{"emscripten_get_heap_size", e.heapSize},
+		{"emscripten_notify_memory_growth", func(proc *exec.Process, index int32) {}},
+		{"setTempRet0", e.setTempRet0},
+		{"getTempRet0", e.getTempRet0},
+		{"emscripten_get_now", e.getNow},
+		{"_emscripten_get_now", e.getNow},
+		{"emscripten_date_now", e.dateNow},
+		{"__syscall_ioctl", e.syscallIoctl},
+		{"__syscall_fcntl64", e.syscallFcntl64},
+		{"__syscall_openat", e.syscallOpenat},
+		{"__syscall_getcwd", e.syscallGetcwd},
+	}
+	for _, n := range legacySyscalls {
+		for _, prefix := range []string{"__syscall", "___syscall"} {
+			fns = append(fns, function{fmt.Sprintf("%s%d", prefix, n), e.legacySyscall})
+		}
+	}
+
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Export.Entries = make(map[string]wasm.ExportEntry, len(fns))
+	m.Types.Entries = make([]wasm.FunctionSig, len(fns))
+	for i, fn := range fns {
+		v := reflect.ValueOf(fn.fn)
+		m.Types.Entries[i] = signature(v.Type())
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+			Sig:  &m.Types.Entries[i],
+			Host: v,
+			Body: &wasm.FunctionBody{},
+		})
+		m.Export.Entries[fn.name] = wasm.ExportEntry{FieldStr: fn.name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+	return m
+}
+
+// signature returns the signature of a host function of type t, whose
+// first parameter is the *exec.Process.
+func signature(t reflect.Type) wasm.FunctionSig {
+	valueType := func(t reflect.Type) wasm.ValueType {
+		switch t.Kind() {
+		case reflect.Int32, reflect.Uint32:
+			return wasm.ValueTypeI32
+		case reflect.Int64, reflect.Uint64:
+			return wasm.ValueTypeI64
+		case reflect.Float64:
+			return wasm.ValueTypeF64
+		}
+		panic(fmt.Sprintf("emscripten: unsupported type %v", t))
+	}
+	sig := wasm.FunctionSig{Form: -0x20}
+	for i := 1; i < t.NumIn(); i++ {
+		sig.ParamTypes = append(sig.ParamTypes, valueType(t.In(i)))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		sig.ReturnTypes = append(sig.ReturnTypes, valueType(t.Out(i)))
+	}
+	return sig
+}
+
+// stop terminates the program, which aborted if err isn't nil.
+func (e *Env) stop(proc *exec.Process, err error) {
+	if e.err == nil {
+		e.err = err
+	}
+	proc.Terminate()
+}
+
+func (e *Env) abort(proc *exec.Process) {
+	e.stop(proc, ErrAbort)
+}
+
+func (e *Env) assertFail(proc *exec.Process, cond, file uint32, line int32, fn uint32) {
+	m := hostio.Memory{Proc: proc}
+	err := &AssertionError{
+		Condition: m.CString(cond),
+		File:      m.CString(file),
+		Line:      line,
+		Func:      m.CString(fn),
+	}
+	fmt.Fprintf(e.stdio.Stderr, "Assertion failed: %s, at: %s,%d,%s\n", err.Condition, err.File, err.Line, err.Func)
+	e.stop(proc, err)
+}
+
+func (e *Env) exit(proc *exec.Process, code int32) {
+	e.exited = true
+	e.code = code
+	e.stop(proc, nil)
+}
+
+// memcpy copies n bytes from src to dest, which may overlap.
+func (e *Env) memcpy(proc *exec.Process, dest, src, n uint32) {
+	m := hostio.Memory{Proc: proc}
+	p := m.Read(src, n)
+	m.Write(dest, p)
+	if m.Fault {
+		e.stop(proc, ErrAbort)
+	}
+}
+
+func (e *Env) memcpyLegacy(proc *exec.Process, dest, src, n uint32) uint32 {
+	e.memcpy(proc, dest, src, n)
+	return dest
+}
+
+const pageSize = 65536
+
+// resizeHeap grows the memory to at least size bytes, and returns 1 if it
+// could.
+func (e *Env) resizeHeap(proc *exec.Process, size uint32) uint32 {
+	cur := uint64(proc.MemSize())
+	if uint64(size) <= cur {
+		return 1
+	}
+	if size > e.maxHeap {
+		return 0
+	}
+	n := (uint64(size) - cur + pageSize - 1) / pageSize
+	if proc.GrowMemory(uint32(n)) < 0 {
+		return 0
+	}
+	return 1
+}
+
+func (e *Env) heapMax(proc *exec.Process) uint32 {
+	return e.maxHeap
+}
+
+func (e *Env) heapSize(proc *exec.Process) uint32 {
+	return uint32(proc.MemSize())
+}
+
+// setTempRet0 and getTempRet0 hold the high 32 bits of the i64 values
+// returned by the functions legalized for JavaScript.
+func (e *Env) setTempRet0(proc *exec.Process, v int32) {
+	e.tempRet0 = v
+}
+
+func (e *Env) getTempRet0(proc *exec.Process) int32 {
+	return e.tempRet0
+}
+
+// getNow returns the monotonic time in milliseconds.
+func (e *Env) getNow(proc *exec.Process) float64 {
+	return float64(e.stdio.Now().Sub(e.start)) / float64(time.Millisecond)
+}
+
+// dateNow returns the real time in milliseconds since the epoch.
+func (e *Env) dateNow(proc *exec.Process) float64 {
+	return math.Floor(float64(e.stdio.Now().UnixNano()) / float64(time.Millisecond))
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package emscripten_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-interpreter/wagon/emscripten"
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// run runs the function main of the module src, in the text format, with
+// the env module of env, and returns the VM and the result of main.
+func run(t *testing.T, env *emscripten.Env, src string) (*exec.VM, interface{}) {
+	t.Helper()
+	m, err := wast.ParseModule(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.ReadModule(buf, func(name string) (*wasm.Module, error) {
+		if name == emscripten.ModuleName {
+			return env.Module(), nil
+		}
+		return nil, fmt.Errorf("unknown module %q", name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+	out, err := vm.ExecCode(int64(m.Export.Entries["main"].Index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm, out
+}
+
+func TestHello(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	env := emscripten.New(emscripten.Stdout(stdout))
+	_, out := run(t, env, `
+(module
+  (import "env" "___syscall146" (func $writev (param i32 i32) (result i32)))
+  (memory 1)
+  (data (i32.const 16) "hello, ")
+  (data (i32.const 32) "world\n")
+  (func (export "main") (result i32)
+    (i32.store (i32.const 0) (i32.const 16))
+    (i32.store (i32.const 4) (i32.const 7))
+    (i32.store (i32.const 8) (i32.const 32))
+    (i32.store (i32.const 12) (i32.const 6))
+    ;; The arguments of writev: fd, iov and iovcnt.
+    (i32.store (i32.const 48) (i32.const 1))
+    (i32.store (i32.const 52) (i32.const 0))
+    (i32.store (i32.const 56) (i32.const 2))
+    (call $writev (i32.const 146) (i32.const 48))))
+`)
+	if got, want := stdout.String(), "hello, world\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if out != uint32(13) {
+		t.Errorf("writev = %v, want 13", out)
+	}
+}
+
+func TestSyscalls(t *testing.T) {
+	stdin := strings.NewReader("input")
+	env := emscripten.New(emscripten.Stdin(stdin))
+	vm, _ := run(t, env, `
+(module
+  (import "env" "__syscall3" (func $legacy (param i32 i32) (result i32)))
+  (import "env" "__syscall_ioctl" (func $ioctl (param i32 i32 i32) (result i32)))
+  (import "env" "__syscall_fcntl64" (func $fcntl (param i32 i32 i32) (result i32)))
+  (import "env" "__syscall_openat" (func $openat (param i32 i32 i32 i32) (result i32)))
+  (import "env" "__syscall_getcwd" (func $getcwd (param i32 i32) (result i32)))
+  (memory 1)
+  (func (export "main")
+    ;; read(0, 64, 3)
+    (i32.store (i32.const 0) (i32.const 0))
+    (i32.store (i32.const 4) (i32.const 64))
+    (i32.store (i32.const 8) (i32.const 3))
+    (i32.store (i32.const 128) (call $legacy (i32.const 3) (i32.const 0)))
+    ;; write(5, 64, 3)
+    (i32.store (i32.const 0) (i32.const 5))
+    (i32.store (i32.const 132) (call $legacy (i32.const 4) (i32.const 0)))
+    (i32.store (i32.const 136) (call $ioctl (i32.const 1) (i32.const 0x5401) (i32.const 0)))
+    (i32.store (i32.const 140) (call $ioctl (i32.const 3) (i32.const 0x5401) (i32.const 0)))
+    (i32.store (i32.const 144) (call $fcntl (i32.const 1) (i32.const 3) (i32.const 0)))
+    (i32.store (i32.const 148) (call $openat (i32.const -100) (i32.const 64) (i32.const 0) (i32.const 0)))
+    (i32.store (i32.const 152) (call $getcwd (i32.const 72) (i32.const 16)))
+    (i32.store (i32.const 156) (call $getcwd (i32.const 72) (i32.const 1)))))
+`)
+	mem := vm.Memory()
+	if got, want := string(mem[64:67]), "inp"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	if got, want := string(mem[72:74]), "/\x00"; got != want {
+		t.Errorf("getcwd wrote %q, want %q", got, want)
+	}
+	for i, want := range []int32{3, -9, 0, -8, 1, -44, 2, -68} {
+		off := 128 + 4*i
+		got := int32(uint32(mem[off]) | uint32(mem[off+1])<<8 | uint32(mem[off+2])<<16 | uint32(mem[off+3])<<24)
+		if got != want {
+			t.Errorf("result %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	env := emscripten.New(emscripten.MaxHeap(4 * 65536))
+	vm, _ := run(t, env, `
+(module
+  (import "env" "emscripten_memcpy_big" (func $memcpy (param i32 i32 i32)))
+  (import "env" "emscripten_resize_heap" (func $resize (param i32) (result i32)))
+  (import "env" "emscripten_get_heap_size" (func $size (result i32)))
+  (import "env" "emscripten_get_heap_max" (func $max (result i32)))
+  (memory 1)
+  (data (i32.const 0) "abcdef")
+  (func (export "main")
+    (call $memcpy (i32.const 2) (i32.const 0) (i32.const 4))
+    (i32.store (i32.const 16) (call $resize (i32.const 100000)))
+    (i32.store (i32.const 20) (call $size))
+    (i32.store (i32.const 24) (call $resize (i32.const 1000000)))
+    (i32.store (i32.const 28) (call $max))
+    (i32.store (i32.const 32) (memory.size))))
+`)
+	mem := vm.Memory()
+	if got, want := string(mem[:6]), "ababcd"; got != want {
+		t.Errorf("memory = %q, want %q", got, want)
+	}
+	want := []byte{
+		1, 0, 0, 0,
+		0, 0, 2, 0,
+		0, 0, 0, 0,
+		0, 0, 4, 0,
+		2, 0, 0, 0,
+	}
+	if got := mem[16:36]; !bytes.Equal(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+}
+
+func TestExit(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		src    string
+		code   int32
+		exited bool
+		err    error
+		stderr string
+	}{
+		{
+			name: "exit",
+			src: `
+(module
+  (import "env" "exit" (func $exit (param i32)))
+  (func (export "main") (result i32)
+    (call $exit (i32.const 3))
+    (i32.const 1)))
+`,
+			code:   3,
+			exited: true,
+		},
+		{
+			name: "abort",
+			src: `
+(module
+  (import "env" "abort" (func $abort))
+  (func (export "main")
+    (call $abort)
+    unreachable))
+`,
+			err: emscripten.ErrAbort,
+		},
+		{
+			name: "assert",
+			src: `
+(module
+  (import "env" "__assert_fail" (func $assert_fail (param i32 i32 i32 i32)))
+  (memory 1)
+  (data (i32.const 0) "x > 0\00main.c\00f\00")
+  (func (export "main")
+    (call $assert_fail (i32.const 0) (i32.const 6) (i32.const 12) (i32.const 13))
+    unreachable))
+`,
+			err:    &emscripten.AssertionError{Condition: "x > 0", File: "main.c", Line: 12, Func: "f"},
+			stderr: "Assertion failed: x > 0, at: main.c,12,f\n",
+		},
+		{
+			name: "fault",
+			src: `
+(module
+  (import "env" "emscripten_memcpy_big" (func $memcpy (param i32 i32 i32)))
+  (memory 1)
+  (func (export "main")
+    (call $memcpy (i32.const 0) (i32.const 65530) (i32.const 16))
+    unreachable))
+`,
+			err: emscripten.ErrAbort,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stderr := new(bytes.Buffer)
+			env := emscripten.New(emscripten.Stderr(stderr))
+			run(t, env, tc.src)
+			if code, exited := env.ExitCode(); code != tc.code || exited != tc.exited {
+				t.Errorf("exit code = %d, %v, want %d, %v", code, exited, tc.code, tc.exited)
+			}
+			if err := env.Err(); !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("err = %v, want %v", err, tc.err)
+			}
+			if got := stderr.String(); got != tc.stderr {
+				t.Errorf("stderr = %q, want %q", got, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestTimeTempRet(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	env := emscripten.New(emscripten.Clock(func() time.Time {
+		now = now.Add(1500 * time.Microsecond)
+		return now
+	}))
+	vm, _ := run(t, env, `
+(module
+  (import "env" "emscripten_get_now" (func $now (result f64)))
+  (import "env" "emscripten_date_now" (func $date (result f64)))
+  (import "env" "setTempRet0" (func $set (param i32)))
+  (import "env" "getTempRet0" (func $get (result i32)))
+  (memory 1)
+  (func (export "main")
+    (f64.store (i32.const 0) (call $now))
+    (f64.store (i32.const 8) (call $date))
+    (call $set (i32.const 7))
+    (i32.store (i32.const 16) (call $get))))
+`)
+	mem := vm.Memory()
+	f64 := func(off int) float64 {
+		var v uint64
+		for i := 7; i >= 0; i-- {
+			v = v<<8 | uint64(mem[off+i])
+		}
+		return math.Float64frombits(v)
+	}
+	if got, want := f64(0), 1.5; got != want {
+		t.Errorf("emscripten_get_now = %v, want %v", got, want)
+	}
+	if got, want := f64(8), 1500000000004.0; got != want {
+		t.Errorf("emscripten_date_now = %v, want %v", got, want)
+	}
+	if got := mem[16]; got != 7 {
+		t.Errorf("getTempRet0 = %d, want 7", got)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package emscripten
+
+import (
+	"io"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/internal/hostio"
+)
+
+// errnos holds the error numbers returned by system calls, which are
+// negated. Recent versions of Emscripten use the numbers of WASI, older
+// ones used the numbers of Linux.
+type errnos struct {
+	badf, inval, noent, notty, spipe, rang int32
+}
+
+var (
+	errnosWASI  = errnos{badf: 8, inval: 28, noent: 44, notty: 59, spipe: 70, rang: 68}
+	errnosLinux = errnos{badf: 9, inval: 22, noent: 2, notty: 25, spipe: 29, rang: 34}
+)
+
+// Requests of ioctl and commands of fcntl.
+const (
+	tcgets     = 0x5401
+	tiocgwinsz = 0x5413
+
+	fGetfd = 1
+	fSetfd = 2
+	fGetfl = 3
+	fSetfl = 4
+)
+
+// isStdio reports whether fd is one of the standard streams, the only
+// files of the program.
+func isStdio(fd int32) bool {
+	return fd >= 0 && fd <= 2
+}
+
+// ioctl makes the standard streams look like terminals, so that the
+// standard output is line buffered.
+func (e *Env) ioctl(errs errnos, fd, op int32) int32 {
+	switch {
+	case !isStdio(fd):
+		return -errs.badf
+	case op == tcgets || op == tiocgwinsz:
+		return 0
+	}
+	return -errs.inval
+}
+
+func (e *Env) fcntl(errs errnos, fd, cmd int32) int32 {
+	switch {
+	case !isStdio(fd):
+		return -errs.badf
+	case cmd == fGetfl && fd == 0:
+		return 0 // O_RDONLY
+	case cmd == fGetfl:
+		return 1 // O_WRONLY
+	case cmd == fGetfd || cmd == fSetfd || cmd == fSetfl:
+		return 0
+	}
+	return -errs.inval
+}
+
+// getcwd writes the working directory, which is always the root.
+func (e *Env) getcwd(errs errnos, m *hostio.Memory, buf, size uint32) int32 {
+	if size < 2 {
+		return -errs.rang
+	}
+	m.Write(buf, []byte("/\x00"))
+	return 2
+}
+
+// read reads into the buffers iovs from the standard input.
+func (e *Env) read(errs errnos, m *hostio.Memory, fd int32, iovs []hostio.Iovec) int32 {
+	if fd != 0 {
+		return -errs.badf
+	}
+	var total int32
+	for _, iov := range iovs {
+		p := make([]byte, iov.Len)
+		n, err := e.stdio.Stdin.Read(p)
+		m.Write(iov.Ptr, p[:n])
+		total += int32(n)
+		if err != nil || n < len(p) {
+			break
+		}
+	}
+	return total
+}
+
+// write writes the buffers iovs to the standard output or error.
+func (e *Env) write(errs errnos, m *hostio.Memory, fd int32, iovs []hostio.Iovec) int32 {
+	var w io.Writer
+	switch fd {
+	case 1:
+		w = e.stdio.Stdout
+	case 2:
+		w = e.stdio.Stderr
+	default:
+		return -errs.badf
+	}
+	var total int32
+	for _, iov := range iovs {
+		n, _ := w.Write(m.Read(iov.Ptr, iov.Len))
+		total += int32(n)
+	}
+	return total
+}
+
+func (e *Env) syscallIoctl(proc *exec.Process, fd, op, varargs int32) int32 {
+	return e.ioctl(errnosWASI, fd, op)
+}
+
+func (e *Env) syscallFcntl64(proc *exec.Process, fd, cmd, varargs int32) int32 {
+	return e.fcntl(errnosWASI, fd, cmd)
+}
+
+// syscallOpenat fails, as the program has no file system.
+func (e *Env) syscallOpenat(proc *exec.Process, dirfd, path, flags, varargs int32) int32 {
+	return -errnosWASI.noent
+}
+
+func (e *Env) syscallGetcwd(proc *exec.Process, buf, size uint32) int32 {
+	m := hostio.Memory{Proc: proc}
+	n := e.getcwd(errnosWASI, &m, buf, size)
+	if m.Fault {
+		e.stop(proc, ErrAbort)
+	}
+	return n
+}
+
+// Numbers of the system calls of older versions of Emscripten.
+const (
+	sysRead    = 3
+	sysWrite   = 4
+	sysOpen    = 5
+	sysClose   = 6
+	sysGetpid  = 20
+	sysIoctl   = 54
+	sysLlseek  = 140
+	sysReadv   = 145
+	sysWritev  = 146
+	sysGetcwd  = 183
+	sysFcntl64 = 221
+)
+
+var legacySyscalls = []int{
+	sysRead, sysWrite, sysOpen, sysClose, sysGetpid, sysIoctl, sysLlseek,
+	sysReadv, sysWritev, sysGetcwd, sysFcntl64,
+}
+
+// legacySyscall implements the system calls of older versions of
+// Emscripten, whose arguments are the i32 values at varargs.
+func (e *Env) legacySyscall(proc *exec.Process, which int32, varargs uint32) int32 {
+	m := hostio.Memory{Proc: proc}
+	arg := func() uint32 {
+		v := m.Uint32(varargs)
+		varargs += 4
+		return v
+	}
+	errs := errnosLinux
+
+	var ret int32
+	switch which {
+	case sysRead, sysWrite:
+		fd, buf, n := int32(arg()), arg(), arg()
+		if which == sysRead {
+			ret = e.read(errs, &m, fd, []hostio.Iovec{{Ptr: buf, Len: n}})
+		} else {
+			ret = e.write(errs, &m, fd, []hostio.Iovec{{Ptr: buf, Len: n}})
+		}
+	case sysReadv, sysWritev:
+		fd, iov, n := int32(arg()), arg(), arg()
+		iovs := m.Iovecs(iov, n)
+		if which == sysReadv {
+			ret = e.read(errs, &m, fd, iovs)
+		} else {
+			ret = e.write(errs, &m, fd, iovs)
+		}
+	case sysOpen:
+		ret = -errs.noent
+	case sysClose:
+		if !isStdio(int32(arg())) {
+			ret = -errs.badf
+		}
+	case sysGetpid:
+		ret = 42
+	case sysIoctl:
+		fd, op := int32(arg()), int32(arg())
+		ret = e.ioctl(errs, fd, op)
+	case sysLlseek:
+		ret = -errs.spipe
+		if !isStdio(int32(arg())) {
+			ret = -errs.badf
+		}
+	case sysGetcwd:
+		buf, size := arg(), arg()
+		ret = e.getcwd(errs, &m, buf, size)
+	case sysFcntl64:
+		fd, cmd := int32(arg()), int32(arg())
+		ret = e.fcntl(errs, fd, cmd)
+	}
+	if m.Fault {
+		e.stop(proc, ErrAbort)
+	}
+	return ret
+}
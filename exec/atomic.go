@@ -0,0 +1,247 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// ErrUnalignedAtomic is the error value used while trapping the VM when an
+// atomic operator accesses an address that isn't a multiple of the size of
+// the access.
+var ErrUnalignedAtomic = errors.New("exec: unaligned atomic memory access")
+
+// ErrWaitUnsharedMemory is the error value used while trapping the VM when
+// memory.atomic.wait is used on a linear memory that isn't shared.
+var ErrWaitUnsharedMemory = errors.New("exec: wait on unshared memory")
+
+// loadN returns the little endian integer made of the first size bytes of b.
+func loadN(b []byte, size int) uint64 {
+	switch size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(endianess.Uint16(b))
+	case 4:
+		return uint64(endianess.Uint32(b))
+	}
+	return endianess.Uint64(b)
+}
+
+// storeN stores the size low bytes of v in b, in little endian order.
+func storeN(b []byte, size int, v uint64) {
+	switch size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		endianess.PutUint16(b, uint16(v))
+	case 4:
+		endianess.PutUint32(b, uint32(v))
+	default:
+		endianess.PutUint64(b, v)
+	}
+}
+
+// truncate returns the size low bytes of v.
+func truncate(v uint64, size int) uint64 {
+	if size == 8 {
+		return v
+	}
+	return v & (1<<(8*uint(size)) - 1)
+}
+
+// atomicOp executes an operator prefixed by ops.AtomicPrefix, using the
+// sub-opcode that follows the prefix.
+func (vm *VM) atomicOp() {
+	vm.atomicFuncTable[vm.fetchUint32()]()
+}
+
+// atomicAddr returns the memory and effective address of an atomic
+// operator accessing size bytes, trapping the VM if the address isn't
+// aligned.
+func (vm *VM) atomicAddr(size int) (*linearMemory, uint64) {
+	mem, addr := vm.effectiveAddr(size)
+	if addr%uint64(size) != 0 {
+		panic(ErrUnalignedAtomic)
+	}
+	return mem, addr
+}
+
+// atomicMem returns the memory, and the size bytes of this memory, which
+// are accessed by an atomic operator.
+func (vm *VM) atomicMem(size int) (*linearMemory, []byte) {
+	mem, addr := vm.atomicAddr(size)
+	return mem, mem.bytes[addr : addr+uint64(size)]
+}
+
+func (vm *VM) atomicLoad(size int) func() {
+	return func() {
+		mem, b := vm.atomicMem(size)
+		var v uint64
+		mem.atomically(func() {
+			v = loadN(b, size)
+		})
+		vm.pushUint64(v)
+	}
+}
+
+func (vm *VM) atomicStore(size int) func() {
+	return func() {
+		v := vm.popUint64()
+		mem, b := vm.atomicMem(size)
+		mem.atomically(func() {
+			storeN(b, size, v)
+		})
+	}
+}
+
+// atomicRMW returns the implementation of a read-modify-write operator,
+// which stores f(old, v) and returns the old value.
+func (vm *VM) atomicRMW(size int, f func(old, v uint64) uint64) func() {
+	return func() {
+		v := vm.popUint64()
+		mem, b := vm.atomicMem(size)
+		var old uint64
+		mem.atomically(func() {
+			old = loadN(b, size)
+			storeN(b, size, f(old, v))
+		})
+		vm.pushUint64(old)
+	}
+}
+
+func (vm *VM) atomicCmpxchg(size int) func() {
+	return func() {
+		replacement := vm.popUint64()
+		expected := truncate(vm.popUint64(), size)
+		mem, b := vm.atomicMem(size)
+		var old uint64
+		mem.atomically(func() {
+			old = loadN(b, size)
+			if old == expected {
+				storeN(b, size, replacement)
+			}
+		})
+		vm.pushUint64(old)
+	}
+}
+
+func (vm *VM) atomicWait(size int) func() {
+	return func() {
+		timeout := vm.popInt64()
+		expected := truncate(vm.popUint64(), size)
+		mem, addr := vm.atomicAddr(size)
+		if mem.shared == nil {
+			panic(ErrWaitUnsharedMemory)
+		}
+		vm.pushInt32(mem.shared.wait(uint32(addr), size, expected, timeout))
+	}
+}
+
+func (vm *VM) atomicNotify() {
+	count := vm.popUint32()
+	mem, addr := vm.atomicAddr(4)
+	if mem.shared == nil {
+		// there can't be any waiter on an unshared memory
+		vm.pushInt32(0)
+		return
+	}
+	vm.pushInt32(mem.shared.notify(uint32(addr), count))
+}
+
+func (vm *VM) atomicFence() {
+	for _, mem := range vm.memories {
+		mem.atomically(func() {})
+	}
+}
+
+func (vm *VM) newAtomicFuncTable() {
+	t := &vm.atomicFuncTable
+
+	t[ops.MemoryAtomicNotify] = vm.atomicNotify
+	t[ops.MemoryAtomicWait32] = vm.atomicWait(4)
+	t[ops.MemoryAtomicWait64] = vm.atomicWait(8)
+	t[ops.AtomicFence] = vm.atomicFence
+
+	t[ops.I32AtomicLoad] = vm.atomicLoad(4)
+	t[ops.I64AtomicLoad] = vm.atomicLoad(8)
+	t[ops.I32AtomicLoad8U] = vm.atomicLoad(1)
+	t[ops.I32AtomicLoad16U] = vm.atomicLoad(2)
+	t[ops.I64AtomicLoad8U] = vm.atomicLoad(1)
+	t[ops.I64AtomicLoad16U] = vm.atomicLoad(2)
+	t[ops.I64AtomicLoad32U] = vm.atomicLoad(4)
+	t[ops.I32AtomicStore] = vm.atomicStore(4)
+	t[ops.I64AtomicStore] = vm.atomicStore(8)
+	t[ops.I32AtomicStore8] = vm.atomicStore(1)
+	t[ops.I32AtomicStore16] = vm.atomicStore(2)
+	t[ops.I64AtomicStore8] = vm.atomicStore(1)
+	t[ops.I64AtomicStore16] = vm.atomicStore(2)
+	t[ops.I64AtomicStore32] = vm.atomicStore(4)
+
+	add := func(old, v uint64) uint64 { return old + v }
+	sub := func(old, v uint64) uint64 { return old - v }
+	and := func(old, v uint64) uint64 { return old & v }
+	or := func(old, v uint64) uint64 { return old | v }
+	xor := func(old, v uint64) uint64 { return old ^ v }
+	xchg := func(old, v uint64) uint64 { return v }
+
+	t[ops.I32AtomicRmwAdd] = vm.atomicRMW(4, add)
+	t[ops.I64AtomicRmwAdd] = vm.atomicRMW(8, add)
+	t[ops.I32AtomicRmw8AddU] = vm.atomicRMW(1, add)
+	t[ops.I32AtomicRmw16AddU] = vm.atomicRMW(2, add)
+	t[ops.I64AtomicRmw8AddU] = vm.atomicRMW(1, add)
+	t[ops.I64AtomicRmw16AddU] = vm.atomicRMW(2, add)
+	t[ops.I64AtomicRmw32AddU] = vm.atomicRMW(4, add)
+
+	t[ops.I32AtomicRmwSub] = vm.atomicRMW(4, sub)
+	t[ops.I64AtomicRmwSub] = vm.atomicRMW(8, sub)
+	t[ops.I32AtomicRmw8SubU] = vm.atomicRMW(1, sub)
+	t[ops.I32AtomicRmw16SubU] = vm.atomicRMW(2, sub)
+	t[ops.I64AtomicRmw8SubU] = vm.atomicRMW(1, sub)
+	t[ops.I64AtomicRmw16SubU] = vm.atomicRMW(2, sub)
+	t[ops.I64AtomicRmw32SubU] = vm.atomicRMW(4, sub)
+
+	t[ops.I32AtomicRmwAnd] = vm.atomicRMW(4, and)
+	t[ops.I64AtomicRmwAnd] = vm.atomicRMW(8, and)
+	t[ops.I32AtomicRmw8AndU] = vm.atomicRMW(1, and)
+	t[ops.I32AtomicRmw16AndU] = vm.atomicRMW(2, and)
+	t[ops.I64AtomicRmw8AndU] = vm.atomicRMW(1, and)
+	t[ops.I64AtomicRmw16AndU] = vm.atomicRMW(2, and)
+	t[ops.I64AtomicRmw32AndU] = vm.atomicRMW(4, and)
+
+	t[ops.I32AtomicRmwOr] = vm.atomicRMW(4, or)
+	t[ops.I64AtomicRmwOr] = vm.atomicRMW(8, or)
+	t[ops.I32AtomicRmw8OrU] = vm.atomicRMW(1, or)
+	t[ops.I32AtomicRmw16OrU] = vm.atomicRMW(2, or)
+	t[ops.I64AtomicRmw8OrU] = vm.atomicRMW(1, or)
+	t[ops.I64AtomicRmw16OrU] = vm.atomicRMW(2, or)
+	t[ops.I64AtomicRmw32OrU] = vm.atomicRMW(4, or)
+
+	t[ops.I32AtomicRmwXor] = vm.atomicRMW(4, xor)
+	t[ops.I64AtomicRmwXor] = vm.atomicRMW(8, xor)
+	t[ops.I32AtomicRmw8XorU] = vm.atomicRMW(1, xor)
+	t[ops.I32AtomicRmw16XorU] = vm.atomicRMW(2, xor)
+	t[ops.I64AtomicRmw8XorU] = vm.atomicRMW(1, xor)
+	t[ops.I64AtomicRmw16XorU] = vm.atomicRMW(2, xor)
+	t[ops.I64AtomicRmw32XorU] = vm.atomicRMW(4, xor)
+
+	t[ops.I32AtomicRmwXchg] = vm.atomicRMW(4, xchg)
+	t[ops.I64AtomicRmwXchg] = vm.atomicRMW(8, xchg)
+	t[ops.I32AtomicRmw8XchgU] = vm.atomicRMW(1, xchg)
+	t[ops.I32AtomicRmw16XchgU] = vm.atomicRMW(2, xchg)
+	t[ops.I64AtomicRmw8XchgU] = vm.atomicRMW(1, xchg)
+	t[ops.I64AtomicRmw16XchgU] = vm.atomicRMW(2, xchg)
+	t[ops.I64AtomicRmw32XchgU] = vm.atomicRMW(4, xchg)
+
+	t[ops.I32AtomicRmwCmpxchg] = vm.atomicCmpxchg(4)
+	t[ops.I64AtomicRmwCmpxchg] = vm.atomicCmpxchg(8)
+	t[ops.I32AtomicRmw8CmpxchgU] = vm.atomicCmpxchg(1)
+	t[ops.I32AtomicRmw16CmpxchgU] = vm.atomicCmpxchg(2)
+	t[ops.I64AtomicRmw8CmpxchgU] = vm.atomicCmpxchg(1)
+	t[ops.I64AtomicRmw16CmpxchgU] = vm.atomicCmpxchg(2)
+	t[ops.I64AtomicRmw32CmpxchgU] = vm.atomicCmpxchg(4)
+}
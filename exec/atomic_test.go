@@ -0,0 +1,120 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// newThreads creates n VMs for testdata/threads.wasm, all using the shared
+// memory created by the first one.
+func newThreads(t *testing.T, n int) (*wasm.Module, []*VM) {
+	f, err := os.Open("testdata/threads.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := wasm.ReadModule(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vms := make([]*VM, n)
+	for i := range vms {
+		var opts []VMOption
+		if i > 0 {
+			opts = append(opts, WithSharedMemory(vms[0].SharedMemory()))
+		}
+		vms[i], err = NewVM(m, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vms[0].SharedMemory() == nil {
+		t.Fatal("the memory of the VM is not shared")
+	}
+	return m, vms
+}
+
+func TestSharedMemoryAtomics(t *testing.T) {
+	const (
+		threads = 8
+		incs    = 1000
+	)
+	m, vms := newThreads(t, threads)
+	inc := int64(m.Export.Entries["inc"].Index)
+
+	var wg sync.WaitGroup
+	errs := make([]error, threads)
+	for i, vm := range vms {
+		wg.Add(1)
+		go func(i int, vm *VM) {
+			defer wg.Done()
+			_, errs[i] = vm.ExecCode(inc, incs)
+		}(i, vm)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("thread %d: %v", i, err)
+		}
+	}
+
+	rtrn, err := vms[0].ExecCode(int64(m.Export.Entries["counter"].Index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != uint32(threads*incs) {
+		t.Errorf("counter is %v, want %d", rtrn, threads*incs)
+	}
+}
+
+func TestSharedMemoryWaitNotify(t *testing.T) {
+	m, vms := newThreads(t, 2)
+
+	done := make(chan interface{})
+	go func() {
+		rtrn, err := vms[0].ExecCode(int64(m.Export.Entries["wait"].Index))
+		if err != nil {
+			rtrn = err
+		}
+		done <- rtrn
+	}()
+
+	// the waiter returns 0 if it was woken, or 1 if notify stored the
+	// value it waits on before it started waiting.
+	notify := int64(m.Export.Entries["notify"].Index)
+	timeout := time.After(10 * time.Second)
+	for {
+		if _, err := vms[1].ExecCode(notify); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case rtrn := <-done:
+			if rtrn != uint32(0) && rtrn != uint32(1) {
+				t.Fatalf("wait returned %v, want 0 or 1", rtrn)
+			}
+			return
+		case <-timeout:
+			t.Fatal("the waiting VM was not woken")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestSharedMemoryGrow(t *testing.T) {
+	m, vms := newThreads(t, 2)
+	if _, err := vms[0].ExecCode(int64(m.Export.Entries["grow"].Index)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(vms[1].Memory()); n != 2*wasmPageSize {
+		t.Errorf("the memory of the other VM is %d bytes long, want %d", n, 2*wasmPageSize)
+	}
+}
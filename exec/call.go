@@ -15,8 +15,38 @@ var (
 	// an invalid index to the module's table space is used as an operand to
 	// call_indirect
 	ErrUndefinedElementIndex = errors.New("exec: undefined element index")
+	// ErrCallStackExhausted is the error value used while trapping the VM
+	// when a call exceeds the limit set by MaxCallDepth.
+	ErrCallStackExhausted = errors.New("exec: call stack exhausted")
+	// ErrStackExhausted is the error value used while trapping the VM
+	// when a call exceeds the limit set by MaxStackSize.
+	ErrStackExhausted = errors.New("exec: stack exhausted")
 )
 
+// enter accounts for the frame of a called WebAssembly function, of size
+// values, which must be released with leave when the function returns.
+func (vm *VM) enter(size int) {
+	if max := vm.cfg.maxCallDepth; max != 0 && vm.depth >= max {
+		panic(ErrCallStackExhausted)
+	}
+	vm.resizeFrame(0, size)
+	vm.depth++
+}
+
+func (vm *VM) leave(size int) {
+	vm.depth--
+	vm.stackSize -= size
+}
+
+// resizeFrame changes the size of the current frame from prev to size
+// values, as done by tail calls.
+func (vm *VM) resizeFrame(prev, size int) {
+	if max := vm.cfg.maxStackSize; max != 0 && vm.stackSize-prev+size > max {
+		panic(ErrStackExhausted)
+	}
+	vm.stackSize += size - prev
+}
+
 func (vm *VM) call() {
 	index := vm.fetchUint32()
 
@@ -24,14 +54,24 @@ func (vm *VM) call() {
 }
 
 func (vm *VM) callIndirect() {
+	index := vm.indirectCallee()
+
+	vm.funcs[index].call(vm, index)
+}
+
+// indirectCallee reads the immediates of call_indirect or
+// return_call_indirect, pops the index into the table, and returns the
+// index of the function it refers to in the function index space, after
+// checking that its signature is the expected one.
+func (vm *VM) indirectCallee() int64 {
 	index := vm.fetchUint32()
 	fnExpect := vm.module.Types.Entries[index]
-	_ = vm.fetchUint32() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#call-operators-described-here)
+	table := vm.tables[vm.fetchUint32()]
 	tableIndex := vm.popUint32()
-	if int(tableIndex) >= len(vm.module.TableIndexSpace[0]) {
+	if int(tableIndex) >= len(table) || table[tableIndex] == 0 {
 		panic(ErrUndefinedElementIndex)
 	}
-	elemIndex := vm.module.TableIndexSpace[0][tableIndex]
+	elemIndex := table[tableIndex] - 1
 	fnActual := vm.module.FunctionIndexSpace[elemIndex]
 
 	if len(fnExpect.ParamTypes) != len(fnActual.Sig.ParamTypes) {
@@ -53,5 +93,44 @@ func (vm *VM) callIndirect() {
 		}
 	}
 
-	vm.funcs[elemIndex].call(vm, int64(elemIndex))
+	return int64(elemIndex)
+}
+
+// tailCall implements return_call and return_call_indirect: the execution
+// context of the current function is replaced by the one of the function
+// at index, so that the call stack doesn't grow. It returns false if the
+// callee is a host function, which has then been called normally, and
+// whose results are to be returned by the current function.
+func (vm *VM) tailCall(index int64) (compiledFunction, bool) {
+	if _, ok := vm.funcs[index].(goFunction); ok {
+		vm.funcs[index].call(vm, index)
+		return compiledFunction{}, false
+	}
+	compiled, err := vm.compile(index)
+	if err != nil {
+		panic(err)
+	}
+	size := compiled.frameSize()
+	vm.resizeFrame(vm.ctx.size, size)
+
+	locals := make([]value, compiled.totalLocalVars)
+	for i := compiled.args - 1; i >= 0; i-- {
+		locals[i] = vm.popValue()
+	}
+	// the operand stack of the current function can be reused, as
+	// nothing remains on it that the callee could return.
+	stack := vm.ctx.stack[:0]
+	if cap(stack) < compiled.maxDepth {
+		stack = make([]value, 0, compiled.maxDepth)
+	}
+
+	vm.ctx = context{
+		stack:   stack,
+		locals:  locals,
+		code:    compiled.code,
+		pc:      0,
+		curFunc: index,
+		size:    size,
+	}
+	return compiled, true
 }
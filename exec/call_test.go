@@ -237,3 +237,47 @@ func TestHostTerminate(t *testing.T) {
 		t.Fatalf("Terminate did not abort execution: abort=%v, pc=%#x", vm.abort, vm.ctx.pc)
 	}
 }
+
+func TestHostTerminateNested(t *testing.T) {
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Types = &wasm.SectionTypes{
+		Entries: []wasm.FunctionSig{
+			// (func [] -> [i32])
+			{Form: 0, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			// (func [] -> [])
+			{Form: 0},
+		},
+	}
+	m.Function = &wasm.SectionFunctions{
+		Types: []uint32{0, 0},
+	}
+
+	// call 1
+	// i32.const 1
+	outer := wasm.FunctionBody{Module: m, Code: []byte{0x10, 0x01, 0x41, 0x01}}
+	// call 2
+	// i32.const 2
+	inner := wasm.FunctionBody{Module: m, Code: []byte{0x10, 0x02, 0x41, 0x02}}
+	m.FunctionIndexSpace = []wasm.Function{
+		{Sig: &m.Types.Entries[0], Body: &outer},
+		{Sig: &m.Types.Entries[0], Body: &inner},
+		{Sig: &m.Types.Entries[1], Host: reflect.ValueOf(func(proc *Process) { proc.Terminate() })},
+	}
+	m.Code = &wasm.SectionCode{
+		Bodies: []wasm.FunctionBody{outer, inner},
+	}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("Error creating VM: %v", err)
+	}
+	// the functions returning values stop with empty stacks.
+	rtrn, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != nil {
+		t.Errorf("terminated function returned %v, want nil", rtrn)
+	}
+}
@@ -5,27 +5,53 @@
 package exec
 
 import (
+	"errors"
 	"math"
 )
 
+var (
+	// ErrInvalidConversion is the error value used while trapping the VM
+	// when a trunc operator converts NaN to an integer.
+	ErrInvalidConversion = errors.New("exec: invalid conversion to integer")
+	// ErrIntegerOverflow is the error value used while trapping the VM
+	// when a trunc operator converts a float out of the range of the
+	// integer type, or when a div_s operator divides the smallest integer
+	// by -1.
+	ErrIntegerOverflow = errors.New("exec: integer overflow")
+)
+
+// trunc truncates f towards zero, and traps the VM unless the result is in
+// the range [min, max) of an integer type, in which the conversion of
+// the result to this type is exact.
+func trunc(f, min, max float64) float64 {
+	if f != f {
+		panic(ErrInvalidConversion)
+	}
+	t := math.Trunc(f)
+	if t < min || t >= max {
+		panic(ErrIntegerOverflow)
+	}
+	return t
+}
+
 func (vm *VM) i32Wrapi64() {
 	vm.pushUint32(uint32(vm.popUint64()))
 }
 
 func (vm *VM) i32TruncSF32() {
-	vm.pushInt32(int32(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushInt32(int32(trunc(float64(vm.popFloat32()), math.MinInt32, 1<<31)))
 }
 
 func (vm *VM) i32TruncUF32() {
-	vm.pushUint32(uint32(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushUint32(uint32(trunc(float64(vm.popFloat32()), 0, 1<<32)))
 }
 
 func (vm *VM) i32TruncSF64() {
-	vm.pushInt32(int32(math.Trunc(vm.popFloat64())))
+	vm.pushInt32(int32(trunc(vm.popFloat64(), math.MinInt32, 1<<31)))
 }
 
 func (vm *VM) i32TruncUF64() {
-	vm.pushUint32(uint32(math.Trunc(vm.popFloat64())))
+	vm.pushUint32(uint32(trunc(vm.popFloat64(), 0, 1<<32)))
 }
 
 func (vm *VM) i64ExtendSI32() {
@@ -37,19 +63,19 @@ func (vm *VM) i64ExtendUI32() {
 }
 
 func (vm *VM) i64TruncSF32() {
-	vm.pushInt64(int64(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushInt64(int64(trunc(float64(vm.popFloat32()), math.MinInt64, 1<<63)))
 }
 
 func (vm *VM) i64TruncUF32() {
-	vm.pushUint64(uint64(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushUint64(uint64(trunc(float64(vm.popFloat32()), 0, 1<<64)))
 }
 
 func (vm *VM) i64TruncSF64() {
-	vm.pushInt64(int64(math.Trunc(vm.popFloat64())))
+	vm.pushInt64(int64(trunc(vm.popFloat64(), math.MinInt64, 1<<63)))
 }
 
 func (vm *VM) i64TruncUF64() {
-	vm.pushUint64(uint64(math.Trunc(vm.popFloat64())))
+	vm.pushUint64(uint64(trunc(vm.popFloat64(), 0, 1<<64)))
 }
 
 func (vm *VM) f32ConvertSI32() {
@@ -69,7 +95,7 @@ func (vm *VM) f32ConvertUI64() {
 }
 
 func (vm *VM) f32DemoteF64() {
-	vm.pushFloat32(float32(vm.popFloat64()))
+	vm.pushFloat32Result(float32(vm.popFloat64()))
 }
 
 func (vm *VM) f64ConvertSI32() {
@@ -89,5 +115,63 @@ func (vm *VM) f64ConvertUI64() {
 }
 
 func (vm *VM) f64PromoteF32() {
-	vm.pushFloat64(float64(vm.popFloat32()))
+	vm.pushFloat64Result(float64(vm.popFloat32()))
+}
+
+// truncSatS truncates f towards zero, saturating the result to the
+// [min, max] range. NaN is converted to 0.
+func truncSatS(f float64, min, max int64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= float64(min):
+		return min
+	case f >= float64(max):
+		return max
+	}
+	return int64(f)
+}
+
+// truncSatU truncates f towards zero, saturating the result to the
+// [0, max] range. NaN is converted to 0.
+func truncSatU(f float64, max uint64) uint64 {
+	switch {
+	case math.IsNaN(f), f <= 0:
+		return 0
+	case f >= float64(max):
+		return max
+	}
+	return uint64(f)
+}
+
+func (vm *VM) i32TruncSatSF32() {
+	vm.pushInt32(int32(truncSatS(float64(vm.popFloat32()), math.MinInt32, math.MaxInt32)))
+}
+
+func (vm *VM) i32TruncSatUF32() {
+	vm.pushUint32(uint32(truncSatU(float64(vm.popFloat32()), math.MaxUint32)))
+}
+
+func (vm *VM) i32TruncSatSF64() {
+	vm.pushInt32(int32(truncSatS(vm.popFloat64(), math.MinInt32, math.MaxInt32)))
+}
+
+func (vm *VM) i32TruncSatUF64() {
+	vm.pushUint32(uint32(truncSatU(vm.popFloat64(), math.MaxUint32)))
+}
+
+func (vm *VM) i64TruncSatSF32() {
+	vm.pushInt64(truncSatS(float64(vm.popFloat32()), math.MinInt64, math.MaxInt64))
+}
+
+func (vm *VM) i64TruncSatUF32() {
+	vm.pushUint64(truncSatU(float64(vm.popFloat32()), math.MaxUint64))
+}
+
+func (vm *VM) i64TruncSatSF64() {
+	vm.pushInt64(truncSatS(vm.popFloat64(), math.MinInt64, math.MaxInt64))
+}
+
+func (vm *VM) i64TruncSatUF64() {
+	vm.pushUint64(truncSatU(vm.popFloat64(), math.MaxUint64))
 }
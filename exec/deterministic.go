@@ -0,0 +1,151 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+var (
+	// ErrNondeterministicSharedMemory is returned by NewVM in deterministic
+	// mode when the module uses a shared memory, or one is given with the
+	// WithSharedMemory option, as other threads can change it at any time.
+	ErrNondeterministicSharedMemory = errors.New("exec: shared memories are not deterministic")
+
+	// ErrNondeterministicWait is returned by NewVM in deterministic mode when
+	// the module uses memory.atomic.wait32, memory.atomic.wait64 or
+	// memory.atomic.notify, whose results depend on timing.
+	ErrNondeterministicWait = errors.New("exec: wait and notify are not deterministic")
+)
+
+// NondeterministicHostError is returned by NewVM in deterministic mode when
+// the module imports a host function not allowed by DeterministicHost.
+type NondeterministicHostError struct {
+	Module, Field string
+}
+
+func (e NondeterministicHostError) Error() string {
+	return fmt.Sprintf("exec: host function %s.%s is not deterministic", e.Module, e.Field)
+}
+
+// The canonical NaNs of the float types, with only the most significant
+// bit of the payload set.
+const (
+	canonicalNaN32 = 0x7fc00000
+	canonicalNaN64 = 0x7ff8000000000000
+)
+
+// pushFloat32Result and pushFloat64Result push the result of an
+// arithmetic float operator. In deterministic mode, a NaN result is
+// replaced by the canonical NaN, as its payload may depend on the
+// platform.
+func (vm *VM) pushFloat32Result(f float32) {
+	if f != f && vm.cfg.deterministic {
+		vm.pushUint32(canonicalNaN32)
+		return
+	}
+	vm.pushFloat32(f)
+}
+
+func (vm *VM) pushFloat64Result(f float64) {
+	if f != f && vm.cfg.deterministic {
+		vm.pushUint64(canonicalNaN64)
+		return
+	}
+	vm.pushFloat64(f)
+}
+
+// setF32LaneResult and setF64LaneResult set the lane i of v to the result
+// of an arithmetic float operator on vectors, with the NaN results
+// canonicalized in deterministic mode like pushFloat32Result does.
+func (vm *VM) setF32LaneResult(v *v128, i int, f float32) {
+	if f != f && vm.cfg.deterministic {
+		i32x4.set(v, i, canonicalNaN32)
+		return
+	}
+	setF32Lane(v, i, f)
+}
+
+func (vm *VM) setF64LaneResult(v *v128, i int, f float64) {
+	if f != f && vm.cfg.deterministic {
+		i64x2.set(v, i, canonicalNaN64)
+		return
+	}
+	setF64Lane(v, i, f)
+}
+
+// checkSharedMemories returns ErrNondeterministicSharedMemory if module
+// declares or imports a shared memory, or if the VM is given one.
+func (vm *VM) checkSharedMemories(module *wasm.Module) error {
+	if vm.cfg.sharedMemory != nil {
+		return ErrNondeterministicSharedMemory
+	}
+	for i := 0; ; i++ {
+		t := module.GetMemory(i)
+		if t == nil {
+			return nil
+		}
+		if t.Limits.Shared() {
+			return ErrNondeterministicSharedMemory
+		}
+	}
+}
+
+// checkWaitNotify returns ErrNondeterministicWait if a function of module
+// uses the wait or notify operators.
+func checkWaitNotify(module *wasm.Module) error {
+	for _, fn := range module.FunctionIndexSpace {
+		if fn.IsHost() || fn.Body == nil {
+			continue
+		}
+		instrs, err := disasm.Disassemble(fn.Body.Code)
+		if err != nil {
+			return err
+		}
+		for _, instr := range instrs {
+			if instr.Op.Code != ops.AtomicPrefix {
+				continue
+			}
+			switch instr.Op.Sub {
+			case ops.MemoryAtomicNotify, ops.MemoryAtomicWait32, ops.MemoryAtomicWait64:
+				return ErrNondeterministicWait
+			}
+		}
+	}
+	return nil
+}
+
+// checkHostFunctions returns a NondeterministicHostError if module uses a
+// host function not allowed by the DeterministicHost option.
+func (vm *VM) checkHostFunctions(module *wasm.Module) error {
+	// the imported functions come first in the function index space.
+	var imports []wasm.ImportEntry
+	if module.Import != nil {
+		for _, entry := range module.Import.Entries {
+			if entry.Type.Kind() == wasm.ExternalFunction {
+				imports = append(imports, entry)
+			}
+		}
+	}
+	for i, fn := range module.FunctionIndexSpace {
+		if !fn.IsHost() {
+			continue
+		}
+		var entry wasm.ImportEntry
+		if i < len(imports) {
+			entry = imports[i]
+		}
+		allow := vm.cfg.deterministicHost
+		if allow == nil || !allow(entry.ModuleName, entry.FieldName) {
+			return NondeterministicHostError{entry.ModuleName, entry.FieldName}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,603 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// readWat reads the module src, in the text format, whose imports from
+// "env" are the host functions fns.
+func readWat(t *testing.T, src string, fns map[string]interface{}) *wasm.Module {
+	t.Helper()
+	m, err := wast.ParseModule(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.ReadModule(buf, func(name string) (*wasm.Module, error) {
+		if name != "env" {
+			return nil, fmt.Errorf("unknown module %q", name)
+		}
+		env := wasm.NewModule()
+		env.Start = nil
+		env.Export.Entries = make(map[string]wasm.ExportEntry)
+		env.Types.Entries = make([]wasm.FunctionSig, 0, len(fns))
+		for field, fn := range fns {
+			v := reflect.ValueOf(fn)
+			sig := wasm.FunctionSig{Form: -0x20}
+			for i := 1; i < v.Type().NumIn(); i++ {
+				sig.ParamTypes = append(sig.ParamTypes, wasm.ValueTypeI32)
+			}
+			for i := 0; i < v.Type().NumOut(); i++ {
+				sig.ReturnTypes = append(sig.ReturnTypes, wasm.ValueTypeI32)
+			}
+			env.Types.Entries = append(env.Types.Entries, sig)
+			env.Export.Entries[field] = wasm.ExportEntry{FieldStr: field, Kind: wasm.ExternalFunction, Index: uint32(len(env.FunctionIndexSpace))}
+			env.FunctionIndexSpace = append(env.FunctionIndexSpace, wasm.Function{
+				Sig:  &env.Types.Entries[len(env.Types.Entries)-1],
+				Host: v,
+				Body: &wasm.FunctionBody{},
+			})
+		}
+		return env, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// call calls the exported function name.
+func call(vm *exec.VM, m *wasm.Module, name string, args ...uint64) (interface{}, error) {
+	return vm.ExecCode(int64(m.Export.Entries[name].Index), args...)
+}
+
+const floatsWat = `
+(module
+  (memory (export "memory") 1)
+  (func (export "f32") (param $a i32) (param $b i32) (result i32)
+    (local $x f32) (local $y f32)
+    (local.set $x (f32.reinterpret_i32 (local.get $a)))
+    (local.set $y (f32.reinterpret_i32 (local.get $b)))
+    (f32.store (i32.const 0) (f32.add (local.get $x) (local.get $y)))
+    (f32.store (i32.const 4) (f32.sub (local.get $x) (local.get $y)))
+    (f32.store (i32.const 8) (f32.mul (local.get $x) (local.get $y)))
+    (f32.store (i32.const 12) (f32.div (local.get $x) (local.get $y)))
+    (f32.store (i32.const 16) (f32.min (local.get $x) (local.get $y)))
+    (f32.store (i32.const 20) (f32.max (local.get $x) (local.get $y)))
+    (f32.store (i32.const 24) (f32.sqrt (local.get $x)))
+    (f32.store (i32.const 28) (f32.nearest (local.get $x)))
+    (f32.store (i32.const 32) (f32.copysign (local.get $x) (local.get $y)))
+    (f64.store (i32.const 40) (f64.promote_f32 (local.get $x)))
+    (i32.load (i32.const 12)))
+  (func (export "f64") (param $a i64) (param $b i64) (result i64)
+    (local $x f64) (local $y f64)
+    (local.set $x (f64.reinterpret_i64 (local.get $a)))
+    (local.set $y (f64.reinterpret_i64 (local.get $b)))
+    (f64.store (i32.const 0) (f64.add (local.get $x) (local.get $y)))
+    (f64.store (i32.const 8) (f64.sub (local.get $x) (local.get $y)))
+    (f64.store (i32.const 16) (f64.mul (local.get $x) (local.get $y)))
+    (f64.store (i32.const 24) (f64.div (local.get $x) (local.get $y)))
+    (f64.store (i32.const 32) (f64.min (local.get $x) (local.get $y)))
+    (f64.store (i32.const 40) (f64.max (local.get $x) (local.get $y)))
+    (f64.store (i32.const 48) (f64.sqrt (local.get $x)))
+    (f64.store (i32.const 56) (f64.nearest (local.get $x)))
+    (f32.store (i32.const 64) (f32.demote_f64 (local.get $x)))
+    (i64.load (i32.const 24))))
+`
+
+func TestDeterministicNaN(t *testing.T) {
+	m := readWat(t, floatsWat, nil)
+	vm, err := exec.NewVM(m, exec.Deterministic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0/0 and the square root of -1 are NaNs whose sign depends on the
+	// platform, and the payloads of NaN operands are propagated.
+	for _, tc := range []struct {
+		fn   string
+		a, b uint64
+		nans []int
+	}{
+		{"f32", 0, 0, []int{12}},
+		{"f32", uint64(math.Float32bits(-1)), 0x7fa00001, []int{0, 4, 8, 12, 16, 20, 24}},
+		{"f64", 0, 0, []int{24}},
+		{"f64", math.Float64bits(-1), 0x7ff4000000000001, []int{0, 8, 16, 24, 32, 40, 48}},
+		{"f64", 0xfff4000000000001, 0, []int{0, 8, 16, 24, 32, 40, 48, 56}},
+	} {
+		if _, err := call(vm, m, tc.fn, tc.a, tc.b); err != nil {
+			t.Fatal(err)
+		}
+		mem := vm.Memory()
+		for _, off := range tc.nans {
+			if tc.fn == "f32" {
+				if got := binary.LittleEndian.Uint32(mem[off:]); got != 0x7fc00000 {
+					t.Errorf("%s(%#x, %#x) at %d = %#x, want 0x7fc00000", tc.fn, tc.a, tc.b, off, got)
+				}
+				continue
+			}
+			if got := binary.LittleEndian.Uint64(mem[off:]); got != 0x7ff8000000000000 {
+				t.Errorf("%s(%#x, %#x) at %d = %#x, want 0x7ff8000000000000", tc.fn, tc.a, tc.b, off, got)
+			}
+		}
+	}
+
+	// the conversions of NaNs are canonical too.
+	if _, err := call(vm, m, "f32", 0xffa00001, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint64(vm.Memory()[40:]); got != 0x7ff8000000000000 {
+		t.Errorf("f64.promote_f32 = %#x, want 0x7ff8000000000000", got)
+	}
+	if _, err := call(vm, m, "f64", 0xfff4000000000001, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(vm.Memory()[64:]); got != 0x7fc00000 {
+		t.Errorf("f32.demote_f64 = %#x, want 0x7fc00000", got)
+	}
+}
+
+const vectorFloatsWat = `
+(module
+  (memory (export "memory") 1)
+  (func (export "f32x4") (param $a i32) (param $b i32)
+    (local $x v128) (local $y v128)
+    (local.set $x (f32x4.splat (f32.reinterpret_i32 (local.get $a))))
+    (local.set $y (f32x4.splat (f32.reinterpret_i32 (local.get $b))))
+    (v128.store (i32.const 0) (f32x4.add (local.get $x) (local.get $y)))
+    (v128.store (i32.const 16) (f32x4.sub (local.get $x) (local.get $y)))
+    (v128.store (i32.const 32) (f32x4.mul (local.get $x) (local.get $y)))
+    (v128.store (i32.const 48) (f32x4.div (local.get $x) (local.get $y)))
+    (v128.store (i32.const 64) (f32x4.min (local.get $x) (local.get $y)))
+    (v128.store (i32.const 80) (f32x4.max (local.get $x) (local.get $y)))
+    (v128.store (i32.const 96) (f32x4.sqrt (local.get $x)))
+    (v128.store (i32.const 112) (f32x4.nearest (local.get $x)))
+    (v128.store (i32.const 128) (f64x2.promote_low_f32x4 (local.get $x)))
+    (v128.store (i32.const 144) (f32x4.neg (local.get $x))))
+  (func (export "f64x2") (param $a i64) (param $b i64)
+    (local $x v128) (local $y v128)
+    (local.set $x (f64x2.splat (f64.reinterpret_i64 (local.get $a))))
+    (local.set $y (f64x2.splat (f64.reinterpret_i64 (local.get $b))))
+    (v128.store (i32.const 0) (f64x2.add (local.get $x) (local.get $y)))
+    (v128.store (i32.const 16) (f64x2.sub (local.get $x) (local.get $y)))
+    (v128.store (i32.const 32) (f64x2.mul (local.get $x) (local.get $y)))
+    (v128.store (i32.const 48) (f64x2.div (local.get $x) (local.get $y)))
+    (v128.store (i32.const 64) (f64x2.min (local.get $x) (local.get $y)))
+    (v128.store (i32.const 80) (f64x2.max (local.get $x) (local.get $y)))
+    (v128.store (i32.const 96) (f64x2.sqrt (local.get $x)))
+    (v128.store (i32.const 112) (f64x2.nearest (local.get $x)))
+    (v128.store (i32.const 128) (f32x4.demote_f64x2_zero (local.get $x)))
+    (v128.store (i32.const 144) (f64x2.neg (local.get $x)))))
+`
+
+func TestDeterministicVectorNaN(t *testing.T) {
+	m := readWat(t, vectorFloatsWat, nil)
+	vm, err := exec.NewVM(m, exec.Deterministic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lanes of the results at the offsets nans are canonical NaNs, and
+	// the lanes at 144 keep the payload of the negated operand.
+	for _, tc := range []struct {
+		fn   string
+		a, b uint64
+		nans []int
+		neg  uint64
+	}{
+		{"f32x4", 0, 0, []int{48}, 0x80000000},
+		{"f32x4", 0x7fa00001, uint64(math.Float32bits(1)), []int{0, 16, 32, 48, 64, 80, 96, 112}, 0xffa00001},
+		{"f64x2", 0, 0, []int{48}, 0x8000000000000000},
+		{"f64x2", 0x7ff4000000000001, math.Float64bits(1), []int{0, 16, 32, 48, 64, 80, 96, 112}, 0xfff4000000000001},
+	} {
+		if _, err := call(vm, m, tc.fn, tc.a, tc.b); err != nil {
+			t.Fatal(err)
+		}
+		mem := vm.Memory()
+		size := 8
+		if tc.fn == "f32x4" {
+			size = 4
+		}
+		for _, off := range tc.nans {
+			for i := 0; i < 16; i += size {
+				if tc.fn == "f32x4" {
+					if got := binary.LittleEndian.Uint32(mem[off+i:]); got != 0x7fc00000 {
+						t.Errorf("%s(%#x, %#x) at %d = %#x, want 0x7fc00000", tc.fn, tc.a, tc.b, off+i, got)
+					}
+					continue
+				}
+				if got := binary.LittleEndian.Uint64(mem[off+i:]); got != 0x7ff8000000000000 {
+					t.Errorf("%s(%#x, %#x) at %d = %#x, want 0x7ff8000000000000", tc.fn, tc.a, tc.b, off+i, got)
+				}
+			}
+		}
+		var got uint64
+		if tc.fn == "f32x4" {
+			got = uint64(binary.LittleEndian.Uint32(mem[144:]))
+		} else {
+			got = binary.LittleEndian.Uint64(mem[144:])
+		}
+		if got != tc.neg {
+			t.Errorf("%s(%#x, %#x): neg = %#x, want %#x", tc.fn, tc.a, tc.b, got, tc.neg)
+		}
+	}
+
+	// the conversions of NaNs are canonical too.
+	if _, err := call(vm, m, "f32x4", 0xffa00001, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint64(vm.Memory()[128:]); got != 0x7ff8000000000000 {
+		t.Errorf("f64x2.promote_low_f32x4 = %#x, want 0x7ff8000000000000", got)
+	}
+	if _, err := call(vm, m, "f64x2", 0xfff4000000000001, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(vm.Memory()[128:]); got != 0x7fc00000 {
+		t.Errorf("f32x4.demote_f64x2_zero = %#x, want 0x7fc00000", got)
+	}
+}
+
+func TestDeterministicSharedMemory(t *testing.T) {
+	m := readWat(t, `(module (memory 1 1 shared))`, nil)
+	if _, err := exec.NewVM(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.NewVM(m, exec.Deterministic(true)); err != exec.ErrNondeterministicSharedMemory {
+		t.Errorf("shared memory: err = %v, want %v", err, exec.ErrNondeterministicSharedMemory)
+	}
+
+	mem, err := exec.NewSharedMemory(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = readWat(t, `(module (memory 1))`, nil)
+	if _, err := exec.NewVM(m, exec.Deterministic(true), exec.WithSharedMemory(mem)); err != exec.ErrNondeterministicSharedMemory {
+		t.Errorf("WithSharedMemory: err = %v, want %v", err, exec.ErrNondeterministicSharedMemory)
+	}
+
+	for _, instr := range []string{
+		"(memory.atomic.notify (i32.const 0) (i32.const 1))",
+		"(memory.atomic.wait32 (i32.const 0) (i32.const 0) (i64.const 0))",
+		"(memory.atomic.wait64 (i32.const 0) (i64.const 0) (i64.const 0))",
+	} {
+		m := readWat(t, `(module (memory 1) (func (result i32) `+instr+`))`, nil)
+		if _, err := exec.NewVM(m); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := exec.NewVM(m, exec.Deterministic(true)); err != exec.ErrNondeterministicWait {
+			t.Errorf("%s: err = %v, want %v", instr, err, exec.ErrNondeterministicWait)
+		}
+	}
+}
+
+func TestFloatOperators(t *testing.T) {
+	m := readWat(t, `
+(module
+  (func (export "f32.nearest") (param f32) (result f32) (f32.nearest (local.get 0)))
+  (func (export "f64.nearest") (param f64) (result f64) (f64.nearest (local.get 0)))
+  (func (export "f32.copysign") (param f32 f32) (result f32) (f32.copysign (local.get 0) (local.get 1)))
+  (func (export "f64.copysign") (param f64 f64) (result f64) (f64.copysign (local.get 0) (local.get 1)))
+  (func (export "f32.neg") (param f32) (result f32) (f32.neg (local.get 0)))
+  (func (export "f64.abs") (param f64) (result f64) (f64.abs (local.get 0)))
+  (func (export "i32.trunc_f32_s") (param f32) (result i32) (i32.trunc_f32_s (local.get 0)))
+  (func (export "i32.trunc_f64_u") (param f64) (result i32) (i32.trunc_f64_u (local.get 0)))
+  (func (export "i64.trunc_f64_s") (param f64) (result i64) (i64.trunc_f64_s (local.get 0)))
+  (func (export "i64.trunc_f32_u") (param f32) (result i64) (i64.trunc_f32_u (local.get 0))))
+`, nil)
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+
+	f32 := func(f float32) uint64 { return uint64(math.Float32bits(f)) }
+	f64 := math.Float64bits
+	for _, tc := range []struct {
+		fn   string
+		args []uint64
+		want interface{}
+		err  error
+	}{
+		{fn: "f32.nearest", args: []uint64{f32(2.5)}, want: float32(2)},
+		{fn: "f32.nearest", args: []uint64{f32(-3.5)}, want: float32(-4)},
+		{fn: "f32.nearest", args: []uint64{f32(-0.25)}, want: float32(math.Copysign(0, -1))},
+		{fn: "f32.nearest", args: []uint64{f32(1e10)}, want: float32(1e10)},
+		{fn: "f64.nearest", args: []uint64{f64(0.5)}, want: float64(0)},
+		{fn: "f64.nearest", args: []uint64{f64(1.5)}, want: float64(2)},
+		{fn: "f64.nearest", args: []uint64{f64(4503599627370497)}, want: float64(4503599627370497)},
+		{fn: "f64.nearest", args: []uint64{f64(1e300)}, want: float64(1e300)},
+		{fn: "f32.copysign", args: []uint64{f32(2), f32(-1)}, want: float32(-2)},
+		{fn: "f32.copysign", args: []uint64{f32(-2), f32(1)}, want: float32(2)},
+		{fn: "f64.copysign", args: []uint64{f64(3), f64(-0.5)}, want: float64(-3)},
+		{fn: "f32.neg", args: []uint64{0x7fa00001}, want: math.Float32frombits(0xffa00001)},
+		{fn: "f64.abs", args: []uint64{0xfff4000000000001}, want: math.Float64frombits(0x7ff4000000000001)},
+		{fn: "i32.trunc_f32_s", args: []uint64{f32(-2147483648)}, want: uint32(0x80000000)},
+		{fn: "i32.trunc_f32_s", args: []uint64{f32(2147483648)}, err: exec.ErrIntegerOverflow},
+		{fn: "i32.trunc_f32_s", args: []uint64{0x7fc00000}, err: exec.ErrInvalidConversion},
+		{fn: "i32.trunc_f64_u", args: []uint64{f64(-0.9)}, want: uint32(0)},
+		{fn: "i32.trunc_f64_u", args: []uint64{f64(4294967295.9)}, want: uint32(4294967295)},
+		{fn: "i32.trunc_f64_u", args: []uint64{f64(4294967296)}, err: exec.ErrIntegerOverflow},
+		{fn: "i32.trunc_f64_u", args: []uint64{f64(-1)}, err: exec.ErrIntegerOverflow},
+		{fn: "i64.trunc_f64_s", args: []uint64{f64(-9223372036854775808)}, want: uint64(1 << 63)},
+		{fn: "i64.trunc_f64_s", args: []uint64{f64(9223372036854775808)}, err: exec.ErrIntegerOverflow},
+		{fn: "i64.trunc_f32_u", args: []uint64{f32(1.8446743e19)}, want: uint64(18446742974197923840)},
+		{fn: "i64.trunc_f32_u", args: []uint64{f32(float32(math.Inf(1)))}, err: exec.ErrIntegerOverflow},
+	} {
+		got, err := call(vm, m, tc.fn, tc.args...)
+		if err != tc.err {
+			t.Errorf("%s(%#x): err = %v, want %v", tc.fn, tc.args, err, tc.err)
+			continue
+		}
+		if err == nil && floatBits(got) != floatBits(tc.want) {
+			t.Errorf("%s(%#x) = %v, want %v", tc.fn, tc.args, got, tc.want)
+		}
+	}
+}
+
+// floatBits returns the bits of the float v, so that NaN payloads and the
+// sign of zeros are compared, or v itself.
+func floatBits(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float32:
+		return math.Float32bits(v)
+	case float64:
+		return math.Float64bits(v)
+	}
+	return v
+}
+
+func TestDeterministicHost(t *testing.T) {
+	src := `
+(module
+  (import "env" "now" (func $now (result i32)))
+  (import "env" "add" (func $add (param i32 i32) (result i32)))
+  (func (export "f") (result i32)
+    (call $add (i32.const 1) (i32.const 2))))
+`
+	fns := map[string]interface{}{
+		"now": func(proc *exec.Process) int32 { return 0 },
+		"add": func(proc *exec.Process, a, b int32) int32 { return a + b },
+	}
+	m := readWat(t, src, fns)
+	if _, err := exec.NewVM(m); err != nil {
+		t.Fatal(err)
+	}
+	_, err := exec.NewVM(m, exec.Deterministic(true))
+	if want := (exec.NondeterministicHostError{Module: "env", Field: "now"}); err != want {
+		t.Fatalf("err = %v, want %v", err, want)
+	}
+	_, err = exec.NewVM(m, exec.Deterministic(true), exec.DeterministicHost(func(module, field string) bool {
+		return field == "now"
+	}))
+	if want := (exec.NondeterministicHostError{Module: "env", Field: "add"}); err != want {
+		t.Fatalf("err = %v, want %v", err, want)
+	}
+
+	vm, err := exec.NewVM(m, exec.Deterministic(true), exec.DeterministicHost(func(module, field string) bool {
+		return module == "env"
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := call(vm, m, "f"); err != nil || got != uint32(3) {
+		t.Errorf("f() = %v, %v, want 3", got, err)
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	m := readWat(t, `
+(module
+  (func $f (export "f") (param i32) (result i32)
+    (if (result i32) (i32.eqz (local.get 0))
+      (then (i32.const 0))
+      (else (i32.add (i32.const 1) (call $f (i32.sub (local.get 0) (i32.const 1))))))))
+`, nil)
+	for _, tc := range []struct {
+		opts  []exec.VMOption
+		depth uint64 // the deepest call that doesn't trap
+	}{
+		{[]exec.VMOption{exec.MaxCallDepth(10)}, 9},
+		{[]exec.VMOption{exec.Deterministic(true)}, exec.DefaultMaxCallDepth - 1},
+		{[]exec.VMOption{exec.Deterministic(true), exec.MaxCallDepth(100)}, 99},
+	} {
+		vm, err := exec.NewVM(m, tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm.RecoverPanic = true
+		// the VM can still be used after the stack is exhausted.
+		for i := 0; i < 2; i++ {
+			if got, err := call(vm, m, "f", tc.depth); err != nil || got != uint32(tc.depth) {
+				t.Errorf("f(%d) = %v, %v, want %d", tc.depth, got, err, tc.depth)
+			}
+			if _, err := call(vm, m, "f", tc.depth+1); err != exec.ErrCallStackExhausted {
+				t.Errorf("f(%d): err = %v, want %v", tc.depth+1, err, exec.ErrCallStackExhausted)
+			}
+		}
+	}
+}
+
+func TestMaxMemoryPages(t *testing.T) {
+	src := `
+(module
+  (memory 2)
+  (func (export "grow") (param i32) (result i32) (memory.grow (local.get 0))))
+`
+	m := readWat(t, src, nil)
+	if _, err := exec.NewVM(m, exec.MaxMemoryPages(1)); err != exec.ErrMemoryLimit {
+		t.Fatalf("err = %v, want %v", err, exec.ErrMemoryLimit)
+	}
+
+	vm, err := exec.NewVM(m, exec.MaxMemoryPages(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		n, want uint32
+	}{
+		{3, 0xffffffff},
+		{2, 2},
+		{1, 0xffffffff},
+	} {
+		if got, err := call(vm, m, "grow", uint64(tc.n)); err != nil || got != tc.want {
+			t.Errorf("grow(%d) = %v, %v, want %d", tc.n, got, err, tc.want)
+		}
+	}
+
+	// the memories are limited by default, deterministic or not.
+	for _, deterministic := range []bool{false, true} {
+		vm, err = exec.NewVM(m, exec.Deterministic(deterministic))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := call(vm, m, "grow", exec.DefaultMaxMemoryPages-1); err != nil || got != uint32(0xffffffff) {
+			t.Errorf("deterministic=%v: grow(%d) = %v, %v, want -1", deterministic, exec.DefaultMaxMemoryPages-1, got, err)
+		}
+		if got, err := call(vm, m, "grow", 0xffffffff); err != nil || got != uint32(0xffffffff) {
+			t.Errorf("deterministic=%v: grow(-1) = %v, %v, want -1", deterministic, got, err)
+		}
+	}
+
+	m = readWat(t, `(module (memory 1 8 shared))`, nil)
+	if _, err := exec.NewVM(m, exec.MaxMemoryPages(4)); err != exec.ErrMemoryLimit {
+		t.Fatalf("shared memory: err = %v, want %v", err, exec.ErrMemoryLimit)
+	}
+
+	if _, err := exec.NewSharedMemory(1, 8, exec.MaxMemoryPages(4)); err != exec.ErrMemoryLimit {
+		t.Fatalf("NewSharedMemory: err = %v, want %v", err, exec.ErrMemoryLimit)
+	}
+
+	// the limit, set or default, is checked before the 4GiB of the memory
+	// are allocated.
+	m = readWat(t, `(module (memory 1 65536 shared))`, nil)
+	for _, tc := range []struct {
+		name string
+		new  func() error
+	}{
+		{"NewVM(MaxMemoryPages(4))", func() error {
+			_, err := exec.NewVM(m, exec.MaxMemoryPages(4))
+			return err
+		}},
+		{"NewVM", func() error {
+			_, err := exec.NewVM(m)
+			return err
+		}},
+		{"NewSharedMemory", func() error {
+			_, err := exec.NewSharedMemory(1, 65536)
+			return err
+		}},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if err := tc.new(); err != exec.ErrMemoryLimit {
+			t.Fatalf("%s: err = %v, want %v", tc.name, err, exec.ErrMemoryLimit)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%s: %d bytes allocated", tc.name, n)
+		}
+	}
+}
+
+// outcomeHash runs the exported functions of the module src with each of
+// the arguments, in a new deterministic VM, and returns a hash of their
+// results, of their traps, and of the final memory.
+func outcomeHash(t *testing.T, m *wasm.Module, args [][2]uint64) string {
+	t.Helper()
+	vm, err := exec.NewVM(m, exec.Deterministic(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+	h := sha256.New()
+	for _, name := range []string{"f32", "f64", "mix"} {
+		for _, a := range args {
+			out, err := call(vm, m, name, a[0], a[1])
+			fmt.Fprintf(h, "%s(%#x, %#x) = %#v, %v\n", name, a[0], a[1], out, err)
+			h.Write(vm.Memory()[:128])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// TestDeterministicHash checks that running the same computations gives
+// the same outcome, on every platform.
+func TestDeterministicHash(t *testing.T) {
+	src := strings.TrimSuffix(strings.TrimSpace(floatsWat), ")") + `
+  (func (export "mix") (param $a i64) (param $b i64) (result i64)
+    (local $f f64)
+    (local.set $f (f64.div (f64.convert_i64_s (local.get $a)) (f64.reinterpret_i64 (local.get $b))))
+    (i64.store (i32.const 72) (i64.trunc_sat_f64_s (local.get $f)))
+    (i32.store (i32.const 80) (i32.trunc_f32_u (f32.demote_f64 (f64.abs (local.get $f)))))
+    (i64.add (i64.reinterpret_f64 (f64.sqrt (local.get $f))) (i64.rotl (local.get $a) (local.get $b)))))
+`
+	m := readWat(t, src, nil)
+	if err := validate.VerifyModule(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var args [][2]uint64
+	for _, a := range []uint64{0, 1, 0x7fa00001, 0xffa00001, 0x7ff4000000000001, math.Float64bits(-2.5), math.Float64bits(math.Inf(-1)), 1 << 63, 12345} {
+		for _, b := range []uint64{0, math.Float64bits(-0.0), 0x7fc00000, 0xfff8000000000001, math.Float64bits(3), 7} {
+			args = append(args, [2]uint64{a, b})
+		}
+	}
+
+	want := outcomeHash(t, m, args)
+	for i := 0; i < 3; i++ {
+		if got := outcomeHash(t, m, args); got != want {
+			t.Fatalf("run %d: hash = %s, want %s", i, got, want)
+		}
+	}
+	// computed on linux/amd64: any other platform must agree.
+	const golden = "47b897aad282d3dba45cde69a1a53a19306a1ee95b64d0903d2b57df6750ceb1"
+	if want != golden {
+		t.Errorf("hash = %s, want %s", want, golden)
+	}
+}
+
+func TestNoFloat(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err error
+	}{
+		{`(module (func (result i32) (i32.add (i32.const 1) (i32.const 2))))`, nil},
+		{`(module (func (result f32) (f32.const 1)))`, validate.FloatOpError("f32.const")},
+		{`(module (func (param f64) (result i64) (i64.trunc_f64_s (local.get 0))))`, validate.FloatOpError("i64.trunc_s/f64")},
+		{`(module (func (param v128) (result v128) (f32x4.add (local.get 0) (local.get 0))))`, validate.FloatOpError("f32x4.add")},
+		{`(module (func (param v128) (result v128) (i32x4.trunc_sat_f64x2_s_zero (local.get 0))))`, validate.FloatOpError("i32x4.trunc_sat_f64x2_s_zero")},
+	} {
+		m := readWat(t, tc.src, nil)
+		if err := validate.VerifyModule(m); err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		err := validate.VerifyModuleWithOptions(m, validate.Options{NoFloat: true})
+		if verr, ok := err.(validate.Error); ok {
+			err = verr.Err
+		}
+		if err != tc.err {
+			t.Errorf("%s: err = %v, want %v", tc.src, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/gen"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+var (
+	diffModules = flag.Int("diff.modules", 100, "number of random modules run by TestDifferential")
+	diffSeed    = flag.Int64("diff.seed", 1, "seed of the first random module run by TestDifferential")
+)
+
+// diffMaxPages limits the memories of the modules run by TestDifferential.
+const diffMaxPages = 4
+
+// diffConfigs are the configurations of the VMs compared to the reference
+// interpreter by TestDifferential.
+var diffConfigs = []struct {
+	name string
+	opts []exec.VMOption
+}{
+	{"eager", nil},
+	{"lazy", []exec.VMOption{exec.LazyCompile(true)}},
+	{"concurrent", []exec.VMOption{exec.CompileConcurrency(4)}},
+}
+
+// TestDifferential runs random modules generated by the gen package in
+// deterministic VMs, with the functions compiled in different ways, and
+// in the reference interpreter. The results and the traps of the exported
+// functions, and the final globals and memory, must be the same.
+//
+// exec has no optimization passes which could be turned off to get a
+// simpler VM to compare with: the compilation modes only change when the
+// functions are compiled, and all of them run the same compiled code,
+// sharing its bugs. The reference interpreter is written independently of
+// the VM, interpreting the structured instructions directly rather than
+// the compiled branches and stack adjustments, so that it doesn't share
+// them.
+//
+// A failing module can be run again alone with -diff.seed and
+// -diff.modules=1.
+func TestDifferential(t *testing.T) {
+	n := *diffModules
+	if testing.Short() && n > 10 {
+		n = 10
+	}
+	for seed := *diffSeed; seed < *diffSeed+int64(n); seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		m, err := gen.Module(rnd, gen.Config{})
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if err := validate.VerifyModule(m); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		calls := diffCalls(rnd, m)
+
+		want := referenceOutcome(m, calls)
+		for _, c := range diffConfigs {
+			opts := append([]exec.VMOption{exec.Deterministic(true), exec.MaxMemoryPages(diffMaxPages)}, c.opts...)
+			got := vmOutcome(m, calls, opts...)
+			if got != want {
+				buf := new(bytes.Buffer)
+				wast.WriteTo(buf, m, wast.Folded(true))
+				t.Fatalf("seed %d: %s VM:\n%s\nreference interpreter:\n%s\nmodule:\n%s", seed, c.name, got, want, buf)
+			}
+		}
+	}
+}
+
+// diffCall is a call of an exported function.
+type diffCall struct {
+	name  string
+	index int
+	args  []uint64
+}
+
+// diffCalls returns calls of the exported functions of m, in the order of
+// the export section, with random arguments.
+func diffCalls(rnd *rand.Rand, m *wasm.Module) []diffCall {
+	var calls []diffCall
+	if m.Export == nil {
+		return nil
+	}
+	for _, name := range m.Export.Names {
+		entry := m.Export.Entries[name]
+		if entry.Kind != wasm.ExternalFunction {
+			continue
+		}
+		c := diffCall{name: name, index: int(entry.Index)}
+		for _, t := range m.GetFunction(c.index).Sig.ParamTypes {
+			c.args = append(c.args, gen.Value(rnd, t))
+		}
+		calls = append(calls, c)
+	}
+	return calls
+}
+
+// vmOutcome runs the calls in a VM created with opts, and returns their
+// outcome, as written by writeCall and writeState.
+func vmOutcome(m *wasm.Module, calls []diffCall, opts ...exec.VMOption) string {
+	out := new(bytes.Buffer)
+	vm, err := exec.NewVM(m, opts...)
+	if err != nil {
+		return fmt.Sprintf("instantiation: %v", err)
+	}
+	vm.RecoverPanic = true
+	for _, c := range calls {
+		res, err := vm.ExecCode(int64(c.index), c.args...)
+		var results []uint64
+		if res != nil {
+			results = []uint64{valueBits(res)}
+		}
+		writeCall(out, c, results, err)
+	}
+	var globals []uint64
+	for i := range m.GlobalIndexSpace {
+		v, err := vm.Global(i)
+		if err != nil {
+			return fmt.Sprintf("global %d: %v", i, err)
+		}
+		globals = append(globals, valueBits(v))
+	}
+	writeState(out, globals, vm.Memory())
+	return out.String()
+}
+
+// referenceOutcome runs the calls in the reference interpreter, and
+// returns their outcome, as written by writeCall and writeState.
+func referenceOutcome(m *wasm.Module, calls []diffCall) string {
+	out := new(bytes.Buffer)
+	in, err := newInterp(m, diffMaxPages)
+	if err != nil {
+		return fmt.Sprintf("instantiation: %v", err)
+	}
+	for _, c := range calls {
+		var results []uint64
+		err := referenceTrap(func() {
+			results = in.call(c.index, c.args)
+		})
+		writeCall(out, c, results, err)
+	}
+	writeState(out, in.globals, in.memory)
+	return out.String()
+}
+
+// referenceTrap calls fn, and returns the error of the trap it panicked
+// with, if any. Other panics are bugs of the interpreter, and are
+// propagated.
+func referenceTrap(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if re, isRuntime := e.(runtime.Error); !ok || isRuntime && re.Error() != "runtime error: integer divide by zero" {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	fn()
+	return nil
+}
+
+func writeCall(out *bytes.Buffer, c diffCall, results []uint64, err error) {
+	fmt.Fprintf(out, "%s%#x: ", c.name, c.args)
+	if err != nil {
+		fmt.Fprintf(out, "trap: %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "%#x\n", results)
+}
+
+func writeState(out *bytes.Buffer, globals []uint64, memory []byte) {
+	fmt.Fprintf(out, "globals: %#x\n", globals)
+	fmt.Fprintf(out, "memory: %d bytes, %x\n", len(memory), sha256.Sum256(memory))
+}
+
+// valueBits returns the bits of the value v returned by the VM.
+func valueBits(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	case float32:
+		return uint64(math.Float32bits(v))
+	case float64:
+		return math.Float64bits(v)
+	}
+	panic(fmt.Sprintf("unexpected value %#v", v))
+}
+
+// BenchmarkNewVM instantiates random modules of different sizes, generated
+// by the gen package, with their functions compiled eagerly, lazily and
+// concurrently.
+func BenchmarkNewVM(b *testing.B) {
+	for _, size := range []struct {
+		name string
+		c    gen.Config
+	}{
+		{"small", gen.Config{}},
+		{"large", gen.Config{MaxFunctions: 100, MaxBodySize: 1000, MaxDepth: 10}},
+	} {
+		var modules []*wasm.Module
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 10; i++ {
+			m, err := gen.Module(rnd, size.c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			modules = append(modules, m)
+		}
+		for _, c := range diffConfigs {
+			b.Run(size.name+"/"+c.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := exec.NewVM(modules[i%len(modules)], c.opts...); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
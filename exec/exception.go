@@ -0,0 +1,126 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidException is the error value used while trapping the VM when a
+// host function throws an exception whose tag isn't defined by the module,
+// or whose values don't match the type of the tag.
+var ErrInvalidException = errors.New("exec: invalid exception")
+
+// Exception is a WebAssembly exception, thrown by the throw operator or by
+// a host function with (*Process).Throw. An exception that isn't caught by
+// the module is returned as an error by (*VM).ExecCode.
+type Exception struct {
+	Tag    uint32 // index of the tag of the exception in the tag index space of the module
+	values []value
+}
+
+// NewException creates an exception with the given tag, carrying the given
+// values, which are encoded as the arguments of (*VM).ExecCode.
+func NewException(tag uint32, values ...uint64) *Exception {
+	e := &Exception{Tag: tag, values: make([]value, len(values))}
+	for i, v := range values {
+		e.values[i] = value{lo: v}
+	}
+	return e
+}
+
+// Values returns the values carried by the exception, in the order of the
+// parameters of the type of its tag.
+func (e *Exception) Values() []uint64 {
+	values := make([]uint64, len(e.values))
+	for i, v := range e.values {
+		values[i] = v.lo
+	}
+	return values
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("exec: uncaught exception with tag %d", e.Tag)
+}
+
+// throwException makes e the pending exception, which stops the execution
+// of the current function until a catch clause handling e is found, either
+// in this function or in one of its callers.
+func (vm *VM) throwException(e *Exception) {
+	vm.exception = e
+	vm.abort = true
+}
+
+func (vm *VM) throw() {
+	tag := vm.fetchUint32()
+	sig := vm.module.GetTagType(int(tag))
+	values := make([]value, len(sig.ParamTypes))
+	for i := len(values) - 1; i >= 0; i-- {
+		values[i] = vm.popValue()
+	}
+	vm.throwException(&Exception{Tag: tag, values: values})
+}
+
+func (vm *VM) rethrow() {
+	vm.throwException(vm.ctx.caught[vm.fetchUint32()])
+}
+
+// catch looks for a catch clause of the current function handling the
+// pending exception, which was thrown by the operator preceding vm.ctx.pc.
+// If there is one, the stack is unwound, the values of the exception are
+// pushed, and the execution continues with the catch clause.
+func (vm *VM) catch(compiled *compiledFunction) bool {
+	e := vm.exception
+	pc := vm.ctx.pc
+
+	index := -1
+	// try blocks are sorted by start address, so the first one containing
+	// pc, from the end, is the innermost one.
+	for i := len(compiled.tryBlocks) - 1; i >= 0; i-- {
+		if try := compiled.tryBlocks[i]; try.Start < pc && pc <= try.BodyEnd {
+			index = i
+			break
+		}
+	}
+
+	for index >= 0 {
+		try := &compiled.tryBlocks[index]
+		for _, c := range try.Catches {
+			if !c.All && c.Tag != e.Tag {
+				continue
+			}
+			vm.ctx.stack = vm.ctx.stack[:try.StackHeight]
+			if !c.All {
+				for _, v := range e.values {
+					vm.pushValue(v)
+				}
+			}
+			// keep the exception for rethrow
+			if vm.ctx.caught == nil {
+				vm.ctx.caught = make([]*Exception, len(compiled.tryBlocks))
+			}
+			vm.ctx.caught[index] = e
+
+			vm.ctx.pc = c.Addr
+			vm.exception = nil
+			vm.abort = false
+			return true
+		}
+		index = try.Outer
+	}
+	return false
+}
+
+// Throw throws the exception e from the host function being executed, as
+// the throw operator does. The values returned by the host function are
+// discarded.
+func (proc *Process) Throw(e *Exception) {
+	sig := proc.vm.module.GetTagType(int(e.Tag))
+	if sig == nil || len(sig.ParamTypes) != len(e.values) {
+		panic(ErrInvalidException)
+	}
+	proc.vm.throwException(e)
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestUncaughtException(t *testing.T) {
+	f, err := os.Open("testdata/exceptions.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := wasm.ReadModule(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vm.ExecCode(int64(m.Export.Entries["thrower"].Index), 7)
+	e, ok := err.(*Exception)
+	if !ok {
+		t.Fatalf("thrower returned %v, want an exception", err)
+	}
+	if tag := m.Export.Entries["e0"].Index; e.Tag != tag {
+		t.Errorf("the tag of the exception is %d, want %d", e.Tag, tag)
+	}
+	if values := e.Values(); !reflect.DeepEqual(values, []uint64{7}) {
+		t.Errorf("the values of the exception are %v, want [7]", values)
+	}
+
+	// the VM can still be used once the exception is returned
+	rtrn, err := vm.ExecCode(int64(m.Export.Entries["throw_catch"].Index), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != uint32(2) {
+		t.Errorf("throw_catch returned %v, want 2", rtrn)
+	}
+}
+
+// newHostThrowVM creates a VM for a module which calls the host function
+// throw in a try block, and returns the value of the exception it catches.
+func newHostThrowVM(t *testing.T, throw interface{}) *VM {
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Types = &wasm.SectionTypes{
+		Entries: []wasm.FunctionSig{
+			// (func [] -> [i32])
+			{Form: 0, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			// (func [i32] -> [])
+			{Form: 0, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+		},
+	}
+	m.Function = &wasm.SectionFunctions{
+		Types: []uint32{0, 1},
+	}
+	m.Tag = &wasm.SectionTags{
+		Entries: []wasm.Tag{{Type: 1}},
+	}
+
+	// try (result i32)
+	//   i32.const 1
+	//   call 1
+	//   i32.const 0
+	// catch 0
+	// end
+	fb := wasm.FunctionBody{
+		Module: m,
+		Code:   []byte{0x06, 0x7f, 0x41, 0x01, 0x10, 0x01, 0x41, 0x00, 0x07, 0x00, 0x0b},
+	}
+	m.FunctionIndexSpace = []wasm.Function{
+		{
+			Sig:  &m.Types.Entries[0],
+			Body: &fb,
+		},
+		{
+			Sig:  &m.Types.Entries[1],
+			Host: reflect.ValueOf(throw),
+		},
+	}
+	m.Code = &wasm.SectionCode{
+		Bodies: []wasm.FunctionBody{fb},
+	}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("Error creating VM: %v", err)
+	}
+	return vm
+}
+
+func TestHostThrow(t *testing.T) {
+	vm := newHostThrowVM(t, func(proc *Process, v int32) {
+		proc.Throw(NewException(0, uint64(v+41)))
+	})
+	rtrn, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != uint32(42) {
+		t.Errorf("caught %v, want 42", rtrn)
+	}
+}
+
+func TestHostThrowInvalid(t *testing.T) {
+	for _, e := range []*Exception{
+		NewException(1, 0),
+		NewException(0),
+	} {
+		vm := newHostThrowVM(t, func(proc *Process, v int32) {
+			proc.Throw(e)
+		})
+		vm.RecoverPanic = true
+		if _, err := vm.ExecCode(0); err != ErrInvalidException {
+			t.Errorf("throwing %v returned %v, want %v", e, err, ErrInvalidException)
+		}
+	}
+}
@@ -292,7 +292,7 @@ func runTest(fileName string, testCases []testCase, t testing.TB) {
 					t.Fatalf("%s, %s: %v", fileName, testCase.Function, err)
 				}
 			}
-			if p, msg := panics(fn); p && msg != testCase.Trap {
+			if p, msg := panics(fn); !p || msg != testCase.Trap {
 				t.Errorf("%s, %s: unexpected trap message: got=%s, want=%s", fileName, fnString(testCase.Function, testCase.Args), msg, testCase.Trap)
 			}
 			continue
@@ -393,6 +393,34 @@ func BenchmarkModules(b *testing.B) {
 	}
 }
 
+// errorRecorder is a testing.TB which records the errors reported to it.
+type errorRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *errorRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRunTestTrap(t *testing.T) {
+	path := filepath.Join(nonSpecTestsDir, "bug-49.wasm")
+	for _, tc := range []struct {
+		trap string
+		fail bool
+	}{
+		{"", false},
+		// sample returns 1, and doesn't trap.
+		{"i32:1", true},
+	} {
+		r := &errorRecorder{TB: t}
+		runTest(path, []testCase{{Function: "sample", Return: "i32:1", Trap: tc.trap}}, r)
+		if fail := len(r.errors) != 0; fail != tc.fail {
+			t.Errorf("trap %q: errors = %q", tc.trap, r.errors)
+		}
+	}
+}
+
 func TestNonSpec(t *testing.T) {
 	testModules(t, nonSpecTestsDir)
 }
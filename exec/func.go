@@ -9,7 +9,10 @@ import (
 	"math"
 	"reflect"
 
+	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/internal/parallel"
+	"github.com/go-interpreter/wagon/wasm"
 )
 
 type function interface {
@@ -19,12 +22,18 @@ type function interface {
 type compiledFunction struct {
 	code           []byte
 	branchTables   []*compile.BranchTable
+	tryBlocks      []compile.TryBlock
 	maxDepth       int  // maximum stack depth reached while executing the function body
 	totalLocalVars int  // number of local variables used by the function
 	args           int  // number of arguments the function accepts
 	returns        bool // whether the function returns a value
 }
 
+// lazyFunction is a function whose body has not been compiled yet.
+// It is compiled, and replaced by the resulting compiledFunction, by
+// (*VM).CompileAll or the first time it is called.
+type lazyFunction struct{}
+
 type goFunction struct {
 	val reflect.Value
 	typ reflect.Type
@@ -56,6 +65,14 @@ func (fn goFunction) call(vm *VM, index int64) {
 			val.SetUint(raw)
 		case reflect.Int32, reflect.Int64:
 			val.SetInt(int64(raw))
+		case reflect.Interface:
+			// externref, nil for the null reference
+			if v := vm.externValue(raw); v != nil {
+				if !reflect.TypeOf(v).AssignableTo(val.Type()) {
+					panic(fmt.Sprintf("exec: args %d externref value of type %T is not assignable to %v", i, v, val.Type()))
+				}
+				val.Set(reflect.ValueOf(v))
+			}
 		default:
 			panic(fmt.Sprintf("exec: args %d invalid kind=%v", i, kind))
 		}
@@ -64,6 +81,10 @@ func (fn goFunction) call(vm *VM, index int64) {
 	}
 
 	rtrns := fn.val.Call(args)
+	if vm.exception != nil {
+		// thrown by the host function with (*Process).Throw
+		return
+	}
 	for i, out := range rtrns {
 		kind := out.Kind()
 		switch kind {
@@ -73,18 +94,28 @@ func (fn goFunction) call(vm *VM, index int64) {
 			vm.pushUint64(out.Uint())
 		case reflect.Int32, reflect.Int64:
 			vm.pushInt64(out.Int())
+		case reflect.Interface:
+			vm.pushUint64(vm.ExternRef(out.Interface()))
 		default:
 			panic(fmt.Sprintf("exec: return value %d invalid kind=%v", i, kind))
 		}
 	}
 }
 
+// frameSize returns the number of values of the frame of a call of the
+// function, counted by MaxStackSize.
+func (compiled compiledFunction) frameSize() int {
+	return compiled.totalLocalVars + compiled.maxDepth + 1
+}
+
 func (compiled compiledFunction) call(vm *VM, index int64) {
-	newStack := make([]uint64, compiled.maxDepth)
-	locals := make([]uint64, compiled.totalLocalVars)
+	size := compiled.frameSize()
+	vm.enter(size)
+	newStack := make([]value, 0, compiled.maxDepth)
+	locals := make([]value, compiled.totalLocalVars)
 
 	for i := compiled.args - 1; i >= 0; i-- {
-		locals[i] = vm.popUint64()
+		locals[i] = vm.popValue()
 	}
 
 	//save execution context
@@ -96,14 +127,120 @@ func (compiled compiledFunction) call(vm *VM, index int64) {
 		code:    compiled.code,
 		pc:      0,
 		curFunc: index,
+		size:    size,
 	}
 
 	rtrn := vm.execCode(compiled)
 
+	// tail calls may have resized the frame.
+	vm.leave(vm.ctx.size)
 	//restore execution context
 	vm.ctx = prevCtxt
 
 	if compiled.returns {
-		vm.pushUint64(rtrn)
+		vm.pushValue(rtrn)
+	}
+}
+
+func (lazyFunction) call(vm *VM, index int64) {
+	compiled, err := vm.compile(index)
+	if err != nil {
+		panic(err)
+	}
+	compiled.call(vm, index)
+}
+
+// compileFunction disassembles and compiles the body of fn, which must not
+// be a host function.
+func compileFunction(fn wasm.Function, module *wasm.Module) (compiledFunction, error) {
+	disassembly, err := disasm.NewDisassembly(fn, module)
+	if err != nil {
+		return compiledFunction{}, err
+	}
+
+	totalLocalVars := 0
+	totalLocalVars += len(fn.Sig.ParamTypes)
+	for _, entry := range fn.Body.Locals {
+		totalLocalVars += int(entry.Count)
+	}
+	code, table, tryBlocks := compile.Compile(disassembly.Code)
+	return compiledFunction{
+		code:           code,
+		branchTables:   table,
+		tryBlocks:      tryBlocks,
+		maxDepth:       disassembly.MaxDepth,
+		totalLocalVars: totalLocalVars,
+		args:           len(fn.Sig.ParamTypes),
+		returns:        len(fn.Sig.ReturnTypes) != 0,
+	}, nil
+}
+
+// compile returns the compiled function at the given index of the function
+// index space, compiling it first if this was deferred by LazyCompile.
+func (vm *VM) compile(index int64) (compiledFunction, error) {
+	switch fn := vm.funcs[index].(type) {
+	case compiledFunction:
+		return fn, nil
+	case lazyFunction:
+		wasmFn := vm.module.FunctionIndexSpace[index]
+		if err := vm.checkLimits(int(index), wasmFn); err != nil {
+			return compiledFunction{}, err
+		}
+		compiled, err := compileFunction(wasmFn, vm.module)
+		if err != nil {
+			return compiledFunction{}, err
+		}
+		vm.funcs[index] = compiled
+		return compiled, nil
+	default:
+		return compiledFunction{}, fmt.Errorf("exec: function at index %d is not a compiled function", index)
+	}
+}
+
+// LimitError is the error of the compilation of a function exceeding one
+// of the limits set by MaxLocals and MaxBodySize.
+type LimitError struct {
+	Function int    // the index of the function in the function index space
+	Limit    string // "locals" or "body size"
+	Value    uint64
+	Max      int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("exec: function %d: %s %d exceeds the limit of %d", e.Function, e.Limit, e.Value, e.Max)
+}
+
+// checkLimits returns a *LimitError if the function fn, at index, exceeds
+// the limits of the VM. It is checked before fn is compiled.
+func (vm *VM) checkLimits(index int, fn wasm.Function) error {
+	if max := vm.cfg.maxBodySize; max > 0 && len(fn.Body.Code) > max {
+		return &LimitError{index, "body size", uint64(len(fn.Body.Code)), max}
+	}
+	locals := uint64(len(fn.Sig.ParamTypes))
+	for _, entry := range fn.Body.Locals {
+		locals += uint64(entry.Count)
+	}
+	if max := vm.cfg.maxLocals; max > 0 && locals > uint64(max) {
+		return &LimitError{index, "locals", locals, max}
+	}
+	return nil
+}
+
+// CompileAll compiles all the functions of the VM's module whose compilation
+// was deferred by LazyCompile, using the number of goroutines set by
+// CompileConcurrency. It returns the error of the first function (in index
+// space order) that failed to compile, so it can be used to report invalid
+// function bodies before running any code.
+// It is a no-op for VMs created without lazy compilation.
+func (vm *VM) CompileAll() error {
+	var pending []int64
+	for i, fn := range vm.funcs {
+		if _, ok := fn.(lazyFunction); ok {
+			pending = append(pending, int64(i))
+		}
 	}
+	return parallel.Run(len(pending), vm.cfg.compileConcurrency, func(i int) error {
+		_, err := vm.compile(pending[i])
+		return err
+	})
 }
@@ -143,6 +143,12 @@ func (vm *VM) newFuncTable() {
 	vm.funcTable[ops.F64ConvertUI64] = vm.f64ConvertUI64
 	vm.funcTable[ops.F64PromoteF32] = vm.f64PromoteF32
 
+	vm.funcTable[ops.I32Extend8S] = vm.i32Extend8S
+	vm.funcTable[ops.I32Extend16S] = vm.i32Extend16S
+	vm.funcTable[ops.I64Extend8S] = vm.i64Extend8S
+	vm.funcTable[ops.I64Extend16S] = vm.i64Extend16S
+	vm.funcTable[ops.I64Extend32S] = vm.i64Extend32S
+
 	vm.funcTable[ops.I32Load] = vm.i32Load
 	vm.funcTable[ops.I64Load] = vm.i64Load
 	vm.funcTable[ops.F32Load] = vm.f32Load
@@ -169,6 +175,37 @@ func (vm *VM) newFuncTable() {
 	vm.funcTable[ops.CurrentMemory] = vm.currentMemory
 	vm.funcTable[ops.GrowMemory] = vm.growMemory
 
+	vm.funcTable[ops.MiscPrefix] = vm.miscOp
+	vm.miscFuncTable[ops.I32TruncSatSF32] = vm.i32TruncSatSF32
+	vm.miscFuncTable[ops.I32TruncSatUF32] = vm.i32TruncSatUF32
+	vm.miscFuncTable[ops.I32TruncSatSF64] = vm.i32TruncSatSF64
+	vm.miscFuncTable[ops.I32TruncSatUF64] = vm.i32TruncSatUF64
+	vm.miscFuncTable[ops.I64TruncSatSF32] = vm.i64TruncSatSF32
+	vm.miscFuncTable[ops.I64TruncSatUF32] = vm.i64TruncSatUF32
+	vm.miscFuncTable[ops.I64TruncSatSF64] = vm.i64TruncSatSF64
+	vm.miscFuncTable[ops.I64TruncSatUF64] = vm.i64TruncSatUF64
+	vm.miscFuncTable[ops.MemoryInit] = vm.memoryInit
+	vm.miscFuncTable[ops.DataDrop] = vm.dataDrop
+	vm.miscFuncTable[ops.MemoryCopy] = vm.memoryCopy
+	vm.miscFuncTable[ops.MemoryFill] = vm.memoryFill
+	vm.miscFuncTable[ops.TableInit] = vm.tableInit
+	vm.miscFuncTable[ops.ElemDrop] = vm.elemDrop
+	vm.miscFuncTable[ops.TableCopy] = vm.tableCopy
+	vm.miscFuncTable[ops.TableGrow] = vm.tableGrow
+	vm.miscFuncTable[ops.TableSize] = vm.tableSize
+	vm.miscFuncTable[ops.TableFill] = vm.tableFill
+	vm.funcTable[ops.SIMDPrefix] = vm.simdOp
+	vm.newSIMDFuncTable()
+	vm.funcTable[ops.AtomicPrefix] = vm.atomicOp
+	vm.newAtomicFuncTable()
+
+	vm.funcTable[ops.TableGet] = vm.tableGet
+	vm.funcTable[ops.TableSet] = vm.tableSet
+
+	vm.funcTable[ops.RefNull] = vm.refNull
+	vm.funcTable[ops.RefIsNull] = vm.refIsNull
+	vm.funcTable[ops.RefFunc] = vm.refFunc
+
 	vm.funcTable[ops.Drop] = vm.drop
 	vm.funcTable[ops.Select] = vm.selectOp
 
@@ -183,4 +220,13 @@ func (vm *VM) newFuncTable() {
 
 	vm.funcTable[ops.Call] = vm.call
 	vm.funcTable[ops.CallIndirect] = vm.callIndirect
+
+	vm.funcTable[ops.Throw] = vm.throw
+	vm.funcTable[ops.Rethrow] = vm.rethrow
+}
+
+// miscOp executes an operator prefixed by ops.MiscPrefix, using the
+// sub-opcode that follows the prefix.
+func (vm *VM) miscOp() {
+	vm.miscFuncTable[vm.fetchUint32()]()
 }
@@ -0,0 +1,187 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/internal/fuzztest"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// FuzzExec runs the valid modules which can't run for long, calling all of
+// their exported functions with zero arguments. The VM must trap rather
+// than panic, and the results, the traps and the final memory must be the
+// same whether the functions are compiled lazily or not.
+func FuzzExec(f *testing.F) {
+	fuzztest.AddSeeds(f, "..")
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		var outcomes [2]string
+		for i, lazy := range []bool{false, true} {
+			m, err := wasm.ReadModuleWithOptions(bytes.NewReader(raw), nil, fuzztest.DecodeOptions)
+			if err != nil || !terminates(m) {
+				return
+			}
+			if err := validate.VerifyModule(m); err != nil {
+				return
+			}
+			outcomes[i] = run(m, exec.LazyCompile(lazy))
+		}
+		if outcomes[0] != outcomes[1] {
+			t.Fatalf("outcome:\n%s\nwith lazy compilation:\n%s", outcomes[0], outcomes[1])
+		}
+	})
+}
+
+// maxCallDepth bounds the number of nested calls of the modules run by
+// FuzzExec, and maxSteps the number of instructions run by each call of an
+// exported function, counting the ones of the functions it calls, so that
+// every input runs in a few milliseconds at most.
+const (
+	maxCallDepth = 8
+	maxSteps     = 1 << 16
+)
+
+// bulkSteps is the number of steps counted for a bulk memory or table
+// operator, which can touch up to the 1MiB of a memory.
+const bulkSteps = 1 << 10
+
+// terminates returns whether the functions of m, which has no imports, can
+// only run for a short time: they have no loops and no tail calls, don't
+// wait on shared memories or grow tables, and a call of any of them runs
+// at most maxSteps instructions.
+func terminates(m *wasm.Module) bool {
+	if m.Import != nil && len(m.Import.Entries) != 0 {
+		return false
+	}
+	if m.Table != nil {
+		for _, table := range m.Table.Entries {
+			if table.Limits.Initial > 1<<16 {
+				return false
+			}
+		}
+	}
+	// the steps of the longest body, and the calls of the body making
+	// the most, bound the steps of any function at each call depth.
+	var bodySteps, calls uint64 = 1, 0
+	for _, fn := range m.FunctionIndexSpace {
+		instrs, err := disasm.Disassemble(fn.Body.Code)
+		if err != nil {
+			// rejected by the validation.
+			return true
+		}
+		var steps, n uint64
+		for _, instr := range instrs {
+			op := instr.Op
+			switch {
+			case op.Code == ops.Loop, op.Code == ops.ReturnCall, op.Code == ops.ReturnCallIndirect:
+				return false
+			case op.Code == ops.Call, op.Code == ops.CallIndirect:
+				n++
+			case op.Code == ops.MiscPrefix && op.Sub == ops.TableGrow:
+				return false
+			case op.Code == ops.AtomicPrefix && (op.Sub == ops.MemoryAtomicWait32 || op.Sub == ops.MemoryAtomicWait64):
+				return false
+			case op.Code == ops.MiscPrefix && isBulk(op.Sub):
+				steps += bulkSteps - 1
+			}
+			steps++
+		}
+		if steps > bodySteps {
+			bodySteps = steps
+		}
+		if n > calls {
+			calls = n
+		}
+	}
+	total, width := uint64(0), uint64(1)
+	for depth := 0; depth < maxCallDepth && width != 0; depth++ {
+		if width > maxSteps {
+			return false
+		}
+		if total += width * bodySteps; total > maxSteps {
+			return false
+		}
+		width *= calls
+	}
+	return true
+}
+
+// isBulk returns whether the operator prefixed by MiscPrefix with the
+// sub-opcode sub is a bulk memory or table operator.
+func isBulk(sub uint32) bool {
+	switch sub {
+	case ops.MemoryInit, ops.MemoryCopy, ops.MemoryFill, ops.TableInit, ops.TableCopy, ops.TableFill:
+		return true
+	}
+	return false
+}
+
+// run instantiates m, and calls its exported functions in the order of
+// their names. It returns the results of the calls, and a hash of the final
+// memory.
+func run(m *wasm.Module, opts ...exec.VMOption) string {
+	out := new(bytes.Buffer)
+	var vm *exec.VM
+	err := trap(func() error {
+		var err error
+		opts = append(opts, exec.MaxCallDepth(maxCallDepth), exec.MaxMemoryPages(16))
+		vm, err = exec.NewVM(m, opts...)
+		return err
+	})
+	if err != nil {
+		return fmt.Sprintf("instantiation: %v", err)
+	}
+
+	var names []string
+	if m.Export != nil {
+		for name, entry := range m.Export.Entries {
+			if entry.Kind == wasm.ExternalFunction {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		index := int64(m.Export.Entries[name].Index)
+		var args []uint64
+		if fn := m.GetFunction(int(index)); fn != nil {
+			args = make([]uint64, len(fn.Sig.ParamTypes))
+		}
+		var res interface{}
+		err := trap(func() error {
+			var err error
+			res, err = vm.ExecCode(index, args...)
+			return err
+		})
+		fmt.Fprintf(out, "%s: %#v, %v\n", name, floatBits(res), err)
+	}
+	fmt.Fprintf(out, "memory: %x\n", sha256.Sum256(vm.Memory()))
+	return out.String()
+}
+
+// trap calls fn, and returns the error of the trap it panicked with, if
+// any. Other panics are bugs of the VM, and are propagated.
+func trap(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if re, isRuntime := e.(runtime.Error); !ok || isRuntime && re.Error() != "runtime error: integer divide by zero" {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return fn()
+}
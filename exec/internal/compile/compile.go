@@ -8,30 +8,34 @@
 // The conversion process consists of translating block instruction sequences
 // and branch operators (br, br_if, br_table) to absolute jumps to PC values.
 // For instance, an instruction sequence like:
-//     loop
-//       i32.const 1
-//       get_local 0
-//       i32.add
-//       set_local 0
-//       get_local 1
-//       i32.const 1
-//       i32.add
-//       tee_local 1
-//       get_local 2
-//       i32.eq
-//       br_if 0
-//     end
+//
+//	loop
+//	  i32.const 1
+//	  get_local 0
+//	  i32.add
+//	  set_local 0
+//	  get_local 1
+//	  i32.const 1
+//	  i32.add
+//	  tee_local 1
+//	  get_local 2
+//	  i32.eq
+//	  br_if 0
+//	end
+//
 // Is "compiled" to:
-//     i32.const 1
-//     i32.add
-//     set_local 0
-//     get_local 1
-//     i32.const 1
-//     i32.add
-//     tee_local 1
-//     get_local 2
-//     i32.eq
-//     jmpnz <addr> <preserve> <discard>
+//
+//	i32.const 1
+//	i32.add
+//	set_local 0
+//	get_local 1
+//	i32.const 1
+//	i32.add
+//	tee_local 1
+//	get_local 2
+//	i32.eq
+//	jmpnz <addr> <preserve> <discard>
+//
 // Where jmpnz is a jump-if-not-zero operator that takes certain arguments
 // plus the jump address as immediates.
 // This is in contrast with original WebAssembly bytecode, where the target
@@ -92,7 +96,9 @@ type Target struct {
 
 // BranchTable is the structure pointed to by a rewritten br_table instruction.
 // A rewritten br_table instruction is of the format:
-//     br_table <table_index>
+//
+//	br_table <table_index>
+//
 // where <table_index> is the index to an array of
 // BranchTable objects stored by the VM.
 type BranchTable struct {
@@ -102,6 +108,29 @@ type BranchTable struct {
 	blocksLen     int      // The length of the blocks map in Compile when this table was initialized
 }
 
+// TryBlock describes a try block of the compiled code, and how the exceptions
+// thrown while executing its body are handled. The exceptions thrown by an
+// operator at pc are handled by the innermost try block for which
+// Start < pc <= BodyEnd, as pc points to the next operator by then.
+type TryBlock struct {
+	Start       int64   // The address of the first operator of the body
+	BodyEnd     int64   // The address following the last operator of the body
+	StackHeight int64   // The height of the stack when the try block starts
+	Catches     []Catch // The catch clauses of the block, in order
+	// The index of the try block handling the exceptions that no catch
+	// clause of this block handles, or -1 to let the function throw
+	// them to its caller. For try...delegate blocks, this is the try
+	// block the exceptions are delegated to.
+	Outer int
+}
+
+// Catch is a catch (or catch_all) clause of a try block.
+type Catch struct {
+	Tag  uint32 // The index of the tag of the exceptions caught by the clause
+	All  bool   // Whether this is a catch_all clause, catching any exception
+	Addr int64  // The address of the first operator of the clause
+}
+
 // block stores the information relevant for a block created by a control operator
 // sequence (if...else...end, loop...end, and block...end)
 type block struct {
@@ -129,35 +158,67 @@ type block struct {
 
 	discard      disasm.StackInfo // Information about the stack created in this block, used while creating Discard instructions
 	branchTables []*BranchTable   // All branch tables that were defined in this block.
+
+	// The index of the try block created by a 'try' operator, or -1.
+	tryIndex int
+	// The index of the innermost try block whose body contains the
+	// operators of this block, or -1.
+	handler int
 }
 
-// Compile rewrites WebAssembly bytecode from its disassembly.
+// Compile rewrites WebAssembly bytecode from its disassembly. It also returns
+// the branch tables used by the rewritten br_table operators, and the try
+// blocks of the code, in the order in which they start.
 // TODO(vibhavp): Add options for optimizing code. Operators like i32.reinterpret/f32
 // are no-ops, and can be safely removed.
-func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
+func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable, []TryBlock) {
 	buffer := new(bytes.Buffer)
 	branchTables := []*BranchTable{}
+	var tryBlocks []TryBlock
 
 	curBlockDepth := -1
 	blocks := make(map[int]*block) // maps nesting depths (labels) to blocks
 
-	blocks[-1] = &block{}
+	blocks[-1] = &block{tryIndex: -1, handler: -1}
 	for _, instr := range disassembly {
 		if instr.Unreachable {
 			continue
 		}
 		switch instr.Op.Code {
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
-			// memory_immediate has two fields, the alignment and the offset.
-			// The former is simply an optimization hint and can be safely
-			// discarded.
-			instr.Immediates = []interface{}{instr.Immediates[1].(uint32)}
+			// memory_immediate has three fields, the alignment, the offset
+			// and the memory index. The former is simply an optimization
+			// hint and can be safely discarded.
+			instr.Immediates = instr.Immediates[1:]
+		case ops.SIMDPrefix:
+			// same as above, for the SIMD operators accessing the memory.
+			if disasm.IsSIMDMemoryOp(instr.Op.Sub) {
+				instr.Immediates = instr.Immediates[1:]
+			}
+		case ops.AtomicPrefix:
+			// same as above for the atomic operators, the reserved byte
+			// of atomic.fence is discarded as well.
+			instr.Immediates = instr.Immediates[1:]
+			if instr.Op.Sub == ops.AtomicFence {
+				instr.Immediates = nil
+			}
+		case ops.TypedSelect:
+			// the result type is only needed for validation, the typed
+			// select operator behaves as select.
+			instr.Op, _ = ops.New(ops.Select)
+			instr.Immediates = nil
+		case ops.RefNull:
+			// the type of the null reference doesn't matter either, all
+			// null references are 0.
+			instr.Immediates = nil
 		case ops.If:
 			curBlockDepth++
 			buffer.WriteByte(OpJmpZ)
 			blocks[curBlockDepth] = &block{
 				ifBlock:        true,
 				elseAddrOffset: int64(buffer.Len()),
+				tryIndex:       -1,
+				handler:        blocks[curBlockDepth-1].handler,
 			}
 			// the address to jump to if the condition for `if` is false
 			// (i.e when the value on the top of the stack is 0)
@@ -171,15 +232,65 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 				ifBlock:   false,
 				loopBlock: true,
 				discard:   *instr.NewStack,
+				tryIndex:  -1,
+				handler:   blocks[curBlockDepth-1].handler,
 			}
 			continue
 		case ops.Block:
 			curBlockDepth++
 			blocks[curBlockDepth] = &block{
-				ifBlock: false,
-				discard: *instr.NewStack,
+				ifBlock:  false,
+				discard:  *instr.NewStack,
+				tryIndex: -1,
+				handler:  blocks[curBlockDepth-1].handler,
 			}
 			continue
+		case ops.Try:
+			// try blocks are compiled as blocks, whose catch clauses
+			// are only reached through the try block table.
+			curBlockDepth++
+			index := len(tryBlocks)
+			tryBlocks = append(tryBlocks, TryBlock{
+				Start:       int64(buffer.Len()),
+				BodyEnd:     -1,
+				StackHeight: int64(instr.Block.StackHeight),
+				Outer:       blocks[curBlockDepth-1].handler,
+			})
+			blocks[curBlockDepth] = &block{
+				discard:  *instr.NewStack,
+				tryIndex: index,
+				handler:  index,
+			}
+			continue
+		case ops.Catch, ops.CatchAll:
+			block := blocks[curBlockDepth]
+			try := &tryBlocks[block.tryIndex]
+			if try.BodyEnd < 0 {
+				try.BodyEnd = int64(buffer.Len())
+			}
+			// the body, or the previous catch clause, jumps to the end
+			// of the try block, as the if branch of if...else.
+			if instr.NewStack != nil && instr.NewStack.StackTopDiff != 0 {
+				if instr.NewStack.PreserveTop {
+					buffer.WriteByte(OpDiscardPreserveTop)
+				} else {
+					buffer.WriteByte(OpDiscard)
+				}
+				binary.Write(buffer, binary.LittleEndian, instr.NewStack.StackTopDiff)
+			}
+			buffer.WriteByte(OpJmp)
+			block.patchOffsets = append(block.patchOffsets, int64(buffer.Len()))
+			binary.Write(buffer, binary.LittleEndian, int64(0))
+
+			catch := Catch{All: instr.Op.Code == ops.CatchAll, Addr: int64(buffer.Len())}
+			if !catch.All {
+				catch.Tag = instr.Immediates[0].(uint32)
+			}
+			try.Catches = append(try.Catches, catch)
+			// the exceptions thrown by a catch clause are handled by the
+			// enclosing try blocks.
+			block.handler = try.Outer
+			continue
 		case ops.Else:
 			ifInstr := disassembly[instr.Block.ElseIfIndex] // the corresponding `if` instruction for this else
 			if ifInstr.NewStack != nil && ifInstr.NewStack.StackTopDiff != 0 {
@@ -204,10 +315,23 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 			ifBlock.ifBlock = false
 			ifBlock.patchOffsets = append(ifBlock.patchOffsets, ifBlockEndOffset)
 			continue
-		case ops.End:
+		case ops.End, ops.Delegate:
 			depth := curBlockDepth
 			block := blocks[depth]
 
+			if block.tryIndex >= 0 {
+				try := &tryBlocks[block.tryIndex]
+				if try.BodyEnd < 0 {
+					try.BodyEnd = int64(buffer.Len())
+				}
+				if instr.Op.Code == ops.Delegate {
+					// the label is relative to the block enclosing
+					// the try block.
+					label := int(instr.Immediates[0].(uint32))
+					try.Outer = blocks[depth-1-label].handler
+				}
+			}
+
 			if instr.NewStack.StackTopDiff != 0 {
 				// when exiting a block, discard elements to
 				// restore stack height.
@@ -277,6 +401,15 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 			// write the number of elements on the stack we need to discard
 			binary.Write(buffer, binary.LittleEndian, stackTopDiff)
 			continue
+		case ops.Rethrow:
+			// rethrow is rewritten as
+			//     rethrow <try_index>
+			// where <try_index> is the index of the try block whose
+			// catch clause caught the exception to rethrow.
+			label := int(instr.Immediates[0].(uint32))
+			buffer.WriteByte(ops.Rethrow)
+			binary.Write(buffer, binary.LittleEndian, uint32(blocks[curBlockDepth-label].tryIndex))
+			continue
 		case ops.BrTable:
 			branchTable := &BranchTable{
 				// we subtract one for the implicit block created by
@@ -311,6 +444,12 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 		}
 
 		buffer.WriteByte(instr.Op.Code)
+		if instr.Op.IsPrefixed() {
+			// prefixed operators are rewritten as
+			//     <prefix> <sub-opcode> <immediates>
+			// where the sub-opcode is a 4 byte value.
+			binary.Write(buffer, binary.LittleEndian, instr.Op.Sub)
+		}
 		for _, imm := range instr.Immediates {
 			err := binary.Write(buffer, binary.LittleEndian, imm)
 			if err != nil {
@@ -333,7 +472,7 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 	for _, table := range branchTables {
 		table.patchedAddrs = nil
 	}
-	return buffer.Bytes(), branchTables
+	return buffer.Bytes(), branchTables, tryBlocks
 }
 
 // replace the address starting at start with addr
@@ -0,0 +1,726 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// interp is a reference interpreter, running the structured code of the
+// functions of a module as described by the specification, with values
+// held as uint64. It only implements the instructions and the sections
+// generated by the gen package, and is meant to be simple rather than
+// fast.
+//
+// Like a deterministic VM, it canonicalizes the NaNs produced by the float
+// operators, and it traps by panicking with the errors of the VM.
+type interp struct {
+	types    []wasm.FunctionSig
+	funcs    []interpFunc
+	globals  []uint64
+	table    []int // the index of each function of the table plus one, 0 for null
+	memory   []byte
+	maxPages uint64
+	data     [][]byte // the passive data segments, nil once dropped
+}
+
+type interpFunc struct {
+	sig    *wasm.FunctionSig
+	locals int // the number of parameters and local variables
+	code   []disasm.Instr
+	// ends maps the index of each block, loop, if and else instruction to
+	// the index of the matching end, and elses maps the index of each if
+	// instruction with an else to the index of the else.
+	ends  map[int]int
+	elses map[int]int
+}
+
+// newInterp instantiates m, whose memory can't grow beyond maxPages.
+func newInterp(m *wasm.Module, maxPages uint32) (*interp, error) {
+	in := &interp{}
+	if m.Types != nil {
+		in.types = m.Types.Entries
+	}
+	for i, fn := range m.FunctionIndexSpace {
+		code, err := disasm.Disassemble(fn.Body.Code)
+		if err != nil {
+			return nil, err
+		}
+		f := interpFunc{
+			sig:    fn.Sig,
+			locals: len(fn.Sig.ParamTypes),
+			code:   code,
+			ends:   make(map[int]int),
+			elses:  make(map[int]int),
+		}
+		for _, entry := range fn.Body.Locals {
+			f.locals += int(entry.Count)
+		}
+		var starts []int
+		for pc, instr := range code {
+			switch instr.Op.Code {
+			case ops.Block, ops.Loop, ops.If:
+				starts = append(starts, pc)
+			case ops.Else:
+				f.elses[starts[len(starts)-1]] = pc
+			case ops.End:
+				start := starts[len(starts)-1]
+				starts = starts[:len(starts)-1]
+				f.ends[start] = pc
+				if e, ok := f.elses[start]; ok {
+					f.ends[e] = pc
+				}
+			}
+		}
+		if len(starts) != 0 {
+			return nil, fmt.Errorf("function %d: unterminated block", i)
+		}
+		in.funcs = append(in.funcs, f)
+	}
+
+	for _, global := range m.GlobalIndexSpace {
+		v, err := m.ExecInitExpr(global.Init)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case int32:
+			in.globals = append(in.globals, uint64(uint32(v)))
+		case int64:
+			in.globals = append(in.globals, uint64(v))
+		case float32:
+			in.globals = append(in.globals, uint64(math.Float32bits(v)))
+		case float64:
+			in.globals = append(in.globals, math.Float64bits(v))
+		default:
+			return nil, fmt.Errorf("global of type %T", v)
+		}
+	}
+
+	if m.Table != nil && len(m.Table.Entries) != 0 {
+		in.table = make([]int, m.Table.Entries[0].Limits.Initial)
+	}
+	if m.Elements != nil {
+		for _, seg := range m.Elements.Entries {
+			if seg.Mode != wasm.SegmentActive || seg.Exprs != nil {
+				return nil, fmt.Errorf("unsupported element segment")
+			}
+			v, err := m.ExecInitExpr(seg.Offset)
+			if err != nil {
+				return nil, err
+			}
+			offset, ok := v.(int32)
+			if !ok || uint64(uint32(offset))+uint64(len(seg.Elems)) > uint64(len(in.table)) {
+				return nil, fmt.Errorf("element segment out of bounds")
+			}
+			for i, fn := range seg.Elems {
+				in.table[int(uint32(offset))+i] = int(fn) + 1
+			}
+		}
+	}
+
+	if m.Memory != nil && len(m.Memory.Entries) != 0 {
+		limits := m.Memory.Entries[0].Limits
+		in.memory = make([]byte, uint64(limits.Initial)*pageSize)
+		in.maxPages = uint64(maxPages)
+		if limits.Flags&1 != 0 && uint64(limits.Maximum) < in.maxPages {
+			in.maxPages = uint64(limits.Maximum)
+		}
+	}
+	if m.Data != nil {
+		for _, seg := range m.Data.Entries {
+			// the active segments behave as dropped ones once they
+			// are copied.
+			if seg.Mode != wasm.SegmentActive {
+				in.data = append(in.data, seg.Data)
+				continue
+			}
+			in.data = append(in.data, nil)
+			v, err := m.ExecInitExpr(seg.Offset)
+			if err != nil {
+				return nil, err
+			}
+			offset, ok := v.(int32)
+			if !ok || uint64(uint32(offset))+uint64(len(seg.Data)) > uint64(len(in.memory)) {
+				return nil, fmt.Errorf("data segment out of bounds")
+			}
+			copy(in.memory[uint32(offset):], seg.Data)
+		}
+	}
+	return in, nil
+}
+
+const pageSize = 65536
+
+// label is an entry of the stack of the labels of the enclosing blocks.
+type label struct {
+	arity  int  // the number of values carried by a branch
+	height int  // the height of the value stack when entering the block
+	cont   int  // the continuation of a branch
+	loop   bool // whether a branch continues at the start of the block
+}
+
+// call calls the function at the given index, and returns its results.
+func (in *interp) call(index int, args []uint64) []uint64 {
+	f := &in.funcs[index]
+	locals := make([]uint64, f.locals)
+	copy(locals, args)
+
+	var stack []uint64
+	push := func(v uint64) { stack = append(stack, v) }
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	labels := []label{{arity: len(f.sig.ReturnTypes), cont: len(f.code)}}
+	// branch unwinds the stacks for a branch to the label at the given
+	// depth, and returns the continuation.
+	branch := func(depth int) int {
+		l := labels[len(labels)-1-depth]
+		stack = append(stack[:l.height], stack[len(stack)-l.arity:]...)
+		if l.loop {
+			labels = labels[:len(labels)-depth]
+		} else {
+			labels = labels[:len(labels)-1-depth]
+		}
+		return l.cont
+	}
+	arity := func(instr disasm.Instr) int {
+		if instr.Immediates[0].(wasm.BlockType) == wasm.BlockTypeEmpty {
+			return 0
+		}
+		return 1
+	}
+
+	for pc := 0; pc < len(f.code); {
+		instr := f.code[pc]
+		op := instr.Op
+		start := pc
+		pc++
+		switch op.Code {
+		case ops.Nop:
+		case ops.Unreachable:
+			panic(exec.ErrUnreachable)
+		case ops.Block:
+			labels = append(labels, label{arity: arity(instr), height: len(stack), cont: f.ends[start] + 1})
+		case ops.Loop:
+			labels = append(labels, label{height: len(stack), cont: pc, loop: true})
+		case ops.If:
+			cond := pop()
+			l := label{arity: arity(instr), height: len(stack), cont: f.ends[start] + 1}
+			e, hasElse := f.elses[start]
+			switch {
+			case cond != 0:
+				labels = append(labels, l)
+			case hasElse:
+				labels = append(labels, l)
+				pc = e + 1
+			default:
+				pc = l.cont
+			}
+		case ops.Else:
+			// the end of the then branch.
+			labels = labels[:len(labels)-1]
+			pc = f.ends[start] + 1
+		case ops.End:
+			labels = labels[:len(labels)-1]
+		case ops.Br:
+			pc = branch(int(instr.Immediates[0].(uint32)))
+		case ops.BrIf:
+			if pop() != 0 {
+				pc = branch(int(instr.Immediates[0].(uint32)))
+			}
+		case ops.BrTable:
+			n := instr.Immediates[0].(uint32)
+			i := uint32(pop())
+			if i > n {
+				i = n
+			}
+			pc = branch(int(instr.Immediates[1+i].(uint32)))
+		case ops.Return:
+			pc = branch(len(labels) - 1)
+		case ops.Call:
+			callee := &in.funcs[instr.Immediates[0].(uint32)]
+			n := len(callee.sig.ParamTypes)
+			args := append([]uint64(nil), stack[len(stack)-n:]...)
+			stack = stack[:len(stack)-n]
+			stack = append(stack, in.call(int(instr.Immediates[0].(uint32)), args)...)
+		case ops.CallIndirect:
+			i := uint64(uint32(pop()))
+			if i >= uint64(len(in.table)) || in.table[i] == 0 {
+				panic(exec.ErrUndefinedElementIndex)
+			}
+			index := in.table[i] - 1
+			callee := &in.funcs[index]
+			if !sameSig(callee.sig, &in.types[instr.Immediates[0].(uint32)]) {
+				panic(exec.ErrSignatureMismatch)
+			}
+			n := len(callee.sig.ParamTypes)
+			args := append([]uint64(nil), stack[len(stack)-n:]...)
+			stack = stack[:len(stack)-n]
+			stack = append(stack, in.call(index, args)...)
+
+		case ops.Drop:
+			pop()
+		case ops.Select:
+			cond, v2, v1 := pop(), pop(), pop()
+			if cond != 0 {
+				push(v1)
+			} else {
+				push(v2)
+			}
+
+		case ops.GetLocal:
+			push(locals[instr.Immediates[0].(uint32)])
+		case ops.SetLocal:
+			locals[instr.Immediates[0].(uint32)] = pop()
+		case ops.TeeLocal:
+			locals[instr.Immediates[0].(uint32)] = stack[len(stack)-1]
+		case ops.GetGlobal:
+			push(in.globals[instr.Immediates[0].(uint32)])
+		case ops.SetGlobal:
+			in.globals[instr.Immediates[0].(uint32)] = pop()
+
+		case ops.I32Const:
+			push(uint64(uint32(instr.Immediates[0].(int32))))
+		case ops.I64Const:
+			push(uint64(instr.Immediates[0].(int64)))
+		case ops.F32Const:
+			push(uint64(math.Float32bits(instr.Immediates[0].(float32))))
+		case ops.F64Const:
+			push(math.Float64bits(instr.Immediates[0].(float64)))
+
+		case ops.CurrentMemory:
+			push(uint64(len(in.memory) / pageSize))
+		case ops.GrowMemory:
+			n := uint64(uint32(pop()))
+			pages := uint64(len(in.memory) / pageSize)
+			if pages+n > in.maxPages {
+				push(uint64(math.MaxUint32))
+			} else {
+				in.memory = append(in.memory, make([]byte, n*pageSize)...)
+				push(pages)
+			}
+
+		case ops.MiscPrefix:
+			switch op.Sub {
+			case ops.MemoryInit:
+				n, src, dst := uint64(uint32(pop())), uint64(uint32(pop())), uint64(uint32(pop()))
+				data := in.data[instr.Immediates[0].(uint32)]
+				if src+n > uint64(len(data)) || dst+n > uint64(len(in.memory)) {
+					panic(exec.ErrOutOfBoundsMemoryAccess)
+				}
+				copy(in.memory[dst:dst+n], data[src:])
+			case ops.DataDrop:
+				in.data[instr.Immediates[0].(uint32)] = nil
+			case ops.MemoryCopy:
+				n, src, dst := uint64(uint32(pop())), uint64(uint32(pop())), uint64(uint32(pop()))
+				if src+n > uint64(len(in.memory)) || dst+n > uint64(len(in.memory)) {
+					panic(exec.ErrOutOfBoundsMemoryAccess)
+				}
+				copy(in.memory[dst:dst+n], in.memory[src:src+n])
+			case ops.MemoryFill:
+				n, v, dst := uint64(uint32(pop())), byte(pop()), uint64(uint32(pop()))
+				if dst+n > uint64(len(in.memory)) {
+					panic(exec.ErrOutOfBoundsMemoryAccess)
+				}
+				for i := dst; i < dst+n; i++ {
+					in.memory[i] = v
+				}
+			default:
+				conv, ok := satConversions[op.Sub]
+				if !ok {
+					panic(fmt.Sprintf("interp: unsupported operator %s", op.Name))
+				}
+				push(conv(pop()))
+			}
+
+		default:
+			if load, ok := loads[op.Code]; ok {
+				b := in.access(pop(), instr, load.size)
+				push(load.fn(b))
+				break
+			}
+			if store, ok := stores[op.Code]; ok {
+				v := pop()
+				store.fn(in.access(pop(), instr, store.size), v)
+				break
+			}
+			if fn, ok := unops[op.Code]; ok {
+				push(fn(pop()))
+				break
+			}
+			if fn, ok := binops[op.Code]; ok {
+				v2 := pop()
+				push(fn(pop(), v2))
+				break
+			}
+			panic(fmt.Sprintf("interp: unsupported operator %s", op.Name))
+		}
+	}
+	return stack[len(stack)-len(f.sig.ReturnTypes):]
+}
+
+// sameSig returns whether the function types a and b are the same.
+func sameSig(a, b *wasm.FunctionSig) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) || len(a.ReturnTypes) != len(b.ReturnTypes) {
+		return false
+	}
+	for i, t := range a.ParamTypes {
+		if b.ParamTypes[i] != t {
+			return false
+		}
+	}
+	for i, t := range a.ReturnTypes {
+		if b.ReturnTypes[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// access returns the bytes of the memory accessed by the load or store
+// instruction at the given address.
+func (in *interp) access(addr uint64, instr disasm.Instr, size uint64) []byte {
+	ea := uint64(uint32(addr)) + instr.Immediates[1].(uint64)
+	if ea+size > uint64(len(in.memory)) {
+		panic(exec.ErrOutOfBoundsMemoryAccess)
+	}
+	return in.memory[ea : ea+size]
+}
+
+var le = binary.LittleEndian
+
+var loads = map[byte]struct {
+	size uint64
+	fn   func(b []byte) uint64
+}{
+	ops.I32Load:    {4, func(b []byte) uint64 { return uint64(le.Uint32(b)) }},
+	ops.I64Load:    {8, func(b []byte) uint64 { return le.Uint64(b) }},
+	ops.F32Load:    {4, func(b []byte) uint64 { return uint64(le.Uint32(b)) }},
+	ops.F64Load:    {8, func(b []byte) uint64 { return le.Uint64(b) }},
+	ops.I32Load8s:  {1, func(b []byte) uint64 { return uint64(uint32(int8(b[0]))) }},
+	ops.I32Load8u:  {1, func(b []byte) uint64 { return uint64(b[0]) }},
+	ops.I32Load16s: {2, func(b []byte) uint64 { return uint64(uint32(int16(le.Uint16(b)))) }},
+	ops.I32Load16u: {2, func(b []byte) uint64 { return uint64(le.Uint16(b)) }},
+	ops.I64Load8s:  {1, func(b []byte) uint64 { return uint64(int8(b[0])) }},
+	ops.I64Load8u:  {1, func(b []byte) uint64 { return uint64(b[0]) }},
+	ops.I64Load16s: {2, func(b []byte) uint64 { return uint64(int16(le.Uint16(b))) }},
+	ops.I64Load16u: {2, func(b []byte) uint64 { return uint64(le.Uint16(b)) }},
+	ops.I64Load32s: {4, func(b []byte) uint64 { return uint64(int32(le.Uint32(b))) }},
+	ops.I64Load32u: {4, func(b []byte) uint64 { return uint64(le.Uint32(b)) }},
+}
+
+var stores = map[byte]struct {
+	size uint64
+	fn   func(b []byte, v uint64)
+}{
+	ops.I32Store:   {4, func(b []byte, v uint64) { le.PutUint32(b, uint32(v)) }},
+	ops.I64Store:   {8, func(b []byte, v uint64) { le.PutUint64(b, v) }},
+	ops.F32Store:   {4, func(b []byte, v uint64) { le.PutUint32(b, uint32(v)) }},
+	ops.F64Store:   {8, func(b []byte, v uint64) { le.PutUint64(b, v) }},
+	ops.I32Store8:  {1, func(b []byte, v uint64) { b[0] = byte(v) }},
+	ops.I32Store16: {2, func(b []byte, v uint64) { le.PutUint16(b, uint16(v)) }},
+	ops.I64Store8:  {1, func(b []byte, v uint64) { b[0] = byte(v) }},
+	ops.I64Store16: {2, func(b []byte, v uint64) { le.PutUint16(b, uint16(v)) }},
+	ops.I64Store32: {4, func(b []byte, v uint64) { le.PutUint32(b, uint32(v)) }},
+}
+
+// Conversions between the bits of values and Go values.
+
+func f32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+func f64(v uint64) float64 { return math.Float64frombits(v) }
+
+func bool64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fromF32 and fromF64 return the bits of f, with a NaN replaced by the
+// canonical one. They are used for the results of the operators whose NaN
+// results are canonicalized by deterministic VMs.
+func fromF32(f float32) uint64 {
+	if f != f {
+		return 0x7fc00000
+	}
+	return uint64(math.Float32bits(f))
+}
+
+func fromF64(f float64) uint64 {
+	if f != f {
+		return 0x7ff8000000000000
+	}
+	return math.Float64bits(f)
+}
+
+func fmin(a, b float64) float64 {
+	switch {
+	case a != a || b != b:
+		return math.NaN()
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+func fmax(a, b float64) float64 {
+	switch {
+	case a != a || b != b:
+		return math.NaN()
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return b
+		}
+		return a
+	case a > b:
+		return a
+	}
+	return b
+}
+
+// nearest rounds f to the nearest integer, and ties to the even one.
+func nearest(f float64) float64 {
+	if f != f || math.IsInf(f, 0) || f == 0 {
+		return f
+	}
+	t := math.Trunc(f)
+	switch d := math.Abs(f - t); {
+	case d > 0.5, d == 0.5 && math.Mod(t, 2) != 0:
+		t += math.Copysign(1, f)
+	}
+	if t == 0 {
+		// keep the sign of f, as in -0.4 rounded to -0.
+		return math.Copysign(0, f)
+	}
+	return t
+}
+
+// trunc truncates f, and traps unless the result is in [min, max).
+func trunc(f, min, max float64) float64 {
+	if f != f {
+		panic(exec.ErrInvalidConversion)
+	}
+	t := math.Trunc(f)
+	if t < min || t >= max {
+		panic(exec.ErrIntegerOverflow)
+	}
+	return t
+}
+
+// sat truncates f, and saturates the result to [min, max].
+func sat(f, min, max float64) float64 {
+	switch {
+	case f != f:
+		return 0
+	case f <= min:
+		return min
+	case f >= max:
+		return max
+	}
+	return math.Trunc(f)
+}
+
+var unops = map[byte]func(v uint64) uint64{
+	ops.I32Eqz:    func(v uint64) uint64 { return bool64(uint32(v) == 0) },
+	ops.I64Eqz:    func(v uint64) uint64 { return bool64(v == 0) },
+	ops.I32Clz:    func(v uint64) uint64 { return uint64(bits.LeadingZeros32(uint32(v))) },
+	ops.I32Ctz:    func(v uint64) uint64 { return uint64(bits.TrailingZeros32(uint32(v))) },
+	ops.I32Popcnt: func(v uint64) uint64 { return uint64(bits.OnesCount32(uint32(v))) },
+	ops.I64Clz:    func(v uint64) uint64 { return uint64(bits.LeadingZeros64(v)) },
+	ops.I64Ctz:    func(v uint64) uint64 { return uint64(bits.TrailingZeros64(v)) },
+	ops.I64Popcnt: func(v uint64) uint64 { return uint64(bits.OnesCount64(v)) },
+
+	ops.F32Abs:     func(v uint64) uint64 { return v &^ (1 << 31) },
+	ops.F32Neg:     func(v uint64) uint64 { return v ^ (1 << 31) },
+	ops.F32Ceil:    func(v uint64) uint64 { return fromF32(float32(math.Ceil(float64(f32(v))))) },
+	ops.F32Floor:   func(v uint64) uint64 { return fromF32(float32(math.Floor(float64(f32(v))))) },
+	ops.F32Trunc:   func(v uint64) uint64 { return fromF32(float32(math.Trunc(float64(f32(v))))) },
+	ops.F32Nearest: func(v uint64) uint64 { return fromF32(float32(nearest(float64(f32(v))))) },
+	ops.F32Sqrt:    func(v uint64) uint64 { return fromF32(float32(math.Sqrt(float64(f32(v))))) },
+	ops.F64Abs:     func(v uint64) uint64 { return v &^ (1 << 63) },
+	ops.F64Neg:     func(v uint64) uint64 { return v ^ (1 << 63) },
+	ops.F64Ceil:    func(v uint64) uint64 { return fromF64(math.Ceil(f64(v))) },
+	ops.F64Floor:   func(v uint64) uint64 { return fromF64(math.Floor(f64(v))) },
+	ops.F64Trunc:   func(v uint64) uint64 { return fromF64(math.Trunc(f64(v))) },
+	ops.F64Nearest: func(v uint64) uint64 { return fromF64(nearest(f64(v))) },
+	ops.F64Sqrt:    func(v uint64) uint64 { return fromF64(math.Sqrt(f64(v))) },
+
+	ops.I32WrapI64:     func(v uint64) uint64 { return uint64(uint32(v)) },
+	ops.I32TruncSF32:   func(v uint64) uint64 { return uint64(uint32(int32(trunc(float64(f32(v)), math.MinInt32, 1<<31)))) },
+	ops.I32TruncUF32:   func(v uint64) uint64 { return uint64(uint32(trunc(float64(f32(v)), 0, 1<<32))) },
+	ops.I32TruncSF64:   func(v uint64) uint64 { return uint64(uint32(int32(trunc(f64(v), math.MinInt32, 1<<31)))) },
+	ops.I32TruncUF64:   func(v uint64) uint64 { return uint64(uint32(trunc(f64(v), 0, 1<<32))) },
+	ops.I64ExtendSI32:  func(v uint64) uint64 { return uint64(int32(v)) },
+	ops.I64ExtendUI32:  func(v uint64) uint64 { return uint64(uint32(v)) },
+	ops.I64TruncSF32:   func(v uint64) uint64 { return uint64(int64(trunc(float64(f32(v)), math.MinInt64, 1<<63))) },
+	ops.I64TruncUF32:   func(v uint64) uint64 { return uint64(trunc(float64(f32(v)), 0, 1<<64)) },
+	ops.I64TruncSF64:   func(v uint64) uint64 { return uint64(int64(trunc(f64(v), math.MinInt64, 1<<63))) },
+	ops.I64TruncUF64:   func(v uint64) uint64 { return uint64(trunc(f64(v), 0, 1<<64)) },
+	ops.F32ConvertSI32: func(v uint64) uint64 { return uint64(math.Float32bits(float32(int32(v)))) },
+	ops.F32ConvertUI32: func(v uint64) uint64 { return uint64(math.Float32bits(float32(uint32(v)))) },
+	ops.F32ConvertSI64: func(v uint64) uint64 { return uint64(math.Float32bits(float32(int64(v)))) },
+	ops.F32ConvertUI64: func(v uint64) uint64 { return uint64(math.Float32bits(float32(v))) },
+	ops.F32DemoteF64:   func(v uint64) uint64 { return fromF32(float32(f64(v))) },
+	ops.F64ConvertSI32: func(v uint64) uint64 { return math.Float64bits(float64(int32(v))) },
+	ops.F64ConvertUI32: func(v uint64) uint64 { return math.Float64bits(float64(uint32(v))) },
+	ops.F64ConvertSI64: func(v uint64) uint64 { return math.Float64bits(float64(int64(v))) },
+	ops.F64ConvertUI64: func(v uint64) uint64 { return math.Float64bits(float64(v)) },
+	ops.F64PromoteF32:  func(v uint64) uint64 { return fromF64(float64(f32(v))) },
+
+	ops.I32ReinterpretF32: func(v uint64) uint64 { return v },
+	ops.I64ReinterpretF64: func(v uint64) uint64 { return v },
+	ops.F32ReinterpretI32: func(v uint64) uint64 { return v },
+	ops.F64ReinterpretI64: func(v uint64) uint64 { return v },
+
+	ops.I32Extend8S:  func(v uint64) uint64 { return uint64(uint32(int8(v))) },
+	ops.I32Extend16S: func(v uint64) uint64 { return uint64(uint32(int16(v))) },
+	ops.I64Extend8S:  func(v uint64) uint64 { return uint64(int8(v)) },
+	ops.I64Extend16S: func(v uint64) uint64 { return uint64(int16(v)) },
+	ops.I64Extend32S: func(v uint64) uint64 { return uint64(int32(v)) },
+}
+
+var binops = map[byte]func(v1, v2 uint64) uint64{
+	ops.I32Eq:  func(v1, v2 uint64) uint64 { return bool64(uint32(v1) == uint32(v2)) },
+	ops.I32Ne:  func(v1, v2 uint64) uint64 { return bool64(uint32(v1) != uint32(v2)) },
+	ops.I32LtS: func(v1, v2 uint64) uint64 { return bool64(int32(v1) < int32(v2)) },
+	ops.I32LtU: func(v1, v2 uint64) uint64 { return bool64(uint32(v1) < uint32(v2)) },
+	ops.I32GtS: func(v1, v2 uint64) uint64 { return bool64(int32(v1) > int32(v2)) },
+	ops.I32GtU: func(v1, v2 uint64) uint64 { return bool64(uint32(v1) > uint32(v2)) },
+	ops.I32LeS: func(v1, v2 uint64) uint64 { return bool64(int32(v1) <= int32(v2)) },
+	ops.I32LeU: func(v1, v2 uint64) uint64 { return bool64(uint32(v1) <= uint32(v2)) },
+	ops.I32GeS: func(v1, v2 uint64) uint64 { return bool64(int32(v1) >= int32(v2)) },
+	ops.I32GeU: func(v1, v2 uint64) uint64 { return bool64(uint32(v1) >= uint32(v2)) },
+	ops.I64Eq:  func(v1, v2 uint64) uint64 { return bool64(v1 == v2) },
+	ops.I64Ne:  func(v1, v2 uint64) uint64 { return bool64(v1 != v2) },
+	ops.I64LtS: func(v1, v2 uint64) uint64 { return bool64(int64(v1) < int64(v2)) },
+	ops.I64LtU: func(v1, v2 uint64) uint64 { return bool64(v1 < v2) },
+	ops.I64GtS: func(v1, v2 uint64) uint64 { return bool64(int64(v1) > int64(v2)) },
+	ops.I64GtU: func(v1, v2 uint64) uint64 { return bool64(v1 > v2) },
+	ops.I64LeS: func(v1, v2 uint64) uint64 { return bool64(int64(v1) <= int64(v2)) },
+	ops.I64LeU: func(v1, v2 uint64) uint64 { return bool64(v1 <= v2) },
+	ops.I64GeS: func(v1, v2 uint64) uint64 { return bool64(int64(v1) >= int64(v2)) },
+	ops.I64GeU: func(v1, v2 uint64) uint64 { return bool64(v1 >= v2) },
+	ops.F32Eq:  func(v1, v2 uint64) uint64 { return bool64(f32(v1) == f32(v2)) },
+	ops.F32Ne:  func(v1, v2 uint64) uint64 { return bool64(f32(v1) != f32(v2)) },
+	ops.F32Lt:  func(v1, v2 uint64) uint64 { return bool64(f32(v1) < f32(v2)) },
+	ops.F32Gt:  func(v1, v2 uint64) uint64 { return bool64(f32(v1) > f32(v2)) },
+	ops.F32Le:  func(v1, v2 uint64) uint64 { return bool64(f32(v1) <= f32(v2)) },
+	ops.F32Ge:  func(v1, v2 uint64) uint64 { return bool64(f32(v1) >= f32(v2)) },
+	ops.F64Eq:  func(v1, v2 uint64) uint64 { return bool64(f64(v1) == f64(v2)) },
+	ops.F64Ne:  func(v1, v2 uint64) uint64 { return bool64(f64(v1) != f64(v2)) },
+	ops.F64Lt:  func(v1, v2 uint64) uint64 { return bool64(f64(v1) < f64(v2)) },
+	ops.F64Gt:  func(v1, v2 uint64) uint64 { return bool64(f64(v1) > f64(v2)) },
+	ops.F64Le:  func(v1, v2 uint64) uint64 { return bool64(f64(v1) <= f64(v2)) },
+	ops.F64Ge:  func(v1, v2 uint64) uint64 { return bool64(f64(v1) >= f64(v2)) },
+
+	ops.I32Add: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) + uint32(v2)) },
+	ops.I32Sub: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) - uint32(v2)) },
+	ops.I32Mul: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) * uint32(v2)) },
+	ops.I32DivS: func(v1, v2 uint64) uint64 {
+		if int32(v1) == math.MinInt32 && int32(v2) == -1 {
+			panic(exec.ErrIntegerOverflow)
+		}
+		return uint64(uint32(int32(v1) / int32(v2)))
+	},
+	ops.I32DivU: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) / uint32(v2)) },
+	ops.I32RemS: func(v1, v2 uint64) uint64 { return uint64(uint32(int32(v1) % int32(v2))) },
+	ops.I32RemU: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) % uint32(v2)) },
+	ops.I32And:  func(v1, v2 uint64) uint64 { return uint64(uint32(v1) & uint32(v2)) },
+	ops.I32Or:   func(v1, v2 uint64) uint64 { return uint64(uint32(v1) | uint32(v2)) },
+	ops.I32Xor:  func(v1, v2 uint64) uint64 { return uint64(uint32(v1) ^ uint32(v2)) },
+	ops.I32Shl:  func(v1, v2 uint64) uint64 { return uint64(uint32(v1) << (v2 & 31)) },
+	ops.I32ShrS: func(v1, v2 uint64) uint64 { return uint64(uint32(int32(v1) >> (v2 & 31))) },
+	ops.I32ShrU: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) >> (v2 & 31)) },
+	ops.I32Rotl: func(v1, v2 uint64) uint64 { return uint64(bits.RotateLeft32(uint32(v1), int(v2&31))) },
+	ops.I32Rotr: func(v1, v2 uint64) uint64 { return uint64(bits.RotateLeft32(uint32(v1), -int(v2&31))) },
+	ops.I64Add:  func(v1, v2 uint64) uint64 { return v1 + v2 },
+	ops.I64Sub:  func(v1, v2 uint64) uint64 { return v1 - v2 },
+	ops.I64Mul:  func(v1, v2 uint64) uint64 { return v1 * v2 },
+	ops.I64DivS: func(v1, v2 uint64) uint64 {
+		if int64(v1) == math.MinInt64 && int64(v2) == -1 {
+			panic(exec.ErrIntegerOverflow)
+		}
+		return uint64(int64(v1) / int64(v2))
+	},
+	ops.I64DivU: func(v1, v2 uint64) uint64 { return v1 / v2 },
+	ops.I64RemS: func(v1, v2 uint64) uint64 { return uint64(int64(v1) % int64(v2)) },
+	ops.I64RemU: func(v1, v2 uint64) uint64 { return v1 % v2 },
+	ops.I64And:  func(v1, v2 uint64) uint64 { return v1 & v2 },
+	ops.I64Or:   func(v1, v2 uint64) uint64 { return v1 | v2 },
+	ops.I64Xor:  func(v1, v2 uint64) uint64 { return v1 ^ v2 },
+	ops.I64Shl:  func(v1, v2 uint64) uint64 { return v1 << (v2 & 63) },
+	ops.I64ShrS: func(v1, v2 uint64) uint64 { return uint64(int64(v1) >> (v2 & 63)) },
+	ops.I64ShrU: func(v1, v2 uint64) uint64 { return v1 >> (v2 & 63) },
+	ops.I64Rotl: func(v1, v2 uint64) uint64 { return bits.RotateLeft64(v1, int(v2&63)) },
+	ops.I64Rotr: func(v1, v2 uint64) uint64 { return bits.RotateLeft64(v1, -int(v2&63)) },
+
+	ops.F32Add:      func(v1, v2 uint64) uint64 { return fromF32(f32(v1) + f32(v2)) },
+	ops.F32Sub:      func(v1, v2 uint64) uint64 { return fromF32(f32(v1) - f32(v2)) },
+	ops.F32Mul:      func(v1, v2 uint64) uint64 { return fromF32(f32(v1) * f32(v2)) },
+	ops.F32Div:      func(v1, v2 uint64) uint64 { return fromF32(f32(v1) / f32(v2)) },
+	ops.F32Min:      func(v1, v2 uint64) uint64 { return fromF32(float32(fmin(float64(f32(v1)), float64(f32(v2))))) },
+	ops.F32Max:      func(v1, v2 uint64) uint64 { return fromF32(float32(fmax(float64(f32(v1)), float64(f32(v2))))) },
+	ops.F32Copysign: func(v1, v2 uint64) uint64 { return v1&^(1<<31) | v2&(1<<31) },
+	ops.F64Add:      func(v1, v2 uint64) uint64 { return fromF64(f64(v1) + f64(v2)) },
+	ops.F64Sub:      func(v1, v2 uint64) uint64 { return fromF64(f64(v1) - f64(v2)) },
+	ops.F64Mul:      func(v1, v2 uint64) uint64 { return fromF64(f64(v1) * f64(v2)) },
+	ops.F64Div:      func(v1, v2 uint64) uint64 { return fromF64(f64(v1) / f64(v2)) },
+	ops.F64Min:      func(v1, v2 uint64) uint64 { return fromF64(fmin(f64(v1), f64(v2))) },
+	ops.F64Max:      func(v1, v2 uint64) uint64 { return fromF64(fmax(f64(v1), f64(v2))) },
+	ops.F64Copysign: func(v1, v2 uint64) uint64 { return v1&^(1<<63) | v2&(1<<63) },
+}
+
+var satConversions = map[uint32]func(v uint64) uint64{
+	ops.I32TruncSatSF32: func(v uint64) uint64 {
+		return uint64(uint32(int32(sat(float64(f32(v)), math.MinInt32, math.MaxInt32))))
+	},
+	ops.I32TruncSatUF32: func(v uint64) uint64 { return uint64(uint32(sat(float64(f32(v)), 0, math.MaxUint32))) },
+	ops.I32TruncSatSF64: func(v uint64) uint64 { return uint64(uint32(int32(sat(f64(v), math.MinInt32, math.MaxInt32)))) },
+	ops.I32TruncSatUF64: func(v uint64) uint64 { return uint64(uint32(sat(f64(v), 0, math.MaxUint32))) },
+	ops.I64TruncSatSF32: func(v uint64) uint64 { return uint64(satInt64(float64(f32(v)))) },
+	ops.I64TruncSatUF32: func(v uint64) uint64 { return satUint64(float64(f32(v))) },
+	ops.I64TruncSatSF64: func(v uint64) uint64 { return uint64(satInt64(f64(v))) },
+	ops.I64TruncSatUF64: func(v uint64) uint64 { return satUint64(f64(v)) },
+}
+
+// satInt64 and satUint64 saturate to the limits of the 64-bit integers,
+// which aren't all exact as floats.
+func satInt64(f float64) int64 {
+	switch {
+	case f != f:
+		return 0
+	case f < math.MinInt64:
+		return math.MinInt64
+	case f >= 1<<63:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+func satUint64(f float64) uint64 {
+	switch {
+	case f != f, f <= 0:
+		return 0
+	case f >= 1<<64:
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
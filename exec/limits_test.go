@@ -0,0 +1,119 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestFunctionLimits(t *testing.T) {
+	m := readWat(t, `
+(module
+  (func (export "f") (param i32) (result i32)
+    (local i64 i64)
+    (i32.add (local.get 0) (i32.const 1))))
+`, nil)
+	for _, tc := range []struct {
+		opts []exec.VMOption
+		err  error
+	}{
+		{nil, nil},
+		{[]exec.VMOption{exec.MaxLocals(3)}, nil},
+		{[]exec.VMOption{exec.MaxLocals(2)}, &exec.LimitError{Function: 0, Limit: "locals", Value: 3, Max: 2}},
+		{[]exec.VMOption{exec.MaxBodySize(3)}, &exec.LimitError{Function: 0, Limit: "body size", Value: 5, Max: 3}},
+		{[]exec.VMOption{exec.MaxBodySize(-1), exec.MaxLocals(-1)}, nil},
+	} {
+		_, err := exec.NewVM(m, tc.opts...)
+		if !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("err = %v, want %v", err, tc.err)
+		}
+
+		// lazily compiled functions are checked when they are called.
+		vm, err := exec.NewVM(m, append(tc.opts, exec.LazyCompile(true))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := call(vm, m, "f", 1); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("lazy: err = %v, want %v", err, tc.err)
+		}
+	}
+}
+
+func TestHugeLocals(t *testing.T) {
+	m := readWat(t, `(module (func (export "f") (local i32)))`, nil)
+	m.FunctionIndexSpace[0].Body.Locals = []wasm.LocalEntry{{Count: 1 << 31, Type: wasm.ValueTypeI32}}
+
+	// the locals are not allocated by the validation.
+	if err := validate.VerifyModule(m); err != nil {
+		t.Fatal(err)
+	}
+	err := validate.VerifyModuleWithOptions(m, validate.Options{MaxLocals: 1000})
+	if verr, ok := err.(validate.Error); ok {
+		err = verr.Err
+	}
+	if want := (validate.LimitError{Limit: "locals", Value: 1 << 31, Max: 1000}); err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+
+	_, err = exec.NewVM(m)
+	want := &exec.LimitError{Function: 0, Limit: "locals", Value: 1 << 31, Max: exec.DefaultMaxLocals}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+}
+
+func TestMaxStackSize(t *testing.T) {
+	m := readWat(t, `
+(module
+  (func $f (export "f") (param i32) (result i32)
+    (if (result i32) (i32.eqz (local.get 0))
+      (then (i32.const 0))
+      (else (i32.add (i32.const 1) (call $f (i32.sub (local.get 0) (i32.const 1))))))))
+`, nil)
+	vm, err := exec.NewVM(m, exec.MaxStackSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+	// find the deepest call that doesn't trap, which depends on the size
+	// of the frames.
+	depth := uint64(0)
+	for ; depth < 100; depth++ {
+		if _, err := call(vm, m, "f", depth); err != nil {
+			if err != exec.ErrStackExhausted {
+				t.Fatalf("f(%d): err = %v, want %v", depth, err, exec.ErrStackExhausted)
+			}
+			break
+		}
+	}
+	if depth == 0 || depth == 100 {
+		t.Fatalf("f traps with a depth of %d", depth)
+	}
+	// the VM can still be used after the stack is exhausted.
+	for i := 0; i < 2; i++ {
+		if got, err := call(vm, m, "f", depth-1); err != nil || got != uint32(depth-1) {
+			t.Errorf("f(%d) = %v, %v, want %d", depth-1, got, err, depth-1)
+		}
+		if _, err := call(vm, m, "f", depth); err != exec.ErrStackExhausted {
+			t.Errorf("f(%d): err = %v, want %v", depth, err, exec.ErrStackExhausted)
+		}
+	}
+
+	// the default limit keeps deep recursions from overflowing the stack
+	// of the goroutine.
+	vm, err = exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+	if _, err := call(vm, m, "f", 1<<30); err != exec.ErrStackExhausted {
+		t.Errorf("f(1<<30): err = %v, want %v", err, exec.ErrStackExhausted)
+	}
+}
@@ -13,202 +13,289 @@ import (
 // when it detects an out of bounds access to the linear memory.
 var ErrOutOfBoundsMemoryAccess = errors.New("exec: out of bounds memory access")
 
-func (vm *VM) fetchBaseAddr() int {
-	return int(vm.fetchUint32() + uint32(vm.popInt32()))
+// ErrMemoryLimit is returned by NewVM when a memory of the module exceeds
+// the limit set by MaxMemoryPages, and by NewSharedMemory.
+var ErrMemoryLimit = errors.New("exec: memory exceeds the limit of pages")
+
+// linearMemory is one of the linear memories of a VM.
+type linearMemory struct {
+	bytes []byte
+
+	// The shared memory that bytes is a view of, nil if the memory isn't
+	// shared.
+	shared *SharedMemory
+
+	is64     bool   // whether the memory is indexed by i64 addresses
+	maxPages uint64 // the size, in pages, the memory can grow to
 }
 
-// inBounds returns true when the next vm.fetchBaseAddr() + offset
-// indices are in bounds accesses to the linear memory.
-func (vm *VM) inBounds(offset int) bool {
-	addr := endianess.Uint32(vm.ctx.code[vm.ctx.pc:]) + uint32(vm.ctx.stack[len(vm.ctx.stack)-1])
-	return int(addr)+offset < len(vm.memory)
+// sync updates the bytes of the memory after the shared memory it is a
+// view of was grown by another VM, and returns whether they changed.
+func (mem *linearMemory) sync() bool {
+	if mem.shared == nil {
+		return false
+	}
+	n := len(mem.bytes)
+	mem.bytes = mem.shared.Bytes()
+	return len(mem.bytes) != n
 }
 
-// curMem returns a slice to the memeory segment pointed to by
-// the current base address on the bytecode stream.
-func (vm *VM) curMem() []byte {
-	return vm.memory[vm.fetchBaseAddr():]
+// rangeCheck traps the VM if the n bytes starting at addr are not all in
+// bounds of the memory.
+func (mem *linearMemory) rangeCheck(addr, n uint64) {
+	if size := uint64(len(mem.bytes)); addr > size || n > size-addr {
+		// another VM may have grown a shared memory
+		mem.sync()
+	}
+	memoryRangeCheck(addr, n, len(mem.bytes))
 }
 
-func (vm *VM) i32Load() {
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+// atomically runs f while holding the lock of the memory if it is shared.
+// Memories that are not shared are only accessed by the goroutine running
+// the VM, f is simply called.
+func (mem *linearMemory) atomically(f func()) {
+	if mem.shared == nil {
+		f()
+		return
 	}
-	vm.pushUint32(endianess.Uint32(vm.curMem()))
+	mem.shared.mu.Lock()
+	defer mem.shared.mu.Unlock()
+	f()
 }
 
-func (vm *VM) i32Load8s() {
-	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+// fetchMemory returns the linear memory whose index is the next byte in
+// the bytecode stream.
+func (vm *VM) fetchMemory() *linearMemory {
+	return vm.memories[uint8(vm.fetchInt8())]
+}
+
+// popAddr pops an address, or a size, in the linear memory mem, which is
+// an i64 if the memory is 64-bit, and an i32 otherwise.
+func (vm *VM) popAddr(mem *linearMemory) uint64 {
+	if mem.is64 {
+		return vm.popUint64()
 	}
-	vm.pushInt32(int32(int8(vm.memory[vm.fetchBaseAddr()])))
+	return uint64(vm.popUint32())
 }
 
-func (vm *VM) i32Load8u() {
-	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+// pushAddr pushes an address, or a size, in the linear memory mem, as an
+// i64 if the memory is 64-bit, and as an i32 otherwise.
+func (vm *VM) pushAddr(mem *linearMemory, v uint64) {
+	if mem.is64 {
+		vm.pushUint64(v)
+	} else {
+		vm.pushUint32(uint32(v))
 	}
-	vm.pushUint32(uint32(uint8(vm.memory[vm.fetchBaseAddr()])))
 }
 
-func (vm *VM) i32Load16s() {
-	if !vm.inBounds(1) {
+// effectiveAddr returns the memory and the address made of the next offset
+// and memory index in the bytecode stream and the base address on the top
+// of the stack, trapping the VM if the n bytes at this address are not all
+// in bounds.
+func (vm *VM) effectiveAddr(n int) (*linearMemory, uint64) {
+	offset := vm.fetchUint64()
+	mem := vm.fetchMemory()
+	addr := offset + vm.popAddr(mem)
+	if addr < offset {
+		// the base address of a 64-bit memory overflowed
 		panic(ErrOutOfBoundsMemoryAccess)
 	}
-	vm.pushInt32(int32(int16(endianess.Uint16(vm.curMem()))))
+	mem.rangeCheck(addr, uint64(n))
+	return mem, addr
+}
+
+// curMem returns the n bytes of the linear memory at the effective address
+// of the operator being executed.
+func (vm *VM) curMem(n int) []byte {
+	mem, addr := vm.effectiveAddr(n)
+	return mem.bytes[addr : addr+uint64(n)]
+}
+
+func (vm *VM) i32Load() {
+	vm.pushUint32(endianess.Uint32(vm.curMem(4)))
+}
+
+func (vm *VM) i32Load8s() {
+	vm.pushInt32(int32(int8(vm.curMem(1)[0])))
+}
+
+func (vm *VM) i32Load8u() {
+	vm.pushUint32(uint32(vm.curMem(1)[0]))
+}
+
+func (vm *VM) i32Load16s() {
+	vm.pushInt32(int32(int16(endianess.Uint16(vm.curMem(2)))))
 }
 
 func (vm *VM) i32Load16u() {
-	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushUint32(uint32(endianess.Uint16(vm.curMem())))
+	vm.pushUint32(uint32(endianess.Uint16(vm.curMem(2))))
 }
 
 func (vm *VM) i64Load() {
-	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushUint64(endianess.Uint64(vm.curMem()))
+	vm.pushUint64(endianess.Uint64(vm.curMem(8)))
 }
 
 func (vm *VM) i64Load8s() {
-	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushInt64(int64(int8(vm.memory[vm.fetchBaseAddr()])))
+	vm.pushInt64(int64(int8(vm.curMem(1)[0])))
 }
 
 func (vm *VM) i64Load8u() {
-	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushUint64(uint64(uint8(vm.memory[vm.fetchBaseAddr()])))
+	vm.pushUint64(uint64(vm.curMem(1)[0]))
 }
 
 func (vm *VM) i64Load16s() {
-	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushInt64(int64(int16(endianess.Uint16(vm.curMem()))))
+	vm.pushInt64(int64(int16(endianess.Uint16(vm.curMem(2)))))
 }
 
 func (vm *VM) i64Load16u() {
-	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushUint64(uint64(endianess.Uint16(vm.curMem())))
+	vm.pushUint64(uint64(endianess.Uint16(vm.curMem(2))))
 }
 
 func (vm *VM) i64Load32s() {
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushInt64(int64(int32(endianess.Uint32(vm.curMem()))))
+	vm.pushInt64(int64(int32(endianess.Uint32(vm.curMem(4)))))
 }
 
 func (vm *VM) i64Load32u() {
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushUint64(uint64(endianess.Uint32(vm.curMem())))
+	vm.pushUint64(uint64(endianess.Uint32(vm.curMem(4))))
 }
 
 func (vm *VM) f32Store() {
 	v := math.Float32bits(vm.popFloat32())
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint32(vm.curMem(), v)
+	endianess.PutUint32(vm.curMem(4), v)
 }
 
 func (vm *VM) f32Load() {
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushFloat32(math.Float32frombits(endianess.Uint32(vm.curMem())))
+	vm.pushFloat32(math.Float32frombits(endianess.Uint32(vm.curMem(4))))
 }
 
 func (vm *VM) f64Store() {
 	v := math.Float64bits(vm.popFloat64())
-	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint64(vm.curMem(), v)
+	endianess.PutUint64(vm.curMem(8), v)
 }
 
 func (vm *VM) f64Load() {
-	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.pushFloat64(math.Float64frombits(endianess.Uint64(vm.curMem())))
+	vm.pushFloat64(math.Float64frombits(endianess.Uint64(vm.curMem(8))))
 }
 
 func (vm *VM) i32Store() {
 	v := vm.popUint32()
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint32(vm.curMem(), v)
+	endianess.PutUint32(vm.curMem(4), v)
 }
 
 func (vm *VM) i32Store8() {
 	v := byte(uint8(vm.popUint32()))
-	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.memory[vm.fetchBaseAddr()] = v
+	vm.curMem(1)[0] = v
 }
 
 func (vm *VM) i32Store16() {
 	v := uint16(vm.popUint32())
-	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint16(vm.curMem(), v)
+	endianess.PutUint16(vm.curMem(2), v)
 }
 
 func (vm *VM) i64Store() {
 	v := vm.popUint64()
-	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint64(vm.curMem(), v)
+	endianess.PutUint64(vm.curMem(8), v)
 }
 
 func (vm *VM) i64Store8() {
 	v := byte(uint8(vm.popUint64()))
-	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	vm.memory[vm.fetchBaseAddr()] = v
+	vm.curMem(1)[0] = v
 }
 
 func (vm *VM) i64Store16() {
 	v := uint16(vm.popUint64())
-	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint16(vm.curMem(), v)
+	endianess.PutUint16(vm.curMem(2), v)
 }
 
 func (vm *VM) i64Store32() {
 	v := uint32(vm.popUint64())
-	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
-	}
-	endianess.PutUint32(vm.curMem(), v)
+	endianess.PutUint32(vm.curMem(4), v)
 }
 
 func (vm *VM) currentMemory() {
-	_ = vm.fetchInt8() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
-	vm.pushInt32(int32(len(vm.memory) / wasmPageSize))
+	mem := vm.fetchMemory()
+	mem.sync()
+	vm.pushAddr(mem, uint64(len(mem.bytes)/wasmPageSize))
 }
 
 func (vm *VM) growMemory() {
-	_ = vm.fetchInt8() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
-	curLen := len(vm.memory) / wasmPageSize
-	n := vm.popInt32()
-	vm.memory = append(vm.memory, make([]byte, n*wasmPageSize)...)
-	vm.pushInt32(int32(curLen))
+	mem := vm.fetchMemory()
+	vm.pushAddr(mem, mem.grow(vm.popAddr(mem)))
+}
+
+// grow grows the memory by n pages, and returns its previous size in
+// pages, or growFailed.
+func (mem *linearMemory) grow(n uint64) uint64 {
+	if mem.shared != nil {
+		if n > math.MaxUint32 {
+			return growFailed
+		}
+		prev := mem.shared.grow(uint32(n))
+		mem.sync()
+		return uint64(int64(prev))
+	}
+	curLen := uint64(len(mem.bytes) / wasmPageSize)
+	if n > mem.maxPages-curLen {
+		return growFailed
+	}
+	mem.bytes = append(mem.bytes, make([]byte, n*wasmPageSize)...)
+	return curLen
+}
+
+// growFailed is the value returned by memory.grow when the memory can't
+// grow, -1 as an i32 or an i64.
+const growFailed = ^uint64(0)
+
+// memoryRangeCheck traps the VM if the n bytes starting at addr are not all
+// in bounds of a memory (or segment) of size size.
+func memoryRangeCheck(addr, n uint64, size int) {
+	if addr > uint64(size) || n > uint64(size)-addr {
+		panic(ErrOutOfBoundsMemoryAccess)
+	}
+}
+
+func (vm *VM) memoryInit() {
+	index := vm.fetchUint32()
+	mem := vm.fetchMemory()
+	n := uint64(vm.popUint32())
+	src := uint64(vm.popUint32())
+	dst := vm.popAddr(mem)
+	data := vm.data[index]
+	memoryRangeCheck(src, n, len(data))
+	mem.rangeCheck(dst, n)
+	copy(mem.bytes[dst:], data[src:src+n])
+}
+
+func (vm *VM) dataDrop() {
+	vm.data[vm.fetchUint32()] = nil
+}
+
+func (vm *VM) memoryCopy() {
+	dstMem := vm.fetchMemory()
+	srcMem := vm.fetchMemory()
+	// the size is an i64 only if both memories are 64-bit
+	var n uint64
+	if dstMem.is64 && srcMem.is64 {
+		n = vm.popUint64()
+	} else {
+		n = uint64(vm.popUint32())
+	}
+	src := vm.popAddr(srcMem)
+	dst := vm.popAddr(dstMem)
+	srcMem.rangeCheck(src, n)
+	dstMem.rangeCheck(dst, n)
+	// copy handles overlapping slices correctly.
+	copy(dstMem.bytes[dst:], srcMem.bytes[src:src+n])
+}
+
+func (vm *VM) memoryFill() {
+	mem := vm.fetchMemory()
+	n := vm.popAddr(mem)
+	v := byte(vm.popUint32())
+	dst := vm.popAddr(mem)
+	mem.rangeCheck(dst, n)
+	b := mem.bytes[dst : dst+n]
+	for i := range b {
+		b[i] = v
+	}
 }
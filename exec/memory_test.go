@@ -0,0 +1,117 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestImportMemories(t *testing.T) {
+	// the importing module imports the memories mem1 and mem2 of
+	// testdata/multi-memory.wasm, and defines a third memory.
+	m := &wasm.Module{Version: 1}
+	m.Types = &wasm.SectionTypes{Entries: []wasm.FunctionSig{
+		{Form: -0x20, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+	}}
+	m.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{
+			ModuleName: "multi",
+			FieldName:  "mem1",
+			Type:       wasm.MemoryImport{Type: wasm.Memory{Limits: wasm.ResizableLimits{Initial: 1}}},
+		},
+		{
+			ModuleName: "multi",
+			FieldName:  "mem2",
+			Type:       wasm.MemoryImport{Type: wasm.Memory{Limits: wasm.ResizableLimits{Flags: 0x4, Initial: 1}}},
+		},
+	}}
+	m.Function = &wasm.SectionFunctions{Types: []uint32{0, 0, 0}}
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{
+		{Limits: wasm.ResizableLimits{Initial: 2}},
+	}}
+	m.Code = &wasm.SectionCode{Bodies: []wasm.FunctionBody{
+		// i32.const 0; i32.load
+		{Code: []byte{0x41, 0x00, 0x28, 0x02, 0x00}},
+		// i64.const 0; i32.load 1
+		{Code: []byte{0x42, 0x00, 0x28, 0x42, 0x01, 0x00}},
+		// memory.size 2
+		{Code: []byte{0x3f, 0x02}},
+	}}
+	m.Sections = []wasm.Section{m.Types, m.Import, m.Function, m.Memory, m.Code}
+	var buf bytes.Buffer
+	if err := wasm.EncodeModule(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := wasm.ReadModule(&buf, func(name string) (*wasm.Module, error) {
+		f, err := os.Open("testdata/multi-memory.wasm")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return wasm.ReadModule(f, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []uint32{42, 0, 2} {
+		rtrn, err := vm.ExecCode(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtrn != want {
+			t.Errorf("function %d returned %v, want %d", i, rtrn, want)
+		}
+	}
+	if n := len(vm.MemoryAt(2)); n != 2*wasmPageSize {
+		t.Errorf("the memory defined by the module is %d bytes long, want %d", n, 2*wasmPageSize)
+	}
+}
+
+func TestProcessGrowMemory(t *testing.T) {
+	m := &wasm.Module{Version: 1}
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{
+		{Limits: wasm.ResizableLimits{Flags: 0x1, Initial: 1, Maximum: 2}},
+	}}
+	m.Sections = []wasm.Section{m.Memory}
+	var buf bytes.Buffer
+	if err := wasm.EncodeModule(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.ReadModule(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := NewProcess(vm)
+	for _, tc := range []struct {
+		n    uint32
+		want int64
+	}{
+		{0, 1},
+		{1, 1},
+		{1, -1},
+		{0, 2},
+	} {
+		if got := proc.GrowMemory(tc.n); got != tc.want {
+			t.Fatalf("GrowMemory(%d) = %d, want %d", tc.n, got, tc.want)
+		}
+	}
+	if got, want := proc.MemSize(), 2*wasmPageSize; got != want {
+		t.Errorf("MemSize() = %d, want %d", got, want)
+	}
+}
@@ -34,6 +34,9 @@ func (vm *VM) i32Mul() {
 func (vm *VM) i32DivS() {
 	v2 := vm.popInt32()
 	v1 := vm.popInt32()
+	if v1 == math.MinInt32 && v2 == -1 {
+		panic(ErrIntegerOverflow)
+	}
 	vm.pushInt32(v1 / v2)
 }
 
@@ -76,19 +79,19 @@ func (vm *VM) i32Xor() {
 func (vm *VM) i32Shl() {
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
-	vm.pushUint32(v1 << v2)
+	vm.pushUint32(v1 << (v2 & 31))
 }
 
 func (vm *VM) i32ShrU() {
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
-	vm.pushUint32(v1 >> v2)
+	vm.pushUint32(v1 >> (v2 & 31))
 }
 
 func (vm *VM) i32ShrS() {
 	v2 := vm.popUint32()
 	v1 := vm.popInt32()
-	vm.pushInt32(v1 >> v2)
+	vm.pushInt32(v1 >> (v2 & 31))
 }
 
 func (vm *VM) i32Rotl() {
@@ -194,6 +197,9 @@ func (vm *VM) i64Mul() {
 func (vm *VM) i64DivS() {
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
+	if v1 == math.MinInt64 && v2 == -1 {
+		panic(ErrIntegerOverflow)
+	}
 	vm.pushInt64(v1 / v2)
 }
 
@@ -230,19 +236,19 @@ func (vm *VM) i64Xor() {
 func (vm *VM) i64Shl() {
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 << v2)
+	vm.pushUint64(v1 << (v2 & 63))
 }
 
 func (vm *VM) i64ShrS() {
 	v2 := vm.popUint64()
 	v1 := vm.popInt64()
-	vm.pushInt64(v1 >> v2)
+	vm.pushInt64(v1 >> (v2 & 63))
 }
 
 func (vm *VM) i64ShrU() {
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 >> v2)
+	vm.pushUint64(v1 >> (v2 & 63))
 }
 
 func (vm *VM) i64Rotl() {
@@ -319,65 +325,74 @@ func (vm *VM) i64GeU() {
 
 // float32 operators
 
+const (
+	signBit32 = 1 << 31
+	signBit64 = 1 << 63
+)
+
+// The abs, neg and copysign operators only change the sign bit, which
+// keeps the payload of NaNs.
+
 func (vm *VM) f32Abs() {
-	vm.pushFloat32(float32(math.Abs(float64(vm.popFloat32()))))
+	vm.pushUint32(vm.popUint32() &^ signBit32)
 }
 
 func (vm *VM) f32Neg() {
-	vm.pushFloat32(-vm.popFloat32())
+	vm.pushUint32(vm.popUint32() ^ signBit32)
 }
 
 func (vm *VM) f32Ceil() {
-	vm.pushFloat32(float32(math.Ceil(float64(vm.popFloat32()))))
+	vm.pushFloat32Result(float32(math.Ceil(float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Floor() {
-	vm.pushFloat32(float32(math.Floor(float64(vm.popFloat32()))))
+	vm.pushFloat32Result(float32(math.Floor(float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Trunc() {
-	vm.pushFloat32(float32(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushFloat32Result(float32(math.Trunc(float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Nearest() {
-	f := vm.popFloat32()
-	vm.pushFloat32(float32(int32(f + float32(math.Copysign(0.5, float64(f))))))
+	vm.pushFloat32Result(float32(nearest(float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Sqrt() {
-	vm.pushFloat32(float32(math.Sqrt(float64(vm.popFloat32()))))
+	vm.pushFloat32Result(float32(math.Sqrt(float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Add() {
-	vm.pushFloat32(vm.popFloat32() + vm.popFloat32())
+	vm.pushFloat32Result(vm.popFloat32() + vm.popFloat32())
 }
 
 func (vm *VM) f32Sub() {
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
-	vm.pushFloat32(v1 - v2)
+	vm.pushFloat32Result(v1 - v2)
 }
 
 func (vm *VM) f32Mul() {
-	vm.pushFloat32(vm.popFloat32() * vm.popFloat32())
+	vm.pushFloat32Result(vm.popFloat32() * vm.popFloat32())
 }
 
 func (vm *VM) f32Div() {
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
-	vm.pushFloat32(v1 / v2)
+	vm.pushFloat32Result(v1 / v2)
 }
 
 func (vm *VM) f32Min() {
-	vm.pushFloat32(float32(math.Min(float64(vm.popFloat32()), float64(vm.popFloat32()))))
+	vm.pushFloat32Result(float32(fmin(float64(vm.popFloat32()), float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Max() {
-	vm.pushFloat32(float32(math.Max(float64(vm.popFloat32()), float64(vm.popFloat32()))))
+	vm.pushFloat32Result(float32(fmax(float64(vm.popFloat32()), float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Copysign() {
-	vm.pushFloat32(float32(math.Copysign(float64(vm.popFloat32()), float64(vm.popFloat32()))))
+	v2 := vm.popUint32()
+	v1 := vm.popUint32()
+	vm.pushUint32(v1&^signBit32 | v2&signBit32)
 }
 
 func (vm *VM) f32Eq() {
@@ -415,64 +430,95 @@ func (vm *VM) f32Ge() {
 // float64 operators
 
 func (vm *VM) f64Abs() {
-	vm.pushFloat64(math.Abs(vm.popFloat64()))
+	vm.pushUint64(vm.popUint64() &^ signBit64)
 }
 
 func (vm *VM) f64Neg() {
-	vm.pushFloat64(-vm.popFloat64())
+	vm.pushUint64(vm.popUint64() ^ signBit64)
 }
 
 func (vm *VM) f64Ceil() {
-	vm.pushFloat64(math.Ceil(vm.popFloat64()))
+	vm.pushFloat64Result(math.Ceil(vm.popFloat64()))
 }
 
 func (vm *VM) f64Floor() {
-	vm.pushFloat64(math.Floor(vm.popFloat64()))
+	vm.pushFloat64Result(math.Floor(vm.popFloat64()))
 }
 
 func (vm *VM) f64Trunc() {
-	vm.pushFloat64(math.Trunc(vm.popFloat64()))
+	vm.pushFloat64Result(math.Trunc(vm.popFloat64()))
 }
 
 func (vm *VM) f64Nearest() {
-	f := vm.popFloat64()
-	vm.pushFloat64(float64(int64(f + math.Copysign(0.5, f))))
+	vm.pushFloat64Result(nearest(vm.popFloat64()))
+}
+
+// nearest rounds f to the nearest integer, ties to even.
+func nearest(f float64) float64 {
+	if math.IsInf(f, 0) || f != f {
+		return f
+	}
+	t := math.Trunc(f)
+	if d := math.Abs(f - t); d > 0.5 || d == 0.5 && math.Mod(t, 2) != 0 {
+		t += math.Copysign(1, f)
+	}
+	// keep the sign of the values rounded to zero.
+	return math.Copysign(t, f)
+}
+
+// fmin and fmax return the minimum and the maximum of a and b, or NaN if
+// either is NaN. math.Min and math.Max return an infinite operand even if
+// the other one is NaN.
+func fmin(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Min(a, b)
+}
+
+func fmax(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Max(a, b)
 }
 
 func (vm *VM) f64Sqrt() {
-	vm.pushFloat64(math.Sqrt(vm.popFloat64()))
+	vm.pushFloat64Result(math.Sqrt(vm.popFloat64()))
 }
 
 func (vm *VM) f64Add() {
-	vm.pushFloat64(vm.popFloat64() + vm.popFloat64())
+	vm.pushFloat64Result(vm.popFloat64() + vm.popFloat64())
 }
 
 func (vm *VM) f64Sub() {
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushFloat64(v1 - v2)
+	vm.pushFloat64Result(v1 - v2)
 }
 
 func (vm *VM) f64Mul() {
-	vm.pushFloat64(vm.popFloat64() * vm.popFloat64())
+	vm.pushFloat64Result(vm.popFloat64() * vm.popFloat64())
 }
 
 func (vm *VM) f64Div() {
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushFloat64(v1 / v2)
+	vm.pushFloat64Result(v1 / v2)
 }
 
 func (vm *VM) f64Min() {
-	vm.pushFloat64(math.Min(vm.popFloat64(), vm.popFloat64()))
+	vm.pushFloat64Result(fmin(vm.popFloat64(), vm.popFloat64()))
 }
 
 func (vm *VM) f64Max() {
-	vm.pushFloat64(math.Max(vm.popFloat64(), vm.popFloat64()))
+	vm.pushFloat64Result(fmax(vm.popFloat64(), vm.popFloat64()))
 }
 
 func (vm *VM) f64Copysign() {
-	vm.pushFloat64(math.Copysign(vm.popFloat64(), vm.popFloat64()))
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	vm.pushUint64(v1&^signBit64 | v2&signBit64)
 }
 
 func (vm *VM) f64Eq() {
@@ -506,3 +552,23 @@ func (vm *VM) f64Ge() {
 	v1 := vm.popFloat64()
 	vm.pushBool(v1 >= v2)
 }
+
+func (vm *VM) i32Extend8S() {
+	vm.pushInt32(int32(int8(vm.popInt32())))
+}
+
+func (vm *VM) i32Extend16S() {
+	vm.pushInt32(int32(int16(vm.popInt32())))
+}
+
+func (vm *VM) i64Extend8S() {
+	vm.pushInt64(int64(int8(vm.popInt64())))
+}
+
+func (vm *VM) i64Extend16S() {
+	vm.pushInt64(int64(int16(vm.popInt64())))
+}
+
+func (vm *VM) i64Extend32S() {
+	vm.pushInt64(int64(int32(vm.popInt64())))
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+// config holds the settings of a VM, as set by VMOption values.
+type config struct {
+	lazyCompile        bool
+	compileConcurrency int
+	sharedMemory       *SharedMemory
+
+	deterministic     bool
+	deterministicHost func(module, field string) bool
+	maxCallDepth      int
+	maxMemoryPages    int
+
+	maxLocals    int
+	maxStackSize int
+	maxBodySize  int
+}
+
+// VMOption configures the VM created by NewVM.
+type VMOption func(c *config)
+
+// LazyCompile controls whether NewVM defers the compilation of each function
+// body until the function is called for the first time. This makes creating
+// a VM for a large module cheap when only a few of its functions are executed.
+//
+// Errors in a lazily compiled function body are reported when the function
+// is called: ExecCode returns them when the function is called directly,
+// otherwise the VM panics with them (see VM.RecoverPanic).
+// Use (*VM).CompileAll to compile all remaining functions and report any
+// error up front.
+func LazyCompile(v bool) VMOption {
+	return func(c *config) {
+		c.lazyCompile = v
+	}
+}
+
+// CompileConcurrency sets the number of goroutines used to disassemble and
+// compile function bodies when the VM is created, or when (*VM).CompileAll
+// is called. Values lower than 2 compile functions sequentially, which is
+// the default.
+// Regardless of the concurrency, the reported error is always the one of
+// the failing function with the lowest index.
+func CompileConcurrency(n int) VMOption {
+	return func(c *config) {
+		c.compileConcurrency = n
+	}
+}
+
+// DefaultMaxCallDepth is the limit of the call stack of deterministic VMs,
+// see Deterministic.
+const DefaultMaxCallDepth = 10000
+
+// Deterministic controls whether the VM computes the same results on every
+// platform, as needed by replicated systems where all nodes must agree on
+// the outcome of an execution. In deterministic mode:
+//
+//   - the NaN values produced by the arithmetic float operators, on scalars
+//     and on the lanes of vectors, are canonical NaNs, whose payload
+//     doesn't depend on the platform;
+//   - NewVM fails with a NondeterministicHostError if the module imports a
+//     host function not allowed by DeterministicHost;
+//   - NewVM fails with ErrNondeterministicSharedMemory if the module uses a
+//     shared memory or the WithSharedMemory option is given, and with
+//     ErrNondeterministicWait if the module uses the wait or notify
+//     operators;
+//   - the call stack is limited by MaxCallDepth, which defaults to
+//     DefaultMaxCallDepth, so that all VMs run out of resources at the
+//     same point, as the memories do with MaxMemoryPages.
+//
+// Modules can be kept from using float operators at all, vector ones
+// included, with the NoFloat option of the validate package.
+func Deterministic(v bool) VMOption {
+	return func(c *config) {
+		c.deterministic = v
+	}
+}
+
+// DeterministicHost sets the function reporting whether the host function
+// imported as field from module is deterministic, and can be imported by a
+// deterministic VM. By default, deterministic VMs import no host
+// functions.
+func DeterministicHost(allow func(module, field string) bool) VMOption {
+	return func(c *config) {
+		c.deterministicHost = allow
+	}
+}
+
+// MaxCallDepth limits the number of nested calls of WebAssembly functions,
+// beyond which the VM traps with ErrCallStackExhausted. The call stack is
+// only limited by MaxStackSize by default, except in deterministic mode.
+func MaxCallDepth(n int) VMOption {
+	return func(c *config) {
+		c.maxCallDepth = n
+	}
+}
+
+// DefaultMaxMemoryPages is the default limit of the size of each memory,
+// see MaxMemoryPages.
+const DefaultMaxMemoryPages = 16384 // 1GiB
+
+// MaxMemoryPages limits the size, in pages of 64KiB, that each memory can
+// grow to, whatever its declared maximum: memory.grow returns -1 beyond
+// it. NewVM fails with ErrMemoryLimit if a memory is initially larger, or
+// is a shared memory whose maximum is larger, before allocating it. The
+// limit is DefaultMaxMemoryPages by default, and a negative n removes it:
+// a module can then make the host allocate up to 4GiB, or more with a
+// 64-bit memory, for each of its memories.
+func MaxMemoryPages(n int) VMOption {
+	return func(c *config) {
+		c.maxMemoryPages = n
+	}
+}
+
+// Default limits of the functions of a VM, see MaxLocals, MaxBodySize and
+// MaxStackSize. The limits of the local variables and of the body size
+// are the ones of the JavaScript API.
+const (
+	DefaultMaxLocals    = 50000
+	DefaultMaxBodySize  = 7654321
+	DefaultMaxStackSize = 1 << 18
+)
+
+// limit returns the limit set to n by an option: def if n is 0, and 0 for
+// no limit if n is negative.
+func limit(n, def int) int {
+	switch {
+	case n == 0:
+		return def
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// MaxLocals limits the number of local variables of each function, its
+// parameters included, which are allocated by each call. The compilation
+// of a function exceeding it fails with a *LimitError. The limit is
+// DefaultMaxLocals by default, and a negative n removes it.
+func MaxLocals(n int) VMOption {
+	return func(c *config) {
+		c.maxLocals = n
+	}
+}
+
+// MaxBodySize limits the size, in bytes, of the code of each function. The
+// compilation of a function exceeding it fails with a *LimitError. The
+// limit is DefaultMaxBodySize by default, and a negative n removes it.
+func MaxBodySize(n int) VMOption {
+	return func(c *config) {
+		c.maxBodySize = n
+	}
+}
+
+// MaxStackSize limits the number of values of the stack of the VM, across
+// the frames of all the functions being called: each frame holds the local
+// variables and the operand stack of a function, and counts as one more
+// value. A call beyond the limit traps the VM with ErrStackExhausted. The
+// limit is DefaultMaxStackSize by default, and a negative n removes it.
+func MaxStackSize(n int) VMOption {
+	return func(c *config) {
+		c.maxStackSize = n
+	}
+}
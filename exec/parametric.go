@@ -10,12 +10,12 @@ func (vm *VM) drop() {
 
 func (vm *VM) selectOp() {
 	c := vm.popUint32()
-	val2 := vm.popUint64()
-	val1 := vm.popUint64()
+	val2 := vm.popValue()
+	val1 := vm.popValue()
 
 	if c != 0 {
-		vm.pushUint64(val1)
+		vm.pushValue(val1)
 	} else {
-		vm.pushUint64(val2)
+		vm.pushValue(val2)
 	}
 }
@@ -0,0 +1,78 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "reflect"
+
+func (vm *VM) refNull() {
+	vm.pushUint64(0)
+}
+
+func (vm *VM) refIsNull() {
+	vm.pushBool(vm.popUint64() == 0)
+}
+
+func (vm *VM) refFunc() {
+	vm.pushUint64(uint64(vm.fetchUint32()) + 1)
+}
+
+// ExternRef returns an externref value referencing the host value v, that
+// can be passed as an argument to ExecCode. A nil v gives the null
+// reference. WebAssembly code can only pass the reference around, never
+// access v.
+//
+// The referenced values are kept alive for the lifetime of the VM, as the
+// module can store their references in tables and globals. A comparable
+// value, such as a pointer, always gets the same reference, so that
+// referencing the same values again doesn't use more memory. A value which
+// isn't comparable, such as a slice or a map, gets a new reference every
+// time: host functions called repeatedly should reference long-lived
+// comparable values rather than create new ones.
+//
+// Host functions receive and return externref values as interface{}
+// values (or values of any interface type), which are converted
+// automatically.
+func (vm *VM) ExternRef(v interface{}) uint64 {
+	if v == nil {
+		return 0
+	}
+	ref, comparable := vm.lookupExtern(v)
+	if ref != 0 {
+		return ref
+	}
+	vm.externs = append(vm.externs, v)
+	ref = uint64(len(vm.externs))
+	if comparable {
+		if vm.externRefs == nil {
+			vm.externRefs = make(map[interface{}]uint64)
+		}
+		vm.externRefs[v] = ref
+	}
+	return ref
+}
+
+// lookupExtern returns the reference of the host value v, or 0 if v isn't
+// referenced yet. comparable is false if v can't be a key of a map.
+func (vm *VM) lookupExtern(v interface{}) (ref uint64, comparable bool) {
+	if !reflect.TypeOf(v).Comparable() {
+		return 0, false
+	}
+	// a comparable struct or array can still hold values which aren't in
+	// its interface fields, whose hashing panics.
+	defer func() {
+		if recover() != nil {
+			ref, comparable = 0, false
+		}
+	}()
+	return vm.externRefs[v], true
+}
+
+// externValue returns the host value referenced by an externref value.
+func (vm *VM) externValue(ref uint64) interface{} {
+	if ref == 0 {
+		return nil
+	}
+	return vm.externs[ref-1]
+}
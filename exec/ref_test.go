@@ -0,0 +1,170 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+type handle struct {
+	name string
+}
+
+func (h *handle) String() string {
+	return "handle " + h.name
+}
+
+func TestExternRef(t *testing.T) {
+	f, err := os.Open("testdata/reference-types.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := wasm.ReadModule(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &handle{"h"}
+	rtrn, err := vm.ExecCode(int64(m.Export.Entries["extern_table"].Index), vm.ExternRef(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != h {
+		t.Errorf("extern_table returned %v, want %v", rtrn, h)
+	}
+
+	rtrn, err = vm.ExecCode(int64(m.Export.Entries["extern_is_null"].Index), vm.ExternRef(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != uint32(0) {
+		t.Errorf("extern_is_null returned %v, want 0", rtrn)
+	}
+
+	rtrn, err = vm.ExecCode(int64(m.Export.Entries["extern_table"].Index), vm.ExternRef(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtrn != nil {
+		t.Errorf("extern_table returned %v for a null reference", rtrn)
+	}
+}
+
+func TestHostExternRef(t *testing.T) {
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Types = &wasm.SectionTypes{
+		// (func [externref] -> [externref])
+		Entries: []wasm.FunctionSig{
+			{
+				Form:        0,
+				ParamTypes:  []wasm.ValueType{wasm.ValueTypeExternRef},
+				ReturnTypes: []wasm.ValueType{wasm.ValueTypeExternRef},
+			},
+		},
+	}
+	m.Function = &wasm.SectionFunctions{
+		Types: []uint32{0, 0},
+	}
+
+	// passes its argument to the host function, and returns its result:
+	// get_local 0
+	// call 1
+	fb := wasm.FunctionBody{
+		Module: m,
+		Code:   []byte{0x20, 0x00, 0x10, 0x01},
+	}
+	host := func(proc *Process, s fmt.Stringer) interface{} {
+		if s == nil {
+			return nil
+		}
+		return s.String()
+	}
+	m.FunctionIndexSpace = []wasm.Function{
+		{
+			Sig:  &m.Types.Entries[0],
+			Body: &fb,
+		},
+		{
+			Sig:  &m.Types.Entries[0],
+			Host: reflect.ValueOf(host),
+		},
+	}
+	m.Code = &wasm.SectionCode{
+		Bodies: []wasm.FunctionBody{fb},
+	}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("Error creating VM: %v", err)
+	}
+
+	for _, tc := range []struct {
+		arg  interface{}
+		want interface{}
+	}{
+		{&handle{"a"}, "handle a"},
+		{nil, nil},
+	} {
+		rtrn, err := vm.ExecCode(0, vm.ExternRef(tc.arg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtrn != tc.want {
+			t.Errorf("got %v, want %v", rtrn, tc.want)
+		}
+	}
+
+	// the strings returned by the host function reuse their references.
+	h := &handle{"b"}
+	for i := 0; i < 1000; i++ {
+		if _, err := vm.ExecCode(0, vm.ExternRef(h)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(vm.externs); n != 4 {
+		t.Errorf("%d host values referenced, want 4", n)
+	}
+}
+
+func TestExternRefReuse(t *testing.T) {
+	vm := &VM{}
+	h := &handle{"h"}
+	if a, b := vm.ExternRef(h), vm.ExternRef(h); a != b {
+		t.Errorf("ExternRef(h) = %d, then %d", a, b)
+	}
+	if a, b := vm.ExternRef(h), vm.ExternRef(&handle{"h"}); a == b {
+		t.Errorf("ExternRef of different pointers = %d", a)
+	}
+	if a, b := vm.ExternRef("s"), vm.ExternRef("s"); a != b {
+		t.Errorf("ExternRef(\"s\") = %d, then %d", a, b)
+	}
+
+	// values which can't be map keys get a new reference every time.
+	type holder struct{ v interface{} }
+	for _, v := range []interface{}{
+		[]int{1},
+		map[string]int{},
+		holder{[]int{1}},
+	} {
+		a, b := vm.ExternRef(v), vm.ExternRef(v)
+		if a == b {
+			t.Errorf("ExternRef(%#v) = %d twice", v, a)
+		}
+		if got := vm.externValue(a); !reflect.DeepEqual(got, v) {
+			t.Errorf("externValue(ExternRef(%#v)) = %#v", v, got)
+		}
+	}
+}
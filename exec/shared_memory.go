@@ -0,0 +1,180 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxPages is the maximum number of pages of a linear memory.
+const maxPages = 65536
+
+// ErrInvalidSharedMemoryLimits is returned by NewSharedMemory when the
+// initial size of the memory is greater than its maximum size, or when
+// the maximum size is greater than 4GiB.
+var ErrInvalidSharedMemoryLimits = errors.New("exec: invalid shared memory limits")
+
+// SharedMemory is a linear memory declared as shared by a module, which
+// several VMs running on separate goroutines can use concurrently. This
+// is how multithreaded code, where each thread is an instance of the same
+// module, is run: the first VM creates the shared memory, and the others
+// are created with the WithSharedMemory option.
+//
+// Atomic operators, memory.grow, and memory.atomic.wait/notify are
+// serialized by a lock. Regular loads, stores and bulk memory operators
+// don't take this lock: they are not synchronized with the atomic
+// operators, nor with each other. As in the memory model of the threads
+// proposal, concurrent regular accesses to the same bytes, at least one of
+// which is a store, are races whose results are unspecified: a load may
+// observe a torn value, and the Go race detector reports them. Programs
+// must order these accesses with atomic operators or wait/notify.
+type SharedMemory struct {
+	// The whole memory, of the maximum size, is allocated once, so that
+	// growing it never moves it from under the VMs using it.
+	buf []byte
+
+	pages uint32 // the current size in pages, accessed atomically
+
+	mu      sync.Mutex
+	waiters map[uint32][]chan struct{} // goroutines waiting on an address, guarded by mu
+}
+
+// NewSharedMemory creates a shared linear memory of initial pages,
+// which can grow up to max pages. The memory of max pages is allocated at
+// once, so NewSharedMemory fails with ErrMemoryLimit, before allocating
+// it, if max is larger than the limit set by the MaxMemoryPages option,
+// DefaultMaxMemoryPages by default. The other options are ignored.
+func NewSharedMemory(initial, max uint32, opts ...VMOption) (*SharedMemory, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newSharedMemory(initial, max, limit(cfg.maxMemoryPages, DefaultMaxMemoryPages))
+}
+
+// newSharedMemory creates a shared memory whose maximum size is at most
+// maxMemoryPages, or up to 4GiB if maxMemoryPages is 0.
+func newSharedMemory(initial, max uint32, maxMemoryPages int) (*SharedMemory, error) {
+	if initial > max || max > maxPages {
+		return nil, ErrInvalidSharedMemoryLimits
+	}
+	if maxMemoryPages != 0 && uint64(max) > uint64(maxMemoryPages) {
+		return nil, ErrMemoryLimit
+	}
+	return &SharedMemory{
+		buf:     make([]byte, uint64(max)*wasmPageSize),
+		pages:   initial,
+		waiters: make(map[uint32][]chan struct{}),
+	}, nil
+}
+
+// maxPages returns the size, in pages, the memory can grow to.
+func (m *SharedMemory) maxPages() uint64 {
+	return uint64(len(m.buf)) / wasmPageSize
+}
+
+// Bytes returns the current content of the memory.
+func (m *SharedMemory) Bytes() []byte {
+	return m.buf[:uint64(atomic.LoadUint32(&m.pages))*wasmPageSize]
+}
+
+// grow grows the memory by n pages, and returns its previous size in pages,
+// or -1 if the memory can't grow by n pages.
+func (m *SharedMemory) grow(n uint32) int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pages := atomic.LoadUint32(&m.pages)
+	if uint64(pages)+uint64(n) > m.maxPages() {
+		return -1
+	}
+	atomic.StoreUint32(&m.pages, pages+n)
+	return int32(pages)
+}
+
+// wait implements memory.atomic.wait32 and memory.atomic.wait64. It blocks
+// until the value at addr, of the given size, is notified, unless this
+// value isn't expected. A negative timeout, in nanoseconds, waits forever.
+// It returns 0 if the goroutine was woken by notify, 1 if the value wasn't
+// expected, and 2 if the timeout expired.
+func (m *SharedMemory) wait(addr uint32, size int, expected uint64, timeout int64) int32 {
+	m.mu.Lock()
+	if loadN(m.buf[addr:], size) != expected {
+		m.mu.Unlock()
+		return 1
+	}
+	ch := make(chan struct{})
+	m.waiters[addr] = append(m.waiters[addr], ch)
+	m.mu.Unlock()
+
+	if timeout < 0 {
+		<-ch
+		return 0
+	}
+	t := time.NewTimer(time.Duration(timeout))
+	defer t.Stop()
+	select {
+	case <-ch:
+		return 0
+	case <-t.C:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	waiters := m.waiters[addr]
+	for i, c := range waiters {
+		if c == ch {
+			m.waiters[addr] = append(waiters[:i], waiters[i+1:]...)
+			return 2
+		}
+	}
+	// notified after the timeout expired, but before the waiter could
+	// remove itself from the queue.
+	return 0
+}
+
+// notify implements memory.atomic.notify, waking up to count goroutines
+// waiting on addr. It returns the number of goroutines woken.
+func (m *SharedMemory) notify(addr uint32, count uint32) int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	waiters := m.waiters[addr]
+	n := len(waiters)
+	if uint64(count) < uint64(n) {
+		n = int(count)
+	}
+	for _, ch := range waiters[:n] {
+		close(ch)
+	}
+	if n == len(waiters) {
+		delete(m.waiters, addr)
+	} else {
+		m.waiters[addr] = waiters[n:]
+	}
+	return int32(n)
+}
+
+// WithSharedMemory makes the VM use mem as its linear memory, the memory
+// with index 0 if the module has several ones, instead of the memory defined
+// or imported by the module. The content of mem is left untouched, as it has
+// already been initialized by the VM that created it: the active data
+// segments of the module are not copied again.
+func WithSharedMemory(mem *SharedMemory) VMOption {
+	return func(c *config) {
+		c.sharedMemory = mem
+	}
+}
+
+// SharedMemory returns the linear memory of the VM, the memory with index 0
+// if the module has several ones, if it is shared, to create other VMs
+// using it, and nil otherwise.
+func (vm *VM) SharedMemory() *SharedMemory {
+	if len(vm.memories) == 0 {
+		return nil
+	}
+	return vm.memories[0].shared
+}
@@ -0,0 +1,980 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"math/bits"
+
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// v128 is a 128 bit vector, stored in little endian order.
+type v128 [16]byte
+
+func v128Value(b [16]byte) value {
+	return value{lo: endianess.Uint64(b[:8]), hi: endianess.Uint64(b[8:])}
+}
+
+func (v value) v128() [16]byte {
+	var b [16]byte
+	endianess.PutUint64(b[:8], v.lo)
+	endianess.PutUint64(b[8:], v.hi)
+	return b
+}
+
+func (vm *VM) popV128() v128 {
+	return v128(vm.popValue().v128())
+}
+
+func (vm *VM) pushV128(v v128) {
+	vm.pushValue(v128Value(v))
+}
+
+func (vm *VM) fetchV128() v128 {
+	var v v128
+	copy(v[:], vm.ctx.code[vm.ctx.pc:])
+	vm.ctx.pc += 16
+	return v
+}
+
+func (vm *VM) fetchLane() int {
+	return int(uint8(vm.fetchInt8()))
+}
+
+// shape is the width in bytes of the lanes of a v128 value interpreted
+// as a vector of integers.
+type shape int
+
+const (
+	i8x16 shape = 1
+	i16x8 shape = 2
+	i32x4 shape = 4
+	i64x2 shape = 8
+)
+
+func (s shape) lanes() int {
+	return 16 / int(s)
+}
+
+// get returns the lane i of v, zero-extended.
+func (s shape) get(v *v128, i int) uint64 {
+	switch s {
+	case i8x16:
+		return uint64(v[i])
+	case i16x8:
+		return uint64(endianess.Uint16(v[2*i:]))
+	case i32x4:
+		return uint64(endianess.Uint32(v[4*i:]))
+	}
+	return endianess.Uint64(v[8*i:])
+}
+
+// getS returns the lane i of v, sign-extended.
+func (s shape) getS(v *v128, i int) int64 {
+	shift := 64 - 8*uint(s)
+	return int64(s.get(v, i)<<shift) >> shift
+}
+
+// set stores the low bits of x in the lane i of v.
+func (s shape) set(v *v128, i int, x uint64) {
+	switch s {
+	case i8x16:
+		v[i] = byte(x)
+	case i16x8:
+		endianess.PutUint16(v[2*i:], uint16(x))
+	case i32x4:
+		endianess.PutUint32(v[4*i:], uint32(x))
+	default:
+		endianess.PutUint64(v[8*i:], x)
+	}
+}
+
+// satS saturates x to the range of the signed integers of the lane width.
+func (s shape) satS(x int64) int64 {
+	max := int64(1)<<(8*uint(s)-1) - 1
+	switch {
+	case x > max:
+		return max
+	case x < -max-1:
+		return -max - 1
+	}
+	return x
+}
+
+// satU saturates x to the range of the unsigned integers of the lane width.
+func (s shape) satU(x int64) int64 {
+	max := int64(1)<<(8*uint(s)) - 1
+	switch {
+	case x > max:
+		return max
+	case x < 0:
+		return 0
+	}
+	return x
+}
+
+func f32Lane(v *v128, i int) float32 {
+	return math.Float32frombits(uint32(i32x4.get(v, i)))
+}
+
+func setF32Lane(v *v128, i int, f float32) {
+	i32x4.set(v, i, uint64(math.Float32bits(f)))
+}
+
+func f64Lane(v *v128, i int) float64 {
+	return math.Float64frombits(i64x2.get(v, i))
+}
+
+func setF64Lane(v *v128, i int, f float64) {
+	i64x2.set(v, i, math.Float64bits(f))
+}
+
+func boolMask(b bool) uint64 {
+	if b {
+		return ^uint64(0)
+	}
+	return 0
+}
+
+// simdOp executes an operator prefixed by ops.SIMDPrefix, using the
+// sub-opcode that follows the prefix.
+func (vm *VM) simdOp() {
+	vm.simdFuncTable[vm.fetchUint32()]()
+}
+
+func (vm *VM) v128Load() {
+	var v v128
+	copy(v[:], vm.curMem(16))
+	vm.pushV128(v)
+}
+
+func (vm *VM) v128Store() {
+	v := vm.popV128()
+	copy(vm.curMem(16), v[:])
+}
+
+// v128LoadExtend returns the implementation of the v128.loadNxM operators,
+// loading 8 bytes as lanes of the shape s extended to twice their width.
+func (vm *VM) v128LoadExtend(s shape, signed bool) func() {
+	return func() {
+		var v v128
+		copy(v[:], vm.curMem(8))
+		vm.pushV128(extend(s, &v, 0, signed))
+	}
+}
+
+func (vm *VM) v128LoadSplat(s shape) func() {
+	return func() {
+		var v v128
+		copy(v[:], vm.curMem(int(s)))
+		vm.pushV128(splat(s, s.get(&v, 0)))
+	}
+}
+
+func (vm *VM) v128LoadZero(s shape) func() {
+	return func() {
+		var v v128
+		copy(v[:], vm.curMem(int(s)))
+		vm.pushV128(v)
+	}
+}
+
+func (vm *VM) v128LoadLane(s shape) func() {
+	return func() {
+		v := vm.popV128()
+		mem := vm.curMem(int(s))
+		lane := vm.fetchLane()
+		copy(v[lane*int(s):], mem)
+		vm.pushV128(v)
+	}
+}
+
+func (vm *VM) v128StoreLane(s shape) func() {
+	return func() {
+		v := vm.popV128()
+		mem := vm.curMem(int(s))
+		lane := vm.fetchLane()
+		copy(mem, v[lane*int(s):])
+	}
+}
+
+func (vm *VM) v128Const() {
+	vm.pushV128(vm.fetchV128())
+}
+
+func (vm *VM) i8x16Shuffle() {
+	lanes := vm.fetchV128()
+	b := vm.popV128()
+	a := vm.popV128()
+	var r v128
+	for i, l := range lanes {
+		if l < 16 {
+			r[i] = a[l]
+		} else {
+			r[i] = b[l-16]
+		}
+	}
+	vm.pushV128(r)
+}
+
+func (vm *VM) i8x16Swizzle() {
+	s := vm.popV128()
+	a := vm.popV128()
+	var r v128
+	for i, l := range s {
+		if l < 16 {
+			r[i] = a[l]
+		}
+	}
+	vm.pushV128(r)
+}
+
+func splat(s shape, x uint64) v128 {
+	var r v128
+	for i := 0; i < s.lanes(); i++ {
+		s.set(&r, i, x)
+	}
+	return r
+}
+
+// simdSplat returns the implementation of the splat operators. The
+// operand of f32x4.splat and f64x2.splat is splatted as its bit pattern.
+func (vm *VM) simdSplat(s shape) func() {
+	return func() {
+		vm.pushV128(splat(s, vm.popUint64()))
+	}
+}
+
+func (vm *VM) extractLane(s shape, signed bool) func() {
+	return func() {
+		lane := vm.fetchLane()
+		v := vm.popV128()
+		switch {
+		case signed:
+			vm.pushUint32(uint32(s.getS(&v, lane)))
+		default:
+			vm.pushUint64(s.get(&v, lane))
+		}
+	}
+}
+
+func (vm *VM) replaceLane(s shape) func() {
+	return func() {
+		lane := vm.fetchLane()
+		x := vm.popUint64()
+		v := vm.popV128()
+		s.set(&v, lane, x)
+		vm.pushV128(v)
+	}
+}
+
+func (vm *VM) v128Not() {
+	v := vm.popValue()
+	vm.pushValue(value{lo: ^v.lo, hi: ^v.hi})
+}
+
+// v128Bitwise returns the implementation of a bitwise operator on two
+// v128 values, applying f to each half.
+func (vm *VM) v128Bitwise(f func(a, b uint64) uint64) func() {
+	return func() {
+		b := vm.popValue()
+		a := vm.popValue()
+		vm.pushValue(value{lo: f(a.lo, b.lo), hi: f(a.hi, b.hi)})
+	}
+}
+
+func (vm *VM) v128Bitselect() {
+	c := vm.popValue()
+	b := vm.popValue()
+	a := vm.popValue()
+	vm.pushValue(value{
+		lo: a.lo&c.lo | b.lo&^c.lo,
+		hi: a.hi&c.hi | b.hi&^c.hi,
+	})
+}
+
+func (vm *VM) v128AnyTrue() {
+	v := vm.popValue()
+	vm.pushBool(v.lo != 0 || v.hi != 0)
+}
+
+func (vm *VM) allTrue(s shape) func() {
+	return func() {
+		v := vm.popV128()
+		r := true
+		for i := 0; i < s.lanes(); i++ {
+			r = r && s.get(&v, i) != 0
+		}
+		vm.pushBool(r)
+	}
+}
+
+func (vm *VM) bitmask(s shape) func() {
+	return func() {
+		v := vm.popV128()
+		var r uint32
+		for i := 0; i < s.lanes(); i++ {
+			if s.getS(&v, i) < 0 {
+				r |= 1 << uint(i)
+			}
+		}
+		vm.pushUint32(r)
+	}
+}
+
+// lanewise returns the value whose lane i of the shape s is f(i).
+func lanewise(s shape, f func(i int) uint64) v128 {
+	var r v128
+	for i := 0; i < s.lanes(); i++ {
+		s.set(&r, i, f(i))
+	}
+	return r
+}
+
+func (vm *VM) binopU(s shape, f func(a, b uint64) uint64) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		vm.pushV128(lanewise(s, func(i int) uint64 {
+			return f(s.get(&a, i), s.get(&b, i))
+		}))
+	}
+}
+
+func (vm *VM) binopS(s shape, f func(a, b int64) int64) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		vm.pushV128(lanewise(s, func(i int) uint64 {
+			return uint64(f(s.getS(&a, i), s.getS(&b, i)))
+		}))
+	}
+}
+
+func (vm *VM) unopU(s shape, f func(a uint64) uint64) func() {
+	return func() {
+		a := vm.popV128()
+		vm.pushV128(lanewise(s, func(i int) uint64 {
+			return f(s.get(&a, i))
+		}))
+	}
+}
+
+func (vm *VM) unopS(s shape, f func(a int64) int64) func() {
+	return func() {
+		a := vm.popV128()
+		vm.pushV128(lanewise(s, func(i int) uint64 {
+			return uint64(f(s.getS(&a, i)))
+		}))
+	}
+}
+
+func (vm *VM) cmpU(s shape, f func(a, b uint64) bool) func() {
+	return vm.binopU(s, func(a, b uint64) uint64 {
+		return boolMask(f(a, b))
+	})
+}
+
+func (vm *VM) cmpS(s shape, f func(a, b int64) bool) func() {
+	return vm.binopS(s, func(a, b int64) int64 {
+		return int64(boolMask(f(a, b)))
+	})
+}
+
+// shift returns the implementation of a shift operator. The shift count
+// is taken modulo the lane width.
+func (vm *VM) shift(s shape, f func(a int64, n uint) int64) func() {
+	return func() {
+		n := uint(vm.popUint32()) % (8 * uint(s))
+		a := vm.popV128()
+		vm.pushV128(lanewise(s, func(i int) uint64 {
+			return uint64(f(s.getS(&a, i), n))
+		}))
+	}
+}
+
+func (vm *VM) shrU(s shape) func() {
+	return func() {
+		n := uint(vm.popUint32()) % (8 * uint(s))
+		a := vm.popV128()
+		vm.pushV128(lanewise(s, func(i int) uint64 {
+			return s.get(&a, i) >> n
+		}))
+	}
+}
+
+// extend returns the lanes of v of the shape s, starting at the lane
+// first, extended to the shape twice as wide.
+func extend(s shape, v *v128, first int, signed bool) v128 {
+	var r v128
+	d := 2 * s
+	for i := 0; i < d.lanes(); i++ {
+		if signed {
+			d.set(&r, i, uint64(s.getS(v, first+i)))
+		} else {
+			d.set(&r, i, s.get(v, first+i))
+		}
+	}
+	return r
+}
+
+func (vm *VM) extendOp(s shape, high, signed bool) func() {
+	return func() {
+		v := vm.popV128()
+		first := 0
+		if high {
+			first = s.lanes() / 2
+		}
+		vm.pushV128(extend(s, &v, first, signed))
+	}
+}
+
+func (vm *VM) extmul(s shape, high, signed bool) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		first := 0
+		if high {
+			first = s.lanes() / 2
+		}
+		a, b = extend(s, &a, first, signed), extend(s, &b, first, signed)
+		d := 2 * s
+		vm.pushV128(lanewise(d, func(i int) uint64 {
+			return d.get(&a, i) * d.get(&b, i)
+		}))
+	}
+}
+
+func (vm *VM) extaddPairwise(s shape, signed bool) func() {
+	return func() {
+		a := vm.popV128()
+		d := 2 * s
+		vm.pushV128(lanewise(d, func(i int) uint64 {
+			if signed {
+				return uint64(s.getS(&a, 2*i) + s.getS(&a, 2*i+1))
+			}
+			return s.get(&a, 2*i) + s.get(&a, 2*i+1)
+		}))
+	}
+}
+
+// narrow returns the implementation of the narrow operators, which
+// saturate the signed lanes of the shape s of both operands to the
+// shape half as wide.
+func (vm *VM) narrow(s shape, signed bool) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		d := s / 2
+		n := s.lanes()
+		vm.pushV128(lanewise(d, func(i int) uint64 {
+			var x int64
+			if i < n {
+				x = s.getS(&a, i)
+			} else {
+				x = s.getS(&b, i-n)
+			}
+			if signed {
+				return uint64(d.satS(x))
+			}
+			return uint64(d.satU(x))
+		}))
+	}
+}
+
+func (vm *VM) i32x4DotI16x8S() {
+	b := vm.popV128()
+	a := vm.popV128()
+	vm.pushV128(lanewise(i32x4, func(i int) uint64 {
+		return uint64(i16x8.getS(&a, 2*i)*i16x8.getS(&b, 2*i) + i16x8.getS(&a, 2*i+1)*i16x8.getS(&b, 2*i+1))
+	}))
+}
+
+func (vm *VM) f32x4Unop(f func(a float32) float32) func() {
+	return func() {
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 4; i++ {
+			vm.setF32LaneResult(&r, i, f(f32Lane(&a, i)))
+		}
+		vm.pushV128(r)
+	}
+}
+
+func (vm *VM) f64x2Unop(f func(a float64) float64) func() {
+	return func() {
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 2; i++ {
+			vm.setF64LaneResult(&r, i, f(f64Lane(&a, i)))
+		}
+		vm.pushV128(r)
+	}
+}
+
+func (vm *VM) f32x4Binop(f func(a, b float32) float32) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 4; i++ {
+			vm.setF32LaneResult(&r, i, f(f32Lane(&a, i), f32Lane(&b, i)))
+		}
+		vm.pushV128(r)
+	}
+}
+
+func (vm *VM) f64x2Binop(f func(a, b float64) float64) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 2; i++ {
+			vm.setF64LaneResult(&r, i, f(f64Lane(&a, i), f64Lane(&b, i)))
+		}
+		vm.pushV128(r)
+	}
+}
+
+func (vm *VM) f32x4Cmp(f func(a, b float32) bool) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		vm.pushV128(lanewise(i32x4, func(i int) uint64 {
+			return boolMask(f(f32Lane(&a, i), f32Lane(&b, i)))
+		}))
+	}
+}
+
+func (vm *VM) f64x2Cmp(f func(a, b float64) bool) func() {
+	return func() {
+		b := vm.popV128()
+		a := vm.popV128()
+		vm.pushV128(lanewise(i64x2, func(i int) uint64 {
+			return boolMask(f(f64Lane(&a, i), f64Lane(&b, i)))
+		}))
+	}
+}
+
+// f32Op and f64Op adapt a float64 function to the lanes of f32x4 and
+// f64x2 values.
+func f32Op(f func(float64) float64) func(float32) float32 {
+	return func(a float32) float32 { return float32(f(float64(a))) }
+}
+
+func f32Op2(f func(float64, float64) float64) func(float32, float32) float32 {
+	return func(a, b float32) float32 { return float32(f(float64(a), float64(b))) }
+}
+
+func (vm *VM) f32x4DemoteF64x2Zero() {
+	a := vm.popV128()
+	var r v128
+	vm.setF32LaneResult(&r, 0, float32(f64Lane(&a, 0)))
+	vm.setF32LaneResult(&r, 1, float32(f64Lane(&a, 1)))
+	vm.pushV128(r)
+}
+
+func (vm *VM) f64x2PromoteLowF32x4() {
+	a := vm.popV128()
+	var r v128
+	vm.setF64LaneResult(&r, 0, float64(f32Lane(&a, 0)))
+	vm.setF64LaneResult(&r, 1, float64(f32Lane(&a, 1)))
+	vm.pushV128(r)
+}
+
+func (vm *VM) i32x4TruncSatF32x4(signed bool) func() {
+	return func() {
+		a := vm.popV128()
+		vm.pushV128(lanewise(i32x4, func(i int) uint64 {
+			f := float64(f32Lane(&a, i))
+			if signed {
+				return uint64(truncSatS(f, math.MinInt32, math.MaxInt32))
+			}
+			return truncSatU(f, math.MaxUint32)
+		}))
+	}
+}
+
+func (vm *VM) i32x4TruncSatF64x2Zero(signed bool) func() {
+	return func() {
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 2; i++ {
+			f := f64Lane(&a, i)
+			if signed {
+				i32x4.set(&r, i, uint64(truncSatS(f, math.MinInt32, math.MaxInt32)))
+			} else {
+				i32x4.set(&r, i, truncSatU(f, math.MaxUint32))
+			}
+		}
+		vm.pushV128(r)
+	}
+}
+
+func (vm *VM) f32x4ConvertI32x4(signed bool) func() {
+	return func() {
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 4; i++ {
+			if signed {
+				setF32Lane(&r, i, float32(int32(i32x4.get(&a, i))))
+			} else {
+				setF32Lane(&r, i, float32(uint32(i32x4.get(&a, i))))
+			}
+		}
+		vm.pushV128(r)
+	}
+}
+
+func (vm *VM) f64x2ConvertLowI32x4(signed bool) func() {
+	return func() {
+		a := vm.popV128()
+		var r v128
+		for i := 0; i < 2; i++ {
+			if signed {
+				setF64Lane(&r, i, float64(int32(i32x4.get(&a, i))))
+			} else {
+				setF64Lane(&r, i, float64(uint32(i32x4.get(&a, i))))
+			}
+		}
+		vm.pushV128(r)
+	}
+}
+
+// pmin and pmax are the pseudo-minimum and pseudo-maximum of the SIMD
+// proposal, defined as b < a ? b : a and a < b ? b : a.
+func pmin(a, b float64) float64 {
+	if b < a {
+		return b
+	}
+	return a
+}
+
+func pmax(a, b float64) float64 {
+	if a < b {
+		return b
+	}
+	return a
+}
+
+func abs64(a int64) int64 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func minS(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxS(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minU(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxU(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (vm *VM) newSIMDFuncTable() {
+	t := &vm.simdFuncTable
+
+	t[ops.V128Load] = vm.v128Load
+	t[ops.V128Load8x8S] = vm.v128LoadExtend(i8x16, true)
+	t[ops.V128Load8x8U] = vm.v128LoadExtend(i8x16, false)
+	t[ops.V128Load16x4S] = vm.v128LoadExtend(i16x8, true)
+	t[ops.V128Load16x4U] = vm.v128LoadExtend(i16x8, false)
+	t[ops.V128Load32x2S] = vm.v128LoadExtend(i32x4, true)
+	t[ops.V128Load32x2U] = vm.v128LoadExtend(i32x4, false)
+	t[ops.V128Load8Splat] = vm.v128LoadSplat(i8x16)
+	t[ops.V128Load16Splat] = vm.v128LoadSplat(i16x8)
+	t[ops.V128Load32Splat] = vm.v128LoadSplat(i32x4)
+	t[ops.V128Load64Splat] = vm.v128LoadSplat(i64x2)
+	t[ops.V128Store] = vm.v128Store
+	t[ops.V128Load8Lane] = vm.v128LoadLane(i8x16)
+	t[ops.V128Load16Lane] = vm.v128LoadLane(i16x8)
+	t[ops.V128Load32Lane] = vm.v128LoadLane(i32x4)
+	t[ops.V128Load64Lane] = vm.v128LoadLane(i64x2)
+	t[ops.V128Store8Lane] = vm.v128StoreLane(i8x16)
+	t[ops.V128Store16Lane] = vm.v128StoreLane(i16x8)
+	t[ops.V128Store32Lane] = vm.v128StoreLane(i32x4)
+	t[ops.V128Store64Lane] = vm.v128StoreLane(i64x2)
+	t[ops.V128Load32Zero] = vm.v128LoadZero(i32x4)
+	t[ops.V128Load64Zero] = vm.v128LoadZero(i64x2)
+
+	t[ops.V128Const] = vm.v128Const
+	t[ops.I8x16Shuffle] = vm.i8x16Shuffle
+	t[ops.I8x16Swizzle] = vm.i8x16Swizzle
+	t[ops.I8x16Splat] = vm.simdSplat(i8x16)
+	t[ops.I16x8Splat] = vm.simdSplat(i16x8)
+	t[ops.I32x4Splat] = vm.simdSplat(i32x4)
+	t[ops.I64x2Splat] = vm.simdSplat(i64x2)
+	t[ops.F32x4Splat] = vm.simdSplat(i32x4)
+	t[ops.F64x2Splat] = vm.simdSplat(i64x2)
+	t[ops.I8x16ExtractLaneS] = vm.extractLane(i8x16, true)
+	t[ops.I8x16ExtractLaneU] = vm.extractLane(i8x16, false)
+	t[ops.I8x16ReplaceLane] = vm.replaceLane(i8x16)
+	t[ops.I16x8ExtractLaneS] = vm.extractLane(i16x8, true)
+	t[ops.I16x8ExtractLaneU] = vm.extractLane(i16x8, false)
+	t[ops.I16x8ReplaceLane] = vm.replaceLane(i16x8)
+	t[ops.I32x4ExtractLane] = vm.extractLane(i32x4, false)
+	t[ops.I32x4ReplaceLane] = vm.replaceLane(i32x4)
+	t[ops.I64x2ExtractLane] = vm.extractLane(i64x2, false)
+	t[ops.I64x2ReplaceLane] = vm.replaceLane(i64x2)
+	t[ops.F32x4ExtractLane] = vm.extractLane(i32x4, false)
+	t[ops.F32x4ReplaceLane] = vm.replaceLane(i32x4)
+	t[ops.F64x2ExtractLane] = vm.extractLane(i64x2, false)
+	t[ops.F64x2ReplaceLane] = vm.replaceLane(i64x2)
+
+	eq := func(a, b uint64) bool { return a == b }
+	ne := func(a, b uint64) bool { return a != b }
+	ltS := func(a, b int64) bool { return a < b }
+	ltU := func(a, b uint64) bool { return a < b }
+	gtS := func(a, b int64) bool { return a > b }
+	gtU := func(a, b uint64) bool { return a > b }
+	leS := func(a, b int64) bool { return a <= b }
+	leU := func(a, b uint64) bool { return a <= b }
+	geS := func(a, b int64) bool { return a >= b }
+	geU := func(a, b uint64) bool { return a >= b }
+	t[ops.I8x16Eq] = vm.cmpU(i8x16, eq)
+	t[ops.I8x16Ne] = vm.cmpU(i8x16, ne)
+	t[ops.I8x16LtS] = vm.cmpS(i8x16, ltS)
+	t[ops.I8x16LtU] = vm.cmpU(i8x16, ltU)
+	t[ops.I8x16GtS] = vm.cmpS(i8x16, gtS)
+	t[ops.I8x16GtU] = vm.cmpU(i8x16, gtU)
+	t[ops.I8x16LeS] = vm.cmpS(i8x16, leS)
+	t[ops.I8x16LeU] = vm.cmpU(i8x16, leU)
+	t[ops.I8x16GeS] = vm.cmpS(i8x16, geS)
+	t[ops.I8x16GeU] = vm.cmpU(i8x16, geU)
+	t[ops.I16x8Eq] = vm.cmpU(i16x8, eq)
+	t[ops.I16x8Ne] = vm.cmpU(i16x8, ne)
+	t[ops.I16x8LtS] = vm.cmpS(i16x8, ltS)
+	t[ops.I16x8LtU] = vm.cmpU(i16x8, ltU)
+	t[ops.I16x8GtS] = vm.cmpS(i16x8, gtS)
+	t[ops.I16x8GtU] = vm.cmpU(i16x8, gtU)
+	t[ops.I16x8LeS] = vm.cmpS(i16x8, leS)
+	t[ops.I16x8LeU] = vm.cmpU(i16x8, leU)
+	t[ops.I16x8GeS] = vm.cmpS(i16x8, geS)
+	t[ops.I16x8GeU] = vm.cmpU(i16x8, geU)
+	t[ops.I32x4Eq] = vm.cmpU(i32x4, eq)
+	t[ops.I32x4Ne] = vm.cmpU(i32x4, ne)
+	t[ops.I32x4LtS] = vm.cmpS(i32x4, ltS)
+	t[ops.I32x4LtU] = vm.cmpU(i32x4, ltU)
+	t[ops.I32x4GtS] = vm.cmpS(i32x4, gtS)
+	t[ops.I32x4GtU] = vm.cmpU(i32x4, gtU)
+	t[ops.I32x4LeS] = vm.cmpS(i32x4, leS)
+	t[ops.I32x4LeU] = vm.cmpU(i32x4, leU)
+	t[ops.I32x4GeS] = vm.cmpS(i32x4, geS)
+	t[ops.I32x4GeU] = vm.cmpU(i32x4, geU)
+	t[ops.I64x2Eq] = vm.cmpU(i64x2, eq)
+	t[ops.I64x2Ne] = vm.cmpU(i64x2, ne)
+	t[ops.I64x2LtS] = vm.cmpS(i64x2, ltS)
+	t[ops.I64x2GtS] = vm.cmpS(i64x2, gtS)
+	t[ops.I64x2LeS] = vm.cmpS(i64x2, leS)
+	t[ops.I64x2GeS] = vm.cmpS(i64x2, geS)
+
+	feq := func(a, b float64) bool { return a == b }
+	fne := func(a, b float64) bool { return a != b }
+	flt := func(a, b float64) bool { return a < b }
+	fgt := func(a, b float64) bool { return a > b }
+	fle := func(a, b float64) bool { return a <= b }
+	fge := func(a, b float64) bool { return a >= b }
+	f32Cmp := func(f func(a, b float64) bool) func(a, b float32) bool {
+		return func(a, b float32) bool { return f(float64(a), float64(b)) }
+	}
+	t[ops.F32x4Eq] = vm.f32x4Cmp(f32Cmp(feq))
+	t[ops.F32x4Ne] = vm.f32x4Cmp(f32Cmp(fne))
+	t[ops.F32x4Lt] = vm.f32x4Cmp(f32Cmp(flt))
+	t[ops.F32x4Gt] = vm.f32x4Cmp(f32Cmp(fgt))
+	t[ops.F32x4Le] = vm.f32x4Cmp(f32Cmp(fle))
+	t[ops.F32x4Ge] = vm.f32x4Cmp(f32Cmp(fge))
+	t[ops.F64x2Eq] = vm.f64x2Cmp(feq)
+	t[ops.F64x2Ne] = vm.f64x2Cmp(fne)
+	t[ops.F64x2Lt] = vm.f64x2Cmp(flt)
+	t[ops.F64x2Gt] = vm.f64x2Cmp(fgt)
+	t[ops.F64x2Le] = vm.f64x2Cmp(fle)
+	t[ops.F64x2Ge] = vm.f64x2Cmp(fge)
+
+	t[ops.V128Not] = vm.v128Not
+	t[ops.V128And] = vm.v128Bitwise(func(a, b uint64) uint64 { return a & b })
+	t[ops.V128Andnot] = vm.v128Bitwise(func(a, b uint64) uint64 { return a &^ b })
+	t[ops.V128Or] = vm.v128Bitwise(func(a, b uint64) uint64 { return a | b })
+	t[ops.V128Xor] = vm.v128Bitwise(func(a, b uint64) uint64 { return a ^ b })
+	t[ops.V128Bitselect] = vm.v128Bitselect
+	t[ops.V128AnyTrue] = vm.v128AnyTrue
+
+	add := func(a, b uint64) uint64 { return a + b }
+	sub := func(a, b uint64) uint64 { return a - b }
+	mul := func(a, b uint64) uint64 { return a * b }
+	neg := func(a int64) int64 { return -a }
+	shl := func(a int64, n uint) int64 { return a << n }
+	shrS := func(a int64, n uint) int64 { return a >> n }
+	avgrU := func(a, b uint64) uint64 { return (a + b + 1) / 2 }
+
+	t[ops.I8x16Abs] = vm.unopS(i8x16, abs64)
+	t[ops.I8x16Neg] = vm.unopS(i8x16, neg)
+	t[ops.I8x16AllTrue] = vm.allTrue(i8x16)
+	t[ops.I8x16Bitmask] = vm.bitmask(i8x16)
+	t[ops.I8x16Shl] = vm.shift(i8x16, shl)
+	t[ops.I8x16ShrS] = vm.shift(i8x16, shrS)
+	t[ops.I8x16ShrU] = vm.shrU(i8x16)
+	t[ops.I8x16Add] = vm.binopU(i8x16, add)
+	t[ops.I8x16Sub] = vm.binopU(i8x16, sub)
+	t[ops.I8x16AddSatS] = vm.binopS(i8x16, func(a, b int64) int64 { return i8x16.satS(a + b) })
+	t[ops.I8x16SubSatS] = vm.binopS(i8x16, func(a, b int64) int64 { return i8x16.satS(a - b) })
+	t[ops.I8x16AddSatU] = vm.binopU(i8x16, func(a, b uint64) uint64 { return uint64(i8x16.satU(int64(a + b))) })
+	t[ops.I8x16SubSatU] = vm.binopU(i8x16, func(a, b uint64) uint64 { return uint64(i8x16.satU(int64(a) - int64(b))) })
+	t[ops.I8x16AvgrU] = vm.binopU(i8x16, avgrU)
+	t[ops.I8x16MinS] = vm.binopS(i8x16, minS)
+	t[ops.I8x16MinU] = vm.binopU(i8x16, minU)
+	t[ops.I8x16MaxS] = vm.binopS(i8x16, maxS)
+	t[ops.I8x16MaxU] = vm.binopU(i8x16, maxU)
+
+	t[ops.I16x8Abs] = vm.unopS(i16x8, abs64)
+	t[ops.I16x8Neg] = vm.unopS(i16x8, neg)
+	t[ops.I16x8AllTrue] = vm.allTrue(i16x8)
+	t[ops.I16x8Bitmask] = vm.bitmask(i16x8)
+	t[ops.I16x8Shl] = vm.shift(i16x8, shl)
+	t[ops.I16x8ShrS] = vm.shift(i16x8, shrS)
+	t[ops.I16x8ShrU] = vm.shrU(i16x8)
+	t[ops.I16x8Add] = vm.binopU(i16x8, add)
+	t[ops.I16x8Sub] = vm.binopU(i16x8, sub)
+	t[ops.I16x8Mul] = vm.binopU(i16x8, mul)
+	t[ops.I16x8AddSatS] = vm.binopS(i16x8, func(a, b int64) int64 { return i16x8.satS(a + b) })
+	t[ops.I16x8SubSatS] = vm.binopS(i16x8, func(a, b int64) int64 { return i16x8.satS(a - b) })
+	t[ops.I16x8AddSatU] = vm.binopU(i16x8, func(a, b uint64) uint64 { return uint64(i16x8.satU(int64(a + b))) })
+	t[ops.I16x8SubSatU] = vm.binopU(i16x8, func(a, b uint64) uint64 { return uint64(i16x8.satU(int64(a) - int64(b))) })
+	t[ops.I16x8AvgrU] = vm.binopU(i16x8, avgrU)
+	t[ops.I16x8MinS] = vm.binopS(i16x8, minS)
+	t[ops.I16x8MinU] = vm.binopU(i16x8, minU)
+	t[ops.I16x8MaxS] = vm.binopS(i16x8, maxS)
+	t[ops.I16x8MaxU] = vm.binopU(i16x8, maxU)
+
+	t[ops.I32x4Abs] = vm.unopS(i32x4, abs64)
+	t[ops.I32x4Neg] = vm.unopS(i32x4, neg)
+	t[ops.I32x4AllTrue] = vm.allTrue(i32x4)
+	t[ops.I32x4Bitmask] = vm.bitmask(i32x4)
+	t[ops.I32x4Shl] = vm.shift(i32x4, shl)
+	t[ops.I32x4ShrS] = vm.shift(i32x4, shrS)
+	t[ops.I32x4ShrU] = vm.shrU(i32x4)
+	t[ops.I32x4Add] = vm.binopU(i32x4, add)
+	t[ops.I32x4Sub] = vm.binopU(i32x4, sub)
+	t[ops.I32x4Mul] = vm.binopU(i32x4, mul)
+	t[ops.I32x4MinS] = vm.binopS(i32x4, minS)
+	t[ops.I32x4MinU] = vm.binopU(i32x4, minU)
+	t[ops.I32x4MaxS] = vm.binopS(i32x4, maxS)
+	t[ops.I32x4MaxU] = vm.binopU(i32x4, maxU)
+
+	t[ops.I64x2Abs] = vm.unopS(i64x2, abs64)
+	t[ops.I64x2Neg] = vm.unopS(i64x2, neg)
+	t[ops.I64x2AllTrue] = vm.allTrue(i64x2)
+	t[ops.I64x2Bitmask] = vm.bitmask(i64x2)
+	t[ops.I64x2Shl] = vm.shift(i64x2, shl)
+	t[ops.I64x2ShrS] = vm.shift(i64x2, shrS)
+	t[ops.I64x2ShrU] = vm.shrU(i64x2)
+	t[ops.I64x2Add] = vm.binopU(i64x2, add)
+	t[ops.I64x2Sub] = vm.binopU(i64x2, sub)
+	t[ops.I64x2Mul] = vm.binopU(i64x2, mul)
+
+	t[ops.I8x16Popcnt] = vm.unopU(i8x16, func(a uint64) uint64 { return uint64(bits.OnesCount8(uint8(a))) })
+	t[ops.I16x8Q15mulrSatS] = vm.binopS(i16x8, func(a, b int64) int64 { return i16x8.satS((a*b + 0x4000) >> 15) })
+	t[ops.I32x4DotI16x8S] = vm.i32x4DotI16x8S
+	t[ops.I8x16NarrowI16x8S] = vm.narrow(i16x8, true)
+	t[ops.I8x16NarrowI16x8U] = vm.narrow(i16x8, false)
+	t[ops.I16x8NarrowI32x4S] = vm.narrow(i32x4, true)
+	t[ops.I16x8NarrowI32x4U] = vm.narrow(i32x4, false)
+
+	t[ops.I16x8ExtendLowI8x16S] = vm.extendOp(i8x16, false, true)
+	t[ops.I16x8ExtendLowI8x16U] = vm.extendOp(i8x16, false, false)
+	t[ops.I16x8ExtendHighI8x16S] = vm.extendOp(i8x16, true, true)
+	t[ops.I16x8ExtendHighI8x16U] = vm.extendOp(i8x16, true, false)
+	t[ops.I16x8ExtmulLowI8x16S] = vm.extmul(i8x16, false, true)
+	t[ops.I16x8ExtmulLowI8x16U] = vm.extmul(i8x16, false, false)
+	t[ops.I16x8ExtmulHighI8x16S] = vm.extmul(i8x16, true, true)
+	t[ops.I16x8ExtmulHighI8x16U] = vm.extmul(i8x16, true, false)
+	t[ops.I16x8ExtaddPairwiseI8x16S] = vm.extaddPairwise(i8x16, true)
+	t[ops.I16x8ExtaddPairwiseI8x16U] = vm.extaddPairwise(i8x16, false)
+
+	t[ops.I32x4ExtendLowI16x8S] = vm.extendOp(i16x8, false, true)
+	t[ops.I32x4ExtendLowI16x8U] = vm.extendOp(i16x8, false, false)
+	t[ops.I32x4ExtendHighI16x8S] = vm.extendOp(i16x8, true, true)
+	t[ops.I32x4ExtendHighI16x8U] = vm.extendOp(i16x8, true, false)
+	t[ops.I32x4ExtmulLowI16x8S] = vm.extmul(i16x8, false, true)
+	t[ops.I32x4ExtmulLowI16x8U] = vm.extmul(i16x8, false, false)
+	t[ops.I32x4ExtmulHighI16x8S] = vm.extmul(i16x8, true, true)
+	t[ops.I32x4ExtmulHighI16x8U] = vm.extmul(i16x8, true, false)
+	t[ops.I32x4ExtaddPairwiseI16x8S] = vm.extaddPairwise(i16x8, true)
+	t[ops.I32x4ExtaddPairwiseI16x8U] = vm.extaddPairwise(i16x8, false)
+
+	t[ops.I64x2ExtendLowI32x4S] = vm.extendOp(i32x4, false, true)
+	t[ops.I64x2ExtendLowI32x4U] = vm.extendOp(i32x4, false, false)
+	t[ops.I64x2ExtendHighI32x4S] = vm.extendOp(i32x4, true, true)
+	t[ops.I64x2ExtendHighI32x4U] = vm.extendOp(i32x4, true, false)
+	t[ops.I64x2ExtmulLowI32x4S] = vm.extmul(i32x4, false, true)
+	t[ops.I64x2ExtmulLowI32x4U] = vm.extmul(i32x4, false, false)
+	t[ops.I64x2ExtmulHighI32x4S] = vm.extmul(i32x4, true, true)
+	t[ops.I64x2ExtmulHighI32x4U] = vm.extmul(i32x4, true, false)
+
+	// like the scalar operators, abs and neg only change the sign bit.
+	t[ops.F32x4Abs] = vm.unopU(i32x4, func(a uint64) uint64 { return a &^ signBit32 })
+	t[ops.F32x4Neg] = vm.unopU(i32x4, func(a uint64) uint64 { return a ^ signBit32 })
+	t[ops.F32x4Sqrt] = vm.f32x4Unop(f32Op(math.Sqrt))
+	t[ops.F32x4Ceil] = vm.f32x4Unop(f32Op(math.Ceil))
+	t[ops.F32x4Floor] = vm.f32x4Unop(f32Op(math.Floor))
+	t[ops.F32x4Trunc] = vm.f32x4Unop(f32Op(math.Trunc))
+	t[ops.F32x4Nearest] = vm.f32x4Unop(f32Op(math.RoundToEven))
+	t[ops.F32x4Add] = vm.f32x4Binop(func(a, b float32) float32 { return a + b })
+	t[ops.F32x4Sub] = vm.f32x4Binop(func(a, b float32) float32 { return a - b })
+	t[ops.F32x4Mul] = vm.f32x4Binop(func(a, b float32) float32 { return a * b })
+	t[ops.F32x4Div] = vm.f32x4Binop(func(a, b float32) float32 { return a / b })
+	t[ops.F32x4Min] = vm.f32x4Binop(f32Op2(fmin))
+	t[ops.F32x4Max] = vm.f32x4Binop(f32Op2(fmax))
+	t[ops.F32x4Pmin] = vm.f32x4Binop(f32Op2(pmin))
+	t[ops.F32x4Pmax] = vm.f32x4Binop(f32Op2(pmax))
+
+	t[ops.F64x2Abs] = vm.unopU(i64x2, func(a uint64) uint64 { return a &^ signBit64 })
+	t[ops.F64x2Neg] = vm.unopU(i64x2, func(a uint64) uint64 { return a ^ signBit64 })
+	t[ops.F64x2Sqrt] = vm.f64x2Unop(math.Sqrt)
+	t[ops.F64x2Ceil] = vm.f64x2Unop(math.Ceil)
+	t[ops.F64x2Floor] = vm.f64x2Unop(math.Floor)
+	t[ops.F64x2Trunc] = vm.f64x2Unop(math.Trunc)
+	t[ops.F64x2Nearest] = vm.f64x2Unop(math.RoundToEven)
+	t[ops.F64x2Add] = vm.f64x2Binop(func(a, b float64) float64 { return a + b })
+	t[ops.F64x2Sub] = vm.f64x2Binop(func(a, b float64) float64 { return a - b })
+	t[ops.F64x2Mul] = vm.f64x2Binop(func(a, b float64) float64 { return a * b })
+	t[ops.F64x2Div] = vm.f64x2Binop(func(a, b float64) float64 { return a / b })
+	t[ops.F64x2Min] = vm.f64x2Binop(fmin)
+	t[ops.F64x2Max] = vm.f64x2Binop(fmax)
+	t[ops.F64x2Pmin] = vm.f64x2Binop(pmin)
+	t[ops.F64x2Pmax] = vm.f64x2Binop(pmax)
+
+	t[ops.F32x4DemoteF64x2Zero] = vm.f32x4DemoteF64x2Zero
+	t[ops.F64x2PromoteLowF32x4] = vm.f64x2PromoteLowF32x4
+	t[ops.I32x4TruncSatF32x4S] = vm.i32x4TruncSatF32x4(true)
+	t[ops.I32x4TruncSatF32x4U] = vm.i32x4TruncSatF32x4(false)
+	t[ops.F32x4ConvertI32x4S] = vm.f32x4ConvertI32x4(true)
+	t[ops.F32x4ConvertI32x4U] = vm.f32x4ConvertI32x4(false)
+	t[ops.I32x4TruncSatF64x2SZero] = vm.i32x4TruncSatF64x2Zero(true)
+	t[ops.I32x4TruncSatF64x2UZero] = vm.i32x4TruncSatF64x2Zero(false)
+	t[ops.F64x2ConvertLowI32x4S] = vm.f64x2ConvertLowI32x4(true)
+	t[ops.F64x2ConvertLowI32x4U] = vm.f64x2ConvertLowI32x4(false)
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+)
+
+const vectorMinMaxWat = `(module
+  (memory 1)
+  (func (export "f32x4") (param $a f32) (param $b f32)
+    (v128.store (i32.const 0) (f32x4.min (f32x4.splat (local.get $a)) (f32x4.splat (local.get $b))))
+    (v128.store (i32.const 16) (f32x4.max (f32x4.splat (local.get $a)) (f32x4.splat (local.get $b)))))
+  (func (export "f64x2") (param $a f64) (param $b f64)
+    (v128.store (i32.const 0) (f64x2.min (f64x2.splat (local.get $a)) (f64x2.splat (local.get $b))))
+    (v128.store (i32.const 16) (f64x2.max (f64x2.splat (local.get $a)) (f64x2.splat (local.get $b))))))
+`
+
+// TestVectorMinMaxNaN checks that the lanes of min and max are NaN when
+// either operand is NaN, even if the other one is infinite.
+func TestVectorMinMaxNaN(t *testing.T) {
+	m := readWat(t, vectorMinMaxWat, nil)
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inf := range []float64{math.Inf(-1), math.Inf(1)} {
+		for _, args := range [][2]float64{{math.NaN(), inf}, {inf, math.NaN()}} {
+			if _, err := call(vm, m, "f32x4", uint64(math.Float32bits(float32(args[0]))), uint64(math.Float32bits(float32(args[1])))); err != nil {
+				t.Fatal(err)
+			}
+			mem := vm.Memory()
+			for off := 0; off < 32; off += 4 {
+				if got := math.Float32frombits(binary.LittleEndian.Uint32(mem[off:])); got == got {
+					t.Errorf("f32x4(%v, %v) at %d = %v, want NaN", args[0], args[1], off, got)
+				}
+			}
+
+			if _, err := call(vm, m, "f64x2", math.Float64bits(args[0]), math.Float64bits(args[1])); err != nil {
+				t.Fatal(err)
+			}
+			for off := 0; off < 32; off += 8 {
+				if got := math.Float64frombits(binary.LittleEndian.Uint64(mem[off:])); got == got {
+					t.Errorf("f64x2(%v, %v) at %d = %v, want NaN", args[0], args[1], off, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"math"
+	"reflect"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ErrOutOfBoundsTableAccess is the error value used while trapping the VM
+// when it detects an out of bounds access to a table or element segment.
+var ErrOutOfBoundsTableAccess = errors.New("exec: out of bounds table access")
+
+// maxTableSize is the maximum number of elements of a table, table.grow
+// fails beyond it. It is the limit used by the JavaScript API.
+const maxTableSize = 10000000
+
+// newTables creates the tables of the VM from the table index space of
+// module. Imported tables are copied as is, while the tables defined by
+// the module, which come last in the index space, are filled by its
+// active element segments, the other elements being null references.
+// Each table has at least the initial size declared in the module.
+func (vm *VM) newTables(module *wasm.Module) error {
+	vm.tables = make([][]uint64, len(module.TableIndexSpace))
+	first := len(module.TableIndexSpace) // index of the first table defined by the module
+	if module.Table != nil {
+		first -= len(module.Table.Entries)
+	}
+	for i, elems := range module.TableIndexSpace {
+		if i >= first {
+			size := len(elems)
+			if initial := int(module.Table.Entries[i-first].Limits.Initial); initial > size {
+				size = initial
+			}
+			vm.tables[i] = make([]uint64, size)
+			continue
+		}
+		table := make([]uint64, len(elems))
+		for j, index := range elems {
+			table[j] = uint64(index) + 1
+		}
+		vm.tables[i] = table
+	}
+
+	if module.Elements == nil {
+		return nil
+	}
+	for _, seg := range module.Elements.Entries {
+		if seg.Mode != wasm.SegmentActive || int(seg.Index) < first {
+			continue
+		}
+		if int(seg.Index) >= len(vm.tables) {
+			return wasm.InvalidTableIndexError(seg.Index)
+		}
+		val, err := module.ExecInitExpr(seg.Offset)
+		if err != nil {
+			return err
+		}
+		offset, ok := val.(int32)
+		if !ok {
+			return wasm.InvalidValueTypeInitExprError{Wanted: reflect.Int32, Got: reflect.ValueOf(val).Kind()}
+		}
+		refs, err := elemRefs(module, seg)
+		if err != nil {
+			return err
+		}
+		table := vm.tables[seg.Index]
+		if uint64(uint32(offset))+uint64(len(refs)) > uint64(len(table)) {
+			return ErrOutOfBoundsTableAccess
+		}
+		copy(table[uint32(offset):], refs)
+	}
+	return nil
+}
+
+// elemRefs returns the references stored in the element segment seg of
+// module.
+func elemRefs(module *wasm.Module, seg wasm.ElementSegment) ([]uint64, error) {
+	refs := make([]uint64, seg.Len())
+	for i := range refs {
+		index, ok, err := seg.Elem(i)
+		if err != nil {
+			return nil, err
+		}
+		if ok && module.GetFunction(int(index)) == nil {
+			return nil, wasm.InvalidFunctionIndexError(index)
+		}
+		if ok {
+			refs[i] = uint64(index) + 1
+		}
+	}
+	return refs, nil
+}
+
+// tableRangeCheck traps the VM if the n elements starting at index are not
+// all in bounds of a table (or segment) of size size.
+func tableRangeCheck(index, n uint32, size int) {
+	if uint64(index)+uint64(n) > uint64(size) {
+		panic(ErrOutOfBoundsTableAccess)
+	}
+}
+
+func (vm *VM) tableInit() {
+	elems := vm.elems[vm.fetchUint32()]
+	table := vm.tables[vm.fetchUint32()]
+	n := vm.popUint32()
+	src := vm.popUint32()
+	dst := vm.popUint32()
+	tableRangeCheck(src, n, len(elems))
+	tableRangeCheck(dst, n, len(table))
+	copy(table[dst:], elems[src:src+n])
+}
+
+func (vm *VM) elemDrop() {
+	vm.elems[vm.fetchUint32()] = nil
+}
+
+func (vm *VM) tableCopy() {
+	dstTable := vm.tables[vm.fetchUint32()]
+	srcTable := vm.tables[vm.fetchUint32()]
+	n := vm.popUint32()
+	src := vm.popUint32()
+	dst := vm.popUint32()
+	tableRangeCheck(src, n, len(srcTable))
+	tableRangeCheck(dst, n, len(dstTable))
+	copy(dstTable[dst:], srcTable[src:src+n])
+}
+
+func (vm *VM) tableGet() {
+	table := vm.tables[vm.fetchUint32()]
+	index := vm.popUint32()
+	tableRangeCheck(index, 1, len(table))
+	vm.pushUint64(table[index])
+}
+
+func (vm *VM) tableSet() {
+	table := vm.tables[vm.fetchUint32()]
+	ref := vm.popUint64()
+	index := vm.popUint32()
+	tableRangeCheck(index, 1, len(table))
+	table[index] = ref
+}
+
+func (vm *VM) tableSize() {
+	vm.pushUint32(uint32(len(vm.tables[vm.fetchUint32()])))
+}
+
+// tableGrow pushes the previous size of the table, or -1 if it could not
+// be grown past its maximum size.
+func (vm *VM) tableGrow() {
+	tableIndex := vm.fetchUint32()
+	n := vm.popUint32()
+	ref := vm.popUint64()
+	table := vm.tables[tableIndex]
+
+	max := uint64(math.MaxUint32)
+	if t := vm.module.GetTable(int(tableIndex)); t != nil && t.Limits.Flags&0x1 != 0 {
+		max = uint64(t.Limits.Maximum)
+	}
+	size := uint64(len(table)) + uint64(n)
+	if size > max || size > maxTableSize {
+		vm.pushInt32(-1)
+		return
+	}
+
+	vm.pushUint32(uint32(len(table)))
+	for i := uint32(0); i < n; i++ {
+		table = append(table, ref)
+	}
+	vm.tables[tableIndex] = table
+}
+
+func (vm *VM) tableFill() {
+	table := vm.tables[vm.fetchUint32()]
+	n := vm.popUint32()
+	ref := vm.popUint64()
+	index := vm.popUint32()
+	tableRangeCheck(index, n, len(table))
+	for i := index; i < index+n; i++ {
+		table[i] = ref
+	}
+}
@@ -16298,7 +16298,7 @@
     unreachable)
   (func (;93;) (type 5)
     unreachable)
-  (table (;0;) 53 53 anyfunc)
+  (table (;0;) 53 53 funcref)
   (memory (;0;) 17)
   (export "memory" (memory 0))
   (export "sample" (func 1))
@@ -0,0 +1,82 @@
+(module
+  (type (;0;) (func (result i32)))
+  (type (;1;) (func (param i32) (result i32)))
+  (type (;2;) (func))
+  (func (;0;) (type 0) (result i32)
+    i32.const 16
+    i32.const 0
+    i32.const 5
+    memory.init 1
+    i32.const 20
+    i32.load8_u)
+  (func (;1;) (type 0) (result i32)
+    i32.const 42)
+  (func (;2;) (type 0) (result i32)
+    i32.const 7)
+  (func (;3;) (type 0) (result i32)
+    i32.const 1
+    i32.const 0
+    i32.const 4
+    memory.copy
+    i32.const 0
+    i32.load)
+  (func (;4;) (type 0) (result i32)
+    i32.const 100
+    i32.const 171
+    i32.const 4
+    memory.fill
+    i32.const 100
+    i32.load)
+  (func (;5;) (type 2)
+    i32.const 65535
+    i32.const 0
+    i32.const 5
+    memory.init 1)
+  (func (;6;) (type 2)
+    i32.const 65535
+    i32.const 0
+    i32.const 2
+    memory.fill)
+  (func (;7;) (type 2)
+    data.drop 1
+    i32.const 0
+    i32.const 0
+    i32.const 1
+    memory.init 1)
+  (func (;8;) (type 1) (param i32) (result i32)
+    get_local 0
+    call_indirect (type 0))
+  (func (;9;) (type 2)
+    i32.const 1
+    i32.const 0
+    i32.const 2
+    table.init 0)
+  (func (;10;) (type 2)
+    i32.const 0
+    i32.const 2
+    i32.const 1
+    table.copy)
+  (func (;11;) (type 2)
+    elem.drop 0
+    i32.const 0
+    i32.const 0
+    i32.const 1
+    table.init 0)
+  (table (;0;) 3 funcref)
+  (memory (;0;) 1)
+  (export "init_load" (func 0))
+  (export "f42" (func 1))
+  (export "f7" (func 2))
+  (export "copy" (func 3))
+  (export "fill" (func 4))
+  (export "init_oob" (func 5))
+  (export "fill_oob" (func 6))
+  (export "drop_init" (func 7))
+  (export "call" (func 8))
+  (export "table_init" (func 9))
+  (export "table_copy" (func 10))
+  (export "elem_drop" (func 11))
+  (elem func 1 2)
+  (elem (i32.const 0) 1)
+  (data (i32.const 0) "\01\02\03\04")
+  (data "hello"))
@@ -0,0 +1,59 @@
+(module
+  (type (;0;) (func (param i32) (result i32)))
+  (type (;1;) (func (param i64) (result i64)))
+  (type (;2;) (func (param f32) (result i32)))
+  (type (;3;) (func (param f64) (result i32)))
+  (type (;4;) (func (param f32) (result i64)))
+  (type (;5;) (func (param f64) (result i64)))
+  (func (;0;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.extend8_s)
+  (func (;1;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.extend16_s)
+  (func (;2;) (type 1) (param i64) (result i64)
+    get_local 0
+    i64.extend8_s)
+  (func (;3;) (type 1) (param i64) (result i64)
+    get_local 0
+    i64.extend16_s)
+  (func (;4;) (type 1) (param i64) (result i64)
+    get_local 0
+    i64.extend32_s)
+  (func (;5;) (type 2) (param f32) (result i32)
+    get_local 0
+    i32.trunc_sat_f32_s)
+  (func (;6;) (type 2) (param f32) (result i32)
+    get_local 0
+    i32.trunc_sat_f32_u)
+  (func (;7;) (type 3) (param f64) (result i32)
+    get_local 0
+    i32.trunc_sat_f64_s)
+  (func (;8;) (type 3) (param f64) (result i32)
+    get_local 0
+    i32.trunc_sat_f64_u)
+  (func (;9;) (type 4) (param f32) (result i64)
+    get_local 0
+    i64.trunc_sat_f32_s)
+  (func (;10;) (type 4) (param f32) (result i64)
+    get_local 0
+    i64.trunc_sat_f32_u)
+  (func (;11;) (type 5) (param f64) (result i64)
+    get_local 0
+    i64.trunc_sat_f64_s)
+  (func (;12;) (type 5) (param f64) (result i64)
+    get_local 0
+    i64.trunc_sat_f64_u)
+  (export "i32.extend8_s" (func 0))
+  (export "i32.extend16_s" (func 1))
+  (export "i64.extend8_s" (func 2))
+  (export "i64.extend16_s" (func 3))
+  (export "i64.extend32_s" (func 4))
+  (export "i32.trunc_sat_f32_s" (func 5))
+  (export "i32.trunc_sat_f32_u" (func 6))
+  (export "i32.trunc_sat_f64_s" (func 7))
+  (export "i32.trunc_sat_f64_u" (func 8))
+  (export "i64.trunc_sat_f32_s" (func 9))
+  (export "i64.trunc_sat_f32_u" (func 10))
+  (export "i64.trunc_sat_f64_s" (func 11))
+  (export "i64.trunc_sat_f64_u" (func 12)))
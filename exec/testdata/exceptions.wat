@@ -0,0 +1,161 @@
+(module
+  (type (;0;) (func (param i32) (result i32)))
+  (type (;1;) (func (result i32)))
+  (type (;2;) (func (param i32)))
+  (type (;3;) (func))
+  (func (;0;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      get_local 0
+      throw 0
+    catch 0
+      i32.const 1
+      i32.add
+    end)
+  (func (;1;) (type 2) (param i32)
+    get_local 0
+    throw 0)
+  (func (;2;) (type 0) (param i32) (result i32)
+    i32.const 100
+    try (result i32)  ;; label = @1
+      i32.const 5
+      i32.const 6
+      get_local 0
+      call 1
+      i32.add
+    catch 0
+    end
+    i32.add)
+  (func (;3;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      get_local 0
+      call 1
+      i32.const 0
+    catch 1
+      i32.const 1
+    catch_all
+      i32.const 2
+    end)
+  (func (;4;) (type 3)
+    throw 1)
+  (func (;5;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      try  ;; label = @2
+        get_local 0
+        throw 0
+      catch 0
+        drop
+        rethrow 0 (;@2;)
+      end
+      i32.const 0
+    catch 0
+      i32.const 10
+      i32.add
+    end)
+  (func (;6;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      try  ;; label = @2
+        get_local 0
+        throw 0
+      delegate 0 (;@1;)
+      i32.const 0
+    catch 0
+      i32.const 20
+      i32.add
+    end)
+  (func (;7;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      block  ;; label = @2
+        try  ;; label = @3
+          get_local 0
+          call 1
+        delegate 1 (;@1;)
+      end
+      i32.const 0
+    catch 0
+    end)
+  (func (;8;) (type 2) (param i32)
+    try  ;; label = @1
+      get_local 0
+      call 1
+    delegate 0 (;@0;))
+  (func (;9;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      get_local 0
+      call 8
+      i32.const 0
+    catch 0
+    end)
+  (func (;10;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      try (result i32)  ;; label = @2
+        get_local 0
+        call 1
+        i32.const 0
+      catch 1
+        i32.const 1
+      end
+    catch 0
+      i32.const 3
+      i32.add
+    end)
+  (func (;11;) (type 0) (param i32) (result i32)
+    (local i32)
+    loop  ;; label = @1
+      try  ;; label = @2
+        get_local 0
+        call 1
+      catch 0
+        drop
+      end
+      get_local 1
+      i32.const 1
+      i32.add
+      set_local 1
+      get_local 1
+      i32.const 10
+      i32.lt_u
+      br_if 0 (;@1;)
+    end
+    get_local 1)
+  (func (;12;) (type 0) (param i32) (result i32)
+    try (result i32)  ;; label = @1
+      try (result i32)  ;; label = @2
+        get_local 0
+        throw 0
+      catch 0
+        i32.const 1
+        i32.add
+        throw 0
+      end
+    catch 0
+      i32.const 100
+      i32.add
+    end)
+  (func (;13;) (type 0) (param i32) (result i32)
+    i32.const 10
+    i32.const 20
+    get_local 0
+    if (result i32)  ;; label = @1
+      i32.const 2
+    else
+      i32.const 3
+    end
+    i32.add
+    i32.add)
+  (tag (;0;) (type 2) (param i32))
+  (tag (;1;) (type 3))
+  (export "throw_catch" (func 0))
+  (export "e0" (tag 0))
+  (export "thrower" (func 1))
+  (export "catch_callee" (func 2))
+  (export "catch_all" (func 3))
+  (export "uncaught" (func 4))
+  (export "rethrow" (func 5))
+  (export "delegate" (func 6))
+  (export "delegate_block" (func 7))
+  (export "delegate_out" (func 8))
+  (export "catch_delegate_out" (func 9))
+  (export "nested" (func 10))
+  (export "loop_catch" (func 11))
+  (export "throw_in_catch" (func 12))
+  (export "if_else" (func 13)))
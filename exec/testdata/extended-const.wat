@@ -0,0 +1,41 @@
+(module
+  (type (;0;) (func (result i32)))
+  (type (;1;) (func (result i64)))
+  (func (;0;) (type 0) (result i32)
+    get_global 1)
+  (func (;1;) (type 0) (result i32)
+    i32.const 16
+    i32.load)
+  (func (;2;) (type 0) (result i32)
+    i32.const 1
+    call_indirect (type 0))
+  (func (;3;) (type 1) (result i64)
+    get_global 2)
+  (func (;4;) (type 0) (result i32)
+    get_global 3
+    ref.is_null)
+  (func (;5;) (type 0) (result i32)
+    get_global 4
+    ref.is_null)
+  (func (;6;) (type 0) (result i32)
+    i32.const 0
+    get_global 3
+    table.set 0
+    i32.const 0
+    call_indirect (type 0))
+  (global (;0;) i32 (i32.const 8))
+  (global (;1;) i32 (i32.add (get_global 0) (i32.const 4)))
+  (global (;2;) i64 (i64.sub (i64.mul (i64.const 3) (i64.const 5)) (i64.const 1)))
+  (global (;3;) funcref (ref.func 1))
+  (global (;4;) funcref (ref.null func))
+  (table (;0;) 2 funcref)
+  (memory (;0;) 1)
+  (export "get1" (func 0))
+  (export "load16" (func 1))
+  (export "call_elem" (func 2))
+  (export "get2" (func 3))
+  (export "is_null3" (func 4))
+  (export "is_null4" (func 5))
+  (export "call3" (func 6))
+  (elem (i32.sub (i32.const 2) (i32.const 1)) 1)
+  (data (i32.mul (get_global 0) (i32.const 2)) "*\00\00\00"))
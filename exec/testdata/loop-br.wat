@@ -0,0 +1,32 @@
+(module
+  (type (;0;) (func (param i32) (result i32)))
+  (func (;0;) (type 0) (param i32) (result i32)
+    (local i32)
+    loop (result i32)  ;; label = @1
+      get_local 1
+      i32.const 1
+      i32.add
+      set_local 1
+      get_local 1
+      get_local 0
+      i32.lt_u
+      br_if 0 (;@1;)
+      get_local 1
+    end)
+  (func (;1;) (type 0) (param i32) (result i32)
+    (local i32)
+    loop (result i32)  ;; label = @1
+      get_local 1
+      i32.const 1
+      i32.add
+      set_local 1
+      block  ;; label = @2
+        get_local 1
+        get_local 0
+        i32.ge_u
+        br_table 1 (;@1;) 0 (;@2;)
+      end
+      get_local 1
+    end)
+  (export "br_if" (func 0))
+  (export "br_table" (func 1)))
@@ -0,0 +1,45 @@
+(module
+  (type (;0;) (func (result i32)))
+  (type (;1;) (func (param i64 i32) (result i32)))
+  (type (;2;) (func (result i64)))
+  (type (;3;) (func (param i64) (result i64)))
+  (func (;0;) (type 0) (result i32)
+    i64.const 16
+    i32.load)
+  (func (;1;) (type 1) (param i64 i32) (result i32)
+    get_local 0
+    get_local 1
+    i32.store
+    get_local 0
+    i32.load)
+  (func (;2;) (type 0) (result i32)
+    i64.const 4294967296
+    i32.load)
+  (func (;3;) (type 0) (result i32)
+    i64.const -1
+    i32.load offset=16)
+  (func (;4;) (type 2) (result i64)
+    memory.size)
+  (func (;5;) (type 3) (param i64) (result i64)
+    get_local 0
+    memory.grow)
+  (func (;6;) (type 0) (result i32)
+    i64.const 256
+    i32.const 7
+    i64.const 4
+    memory.fill
+    i64.const 512
+    i64.const 256
+    i64.const 4
+    memory.copy
+    i64.const 512
+    i32.load)
+  (memory (;0;) i64 1 2)
+  (export "load_data" (func 0))
+  (export "store_load" (func 1))
+  (export "load_4gib" (func 2))
+  (export "load_overflow" (func 3))
+  (export "size" (func 4))
+  (export "grow" (func 5))
+  (export "fill_copy" (func 6))
+  (data (i64.const 16) "*\00\00\00"))
@@ -0,0 +1,23 @@
+(module
+  (type (;0;) (func (param f32 f32) (result f32)))
+  (type (;1;) (func (param f64 f64) (result f64)))
+  (func (;0;) (type 0) (param f32 f32) (result f32)
+    get_local 0
+    get_local 1
+    f32.min)
+  (func (;1;) (type 0) (param f32 f32) (result f32)
+    get_local 0
+    get_local 1
+    f32.max)
+  (func (;2;) (type 1) (param f64 f64) (result f64)
+    get_local 0
+    get_local 1
+    f64.min)
+  (func (;3;) (type 1) (param f64 f64) (result f64)
+    get_local 0
+    get_local 1
+    f64.max)
+  (export "f32.min" (func 0))
+  (export "f32.max" (func 1))
+  (export "f64.min" (func 2))
+  (export "f64.max" (func 3)))
@@ -0,0 +1,67 @@
+(module
+  (type (;0;) (func (param i32) (result i32)))
+  (type (;1;) (func (param i32 i32)))
+  (type (;2;) (func (result i32)))
+  (func (;0;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.load)
+  (func (;1;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.load 1)
+  (func (;2;) (type 1) (param i32 i32)
+    get_local 0
+    get_local 1
+    i32.store 1)
+  (func (;3;) (type 2) (result i32)
+    memory.size 1)
+  (func (;4;) (type 0) (param i32) (result i32)
+    get_local 0
+    memory.grow 1)
+  (func (;5;) (type 2) (result i32)
+    memory.size)
+  (func (;6;) (type 2) (result i32)
+    i32.const 16
+    i32.const 0
+    i32.const 4
+    memory.copy 0 1
+    i32.const 16
+    i32.load)
+  (func (;7;) (type 2) (result i32)
+    i64.const 32
+    i32.const 0
+    i32.const 4
+    memory.copy 2 1
+    i64.const 32
+    i32.load 2)
+  (func (;8;) (type 2) (result i32)
+    i64.const 8
+    i32.const 5
+    i64.const 1
+    memory.fill 2
+    i64.const 8
+    i32.load8_u 2)
+  (func (;9;) (type 2) (result i32)
+    i32.const 100
+    i32.const 0
+    i32.const 4
+    memory.init 1 1
+    i32.const 100
+    i32.load 1)
+  (memory (;0;) 1)
+  (memory (;1;) 1 2)
+  (memory (;2;) i64 1)
+  (export "load0" (func 0))
+  (export "mem0" (memory 0))
+  (export "load1" (func 1))
+  (export "mem1" (memory 1))
+  (export "store1" (func 2))
+  (export "mem2" (memory 2))
+  (export "size1" (func 3))
+  (export "grow1" (func 4))
+  (export "size0" (func 5))
+  (export "copy_1_to_0" (func 6))
+  (export "copy_1_to_2" (func 7))
+  (export "fill2" (func 8))
+  (export "init1" (func 9))
+  (data 1 (i32.const 0) "*\00\00\00")
+  (data "\07\00\00\00"))
@@ -0,0 +1,88 @@
+(module
+  (type (;0;) (func (result i32)))
+  (type (;1;) (func (param i32) (result i32)))
+  (type (;2;) (func (param externref) (result i32)))
+  (type (;3;) (func (param externref) (result externref)))
+  (type (;4;) (func (result funcref)))
+  (func (;0;) (type 0) (result i32)
+    i32.const 42)
+  (func (;1;) (type 0) (result i32)
+    i32.const 7)
+  (func (;2;) (type 1) (param i32) (result i32)
+    get_local 0
+    table.get 0
+    ref.is_null)
+  (func (;3;) (type 1) (param i32) (result i32)
+    get_local 0
+    call_indirect (type 0))
+  (func (;4;) (type 1) (param i32) (result i32)
+    get_local 0
+    call_indirect 2 (type 0))
+  (func (;5;) (type 0) (result i32)
+    table.size 0)
+  (func (;6;) (type 1) (param i32) (result i32)
+    ref.null func
+    get_local 0
+    table.grow 0)
+  (func (;7;) (type 1) (param i32) (result i32)
+    ref.func 1
+    get_local 0
+    table.grow 0
+    drop
+    table.size 0)
+  (func (;8;) (type 1) (param i32) (result i32)
+    get_local 0
+    ref.func 1
+    table.set 0
+    get_local 0
+    call_indirect (type 0))
+  (func (;9;) (type 1) (param i32) (result i32)
+    i32.const 0
+    ref.func 1
+    i32.const 2
+    table.fill 0
+    get_local 0
+    call_indirect (type 0))
+  (func (;10;) (type 1) (param i32) (result i32)
+    ref.func 0
+    ref.null func
+    get_local 0
+    select (result funcref)
+    ref.is_null)
+  (func (;11;) (type 2) (param externref) (result i32)
+    get_local 0
+    ref.is_null)
+  (func (;12;) (type 3) (param externref) (result externref)
+    i32.const 1
+    get_local 0
+    table.set 1
+    i32.const 1
+    table.get 1)
+  (func (;13;) (type 0) (result i32)
+    i32.const 0
+    table.get 1
+    ref.is_null)
+  (func (;14;) (type 4) (result funcref)
+    ref.func 1)
+  (table (;0;) 2 10 funcref)
+  (table (;1;) 2 externref)
+  (table (;2;) 2 funcref)
+  (export "f42" (func 0))
+  (export "f7" (func 1))
+  (export "is_null" (func 2))
+  (export "call" (func 3))
+  (export "call_table2" (func 4))
+  (export "size" (func 5))
+  (export "grow" (func 6))
+  (export "grow_size" (func 7))
+  (export "set_call" (func 8))
+  (export "fill_call" (func 9))
+  (export "select_null" (func 10))
+  (export "extern_is_null" (func 11))
+  (export "extern_table" (func 12))
+  (export "extern_init" (func 13))
+  (export "ref_func" (func 14))
+  (elem (i32.const 0) 0)
+  (elem (table 1) (i32.const 0) externref (ref.null extern))
+  (elem (table 2) (i32.const 0) func 1 0)
+  (elem declare func 0 1))
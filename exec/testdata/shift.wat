@@ -0,0 +1,33 @@
+(module
+  (type (;0;) (func (param i32 i32) (result i32)))
+  (type (;1;) (func (param i64 i64) (result i64)))
+  (func (;0;) (type 0) (param i32 i32) (result i32)
+    get_local 0
+    get_local 1
+    i32.shl)
+  (func (;1;) (type 0) (param i32 i32) (result i32)
+    get_local 0
+    get_local 1
+    i32.shr_s)
+  (func (;2;) (type 0) (param i32 i32) (result i32)
+    get_local 0
+    get_local 1
+    i32.shr_u)
+  (func (;3;) (type 1) (param i64 i64) (result i64)
+    get_local 0
+    get_local 1
+    i64.shl)
+  (func (;4;) (type 1) (param i64 i64) (result i64)
+    get_local 0
+    get_local 1
+    i64.shr_s)
+  (func (;5;) (type 1) (param i64 i64) (result i64)
+    get_local 0
+    get_local 1
+    i64.shr_u)
+  (export "i32.shl" (func 0))
+  (export "i32.shr_s" (func 1))
+  (export "i32.shr_u" (func 2))
+  (export "i64.shl" (func 3))
+  (export "i64.shr_s" (func 4))
+  (export "i64.shr_u" (func 5)))
@@ -0,0 +1,161 @@
+(module
+  (type (;0;) (func (result i32)))
+  (type (;1;) (func (result i64)))
+  (type (;2;) (func (param i32) (result i32)))
+  (type (;3;) (func (result f64)))
+  (type (;4;) (func (param v128) (result v128)))
+  (func (;0;) (type 4) (param v128) (result v128)
+    get_local 0
+    get_local 0
+    i32x4.add)
+  (func (;1;) (type 0) (result i32)
+    v128.const i32x4 0x00000001 0x00000002 0x00000003 0x00000004
+    v128.const i32x4 0x0000000a 0x00000014 0x0000001e 0x00000028
+    i32x4.add
+    i32x4.extract_lane 2)
+  (func (;2;) (type 0) (result i32)
+    i32.const 100
+    i8x16.splat
+    i32.const 100
+    i8x16.splat
+    i8x16.add_sat_s
+    i8x16.extract_lane_s 0)
+  (func (;3;) (type 0) (result i32)
+    i32.const 10
+    i8x16.splat
+    i32.const 20
+    i8x16.splat
+    i8x16.sub_sat_u
+    i8x16.extract_lane_u 5)
+  (func (;4;) (type 0) (result i32)
+    i32.const 0
+    v128.load offset=4
+    i32x4.extract_lane 1)
+  (func (;5;) (type 0) (result i32)
+    i32.const 0
+    v128.const i32x4 0xdeadbeef 0x00000000 0x00000000 0x00000000
+    v128.store offset=32
+    i32.const 32
+    i32.load)
+  (func (;6;) (type 0) (result i32)
+    i32.const 65530
+    v128.load
+    i32x4.extract_lane 0)
+  (func (;7;) (type 0) (result i32)
+    v128.const i32x4 0x03020100 0x07060504 0x0b0a0908 0x0f0e0d0c
+    v128.const i32x4 0x13121110 0x17161514 0x1b1a1918 0x1f1e1d1c
+    i8x16.shuffle 16 0 17 1 18 2 19 3 20 4 21 5 22 6 23 7
+    i32x4.extract_lane 0)
+  (func (;8;) (type 0) (result i32)
+    i32.const -3
+    i8x16.splat
+    i32.const 7
+    i8x16.splat
+    i16x8.extmul_high_i8x16_s
+    i16x8.extract_lane_s 7)
+  (func (;9;) (type 0) (result i32)
+    f32.const 0x1.4p+1 (;=2.5;)
+    f32x4.splat
+    f32.const 0x1.8p+1 (;=3;)
+    f32x4.splat
+    f32x4.mul
+    i32x4.trunc_sat_f32x4_s
+    i32x4.extract_lane 3)
+  (func (;10;) (type 0) (result i32)
+    v128.const i32x4 0x80000080 0x00000000 0xff000000 0x00000000
+    i8x16.bitmask)
+  (func (;11;) (type 2) (param i32) (result i32)
+    (local v128)
+    get_local 0
+    i32x4.splat
+    set_local 1
+    get_local 1
+    get_local 1
+    i32x4.mul
+    i32x4.extract_lane 3)
+  (func (;12;) (type 1) (result i64)
+    i32.const -1
+    i32x4.splat
+    i64x2.extend_low_i32x4_u
+    i64x2.extract_lane 1)
+  (func (;13;) (type 3) (result f64)
+    f64.const 0x1.4p+1 (;=2.5;)
+    f64x2.splat
+    f64x2.nearest
+    f64x2.extract_lane 0)
+  (func (;14;) (type 0) (result i32)
+    v128.const i32x4 0xffff0000 0x00000000 0x00000000 0x00000000
+    v128.const i32x4 0x0000ffff 0x00000000 0x00000000 0x00000000
+    v128.const i32x4 0x0f0f0f0f 0x00000000 0x00000000 0x00000000
+    v128.bitselect
+    i32x4.extract_lane 0)
+  (func (;15;) (type 0) (result i32)
+    v128.const i32x4 0x00000000 0x00000000 0x00000000 0x00000100
+    v128.any_true)
+  (func (;16;) (type 0) (result i32)
+    i32.const 70000
+    i32x4.splat
+    i32.const -70000
+    i32x4.splat
+    i16x8.narrow_i32x4_s
+    i16x8.extract_lane_s 4)
+  (func (;17;) (type 0) (result i32)
+    i32.const -2
+    i16x8.splat
+    i32.const 300
+    i16x8.splat
+    i32x4.dot_i16x8_s
+    i32x4.extract_lane 0)
+  (func (;18;) (type 0) (result i32)
+    i32.const 5
+    v128.const i32x4 0x00000000 0x00000000 0x00000000 0x00000000
+    v128.load8_lane 2
+    i32x4.extract_lane 0)
+  (func (;19;) (type 0) (result i32)
+    v128.const i32x4 0x03020100 0x07060504 0x0b0a0908 0x0f0e0d0c
+    v128.const i32x4 0x1001ff03 0x00000000 0x00000000 0x00000000
+    i8x16.swizzle
+    i32x4.extract_lane 0)
+  (func (;20;) (type 0) (result i32)
+    i32.const -1
+    i8x16.splat
+    i8x16.popcnt
+    i8x16.extract_lane_u 9)
+  (func (;21;) (type 0) (result i32)
+    block (result v128)  ;; label = @1
+      v128.const i32x4 0x00000005 0x00000006 0x00000007 0x00000008
+    end
+    i32x4.extract_lane 3)
+  (func (;22;) (type 0) (result i32)
+    v128.const i32x4 0x00000001 0x00000002 0x00000003 0x00000004
+    call 0
+    i32x4.extract_lane 1)
+  (func (;23;) (type 0) (result i32)
+    get_global 0
+    i32x4.extract_lane 1)
+  (global (;0;) v128 (v128.const i32x4 0x00000009 0x00000008 0x00000007 0x00000006))
+  (memory (;0;) 1)
+  (export "i32x4.add" (func 1))
+  (export "i8x16.add_sat_s" (func 2))
+  (export "i8x16.sub_sat_u" (func 3))
+  (export "v128.load" (func 4))
+  (export "v128.store" (func 5))
+  (export "v128.load_oob" (func 6))
+  (export "i8x16.shuffle" (func 7))
+  (export "i16x8.extmul_high_i8x16_s" (func 8))
+  (export "f32x4.mul" (func 9))
+  (export "i8x16.bitmask" (func 10))
+  (export "v128_local" (func 11))
+  (export "i64x2.extend_low_i32x4_u" (func 12))
+  (export "f64x2.nearest" (func 13))
+  (export "v128.bitselect" (func 14))
+  (export "v128.any_true" (func 15))
+  (export "i16x8.narrow_i32x4_s" (func 16))
+  (export "i32x4.dot_i16x8_s" (func 17))
+  (export "v128.load8_lane" (func 18))
+  (export "i8x16.swizzle" (func 19))
+  (export "i8x16.popcnt" (func 20))
+  (export "v128_block" (func 21))
+  (export "v128_call" (func 22))
+  (export "v128_global" (func 23))
+  (data (i32.const 0) "\00\01\02\03\04\05\06\07\08\09\0a\0b\0c\0d\0e\0f\10\11\12\13\14\15\16\17\18\19\1a\1b\1c\1d\1e\1f"))
@@ -0,0 +1,75 @@
+(module
+  (type (;0;) (func (param i32) (result i32)))
+  (type (;1;) (func (param i32 i32) (result i32)))
+  (type (;2;) (func (result i32)))
+  (func (;0;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.eqz
+    if  ;; label = @1
+      i32.const 1
+      return
+    end
+    get_local 0
+    i32.const 1
+    i32.sub
+    return_call 1)
+  (func (;1;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.eqz
+    if  ;; label = @1
+      i32.const 0
+      return
+    end
+    get_local 0
+    i32.const 1
+    i32.sub
+    return_call 0)
+  (func (;2;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.eqz
+    if  ;; label = @1
+      i32.const 1
+      return
+    end
+    get_local 0
+    i32.const 1
+    i32.sub
+    i32.const 1
+    return_call_indirect (type 0))
+  (func (;3;) (type 0) (param i32) (result i32)
+    get_local 0
+    i32.eqz
+    if  ;; label = @1
+      i32.const 0
+      return
+    end
+    get_local 0
+    i32.const 1
+    i32.sub
+    i32.const 0
+    return_call_indirect (type 0))
+  (func (;4;) (type 1) (param i32 i32) (result i32)
+    get_local 0
+    i32.eqz
+    if  ;; label = @1
+      get_local 1
+      return
+    end
+    get_local 0
+    i32.const 1
+    i32.sub
+    get_local 1
+    get_local 0
+    i32.add
+    return_call 4)
+  (func (;5;) (type 2) (result i32)
+    i32.const 0
+    return_call_indirect (type 2))
+  (table (;0;) 2 funcref)
+  (export "even" (func 0))
+  (export "odd" (func 1))
+  (export "even_indirect" (func 2))
+  (export "odd_indirect" (func 3))
+  (export "sum" (func 4))
+  (export "sig_mismatch" (func 5))
+  (elem (i32.const 0) 2 3))
@@ -0,0 +1,98 @@
+(module
+  (type (;0;) (func (param i32)))
+  (type (;1;) (func (result i32)))
+  (type (;2;) (func (result i64)))
+  (func (;0;) (type 0) (param i32)
+    block  ;; label = @1
+      loop  ;; label = @2
+        get_local 0
+        i32.eqz
+        br_if 1 (;@1;)
+        i32.const 0
+        i32.const 1
+        i32.atomic.rmw.add
+        drop
+        get_local 0
+        i32.const 1
+        i32.sub
+        set_local 0
+        br 0 (;@2;)
+      end
+    end)
+  (func (;1;) (type 1) (result i32)
+    i32.const 0
+    i32.atomic.load)
+  (func (;2;) (type 1) (result i32)
+    i32.const 4
+    i32.const 0
+    i64.const -1
+    memory.atomic.wait32)
+  (func (;3;) (type 1) (result i32)
+    i32.const 4
+    i32.const 1
+    i32.atomic.store
+    i32.const 4
+    i32.const -1
+    memory.atomic.notify)
+  (func (;4;) (type 1) (result i32)
+    i32.const 8
+    i32.const 1
+    i64.const 0
+    memory.atomic.wait32)
+  (func (;5;) (type 1) (result i32)
+    i32.const 8
+    i32.const 0
+    i64.const 1000000
+    memory.atomic.wait32)
+  (func (;6;) (type 1) (result i32)
+    i32.const 12
+    i32.const 0
+    i32.const 42
+    i32.atomic.rmw.cmpxchg
+    drop
+    i32.const 12
+    i32.const 0
+    i32.const 7
+    i32.atomic.rmw.cmpxchg)
+  (func (;7;) (type 1) (result i32)
+    i32.const 16
+    i32.const 255
+    i32.atomic.store8
+    i32.const 16
+    i32.const 2
+    i32.atomic.rmw8.add_u
+    drop
+    i32.const 16
+    i32.atomic.load)
+  (func (;8;) (type 2) (result i64)
+    i32.const 24
+    i64.const -1
+    i64.atomic.rmw.xchg
+    drop
+    i32.const 24
+    i64.atomic.load32_u)
+  (func (;9;) (type 1) (result i32)
+    i32.const 2
+    i32.atomic.load)
+  (func (;10;) (type 1) (result i32)
+    i32.const 1
+    memory.grow
+    drop
+    i32.const 1
+    memory.grow)
+  (func (;11;) (type 1) (result i32)
+    atomic.fence
+    i32.const 1)
+  (memory (;0;) 1 2 shared)
+  (export "inc" (func 0))
+  (export "counter" (func 1))
+  (export "wait" (func 2))
+  (export "notify" (func 3))
+  (export "wait_not_equal" (func 4))
+  (export "wait_timeout" (func 5))
+  (export "cmpxchg" (func 6))
+  (export "rmw8.add_u" (func 7))
+  (export "i64.xchg" (func 8))
+  (export "unaligned" (func 9))
+  (export "grow" (func 10))
+  (export "fence" (func 11)))
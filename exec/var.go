@@ -6,12 +6,12 @@ package exec
 
 func (vm *VM) getLocal() {
 	index := vm.fetchUint32()
-	vm.pushUint64(vm.ctx.locals[int(index)])
+	vm.pushValue(vm.ctx.locals[int(index)])
 }
 
 func (vm *VM) setLocal() {
 	index := vm.fetchUint32()
-	vm.ctx.locals[int(index)] = vm.popUint64()
+	vm.ctx.locals[int(index)] = vm.popValue()
 }
 
 func (vm *VM) teeLocal() {
@@ -22,10 +22,10 @@ func (vm *VM) teeLocal() {
 
 func (vm *VM) getGlobal() {
 	index := vm.fetchUint32()
-	vm.pushUint64(vm.globals[int(index)])
+	vm.pushValue(vm.globals[int(index)])
 }
 
 func (vm *VM) setGlobal() {
 	index := vm.fetchUint32()
-	vm.globals[int(index)] = vm.popUint64()
+	vm.globals[int(index)] = vm.popValue()
 }
@@ -12,16 +12,12 @@ import (
 	"io"
 	"math"
 
-	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/exec/internal/compile"
 	"github.com/go-interpreter/wagon/wasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 var (
-	// ErrMultipleLinearMemories is returned by (*VM).NewVM when the module
-	// has more then one entries in the linear memory space.
-	ErrMultipleLinearMemories = errors.New("exec: more than one linear memories in module")
 	// ErrInvalidArgumentCount is returned by (*VM).ExecCode when an invalid
 	// number of arguments to the WebAssembly function are passed to it.
 	ErrInvalidArgumentCount = errors.New("exec: invalid number of arguments to function")
@@ -43,24 +39,60 @@ func (e InvalidFunctionIndexError) Error() string {
 	return fmt.Sprintf("Invalid index to function index space: %d", int64(e))
 }
 
+// value is an element of the operand stack, of the local variables or of
+// the globals. Values of all types but v128 only use the low 64 bits.
+type value struct {
+	lo, hi uint64
+}
+
 type context struct {
-	stack   []uint64
-	locals  []uint64
+	stack   []value
+	locals  []value
 	code    []byte
 	pc      int64
 	curFunc int64
+
+	// the exceptions caught by the catch clauses of the try blocks of
+	// the function, for rethrow. It is allocated on the first catch.
+	caught []*Exception
+
+	size int // the number of values of the frame, see MaxStackSize
 }
 
 // VM is the execution context for executing WebAssembly bytecode.
 type VM struct {
 	ctx context
 
-	module  *wasm.Module
-	globals []uint64
-	memory  []byte
-	funcs   []function
+	module   *wasm.Module
+	globals  []value
+	memories []*linearMemory // in the order of the memory index space
+	funcs    []function
+
+	// The elements of each table. 0 is a null reference, and any
+	// other value v is a reference to the function at index v-1
+	// (funcref) or to the host value externs[v-1] (externref).
+	tables [][]uint64
+	// host values referenced by externref values, see ExternRef, and the
+	// references of the comparable ones.
+	externs    []interface{}
+	externRefs map[interface{}]uint64
+	// The contents of the data and element segments that can still be
+	// used by memory.init and table.init, nil for dropped segments.
+	data  [][]byte
+	elems [][]uint64
 
 	funcTable [256]func()
+	// functions implementing the operators prefixed by ops.MiscPrefix,
+	// indexed by sub-opcode.
+	miscFuncTable [32]func()
+	// functions implementing the operators prefixed by ops.SIMDPrefix,
+	// indexed by sub-opcode.
+	simdFuncTable [256]func()
+	// functions implementing the operators prefixed by ops.AtomicPrefix,
+	// indexed by sub-opcode.
+	atomicFuncTable [0x4f]func()
+
+	cfg config // settings from the VMOption values passed to NewVM
 
 	// RecoverPanic controls whether the `ExecCode` method
 	// recovers from a panic and returns it as an error
@@ -70,6 +102,13 @@ type VM struct {
 	RecoverPanic bool
 
 	abort bool // Flag for host functions to terminate execution
+
+	depth     int // the number of nested calls, limited by MaxCallDepth
+	stackSize int // the number of values of all frames, limited by MaxStackSize
+
+	// The exception being thrown, if any, which also sets abort until a
+	// catch clause handling it is found.
+	exception *Exception
 }
 
 // As per the WebAssembly spec: https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/Semantics.md#linear-memory
@@ -79,19 +118,42 @@ var endianess = binary.LittleEndian
 
 // NewVM creates a new VM from a given module. If the module defines a
 // start function, it will be executed.
-func NewVM(module *wasm.Module) (*VM, error) {
+func NewVM(module *wasm.Module, opts ...VMOption) (*VM, error) {
 	var vm VM
-
-	if module.Memory != nil && len(module.Memory.Entries) != 0 {
-		if len(module.Memory.Entries) > 1 {
-			return nil, ErrMultipleLinearMemories
+	for _, opt := range opts {
+		opt(&vm.cfg)
+	}
+	vm.cfg.maxLocals = limit(vm.cfg.maxLocals, DefaultMaxLocals)
+	vm.cfg.maxBodySize = limit(vm.cfg.maxBodySize, DefaultMaxBodySize)
+	vm.cfg.maxStackSize = limit(vm.cfg.maxStackSize, DefaultMaxStackSize)
+	vm.cfg.maxMemoryPages = limit(vm.cfg.maxMemoryPages, DefaultMaxMemoryPages)
+	if vm.cfg.deterministic {
+		if vm.cfg.maxCallDepth == 0 {
+			vm.cfg.maxCallDepth = DefaultMaxCallDepth
+		}
+		if err := vm.checkHostFunctions(module); err != nil {
+			return nil, err
+		}
+		if err := vm.checkSharedMemories(module); err != nil {
+			return nil, err
 		}
-		vm.memory = make([]byte, uint(module.Memory.Entries[0].Limits.Initial)*wasmPageSize)
-		copy(vm.memory, module.LinearMemoryIndexSpace[0])
+		if err := checkWaitNotify(module); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := vm.newMemories(module); err != nil {
+		return nil, err
+	}
+	if err := vm.newTables(module); err != nil {
+		return nil, err
+	}
+	if err := vm.newSegments(module); err != nil {
+		return nil, err
 	}
 
 	vm.funcs = make([]function, len(module.FunctionIndexSpace))
-	vm.globals = make([]uint64, len(module.GlobalIndexSpace))
+	vm.globals = make([]value, len(module.GlobalIndexSpace))
 	vm.newFuncTable()
 	vm.module = module
 
@@ -112,24 +174,12 @@ func NewVM(module *wasm.Module) (*VM, error) {
 			continue
 		}
 
-		disassembly, err := disasm.NewDisassembly(fn, module)
-		if err != nil {
-			return nil, err
-		}
+		vm.funcs[i] = lazyFunction{}
+	}
 
-		totalLocalVars := 0
-		totalLocalVars += len(fn.Sig.ParamTypes)
-		for _, entry := range fn.Body.Locals {
-			totalLocalVars += int(entry.Count)
-		}
-		code, table := compile.Compile(disassembly.Code)
-		vm.funcs[i] = compiledFunction{
-			code:           code,
-			branchTables:   table,
-			maxDepth:       disassembly.MaxDepth,
-			totalLocalVars: totalLocalVars,
-			args:           len(fn.Sig.ParamTypes),
-			returns:        len(fn.Sig.ReturnTypes) != 0,
+	if !vm.cfg.lazyCompile {
+		if err := vm.CompileAll(); err != nil {
+			return nil, err
 		}
 	}
 
@@ -140,13 +190,19 @@ func NewVM(module *wasm.Module) (*VM, error) {
 		}
 		switch v := val.(type) {
 		case int32:
-			vm.globals[i] = uint64(v)
+			vm.globals[i] = value{lo: uint64(v)}
 		case int64:
-			vm.globals[i] = uint64(v)
+			vm.globals[i] = value{lo: uint64(v)}
 		case float32:
-			vm.globals[i] = uint64(math.Float32bits(v))
+			vm.globals[i] = value{lo: uint64(math.Float32bits(v))}
 		case float64:
-			vm.globals[i] = uint64(math.Float64bits(v))
+			vm.globals[i] = value{lo: math.Float64bits(v)}
+		case [16]byte:
+			vm.globals[i] = v128Value(v)
+		case wasm.RefValue:
+			if !v.Null {
+				vm.globals[i] = value{lo: uint64(v.Index) + 1}
+			}
 		}
 	}
 
@@ -160,9 +216,117 @@ func NewVM(module *wasm.Module) (*VM, error) {
 	return &vm, nil
 }
 
-// Memory returns the linear memory space for the VM.
+// newSegments keeps the contents of the passive data and element segments
+// of the module, for use by memory.init and table.init. Active segments
+// have already been copied, and behave as dropped ones.
+func (vm *VM) newSegments(module *wasm.Module) error {
+	if module.Data != nil {
+		vm.data = make([][]byte, len(module.Data.Entries))
+		for i, seg := range module.Data.Entries {
+			if seg.Mode == wasm.SegmentPassive {
+				vm.data[i] = seg.Data
+			}
+		}
+	}
+	if module.Elements != nil {
+		vm.elems = make([][]uint64, len(module.Elements.Entries))
+		for i, seg := range module.Elements.Entries {
+			if seg.Mode != wasm.SegmentPassive {
+				continue
+			}
+			elems, err := elemRefs(module, seg)
+			if err != nil {
+				return err
+			}
+			vm.elems[i] = elems
+		}
+	}
+	return nil
+}
+
+// newMemories creates the linear memories of the module, imported ones
+// included, and copies the active data segments into them.
+func (vm *VM) newMemories(module *wasm.Module) error {
+	for i := 0; ; i++ {
+		t := module.GetMemory(i)
+		if t == nil {
+			break
+		}
+		limits := t.Limits
+		mem := &linearMemory{is64: limits.Is64(), maxPages: maxPages}
+		if mem.is64 {
+			mem.maxPages = math.MaxUint32
+		}
+		if limits.Flags&0x1 != 0 {
+			mem.maxPages = uint64(limits.Maximum)
+		}
+		if limit := uint64(vm.cfg.maxMemoryPages); limit != 0 && mem.maxPages > limit {
+			mem.maxPages = limit
+		}
+		vm.memories = append(vm.memories, mem)
+
+		switch {
+		case i == 0 && vm.cfg.sharedMemory != nil:
+			mem.shared = vm.cfg.sharedMemory
+			mem.sync()
+			if err := vm.checkMemoryLimit(mem.shared.maxPages()); err != nil {
+				return err
+			}
+			continue
+		case vm.cfg.maxMemoryPages != 0 && uint64(limits.Initial) > mem.maxPages:
+			return ErrMemoryLimit
+		case limits.Shared():
+			shared, err := newSharedMemory(limits.Initial, limits.Maximum, vm.cfg.maxMemoryPages)
+			if err != nil {
+				return err
+			}
+			mem.shared = shared
+			mem.sync()
+		default:
+			mem.bytes = make([]byte, uint(limits.Initial)*wasmPageSize)
+		}
+		if i < len(module.LinearMemoryIndexSpace) {
+			copy(mem.bytes, module.LinearMemoryIndexSpace[i])
+		}
+	}
+
+	if len(vm.memories) == 0 && vm.cfg.sharedMemory != nil {
+		mem := &linearMemory{shared: vm.cfg.sharedMemory, maxPages: maxPages}
+		mem.sync()
+		if err := vm.checkMemoryLimit(mem.shared.maxPages()); err != nil {
+			return err
+		}
+		vm.memories = append(vm.memories, mem)
+	}
+	return nil
+}
+
+// checkMemoryLimit returns ErrMemoryLimit if a shared memory whose maximum
+// is maxPages can grow beyond the limit set by MaxMemoryPages. Shared
+// memories are allocated with their maximum size.
+func (vm *VM) checkMemoryLimit(maxPages uint64) error {
+	if limit := uint64(vm.cfg.maxMemoryPages); limit != 0 && maxPages > limit {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// Memory returns the linear memory space for the VM, the memory with index
+// 0 if the module has several ones.
 func (vm *VM) Memory() []byte {
-	return vm.memory
+	return vm.MemoryAt(0)
+}
+
+// MemoryAt returns the linear memory at the given index in the memory index
+// space of the module, which starts with the imported memories, or nil if
+// there is none.
+func (vm *VM) MemoryAt(i int) []byte {
+	if i < 0 || i >= len(vm.memories) {
+		return nil
+	}
+	mem := vm.memories[i]
+	mem.sync()
+	return mem.bytes
 }
 
 func (vm *VM) pushBool(v bool) {
@@ -211,8 +375,14 @@ func (vm *VM) fetchFloat64() float64 {
 	return math.Float64frombits(vm.fetchUint64())
 }
 
+func (vm *VM) popValue() value {
+	v := vm.ctx.stack[len(vm.ctx.stack)-1]
+	vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-1]
+	return v
+}
+
 func (vm *VM) popUint64() uint64 {
-	i := vm.ctx.stack[len(vm.ctx.stack)-1]
+	i := vm.ctx.stack[len(vm.ctx.stack)-1].lo
 	vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-1]
 	return i
 }
@@ -237,8 +407,12 @@ func (vm *VM) popFloat32() float32 {
 	return math.Float32frombits(vm.popUint32())
 }
 
+func (vm *VM) pushValue(v value) {
+	vm.ctx.stack = append(vm.ctx.stack, v)
+}
+
 func (vm *VM) pushUint64(i uint64) {
-	vm.ctx.stack = append(vm.ctx.stack, i)
+	vm.ctx.stack = append(vm.ctx.stack, value{lo: i})
 }
 
 func (vm *VM) pushInt64(i int64) {
@@ -264,6 +438,10 @@ func (vm *VM) pushFloat32(f float32) {
 // ExecCode calls the function with the given index and arguments.
 // fnIndex should be a valid index into the function index space of
 // the VM's module.
+// externref arguments are created with ExternRef. A returned funcref is
+// the uint32 index of the function in the function index space, and a
+// returned externref is the referenced host value. Both are nil for null
+// references.
 func (vm *VM) ExecCode(fnIndex int64, args ...uint64) (rtrn interface{}, err error) {
 	// If used as a library, client code should set vm.RecoverPanic to true
 	// in order to have an error returned.
@@ -279,49 +457,111 @@ func (vm *VM) ExecCode(fnIndex int64, args ...uint64) (rtrn interface{}, err err
 			}
 		}()
 	}
-	if int(fnIndex) > len(vm.funcs) {
+	if fnIndex < 0 || fnIndex >= int64(len(vm.funcs)) {
 		return nil, InvalidFunctionIndexError(fnIndex)
 	}
 	if len(vm.module.GetFunction(int(fnIndex)).Sig.ParamTypes) != len(args) {
 		return nil, ErrInvalidArgumentCount
 	}
-	compiled, ok := vm.funcs[fnIndex].(compiledFunction)
-	if !ok {
+	if _, ok := vm.funcs[fnIndex].(goFunction); ok {
 		panic(fmt.Sprintf("exec: function at index %d is not a compiled function", fnIndex))
 	}
+	compiled, err := vm.compile(fnIndex)
+	if err != nil {
+		return nil, err
+	}
+	// the stack is restored if the execution traps.
+	defer func(depth, stackSize int) {
+		vm.depth, vm.stackSize = depth, stackSize
+	}(vm.depth, vm.stackSize)
+	size := compiled.frameSize()
+	vm.enter(size)
 	if len(vm.ctx.stack) < compiled.maxDepth {
-		vm.ctx.stack = make([]uint64, 0, compiled.maxDepth)
+		vm.ctx.stack = make([]value, 0, compiled.maxDepth)
 	}
-	vm.ctx.locals = make([]uint64, compiled.totalLocalVars)
+	vm.ctx.locals = make([]value, compiled.totalLocalVars)
 	vm.ctx.pc = 0
 	vm.ctx.code = compiled.code
 	vm.ctx.curFunc = fnIndex
+	vm.ctx.caught = nil
+	vm.ctx.size = size
 
 	for i, arg := range args {
-		vm.ctx.locals[i] = arg
+		vm.ctx.locals[i] = value{lo: arg}
 	}
 
-	res := vm.execCode(compiled)
-	if compiled.returns {
+	val := vm.execCode(compiled)
+	if e := vm.exception; e != nil {
+		vm.exception = nil
+		vm.abort = false
+		return nil, e
+	}
+	if compiled.returns && !vm.abort {
 		rtrnType := vm.module.GetFunction(int(fnIndex)).Sig.ReturnTypes[0]
-		switch rtrnType {
-		case wasm.ValueTypeI32:
-			rtrn = uint32(res)
-		case wasm.ValueTypeI64:
-			rtrn = uint64(res)
-		case wasm.ValueTypeF32:
-			rtrn = math.Float32frombits(uint32(res))
-		case wasm.ValueTypeF64:
-			rtrn = math.Float64frombits(res)
-		default:
-			return nil, InvalidReturnTypeError(rtrnType)
-		}
+		return vm.interfaceValue(rtrnType, val)
 	}
 
 	return rtrn, nil
 }
 
-func (vm *VM) execCode(compiled compiledFunction) uint64 {
+// interfaceValue converts v, of type t, to the value returned by ExecCode.
+func (vm *VM) interfaceValue(t wasm.ValueType, v value) (interface{}, error) {
+	res := v.lo
+	switch t {
+	case wasm.ValueTypeV128:
+		return v.v128(), nil
+	case wasm.ValueTypeI32:
+		return uint32(res), nil
+	case wasm.ValueTypeI64:
+		return uint64(res), nil
+	case wasm.ValueTypeF32:
+		return math.Float32frombits(uint32(res)), nil
+	case wasm.ValueTypeF64:
+		return math.Float64frombits(res), nil
+	case wasm.ValueTypeFuncRef:
+		if res != 0 {
+			return uint32(res - 1), nil
+		}
+		return nil, nil
+	case wasm.ValueTypeExternRef:
+		return vm.externValue(res), nil
+	default:
+		return nil, InvalidReturnTypeError(t)
+	}
+}
+
+// Global returns the current value of the global at the given index in the
+// global index space of the module, converted as the values returned by
+// ExecCode.
+func (vm *VM) Global(i int) (interface{}, error) {
+	global := vm.module.GetGlobal(i)
+	if global == nil {
+		return nil, wasm.InvalidGlobalIndexError(i)
+	}
+	return vm.interfaceValue(global.Type.Type, vm.globals[i])
+}
+
+func (vm *VM) execCode(compiled compiledFunction) value {
+	for {
+		vm.run(&compiled)
+		if vm.exception == nil {
+			break
+		}
+		if !vm.catch(&compiled) {
+			// the exception is thrown to the caller
+			return value{}
+		}
+	}
+
+	if compiled.returns && !vm.abort {
+		return vm.ctx.stack[len(vm.ctx.stack)-1]
+	}
+	return value{}
+}
+
+// run executes the code of the current function until it returns, traps,
+// or throws an exception. compiled is updated by tail calls.
+func (vm *VM) run(compiled *compiledFunction) {
 outer:
 	for int(vm.ctx.pc) < len(vm.ctx.code) && !vm.abort {
 		op := vm.ctx.code[vm.ctx.pc]
@@ -329,6 +569,19 @@ outer:
 		switch op {
 		case ops.Return:
 			break outer
+		case ops.ReturnCall, ops.ReturnCallIndirect:
+			var index int64
+			if op == ops.ReturnCall {
+				index = int64(vm.fetchUint32())
+			} else {
+				index = vm.indirectCallee()
+			}
+			callee, ok := vm.tailCall(index)
+			if !ok {
+				break outer
+			}
+			*compiled = callee
+			continue
 		case compile.OpJmp:
 			vm.ctx.pc = vm.fetchInt64()
 			continue
@@ -344,13 +597,13 @@ outer:
 			discard := vm.fetchInt64()
 			if vm.popUint32() != 0 {
 				vm.ctx.pc = target
-				var top uint64
+				var top value
 				if preserveTop {
 					top = vm.ctx.stack[len(vm.ctx.stack)-1]
 				}
 				vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(discard)]
 				if preserveTop {
-					vm.pushUint64(top)
+					vm.pushValue(top)
 				}
 				continue
 			}
@@ -373,13 +626,13 @@ outer:
 				break outer
 			}
 			vm.ctx.pc = target.Addr
-			var top uint64
+			var top value
 			if target.PreserveTop {
 				top = vm.ctx.stack[len(vm.ctx.stack)-1]
 			}
 			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(target.Discard)]
 			if target.PreserveTop {
-				vm.pushUint64(top)
+				vm.pushValue(top)
 			}
 			continue
 		case compile.OpDiscard:
@@ -389,16 +642,11 @@ outer:
 			top := vm.ctx.stack[len(vm.ctx.stack)-1]
 			place := vm.fetchInt64()
 			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(place)]
-			vm.pushUint64(top)
+			vm.pushValue(top)
 		default:
 			vm.funcTable[op]()
 		}
 	}
-
-	if compiled.returns {
-		return vm.ctx.stack[len(vm.ctx.stack)-1]
-	}
-	return 0
 }
 
 // Process is a proxy passed to host functions in order to access
@@ -456,6 +704,23 @@ func (proc *Process) WriteAt(p []byte, off int64) (int, error) {
 	return length, err
 }
 
+// MemSize returns the current size, in bytes, of the default linear memory
+// of the module. Host functions should check the bounds of the accesses
+// they make with ReadAt and WriteAt against it.
+func (proc *Process) MemSize() int {
+	return len(proc.vm.Memory())
+}
+
+// GrowMemory grows the default linear memory of the module by n pages of
+// 64KiB, as memory.grow, and returns its previous size in pages, or -1 if
+// it can't grow.
+func (proc *Process) GrowMemory(n uint32) int64 {
+	if len(proc.vm.memories) == 0 {
+		return -1
+	}
+	return int64(proc.vm.memories[0].grow(uint64(n)))
+}
+
 // Terminate stops the execution of the current module.
 func (proc *Process) Terminate() {
 	proc.vm.abort = true
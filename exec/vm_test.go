@@ -5,19 +5,27 @@
 package exec
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
 )
 
 var (
-	smallMemoryVM      = &VM{memory: []byte{1, 2, 3}}
-	emptyMemoryVM      = &VM{memory: []byte{}}
+	smallMemoryVM      = newMemoryVM([]byte{1, 2, 3})
+	emptyMemoryVM      = newMemoryVM([]byte{})
 	smallMemoryProcess = &Process{vm: smallMemoryVM}
 	emptyMemoryProcess = &Process{vm: emptyMemoryVM}
 	tooBigABuffer      = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
 )
 
+// newMemoryVM returns a VM whose only linear memory is mem.
+func newMemoryVM(mem []byte) *VM {
+	return &VM{memories: []*linearMemory{{bytes: mem}}}
+}
+
 func TestNormalWrite(t *testing.T) {
-	vm := &VM{memory: make([]byte, 300)}
+	vm := newMemoryVM(make([]byte, 300))
 	proc := &Process{vm: vm}
 	n, err := proc.WriteAt(tooBigABuffer, 0)
 	if err != nil {
@@ -33,7 +41,7 @@ func TestWriteBoundary(t *testing.T) {
 	if err == nil {
 		t.Fatal("Should have reported an error and didn't")
 	}
-	if n != len(smallMemoryVM.memory) {
+	if n != len(smallMemoryVM.Memory()) {
 		t.Fatalf("Number of written bytes was %d, should have been 0", n)
 	}
 }
@@ -44,7 +52,7 @@ func TestReadBoundary(t *testing.T) {
 	if err == nil {
 		t.Fatal("Should have reported an error and didn't")
 	}
-	if n != len(smallMemoryVM.memory) {
+	if n != len(smallMemoryVM.Memory()) {
 		t.Fatalf("Number of written bytes was %d, should have been 0", n)
 	}
 }
@@ -95,7 +103,7 @@ func TestWriteEmpty(t *testing.T) {
 }
 
 func TestWriteOffset(t *testing.T) {
-	vm := &VM{memory: make([]byte, 300)}
+	vm := newMemoryVM(make([]byte, 300))
 	proc := &Process{vm: vm}
 
 	n, err := proc.WriteAt(tooBigABuffer, 2)
@@ -106,7 +114,193 @@ func TestWriteOffset(t *testing.T) {
 		t.Fatalf("Number of written bytes was %d, should have been %d", n, len(tooBigABuffer))
 	}
 
-	if vm.memory[0] != 0 || vm.memory[1] != 0 || vm.memory[2] != tooBigABuffer[0] {
+	if mem := vm.Memory(); mem[0] != 0 || mem[1] != 0 || mem[2] != tooBigABuffer[0] {
 		t.Fatal("Writing at offset didn't work")
 	}
 }
+
+// newCodeModule returns a module whose functions all have the signature
+// (func (result i32)) and the given code as their bodies.
+func newCodeModule(codes ...[]byte) *wasm.Module {
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Types = &wasm.SectionTypes{
+		Entries: []wasm.FunctionSig{
+			{
+				Form:        0,
+				ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32},
+			},
+		},
+	}
+	m.Function = &wasm.SectionFunctions{}
+	m.Code = &wasm.SectionCode{}
+	for _, code := range codes {
+		m.Function.Types = append(m.Function.Types, 0)
+		m.Code.Bodies = append(m.Code.Bodies, wasm.FunctionBody{Module: m, Code: code})
+	}
+	for i := range m.Code.Bodies {
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+			Sig:  &m.Types.Entries[0],
+			Body: &m.Code.Bodies[i],
+		})
+	}
+	return m
+}
+
+func TestLazyCompile(t *testing.T) {
+	m := newCodeModule(
+		[]byte{0x41, 0x2a},       // i32.const 42
+		[]byte{0x41, 0x01, 0xff}, // i32.const 1, <invalid opcode>
+	)
+
+	if _, err := NewVM(m); err == nil {
+		t.Fatal("NewVM should have failed to compile the invalid function")
+	}
+
+	vm, err := NewVM(m, LazyCompile(true))
+	if err != nil {
+		t.Fatalf("error creating lazy VM: %v", err)
+	}
+	for i, fn := range vm.funcs {
+		if _, ok := fn.(lazyFunction); !ok {
+			t.Fatalf("function %d was compiled before being called", i)
+		}
+	}
+
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function 0: %v", err)
+	}
+	if res != uint32(42) {
+		t.Fatalf("unexpected return value: got=%v, want=42", res)
+	}
+	if _, ok := vm.funcs[0].(compiledFunction); !ok {
+		t.Fatal("function 0 was not compiled by its first call")
+	}
+	if _, ok := vm.funcs[1].(lazyFunction); !ok {
+		t.Fatal("function 1 was compiled without being called")
+	}
+
+	if _, err := vm.ExecCode(1); err == nil {
+		t.Fatal("executing the invalid function should have failed")
+	}
+	if err := vm.CompileAll(); err == nil {
+		t.Fatal("CompileAll should have reported the invalid function")
+	}
+}
+
+func TestCompileAll(t *testing.T) {
+	m := newCodeModule(
+		[]byte{0x41, 0x2a}, // i32.const 42
+		[]byte{0x41, 0x01}, // i32.const 1
+	)
+	vm, err := NewVM(m, LazyCompile(true))
+	if err != nil {
+		t.Fatalf("error creating lazy VM: %v", err)
+	}
+	if err := vm.CompileAll(); err != nil {
+		t.Fatalf("error compiling functions: %v", err)
+	}
+	for i, fn := range vm.funcs {
+		if _, ok := fn.(compiledFunction); !ok {
+			t.Fatalf("function %d was not compiled by CompileAll", i)
+		}
+	}
+}
+
+func TestCompileConcurrency(t *testing.T) {
+	var codes [][]byte
+	for i := 0; i < 64; i++ {
+		switch i {
+		case 5:
+			codes = append(codes, []byte{0x41, 0x01, 0xe0})
+		case 20:
+			codes = append(codes, []byte{0x41, 0x01, 0xff})
+		default:
+			codes = append(codes, []byte{0x41, byte(i)})
+		}
+	}
+	m := newCodeModule(codes...)
+
+	_, want := NewVM(m)
+	if want == nil {
+		t.Fatal("NewVM should have failed to compile the invalid functions")
+	}
+	for i := 0; i < 10; i++ {
+		_, err := NewVM(m, CompileConcurrency(8))
+		if err == nil || err.Error() != want.Error() {
+			t.Fatalf("unexpected error: got=%v, want=%v", err, want)
+		}
+	}
+
+	m = newCodeModule(codes[:5]...)
+	vm, err := NewVM(m, CompileConcurrency(8))
+	if err != nil {
+		t.Fatalf("error creating VM: %v", err)
+	}
+	for i := range m.FunctionIndexSpace {
+		res, err := vm.ExecCode(int64(i))
+		if err != nil {
+			t.Fatalf("error executing function %d: %v", i, err)
+		}
+		if res != uint32(i) {
+			t.Fatalf("unexpected return value for function %d: got=%v", i, res)
+		}
+	}
+}
+
+func TestExecCodeIndex(t *testing.T) {
+	m := newCodeModule([]byte{0x41, 0x2a}) // i32.const 42
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("error creating VM: %v", err)
+	}
+	for _, index := range []int64{-1, 1} {
+		if _, err := vm.ExecCode(index); err != InvalidFunctionIndexError(index) {
+			t.Errorf("ExecCode(%d): err = %v, want %v", index, err, InvalidFunctionIndexError(index))
+		}
+	}
+}
+
+func TestPassiveElementsIndex(t *testing.T) {
+	m := newCodeModule([]byte{0x41, 0x2a}) // i32.const 42
+	m.Elements = &wasm.SectionElements{
+		Entries: []wasm.ElementSegment{
+			{Mode: wasm.SegmentPassive, Type: wasm.ElemTypeAnyFunc, Elems: []uint32{5}},
+		},
+	}
+	if _, err := NewVM(m); err != wasm.InvalidFunctionIndexError(5) {
+		t.Errorf("err = %v, want %v", err, wasm.InvalidFunctionIndexError(5))
+	}
+}
+
+func TestTableOffset(t *testing.T) {
+	m := newCodeModule([]byte{0x41, 0x2a}) // i32.const 42
+	m.Table = &wasm.SectionTables{
+		Entries: []wasm.Table{{ElementType: wasm.ElemTypeAnyFunc, Limits: wasm.ResizableLimits{Initial: 1}}},
+	}
+	m.TableIndexSpace = [][]uint32{nil}
+	m.Elements = &wasm.SectionElements{
+		Entries: []wasm.ElementSegment{
+			// the offset is an empty expression.
+			{Mode: wasm.SegmentActive, Offset: []byte{0x0b}, Type: wasm.ElemTypeAnyFunc, Elems: []uint32{0}},
+		},
+	}
+	want := wasm.InvalidValueTypeInitExprError{Wanted: reflect.Int32, Got: reflect.Invalid}
+	if _, err := NewVM(m); err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+}
+
+func TestTableIndex(t *testing.T) {
+	m := newCodeModule([]byte{0x41, 0x2a}) // i32.const 42
+	m.Elements = &wasm.SectionElements{
+		Entries: []wasm.ElementSegment{
+			// an active segment, without a table.
+			{Mode: wasm.SegmentActive, Offset: []byte{0x41, 0x00, 0x0b}, Type: wasm.ElemTypeAnyFunc, Elems: []uint32{0}},
+		},
+	}
+	if _, err := NewVM(m); err != wasm.InvalidTableIndexError(0) {
+		t.Errorf("err = %v, want %v", err, wasm.InvalidTableIndexError(0))
+	}
+}
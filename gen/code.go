@@ -0,0 +1,665 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gen
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// noValue is the type of the blocks without a result, and of the
+// branches to loops.
+const noValue = wasm.ValueType(wasm.BlockTypeEmpty)
+
+var (
+	// numOps maps each value type to the numeric operators returning it,
+	// which take their operands from the stack and have no immediates.
+	numOps = make(map[wasm.ValueType][]ops.Op)
+	// loadOps maps each value type to the load operators returning it,
+	// and storeOps to the store operators storing it.
+	loadOps  = make(map[wasm.ValueType][]ops.Op)
+	storeOps = make(map[wasm.ValueType][]ops.Op)
+)
+
+// accessSize maps the load and store operators to the number of bytes
+// they access.
+var accessSize = map[byte]uint32{
+	ops.I32Load: 4, ops.I64Load: 8, ops.F32Load: 4, ops.F64Load: 8,
+	ops.I32Load8s: 1, ops.I32Load8u: 1, ops.I32Load16s: 2, ops.I32Load16u: 2,
+	ops.I64Load8s: 1, ops.I64Load8u: 1, ops.I64Load16s: 2, ops.I64Load16u: 2,
+	ops.I64Load32s: 4, ops.I64Load32u: 4,
+	ops.I32Store: 4, ops.I64Store: 8, ops.F32Store: 4, ops.F64Store: 8,
+	ops.I32Store8: 1, ops.I32Store16: 2, ops.I64Store8: 1, ops.I64Store16: 2, ops.I64Store32: 4,
+}
+
+func init() {
+	for code := int(ops.I32Eqz); code <= int(ops.I64Extend32S); code++ {
+		if op, err := ops.New(byte(code)); err == nil {
+			numOps[op.Returns] = append(numOps[op.Returns], op)
+		}
+	}
+	for sub := uint32(ops.I32TruncSatSF32); sub <= uint32(ops.I64TruncSatUF64); sub++ {
+		if op, err := ops.NewPrefixed(ops.MiscPrefix, sub); err == nil {
+			numOps[op.Returns] = append(numOps[op.Returns], op)
+		}
+	}
+	for code := range accessSize {
+		op, err := ops.New(code)
+		if err != nil {
+			panic(err)
+		}
+		if op.Returns != noValue {
+			loadOps[op.Returns] = append(loadOps[op.Returns], op)
+		} else {
+			// the stored value is the first operand popped.
+			storeOps[op.Args[0]] = append(storeOps[op.Args[0]], op)
+		}
+	}
+	// sort the operators, which are generated from the map in a random
+	// order.
+	for _, m := range []map[wasm.ValueType][]ops.Op{loadOps, storeOps} {
+		for _, l := range m {
+			for i := 1; i < len(l); i++ {
+				for j := i; j > 0 && l[j].Code < l[j-1].Code; j-- {
+					l[j], l[j-1] = l[j-1], l[j]
+				}
+			}
+		}
+	}
+}
+
+// function generates the body of a function of type sig.
+func (g *generator) function(sig *wasm.FunctionSig) (wasm.FunctionBody, error) {
+	g.sig = sig
+	g.locals = append([]wasm.ValueType(nil), sig.ParamTypes...)
+	for n := g.rnd.Intn(g.c.MaxLocals + 1); n > 0; n-- {
+		g.locals = append(g.locals, g.valueType())
+	}
+	g.counters = 0
+	g.code = nil
+	g.budget = g.c.MaxBodySize
+	g.depth = 0
+
+	result := noValue
+	if len(sig.ReturnTypes) != 0 {
+		result = sig.ReturnTypes[0]
+	}
+	// the body is the block of the function, branching to which returns.
+	g.labels = []wasm.ValueType{result}
+	g.consumeFuel()
+	g.seq(result)
+
+	code, err := disasm.Assemble(g.code)
+	if err != nil {
+		return wasm.FunctionBody{}, err
+	}
+	var locals []wasm.LocalEntry
+	for _, t := range g.locals[len(sig.ParamTypes):] {
+		if n := len(locals); n != 0 && locals[n-1].Type == t {
+			locals[n-1].Count++
+		} else {
+			locals = append(locals, wasm.LocalEntry{Count: 1, Type: t})
+		}
+	}
+	if g.counters != 0 {
+		locals = append(locals, wasm.LocalEntry{Count: uint32(g.counters), Type: wasm.ValueTypeI32})
+	}
+	return wasm.FunctionBody{Locals: locals, Code: code}, nil
+}
+
+// emit appends an instruction to the code of the function.
+func (g *generator) emit(code byte, imms ...interface{}) {
+	g.emitOp(instr(code, imms...).Op, imms...)
+}
+
+func (g *generator) emitOp(op ops.Op, imms ...interface{}) {
+	g.code = append(g.code, disasm.Instr{Op: op, Immediates: imms})
+	g.budget--
+}
+
+// full returns whether the function can't grow any deeper, or any longer.
+func (g *generator) full() bool {
+	return g.depth >= g.c.MaxDepth || g.budget <= 0
+}
+
+// consumeFuel generates the code consuming a unit of fuel, which traps if
+// the fuel is exhausted.
+func (g *generator) consumeFuel() {
+	if !g.fuel {
+		return
+	}
+	g.emit(ops.GetGlobal, uint32(0))
+	g.emit(ops.I32Eqz)
+	g.emit(ops.If, wasm.BlockTypeEmpty)
+	g.emit(ops.Unreachable)
+	g.emit(ops.End)
+	g.emit(ops.GetGlobal, uint32(0))
+	g.emit(ops.I32Const, int32(1))
+	g.emit(ops.I32Sub)
+	g.emit(ops.SetGlobal, uint32(0))
+}
+
+// seq generates the instructions of a block whose result is of type t:
+// statements, followed by a value of type t or by a branch.
+func (g *generator) seq(t wasm.ValueType) {
+	g.stmts()
+	g.tail(t)
+}
+
+// tail generates the end of a block whose result is of type t.
+func (g *generator) tail(t wasm.ValueType) {
+	if g.rnd.Intn(8) == 0 {
+		g.branch()
+		return
+	}
+	if t != noValue {
+		g.expr(t)
+	}
+}
+
+// stmts generates a few instructions leaving the stack unchanged.
+func (g *generator) stmts() {
+	for n := g.rnd.Intn(4); n > 0 && !g.full(); n-- {
+		g.stmt()
+	}
+}
+
+func (g *generator) stmt() {
+	g.depth++
+	defer func() { g.depth-- }()
+	for {
+		switch g.rnd.Intn(13) {
+		case 0:
+			t := g.valueType()
+			g.expr(t)
+			g.emit(ops.Drop)
+		case 1, 2:
+			if len(g.locals) == 0 {
+				continue
+			}
+			i := g.rnd.Intn(len(g.locals))
+			g.expr(g.locals[i])
+			g.emit(ops.SetLocal, uint32(i))
+		case 3:
+			if !g.memory {
+				continue
+			}
+			g.store()
+		case 4:
+			g.block(noValue)
+		case 5:
+			g.loop(noValue)
+		case 6:
+			g.ifElse(noValue)
+		case 7:
+			n, ok := g.label(noValue)
+			if !ok {
+				continue
+			}
+			g.expr(wasm.ValueTypeI32)
+			g.emit(ops.BrIf, n)
+		case 8:
+			fn := g.rnd.Intn(len(g.funcs))
+			sig := g.call(fn)
+			if len(sig.ReturnTypes) != 0 {
+				g.emit(ops.Drop)
+			}
+		case 9:
+			g.emit(ops.Nop)
+		case 10:
+			i, ok := g.global(g.valueType(), true)
+			if !ok {
+				continue
+			}
+			g.expr(g.globals[i].Type)
+			g.emit(ops.SetGlobal, i)
+		case 11:
+			if g.table == 0 {
+				continue
+			}
+			sig := g.callIndirect(g.rnd.Intn(len(g.types)))
+			if len(sig.ReturnTypes) != 0 {
+				g.emit(ops.Drop)
+			}
+		case 12:
+			if !g.memory || g.c.NoBulkMemory {
+				continue
+			}
+			g.bulkMemory()
+		}
+		return
+	}
+}
+
+// branch generates an unconditional branch, which ends a block.
+func (g *generator) branch() {
+	switch g.rnd.Intn(8) {
+	case 0:
+		g.emit(ops.Unreachable)
+	case 1:
+		if len(g.sig.ReturnTypes) != 0 {
+			g.expr(g.sig.ReturnTypes[0])
+		}
+		g.emit(ops.Return)
+	case 2, 3:
+		// all the targets of br_table take values of the same type.
+		t := g.labels[g.rnd.Intn(len(g.labels))]
+		var targets []interface{}
+		for i := g.rnd.Intn(4); i >= 0; i-- {
+			n, _ := g.label(t)
+			targets = append(targets, n)
+		}
+		if t != noValue {
+			g.expr(t)
+		}
+		g.expr(wasm.ValueTypeI32)
+		g.emit(ops.BrTable, append([]interface{}{uint32(len(targets) - 1)}, targets...)...)
+	default:
+		n := g.rnd.Intn(len(g.labels))
+		if t := g.labels[len(g.labels)-1-n]; t != noValue {
+			g.expr(t)
+		}
+		g.emit(ops.Br, uint32(n))
+	}
+}
+
+// label returns the relative depth of a random enclosing block, the
+// branches to which take a value of type t.
+func (g *generator) label(t wasm.ValueType) (uint32, bool) {
+	var depths []uint32
+	for i, l := range g.labels {
+		if l == t {
+			depths = append(depths, uint32(len(g.labels)-1-i))
+		}
+	}
+	if len(depths) == 0 {
+		return 0, false
+	}
+	return depths[g.rnd.Intn(len(depths))], true
+}
+
+// block generates a block whose result is of type t.
+func (g *generator) block(t wasm.ValueType) {
+	g.emit(ops.Block, wasm.BlockType(t))
+	g.labels = append(g.labels, t)
+	g.seq(t)
+	g.labels = g.labels[:len(g.labels)-1]
+	g.emit(ops.End)
+}
+
+// loop generates a loop whose result is of type t. The loop iterates a few
+// times, as counted by a local variable of its own, unless the code of its
+// body branches to it.
+func (g *generator) loop(t wasm.ValueType) {
+	counter := uint32(len(g.locals) + g.counters)
+	g.counters++
+	g.emit(ops.I32Const, int32(g.rnd.Intn(4)))
+	g.emit(ops.SetLocal, counter)
+
+	g.emit(ops.Loop, wasm.BlockType(t))
+	g.labels = append(g.labels, noValue)
+	g.consumeFuel()
+	g.stmts()
+	g.emit(ops.GetLocal, counter)
+	g.emit(ops.I32Const, int32(1))
+	g.emit(ops.I32Sub)
+	g.emit(ops.TeeLocal, counter)
+	g.emit(ops.I32Const, int32(0))
+	g.emit(ops.I32GtS)
+	g.emit(ops.BrIf, uint32(0))
+	g.tail(t)
+	g.labels = g.labels[:len(g.labels)-1]
+	g.emit(ops.End)
+}
+
+// ifElse generates an if block whose result is of type t.
+func (g *generator) ifElse(t wasm.ValueType) {
+	g.expr(wasm.ValueTypeI32)
+	g.emit(ops.If, wasm.BlockType(t))
+	g.labels = append(g.labels, t)
+	g.seq(t)
+	if t != noValue || g.rnd.Intn(2) == 0 {
+		g.emit(ops.Else)
+		g.seq(t)
+	}
+	g.labels = g.labels[:len(g.labels)-1]
+	g.emit(ops.End)
+}
+
+// call generates a call to the function at index fn, and returns its
+// type.
+func (g *generator) call(fn int) *wasm.FunctionSig {
+	sig := &g.types[g.funcs[fn]]
+	for _, t := range sig.ParamTypes {
+		g.expr(t)
+	}
+	g.emit(ops.Call, uint32(fn))
+	return sig
+}
+
+// callIndirect generates a call_indirect of a function of the type at
+// index typ, most often through an element of the table, and returns the
+// type.
+func (g *generator) callIndirect(typ int) *wasm.FunctionSig {
+	sig := &g.types[typ]
+	for _, t := range sig.ParamTypes {
+		g.expr(t)
+	}
+	if g.rnd.Intn(4) != 0 {
+		g.emit(ops.I32Const, int32(g.rnd.Intn(g.table+1)))
+	} else {
+		g.expr(wasm.ValueTypeI32)
+	}
+	g.emit(ops.CallIndirect, uint32(typ), uint32(0))
+	return sig
+}
+
+// bulkMemory generates an operator of the bulk memory proposal, whose
+// lengths are most often small.
+func (g *generator) bulkMemory() {
+	length := func(max int) {
+		if g.rnd.Intn(4) != 0 {
+			g.emit(ops.I32Const, int32(g.rnd.Intn(max+1)))
+		} else {
+			g.expr(wasm.ValueTypeI32)
+		}
+	}
+	// memory.init and data.drop need a data segment.
+	n := 2
+	if len(g.data) != 0 {
+		n = 4
+	}
+	switch g.rnd.Intn(n) {
+	case 0:
+		g.address()
+		g.expr(wasm.ValueTypeI32)
+		length(64)
+		g.emitOp(miscOp(ops.MemoryFill), uint8(0))
+	case 1:
+		g.address()
+		g.address()
+		length(64)
+		g.emitOp(miscOp(ops.MemoryCopy), uint8(0), uint8(0))
+	case 2:
+		seg := g.rnd.Intn(len(g.data))
+		src := g.rnd.Intn(g.data[seg] + 1)
+		g.address()
+		g.emit(ops.I32Const, int32(src))
+		length(g.data[seg] - src)
+		g.emitOp(miscOp(ops.MemoryInit), uint32(seg), uint8(0))
+	case 3:
+		g.emitOp(miscOp(ops.DataDrop), uint32(g.rnd.Intn(len(g.data))))
+	}
+}
+
+// miscOp returns the operator prefixed by ops.MiscPrefix with the
+// sub-opcode sub.
+func miscOp(sub uint32) ops.Op {
+	op, err := ops.NewPrefixed(ops.MiscPrefix, sub)
+	if err != nil {
+		panic(err)
+	}
+	return op
+}
+
+// expr generates instructions pushing a single value of type t.
+func (g *generator) expr(t wasm.ValueType) {
+	if g.full() {
+		g.leaf(t)
+		return
+	}
+	g.depth++
+	defer func() { g.depth-- }()
+	for {
+		switch g.rnd.Intn(18) {
+		case 0, 1:
+			g.leaf(t)
+		case 2, 3, 4, 5:
+			op := g.numOps[t][g.rnd.Intn(len(g.numOps[t]))]
+			for _, arg := range op.Args {
+				g.expr(arg)
+			}
+			g.emitOp(op)
+		case 6:
+			if !g.memory {
+				continue
+			}
+			g.load(t)
+		case 7:
+			i, ok := g.local(t)
+			if !ok {
+				continue
+			}
+			g.expr(t)
+			g.emit(ops.TeeLocal, i)
+		case 8:
+			g.expr(t)
+			g.expr(t)
+			g.expr(wasm.ValueTypeI32)
+			g.emit(ops.Select)
+		case 9:
+			g.block(t)
+		case 10:
+			g.loop(t)
+		case 11:
+			g.ifElse(t)
+		case 12:
+			// the value is the one of a branch, taken if the
+			// condition holds.
+			n, ok := g.label(t)
+			if !ok {
+				continue
+			}
+			g.expr(t)
+			g.expr(wasm.ValueTypeI32)
+			g.emit(ops.BrIf, n)
+		case 13:
+			var fns []int
+			for i, typ := range g.funcs {
+				if r := g.types[typ].ReturnTypes; len(r) != 0 && r[0] == t {
+					fns = append(fns, i)
+				}
+			}
+			if len(fns) == 0 {
+				continue
+			}
+			g.call(fns[g.rnd.Intn(len(fns))])
+		case 14, 15:
+			if t != wasm.ValueTypeI32 || !g.memory {
+				continue
+			}
+			if g.rnd.Intn(2) == 0 {
+				g.emit(ops.CurrentMemory, uint8(0))
+				break
+			}
+			// the memory can only grow by a few pages.
+			if g.rnd.Intn(4) != 0 {
+				g.emit(ops.I32Const, int32(g.rnd.Intn(3)))
+			} else {
+				g.expr(wasm.ValueTypeI32)
+			}
+			g.emit(ops.GrowMemory, uint8(0))
+		case 16:
+			i, ok := g.global(t, false)
+			if !ok {
+				continue
+			}
+			g.emit(ops.GetGlobal, i)
+		case 17:
+			if g.table == 0 {
+				continue
+			}
+			var types []int
+			for i, sig := range g.types {
+				if len(sig.ReturnTypes) != 0 && sig.ReturnTypes[0] == t {
+					types = append(types, i)
+				}
+			}
+			if len(types) == 0 {
+				continue
+			}
+			g.callIndirect(types[g.rnd.Intn(len(types))])
+		}
+		return
+	}
+}
+
+// leaf generates an instruction pushing a value of type t, a constant or
+// a local variable.
+func (g *generator) leaf(t wasm.ValueType) {
+	if i, ok := g.local(t); ok && g.rnd.Intn(2) == 0 {
+		g.emit(ops.GetLocal, i)
+		return
+	}
+	c := g.constant(t)
+	g.emitOp(c.Op, c.Immediates...)
+}
+
+// constant returns a const instruction pushing a random value of type t.
+func (g *generator) constant(t wasm.ValueType) disasm.Instr {
+	v := Value(g.rnd, t)
+	switch t {
+	case wasm.ValueTypeI64:
+		return instr(ops.I64Const, int64(v))
+	case wasm.ValueTypeF32:
+		return instr(ops.F32Const, math.Float32frombits(uint32(v)))
+	case wasm.ValueTypeF64:
+		return instr(ops.F64Const, math.Float64frombits(v))
+	}
+	return instr(ops.I32Const, int32(v))
+}
+
+// local returns the index of a random local variable of type t.
+func (g *generator) local(t wasm.ValueType) (uint32, bool) {
+	var locals []uint32
+	for i, l := range g.locals {
+		if l == t {
+			locals = append(locals, uint32(i))
+		}
+	}
+	if len(locals) == 0 {
+		return 0, false
+	}
+	return locals[g.rnd.Intn(len(locals))], true
+}
+
+// global returns the index of a random global variable of type t, which
+// is mutable if mutable is set. The global holding the fuel is left out.
+func (g *generator) global(t wasm.ValueType, mutable bool) (uint32, bool) {
+	var globals []uint32
+	for i, global := range g.globals {
+		if global.Type == t && (global.Mutable || !mutable) && !(i == 0 && g.fuel) {
+			globals = append(globals, uint32(i))
+		}
+	}
+	if len(globals) == 0 {
+		return 0, false
+	}
+	return globals[g.rnd.Intn(len(globals))], true
+}
+
+// address generates the address of a memory access, which is most often
+// in the first page of the memory.
+func (g *generator) address() {
+	g.expr(wasm.ValueTypeI32)
+	if g.rnd.Intn(4) != 0 {
+		g.emit(ops.I32Const, int32(wasmPageSize-1))
+		g.emit(ops.I32And)
+	}
+}
+
+// memoryImmediate returns the immediates of the memory access op, with a
+// random alignment and offset.
+func (g *generator) memoryImmediate(op ops.Op) []interface{} {
+	align := uint32(0)
+	for size := accessSize[op.Code]; size > 1<<align; {
+		align++
+	}
+	offset := uint64(g.rnd.Intn(16))
+	if g.rnd.Intn(16) == 0 {
+		offset = uint64(g.rnd.Int31())
+	}
+	return []interface{}{uint32(g.rnd.Intn(int(align) + 1)), offset, uint8(0)}
+}
+
+func (g *generator) load(t wasm.ValueType) {
+	op := loadOps[t][g.rnd.Intn(len(loadOps[t]))]
+	g.address()
+	g.emitOp(op, g.memoryImmediate(op)...)
+}
+
+func (g *generator) store() {
+	t := g.valueType()
+	op := storeOps[t][g.rnd.Intn(len(storeOps[t]))]
+	g.address()
+	g.expr(t)
+	g.emitOp(op, g.memoryImmediate(op)...)
+}
+
+// Value returns a random value of type t, given as the arguments of
+// exec.VM.ExecCode: the bits of the value, zero-extended to 64 bits. The
+// limits of the types, NaNs and other special values are more likely than
+// the others.
+func Value(rnd *rand.Rand, t wasm.ValueType) uint64 {
+	switch t {
+	case wasm.ValueTypeI32:
+		return uint64(uint32(intValue(rnd, 32)))
+	case wasm.ValueTypeI64:
+		return uint64(intValue(rnd, 64))
+	case wasm.ValueTypeF32:
+		if rnd.Intn(4) == 0 {
+			return uint64(rnd.Uint32())
+		}
+		return uint64(math.Float32bits(float32(floatValue(rnd))))
+	case wasm.ValueTypeF64:
+		if rnd.Intn(4) == 0 {
+			return rnd.Uint64()
+		}
+		return math.Float64bits(floatValue(rnd))
+	}
+	return 0
+}
+
+// intValue returns a random integer of the given size.
+func intValue(rnd *rand.Rand, bits uint) int64 {
+	switch rnd.Intn(8) {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	case 2:
+		// the minimum, or the maximum
+		return -1<<(bits-1) - int64(rnd.Intn(2))
+	case 3:
+		return 1 << uint(rnd.Intn(int(bits)))
+	case 4, 5:
+		return int64(rnd.Intn(33)) - 16
+	}
+	return int64(rnd.Uint64())
+}
+
+// specialFloats are the values which are most likely to break float
+// operators and conversions.
+var specialFloats = []float64{
+	0, math.Copysign(0, -1), 1, -1, 0.5, -0.5, 1.5, 2.5, -2.5,
+	math.Inf(1), math.Inf(-1), math.NaN(),
+	math.MaxFloat32, math.SmallestNonzeroFloat32, math.MaxFloat64, math.SmallestNonzeroFloat64,
+	math.MaxInt32, math.MinInt32, math.MaxUint32, 1 << 31, -(1 << 31) - 1,
+	1 << 63, -(1 << 63), 1 << 64, 1<<63 - 1024,
+}
+
+func floatValue(rnd *rand.Rand) float64 {
+	switch rnd.Intn(3) {
+	case 0:
+		return specialFloats[rnd.Intn(len(specialFloats))]
+	case 1:
+		return float64(rnd.Intn(201)-100) / 4
+	}
+	return rnd.NormFloat64() * math.Pow(2, float64(rnd.Intn(129)-64))
+}
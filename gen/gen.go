@@ -0,0 +1,382 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gen generates random valid WebAssembly modules, in the spirit of
+// wasm-smith, to fuzz the programs embedding wagon, to test the packages of
+// wagon against each other, and to benchmark them.
+//
+// The modules are made of function types, functions, a memory, a table and
+// global variables. The bodies of the functions mix structured control
+// flow with numeric, variable, memory and call instructions. They are
+// well-typed by construction, so that validate.VerifyModule accepts them.
+// Unless disabled, the loops and the functions consume the fuel held by
+// the first global of the module, and trap once it is exhausted, so that
+// all the functions terminate.
+package gen
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// Config sets the size and the features of the generated modules. A zero
+// limit is replaced by its default, and a negative one by 0, while the
+// zero Config enables all the features.
+type Config struct {
+	// MaxTypes and MaxFunctions limit the number of function types and
+	// of functions of the module.
+	MaxTypes     int
+	MaxFunctions int
+	// MaxParams limits the number of parameters of the function types,
+	// and MaxLocals the number of local variables declared by each
+	// function, besides the counters of its loops.
+	MaxParams int
+	MaxLocals int
+	// MaxBodySize limits the number of instructions of each function,
+	// which can be exceeded by the few instructions closing its blocks.
+	MaxBodySize int
+	// MaxDepth limits the nesting of the blocks and of the expressions.
+	MaxDepth int
+	// MaxMemoryPages limits the maximum size of the memory of the module,
+	// which has no memory with a limit of 0. MaxDataSegments limits the
+	// number of data segments initializing it.
+	MaxMemoryPages  int
+	MaxDataSegments int
+	// MaxTableSize limits the size of the table of the module, whose
+	// functions are called by call_indirect, and which has no table with
+	// a limit of 0. MaxElemSegments limits the number of element segments
+	// initializing it.
+	MaxTableSize    int
+	MaxElemSegments int
+	// MaxGlobals limits the number of global variables of the module,
+	// besides the one holding the fuel.
+	MaxGlobals int
+	// Fuel is the number of function calls and loop iterations after
+	// which the functions trap. With a limit of 0, the functions don't
+	// consume fuel, and may not terminate.
+	Fuel int
+
+	// NoFloat keeps the float types out of the module, which is then
+	// accepted by validate with Options.NoFloat.
+	NoFloat bool
+	// NoSignExtension, NoSaturatingConversions and NoBulkMemory keep the
+	// operators of these proposals out of the module. With all three, the
+	// module only uses the features of the MVP.
+	NoSignExtension         bool
+	NoSaturatingConversions bool
+	NoBulkMemory            bool
+}
+
+// Default limits of Config, for small modules which run quickly.
+const (
+	DefaultMaxTypes        = 8
+	DefaultMaxFunctions    = 8
+	DefaultMaxParams       = 4
+	DefaultMaxLocals       = 8
+	DefaultMaxBodySize     = 100
+	DefaultMaxDepth        = 6
+	DefaultMaxMemoryPages  = 2
+	DefaultMaxDataSegments = 4
+	DefaultMaxTableSize    = 8
+	DefaultMaxElemSegments = 2
+	DefaultMaxGlobals      = 4
+	DefaultFuel            = 1000
+)
+
+// withDefaults returns the config with the default limits set, and with
+// negative limits replaced by 0.
+func (c Config) withDefaults() Config {
+	limit := func(n *int, def int) {
+		switch {
+		case *n == 0:
+			*n = def
+		case *n < 0:
+			*n = 0
+		}
+	}
+	limit(&c.MaxTypes, DefaultMaxTypes)
+	limit(&c.MaxFunctions, DefaultMaxFunctions)
+	limit(&c.MaxParams, DefaultMaxParams)
+	limit(&c.MaxLocals, DefaultMaxLocals)
+	limit(&c.MaxBodySize, DefaultMaxBodySize)
+	limit(&c.MaxDepth, DefaultMaxDepth)
+	limit(&c.MaxMemoryPages, DefaultMaxMemoryPages)
+	limit(&c.MaxDataSegments, DefaultMaxDataSegments)
+	limit(&c.MaxTableSize, DefaultMaxTableSize)
+	limit(&c.MaxElemSegments, DefaultMaxElemSegments)
+	limit(&c.MaxGlobals, DefaultMaxGlobals)
+	limit(&c.Fuel, DefaultFuel)
+	return c
+}
+
+// Module generates a random module, whose functions are exported as "f0",
+// "f1"..., whose memory is exported as "memory", and whose table is
+// exported as "table". The module is returned as read by wasm.ReadModule
+// from its binary encoding.
+func Module(rnd *rand.Rand, c Config) (*wasm.Module, error) {
+	b, err := Bytes(rnd, c)
+	if err != nil {
+		return nil, err
+	}
+	return wasm.ReadModule(bytes.NewReader(b), nil)
+}
+
+// Bytes generates a random module, as Module does, and returns its binary
+// encoding.
+func Bytes(rnd *rand.Rand, c Config) ([]byte, error) {
+	m, err := newGenerator(rnd, c).module()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// valueTypes are the types of the values of the generated code, the
+// float types last.
+var valueTypes = []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64}
+
+// generator holds the state of the generation of a module, and of the
+// function being generated.
+type generator struct {
+	rnd *rand.Rand
+	c   Config
+	// the value types and the numeric operators enabled by c.
+	valueTypes []wasm.ValueType
+	numOps     map[wasm.ValueType][]ops.Op
+
+	types   []wasm.FunctionSig
+	funcs   []uint32 // the type of each function
+	globals []wasm.GlobalVar
+	fuel    bool  // whether global 0 holds the fuel
+	memory  bool  // whether the module has a memory
+	data    []int // the size of each data segment, if bulk memory is enabled
+	table   int   // the size of the table
+
+	sig      *wasm.FunctionSig
+	locals   []wasm.ValueType // the parameters and the local variables
+	counters int              // the number of loop counters, declared after the locals
+	labels   []wasm.ValueType // the type of the branches to the enclosing blocks, innermost last
+	code     []disasm.Instr
+	budget   int // the number of instructions left to the function
+	depth    int
+}
+
+// newGenerator returns a generator of modules configured by c.
+func newGenerator(rnd *rand.Rand, c Config) *generator {
+	g := &generator{rnd: rnd, c: c.withDefaults()}
+	g.valueTypes = valueTypes
+	if c.NoFloat {
+		g.valueTypes = valueTypes[:2]
+	}
+	g.numOps = make(map[wasm.ValueType][]ops.Op)
+	for _, t := range g.valueTypes {
+		for _, op := range numOps[t] {
+			if g.enabled(op) {
+				g.numOps[t] = append(g.numOps[t], op)
+			}
+		}
+	}
+	return g
+}
+
+// enabled returns whether the numeric operator op is enabled by the
+// config.
+func (g *generator) enabled(op ops.Op) bool {
+	switch {
+	case g.c.NoSignExtension && op.Code >= ops.I32Extend8S && op.Code <= ops.I64Extend32S:
+		return false
+	case g.c.NoSaturatingConversions && op.Code == ops.MiscPrefix:
+		return false
+	case g.c.NoFloat:
+		if isFloat(op.Returns) {
+			return false
+		}
+		for _, t := range op.Args {
+			if isFloat(t) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isFloat(t wasm.ValueType) bool {
+	return t == wasm.ValueTypeF32 || t == wasm.ValueTypeF64
+}
+
+// module generates the sections of the module.
+func (g *generator) module() (*wasm.Module, error) {
+	m := &wasm.Module{Version: 1}
+	export := &wasm.SectionExports{Entries: make(map[string]wasm.ExportEntry)}
+	addExport := func(name string, kind wasm.External, index uint32) {
+		export.Entries[name] = wasm.ExportEntry{FieldStr: name, Kind: kind, Index: index}
+		export.Names = append(export.Names, name)
+	}
+
+	if g.c.MaxTypes > 0 && g.c.MaxFunctions > 0 {
+		m.Types = &wasm.SectionTypes{}
+		for n := 1 + g.rnd.Intn(g.c.MaxTypes); n > 0; n-- {
+			m.Types.Entries = append(m.Types.Entries, g.funcType())
+		}
+		g.types = m.Types.Entries
+		m.Function = &wasm.SectionFunctions{}
+		for n := 1 + g.rnd.Intn(g.c.MaxFunctions); n > 0; n-- {
+			m.Function.Types = append(m.Function.Types, uint32(g.rnd.Intn(len(g.types))))
+		}
+		g.funcs = m.Function.Types
+	}
+
+	if g.c.MaxTableSize > 0 && len(g.funcs) != 0 {
+		// unlike the memory, the table has a fixed size.
+		g.table = 1 + g.rnd.Intn(g.c.MaxTableSize)
+		m.Table = &wasm.SectionTables{Entries: []wasm.Table{{
+			ElementType: wasm.ElemTypeAnyFunc,
+			Limits:      wasm.ResizableLimits{Flags: 1, Initial: uint32(g.table), Maximum: uint32(g.table)},
+		}}}
+		addExport("table", wasm.ExternalTable, 0)
+		if n := g.rnd.Intn(g.c.MaxElemSegments + 1); n > 0 {
+			m.Elements = &wasm.SectionElements{}
+			for ; n > 0; n-- {
+				offset := g.rnd.Intn(g.table)
+				seg := wasm.ElementSegment{
+					Offset: initExpr(instr(ops.I32Const, int32(offset))),
+					Type:   wasm.ElemTypeAnyFunc,
+				}
+				for i := 1 + g.rnd.Intn(g.table-offset); i > 0; i-- {
+					seg.Elems = append(seg.Elems, uint32(g.rnd.Intn(len(g.funcs))))
+				}
+				m.Elements.Entries = append(m.Elements.Entries, seg)
+			}
+		}
+	}
+
+	if g.c.MaxMemoryPages > 0 {
+		g.memory = true
+		// the memory always has a maximum, so that it can't grow much.
+		initial := 1 + g.rnd.Intn(g.c.MaxMemoryPages)
+		maximum := initial + g.rnd.Intn(g.c.MaxMemoryPages-initial+1)
+		m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{
+			Limits: wasm.ResizableLimits{Flags: 1, Initial: uint32(initial), Maximum: uint32(maximum)},
+		}}}
+		addExport("memory", wasm.ExternalMemory, 0)
+		if n := g.rnd.Intn(g.c.MaxDataSegments + 1); n > 0 {
+			m.Data = &wasm.SectionData{}
+			for ; n > 0; n-- {
+				data := make([]byte, 1+g.rnd.Intn(64))
+				g.rnd.Read(data)
+				seg := wasm.DataSegment{Data: data}
+				if !g.c.NoBulkMemory && g.rnd.Intn(3) == 0 {
+					seg.Mode = wasm.SegmentPassive
+				} else {
+					offset := g.rnd.Intn(initial*wasmPageSize - len(data) + 1)
+					seg.Offset = initExpr(instr(ops.I32Const, int32(offset)))
+				}
+				m.Data.Entries = append(m.Data.Entries, seg)
+			}
+			if !g.c.NoBulkMemory {
+				// needed by memory.init and data.drop.
+				m.DataCount = &wasm.SectionDataCount{Count: uint32(len(m.Data.Entries))}
+				for _, seg := range m.Data.Entries {
+					g.data = append(g.data, len(seg.Data))
+				}
+			}
+		}
+	}
+
+	if g.c.Fuel > 0 {
+		g.fuel = true
+		g.globals = append(g.globals, wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true})
+	}
+	for n := g.rnd.Intn(g.c.MaxGlobals + 1); n > 0; n-- {
+		g.globals = append(g.globals, wasm.GlobalVar{Type: g.valueType(), Mutable: g.rnd.Intn(2) == 0})
+	}
+	if len(g.globals) != 0 {
+		m.Global = &wasm.SectionGlobals{}
+		for i, global := range g.globals {
+			var init disasm.Instr
+			if i == 0 && g.fuel {
+				init = instr(ops.I32Const, int32(g.c.Fuel))
+			} else {
+				init = g.constant(global.Type)
+			}
+			m.Global.Globals = append(m.Global.Globals, wasm.GlobalEntry{Type: global, Init: initExpr(init)})
+		}
+	}
+
+	if len(g.funcs) != 0 {
+		m.Code = &wasm.SectionCode{}
+		for i, t := range g.funcs {
+			body, err := g.function(&g.types[t])
+			if err != nil {
+				return nil, err
+			}
+			m.Code.Bodies = append(m.Code.Bodies, body)
+			addExport(funcName(i), wasm.ExternalFunction, uint32(i))
+		}
+	}
+	if len(export.Entries) != 0 {
+		m.Export = export
+	}
+
+	for _, s := range []wasm.Section{m.Types, m.Function, m.Table, m.Memory, m.Global, m.Export, m.Elements, m.DataCount, m.Code, m.Data} {
+		// the missing sections are typed nil pointers.
+		if !reflect.ValueOf(s).IsNil() {
+			m.Sections = append(m.Sections, s)
+		}
+	}
+	return m, nil
+}
+
+const wasmPageSize = 65536
+
+// funcName returns the name under which the function at index i is
+// exported.
+func funcName(i int) string {
+	return "f" + strconv.Itoa(i)
+}
+
+// funcType generates a function type, with one result at most.
+func (g *generator) funcType() wasm.FunctionSig {
+	sig := wasm.FunctionSig{Form: int8(wasm.TypeFunc)}
+	for n := g.rnd.Intn(g.c.MaxParams + 1); n > 0; n-- {
+		sig.ParamTypes = append(sig.ParamTypes, g.valueType())
+	}
+	if g.rnd.Intn(4) != 0 {
+		sig.ReturnTypes = []wasm.ValueType{g.valueType()}
+	}
+	return sig
+}
+
+func (g *generator) valueType() wasm.ValueType {
+	return g.valueTypes[g.rnd.Intn(len(g.valueTypes))]
+}
+
+// instr returns the instruction made of the operator code and of its
+// immediates.
+func instr(code byte, imms ...interface{}) disasm.Instr {
+	op, err := ops.New(code)
+	if err != nil {
+		panic(err)
+	}
+	return disasm.Instr{Op: op, Immediates: imms}
+}
+
+// initExpr returns the initializer expression made of instrs.
+func initExpr(instrs ...disasm.Instr) []byte {
+	code, err := disasm.Assemble(append(instrs, instr(ops.End)))
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gen_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/gen"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+func TestModuleValid(t *testing.T) {
+	n := 500
+	if testing.Short() {
+		n = 50
+	}
+	for seed := int64(0); seed < int64(n); seed++ {
+		m, err := gen.Module(rand.New(rand.NewSource(seed)), gen.Config{})
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if err := validate.VerifyModule(m); err != nil {
+			buf := new(bytes.Buffer)
+			wast.WriteTo(buf, m)
+			t.Fatalf("seed %d: %v\n%s", seed, err, buf)
+		}
+	}
+}
+
+func TestModuleDeterministic(t *testing.T) {
+	encode := func() []byte {
+		m, err := gen.Module(rand.New(rand.NewSource(1)), gen.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := wasm.EncodeModule(buf, m); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	if !bytes.Equal(encode(), encode()) {
+		t.Error("modules generated from the same seed differ")
+	}
+}
+
+func TestModuleConfig(t *testing.T) {
+	mvp := gen.Config{NoSignExtension: true, NoSaturatingConversions: true, NoBulkMemory: true}
+	for _, test := range []struct {
+		name string
+		c    gen.Config
+	}{
+		{"mvp", mvp},
+		{"nofloat", gen.Config{NoFloat: true}},
+		{"mvp-nofloat", gen.Config{NoFloat: true, NoSignExtension: true, NoSaturatingConversions: true, NoBulkMemory: true}},
+		{"nomemory", gen.Config{MaxMemoryPages: -1, MaxTableSize: -1, MaxGlobals: -1, Fuel: -1}},
+		{"nofunctions", gen.Config{MaxFunctions: -1}},
+		{"small", gen.Config{MaxTypes: 1, MaxFunctions: 1, MaxParams: -1, MaxLocals: -1, MaxBodySize: 1, MaxDepth: 1}},
+		{"large", gen.Config{MaxFunctions: 50, MaxBodySize: 1000, MaxDepth: 20, MaxTableSize: 100, MaxGlobals: 20}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for seed := int64(0); seed < 50; seed++ {
+				m, err := gen.Module(rand.New(rand.NewSource(seed)), test.c)
+				if err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+				if err := validate.VerifyModuleWithOptions(m, validate.Options{NoFloat: test.c.NoFloat}); err != nil {
+					buf := new(bytes.Buffer)
+					wast.WriteTo(buf, m)
+					t.Fatalf("seed %d: %v\n%s", seed, err, buf)
+				}
+				if err := checkFeatures(m, test.c); err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+			}
+		})
+	}
+}
+
+// checkFeatures returns an error if m uses features disabled by c.
+func checkFeatures(m *wasm.Module, c gen.Config) error {
+	if m.DataCount != nil && c.NoBulkMemory {
+		return fmt.Errorf("data count section")
+	}
+	if m.Data != nil {
+		for _, seg := range m.Data.Entries {
+			if seg.Mode != wasm.SegmentActive && c.NoBulkMemory {
+				return fmt.Errorf("passive data segment")
+			}
+		}
+	}
+	for _, fn := range m.FunctionIndexSpace {
+		code, err := disasm.Disassemble(fn.Body.Code)
+		if err != nil {
+			return err
+		}
+		for _, instr := range code {
+			op := instr.Op
+			switch {
+			case op.Code >= ops.I32Extend8S && op.Code <= ops.I64Extend32S && c.NoSignExtension:
+				return fmt.Errorf("sign extension operator %s", op.Name)
+			case op.Code == ops.MiscPrefix && op.Sub <= ops.I64TruncSatUF64 && c.NoSaturatingConversions:
+				return fmt.Errorf("saturating conversion %s", op.Name)
+			case op.Code == ops.MiscPrefix && op.Sub > ops.I64TruncSatUF64 && c.NoBulkMemory:
+				return fmt.Errorf("bulk memory operator %s", op.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func BenchmarkModule(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i++ {
+		if _, err := gen.Module(rnd, gen.Config{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNewVM measures the creation of VMs, which compiles all the
+// functions, for generated modules.
+func BenchmarkNewVM(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	modules := make([]*wasm.Module, 64)
+	for i := range modules {
+		m, err := gen.Module(rnd, gen.Config{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		modules[i] = m
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := exec.NewVM(modules[i%len(modules)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1 +1,3 @@
 module github.com/go-interpreter/wagon
+
+go 1.18
@@ -0,0 +1,216 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var errNotImplemented = &Exception{Value: NewError("ENOSYS", "not implemented")}
+
+// newGlobal returns the global object of the runtime r, with the
+// properties the Go runtime and the standard library use: the Object,
+// Array, Uint8Array and Date constructors, and the fs, process, path and
+// console objects of Node.js.
+func (r *Runtime) newGlobal() *Object {
+	object := NewConstructor(func(args []Value) (Value, error) {
+		return NewObject(nil), nil
+	})
+	array := NewConstructor(func(args []Value) (Value, error) {
+		n := 0
+		if len(args) == 1 {
+			if f, ok := args[0].(float64); ok {
+				n = int(f)
+			}
+		}
+		elems := make([]Value, n)
+		for i := range elems {
+			elems[i] = Undefined
+		}
+		return &Object{Props: make(map[string]Value), Elems: elems}, nil
+	})
+	uint8Array := NewConstructor(func(args []Value) (Value, error) {
+		n := 0
+		if len(args) >= 1 {
+			if f, ok := args[0].(float64); ok && f >= 0 {
+				n = int(f)
+			}
+		}
+		return &Object{Props: make(map[string]Value), Bytes: make([]byte, n)}, nil
+	})
+	date := NewConstructor(func(args []Value) (Value, error) {
+		_, offset := r.stdio.Now().Zone()
+		return NewObject(map[string]Value{
+			"getTimezoneOffset": NewFunc(func(this Value, args []Value) (Value, error) {
+				return float64(-offset / 60), nil
+			}),
+		}), nil
+	})
+
+	global := NewObject(map[string]Value{
+		"Object":     object,
+		"Array":      array,
+		"Uint8Array": uint8Array,
+		"Date":       date,
+		"fs":         r.newFS(),
+		"process":    newProcess(),
+		"path": NewObject(map[string]Value{
+			"resolve": NewFunc(func(this Value, args []Value) (Value, error) {
+				parts := make([]string, len(args))
+				for i, arg := range args {
+					parts[i] = toString(arg)
+				}
+				return strings.Join(parts, "/"), nil
+			}),
+		}),
+		"console": NewObject(map[string]Value{
+			"log":   r.newLog(),
+			"warn":  r.newLog(),
+			"error": r.newLog(),
+		}),
+	})
+	global.Set("globalThis", global)
+	for name, v := range r.globals {
+		global.Set(name, v)
+	}
+	return global
+}
+
+// newLog returns a console function writing its arguments to the standard
+// error.
+func (r *Runtime) newLog() *Object {
+	return NewFunc(func(this Value, args []Value) (Value, error) {
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			parts[i] = toString(arg)
+		}
+		fmt.Fprintln(r.stdio.Stderr, strings.Join(parts, " "))
+		return Undefined, nil
+	})
+}
+
+// newProcess returns the process object, which tells there are no user
+// nor process identifiers and no working directory.
+func newProcess() *Object {
+	id := NewFunc(func(this Value, args []Value) (Value, error) {
+		return float64(-1), nil
+	})
+	enosys := NewFunc(func(this Value, args []Value) (Value, error) {
+		return nil, errNotImplemented
+	})
+	return NewObject(map[string]Value{
+		"getuid":    id,
+		"getgid":    id,
+		"geteuid":   id,
+		"getegid":   id,
+		"getgroups": enosys,
+		"pid":       float64(-1),
+		"ppid":      float64(-1),
+		"umask":     enosys,
+		"cwd":       enosys,
+		"chdir":     enosys,
+	})
+}
+
+// newFS returns the fs object, which reads the standard input and writes
+// the standard output and error. The other operations fail with ENOSYS.
+//
+// As in Node.js, the functions of fs give their results to callbacks
+// rather than returning them.
+func (r *Runtime) newFS() *Object {
+	fs := NewObject(map[string]Value{
+		"constants": NewObject(map[string]Value{
+			"O_WRONLY":    float64(-1),
+			"O_RDWR":      float64(-1),
+			"O_CREAT":     float64(-1),
+			"O_TRUNC":     float64(-1),
+			"O_APPEND":    float64(-1),
+			"O_EXCL":      float64(-1),
+			"O_DIRECTORY": float64(-1),
+		}),
+	})
+	for _, name := range []string{
+		"chmod", "chown", "close", "fchmod", "fchown", "fstat", "ftruncate",
+		"lchown", "link", "lstat", "mkdir", "open", "readdir", "readlink",
+		"rename", "rmdir", "stat", "symlink", "truncate", "unlink", "utimes",
+	} {
+		fs.Set(name, NewFunc(func(this Value, args []Value) (Value, error) {
+			return callback(args, errNotImplemented.Value)
+		}))
+	}
+	fs.Set("fsync", NewFunc(func(this Value, args []Value) (Value, error) {
+		return callback(args, Null)
+	}))
+
+	// write(fd, buffer, offset, length, position, callback)
+	fs.Set("write", NewFunc(func(this Value, args []Value) (Value, error) {
+		fd, p, err := fsBuffer(args)
+		if err != nil {
+			return nil, err
+		}
+		var w io.Writer
+		switch fd {
+		case 1:
+			w = r.stdio.Stdout
+		case 2:
+			w = r.stdio.Stderr
+		default:
+			return callback(args, NewError("EBADF", "bad file descriptor"))
+		}
+		n, err := w.Write(p)
+		if err != nil {
+			return callback(args, NewError("EIO", err.Error()))
+		}
+		return callback(args, Null, float64(n))
+	}))
+
+	// read(fd, buffer, offset, length, position, callback)
+	fs.Set("read", NewFunc(func(this Value, args []Value) (Value, error) {
+		fd, p, err := fsBuffer(args)
+		if err != nil {
+			return nil, err
+		}
+		if fd != 0 {
+			return callback(args, NewError("EBADF", "bad file descriptor"))
+		}
+		n, err := r.stdio.Stdin.Read(p)
+		if err != nil && err != io.EOF {
+			return callback(args, NewError("EIO", err.Error()))
+		}
+		return callback(args, Null, float64(n))
+	}))
+	return fs
+}
+
+// fsBuffer returns the file descriptor and the part of the buffer given to
+// fs.read or fs.write.
+func fsBuffer(args []Value) (int, []byte, error) {
+	if len(args) < 6 {
+		return 0, nil, errors.New("missing arguments")
+	}
+	fd, _ := args[0].(float64)
+	buf, ok := args[1].(*Object)
+	off, _ := args[2].(float64)
+	n, _ := args[3].(float64)
+	if !ok || buf.Bytes == nil || off < 0 || n < 0 || off+n > float64(len(buf.Bytes)) {
+		return 0, nil, errors.New("invalid buffer")
+	}
+	return int(fd), buf.Bytes[int(off):int(off+n)], nil
+}
+
+// callback calls the callback given as the last argument of a function of
+// fs with results.
+func callback(args []Value, results ...Value) (Value, error) {
+	if len(args) == 0 {
+		return nil, errNotFunction
+	}
+	if _, err := call(args[len(args)-1], Undefined, results); err != nil {
+		return nil, err
+	}
+	return Undefined, nil
+}
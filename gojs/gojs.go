@@ -0,0 +1,722 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gojs implements the host module imported by the programs built
+// by the Go toolchain with GOOS=js GOARCH=wasm, in place of the
+// wasm_exec.js support file of the Go distribution.
+//
+// The JavaScript values used by the Go runtime and by the syscall/js
+// package are modeled by Value and Object. The global object provides
+// what the standard library needs to run outside of a browser: the
+// program can write to the standard output and error, read the standard
+// input, get its arguments and environment, the time and random numbers,
+// and use timers.
+//
+//	rt := gojs.New(gojs.Args("prog"), gojs.Stdout(os.Stdout))
+//	m, err := wasm.ReadModule(r, func(name string) (*wasm.Module, error) {
+//		if name == gojs.ModuleName {
+//			return rt.Module(), nil
+//		}
+//		return nil, fmt.Errorf("unknown module %q", name)
+//	})
+//	...
+//	vm, err := exec.NewVM(m)
+//	...
+//	err = rt.Run(vm, m)
+package gojs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/internal/hostio"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// Names of the module imported by Go programs. Go 1.21 renamed the module
+// from "go" to "gojs": Module serves both.
+const (
+	ModuleName       = "gojs"
+	LegacyModuleName = "go"
+)
+
+// Option configures a Runtime created by New.
+type Option func(r *Runtime)
+
+// Args sets the command-line arguments of the program, starting with its
+// name.
+func Args(args ...string) Option {
+	return func(r *Runtime) {
+		r.args = args
+	}
+}
+
+// Environ sets the environment variables of the program, in the form
+// "key=value".
+func Environ(env ...string) Option {
+	return func(r *Runtime) {
+		r.env = env
+	}
+}
+
+// stdio wraps an option of the standard streams or of the clock.
+func stdio(opt hostio.Option) Option {
+	return func(r *Runtime) {
+		opt(&r.stdio)
+	}
+}
+
+// Stdin sets the standard input of the program, which is empty by default.
+func Stdin(rd io.Reader) Option {
+	return stdio(hostio.Stdin(rd))
+}
+
+// Stdout sets the standard output of the program, which is discarded by
+// default.
+func Stdout(w io.Writer) Option {
+	return stdio(hostio.Stdout(w))
+}
+
+// Stderr sets the standard error of the program, which is discarded by
+// default.
+func Stderr(w io.Writer) Option {
+	return stdio(hostio.Stderr(w))
+}
+
+// Clock sets the function returning the current time, which is time.Now
+// by default. Timers wait for the real time in any case.
+func Clock(now func() time.Time) Option {
+	return stdio(hostio.Clock(now))
+}
+
+// Random sets the source of the random bytes of the program, which is
+// crypto/rand.Reader by default.
+func Random(rd io.Reader) Option {
+	return func(r *Runtime) {
+		r.random = rd
+	}
+}
+
+// Global adds a property to the global object, which the program can get
+// with js.Global().Get(name).
+func Global(name string, v Value) Option {
+	return func(r *Runtime) {
+		r.globals[name] = v
+	}
+}
+
+// Runtime holds the state of an instance of the host module: the
+// JavaScript values referenced by the program, its pending events and
+// timers, and its exit code.
+type Runtime struct {
+	args    []string
+	env     []string
+	stdio   hostio.Stdio
+	random  io.Reader
+	globals map[string]Value
+
+	values []Value
+	counts []int // references held by the program, or -1 for predefined values
+	ids    map[Value]uint32
+	pool   []uint32 // ids of the released values
+
+	goObj   *Object
+	events  []*Object
+	timers  map[int32]time.Time
+	timerID int32
+
+	exited bool
+	code   int
+}
+
+// New returns a Runtime configured by the options opts.
+func New(opts ...Option) *Runtime {
+	r := &Runtime{
+		args:    []string{"js"},
+		stdio:   hostio.DefaultStdio(),
+		random:  rand.Reader,
+		globals: make(map[string]Value),
+		timers:  make(map[int32]time.Time),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.goObj = NewObject(map[string]Value{
+		"_pendingEvent": Null,
+		"_makeFuncWrapper": NewFunc(func(this Value, args []Value) (Value, error) {
+			if len(args) != 1 {
+				return nil, errors.New("_makeFuncWrapper: invalid arguments")
+			}
+			id := args[0]
+			return NewFunc(func(this Value, args []Value) (Value, error) {
+				// the function is run once the program is paused.
+				r.events = append(r.events, NewObject(map[string]Value{
+					"id":   id,
+					"this": this,
+					"args": &Object{Props: make(map[string]Value), Elems: append([]Value{}, args...)},
+				}))
+				return Undefined, nil
+			}), nil
+		}),
+	})
+	r.values = []Value{math.NaN(), float64(0), Null, true, false, r.newGlobal(), r.goObj}
+	r.counts = make([]int, len(r.values))
+	r.ids = make(map[Value]uint32)
+	for id, v := range r.values {
+		r.counts[id] = -1
+		if id > 0 {
+			r.ids[v] = uint32(id)
+		}
+	}
+	return r
+}
+
+// ExitCode returns the exit code of the program, and whether it has
+// exited.
+func (r *Runtime) ExitCode() (code int, exited bool) {
+	return r.code, r.exited
+}
+
+// Module returns the host module of r, to be returned by the importer
+// given to wasm.ReadModule for ModuleName or LegacyModuleName.
+func (r *Runtime) Module() *wasm.Module {
+	fns := []struct {
+		name string
+		fn   func(proc *exec.Process, sp uint32)
+	}{
+		{"runtime.wasmExit", r.wasmExit},
+		{"runtime.wasmWrite", r.wasmWrite},
+		{"runtime.resetMemoryDataView", func(proc *exec.Process, sp uint32) {}},
+		{"runtime.nanotime", r.nanotime},
+		{"runtime.nanotime1", r.nanotime},
+		{"runtime.walltime", r.walltime},
+		{"runtime.walltime1", r.walltime},
+		{"runtime.scheduleTimeoutEvent", r.scheduleTimeoutEvent},
+		{"runtime.clearTimeoutEvent", r.clearTimeoutEvent},
+		{"runtime.getRandomData", r.getRandomData},
+		{"syscall/js.finalizeRef", r.finalizeRef},
+		{"syscall/js.stringVal", r.stringVal},
+		{"syscall/js.valueGet", r.valueGet},
+		{"syscall/js.valueSet", r.valueSet},
+		{"syscall/js.valueDelete", r.valueDelete},
+		{"syscall/js.valueIndex", r.valueIndex},
+		{"syscall/js.valueSetIndex", r.valueSetIndex},
+		{"syscall/js.valueCall", r.valueCall},
+		{"syscall/js.valueInvoke", r.valueInvoke},
+		{"syscall/js.valueNew", r.valueNew},
+		{"syscall/js.valueLength", r.valueLength},
+		{"syscall/js.valuePrepareString", r.valuePrepareString},
+		{"syscall/js.valueLoadString", r.valueLoadString},
+		{"syscall/js.valueInstanceOf", r.valueInstanceOf},
+		{"syscall/js.copyBytesToGo", r.copyBytesToGo},
+		{"syscall/js.copyBytesToJS", r.copyBytesToJS},
+		{"debug", func(proc *exec.Process, v uint32) {}},
+	}
+
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Export.Entries = make(map[string]wasm.ExportEntry, len(fns))
+	m.Types.Entries = []wasm.FunctionSig{{Form: -0x20, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}}}
+	for i, fn := range fns {
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+			Sig:  &m.Types.Entries[0],
+			Host: reflect.ValueOf(fn.fn),
+			Body: &wasm.FunctionBody{},
+		})
+		m.Export.Entries[fn.name] = wasm.ExportEntry{FieldStr: fn.name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+	return m
+}
+
+// Addresses of the arguments and of the environment given to the program,
+// which must end before the data of the program.
+const (
+	argsAddr = 4096
+	dataAddr = 4096 + 8192
+)
+
+// Run runs the program of the module m, instantiated as vm, until it exits
+// or until it waits forever.
+//
+// As in Node.js, the events, such as the calls of the callbacks given to
+// fs functions and the timers, are run once the program is paused, that is
+// when all of its goroutines are blocked. A program waiting forever is
+// resumed with an event which makes it fail with a deadlock error.
+func (r *Runtime) Run(vm *exec.VM, m *wasm.Module) error {
+	export := func(name string) (int64, error) {
+		e, ok := m.Export.Entries[name]
+		if !ok || e.Kind != wasm.ExternalFunction {
+			return 0, fmt.Errorf("gojs: module has no %q function", name)
+		}
+		return int64(e.Index), nil
+	}
+	run, err := export("run")
+	if err != nil {
+		return err
+	}
+	resume, err := export("resume")
+	if err != nil {
+		return err
+	}
+
+	argv, err := r.writeArgs(exec.NewProcess(vm))
+	if err != nil {
+		return err
+	}
+	if _, err := vm.ExecCode(run, uint64(len(r.args)), uint64(argv)); err != nil {
+		return err
+	}
+	deadlock := false
+	for !r.exited {
+		if len(r.events) > 0 {
+			r.goObj.Set("_pendingEvent", r.events[0])
+			r.events = r.events[1:]
+		} else if len(r.timers) > 0 {
+			r.fireTimer()
+		} else if !deadlock {
+			deadlock = true
+			r.goObj.Set("_pendingEvent", NewObject(map[string]Value{"id": float64(0)}))
+		} else {
+			return errors.New("gojs: program did not exit")
+		}
+		if _, err := vm.ExecCode(resume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArgs writes the arguments and the environment of the program to its
+// memory, as the arrays of pointers to NUL-terminated strings expected by
+// the Go runtime, and returns the address of the arguments.
+func (r *Runtime) writeArgs(proc *exec.Process) (uint32, error) {
+	buf := new(bytes.Buffer)
+	var ptrs []uint32
+	str := func(s string) {
+		ptrs = append(ptrs, uint32(argsAddr+buf.Len()))
+		buf.WriteString(s)
+		buf.WriteByte(0)
+		for buf.Len()%8 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	for _, arg := range r.args {
+		str(arg)
+	}
+	ptrs = append(ptrs, 0)
+	env := append([]string{}, r.env...)
+	sort.Strings(env)
+	for _, kv := range env {
+		str(kv)
+	}
+	ptrs = append(ptrs, 0)
+
+	argv := uint32(argsAddr + buf.Len())
+	for _, ptr := range ptrs {
+		binary.Write(buf, binary.LittleEndian, uint64(ptr))
+	}
+	if argsAddr+buf.Len() >= dataAddr || argsAddr+buf.Len() > proc.MemSize() {
+		return 0, errors.New("gojs: arguments and environment are too long")
+	}
+	proc.WriteAt(buf.Bytes(), argsAddr)
+	return argv, nil
+}
+
+// fireTimer waits for the earliest timer, and removes it.
+func (r *Runtime) fireTimer() {
+	var id int32
+	var at time.Time
+	for i, t := range r.timers {
+		if at.IsZero() || t.Before(at) || t.Equal(at) && i < id {
+			id, at = i, t
+		}
+	}
+	time.Sleep(time.Until(at))
+	delete(r.timers, id)
+}
+
+// memory gives the host functions access to the linear memory of the
+// program, where the Go ABI passes the arguments and the results of the
+// functions from the stack pointer sp.
+type memory struct {
+	proc *exec.Process
+}
+
+func (m memory) bytes(addr, n uint64) []byte {
+	if addr+n > uint64(m.proc.MemSize()) || addr+n < addr {
+		panic(fmt.Errorf("gojs: out of bounds memory access at %#x", addr))
+	}
+	p := make([]byte, n)
+	m.proc.ReadAt(p, int64(addr))
+	return p
+}
+
+func (m memory) write(addr uint64, p []byte) {
+	if addr+uint64(len(p)) > uint64(m.proc.MemSize()) || addr+uint64(len(p)) < addr {
+		panic(fmt.Errorf("gojs: out of bounds memory access at %#x", addr))
+	}
+	m.proc.WriteAt(p, int64(addr))
+}
+
+func (m memory) uint32(addr uint64) uint32 {
+	return binary.LittleEndian.Uint32(m.bytes(addr, 4))
+}
+
+func (m memory) int64(addr uint64) int64 {
+	return int64(binary.LittleEndian.Uint64(m.bytes(addr, 8)))
+}
+
+func (m memory) putUint8(addr uint64, v uint8) {
+	m.write(addr, []byte{v})
+}
+
+func (m memory) putUint32(addr uint64, v uint32) {
+	var p [4]byte
+	binary.LittleEndian.PutUint32(p[:], v)
+	m.write(addr, p[:])
+}
+
+func (m memory) putInt64(addr uint64, v int64) {
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], uint64(v))
+	m.write(addr, p[:])
+}
+
+// slice returns the content of the []byte at addr.
+func (m memory) slice(addr uint64) []byte {
+	return m.bytes(uint64(m.int64(addr)), uint64(m.int64(addr+8)))
+}
+
+// putSlice copies p to the []byte at addr.
+func (m memory) putSlice(addr uint64, p []byte) {
+	m.write(uint64(m.int64(addr)), p)
+}
+
+func (m memory) string(addr uint64) string {
+	return string(m.slice(addr))
+}
+
+func (m memory) putBool(addr uint64, v bool) {
+	if v {
+		m.putUint8(addr, 1)
+	} else {
+		m.putUint8(addr, 0)
+	}
+}
+
+// References to JavaScript values are NaN-boxed: numbers other than 0 and
+// NaN are stored as themselves, undefined as 0, and the other values as a
+// NaN holding their id and their type.
+const nanHead = 0x7ff80000
+
+// Type flags of references.
+const (
+	typeFlagNone = iota
+	typeFlagObject
+	typeFlagString
+	typeFlagSymbol
+	typeFlagFunction
+)
+
+// load returns the value whose reference is at addr.
+func (r *Runtime) load(m memory, addr uint64) Value {
+	bits := uint64(m.int64(addr))
+	f := math.Float64frombits(bits)
+	switch {
+	case f == 0:
+		return Undefined
+	case !math.IsNaN(f):
+		return f
+	}
+	id := uint32(bits)
+	if int(id) >= len(r.values) {
+		return Undefined
+	}
+	return r.values[id]
+}
+
+// loadSlice returns the values whose references are held by the []ref at
+// addr.
+func (r *Runtime) loadSlice(m memory, addr uint64) []Value {
+	ptr, n := uint64(m.int64(addr)), m.int64(addr+8)
+	vs := make([]Value, n)
+	for i := range vs {
+		vs[i] = r.load(m, ptr+8*uint64(i))
+	}
+	return vs
+}
+
+// store writes a reference to the value v at addr.
+func (r *Runtime) store(m memory, addr uint64, v Value) {
+	if f, ok := v.(float64); ok && f != 0 {
+		if math.IsNaN(f) {
+			m.putInt64(addr, nanHead<<32)
+		} else {
+			m.putInt64(addr, int64(math.Float64bits(f)))
+		}
+		return
+	}
+	if v == Undefined || v == nil {
+		m.putInt64(addr, 0)
+		return
+	}
+
+	id, ok := r.ids[v]
+	if !ok {
+		if n := len(r.pool); n > 0 {
+			id, r.pool = r.pool[n-1], r.pool[:n-1]
+			r.values[id], r.counts[id] = v, 0
+		} else {
+			id = uint32(len(r.values))
+			r.values = append(r.values, v)
+			r.counts = append(r.counts, 0)
+		}
+		r.ids[v] = id
+	}
+	if r.counts[id] >= 0 {
+		r.counts[id]++
+	}
+	flag := typeFlagNone
+	switch v := v.(type) {
+	case *Object:
+		flag = typeFlagObject
+		if v.Call != nil || v.New != nil {
+			flag = typeFlagFunction
+		}
+	case string:
+		flag = typeFlagString
+	}
+	m.putInt64(addr, int64(nanHead|flag)<<32|int64(id))
+}
+
+// func wasmExit(code int32)
+func (r *Runtime) wasmExit(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	r.exited = true
+	r.code = int(int32(m.uint32(uint64(sp) + 8)))
+	proc.Terminate()
+}
+
+// func wasmWrite(fd uintptr, p unsafe.Pointer, n int32)
+func (r *Runtime) wasmWrite(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	fd := m.int64(uint64(sp) + 8)
+	p := m.bytes(uint64(m.int64(uint64(sp)+16)), uint64(m.uint32(uint64(sp)+24)))
+	switch fd {
+	case 1:
+		r.stdio.Stdout.Write(p)
+	case 2:
+		r.stdio.Stderr.Write(p)
+	}
+}
+
+// func nanotime1() int64
+func (r *Runtime) nanotime(proc *exec.Process, sp uint32) {
+	memory{proc}.putInt64(uint64(sp)+8, r.stdio.Now().UnixNano())
+}
+
+// func walltime() (sec int64, nsec int32)
+func (r *Runtime) walltime(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	now := r.stdio.Now()
+	m.putInt64(uint64(sp)+8, now.Unix())
+	m.putUint32(uint64(sp)+16, uint32(now.Nanosecond()))
+}
+
+// func scheduleTimeoutEvent(delay int64) int32
+func (r *Runtime) scheduleTimeoutEvent(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	delay := time.Duration(m.int64(uint64(sp)+8)) * time.Millisecond
+	r.timerID++
+	r.timers[r.timerID] = time.Now().Add(delay)
+	m.putUint32(uint64(sp)+16, uint32(r.timerID))
+}
+
+// func clearTimeoutEvent(id int32)
+func (r *Runtime) clearTimeoutEvent(proc *exec.Process, sp uint32) {
+	delete(r.timers, int32(memory{proc}.uint32(uint64(sp)+8)))
+}
+
+// func getRandomData(r []byte)
+func (r *Runtime) getRandomData(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	p := m.slice(uint64(sp) + 8)
+	if _, err := io.ReadFull(r.random, p); err != nil {
+		panic(fmt.Errorf("gojs: could not read random data: %v", err))
+	}
+	m.putSlice(uint64(sp)+8, p)
+}
+
+// func finalizeRef(v ref)
+func (r *Runtime) finalizeRef(proc *exec.Process, sp uint32) {
+	id := memory{proc}.uint32(uint64(sp) + 8)
+	if int(id) >= len(r.values) || r.counts[id] <= 0 {
+		return
+	}
+	r.counts[id]--
+	if r.counts[id] == 0 {
+		delete(r.ids, r.values[id])
+		r.values[id] = nil
+		r.pool = append(r.pool, id)
+	}
+}
+
+// func stringVal(value string) ref
+func (r *Runtime) stringVal(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	r.store(m, uint64(sp)+24, m.string(uint64(sp)+8))
+}
+
+// func valueGet(v ref, p string) ref
+func (r *Runtime) valueGet(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	r.store(m, uint64(sp)+32, get(r.load(m, uint64(sp)+8), m.string(uint64(sp)+16)))
+}
+
+// func valueSet(v ref, p string, x ref)
+func (r *Runtime) valueSet(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	if o, ok := r.load(m, uint64(sp)+8).(*Object); ok {
+		o.Set(m.string(uint64(sp)+16), r.load(m, uint64(sp)+32))
+	}
+}
+
+// func valueDelete(v ref, p string)
+func (r *Runtime) valueDelete(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	if o, ok := r.load(m, uint64(sp)+8).(*Object); ok {
+		delete(o.Props, m.string(uint64(sp)+16))
+	}
+}
+
+// func valueIndex(v ref, i int) ref
+func (r *Runtime) valueIndex(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	var v Value = Undefined
+	if o, ok := r.load(m, uint64(sp)+8).(*Object); ok {
+		v = o.Index(int(m.int64(uint64(sp) + 16)))
+	}
+	r.store(m, uint64(sp)+24, v)
+}
+
+// func valueSetIndex(v ref, i int, x ref)
+func (r *Runtime) valueSetIndex(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	if o, ok := r.load(m, uint64(sp)+8).(*Object); ok {
+		o.SetIndex(int(m.int64(uint64(sp)+16)), r.load(m, uint64(sp)+24))
+	}
+}
+
+// storeResult writes the result of a call, or the exception it threw, at
+// addr, and whether it succeeded after it.
+func (r *Runtime) storeResult(m memory, addr uint64, v Value, err error) {
+	if err != nil {
+		v = errorValue(err)
+	}
+	r.store(m, addr, v)
+	m.putBool(addr+8, err == nil)
+}
+
+// func valueCall(v ref, m string, args []ref) (ref, bool)
+func (r *Runtime) valueCall(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	v := r.load(m, uint64(sp)+8)
+	name := m.string(uint64(sp) + 16)
+	fn := get(v, name)
+	if fn == Undefined {
+		r.storeResult(m, uint64(sp)+56, nil, fmt.Errorf("%s is not a function", name))
+		return
+	}
+	res, err := call(fn, v, r.loadSlice(m, uint64(sp)+32))
+	r.storeResult(m, uint64(sp)+56, res, err)
+}
+
+// func valueInvoke(v ref, args []ref) (ref, bool)
+func (r *Runtime) valueInvoke(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	res, err := call(r.load(m, uint64(sp)+8), Undefined, r.loadSlice(m, uint64(sp)+16))
+	r.storeResult(m, uint64(sp)+40, res, err)
+}
+
+// func valueNew(v ref, args []ref) (ref, bool)
+func (r *Runtime) valueNew(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	res, err := construct(r.load(m, uint64(sp)+8), r.loadSlice(m, uint64(sp)+16))
+	r.storeResult(m, uint64(sp)+40, res, err)
+}
+
+// func valueLength(v ref) int
+func (r *Runtime) valueLength(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	n, _ := get(r.load(m, uint64(sp)+8), "length").(float64)
+	m.putInt64(uint64(sp)+16, int64(n))
+}
+
+// func valuePrepareString(v ref) (ref, int)
+func (r *Runtime) valuePrepareString(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	str := []byte(toString(r.load(m, uint64(sp)+8)))
+	r.store(m, uint64(sp)+16, &Object{Props: make(map[string]Value), Bytes: str})
+	m.putInt64(uint64(sp)+24, int64(len(str)))
+}
+
+// func valueLoadString(v ref, b []byte)
+func (r *Runtime) valueLoadString(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	if o, ok := r.load(m, uint64(sp)+8).(*Object); ok {
+		n := m.int64(uint64(sp) + 24)
+		if int64(len(o.Bytes)) < n {
+			n = int64(len(o.Bytes))
+		}
+		m.putSlice(uint64(sp)+16, o.Bytes[:n])
+	}
+}
+
+// func valueInstanceOf(v ref, t ref) bool
+func (r *Runtime) valueInstanceOf(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	o, ok := r.load(m, uint64(sp)+8).(*Object)
+	t, _ := r.load(m, uint64(sp)+16).(*Object)
+	m.putBool(uint64(sp)+24, ok && t != nil && o.constructor == t)
+}
+
+// func copyBytesToGo(dst []byte, src ref) (int, bool)
+func (r *Runtime) copyBytesToGo(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	src, ok := r.load(m, uint64(sp)+32).(*Object)
+	if !ok || src.Bytes == nil {
+		m.putBool(uint64(sp)+48, false)
+		return
+	}
+	p := src.Bytes
+	if n := m.int64(uint64(sp) + 16); int64(len(p)) > n {
+		p = p[:n]
+	}
+	m.putSlice(uint64(sp)+8, p)
+	m.putInt64(uint64(sp)+40, int64(len(p)))
+	m.putBool(uint64(sp)+48, true)
+}
+
+// func copyBytesToJS(dst ref, src []byte) (int, bool)
+func (r *Runtime) copyBytesToJS(proc *exec.Process, sp uint32) {
+	m := memory{proc}
+	dst, ok := r.load(m, uint64(sp)+8).(*Object)
+	if !ok || dst.Bytes == nil {
+		m.putBool(uint64(sp)+48, false)
+		return
+	}
+	n := copy(dst.Bytes, m.slice(uint64(sp)+16))
+	m.putInt64(uint64(sp)+40, int64(n))
+	m.putBool(uint64(sp)+48, true)
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/gojs"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// build builds the command in testdata/name with GOOS=js GOARCH=wasm, and
+// returns the path of the module.
+func build(t *testing.T, name string) string {
+	if testing.Short() {
+		t.Skip("skipping build of a Go program in short mode")
+	}
+	dir, err := ioutil.TempDir("", "wagon-gojs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, name+".wasm")
+	cmd := osexec.Command("go", "build", "-o", out, ".")
+	cmd.Dir = filepath.Join("testdata", name)
+	cmd.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm", "GO111MODULE=off")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("could not build %s: %v\n%s", name, err, msg)
+	}
+	return out
+}
+
+func TestRun(t *testing.T) {
+	fname := build(t, "hello")
+	defer os.RemoveAll(filepath.Dir(fname))
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	custom := gojs.NewObject(map[string]gojs.Value{
+		"name": "custom",
+		"add": gojs.NewFunc(func(this gojs.Value, args []gojs.Value) (gojs.Value, error) {
+			return args[0].(float64) + args[1].(float64), nil
+		}),
+	})
+	rt := gojs.New(
+		gojs.Args("hello", "a", "b"),
+		gojs.Environ("GREETING=hi"),
+		gojs.Stdin(strings.NewReader("line 1\nline 2\n")),
+		gojs.Stdout(stdout),
+		gojs.Stderr(stderr),
+		gojs.Global("custom", custom),
+	)
+	m, err := wasm.ReadModule(f, func(name string) (*wasm.Module, error) {
+		if name == gojs.ModuleName || name == gojs.LegacyModuleName {
+			return rt.Module(), nil
+		}
+		return nil, fmt.Errorf("unknown module %q", name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+	if err := rt.Run(vm, m); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `hello, world [a b] hi
+goroutine 2
+goroutine 1
+goroutine 0
+custom 3
+3
+read: line 1
+read: line 2
+`
+	if got := stdout.String(); got != want {
+		t.Errorf("stdout:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := stderr.String(), "exiting\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+	if code, exited := rt.ExitCode(); !exited || code != 3 {
+		t.Errorf("exit code = %d, %v, want 3, true", code, exited)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command hello is run by the tests of the gojs package, once built with
+// GOOS=js GOARCH=wasm.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+func main() {
+	fmt.Println("hello, world", os.Args[1:], os.Getenv("GREETING"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(3-i) * 10 * time.Millisecond)
+			fmt.Println("goroutine", i)
+		}(i)
+	}
+	wg.Wait()
+
+	custom := js.Global().Get("custom")
+	fmt.Println(custom.Get("name").String(), custom.Call("add", 1, 2).Int())
+	fmt.Println(js.ValueOf([]interface{}{1, "a", nil}).Length())
+
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		fmt.Println("read:", s.Text())
+	}
+	fmt.Fprintln(os.Stderr, "exiting")
+	os.Exit(3)
+}
@@ -0,0 +1,258 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Value is a JavaScript value given to or received from the Go program:
+// Undefined, Null, a bool, a float64, a string, or an *Object. All the
+// values are comparable.
+type Value interface{}
+
+type undefined struct{}
+
+type null struct{}
+
+// Undefined and Null are the JavaScript values undefined and null.
+var (
+	Undefined Value = undefined{}
+	Null      Value = null{}
+)
+
+// Func is the implementation of a JavaScript function. A non-nil error is
+// thrown as an exception: an *Exception throws its value, and other errors
+// throw an Error object holding their message.
+type Func func(this Value, args []Value) (Value, error)
+
+// An Object is a JavaScript object, holding named properties. An object
+// is a function if it has Call, and a constructor if it has New. The
+// content of arrays and of Uint8Arrays is held by Elems and Bytes.
+type Object struct {
+	Props map[string]Value
+	Call  Func
+	New   func(args []Value) (Value, error)
+
+	Elems []Value
+	Bytes []byte
+
+	// constructor is the constructor which created the object, if any.
+	constructor *Object
+}
+
+// NewObject returns an object with the properties props.
+func NewObject(props map[string]Value) *Object {
+	if props == nil {
+		props = make(map[string]Value)
+	}
+	return &Object{Props: props}
+}
+
+// NewFunc returns a function object calling fn.
+func NewFunc(fn Func) *Object {
+	return &Object{Props: make(map[string]Value), Call: fn}
+}
+
+// NewConstructor returns a function object whose New creates objects with
+// fn. Objects created by the constructor are instances of it.
+func NewConstructor(fn func(args []Value) (Value, error)) *Object {
+	return &Object{Props: make(map[string]Value), New: fn}
+}
+
+// Get returns the property name of o, or Undefined.
+func (o *Object) Get(name string) Value {
+	if name == "length" && (o.Elems != nil || o.Bytes != nil) {
+		return float64(o.Len())
+	}
+	if i, ok := o.index(name); ok {
+		return o.Index(i)
+	}
+	if v, ok := o.Props[name]; ok {
+		return v
+	}
+	return Undefined
+}
+
+// Set sets the property name of o.
+func (o *Object) Set(name string, v Value) {
+	if i, ok := o.index(name); ok {
+		o.SetIndex(i, v)
+		return
+	}
+	if o.Props == nil {
+		o.Props = make(map[string]Value)
+	}
+	o.Props[name] = v
+}
+
+// index returns the index named by the property name of an array.
+func (o *Object) index(name string) (int, bool) {
+	if o.Elems == nil && o.Bytes == nil {
+		return 0, false
+	}
+	i, err := strconv.Atoi(name)
+	return i, err == nil && i >= 0
+}
+
+// Len returns the length of an array or of a Uint8Array.
+func (o *Object) Len() int {
+	if o.Bytes != nil {
+		return len(o.Bytes)
+	}
+	return len(o.Elems)
+}
+
+// Index returns the element i of an array or of a Uint8Array, or
+// Undefined.
+func (o *Object) Index(i int) Value {
+	switch {
+	case i < 0:
+		return Undefined
+	case o.Bytes != nil:
+		if i < len(o.Bytes) {
+			return float64(o.Bytes[i])
+		}
+	case i < len(o.Elems):
+		return o.Elems[i]
+	}
+	return Undefined
+}
+
+// SetIndex sets the element i of an array or of a Uint8Array. Arrays grow
+// as needed.
+func (o *Object) SetIndex(i int, v Value) {
+	switch {
+	case i < 0:
+	case o.Bytes != nil:
+		if f, ok := v.(float64); ok && i < len(o.Bytes) {
+			o.Bytes[i] = byte(int64(f))
+		}
+	default:
+		for len(o.Elems) <= i {
+			o.Elems = append(o.Elems, Undefined)
+		}
+		o.Elems[i] = v
+	}
+}
+
+// Exception is an error thrown by a Func with a JavaScript value.
+type Exception struct {
+	Value Value
+}
+
+func (e *Exception) Error() string {
+	if o, ok := e.Value.(*Object); ok {
+		if msg, ok := o.Get("message").(string); ok {
+			return msg
+		}
+	}
+	return toString(e.Value)
+}
+
+// NewError returns an Error object with a message and, if it isn't empty,
+// a Node.js error code such as "ENOENT".
+func NewError(code, message string) *Object {
+	o := NewObject(map[string]Value{"message": message})
+	if code != "" {
+		o.Props["code"] = code
+	}
+	return o
+}
+
+// errorValue returns the value thrown for the error err.
+func errorValue(err error) Value {
+	if e, ok := err.(*Exception); ok {
+		return e.Value
+	}
+	return NewError("", err.Error())
+}
+
+var errNotFunction = errors.New("value is not a function")
+
+// call calls the function fn with this and args.
+func call(fn, this Value, args []Value) (Value, error) {
+	o, ok := fn.(*Object)
+	if !ok || o.Call == nil {
+		return nil, errNotFunction
+	}
+	return o.Call(this, args)
+}
+
+// construct creates an object with the constructor fn.
+func construct(fn Value, args []Value) (Value, error) {
+	o, ok := fn.(*Object)
+	if !ok || o.New == nil {
+		return nil, errors.New("value is not a constructor")
+	}
+	v, err := o.New(args)
+	if obj, ok := v.(*Object); ok && obj.constructor == nil {
+		obj.constructor = o
+	}
+	return v, err
+}
+
+// get returns the property name of v. Only objects have properties, and
+// the length of strings.
+func get(v Value, name string) Value {
+	switch v := v.(type) {
+	case *Object:
+		return v.Get(name)
+	case string:
+		if name == "length" {
+			return float64(len(v))
+		}
+	}
+	return Undefined
+}
+
+// toString converts v to a string, as the String function.
+func toString(v Value) string {
+	switch v := v.(type) {
+	case undefined:
+		return "undefined"
+	case null:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == math.Trunc(v) && math.Abs(v) < 1e21:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case *Object:
+		switch {
+		case v.Call != nil || v.New != nil:
+			return "function () { [native code] }"
+		case v.Elems != nil:
+			s := ""
+			for i, e := range v.Elems {
+				if i > 0 {
+					s += ","
+				}
+				if e != Undefined && e != Null {
+					s += toString(e)
+				}
+			}
+			return s
+		}
+		if msg, ok := v.Props["message"].(string); ok {
+			return "Error: " + msg
+		}
+		return "[object Object]"
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// testProcess returns a process with one page of memory.
+func testProcess(t *testing.T) *exec.Process {
+	t.Helper()
+	m, err := wast.ParseModule(strings.NewReader("(module (memory 1))"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = wasm.ReadModule(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exec.NewProcess(vm)
+}
+
+func TestRefs(t *testing.T) {
+	r := New()
+	m := memory{testProcess(t)}
+	obj := NewObject(nil)
+	fn := NewFunc(func(this Value, args []Value) (Value, error) { return Undefined, nil })
+	for _, tc := range []struct {
+		v    Value
+		bits uint64
+	}{
+		{Undefined, 0},
+		{float64(0), nanHead<<32 | 1},
+		{Null, nanHead<<32 | 2},
+		{true, nanHead<<32 | 3},
+		{false, nanHead<<32 | 4},
+		{float64(1.5), math.Float64bits(1.5)},
+		{math.NaN(), nanHead << 32},
+		{"str", (nanHead|typeFlagString)<<32 | 7},
+		{obj, (nanHead|typeFlagObject)<<32 | 8},
+		{fn, (nanHead|typeFlagFunction)<<32 | 9},
+		{"str", (nanHead|typeFlagString)<<32 | 7},
+	} {
+		r.store(m, 8, tc.v)
+		if got := uint64(m.int64(8)); got != tc.bits {
+			t.Errorf("store(%v) = %#x, want %#x", tc.v, got, tc.bits)
+		}
+		got := r.load(m, 8)
+		if f, ok := tc.v.(float64); ok && math.IsNaN(f) {
+			if g, ok := got.(float64); !ok || !math.IsNaN(g) {
+				t.Errorf("load(store(NaN)) = %v", got)
+			}
+		} else if got != tc.v {
+			t.Errorf("load(store(%v)) = %v", tc.v, got)
+		}
+	}
+
+	// "str" is referenced twice, and is released after two finalizeRef.
+	m.putInt64(8, 7)
+	r.finalizeRef(m.proc, 0)
+	if r.values[7] != "str" {
+		t.Fatalf("value released with remaining references")
+	}
+	r.finalizeRef(m.proc, 0)
+	if r.values[7] != nil {
+		t.Fatalf("value not released")
+	}
+	r.store(m, 8, "other")
+	if got, want := uint64(m.int64(8)), uint64((nanHead|typeFlagString)<<32|7); got != want {
+		t.Errorf("store reusing id = %#x, want %#x", got, want)
+	}
+
+	// predefined values are never released.
+	m.putInt64(8, 5)
+	r.finalizeRef(m.proc, 0)
+	if _, ok := r.values[5].(*Object); !ok {
+		t.Errorf("global object released")
+	}
+}
+
+func TestToString(t *testing.T) {
+	for _, tc := range []struct {
+		v    Value
+		want string
+	}{
+		{Undefined, "undefined"},
+		{Null, "null"},
+		{true, "true"},
+		{float64(42), "42"},
+		{float64(-0.5), "-0.5"},
+		{math.Inf(-1), "-Infinity"},
+		{"s", "s"},
+		{&Object{Elems: []Value{float64(1), Null, "a"}}, "1,,a"},
+		{NewError("ENOENT", "no such file"), "Error: no such file"},
+		{NewObject(nil), "[object Object]"},
+	} {
+		if got := toString(tc.v); got != tc.want {
+			t.Errorf("toString(%v) = %q, want %q", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestMemoryBounds(t *testing.T) {
+	m := memory{testProcess(t)}
+	for _, tc := range []struct {
+		addr, n uint64
+	}{
+		{65536, 1},
+		{65535, 2},
+		{math.MaxUint64, 2},
+		{math.MaxUint64 - 1, 3},
+	} {
+		for name, access := range map[string]func(){
+			"bytes": func() { m.bytes(tc.addr, tc.n) },
+			"write": func() { m.write(tc.addr, make([]byte, tc.n)) },
+		} {
+			func() {
+				defer func() {
+					err, _ := recover().(error)
+					if err == nil || !strings.HasPrefix(err.Error(), "gojs: out of bounds memory access") {
+						t.Errorf("%s(%#x, %d): panic %v, want an out of bounds memory access", name, tc.addr, tc.n, err)
+					}
+				}()
+				access()
+			}()
+		}
+	}
+	m.write(65534, []byte{1, 2})
+	if got := m.bytes(65534, 2); !bytes.Equal(got, []byte{1, 2}) {
+		t.Errorf("bytes(65534, 2) = %v, want [1 2]", got)
+	}
+}
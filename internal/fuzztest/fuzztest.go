@@ -0,0 +1,57 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fuzztest provides the seed corpus and the decoding options
+// shared by the fuzz tests of the wasm, validate and exec packages.
+package fuzztest
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-interpreter/wagon/gen"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// DecodeOptions keep the memories and the tables of the fuzzed modules
+// small.
+var DecodeOptions = wasm.DecodeOptions{MaxMemorySize: 1 << 20, MaxTableSize: 1 << 16}
+
+// seedPatterns match the test modules, relative to the root of the
+// repository.
+var seedPatterns = []string{
+	"wasm/testdata/*.wasm",
+	"exec/testdata/*.wasm",
+	"exec/testdata/spec/*.wasm",
+	"cmd/*/testdata/*.wasm",
+}
+
+// AddSeeds adds the test modules, and a few random modules generated by
+// the gen package, to the corpus of f. root is the path of the root of the
+// repository from the directory of the package being tested.
+func AddSeeds(f *testing.F, root string) {
+	for _, pattern := range seedPatterns {
+		fnames, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, fname := range fnames {
+			raw, err := ioutil.ReadFile(fname)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(raw)
+		}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		raw, err := gen.Bytes(rnd, gen.Config{})
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+}
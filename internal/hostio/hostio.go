@@ -0,0 +1,66 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hostio provides what the host modules, such as the wasi,
+// emscripten and gojs packages, share: the standard streams and the clock
+// of the program, and the access to its linear memory from the host
+// functions.
+package hostio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Stdio holds the standard streams and the clock of a program.
+type Stdio struct {
+	Stdin  io.Reader        // empty by default
+	Stdout io.Writer        // discarded by default
+	Stderr io.Writer        // discarded by default
+	Now    func() time.Time // time.Now by default
+}
+
+// DefaultStdio returns the Stdio of a program run without options.
+func DefaultStdio() Stdio {
+	return Stdio{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: ioutil.Discard,
+		Stderr: ioutil.Discard,
+		Now:    time.Now,
+	}
+}
+
+// Option sets a field of a Stdio. The host modules wrap it in their own
+// option type, as their Stdin, Stdout, Stderr and Clock options.
+type Option func(s *Stdio)
+
+// Stdin sets the standard input of the program.
+func Stdin(r io.Reader) Option {
+	return func(s *Stdio) {
+		s.Stdin = r
+	}
+}
+
+// Stdout sets the standard output of the program.
+func Stdout(w io.Writer) Option {
+	return func(s *Stdio) {
+		s.Stdout = w
+	}
+}
+
+// Stderr sets the standard error of the program.
+func Stderr(w io.Writer) Option {
+	return func(s *Stdio) {
+		s.Stderr = w
+	}
+}
+
+// Clock sets the function returning the current time.
+func Clock(now func() time.Time) Option {
+	return func(s *Stdio) {
+		s.Now = now
+	}
+}
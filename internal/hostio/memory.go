@@ -0,0 +1,122 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hostio
+
+import (
+	"encoding/binary"
+
+	"github.com/go-interpreter/wagon/exec"
+)
+
+// Memory gives the host functions access to the linear memory of the
+// program. An access out of the bounds of the memory sets Fault, and isn't
+// done, nor are the following accesses: a host function can make all its
+// accesses and check Fault once.
+type Memory struct {
+	Proc  *exec.Process
+	Fault bool
+}
+
+// Check reports whether the n bytes at ptr are in bounds, and sets Fault
+// otherwise.
+func (m *Memory) Check(ptr uint32, n uint64) bool {
+	if m.Fault || uint64(ptr)+n > uint64(m.Proc.MemSize()) {
+		m.Fault = true
+		return false
+	}
+	return true
+}
+
+// Read returns a copy of the n bytes at ptr, or nil if they are out of
+// bounds.
+func (m *Memory) Read(ptr, n uint32) []byte {
+	if !m.Check(ptr, uint64(n)) {
+		return nil
+	}
+	p := make([]byte, n)
+	m.Proc.ReadAt(p, int64(ptr))
+	return p
+}
+
+// Write writes p at ptr.
+func (m *Memory) Write(ptr uint32, p []byte) {
+	if m.Check(ptr, uint64(len(p))) {
+		m.Proc.WriteAt(p, int64(ptr))
+	}
+}
+
+// Uint32 returns the little-endian uint32 at ptr, or 0 if it is out of
+// bounds.
+func (m *Memory) Uint32(ptr uint32) uint32 {
+	p := m.Read(ptr, 4)
+	if p == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(p)
+}
+
+// Uint64 returns the little-endian uint64 at ptr, or 0 if it is out of
+// bounds.
+func (m *Memory) Uint64(ptr uint32) uint64 {
+	p := m.Read(ptr, 8)
+	if p == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(p)
+}
+
+// PutUint32 writes v at ptr, in little-endian order.
+func (m *Memory) PutUint32(ptr uint32, v uint32) {
+	var p [4]byte
+	binary.LittleEndian.PutUint32(p[:], v)
+	m.Write(ptr, p[:])
+}
+
+// PutUint64 writes v at ptr, in little-endian order.
+func (m *Memory) PutUint64(ptr uint32, v uint64) {
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], v)
+	m.Write(ptr, p[:])
+}
+
+// CString returns the NUL-terminated string at ptr.
+func (m *Memory) CString(ptr uint32) string {
+	var s []byte
+	for ptr < uint32(m.Proc.MemSize()) {
+		c := m.Read(ptr, 1)
+		if c == nil {
+			break
+		}
+		if c[0] == 0 {
+			return string(s)
+		}
+		s = append(s, c[0])
+		ptr++
+	}
+	m.Fault = true
+	return string(s)
+}
+
+// An Iovec is a buffer in the linear memory.
+type Iovec struct {
+	Ptr, Len uint32
+}
+
+// Iovecs reads the array of n iovecs, or WASI ciovecs, at ptr. It returns
+// nil if the array, or one of the buffers, is out of bounds.
+func (m *Memory) Iovecs(ptr, n uint32) []Iovec {
+	if !m.Check(ptr, 8*uint64(n)) {
+		return nil
+	}
+	iovs := make([]Iovec, n)
+	for i := range iovs {
+		iov := Iovec{Ptr: m.Uint32(ptr + 8*uint32(i)), Len: m.Uint32(ptr + 8*uint32(i) + 4)}
+		if !m.Check(iov.Ptr, uint64(iov.Len)) {
+			return nil
+		}
+		iovs[i] = iov
+	}
+	return iovs
+}
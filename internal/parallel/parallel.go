@@ -0,0 +1,73 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package parallel provides a helper for processing independent items,
+// such as function bodies, across several goroutines.
+package parallel
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Run calls fn for every index in [0, n) using at most workers goroutines.
+// If workers is less than 2, fn is called sequentially.
+//
+// Run returns the error of the lowest index for which fn failed, or nil.
+// The returned error does not depend on scheduling: every index below the
+// lowest failing one is always processed, while indices above a known
+// failure may be skipped.
+func Run(n, workers int, fn func(i int) error) error {
+	if workers < 2 || n < 2 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if workers > n {
+		workers = n
+	}
+
+	var (
+		next     int64 = -1
+		mu       sync.Mutex
+		failed   = n // lowest index that failed so far
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	lowestFailure := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed
+	}
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				// indices are handed out in increasing order, so once
+				// one is above a failure, all the following ones are too.
+				if i >= n || i > lowestFailure() {
+					return
+				}
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if i < failed {
+						failed = i
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parallel
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	for _, workers := range []int{0, 1, 2, 8, 100} {
+		var count int64
+		seen := make([]int32, 50)
+		err := Run(len(seen), workers, func(i int) error {
+			atomic.AddInt64(&count, 1)
+			atomic.AddInt32(&seen[i], 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+		if count != int64(len(seen)) {
+			t.Fatalf("workers=%d: fn was called %d times, want %d", workers, count, len(seen))
+		}
+		for i, v := range seen {
+			if v != 1 {
+				t.Fatalf("workers=%d: index %d was processed %d times", workers, i, v)
+			}
+		}
+	}
+}
+
+func TestRunFirstError(t *testing.T) {
+	for _, workers := range []int{1, 4, 16} {
+		for iter := 0; iter < 20; iter++ {
+			err := Run(200, workers, func(i int) error {
+				if i%37 == 36 {
+					return fmt.Errorf("error at %d", i)
+				}
+				return nil
+			})
+			if err == nil || err.Error() != "error at 36" {
+				t.Fatalf("workers=%d: unexpected error: got=%v, want=error at 36", workers, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spectest
+
+import (
+	"reflect"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// hostModule returns the "spectest" module imported by the scripts of the
+// test suite, whose print functions do nothing.
+func hostModule() *wasm.Module {
+	i32, i64, f32, f64 := wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64
+
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Export.Entries = make(map[string]wasm.ExportEntry)
+
+	fns := []struct {
+		name   string
+		params []wasm.ValueType
+		host   interface{}
+	}{
+		{"print", nil, func(proc *exec.Process) {}},
+		{"print_i32", []wasm.ValueType{i32}, func(proc *exec.Process, v int32) {}},
+		{"print_i64", []wasm.ValueType{i64}, func(proc *exec.Process, v int64) {}},
+		{"print_f32", []wasm.ValueType{f32}, func(proc *exec.Process, v float32) {}},
+		{"print_f64", []wasm.ValueType{f64}, func(proc *exec.Process, v float64) {}},
+		{"print_i32_f32", []wasm.ValueType{i32, f32}, func(proc *exec.Process, v int32, w float32) {}},
+		{"print_f64_f64", []wasm.ValueType{f64, f64}, func(proc *exec.Process, v, w float64) {}},
+	}
+	m.Types.Entries = make([]wasm.FunctionSig, len(fns))
+	for i, fn := range fns {
+		m.Types.Entries[i] = wasm.FunctionSig{Form: -0x20, ParamTypes: fn.params}
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+			Sig:  &m.Types.Entries[i],
+			Host: reflect.ValueOf(fn.host),
+			Body: &wasm.FunctionBody{},
+		})
+		m.Export.Entries[fn.name] = wasm.ExportEntry{FieldStr: fn.name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+
+	globals := []struct {
+		name string
+		typ  wasm.ValueType
+		init []byte
+	}{
+		{"global_i32", i32, []byte{0x41, 0x9a, 0x05, 0x0b}},                                     // i32.const 666
+		{"global_i64", i64, []byte{0x42, 0x9a, 0x05, 0x0b}},                                     // i64.const 666
+		{"global_f32", f32, []byte{0x43, 0x66, 0xa6, 0x26, 0x44, 0x0b}},                         // f32.const 666.6
+		{"global_f64", f64, []byte{0x44, 0xcd, 0xcc, 0xcc, 0xcc, 0xcc, 0xd4, 0x84, 0x40, 0x0b}}, // f64.const 666.6
+	}
+	for i, g := range globals {
+		m.GlobalIndexSpace = append(m.GlobalIndexSpace, wasm.GlobalEntry{
+			Type: wasm.GlobalVar{Type: g.typ},
+			Init: g.init,
+		})
+		m.Export.Entries[g.name] = wasm.ExportEntry{FieldStr: g.name, Kind: wasm.ExternalGlobal, Index: uint32(i)}
+	}
+
+	m.TableIndexSpace = [][]uint32{make([]uint32, 10, 20)}
+	m.Export.Entries["table"] = wasm.ExportEntry{FieldStr: "table", Kind: wasm.ExternalTable}
+
+	m.LinearMemoryIndexSpace = [][]byte{make([]byte, 65536)}
+	m.Export.Entries["memory"] = wasm.ExportEntry{FieldStr: "memory", Kind: wasm.ExternalMemory}
+
+	return m
+}
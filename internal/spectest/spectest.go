@@ -0,0 +1,510 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package spectest runs the scripts of the WebAssembly specification test
+// suite (.wast files), and reports which of their commands pass.
+package spectest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// Status is the outcome of a command of a script.
+type Status int
+
+const (
+	Pass Status = iota
+	Fail
+	Skip // the command uses a feature that is not supported
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "fail"
+	case Skip:
+		return "skip"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Result is the outcome of a command of a script.
+type Result struct {
+	Line    int
+	Command wast.CommandType
+	Status  Status
+	Msg     string // why the command failed or was skipped
+}
+
+// Report holds the results of the commands of a script, in order.
+type Report struct {
+	Name    string
+	Results []Result
+}
+
+// Count returns the number of commands with the given status.
+func (r *Report) Count(s Status) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%s: %d passed, %d failed, %d skipped",
+		r.Name, r.Count(Pass), r.Count(Fail), r.Count(Skip))
+}
+
+// RunFile parses and runs the script in the named file.
+func RunFile(name string) (*Report, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := wast.ParseScript(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return Run(name, s), nil
+}
+
+// Run runs the commands of the script s, reporting them under the given
+// name.
+func Run(name string, s *wast.Script) *Report {
+	r := &runner{
+		report:    &Report{Name: name},
+		registry:  map[string]*wasm.Module{"spectest": hostModule()},
+		instances: make(map[string]*instance),
+	}
+	for _, cmd := range s.Commands {
+		status, msg := r.run(cmd)
+		r.report.Results = append(r.report.Results, Result{
+			Line:    cmd.Line,
+			Command: cmd.Type,
+			Status:  status,
+			Msg:     msg,
+		})
+	}
+	return r.report
+}
+
+// stage is the step of the instantiation of a module at which it failed.
+type stage int
+
+const (
+	stageDecode      stage = iota // the module is malformed
+	stageLink                     // the imports of the module can't be resolved
+	stageValidate                 // the module is invalid
+	stageInstantiate              // the initialization of the module traps
+)
+
+func (s stage) String() string {
+	return [...]string{"malformed", "unlinkable", "invalid", "uninstantiable"}[s]
+}
+
+// instance is a module of a script, and the VM running it.
+type instance struct {
+	module *wasm.Module
+	vm     *exec.VM
+
+	err   error // why the module couldn't be instantiated
+	stage stage // the step at which the instantiation failed
+}
+
+// skipError is returned for commands using unsupported features.
+type skipError string
+
+func (e skipError) Error() string {
+	return string(e)
+}
+
+// hostRef is the host value referenced by the externref values of
+// scripts.
+type hostRef uint64
+
+type runner struct {
+	report    *Report
+	registry  map[string]*wasm.Module // the modules that can be imported, by name
+	instances map[string]*instance    // the modules with an identifier
+	current   *instance               // the last defined module
+}
+
+func (r *runner) run(cmd *wast.Command) (Status, string) {
+	var err error
+	switch cmd.Type {
+	case wast.CommandModule:
+		inst := r.instantiate(cmd.Module)
+		r.current = inst
+		if cmd.Module.ID != "" {
+			r.instances[cmd.Module.ID] = inst
+		}
+		err = inst.error()
+	case wast.CommandRegister:
+		inst, ierr := r.lookup(cmd.ModuleID)
+		if err = ierr; err == nil {
+			r.registry[cmd.Name] = inst.module
+		}
+	case wast.CommandAction:
+		_, _, err = r.do(cmd.Action)
+	case wast.CommandAssertReturn:
+		err = r.assertReturn(cmd)
+	case wast.CommandAssertTrap:
+		if cmd.Module != nil {
+			err = r.assertFailure(cmd.Module, stageInstantiate)
+			break
+		}
+		err = r.assertTrap(cmd)
+	case wast.CommandAssertException:
+		_, _, err = r.do(cmd.Action)
+		switch err.(type) {
+		case nil:
+			err = fmt.Errorf("expected an exception")
+		case skipError:
+		case *exec.Exception:
+			err = nil
+		default:
+			err = fmt.Errorf("expected an exception, got %v", err)
+		}
+	case wast.CommandAssertExhaustion:
+		_, _, err = r.do(cmd.Action)
+		switch err {
+		case nil:
+			err = fmt.Errorf("expected the exhaustion of the stack (%s)", cmd.Failure)
+		case exec.ErrCallStackExhausted, exec.ErrStackExhausted:
+			err = nil
+		default:
+			if _, ok := err.(skipError); !ok {
+				err = fmt.Errorf("expected the exhaustion of the stack (%s), got %v", cmd.Failure, err)
+			}
+		}
+	case wast.CommandAssertMalformed:
+		err = r.assertFailure(cmd.Module, stageDecode)
+	case wast.CommandAssertInvalid:
+		err = r.assertFailure(cmd.Module, stageDecode, stageValidate)
+	case wast.CommandAssertUnlinkable:
+		err = r.assertFailure(cmd.Module, stageLink, stageInstantiate)
+	default:
+		err = skipError(fmt.Sprintf("unknown command %s", cmd.Type))
+	}
+
+	switch err.(type) {
+	case nil:
+		return Pass, ""
+	case skipError:
+		return Skip, err.Error()
+	default:
+		return Fail, err.Error()
+	}
+}
+
+func (inst *instance) error() error {
+	if inst.err == nil {
+		return nil
+	}
+	return fmt.Errorf("%v module: %v", inst.stage, inst.err)
+}
+
+// instantiate decodes, links, validates and instantiates the module m.
+func (r *runner) instantiate(m *wast.ScriptModule) (inst *instance) {
+	inst = &instance{}
+	defer func() {
+		if e := recover(); e != nil {
+			inst.err = fmt.Errorf("panic: %v", e)
+		}
+	}()
+
+	raw, err := m.Bytes()
+	if err == nil {
+		_, err = wasm.DecodeModule(bytes.NewReader(raw))
+	}
+	if err != nil {
+		inst.err, inst.stage = err, stageDecode
+		return inst
+	}
+
+	resolved := true
+	inst.module, err = wasm.ReadModule(bytes.NewReader(raw), func(name string) (*wasm.Module, error) {
+		m, ok := r.registry[name]
+		if !ok {
+			resolved = false
+			return nil, fmt.Errorf("unknown module %q", name)
+		}
+		return m, nil
+	})
+	if err != nil {
+		// besides resolving the imports, ReadModule checks the indices
+		// and the initializer expressions of the module, as the
+		// validation does.
+		inst.err, inst.stage = err, stageValidate
+		if !resolved || isLinkError(err) {
+			inst.stage = stageLink
+		}
+		return inst
+	}
+
+	inst.stage = stageValidate
+	if err := validate.VerifyModule(inst.module); err != nil {
+		inst.err = err
+		return inst
+	}
+
+	inst.stage = stageInstantiate
+	inst.vm, err = exec.NewVM(inst.module)
+	if err != nil {
+		inst.err = err
+		return inst
+	}
+	inst.vm.RecoverPanic = true
+	return inst
+}
+
+// isLinkError reports whether err, returned by wasm.ReadModule, is due to
+// an import that doesn't match the exports of the imported module.
+func isLinkError(err error) bool {
+	switch err.(type) {
+	case wasm.ExportNotFoundError, wasm.KindMismatchError:
+		return true
+	}
+	return err == wasm.ErrNoExportsInImportedModule || err == wasm.ErrImportMutGlobal
+}
+
+// trapMessages returns the messages of the specification test suite for
+// the trap of the VM with err, or nil if err isn't a trap.
+func trapMessages(err error) []string {
+	switch err {
+	case exec.ErrUnreachable:
+		return []string{"unreachable"}
+	case exec.ErrOutOfBoundsMemoryAccess:
+		return []string{"out of bounds memory access"}
+	case exec.ErrOutOfBoundsTableAccess:
+		return []string{"out of bounds table access"}
+	case exec.ErrIntegerOverflow:
+		return []string{"integer overflow"}
+	case exec.ErrInvalidConversion:
+		return []string{"invalid conversion to integer"}
+	case exec.ErrSignatureMismatch:
+		return []string{"indirect call type mismatch"}
+	case exec.ErrUndefinedElementIndex:
+		// out of bounds of the table, or null.
+		return []string{"undefined element", "uninitialized element"}
+	case exec.ErrUnalignedAtomic:
+		return []string{"unaligned atomic"}
+	case exec.ErrWaitUnsharedMemory:
+		return []string{"expected shared memory"}
+	}
+	if re, ok := err.(runtime.Error); ok && re.Error() == "runtime error: integer divide by zero" {
+		return []string{"integer divide by zero"}
+	}
+	return nil
+}
+
+// assertTrap checks that the action of cmd traps with the expected
+// failure. The message of the trap must start with the expected one.
+func (r *runner) assertTrap(cmd *wast.Command) error {
+	_, _, err := r.do(cmd.Action)
+	if err == nil {
+		return fmt.Errorf("expected a trap (%s)", cmd.Failure)
+	}
+	if _, ok := err.(skipError); ok {
+		return err
+	}
+	for _, msg := range trapMessages(err) {
+		if strings.HasPrefix(msg, cmd.Failure) {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected a trap (%s), got %v", cmd.Failure, err)
+}
+
+// assertFailure checks that the instantiation of m fails at one of the
+// given stages.
+func (r *runner) assertFailure(m *wast.ScriptModule, stages ...stage) error {
+	inst := r.instantiate(m)
+	if inst.err == nil {
+		return fmt.Errorf("expected the instantiation of the module to fail")
+	}
+	for _, s := range stages {
+		if inst.stage == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected failure: %v", inst.error())
+}
+
+// lookup returns the module with the given identifier, or the last
+// defined module if id is empty.
+func (r *runner) lookup(id string) (*instance, error) {
+	inst := r.current
+	if id != "" {
+		inst = r.instances[id]
+	}
+	if inst == nil {
+		if id == "" {
+			return nil, fmt.Errorf("no module defined")
+		}
+		return nil, fmt.Errorf("unknown module %s", id)
+	}
+	if inst.err != nil {
+		return nil, inst.error()
+	}
+	return inst, nil
+}
+
+// do performs the action a, and returns its result and the type of the
+// result, which is nil if there is none.
+func (r *runner) do(a *wast.Action) (interface{}, []wasm.ValueType, error) {
+	inst, err := r.lookup(a.ModuleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := inst.module
+	var export wasm.ExportEntry
+	ok := false
+	if m.Export != nil {
+		export, ok = m.Export.Entries[a.Field]
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown export %q", a.Field)
+	}
+
+	switch a.Type {
+	case wast.ActionGet:
+		if export.Kind != wasm.ExternalGlobal {
+			return nil, nil, fmt.Errorf("export %q is not a global", a.Field)
+		}
+		v, err := inst.vm.Global(int(export.Index))
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, []wasm.ValueType{m.GetGlobal(int(export.Index)).Type.Type}, nil
+	}
+
+	if export.Kind != wasm.ExternalFunction {
+		return nil, nil, fmt.Errorf("export %q is not a function", a.Field)
+	}
+	sig := m.GetFunction(int(export.Index)).Sig
+	if len(sig.ReturnTypes) > 1 {
+		return nil, nil, skipError("functions with several results are not supported")
+	}
+	if len(a.Args) != len(sig.ParamTypes) {
+		return nil, nil, fmt.Errorf("%q expects %d arguments, got %d", a.Field, len(sig.ParamTypes), len(a.Args))
+	}
+	args := make([]uint64, len(a.Args))
+	for i, v := range a.Args {
+		switch {
+		case v.Type == wasm.ValueTypeV128:
+			return nil, nil, skipError("v128 arguments are not supported")
+		case v.Null:
+		case v.Type == wasm.ValueTypeExternRef:
+			args[i] = inst.vm.ExternRef(hostRef(v.Bits))
+		default:
+			args[i] = v.Bits
+		}
+	}
+	rtrn, err := inst.vm.ExecCode(int64(export.Index), args...)
+	return rtrn, sig.ReturnTypes, err
+}
+
+func (r *runner) assertReturn(cmd *wast.Command) error {
+	got, types, err := r.do(cmd.Action)
+	if err != nil {
+		return err
+	}
+	if len(types) != len(cmd.Expected) {
+		return fmt.Errorf("expected %d results, got %d", len(cmd.Expected), len(types))
+	}
+	for _, want := range cmd.Expected {
+		if !match(want, got) {
+			return fmt.Errorf("result %v does not match the expected one", got)
+		}
+	}
+	return nil
+}
+
+// match reports whether the value returned by the VM matches the expected
+// value or pattern want.
+func match(want wast.Value, got interface{}) bool {
+	switch want.Type {
+	case wasm.ValueTypeI32:
+		v, ok := got.(uint32)
+		return ok && v == uint32(want.Bits)
+	case wasm.ValueTypeI64:
+		v, ok := got.(uint64)
+		return ok && v == want.Bits
+	case wasm.ValueTypeF32:
+		v, ok := got.(float32)
+		return ok && matchLane(want.NaN, 0, uint64(math.Float32bits(v)), want.Bits, 32)
+	case wasm.ValueTypeF64:
+		v, ok := got.(float64)
+		return ok && matchLane(want.NaN, 0, math.Float64bits(v), want.Bits, 64)
+	case wasm.ValueTypeV128:
+		v, ok := got.([16]byte)
+		if !ok {
+			return false
+		}
+		if want.NaN == nil {
+			return v == want.V128
+		}
+		size := 16 / len(want.NaN)
+		for i := range want.NaN {
+			var b, w [8]byte
+			copy(b[:], v[i*size:(i+1)*size])
+			copy(w[:], want.V128[i*size:(i+1)*size])
+			if !matchLane(want.NaN, i, binary.LittleEndian.Uint64(b[:]), binary.LittleEndian.Uint64(w[:]), size*8) {
+				return false
+			}
+		}
+		return true
+	case wasm.ValueTypeFuncRef:
+		_, ok := got.(uint32)
+		return want.Null && got == nil || want.AnyRef && ok
+	case wasm.ValueTypeExternRef:
+		switch {
+		case want.Null:
+			return got == nil
+		case want.AnyRef:
+			return got != nil
+		}
+		return got == hostRef(want.Bits)
+	}
+	return false
+}
+
+// matchLane reports whether the bits of the i-th floating point lane of a
+// result match the expected bits, or the NaN pattern of the lane.
+func matchLane(nan []wast.NaNPattern, i int, bits, want uint64, bitSize int) bool {
+	if nan == nil || nan[i] == wast.NaNNone {
+		return bits == want
+	}
+	mantBits, expMask := uint(52), uint64(0x7ff)<<52
+	if bitSize == 32 {
+		mantBits, expMask = 23, uint64(0xff)<<23
+	}
+	quiet := uint64(1) << (mantBits - 1)
+	payload := bits & (1<<mantBits - 1)
+	if bits&expMask != expMask || payload == 0 {
+		return false
+	}
+	if nan[i] == wast.NaNCanonical {
+		return payload == quiet
+	}
+	return payload&quiet != 0
+}
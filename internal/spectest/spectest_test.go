@@ -0,0 +1,73 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spectest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wast"
+)
+
+func TestRunFile(t *testing.T) {
+	fnames, err := filepath.Glob("testdata/*.wast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range fnames {
+		report, err := RunFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range report.Results {
+			if res.Status == Fail {
+				t.Errorf("%s:%d: %s: %s", fname, res.Line, res.Command, res.Msg)
+			}
+		}
+		t.Log(report)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/binary.wast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the first module of the script, and check wrong assertions
+	// about it.
+	src := string(raw[:strings.Index(string(raw), "(assert_return")]) + `
+(assert_return (invoke "add" (i32.const 1) (i32.const 2)) (i32.const 4))
+(assert_return (invoke "fdiv" (f32.const 1) (f32.const 1)) (f32.const nan:arithmetic))
+(assert_return (invoke "null") (ref.func))
+(assert_trap (invoke "add" (i32.const 1) (i32.const 2)) "unreachable")
+(assert_return (invoke "missing"))
+(assert_return (invoke "add" (i32.const 1)) (i32.const 1))
+(assert_return (invoke $unknown "add" (i32.const 1) (i32.const 2)) (i32.const 3))
+(assert_trap (invoke "nope") "unreachable")
+(assert_trap (invoke $unknown "add" (i32.const 1) (i32.const 2)) "unreachable")
+(assert_trap (invoke "div_s" (i32.const 1) (i32.const 0)) "integer overflow")
+(assert_invalid (module (import "unknown" "f" (func))) "unknown import")
+(module $unlinkable (import "unknown" "f" (func)) (func (export "f") unreachable))
+(assert_trap (invoke "f") "unreachable")
+`
+	s, err := wast.ParseScript(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Run("failures", s)
+	if len(report.Results) != 14 {
+		t.Fatalf("got %d results, want 14", len(report.Results))
+	}
+	if report.Results[0].Status != Pass {
+		t.Errorf("the module was not instantiated: %s", report.Results[0].Msg)
+	}
+	for _, res := range report.Results[1:] {
+		if res.Status != Fail {
+			t.Errorf("line %d: %s: got status %v, want %v", res.Line, res.Command, res.Status, Fail)
+		}
+	}
+}
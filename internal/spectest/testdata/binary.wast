@@ -0,0 +1,84 @@
+;; Commands of all kinds, on modules in the binary format.
+
+;; (module $m
+;;   (import "spectest" "global_i32" (global i32))
+;;   (global (export "g") (mut i32) (i32.const 42))
+;;   (func (export "add") (param i32 i32) (result i32) ...)
+;;   (func (export "div_s") (param i32 i32) (result i32) ...)
+;;   (func (export "fdiv") (param f32 f32) (result f32) ...)
+;;   (func (export "set_g") (param i32) ...)
+;;   (func (export "ext") (param externref) (result externref) ...)
+;;   (func (export "null") (result funcref) ...)
+;;   (func (export "spectest_global") (result i32) ...))
+(module $m binary
+  "\00\61\73\6d\01\00\00\00\01\1e\06\60\02\7f\7f\01\7f\60\02\7d"
+  "\7d\01\7d\60\01\7f\00\60\01\6f\01\6f\60\00\01\70\60\00\01\7f"
+  "\02\18\01\08\73\70\65\63\74\65\73\74\0a\67\6c\6f\62\61\6c\5f"
+  "\69\33\32\03\7f\00\03\08\07\00\00\01\02\03\04\05\06\06\01\7f"
+  "\01\41\2a\0b\07\41\08\03\61\64\64\00\00\05\64\69\76\5f\73\00"
+  "\01\01\67\03\01\04\66\64\69\76\00\02\05\73\65\74\5f\67\00\03"
+  "\03\65\78\74\00\04\04\6e\75\6c\6c\00\05\0f\73\70\65\63\74\65"
+  "\73\74\5f\67\6c\6f\62\61\6c\00\06\0a\2f\07\07\00\20\00\20\01"
+  "\6a\0b\07\00\20\00\20\01\6d\0b\07\00\20\00\20\01\95\0b\06\00"
+  "\20\00\24\01\0b\04\00\20\00\0b\04\00\d0\70\0b\04\00\23\00\0b"
+)
+
+(assert_return (invoke "add" (i32.const 1) (i32.const 2)) (i32.const 3))
+(assert_return (invoke "add" (i32.const -1) (i32.const 0x7fff_ffff)) (i32.const 0x7ffffffe))
+(assert_trap (invoke "div_s" (i32.const 1) (i32.const 0)) "integer divide by zero")
+(assert_return (invoke "fdiv" (f32.const 0) (f32.const 0)) (f32.const nan:canonical))
+(assert_return (invoke "fdiv" (f32.const 1) (f32.const 0x1p1)) (f32.const 0.5))
+(assert_return (get "g") (i32.const 42))
+(invoke "set_g" (i32.const 7))
+(assert_return (get $m "g") (i32.const 7))
+(assert_return (invoke "ext" (ref.extern 1)) (ref.extern 1))
+(assert_return (invoke "ext" (ref.extern 1)) (ref.extern))
+(assert_return (invoke "ext" (ref.null extern)) (ref.null extern))
+(assert_return (invoke "null") (ref.null func))
+(assert_return (invoke "spectest_global") (i32.const 666))
+
+(register "m" $m)
+
+;; (module
+;;   (import "m" "add" (func (param i32 i32) (result i32)))
+;;   (func (export "call_add") (result i32) ...))
+(module binary
+  "\00\61\73\6d\01\00\00\00\01\0b\02\60\02\7f\7f\01\7f\60\00\01"
+  "\7f\02\09\01\01\6d\03\61\64\64\00\00\03\02\01\01\07\0c\01\08"
+  "\63\61\6c\6c\5f\61\64\64\00\01\0a\0a\01\08\00\41\02\41\03\10"
+  "\00\0b"
+)
+
+(assert_return (invoke "call_add") (i32.const 5))
+
+(assert_malformed
+  (module binary "\00msa\01\00\00\00")
+  "magic header not detected"
+)
+
+;; (module (func (result i32) (local.get 0)))
+(assert_invalid
+  (module binary
+    "\00\61\73\6d\01\00\00\00\01\05\01\60\00\01\7f\03\02\01\00\0a"
+    "\06\01\04\00\20\00\0b"
+  )
+  "unknown local"
+)
+
+;; (module (import "m" "nope" (func)))
+(assert_unlinkable
+  (module binary
+    "\00\61\73\6d\01\00\00\00\01\04\01\60\00\00\02\0a\01\01\6d\04"
+    "\6e\6f\70\65\00\00"
+  )
+  "unknown import"
+)
+
+;; (module (func unreachable) (start 0))
+(assert_trap
+  (module binary
+    "\00\61\73\6d\01\00\00\00\01\04\01\60\00\00\03\02\01\00\08\01"
+    "\00\0a\05\01\03\00\00\0b"
+  )
+  "unreachable"
+)
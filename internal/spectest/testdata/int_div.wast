@@ -0,0 +1,20 @@
+;; The overflow of the signed divisions, taken from the int_exprs and
+;; traps tests of the spec.
+
+(module
+  (func (export "i32.div_s") (param i32 i32) (result i32)
+    (i32.div_s (local.get 0) (local.get 1)))
+  (func (export "i64.div_s") (param i64 i64) (result i64)
+    (i64.div_s (local.get 0) (local.get 1)))
+  (func (export "no_dce.i32.div_s") (param i32 i32)
+    (drop (i32.div_s (local.get 0) (local.get 1))))
+  (func (export "no_dce.i64.div_s") (param i64 i64)
+    (drop (i64.div_s (local.get 0) (local.get 1)))))
+
+(assert_trap (invoke "i32.div_s" (i32.const 0x80000000) (i32.const -1)) "integer overflow")
+(assert_trap (invoke "i64.div_s" (i64.const 0x8000000000000000) (i64.const -1)) "integer overflow")
+(assert_trap (invoke "no_dce.i32.div_s" (i32.const 0x80000000) (i32.const -1)) "integer overflow")
+(assert_trap (invoke "no_dce.i64.div_s" (i64.const 0x8000000000000000) (i64.const -1)) "integer overflow")
+(assert_return (invoke "i32.div_s" (i32.const 0x80000000) (i32.const 1)) (i32.const 0x80000000))
+(assert_return (invoke "i64.div_s" (i64.const 0x8000000000000000) (i64.const 1)) (i64.const 0x8000000000000000))
+(assert_trap (invoke "i32.div_s" (i32.const 1) (i32.const 0)) "integer divide by zero")
@@ -0,0 +1,28 @@
+;; Offsets of 64-bit memories beyond 4GiB, which must neither be rejected
+;; nor truncated to 32 bits.
+
+(module
+  (memory i64 1)
+  (data (i64.const 16) "\2a\00\00\00")
+  (func (export "load") (param i64) (result i32)
+    (i32.load (local.get 0)))
+  (func (export "load_4gib") (param i64) (result i32)
+    (i32.load offset=4294967312 (local.get 0)))
+  (func (export "store_4gib") (param i64)
+    (i32.store offset=4294967312 (local.get 0) (i32.const 7)))
+  (func (export "load_max") (param i64) (result i32)
+    (i32.load offset=0xffffffffffffffff (local.get 0))))
+
+(assert_return (invoke "load" (i64.const 16)) (i32.const 42))
+(assert_trap (invoke "load_4gib" (i64.const 0)) "out of bounds memory access")
+(assert_trap (invoke "store_4gib" (i64.const 0)) "out of bounds memory access")
+(assert_return (invoke "load" (i64.const 16)) (i32.const 42))
+;; the effective address wraps around to 16, which must trap.
+(assert_trap (invoke "load_4gib" (i64.const -4294967296)) "out of bounds memory access")
+(assert_trap (invoke "load_max" (i64.const 17)) "out of bounds memory access")
+
+(assert_invalid
+  (module
+    (memory 1)
+    (func (drop (i32.load offset=4294967296 (i32.const 0)))))
+  "offset out of range")
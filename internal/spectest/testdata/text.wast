@@ -0,0 +1,60 @@
+;; Modules in the text format.
+
+(module $math
+  (import "spectest" "global_i32" (global $base i32))
+  (global $count (export "count") (mut i32) (i32.const 0))
+  (memory (export "mem") (data "\01\02\03\04"))
+  (func $fac (export "fac") (param $n i64) (result i64)
+    (global.set $count (i32.add (global.get $count) (i32.const 1)))
+    (if (result i64) (i64.eqz (local.get $n))
+      (then (i64.const 1))
+      (else (i64.mul (local.get $n) (call $fac (i64.sub (local.get $n) (i64.const 1)))))))
+  (func (export "base") (result i32) global.get $base)
+  (func (export "load") (param i32) (result i32)
+    local.get 0
+    i32.load8_u offset=1)
+  (func (export "sum") (param $n i32) (result i32) (local $s i32)
+    block $done
+      loop $loop
+        local.get $n
+        i32.eqz
+        br_if $done
+        (local.set $s (i32.add (local.get $s) (local.get $n)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        br $loop
+      end
+    end
+    local.get $s)
+  (func (export "div") (param f64 f64) (result f64)
+    (f64.div (local.get 0) (local.get 1)))
+  (func $loop (export "loop") (call $loop))
+  (func $grow (export "grow") (param i32) (local i64 i64 i64 i64)
+    (call $grow (i32.add (local.get 0) (i32.const 1)))))
+
+(assert_return (invoke "fac" (i64.const 20)) (i64.const 2432902008176640000))
+(assert_return (get "count") (i32.const 21))
+(assert_return (invoke "base") (i32.const 666))
+(assert_return (invoke "load" (i32.const 2)) (i32.const 4))
+(assert_trap (invoke "load" (i32.const 65535)) "out of bounds memory access")
+(assert_return (invoke "sum" (i32.const 100)) (i32.const 5050))
+(assert_return (invoke "div" (f64.const 0x1p+0) (f64.const 0)) (f64.const inf))
+(assert_return (invoke "div" (f64.const 0) (f64.const 0)) (f64.const nan:canonical))
+(assert_exhaustion (invoke "loop") "call stack exhausted")
+(assert_exhaustion (invoke "grow" (i32.const 0)) "call stack exhausted")
+
+(register "math" $math)
+(module
+  (import "math" "sum" (func $sum (param i32) (result i32)))
+  (func (export "sum10") (result i32) (call $sum (i32.const 10))))
+(assert_return (invoke "sum10") (i32.const 55))
+
+(module quote
+  "(func (export \"answer\") (result i32)"
+  "  i32.const 42)")
+(assert_return (invoke "answer") (i32.const 42))
+
+(assert_malformed (module quote "(func (i32.unknown))") "unknown operator")
+(assert_malformed (module quote "(func (br $l))") "unknown label")
+(assert_invalid (module (func (result i32) (i32.add (i64.const 0) (i32.const 1)))) "type mismatch")
+(assert_unlinkable (module (import "math" "nope" (func))) "unknown import")
+(assert_trap (module (func $f unreachable) (start $f)) "unreachable")
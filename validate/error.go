@@ -24,6 +24,23 @@ func (e Error) Error() string {
 
 var ErrStackUnderflow = errors.New("validate: stack underflow")
 
+// ErrTailCallResultMismatch is returned when the results of the function
+// called by a tail call aren't the results of the calling function.
+var ErrTailCallResultMismatch = errors.New("validate: tail call result types don't match the caller's")
+
+// ErrMultipleResults is returned for functions with more than one result,
+// which aren't supported.
+var ErrMultipleResults = errors.New("validate: multiple results are not supported")
+
+// ErrExtraOperands is returned when a function leaves more values on the
+// stack than its results.
+var ErrExtraOperands = errors.New("validate: values left on the stack at the end of the function")
+
+// ErrOffsetOutOfRange is returned when the offset of an access to a 32-bit
+// linear memory is larger than 2^32-1, which only the offsets of 64-bit
+// memories can be.
+var ErrOffsetOutOfRange = errors.New("validate: memory offset out of range")
+
 type InvalidImmediateError struct {
 	ImmType string
 	OpName  string
@@ -61,14 +78,59 @@ func (e InvalidTypeError) Error() string {
 	return fmt.Sprintf("invalid type, got: %v, wanted: %v", e.Got, e.Wanted)
 }
 
+// InvalidTagTypeError is returned when the type of a tag is not the index
+// of the type of a function without results.
+type InvalidTagTypeError uint32
+
+func (e InvalidTagTypeError) Error() string {
+	return fmt.Sprintf("invalid type index %d for tag", uint32(e))
+}
+
+type InvalidRefTypeError wasm.ValueType
+
+func (e InvalidRefTypeError) Error() string {
+	return fmt.Sprintf("invalid type, got: %v, wanted a reference type", wasm.ValueType(e))
+}
+
 type InvalidElementIndexError uint32
 
 func (e InvalidElementIndexError) Error() string {
 	return fmt.Sprintf("invalid element index %d", uint32(e))
 }
 
+type InvalidDataIndexError uint32
+
+func (e InvalidDataIndexError) Error() string {
+	return fmt.Sprintf("invalid data segment index %d", uint32(e))
+}
+
 type NoSectionError wasm.SectionID
 
 func (e NoSectionError) Error() string {
 	return fmt.Sprintf("reference to non existant section (id %d) in module", wasm.SectionID(e))
 }
+
+type InvalidLaneIndexError uint8
+
+func (e InvalidLaneIndexError) Error() string {
+	return fmt.Sprintf("invalid lane index %d", uint8(e))
+}
+
+// FloatOpError is returned when a module uses the float operator with the
+// given name, while floats are rejected by Options.NoFloat.
+type FloatOpError string
+
+func (e FloatOpError) Error() string {
+	return fmt.Sprintf("float operator %s is not allowed", string(e))
+}
+
+// LimitError is returned when a function exceeds one of the limits set by
+// Options: Limit is "locals" or "body size".
+type LimitError struct {
+	Limit      string
+	Value, Max uint64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package validate_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-interpreter/wagon/internal/fuzztest"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func FuzzVerifyModule(f *testing.F) {
+	fuzztest.AddSeeds(f, "..")
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		m, err := wasm.ReadModuleWithOptions(bytes.NewReader(raw), nil, fuzztest.DecodeOptions)
+		if err != nil {
+			return
+		}
+		validate.VerifyModule(m)
+	})
+}
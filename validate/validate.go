@@ -8,13 +8,18 @@ package validate
 import (
 	"bytes"
 	"io"
+	"math"
+	"sort"
+	"strings"
 
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/internal/parallel"
 	"github.com/go-interpreter/wagon/wasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 // vibhavp: TODO: We do not verify whether blocks don't access for the parent block, do that.
-func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Module) (*mockVM, error) {
+func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Module, opts Options) (*mockVM, error) {
 	vm := &mockVM{
 		stack:    []operand{},
 		stackTop: 0,
@@ -27,21 +32,22 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 		curFunc:     fn,
 	}
 
-	localVariables := []operand{}
-
 	// Paramters count as local variables too
 	// This comment explains how local variables work: https://github.com/WebAssembly/design/issues/1037#issuecomment-293505798
-	for _, entry := range fn.ParamTypes {
-		localVariables = append(localVariables, operand{entry})
-	}
+	localVariables := newLocals(fn, body)
 
-	for _, entry := range body.Locals {
-		vars := make([]operand, entry.Count)
-		for i := uint32(0); i < entry.Count; i++ {
-			vars[i].Type = entry.Type
-			logger.Printf("Var %v", entry.Type)
-		}
-		localVariables = append(localVariables, vars...)
+	maxLocals := uint64(math.MaxUint32)
+	if opts.MaxLocals > 0 && uint64(opts.MaxLocals) < maxLocals {
+		maxLocals = uint64(opts.MaxLocals)
+	}
+	if n := localVariables.len(); n > maxLocals {
+		return vm, LimitError{"locals", n, maxLocals}
+	}
+	if len(fn.ReturnTypes) > 1 {
+		return vm, ErrMultipleResults
+	}
+	if opts.MaxBodySize > 0 && len(body.Code) > opts.MaxBodySize {
+		return vm, LimitError{"body size", uint64(len(body.Code)), uint64(opts.MaxBodySize)}
 	}
 
 	for {
@@ -52,28 +58,44 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			return vm, err
 		}
 
-		opStruct, err := ops.New(op)
-		if err != nil {
-			return vm, err
+		var opStruct ops.Op
+		if ops.IsPrefix(op) {
+			sub, err := vm.fetchVarUint()
+			if err != nil {
+				return vm, err
+			}
+			opStruct, err = ops.NewPrefixed(op, sub)
+			if err != nil {
+				return vm, err
+			}
+		} else {
+			opStruct, err = ops.New(op)
+			if err != nil {
+				return vm, err
+			}
 		}
 
 		logger.Printf("PC: %d OP: %s polymorphic: %v", vm.pc(), opStruct.Name, vm.isPolymorphic())
 
+		if opts.NoFloat && isFloatOp(opStruct) {
+			return vm, FloatOpError(opStruct.Name)
+		}
+
 		if !opStruct.Polymorphic {
-			if err := vm.adjustStack(opStruct); err != nil {
+			if err := vm.adjustStack(vm.addressOperands(opStruct, module)); err != nil {
 				return vm, err
 			}
 		}
 
 		switch op {
-		case ops.If, ops.Block, ops.Loop:
+		case ops.If, ops.Block, ops.Loop, ops.Try:
 			sig, err := vm.fetchVarInt()
 			if err != nil {
 				return vm, err
 			}
 
 			switch wasm.ValueType(sig) {
-			case wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64, wasm.ValueType(wasm.BlockTypeEmpty):
+			case wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64, wasm.ValueTypeV128, wasm.ValueTypeFuncRef, wasm.ValueTypeExternRef, wasm.ValueType(wasm.BlockTypeEmpty):
 				vm.pushBlock(op, wasm.BlockType(sig))
 			default:
 				if !vm.isPolymorphic() {
@@ -95,7 +117,50 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				vm.pushOperand(wasm.ValueType(block.blockType))
 			}
 			vm.stackTop = block.stackTop
-		case ops.End:
+			// an if block has one else at most.
+			block.op = op
+		case ops.Catch, ops.CatchAll:
+			// as else, catch and catch_all end the previous part of
+			// the block, and catch pushes the values of the exception.
+			block := vm.topBlock()
+			if block == nil || (block.op != ops.Try && block.op != ops.Catch) {
+				return vm, UnmatchedOpError(op)
+			}
+
+			if block.blockType != wasm.BlockTypeEmpty {
+				top, under := vm.topOperand()
+				if !vm.isPolymorphic() && (under || top.Type != wasm.ValueType(block.blockType)) {
+					return vm, InvalidTypeError{wasm.ValueType(block.blockType), top.Type}
+				}
+			}
+			vm.stackTop = block.stackTop
+			block.op = op
+
+			if op == ops.Catch {
+				sig, err := vm.fetchTag(module)
+				if err != nil {
+					return vm, err
+				}
+				for _, t := range sig.ParamTypes {
+					vm.pushOperand(t)
+				}
+			}
+		case ops.End, ops.Delegate:
+			if op == ops.Delegate {
+				block := vm.topBlock()
+				if block == nil || block.op != ops.Try {
+					return vm, UnmatchedOpError(op)
+				}
+				// the label is relative to the block enclosing the
+				// try block, the function body being the outermost.
+				depth, err := vm.fetchVarUint()
+				if err != nil {
+					return vm, err
+				}
+				if int(depth) >= len(vm.blocks) {
+					return vm, InvalidLabelError(depth)
+				}
+			}
 			isPolymorphic := vm.isPolymorphic()
 
 			block := vm.popBlock()
@@ -158,12 +223,32 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			}
 			vm.setPolymorphic()
 
-		case ops.Return:
-			if len(fn.ReturnTypes) > 1 {
-				panic("not implemented")
+		case ops.Throw:
+			sig, err := vm.fetchTag(module)
+			if err != nil {
+				return vm, err
 			}
+			for i := len(sig.ParamTypes) - 1; i >= 0; i-- {
+				operand, under := vm.popOperand()
+				if !vm.isPolymorphic() && (under || operand.Type != sig.ParamTypes[i]) {
+					return vm, InvalidTypeError{sig.ParamTypes[i], operand.Type}
+				}
+			}
+			vm.setPolymorphic()
+		case ops.Rethrow:
+			depth, err := vm.fetchVarUint()
+			if err != nil {
+				return vm, err
+			}
+			// the label must be the one of a catch clause
+			block := vm.getBlockFromDepth(int(depth))
+			if block == nil || (block.op != ops.Catch && block.op != ops.CatchAll) {
+				return vm, InvalidLabelError(depth)
+			}
+			vm.setPolymorphic()
+
+		case ops.Return:
 			if len(fn.ReturnTypes) != 0 {
-				// only single returns supported for now
 				top, under := vm.popOperand()
 				if !vm.isPolymorphic() && (under || top.Type != fn.ReturnTypes[0]) {
 					return vm, InvalidTypeError{fn.ReturnTypes[0], top.Type}
@@ -175,7 +260,7 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			vm.setPolymorphic()
 
 		case ops.I32Const:
-			_, err := vm.fetchVarUint()
+			_, err := vm.fetchVarInt()
 			if err != nil {
 				return vm, err
 			}
@@ -199,21 +284,20 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			if err != nil {
 				return vm, err
 			}
-			if int(i) >= len(localVariables) {
+			t, ok := localVariables.get(i)
+			if !ok {
 				return vm, InvalidLocalIndexError(i)
 			}
 
-			v := localVariables[i]
-
 			if op == ops.GetLocal {
-				vm.pushOperand(v.Type)
+				vm.pushOperand(t)
 			} else { // == set_local or tee_local
 				top, under := vm.popOperand()
-				if !vm.isPolymorphic() && (under || top.Type != v.Type) {
-					return vm, InvalidTypeError{v.Type, top.Type}
+				if !vm.isPolymorphic() && (under || top.Type != t) {
+					return vm, InvalidTypeError{t, top.Type}
 				}
 				if op == ops.TeeLocal {
-					vm.pushOperand(v.Type)
+					vm.pushOperand(t)
 				}
 			}
 
@@ -237,24 +321,28 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			}
 
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
-			// read memory_immediate
-			// flags
-			_, err := vm.fetchVarUint()
-			if err != nil {
+			if _, err := vm.fetchMemoryImmediate(module); err != nil {
 				return vm, err
 			}
-			// offset
-			_, err = vm.fetchVarUint()
-			if err != nil {
+		case ops.CurrentMemory, ops.GrowMemory:
+			if err := vm.fetchMemoryIndex(module); err != nil {
 				return vm, err
 			}
-		case ops.CurrentMemory, ops.GrowMemory:
-			_, err := vm.fetchVarUint()
-			if err != nil {
+
+		case ops.MiscPrefix:
+			if err := vm.verifyMiscOp(opStruct.Sub, module); err != nil {
+				return vm, err
+			}
+		case ops.SIMDPrefix:
+			if err := vm.verifySIMDOp(opStruct.Sub, module); err != nil {
+				return vm, err
+			}
+		case ops.AtomicPrefix:
+			if err := vm.verifyAtomicOp(opStruct, module); err != nil {
 				return vm, err
 			}
 
-		case ops.Call:
+		case ops.Call, ops.ReturnCall:
 			index, err := vm.fetchVarUint()
 			if err != nil {
 				return vm, err
@@ -274,14 +362,15 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				}
 			}
 
-			if len(fn.Sig.ReturnTypes) > 0 {
+			if op == ops.ReturnCall {
+				if err := vm.verifyTailCall(fn.Sig); err != nil {
+					return vm, err
+				}
+			} else if len(fn.Sig.ReturnTypes) > 0 {
 				vm.pushOperand(fn.Sig.ReturnTypes[0])
 			}
 
-		case ops.CallIndirect:
-			if module.Table == nil || len(module.Table.Entries) == 0 {
-				return vm, NoSectionError(wasm.SectionIDTable)
-			}
+		case ops.CallIndirect, ops.ReturnCallIndirect:
 			// The call_indirect process consists of getting two i32 values
 			// off (first from the bytecode stream, and the second from
 			//  the stack) and using first as an index into the "Types" section
@@ -299,8 +388,20 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				return vm, err
 			}
 
+			if module.Types == nil || int(index) >= len(module.Types.Entries) {
+				return vm, InvalidImmediateError{"type index", opStruct.Name}
+			}
 			fnExpectSig := module.Types.Entries[index]
 
+			// table index, which was a reserved 0 byte in the MVP
+			table, err := vm.fetchTable(module)
+			if err != nil {
+				return vm, err
+			}
+			if table.ElementType != wasm.ElemTypeAnyFunc {
+				return vm, InvalidTypeError{wasm.ValueTypeFuncRef, wasm.ValueType(table.ElementType)}
+			}
+
 			if operand, under := vm.popOperand(); !vm.isPolymorphic() && (under || operand.Type != wasm.ValueTypeI32) {
 				return vm, InvalidTypeError{wasm.ValueTypeI32, operand.Type}
 			}
@@ -313,7 +414,11 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				}
 			}
 
-			if len(fnExpectSig.ReturnTypes) > 0 {
+			if op == ops.ReturnCallIndirect {
+				if err := vm.verifyTailCall(&fnExpectSig); err != nil {
+					return vm, err
+				}
+			} else if len(fnExpectSig.ReturnTypes) > 0 {
 				vm.pushOperand(fnExpectSig.ReturnTypes[0])
 			}
 
@@ -342,19 +447,471 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 
 			// last 2 popped values should be of the same type
 			if operands[0].Type != operands[1].Type {
-				return vm, InvalidTypeError{operands[1].Type, operands[2].Type}
+				return vm, InvalidTypeError{operands[1].Type, operands[0].Type}
 			}
 
 			vm.pushOperand(operands[1].Type)
+
+		case ops.TypedSelect:
+			count, err := vm.fetchVarUint()
+			if err != nil {
+				return vm, err
+			}
+			if count != 1 {
+				return vm, InvalidImmediateError{"single value_type", opStruct.Name}
+			}
+			t, err := vm.fetchVarInt()
+			if err != nil {
+				return vm, err
+			}
+			for _, want := range []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueType(t), wasm.ValueType(t)} {
+				if err := vm.popOperandType(want); err != nil {
+					return vm, err
+				}
+			}
+			vm.pushOperand(wasm.ValueType(t))
+
+		case ops.RefNull:
+			t, err := vm.fetchVarInt()
+			if err != nil {
+				return vm, err
+			}
+			if !wasm.ValueType(t).IsRef() {
+				return vm, InvalidImmediateError{"reference type", opStruct.Name}
+			}
+			vm.pushOperand(wasm.ValueType(t))
+
+		case ops.RefIsNull:
+			operand, under := vm.popOperand()
+			if !vm.isPolymorphic() && (under || !operand.Type.IsRef()) {
+				return vm, InvalidRefTypeError(operand.Type)
+			}
+			vm.pushOperand(wasm.ValueTypeI32)
+
+		case ops.RefFunc:
+			index, err := vm.fetchVarUint()
+			if err != nil {
+				return vm, err
+			}
+			if module.GetFunction(int(index)) == nil {
+				return vm, wasm.InvalidFunctionIndexError(index)
+			}
+
+		case ops.TableGet, ops.TableSet:
+			table, err := vm.fetchTable(module)
+			if err != nil {
+				return vm, err
+			}
+			t := wasm.ValueType(table.ElementType)
+			if op == ops.TableSet {
+				if err := vm.popOperandType(t); err != nil {
+					return vm, err
+				}
+			}
+			if err := vm.popOperandType(wasm.ValueTypeI32); err != nil {
+				return vm, err
+			}
+			if op == ops.TableGet {
+				vm.pushOperand(t)
+			}
+		}
+	}
+
+	// the end of the function body, which isn't part of the code, can
+	// only close the function's own block.
+	if block := vm.topBlock(); block != nil {
+		return vm, UnmatchedOpError(block.op)
+	}
+	// as for a return, the function leaves its results on the stack, and
+	// nothing else, unless the end is unreachable.
+	if !vm.isPolymorphic() {
+		if len(fn.ReturnTypes) != 0 {
+			top, under := vm.topOperand()
+			if under || top.Type != fn.ReturnTypes[0] {
+				return vm, InvalidTypeError{fn.ReturnTypes[0], top.Type}
+			}
+		}
+		if vm.stackTop > len(fn.ReturnTypes) {
+			return vm, ErrExtraOperands
 		}
 	}
 
 	return vm, nil
 }
 
+// verifyMiscOp reads and checks the immediates of the operator prefixed by
+// ops.MiscPrefix with the sub-opcode sub. Its operands have already been
+// checked by adjustStack.
+func (vm *mockVM) verifyMiscOp(sub uint32, module *wasm.Module) error {
+	switch sub {
+	case ops.MemoryInit, ops.DataDrop:
+		index, err := vm.fetchVarUint()
+		if err != nil {
+			return err
+		}
+		// the number of data segments must be known before the code section,
+		// which is the purpose of the data count section.
+		if module.DataCount == nil {
+			return NoSectionError(wasm.SectionIDDataCount)
+		}
+		if index >= module.DataCount.Count {
+			return InvalidDataIndexError(index)
+		}
+		if sub == ops.MemoryInit {
+			return vm.fetchMemoryIndex(module)
+		}
+	case ops.MemoryCopy:
+		if err := vm.fetchMemoryIndex(module); err != nil {
+			return err
+		}
+		return vm.fetchMemoryIndex(module)
+	case ops.MemoryFill:
+		return vm.fetchMemoryIndex(module)
+	case ops.TableInit, ops.ElemDrop:
+		index, err := vm.fetchVarUint()
+		if err != nil {
+			return err
+		}
+		if module.Elements == nil || int(index) >= len(module.Elements.Entries) {
+			return InvalidElementIndexError(index)
+		}
+		if sub == ops.TableInit {
+			table, err := vm.fetchTable(module)
+			if err != nil {
+				return err
+			}
+			if t := module.Elements.Entries[index].Type; t != table.ElementType {
+				return InvalidTypeError{wasm.ValueType(table.ElementType), wasm.ValueType(t)}
+			}
+		}
+	case ops.TableCopy:
+		dst, err := vm.fetchTable(module)
+		if err != nil {
+			return err
+		}
+		src, err := vm.fetchTable(module)
+		if err != nil {
+			return err
+		}
+		if src.ElementType != dst.ElementType {
+			return InvalidTypeError{wasm.ValueType(dst.ElementType), wasm.ValueType(src.ElementType)}
+		}
+	case ops.TableSize:
+		_, err := vm.fetchTable(module)
+		return err
+	case ops.TableGrow, ops.TableFill:
+		table, err := vm.fetchTable(module)
+		if err != nil {
+			return err
+		}
+		// table.grow takes the initial value and the number of new
+		// elements, table.fill the index, the value and the number of
+		// elements to set.
+		args := []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueType(table.ElementType)}
+		if sub == ops.TableFill {
+			args = append(args, wasm.ValueTypeI32)
+		}
+		for _, t := range args {
+			if err := vm.popOperandType(t); err != nil {
+				return err
+			}
+		}
+		if sub == ops.TableGrow {
+			vm.pushOperand(wasm.ValueTypeI32)
+		}
+	}
+	return nil
+}
+
+// verifySIMDOp reads and checks the immediates of the operator prefixed by
+// ops.SIMDPrefix with the sub-opcode sub. Its operands have already been
+// checked by adjustStack.
+func (vm *mockVM) verifySIMDOp(sub uint32, module *wasm.Module) error {
+	if disasm.IsSIMDMemoryOp(sub) {
+		if _, err := vm.fetchMemoryImmediate(module); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case sub == ops.V128Const || sub == ops.I8x16Shuffle:
+		var imm [16]byte
+		if _, err := io.ReadFull(vm.code, imm[:]); err != nil {
+			return err
+		}
+		if sub == ops.I8x16Shuffle {
+			for _, lane := range imm {
+				if lane >= 32 {
+					return InvalidLaneIndexError(lane)
+				}
+			}
+		}
+	case sub >= ops.I8x16ExtractLaneS && sub <= ops.F64x2ReplaceLane,
+		sub >= ops.V128Load8Lane && sub <= ops.V128Store64Lane:
+		lane, err := vm.code.ReadByte()
+		if err != nil {
+			return err
+		}
+		if int(lane) >= simdLaneCount(sub) {
+			return InvalidLaneIndexError(lane)
+		}
+	}
+	return nil
+}
+
+// verifyAtomicOp reads and checks the immediates of an operator prefixed
+// by ops.AtomicPrefix. Unlike other memory operators, the alignment of
+// atomic operators must be the natural alignment of the access.
+func (vm *mockVM) verifyAtomicOp(op ops.Op, module *wasm.Module) error {
+	if op.Sub == ops.AtomicFence {
+		reserved, err := vm.code.ReadByte()
+		if err != nil {
+			return err
+		}
+		if reserved != 0 {
+			return InvalidImmediateError{"reserved", op.Name}
+		}
+		return nil
+	}
+
+	align, err := vm.fetchMemoryImmediate(module)
+	if err != nil {
+		return err
+	}
+	if align != disasm.AtomicAlignment(op.Sub) {
+		return InvalidImmediateError{"memory_immediate", op.Name}
+	}
+	return nil
+}
+
+// simdLaneCount returns the number of lanes of the shape the SIMD operator
+// with the sub-opcode sub operates on.
+func simdLaneCount(sub uint32) int {
+	switch sub {
+	case ops.I8x16ExtractLaneS, ops.I8x16ExtractLaneU, ops.I8x16ReplaceLane, ops.V128Load8Lane, ops.V128Store8Lane:
+		return 16
+	case ops.I16x8ExtractLaneS, ops.I16x8ExtractLaneU, ops.I16x8ReplaceLane, ops.V128Load16Lane, ops.V128Store16Lane:
+		return 8
+	case ops.I32x4ExtractLane, ops.I32x4ReplaceLane, ops.F32x4ExtractLane, ops.F32x4ReplaceLane, ops.V128Load32Lane, ops.V128Store32Lane:
+		return 4
+	}
+	return 2
+}
+
+// verifyTailCall checks that the results of the function called by a
+// return_call or return_call_indirect operator, of signature sig, are the
+// results of the calling function, and ends the current block.
+func (vm *mockVM) verifyTailCall(sig *wasm.FunctionSig) error {
+	results := vm.curFunc.ReturnTypes
+	if len(sig.ReturnTypes) != len(results) {
+		return ErrTailCallResultMismatch
+	}
+	for i, t := range sig.ReturnTypes {
+		if t != results[i] {
+			return ErrTailCallResultMismatch
+		}
+	}
+	vm.setPolymorphic()
+	return nil
+}
+
+// fetchMemoryImmediate reads a memory_immediate, and returns its alignment.
+// It checks that the memory accessed, the first one unless the immediate has
+// a memory index, exists, and that the offset fits in the addresses of the
+// memory.
+func (vm *mockVM) fetchMemoryImmediate(module *wasm.Module) (uint32, error) {
+	align, err := vm.fetchVarUint()
+	if err != nil {
+		return 0, err
+	}
+	index := uint32(0)
+	if align&disasm.MemoryIndexFlag != 0 {
+		align &^= disasm.MemoryIndexFlag
+		if index, err = vm.fetchVarUint(); err != nil {
+			return 0, err
+		}
+	}
+	if err := checkMemoryIndex(module, index); err != nil {
+		return 0, err
+	}
+	offset, err := vm.fetchVarUint64()
+	if err != nil {
+		return 0, err
+	}
+	if mem := module.GetMemory(int(index)); offset > math.MaxUint32 && !mem.Limits.Is64() {
+		return 0, ErrOffsetOutOfRange
+	}
+	return align, nil
+}
+
+// fetchMemoryIndex reads the index of a linear memory, and checks that the
+// memory exists.
+func (vm *mockVM) fetchMemoryIndex(module *wasm.Module) error {
+	index, err := vm.fetchVarUint()
+	if err != nil {
+		return err
+	}
+	return checkMemoryIndex(module, index)
+}
+
+// checkMemoryIndex checks that the linear memory at index exists.
+func checkMemoryIndex(module *wasm.Module, index uint32) error {
+	n := countImports(module, wasm.ExternalMemory)
+	if module.Memory != nil {
+		n += len(module.Memory.Entries)
+	}
+	if int(index) >= n {
+		return wasm.InvalidLinearMemoryIndexError(index)
+	}
+	return nil
+}
+
+// fetchTag reads the index of a tag, and returns the type of the
+// exceptions of the tag.
+func (vm *mockVM) fetchTag(module *wasm.Module) (*wasm.FunctionSig, error) {
+	index, err := vm.fetchVarUint()
+	if err != nil {
+		return nil, err
+	}
+	sig := module.GetTagType(int(index))
+	if sig == nil {
+		return nil, wasm.InvalidTagIndexError(index)
+	}
+	return sig, nil
+}
+
+// fetchTable reads the index of a table, and returns the type of the
+// table.
+func (vm *mockVM) fetchTable(module *wasm.Module) (*wasm.Table, error) {
+	index, err := vm.fetchVarUint()
+	if err != nil {
+		return nil, err
+	}
+	table := module.GetTable(int(index))
+	if table == nil {
+		return nil, wasm.InvalidTableIndexError(index)
+	}
+	return table, nil
+}
+
+// countImports returns the number of entities of the given kind imported
+// by the module.
+func countImports(module *wasm.Module, kind wasm.External) int {
+	if module.Import == nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range module.Import.Entries {
+		if entry.Type.Kind() == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// locals holds the types of the local variables of a function, as runs of
+// variables of the same type, so that declaring many local variables
+// doesn't allocate them.
+type locals struct {
+	ends  []uint64 // the index following the last variable of each run
+	types []wasm.ValueType
+}
+
+// newLocals returns the local variables of a function, starting with its
+// parameters.
+func newLocals(fn *wasm.FunctionSig, body *wasm.FunctionBody) locals {
+	var l locals
+	add := func(n uint64, t wasm.ValueType) {
+		if n == 0 {
+			return
+		}
+		l.ends = append(l.ends, l.len()+n)
+		l.types = append(l.types, t)
+	}
+	for _, t := range fn.ParamTypes {
+		add(1, t)
+	}
+	for _, entry := range body.Locals {
+		add(uint64(entry.Count), entry.Type)
+	}
+	return l
+}
+
+// len returns the number of local variables.
+func (l locals) len() uint64 {
+	if len(l.ends) == 0 {
+		return 0
+	}
+	return l.ends[len(l.ends)-1]
+}
+
+// get returns the type of the local variable i, and whether it exists.
+func (l locals) get(i uint32) (wasm.ValueType, bool) {
+	run := sort.Search(len(l.ends), func(j int) bool { return uint64(i) < l.ends[j] })
+	if run == len(l.ends) {
+		return 0, false
+	}
+	return l.types[run], true
+}
+
+// isFloatOp reports whether op takes or returns floats, or operates on
+// the float lanes of vectors.
+func isFloatOp(op ops.Op) bool {
+	isFloat := func(t wasm.ValueType) bool {
+		return t == wasm.ValueTypeF32 || t == wasm.ValueTypeF64
+	}
+	if isFloat(op.Returns) {
+		return true
+	}
+	for _, t := range op.Args {
+		if isFloat(t) {
+			return true
+		}
+	}
+	return strings.Contains(op.Name, "f32x4") || strings.Contains(op.Name, "f64x2")
+}
+
+// Options restricts the modules accepted by VerifyModuleWithOptions, and
+// sets how they are verified.
+type Options struct {
+	// Workers is the number of goroutines verifying function bodies, as
+	// with VerifyModuleConcurrently.
+	Workers int
+
+	// MaxLocals and MaxBodySize, if positive, limit the number of local
+	// variables of each function, its parameters included, and the size
+	// of its code in bytes. Functions exceeding them are rejected with a
+	// LimitError. There can't be more than 2^32-1 local variables in any
+	// case.
+	MaxLocals   int
+	MaxBodySize int
+
+	// NoFloat rejects the modules using float operators with a
+	// FloatOpError, for the systems where all the nodes running a module
+	// must compute identical results (see also exec.Deterministic).
+	NoFloat bool
+}
+
 // VerifyModule verifies the given module according to WebAssembly verification
 // specs.
 func VerifyModule(module *wasm.Module) error {
+	return VerifyModuleWithOptions(module, Options{})
+}
+
+// VerifyModuleConcurrently is like VerifyModule, but verifies function bodies
+// using at most workers goroutines, or sequentially if workers is lower than
+// 2. The returned error is always the same as the one VerifyModule would
+// return: it reports the failing function with the lowest index.
+func VerifyModuleConcurrently(module *wasm.Module, workers int) error {
+	return VerifyModuleWithOptions(module, Options{Workers: workers})
+}
+
+// VerifyModuleWithOptions is like VerifyModule, with the restrictions and
+// settings of opts.
+func VerifyModuleWithOptions(module *wasm.Module, opts Options) error {
+	if err := verifyTags(module); err != nil {
+		return err
+	}
 	if module.Function == nil || module.Types == nil || len(module.Types.Entries) == 0 {
 		return nil
 	}
@@ -363,12 +920,24 @@ func VerifyModule(module *wasm.Module) error {
 	}
 
 	logger.Printf("There are %d functions", len(module.Function.Types))
-	for i, fn := range module.FunctionIndexSpace {
-		if vm, err := verifyBody(fn.Sig, fn.Body, module); err != nil {
+	return parallel.Run(len(module.FunctionIndexSpace), opts.Workers, func(i int) error {
+		fn := module.FunctionIndexSpace[i]
+		if vm, err := verifyBody(fn.Sig, fn.Body, module, opts); err != nil {
 			return Error{vm.pc(), i, err}
 		}
 		logger.Printf("No errors in function %d", i)
-	}
+		return nil
+	})
+}
 
+// verifyTags checks that the type of each tag of the module is the type of
+// a function without results.
+func verifyTags(module *wasm.Module) error {
+	for i := 0; module.GetTag(i) != nil; i++ {
+		sig := module.GetTagType(i)
+		if sig == nil || len(sig.ReturnTypes) != 0 {
+			return InvalidTagTypeError(module.GetTag(i).Type)
+		}
+	}
 	return nil
 }
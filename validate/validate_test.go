@@ -0,0 +1,135 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package validate_test
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/gen"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// readWat reads the module written in the text format by src, with its
+// index spaces populated.
+func readWat(t *testing.T, src string) *wasm.Module {
+	t.Helper()
+	m, err := wast.ParseModule(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.ReadModule(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// invalidWat has several invalid functions, failing with different errors.
+const invalidWat = `
+(module
+  (func (result i32) (i32.const 0))
+  (func (result i32) (i32.add (i64.const 0) (i32.const 0)))
+  (func (i32.add (i32.const 0)) (drop))
+  (func (result i32) (i32.const 1))
+  (func (result i64) (f32.const 0))
+  (func (i32.const 0) (drop)))
+`
+
+func TestVerifyModuleConcurrently(t *testing.T) {
+	var modules []*wasm.Module
+	for seed := int64(0); seed < 20; seed++ {
+		m, err := gen.Module(rand.New(rand.NewSource(seed)), gen.Config{MaxFunctions: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		modules = append(modules, m)
+	}
+	invalid := readWat(t, invalidWat)
+	modules = append(modules, invalid)
+
+	for i, m := range modules {
+		want := validate.VerifyModule(m)
+		for _, workers := range []int{-1, 0, 1, 2, 4, 64} {
+			// the scheduling of the goroutines changes from one run to
+			// the other, while the error must not.
+			for run := 0; run < 10; run++ {
+				got := validate.VerifyModuleConcurrently(m, workers)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("module %d, %d workers: got %v, want %v", i, workers, got, want)
+				}
+			}
+		}
+	}
+
+	err, ok := validate.VerifyModule(invalid).(validate.Error)
+	if !ok || err.Function != 1 {
+		t.Errorf("got %v, want an error in function 1", err)
+	}
+}
+
+// TestVerifyBody checks the bodies rejected by the validation since the
+// fuzzing of the validator, and a body it wrongly rejected. The code of a
+// body doesn't include the end of the function.
+func TestVerifyBody(t *testing.T) {
+	m := readWat(t, `(module (table 1 funcref) (func (result i32) (i32.const 0)))`)
+	for _, tc := range []struct {
+		name string
+		code []byte
+		err  error
+	}{
+		{"valid", []byte{0x41, 0x00}, nil},
+		{"missing result", nil, validate.InvalidTypeError{Wanted: wasm.ValueTypeI32}},
+		{"wrong result", []byte{0x42, 0x00}, validate.InvalidTypeError{Wanted: wasm.ValueTypeI32, Got: wasm.ValueTypeI64}},
+		{"extra value", []byte{0x41, 0x00, 0x41, 0x00}, validate.ErrExtraOperands},
+		{"unreachable end", []byte{0x41, 0x00, 0x41, 0x00, 0x00}, nil},
+		{"unclosed block", []byte{0x41, 0x00, 0x02, 0x40}, validate.UnmatchedOpError(0x02)},
+		{"else", []byte{0x41, 0x00, 0x04, 0x40, 0x05, 0x0b, 0x41, 0x00}, nil},
+		{"repeated else", []byte{0x41, 0x00, 0x04, 0x40, 0x05, 0x05, 0x0b, 0x41, 0x00}, validate.UnmatchedOpError(0x05)},
+		{"type index", []byte{0x41, 0x00, 0x11, 0x01, 0x00}, validate.InvalidImmediateError{ImmType: "type index", OpName: "call_indirect"}},
+		{"no memory", []byte{0x41, 0x00, 0x28, 0x02, 0x00}, wasm.InvalidLinearMemoryIndexError(0)},
+		{"select", []byte{0x41, 0x00, 0x42, 0x00, 0x41, 0x00, 0x1b}, validate.InvalidTypeError{Wanted: wasm.ValueTypeI32, Got: wasm.ValueTypeI64}},
+		// i32.const takes a signed LEB128 value, here the 5 bytes encoding
+		// of -1, which doesn't fit in an unsigned one.
+		{"i32.const -1", []byte{0x41, 0xff, 0xff, 0xff, 0xff, 0x7f}, nil},
+	} {
+		m.FunctionIndexSpace[0].Body.Code = tc.code
+		err := validate.VerifyModule(m)
+		if e, ok := err.(validate.Error); ok {
+			err = e.Err
+		}
+		if err != tc.err {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestMultipleResults(t *testing.T) {
+	m := readWat(t, `(module (func (result i32) (i32.const 0)))`)
+	sig := m.FunctionIndexSpace[0].Sig
+	sig.ReturnTypes = append(sig.ReturnTypes, wasm.ValueTypeI32)
+	for _, code := range [][]byte{
+		{0x41, 0x00, 0x41, 0x00},       // i32.const 0, i32.const 0
+		{0x41, 0x00, 0x41, 0x00, 0x0f}, // and return
+	} {
+		m.FunctionIndexSpace[0].Body.Code = code
+		err := validate.VerifyModule(m)
+		if e, ok := err.(validate.Error); ok {
+			err = e.Err
+		}
+		if err != validate.ErrMultipleResults {
+			t.Errorf("%x: err = %v, want %v", code, err, validate.ErrMultipleResults)
+		}
+	}
+}
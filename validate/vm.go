@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"io"
 
+	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/wasm"
 	"github.com/go-interpreter/wagon/wasm/leb128"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
@@ -45,6 +46,10 @@ func (vm *mockVM) fetchVarUint() (uint32, error) {
 	return leb128.ReadVarUint32(vm.code)
 }
 
+func (vm *mockVM) fetchVarUint64() (uint64, error) {
+	return leb128.ReadVarUint64(vm.code)
+}
+
 func (vm *mockVM) fetchVarInt() (int32, error) {
 	return leb128.ReadVarint32(vm.code)
 }
@@ -196,6 +201,104 @@ func (vm *mockVM) adjustStack(op ops.Op) error {
 	return nil
 }
 
+// addressOperands returns op, with the type of its operands which are
+// addresses or sizes in a 64-bit linear memory changed to i64. memory.size
+// and memory.grow also return an i64 for such memories. The indices of the
+// memories accessed by op are peeked from its immediates, which are checked
+// later on.
+func (vm *mockVM) addressOperands(op ops.Op, module *wasm.Module) ops.Op {
+	pos, _ := vm.code.Seek(0, io.SeekCurrent)
+	defer vm.code.Seek(pos, io.SeekStart)
+
+	is64 := func(index uint32) bool {
+		mem := module.GetMemory(int(index))
+		return mem != nil && mem.Limits.Is64()
+	}
+	// memoryImmediate returns whether the memory of memory_immediate is
+	// 64-bit.
+	memoryImmediate := func() bool {
+		align, _ := vm.fetchVarUint()
+		if align&disasm.MemoryIndexFlag == 0 {
+			return is64(0)
+		}
+		index, _ := vm.fetchVarUint()
+		return is64(index)
+	}
+	memoryIndex := func() bool {
+		index, _ := vm.fetchVarUint()
+		return is64(index)
+	}
+
+	var addrs []int // the indices of the operands in op.Args
+	switch op.Code {
+	case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
+		if memoryImmediate() {
+			addrs = []int{len(op.Args) - 1}
+		}
+	case ops.CurrentMemory:
+		if memoryIndex() {
+			op.Returns = wasm.ValueTypeI64
+		}
+	case ops.GrowMemory:
+		if memoryIndex() {
+			addrs = []int{0}
+			op.Returns = wasm.ValueTypeI64
+		}
+	case ops.MiscPrefix:
+		switch op.Sub {
+		case ops.MemoryInit:
+			vm.fetchVarUint() // data segment index
+			if memoryIndex() {
+				// the offset in the data segment stays an i32
+				addrs = []int{2}
+			}
+		case ops.MemoryCopy:
+			dst, src := memoryIndex(), memoryIndex()
+			if dst {
+				addrs = append(addrs, 2)
+			}
+			if src {
+				addrs = append(addrs, 1)
+			}
+			// the size is an i64 only if both memories are 64-bit
+			if dst && src {
+				addrs = append(addrs, 0)
+			}
+		case ops.MemoryFill:
+			if memoryIndex() {
+				addrs = []int{0, 2}
+			}
+		}
+	case ops.SIMDPrefix:
+		if disasm.IsSIMDMemoryOp(op.Sub) && memoryImmediate() {
+			addrs = []int{len(op.Args) - 1}
+		}
+	case ops.AtomicPrefix:
+		if op.Sub != ops.AtomicFence && memoryImmediate() {
+			addrs = []int{len(op.Args) - 1}
+		}
+	}
+	if len(addrs) != 0 {
+		args := make([]wasm.ValueType, len(op.Args))
+		copy(args, op.Args)
+		for _, i := range addrs {
+			args[i] = wasm.ValueTypeI64
+		}
+		op.Args = args
+	}
+	return op
+}
+
+// popOperandType pops an operand off the stack, and checks that it is of
+// type t.
+func (vm *mockVM) popOperandType(t wasm.ValueType) error {
+	op, under := vm.popOperand()
+	if !vm.isPolymorphic() && (under || op.Type != t) {
+		return InvalidTypeError{t, op.Type}
+	}
+	return nil
+}
+
 // setPolymorphic sets the current block as having a polymorphic stack
 // blocks created under it will be polymorphic too. All type-checking
 // is ignored in a polymorhpic stack.
@@ -0,0 +1,93 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"os"
+	"syscall"
+)
+
+// Errno is an error number returned by the functions of the WASI module.
+type Errno uint32
+
+// Error numbers returned by the WASI functions, with the names of the
+// WASI specification.
+const (
+	ErrnoSuccess     Errno = 0
+	Errno2big        Errno = 1
+	ErrnoAcces       Errno = 2
+	ErrnoAgain       Errno = 6
+	ErrnoBadf        Errno = 8
+	ErrnoExist       Errno = 20
+	ErrnoFault       Errno = 21
+	ErrnoInval       Errno = 28
+	ErrnoIo          Errno = 29
+	ErrnoIsdir       Errno = 31
+	ErrnoLoop        Errno = 32
+	ErrnoNametoolong Errno = 37
+	ErrnoNoent       Errno = 44
+	ErrnoNospc       Errno = 51
+	ErrnoNosys       Errno = 52
+	ErrnoNotdir      Errno = 54
+	ErrnoNotempty    Errno = 55
+	ErrnoNotsup      Errno = 58
+	ErrnoPerm        Errno = 63
+	ErrnoRofs        Errno = 69
+	ErrnoSpipe       Errno = 70
+	ErrnoXdev        Errno = 75
+	ErrnoNotcapable  Errno = 76
+)
+
+// fsErrno returns the error number of the error err returned by a FS or a
+// File.
+func fsErrno(err error) Errno {
+	switch e := err.(type) {
+	case nil:
+		return ErrnoSuccess
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	switch err {
+	case syscall.ENOENT:
+		return ErrnoNoent
+	case syscall.EEXIST:
+		return ErrnoExist
+	case syscall.EACCES:
+		return ErrnoAcces
+	case syscall.EPERM:
+		return ErrnoPerm
+	case syscall.EISDIR:
+		return ErrnoIsdir
+	case syscall.ENOTDIR:
+		return ErrnoNotdir
+	case syscall.ENOTEMPTY:
+		return ErrnoNotempty
+	case syscall.EINVAL:
+		return ErrnoInval
+	case syscall.ELOOP:
+		return ErrnoLoop
+	case syscall.ENAMETOOLONG:
+		return ErrnoNametoolong
+	case syscall.ENOSPC:
+		return ErrnoNospc
+	case syscall.EROFS:
+		return ErrnoRofs
+	case syscall.EXDEV:
+		return ErrnoXdev
+	}
+	switch {
+	case os.IsNotExist(err):
+		return ErrnoNoent
+	case os.IsExist(err):
+		return ErrnoExist
+	case os.IsPermission(err):
+		return ErrnoAcces
+	}
+	return ErrnoIo
+}
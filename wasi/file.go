@@ -0,0 +1,653 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/internal/hostio"
+)
+
+// fileDesc is an open file descriptor: a standard stream, a file, or a
+// directory.
+type fileDesc struct {
+	r io.Reader // standard input
+	w io.Writer // standard output or error
+
+	file    File
+	fs      FS
+	path    string // path of the file or of the directory in fs
+	preopen string // name of a preopened directory
+	dir     bool
+	flags   uint16 // fdflags
+
+	dirents []os.FileInfo // entries of a directory read by fd_readdir
+}
+
+// File types.
+const (
+	filetypeUnknown         = 0
+	filetypeCharacterDevice = 2
+	filetypeDirectory       = 3
+	filetypeRegularFile     = 4
+)
+
+// Flags of path_open and of file descriptors.
+const (
+	oflagCreat     = 1 << 0
+	oflagDirectory = 1 << 1
+	oflagExcl      = 1 << 2
+	oflagTrunc     = 1 << 3
+
+	fdflagAppend = 1 << 0
+)
+
+// Rights of file descriptors. All the rights are given to all file
+// descriptors, the ones asked to path_open only select the mode a file is
+// opened in.
+const (
+	rightFdRead            = 1 << 1
+	rightFdWrite           = 1 << 6
+	rightFdAllocate        = 1 << 8
+	rightFdFilestatSetSize = 1 << 22
+	rightsAll              = 1<<29 - 1
+)
+
+// fd returns the file descriptor fd.
+func (s *System) fd(fd uint32) (*fileDesc, Errno) {
+	d, ok := s.fds[fd]
+	if !ok {
+		return nil, ErrnoBadf
+	}
+	return d, ErrnoSuccess
+}
+
+// file returns the file descriptor fd of an open file.
+func (s *System) file(fd uint32) (*fileDesc, Errno) {
+	d, errno := s.fd(fd)
+	switch {
+	case errno != ErrnoSuccess:
+		return nil, errno
+	case d.dir:
+		return nil, ErrnoIsdir
+	case d.file == nil:
+		return nil, ErrnoSpipe
+	}
+	return d, ErrnoSuccess
+}
+
+func (d *fileDesc) reader() io.Reader {
+	if d.file != nil {
+		return d.file
+	}
+	return d.r
+}
+
+func (d *fileDesc) writer() io.Writer {
+	if d.file != nil {
+		return d.file
+	}
+	return d.w
+}
+
+func (d *fileDesc) filetype() uint8 {
+	switch {
+	case d.dir:
+		return filetypeDirectory
+	case d.file != nil:
+		return filetypeRegularFile
+	}
+	return filetypeCharacterDevice
+}
+
+// stat returns a description of the file or directory of d, or nil for a
+// standard stream.
+func (d *fileDesc) stat() (os.FileInfo, error) {
+	switch {
+	case d.file != nil:
+		return d.file.Stat()
+	case d.dir:
+		return d.fs.Stat(d.path)
+	}
+	return nil, nil
+}
+
+// putFilestat writes the filestat structure describing fi, of the given
+// file type, at ptr.
+func (m *memory) putFilestat(ptr uint32, filetype uint8, fi os.FileInfo) {
+	var p [64]byte
+	p[16] = filetype
+	binary.LittleEndian.PutUint64(p[24:], 1) // nlink
+	if fi != nil {
+		mtim := uint64(fi.ModTime().UnixNano())
+		binary.LittleEndian.PutUint64(p[32:], uint64(fi.Size()))
+		binary.LittleEndian.PutUint64(p[40:], mtim)
+		binary.LittleEndian.PutUint64(p[48:], mtim)
+		binary.LittleEndian.PutUint64(p[56:], mtim)
+	}
+	m.Write(ptr, p[:])
+}
+
+func fileInfoType(fi os.FileInfo) uint8 {
+	switch {
+	case fi.IsDir():
+		return filetypeDirectory
+	case fi.Mode().IsRegular():
+		return filetypeRegularFile
+	}
+	return filetypeUnknown
+}
+
+func (s *System) fdAdvise(proc *exec.Process, fd uint32, offset, length uint64, advice uint32) Errno {
+	_, errno := s.fd(fd)
+	return errno
+}
+
+func (s *System) fdAllocate(proc *exec.Process, fd uint32, offset, length uint64) Errno {
+	return ErrnoNotsup
+}
+
+func (s *System) fdClose(proc *exec.Process, fd uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	delete(s.fds, fd)
+	if d.file != nil {
+		return fsErrno(d.file.Close())
+	}
+	return ErrnoSuccess
+}
+
+// fd_sync and fd_datasync flush the files which support it, like *os.File.
+func (s *System) fdSync(proc *exec.Process, fd uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f, ok := d.file.(interface {
+		Sync() error
+	}); ok {
+		return fsErrno(f.Sync())
+	}
+	return ErrnoSuccess
+}
+
+func (s *System) fdFdstatGet(proc *exec.Process, fd, ptr uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	var p [24]byte
+	p[0] = d.filetype()
+	binary.LittleEndian.PutUint16(p[2:], d.flags)
+	binary.LittleEndian.PutUint64(p[8:], rightsAll)
+	binary.LittleEndian.PutUint64(p[16:], rightsAll)
+	m := memory{hostio.Memory{Proc: proc}}
+	m.Write(ptr, p[:])
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) fdFdstatSetFlags(proc *exec.Process, fd, flags uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	d.flags = uint16(flags)
+	return ErrnoSuccess
+}
+
+func (s *System) fdFdstatSetRights(proc *exec.Process, fd uint32, base, inheriting uint64) Errno {
+	_, errno := s.fd(fd)
+	return errno
+}
+
+func (s *System) fdFilestatGet(proc *exec.Process, fd, ptr uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	fi, err := d.stat()
+	if err != nil {
+		return fsErrno(err)
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	m.putFilestat(ptr, d.filetype(), fi)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) fdFilestatSetSize(proc *exec.Process, fd uint32, size uint64) Errno {
+	d, errno := s.file(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	return fsErrno(d.file.Truncate(int64(size)))
+}
+
+func (s *System) fdFilestatSetTimes(proc *exec.Process, fd uint32, atim, mtim uint64, flags uint32) Errno {
+	return ErrnoNosys
+}
+
+// readv reads into the buffers iovs with read, and returns the number of
+// bytes read. Reaching the end of the file isn't an error.
+func (m *memory) readv(iovs []hostio.Iovec, read func(p []byte) (int, error)) (uint32, error) {
+	var total uint32
+	for _, iov := range iovs {
+		p := make([]byte, iov.Len)
+		n, err := read(p)
+		m.Write(iov.Ptr, p[:n])
+		total += uint32(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil || n < len(p) {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// writev writes the buffers iovs with write, and returns the number of
+// bytes written.
+func (m *memory) writev(iovs []hostio.Iovec, write func(p []byte) (int, error)) (uint32, error) {
+	var total uint32
+	for _, iov := range iovs {
+		n, err := write(m.Read(iov.Ptr, iov.Len))
+		total += uint32(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (s *System) fdRead(proc *exec.Process, fd, iovs, iovsLen, nreadPtr uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	r := d.reader()
+	if r == nil || d.dir {
+		return ErrnoBadf
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	bufs := m.Iovecs(iovs, iovsLen)
+	if m.Fault {
+		return ErrnoFault
+	}
+	n, err := m.readv(bufs, r.Read)
+	m.PutUint32(nreadPtr, n)
+	return m.errno(fsErrno(err))
+}
+
+func (s *System) fdPread(proc *exec.Process, fd, iovs, iovsLen uint32, offset uint64, nreadPtr uint32) Errno {
+	d, errno := s.file(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	ra, ok := d.file.(io.ReaderAt)
+	if !ok {
+		return ErrnoNotsup
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	bufs := m.Iovecs(iovs, iovsLen)
+	if m.Fault {
+		return ErrnoFault
+	}
+	off := int64(offset)
+	n, err := m.readv(bufs, func(p []byte) (int, error) {
+		n, err := ra.ReadAt(p, off)
+		off += int64(n)
+		return n, err
+	})
+	m.PutUint32(nreadPtr, n)
+	return m.errno(fsErrno(err))
+}
+
+func (s *System) fdWrite(proc *exec.Process, fd, iovs, iovsLen, nwrittenPtr uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	w := d.writer()
+	if w == nil || d.dir {
+		return ErrnoBadf
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	bufs := m.Iovecs(iovs, iovsLen)
+	if m.Fault {
+		return ErrnoFault
+	}
+	n, err := m.writev(bufs, w.Write)
+	m.PutUint32(nwrittenPtr, n)
+	return m.errno(fsErrno(err))
+}
+
+func (s *System) fdPwrite(proc *exec.Process, fd, iovs, iovsLen uint32, offset uint64, nwrittenPtr uint32) Errno {
+	d, errno := s.file(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	wa, ok := d.file.(io.WriterAt)
+	if !ok {
+		return ErrnoNotsup
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	bufs := m.Iovecs(iovs, iovsLen)
+	if m.Fault {
+		return ErrnoFault
+	}
+	off := int64(offset)
+	n, err := m.writev(bufs, func(p []byte) (int, error) {
+		n, err := wa.WriteAt(p, off)
+		off += int64(n)
+		return n, err
+	})
+	m.PutUint32(nwrittenPtr, n)
+	return m.errno(fsErrno(err))
+}
+
+func (s *System) fdPrestatGet(proc *exec.Process, fd, ptr uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess || d.preopen == "" {
+		return ErrnoBadf
+	}
+	var p [8]byte // the tag 0 of directories, and the length of the name
+	binary.LittleEndian.PutUint32(p[4:], uint32(len(d.preopen)))
+	m := memory{hostio.Memory{Proc: proc}}
+	m.Write(ptr, p[:])
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) fdPrestatDirName(proc *exec.Process, fd, ptr, n uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess || d.preopen == "" {
+		return ErrnoBadf
+	}
+	name := d.preopen
+	if len(name) > int(n) {
+		name = name[:n]
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	m.Write(ptr, []byte(name))
+	return m.errno(ErrnoSuccess)
+}
+
+// fd_readdir writes the entries of a directory from the one numbered
+// cookie. The entries are read again when cookie is 0.
+func (s *System) fdReaddir(proc *exec.Process, fd, buf, bufLen uint32, cookie uint64, bufusedPtr uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if !d.dir {
+		return ErrnoNotdir
+	}
+	if cookie == 0 || d.dirents == nil {
+		f, err := d.fs.OpenFile(d.path, os.O_RDONLY, 0)
+		if err != nil {
+			return fsErrno(err)
+		}
+		d.dirents, err = f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return fsErrno(err)
+		}
+		sort.Slice(d.dirents, func(i, j int) bool {
+			return d.dirents[i].Name() < d.dirents[j].Name()
+		})
+	}
+
+	var p []byte
+	for i := cookie; i < uint64(len(d.dirents)) && len(p) < int(bufLen); i++ {
+		fi := d.dirents[i]
+		var dirent [24]byte
+		binary.LittleEndian.PutUint64(dirent[0:], i+1) // cookie of the next entry
+		binary.LittleEndian.PutUint32(dirent[16:], uint32(len(fi.Name())))
+		dirent[20] = fileInfoType(fi)
+		p = append(p, dirent[:]...)
+		p = append(p, fi.Name()...)
+	}
+	if len(p) > int(bufLen) {
+		// the last entry is truncated, which tells there are more.
+		p = p[:bufLen]
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	m.Write(buf, p)
+	m.PutUint32(bufusedPtr, uint32(len(p)))
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) fdRenumber(proc *exec.Process, fd, to uint32) Errno {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	old, errno := s.fd(to)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if old.file != nil && old != d {
+		old.file.Close()
+	}
+	delete(s.fds, fd)
+	s.fds[to] = d
+	return ErrnoSuccess
+}
+
+func (s *System) fdSeek(proc *exec.Process, fd uint32, offset uint64, whence, newOffsetPtr uint32) Errno {
+	d, errno := s.file(fd)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if whence > io.SeekEnd {
+		return ErrnoInval
+	}
+	off, err := d.file.Seek(int64(offset), int(whence))
+	if err != nil {
+		return fsErrno(err)
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	m.PutUint64(newOffsetPtr, uint64(off))
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) fdTell(proc *exec.Process, fd, offsetPtr uint32) Errno {
+	return s.fdSeek(proc, fd, 0, io.SeekCurrent, offsetPtr)
+}
+
+// resolve returns the directory fd and the path in its file system of the
+// path at ptr, relative to the directory. Paths leading out of the file
+// system aren't allowed.
+func (s *System) resolve(m *memory, fd, ptr, n uint32) (*fileDesc, string, Errno) {
+	d, errno := s.fd(fd)
+	if errno != ErrnoSuccess {
+		return nil, "", errno
+	}
+	if !d.dir {
+		return nil, "", ErrnoNotdir
+	}
+	p := m.Read(ptr, n)
+	if m.Fault {
+		return nil, "", ErrnoFault
+	}
+	name := string(p)
+	switch {
+	case strings.IndexByte(name, 0) >= 0:
+		return nil, "", ErrnoInval
+	case strings.HasPrefix(name, "/"):
+		return nil, "", ErrnoNotcapable
+	}
+	name = path.Join(d.path, name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return nil, "", ErrnoNotcapable
+	}
+	return d, name, ErrnoSuccess
+}
+
+// newFD adds d to the open file descriptors, and returns its number.
+func (s *System) newFD(d *fileDesc) uint32 {
+	fd := uint32(3)
+	for s.fds[fd] != nil {
+		fd++
+	}
+	s.fds[fd] = d
+	return fd
+}
+
+func (s *System) pathOpen(proc *exec.Process, fd, dirflags, ptr, n, oflags uint32, rights, inheriting uint64, fdflags, fdPtr uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, name, errno := s.resolve(&m, fd, ptr, n)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	write := rights&(rightFdWrite|rightFdAllocate|rightFdFilestatSetSize) != 0 || oflags&oflagTrunc != 0
+	d := &fileDesc{fs: dir.fs, path: name, flags: uint16(fdflags)}
+	fi, err := dir.fs.Stat(name)
+	switch {
+	case err == nil && fi.IsDir():
+		if write {
+			return ErrnoIsdir
+		}
+		if oflags&oflagExcl != 0 {
+			return ErrnoExist
+		}
+		d.dir = true
+	case oflags&oflagDirectory != 0:
+		if err != nil {
+			return fsErrno(err)
+		}
+		return ErrnoNotdir
+	default:
+		flag := os.O_RDONLY
+		switch {
+		case write && rights&rightFdRead != 0:
+			flag = os.O_RDWR
+		case write:
+			flag = os.O_WRONLY
+		}
+		if oflags&oflagCreat != 0 {
+			flag |= os.O_CREATE
+		}
+		if oflags&oflagExcl != 0 {
+			flag |= os.O_EXCL
+		}
+		if oflags&oflagTrunc != 0 {
+			flag |= os.O_TRUNC
+		}
+		if fdflags&fdflagAppend != 0 {
+			flag |= os.O_APPEND
+		}
+		if d.file, err = dir.fs.OpenFile(name, flag, 0644); err != nil {
+			return fsErrno(err)
+		}
+	}
+	m.PutUint32(fdPtr, s.newFD(d))
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) pathCreateDirectory(proc *exec.Process, fd, ptr, n uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, name, errno := s.resolve(&m, fd, ptr, n)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	return fsErrno(dir.fs.Mkdir(name, 0755))
+}
+
+func (s *System) pathFilestatGet(proc *exec.Process, fd, flags, ptr, n, statPtr uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, name, errno := s.resolve(&m, fd, ptr, n)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	fi, err := dir.fs.Stat(name)
+	if err != nil {
+		return fsErrno(err)
+	}
+	m.putFilestat(statPtr, fileInfoType(fi), fi)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) pathFilestatSetTimes(proc *exec.Process, fd, flags, ptr, n uint32, atim, mtim uint64, fstFlags uint32) Errno {
+	return ErrnoNosys
+}
+
+func (s *System) pathLink(proc *exec.Process, oldFd, oldFlags, oldPtr, oldLen, newFd, newPtr, newLen uint32) Errno {
+	return ErrnoNosys
+}
+
+// path_readlink fails with ErrnoInval for existing files, as a FS has no
+// symbolic links.
+func (s *System) pathReadlink(proc *exec.Process, fd, ptr, n, buf, bufLen, bufusedPtr uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, name, errno := s.resolve(&m, fd, ptr, n)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if _, err := dir.fs.Stat(name); err != nil {
+		return fsErrno(err)
+	}
+	return ErrnoInval
+}
+
+func (s *System) pathRemoveDirectory(proc *exec.Process, fd, ptr, n uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, name, errno := s.resolve(&m, fd, ptr, n)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	fi, err := dir.fs.Stat(name)
+	switch {
+	case err != nil:
+		return fsErrno(err)
+	case !fi.IsDir():
+		return ErrnoNotdir
+	}
+	return fsErrno(dir.fs.Remove(name))
+}
+
+func (s *System) pathRename(proc *exec.Process, fd, oldPtr, oldLen, newFd, newPtr, newLen uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, oldname, errno := s.resolve(&m, fd, oldPtr, oldLen)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	newDir, newname, errno := s.resolve(&m, newFd, newPtr, newLen)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if dir.fs != newDir.fs {
+		return ErrnoXdev
+	}
+	return fsErrno(dir.fs.Rename(oldname, newname))
+}
+
+func (s *System) pathSymlink(proc *exec.Process, oldPtr, oldLen, fd, newPtr, newLen uint32) Errno {
+	return ErrnoNosys
+}
+
+func (s *System) pathUnlinkFile(proc *exec.Process, fd, ptr, n uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	dir, name, errno := s.resolve(&m, fd, ptr, n)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	fi, err := dir.fs.Stat(name)
+	switch {
+	case err != nil:
+		return fsErrno(err)
+	case fi.IsDir():
+		return ErrnoIsdir
+	}
+	return fsErrno(dir.fs.Remove(name))
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// newProcess returns a process with one page of memory.
+func newProcess(t *testing.T) *exec.Process {
+	t.Helper()
+	m, err := wast.ParseModule(strings.NewReader("(module (memory 1))"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = wasm.ReadModule(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exec.NewProcess(vm)
+}
+
+// fileTester calls the functions of a System, with the paths and the
+// buffers in the memory of a process.
+type fileTester struct {
+	t    *testing.T
+	s    *System
+	proc *exec.Process
+}
+
+const (
+	pathPtr = 0x100
+	bufPtr  = 0x1000
+	resPtr  = 0x10
+)
+
+func (ft *fileTester) path(name string) (uint32, uint32) {
+	ft.proc.WriteAt([]byte(name), pathPtr)
+	return pathPtr, uint32(len(name))
+}
+
+func (ft *fileTester) uint32(ptr uint32) uint32 {
+	var p [4]byte
+	ft.proc.ReadAt(p[:], int64(ptr))
+	return binary.LittleEndian.Uint32(p[:])
+}
+
+func (ft *fileTester) check(what string, got, want Errno) {
+	ft.t.Helper()
+	if got != want {
+		ft.t.Fatalf("%s: errno = %d, want %d", what, got, want)
+	}
+}
+
+func (ft *fileTester) open(dir uint32, name string, oflags uint32, rights uint64, fdflags uint32) (uint32, Errno) {
+	ptr, n := ft.path(name)
+	errno := ft.s.pathOpen(ft.proc, dir, 0, ptr, n, oflags, rights, 0, fdflags, resPtr)
+	return ft.uint32(resPtr), errno
+}
+
+func (ft *fileTester) write(fd uint32, data string) {
+	ft.t.Helper()
+	ft.proc.WriteAt([]byte(data), bufPtr)
+	iov := make([]byte, 8)
+	binary.LittleEndian.PutUint32(iov, bufPtr)
+	binary.LittleEndian.PutUint32(iov[4:], uint32(len(data)))
+	ft.proc.WriteAt(iov, 0x20)
+	ft.check("fd_write", ft.s.fdWrite(ft.proc, fd, 0x20, 1, resPtr), ErrnoSuccess)
+	if n := ft.uint32(resPtr); n != uint32(len(data)) {
+		ft.t.Fatalf("fd_write: wrote %d bytes, want %d", n, len(data))
+	}
+}
+
+func (ft *fileTester) read(fd uint32, n int) string {
+	ft.t.Helper()
+	iov := make([]byte, 8)
+	binary.LittleEndian.PutUint32(iov, bufPtr)
+	binary.LittleEndian.PutUint32(iov[4:], uint32(n))
+	ft.proc.WriteAt(iov, 0x20)
+	ft.check("fd_read", ft.s.fdRead(ft.proc, fd, 0x20, 1, resPtr), ErrnoSuccess)
+	p := make([]byte, ft.uint32(resPtr))
+	ft.proc.ReadAt(p, bufPtr)
+	return string(p)
+}
+
+// readdir returns the names of the entries of the directory fd.
+func (ft *fileTester) readdir(fd uint32) []string {
+	ft.t.Helper()
+	var names []string
+	var cookie uint64
+	for {
+		ft.check("fd_readdir", ft.s.fdReaddir(ft.proc, fd, bufPtr, 64, cookie, resPtr), ErrnoSuccess)
+		used := ft.uint32(resPtr)
+		p := make([]byte, used)
+		ft.proc.ReadAt(p, bufPtr)
+		for len(p) >= 24 {
+			n := int(binary.LittleEndian.Uint32(p[16:]))
+			if len(p) < 24+n {
+				break
+			}
+			cookie = binary.LittleEndian.Uint64(p)
+			names = append(names, string(p[24:24+n]))
+			p = p[24+n:]
+		}
+		if used < 64 {
+			return names
+		}
+	}
+}
+
+func testFiles(t *testing.T, fsys FS) {
+	ft := &fileTester{t: t, s: New(Preopen("/", fsys)), proc: newProcess(t)}
+	const (
+		rdonly = rightFdRead
+		rdwr   = rightFdRead | rightFdWrite
+	)
+
+	_, errno := ft.open(3, "a.txt", 0, rdonly, 0)
+	ft.check("open missing file", errno, ErrnoNoent)
+	fd, errno := ft.open(3, "a.txt", oflagCreat, rdwr, 0)
+	ft.check("create file", errno, ErrnoSuccess)
+	ft.write(fd, "hello")
+	ft.check("fd_seek", ft.s.fdSeek(ft.proc, fd, 1, 0, resPtr), ErrnoSuccess)
+	if got, want := ft.read(fd, 10), "ello"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	ft.check("fd_close", ft.s.fdClose(ft.proc, fd), ErrnoSuccess)
+	ft.check("fd_close closed", ft.s.fdClose(ft.proc, fd), ErrnoBadf)
+
+	_, errno = ft.open(3, "a.txt", oflagCreat|oflagExcl, rdwr, 0)
+	ft.check("create existing file", errno, ErrnoExist)
+	fd, errno = ft.open(3, "a.txt", 0, rdwr, fdflagAppend)
+	ft.check("open for append", errno, ErrnoSuccess)
+	ft.write(fd, ", world")
+	ft.s.fdClose(ft.proc, fd)
+
+	ptr, n := ft.path("sub")
+	ft.check("path_create_directory", ft.s.pathCreateDirectory(ft.proc, 3, ptr, n), ErrnoSuccess)
+	ft.check("path_create_directory existing", ft.s.pathCreateDirectory(ft.proc, 3, ptr, n), ErrnoExist)
+	sub, errno := ft.open(3, "sub", oflagDirectory, rdonly, 0)
+	ft.check("open directory", errno, ErrnoSuccess)
+	_, errno = ft.open(3, "sub", 0, rdwr, 0)
+	ft.check("open directory for writing", errno, ErrnoIsdir)
+	_, errno = ft.open(3, "a.txt", oflagDirectory, rdonly, 0)
+	ft.check("open file as directory", errno, ErrnoNotdir)
+
+	// paths are relative to the directory, and can't leave the preopened one.
+	_, errno = ft.open(sub, "../a.txt", 0, rdonly, 0)
+	ft.check("open in parent", errno, ErrnoSuccess)
+	_, errno = ft.open(sub, "../../a.txt", 0, rdonly, 0)
+	ft.check("open outside", errno, ErrnoNotcapable)
+	_, errno = ft.open(sub, "/a.txt", 0, rdonly, 0)
+	ft.check("open absolute path", errno, ErrnoNotcapable)
+
+	for i, name := range []string{"c", "b", "a", "long-name-of-a-file"} {
+		fd, errno := ft.open(sub, name, oflagCreat, rdwr, 0)
+		ft.check("create "+name, errno, ErrnoSuccess)
+		ft.write(fd, strings.Repeat("x", i))
+		ft.s.fdClose(ft.proc, fd)
+	}
+	if got, want := strings.Join(ft.readdir(sub), " "), "a b c long-name-of-a-file"; got != want {
+		t.Errorf("readdir = %q, want %q", got, want)
+	}
+
+	oldPtr, oldLen := uint32(0x200), uint32(len("a.txt"))
+	ft.proc.WriteAt([]byte("a.txt"), int64(oldPtr))
+	ptr, n = ft.path("sub/d.txt")
+	ft.check("path_rename", ft.s.pathRename(ft.proc, 3, oldPtr, oldLen, 3, ptr, n), ErrnoSuccess)
+	fd, errno = ft.open(sub, "d.txt", 0, rdonly, 0)
+	ft.check("open renamed file", errno, ErrnoSuccess)
+	if got, want := ft.read(fd, 20), "hello, world"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	ft.check("fd_filestat_get", ft.s.fdFilestatGet(ft.proc, fd, bufPtr), ErrnoSuccess)
+	var stat [64]byte
+	ft.proc.ReadAt(stat[:], bufPtr)
+	if typ, size := stat[16], binary.LittleEndian.Uint64(stat[32:]); typ != filetypeRegularFile || size != 12 {
+		t.Errorf("filestat: type %d, size %d, want %d, 12", typ, size, filetypeRegularFile)
+	}
+
+	ptr, n = ft.path("sub")
+	ft.check("path_unlink_file directory", ft.s.pathUnlinkFile(ft.proc, 3, ptr, n), ErrnoIsdir)
+	ft.check("path_remove_directory not empty", ft.s.pathRemoveDirectory(ft.proc, 3, ptr, n), ErrnoNotempty)
+	for _, name := range []string{"a", "b", "c", "d.txt", "long-name-of-a-file"} {
+		ptr, n := ft.path(name)
+		ft.check("path_unlink_file "+name, ft.s.pathUnlinkFile(ft.proc, sub, ptr, n), ErrnoSuccess)
+	}
+	ptr, n = ft.path("sub")
+	ft.check("path_remove_directory", ft.s.pathRemoveDirectory(ft.proc, 3, ptr, n), ErrnoSuccess)
+	if got := ft.readdir(3); len(got) != 0 {
+		t.Errorf("readdir = %q, want none", got)
+	}
+}
+
+func TestMemFSFiles(t *testing.T) {
+	testFiles(t, NewMemFS())
+}
+
+func TestDirFSFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wagon-wasi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testFiles(t, DirFS(root))
+}
+
+func TestFault(t *testing.T) {
+	proc := newProcess(t)
+	s := New()
+	size := uint32(proc.MemSize())
+	for _, tc := range []struct {
+		name  string
+		errno Errno
+	}{
+		{"args_sizes_get", s.argsSizesGet(proc, size-2, 0)},
+		{"fd_write", s.fdWrite(proc, 1, size-4, 1, 0)},
+		{"fd_fdstat_get", s.fdFdstatGet(proc, 1, size-8)},
+		{"random_get", s.randomGet(proc, size, 1)},
+	} {
+		if tc.errno != ErrnoFault {
+			t.Errorf("%s: errno = %d, want %d", tc.name, tc.errno, ErrnoFault)
+		}
+	}
+}
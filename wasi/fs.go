@@ -0,0 +1,94 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FS is a file system whose directories can be given to a module with
+// Preopen.
+//
+// Names are slash-separated paths relative to the root of the file
+// system, which are cleaned and never refer to the parent of the root:
+// the System resolves the paths given by the module before calling the
+// methods of FS. The root itself is named ".".
+type FS interface {
+	// OpenFile opens the named file or directory with the flags and the
+	// permissions of os.OpenFile.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	// Stat returns a description of the named file.
+	Stat(name string) (os.FileInfo, error)
+
+	// Mkdir creates a directory.
+	Mkdir(name string, perm os.FileMode) error
+
+	// Remove removes a file or an empty directory.
+	Remove(name string) error
+
+	// Rename renames a file or a directory, replacing newname if it
+	// exists and isn't a directory.
+	Rename(oldname, newname string) error
+}
+
+// File is an open file of a FS. *os.File implements File.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+
+	// Stat returns a description of the file.
+	Stat() (os.FileInfo, error)
+
+	// Readdir reads the content of a directory as os.File.Readdir.
+	Readdir(n int) ([]os.FileInfo, error)
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+}
+
+// DirFS returns a FS giving access to the files of the directory dir of
+// the host file system.
+//
+// The paths used by the module are confined to dir, but symbolic links
+// found in dir are followed by the host and may lead outside of it.
+func DirFS(dir string) FS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) path(name string) string {
+	return filepath.Join(string(dir), filepath.FromSlash(name))
+}
+
+func (dir dirFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(dir.path(name), flag, perm)
+	if err != nil {
+		// avoid returning a non-nil File holding a nil *os.File.
+		return nil, err
+	}
+	return f, nil
+}
+
+func (dir dirFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(dir.path(name))
+}
+
+func (dir dirFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(dir.path(name), perm)
+}
+
+func (dir dirFS) Remove(name string) error {
+	return os.Remove(dir.path(name))
+}
+
+func (dir dirFS) Rename(oldname, newname string) error {
+	return os.Rename(dir.path(oldname), dir.path(newname))
+}
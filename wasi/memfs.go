@@ -0,0 +1,451 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is a file system held in memory, which gives files to a module
+// without giving it access to the file system of the host.
+type MemFS struct {
+	mu   sync.Mutex
+	root *memNode
+}
+
+type memNode struct {
+	name     string
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]*memNode // entries of a directory
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{root: newMemDir(".", 0755)}
+}
+
+func newMemDir(name string, perm os.FileMode) *memNode {
+	return &memNode{
+		name:     name,
+		mode:     os.ModeDir | perm&os.ModePerm,
+		modTime:  time.Now(),
+		children: make(map[string]*memNode),
+	}
+}
+
+func (n *memNode) isDir() bool {
+	return n.mode.IsDir()
+}
+
+// lookup returns the node of the file name.
+func (fs *MemFS) lookup(op, name string) (*memNode, error) {
+	n := fs.root
+	name = path.Clean(name)
+	if name == "." {
+		return n, nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if !n.isDir() {
+			return nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+		child, ok := n.children[elem]
+		if !ok {
+			return nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// parent returns the node of the directory holding the file name, and the
+// last element of name.
+func (fs *MemFS) parent(op, name string) (*memNode, string, error) {
+	name = path.Clean(name)
+	if name == "." {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: syscall.EINVAL}
+	}
+	dir, err := fs.lookup(op, path.Dir(name))
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.isDir() {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return dir, path.Base(name), nil
+}
+
+// WriteFile writes data to the named file, creating it and its parent
+// directories if needed.
+func (fs *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if err := fs.MkdirAll(path.Dir(path.Clean(name)), 0755); err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile returns the content of the named file.
+func (fs *MemFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// MkdirAll creates the named directory and its parents if needed.
+func (fs *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := fs.root
+	name = path.Clean(name)
+	if name == "." {
+		return nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		child, ok := n.children[elem]
+		if !ok {
+			child = newMemDir(elem, perm)
+			n.children[elem] = child
+		}
+		if !child.isDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		n = child
+	}
+	return nil
+}
+
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup("open", name)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		dir, base, err := fs.parent("open", name)
+		if err != nil {
+			return nil, err
+		}
+		n = &memNode{name: base, mode: perm & os.ModePerm, modTime: time.Now()}
+		dir.children[base] = n
+	case err != nil:
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if n.isDir() && writable {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	if flag&os.O_TRUNC != 0 && writable {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return &memFile{fs: fs, node: n, flag: flag}, nil
+}
+
+func (fs *MemFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+func (fs *MemFS) Mkdir(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, base, err := fs.parent("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, ok := dir.children[base]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EEXIST}
+	}
+	dir.children[base] = newMemDir(base, perm)
+	return nil
+}
+
+func (fs *MemFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, base, err := fs.parent("remove", name)
+	if err != nil {
+		return err
+	}
+	n, ok := dir.children[base]
+	switch {
+	case !ok:
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOENT}
+	case n.isDir() && len(n.children) != 0:
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(dir.children, base)
+	return nil
+}
+
+func (fs *MemFS) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	odir, obase, err := fs.parent("rename", oldname)
+	if err != nil {
+		return err
+	}
+	ndir, nbase, err := fs.parent("rename", newname)
+	if err != nil {
+		return err
+	}
+	n, ok := odir.children[obase]
+	if !ok {
+		return linkErr(syscall.ENOENT)
+	}
+	if n.isDir() && strings.HasPrefix(path.Clean(newname)+"/", path.Clean(oldname)+"/") {
+		// a directory can't be moved into itself.
+		return linkErr(syscall.EINVAL)
+	}
+	if old, ok := ndir.children[nbase]; ok && old != n {
+		switch {
+		case old.isDir() && !n.isDir():
+			return linkErr(syscall.EISDIR)
+		case !old.isDir() && n.isDir():
+			return linkErr(syscall.ENOTDIR)
+		case old.isDir() && len(old.children) != 0:
+			return linkErr(syscall.ENOTEMPTY)
+		}
+	}
+	delete(odir.children, obase)
+	n.name = nbase
+	ndir.children[nbase] = n
+	return nil
+}
+
+func (n *memNode) info() os.FileInfo {
+	return memInfo{name: n.name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memInfo) Name() string       { return fi.name }
+func (fi memInfo) Size() int64        { return fi.size }
+func (fi memInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memInfo) ModTime() time.Time { return fi.modTime }
+func (fi memInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memInfo) Sys() interface{}   { return nil }
+
+// memFile is an open file of a MemFS.
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	flag   int
+	off    int64
+	dirPos int // number of directory entries already read
+	closed bool
+}
+
+func (f *memFile) check(op string, write bool) error {
+	var err error
+	switch {
+	case f.closed:
+		err = os.ErrClosed
+	case f.node.isDir():
+		err = syscall.EISDIR
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		err = syscall.EBADF
+	case !write && f.flag&os.O_WRONLY != 0:
+		err = syscall.EBADF
+	default:
+		return nil
+	}
+	return &os.PathError{Op: op, Path: f.node.name, Err: err}
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	n, err := f.readAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		f.off = int64(len(f.node.data))
+	}
+	n, err := f.writeAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+func (f *memFile) writeAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.resize(end)
+	}
+	copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+// resize changes the size of the data of a file, padding it with zeros.
+func (n *memNode) resize(size int64) {
+	if size <= int64(cap(n.data)) {
+		old := len(n.data)
+		n.data = n.data[:size]
+		for i := old; i < len(n.data); i++ {
+			n.data[i] = 0
+		}
+		return
+	}
+	data := make([]byte, size, 2*size)
+	copy(data, n.data)
+	n.data = data
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.node.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.node.info(), nil
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	if !f.node.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.node.name, Err: syscall.ENOTDIR}
+	}
+	names := make([]string, 0, len(f.node.children))
+	for name := range f.node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if f.dirPos > len(names) {
+		f.dirPos = len(names)
+	}
+	names = names[f.dirPos:]
+	if count > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if len(names) > count {
+			names = names[:count]
+		}
+	}
+	f.dirPos += len(names)
+	infos := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		infos[i] = f.node.children[name].info()
+	}
+	return infos, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.node.name, Err: syscall.EINVAL}
+	}
+	f.node.resize(size)
+	f.node.modTime = time.Now()
+	return nil
+}
@@ -0,0 +1,460 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasi implements the wasi_snapshot_preview1 module of the
+// WebAssembly System Interface, which modules compiled for WASI by clang,
+// Rust or TinyGo import to get their arguments and environment, the time,
+// random numbers, the standard streams and files.
+//
+// A System holds the state of the module for one instance: its Module
+// method returns the host module to give to wasm.ReadModule.
+//
+//	sys := wasi.New(wasi.Args("prog"), wasi.Stdout(os.Stdout))
+//	m, err := wasm.ReadModule(r, func(name string) (*wasm.Module, error) {
+//		if name == wasi.ModuleName {
+//			return sys.Module(), nil
+//		}
+//		return nil, fmt.Errorf("unknown module %q", name)
+//	})
+//
+// Files are only reachable through the directories given with Preopen,
+// which are backed by a FS: DirFS gives access to a directory of the host,
+// and MemFS keeps the files in memory.
+package wasi
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/internal/hostio"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ModuleName is the name of the module imported by WASI programs.
+const ModuleName = "wasi_snapshot_preview1"
+
+// Option configures a System created by New.
+type Option func(s *System)
+
+// Args sets the command-line arguments of the program, starting with its
+// name.
+func Args(args ...string) Option {
+	return func(s *System) {
+		s.args = args
+	}
+}
+
+// Environ sets the environment variables of the program, in the form
+// "key=value".
+func Environ(env ...string) Option {
+	return func(s *System) {
+		s.env = env
+	}
+}
+
+// stdio wraps an option of the standard streams or of the clock.
+func stdio(opt hostio.Option) Option {
+	return func(s *System) {
+		opt(&s.stdio)
+	}
+}
+
+// Stdin sets the standard input of the program, which is empty by default.
+func Stdin(r io.Reader) Option {
+	return stdio(hostio.Stdin(r))
+}
+
+// Stdout sets the standard output of the program, which is discarded by
+// default.
+func Stdout(w io.Writer) Option {
+	return stdio(hostio.Stdout(w))
+}
+
+// Stderr sets the standard error of the program, which is discarded by
+// default.
+func Stderr(w io.Writer) Option {
+	return stdio(hostio.Stderr(w))
+}
+
+// Preopen gives the program access to the root of the file system fsys,
+// as the directory name, such as "/" or "/tmp". Preopened directories get
+// file descriptors from 3, in the order of the options.
+func Preopen(name string, fsys FS) Option {
+	return func(s *System) {
+		s.fds[uint32(len(s.fds))] = &fileDesc{fs: fsys, path: ".", preopen: name, dir: true}
+	}
+}
+
+// Clock sets the function returning the current time, which is time.Now
+// by default. The monotonic clocks count from the creation of the System.
+func Clock(now func() time.Time) Option {
+	return stdio(hostio.Clock(now))
+}
+
+// Random sets the source of the random bytes returned by random_get,
+// which is crypto/rand.Reader by default.
+func Random(r io.Reader) Option {
+	return func(s *System) {
+		s.random = r
+	}
+}
+
+// System holds the state of an instance of the WASI module: the arguments
+// and the environment of the program, its open files, and its exit code.
+type System struct {
+	args   []string
+	env    []string
+	stdio  hostio.Stdio
+	start  time.Time
+	random io.Reader
+	fds    map[uint32]*fileDesc
+
+	exited bool
+	code   uint32
+}
+
+// New returns a System configured by the options opts.
+func New(opts ...Option) *System {
+	s := &System{
+		stdio:  hostio.DefaultStdio(),
+		random: rand.Reader,
+		fds:    map[uint32]*fileDesc{0: {}, 1: {}, 2: {}},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.fds[0].r = s.stdio.Stdin
+	s.fds[1].w = s.stdio.Stdout
+	s.fds[2].w = s.stdio.Stderr
+	s.start = s.stdio.Now()
+	return s
+}
+
+// ExitCode returns the exit code given by the program to proc_exit, and
+// whether it has called it.
+func (s *System) ExitCode() (code uint32, exited bool) {
+	return s.code, s.exited
+}
+
+// Module returns the host module of s, to be returned by the importer
+// given to wasm.ReadModule for ModuleName. The functions which aren't
+// supported return ErrnoNosys.
+func (s *System) Module() *wasm.Module {
+	fns := []struct {
+		name string
+		fn   interface{}
+	}{
+		{"args_get", s.argsGet},
+		{"args_sizes_get", s.argsSizesGet},
+		{"environ_get", s.environGet},
+		{"environ_sizes_get", s.environSizesGet},
+		{"clock_res_get", s.clockResGet},
+		{"clock_time_get", s.clockTimeGet},
+		{"fd_advise", s.fdAdvise},
+		{"fd_allocate", s.fdAllocate},
+		{"fd_close", s.fdClose},
+		{"fd_datasync", s.fdSync},
+		{"fd_fdstat_get", s.fdFdstatGet},
+		{"fd_fdstat_set_flags", s.fdFdstatSetFlags},
+		{"fd_fdstat_set_rights", s.fdFdstatSetRights},
+		{"fd_filestat_get", s.fdFilestatGet},
+		{"fd_filestat_set_size", s.fdFilestatSetSize},
+		{"fd_filestat_set_times", s.fdFilestatSetTimes},
+		{"fd_pread", s.fdPread},
+		{"fd_prestat_get", s.fdPrestatGet},
+		{"fd_prestat_dir_name", s.fdPrestatDirName},
+		{"fd_pwrite", s.fdPwrite},
+		{"fd_read", s.fdRead},
+		{"fd_readdir", s.fdReaddir},
+		{"fd_renumber", s.fdRenumber},
+		{"fd_seek", s.fdSeek},
+		{"fd_sync", s.fdSync},
+		{"fd_tell", s.fdTell},
+		{"fd_write", s.fdWrite},
+		{"path_create_directory", s.pathCreateDirectory},
+		{"path_filestat_get", s.pathFilestatGet},
+		{"path_filestat_set_times", s.pathFilestatSetTimes},
+		{"path_link", s.pathLink},
+		{"path_open", s.pathOpen},
+		{"path_readlink", s.pathReadlink},
+		{"path_remove_directory", s.pathRemoveDirectory},
+		{"path_rename", s.pathRename},
+		{"path_symlink", s.pathSymlink},
+		{"path_unlink_file", s.pathUnlinkFile},
+		{"poll_oneoff", s.pollOneoff},
+		{"proc_exit", s.procExit},
+		{"proc_raise", s.procRaise},
+		{"random_get", s.randomGet},
+		{"sched_yield", s.schedYield},
+		{"sock_accept", s.sockAccept},
+		{"sock_recv", s.sockRecv},
+		{"sock_send", s.sockSend},
+		{"sock_shutdown", s.sockShutdown},
+	}
+
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Export.Entries = make(map[string]wasm.ExportEntry, len(fns))
+	m.Types.Entries = make([]wasm.FunctionSig, len(fns))
+	for i, fn := range fns {
+		v := reflect.ValueOf(fn.fn)
+		m.Types.Entries[i] = signature(v.Type())
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+			Sig:  &m.Types.Entries[i],
+			Host: v,
+			Body: &wasm.FunctionBody{},
+		})
+		m.Export.Entries[fn.name] = wasm.ExportEntry{FieldStr: fn.name, Kind: wasm.ExternalFunction, Index: uint32(i)}
+	}
+	return m
+}
+
+// signature returns the signature of a host function of type t, whose
+// first parameter is the *exec.Process.
+func signature(t reflect.Type) wasm.FunctionSig {
+	valueType := func(t reflect.Type) wasm.ValueType {
+		switch t.Kind() {
+		case reflect.Int32, reflect.Uint32:
+			return wasm.ValueTypeI32
+		case reflect.Int64, reflect.Uint64:
+			return wasm.ValueTypeI64
+		}
+		panic(fmt.Sprintf("wasi: unsupported type %v", t))
+	}
+	sig := wasm.FunctionSig{Form: -0x20}
+	for i := 1; i < t.NumIn(); i++ {
+		sig.ParamTypes = append(sig.ParamTypes, valueType(t.In(i)))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		sig.ReturnTypes = append(sig.ReturnTypes, valueType(t.Out(i)))
+	}
+	return sig
+}
+
+// memory gives the host functions access to the linear memory of the
+// module.
+type memory struct {
+	hostio.Memory
+}
+
+// errno returns ErrnoFault if an access was out of bounds, and e
+// otherwise.
+func (m *memory) errno(e Errno) Errno {
+	if m.Fault {
+		return ErrnoFault
+	}
+	return e
+}
+
+// putStrings writes the strings strs, terminated by a NUL byte, to buf,
+// and their addresses to the array at ptrs.
+func (m *memory) putStrings(strs []string, ptrs, buf uint32) {
+	for i, str := range strs {
+		m.PutUint32(ptrs+4*uint32(i), buf)
+		m.Write(buf, append([]byte(str), 0))
+		buf += uint32(len(str)) + 1
+	}
+}
+
+// putSizes writes the number of strings of strs, and the size of the
+// buffer holding them.
+func (m *memory) putSizes(strs []string, countPtr, sizePtr uint32) {
+	size := 0
+	for _, str := range strs {
+		size += len(str) + 1
+	}
+	m.PutUint32(countPtr, uint32(len(strs)))
+	m.PutUint32(sizePtr, uint32(size))
+}
+
+func (s *System) argsGet(proc *exec.Process, argv, buf uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	m.putStrings(s.args, argv, buf)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) argsSizesGet(proc *exec.Process, countPtr, sizePtr uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	m.putSizes(s.args, countPtr, sizePtr)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) environGet(proc *exec.Process, environ, buf uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	m.putStrings(s.env, environ, buf)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) environSizesGet(proc *exec.Process, countPtr, sizePtr uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	m.putSizes(s.env, countPtr, sizePtr)
+	return m.errno(ErrnoSuccess)
+}
+
+// Clock identifiers.
+const (
+	clockRealtime = iota
+	clockMonotonic
+	clockProcessCPUTime
+	clockThreadCPUTime
+)
+
+// time returns the time of the clock id in nanoseconds.
+func (s *System) time(id uint32) (uint64, Errno) {
+	switch id {
+	case clockRealtime:
+		return uint64(s.stdio.Now().UnixNano()), ErrnoSuccess
+	case clockMonotonic, clockProcessCPUTime, clockThreadCPUTime:
+		return uint64(s.stdio.Now().Sub(s.start)), ErrnoSuccess
+	}
+	return 0, ErrnoInval
+}
+
+func (s *System) clockResGet(proc *exec.Process, id, resPtr uint32) Errno {
+	if id > clockThreadCPUTime {
+		return ErrnoInval
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	m.PutUint64(resPtr, 1)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) clockTimeGet(proc *exec.Process, id uint32, precision uint64, timePtr uint32) Errno {
+	t, errno := s.time(id)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	m.PutUint64(timePtr, t)
+	return m.errno(ErrnoSuccess)
+}
+
+func (s *System) randomGet(proc *exec.Process, buf, n uint32) Errno {
+	m := memory{hostio.Memory{Proc: proc}}
+	if !m.Check(buf, uint64(n)) {
+		return ErrnoFault
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(s.random, p); err != nil {
+		return ErrnoIo
+	}
+	m.Write(buf, p)
+	return m.errno(ErrnoSuccess)
+}
+
+// Types of the events of poll_oneoff.
+const (
+	eventClock = iota
+	eventFdRead
+	eventFdWrite
+)
+
+// poll_oneoff waits for the clock subscriptions with the earliest timeout.
+// Subscriptions to file descriptors are reported immediately, as reading
+// or writing them doesn't block.
+func (s *System) pollOneoff(proc *exec.Process, in, out, n, neventsPtr uint32) Errno {
+	if n == 0 {
+		return ErrnoInval
+	}
+	m := memory{hostio.Memory{Proc: proc}}
+	if !m.Check(in, 48*uint64(n)) || !m.Check(out, 32*uint64(n)) {
+		return ErrnoFault
+	}
+	type event struct {
+		userdata uint64
+		errno    Errno
+		typ      uint8
+	}
+	var fdEvents, clockEvents []event
+	var timeouts []uint64
+	var wait uint64
+	for i := uint32(0); i < n; i++ {
+		sub := m.Read(in+48*i, 48)
+		e := event{userdata: binary.LittleEndian.Uint64(sub), typ: sub[8]}
+		switch e.typ {
+		case eventClock:
+			id := binary.LittleEndian.Uint32(sub[16:])
+			timeout := binary.LittleEndian.Uint64(sub[24:])
+			if flags := binary.LittleEndian.Uint16(sub[40:]); flags&1 != 0 {
+				// the timeout is an absolute time.
+				now, errno := s.time(id)
+				e.errno = errno
+				if timeout > now {
+					timeout -= now
+				} else {
+					timeout = 0
+				}
+			}
+			if len(clockEvents) == 0 || timeout < wait {
+				wait = timeout
+			}
+			clockEvents = append(clockEvents, e)
+			timeouts = append(timeouts, timeout)
+		case eventFdRead, eventFdWrite:
+			if _, ok := s.fds[binary.LittleEndian.Uint32(sub[16:])]; !ok {
+				e.errno = ErrnoBadf
+			}
+			fdEvents = append(fdEvents, e)
+		default:
+			return ErrnoInval
+		}
+	}
+	events := fdEvents
+	if len(events) == 0 {
+		time.Sleep(time.Duration(wait))
+		for i, e := range clockEvents {
+			if timeouts[i] <= wait {
+				events = append(events, e)
+			}
+		}
+	}
+	for i, e := range events {
+		var p [32]byte
+		binary.LittleEndian.PutUint64(p[0:], e.userdata)
+		binary.LittleEndian.PutUint16(p[8:], uint16(e.errno))
+		p[10] = e.typ
+		m.Write(out+32*uint32(i), p[:])
+	}
+	m.PutUint32(neventsPtr, uint32(len(events)))
+	return m.errno(ErrnoSuccess)
+}
+
+// proc_exit terminates the execution of the module. The exit code is
+// returned by ExitCode.
+func (s *System) procExit(proc *exec.Process, code uint32) {
+	s.exited = true
+	s.code = code
+	proc.Terminate()
+}
+
+func (s *System) procRaise(proc *exec.Process, sig uint32) Errno {
+	return ErrnoNosys
+}
+
+func (s *System) schedYield(proc *exec.Process) Errno {
+	runtime.Gosched()
+	return ErrnoSuccess
+}
+
+func (s *System) sockAccept(proc *exec.Process, fd, flags, fdPtr uint32) Errno {
+	return ErrnoNosys
+}
+
+func (s *System) sockRecv(proc *exec.Process, fd, iovs, iovsLen, flags, nreadPtr, flagsPtr uint32) Errno {
+	return ErrnoNosys
+}
+
+func (s *System) sockSend(proc *exec.Process, fd, iovs, iovsLen, flags, nwrittenPtr uint32) Errno {
+	return ErrnoNosys
+}
+
+func (s *System) sockShutdown(proc *exec.Process, fd, how uint32) Errno {
+	return ErrnoNosys
+}
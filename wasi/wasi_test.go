@@ -0,0 +1,201 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasi"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// run runs the function _start of the module src, in the text format,
+// with the WASI module of sys, and returns the VM.
+func run(t *testing.T, sys *wasi.System, src string) *exec.VM {
+	t.Helper()
+	m, err := wast.ParseModule(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.ReadModule(buf, func(name string) (*wasm.Module, error) {
+		if name == wasi.ModuleName {
+			return sys.Module(), nil
+		}
+		return nil, fmt.Errorf("unknown module %q", name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.RecoverPanic = true
+	if _, err := vm.ExecCode(int64(m.Export.Entries["_start"].Index)); err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestHello(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	sys := wasi.New(wasi.Stdout(stdout))
+	run(t, sys, `
+(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 16) "hello, world\n")
+  (func (export "_start")
+    (i32.store (i32.const 0) (i32.const 16))
+    (i32.store (i32.const 4) (i32.const 13))
+    (call $proc_exit (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8)))
+    unreachable))
+`)
+	if got, want := stdout.String(), "hello, world\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if code, exited := sys.ExitCode(); !exited || code != 0 {
+		t.Errorf("exit code = %d, %v, want 0, true", code, exited)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	sys := wasi.New()
+	run(t, sys, `
+(module
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (func (export "_start")
+    (call $proc_exit (i32.const 3))
+    unreachable))
+`)
+	if code, exited := sys.ExitCode(); !exited || code != 3 {
+		t.Errorf("exit code = %d, %v, want 3, true", code, exited)
+	}
+
+	sys = wasi.New()
+	run(t, sys, `(module (func (export "_start")))`)
+	if code, exited := sys.ExitCode(); exited {
+		t.Errorf("exit code = %d, %v, want 0, false", code, exited)
+	}
+}
+
+func TestArgsEnviron(t *testing.T) {
+	sys := wasi.New(wasi.Args("prog", "a", "bc"), wasi.Environ("K=V"))
+	vm := run(t, sys, `
+(module
+  (import "wasi_snapshot_preview1" "args_sizes_get" (func $args_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "args_get" (func $args_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_get" (func $environ_get (param i32 i32) (result i32)))
+  (memory 1)
+  (func (export "_start")
+    (drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+    (drop (call $args_get (i32.const 8) (i32.const 32)))
+    (drop (call $environ_sizes_get (i32.const 64) (i32.const 68)))
+    (drop (call $environ_get (i32.const 72) (i32.const 80)))))
+`)
+	mem := vm.Memory()
+	for _, tc := range []struct {
+		off  int
+		want []byte
+	}{
+		{0, []byte{3, 0, 0, 0, 10, 0, 0, 0}},
+		{8, []byte{32, 0, 0, 0, 37, 0, 0, 0, 39, 0, 0, 0}},
+		{32, []byte("prog\x00a\x00bc\x00")},
+		{64, []byte{1, 0, 0, 0, 4, 0, 0, 0}},
+		{72, []byte{80, 0, 0, 0}},
+		{80, []byte("K=V\x00")},
+	} {
+		if got := mem[tc.off : tc.off+len(tc.want)]; !bytes.Equal(got, tc.want) {
+			t.Errorf("memory at %d = %v, want %v", tc.off, got, tc.want)
+		}
+	}
+}
+
+func TestClockRandom(t *testing.T) {
+	now := time.Unix(1, 500)
+	sys := wasi.New(
+		wasi.Clock(func() time.Time { return now }),
+		wasi.Random(strings.NewReader("0123456789")),
+	)
+	vm := run(t, sys, `
+(module
+  (import "wasi_snapshot_preview1" "clock_time_get" (func $clock_time_get (param i32 i64 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "random_get" (func $random_get (param i32 i32) (result i32)))
+  (memory 1)
+  (func (export "_start")
+    (drop (call $clock_time_get (i32.const 0) (i64.const 1) (i32.const 0)))
+    (drop (call $clock_time_get (i32.const 1) (i64.const 1) (i32.const 8)))
+    (i32.store (i32.const 16) (call $clock_time_get (i32.const 9) (i64.const 1) (i32.const 8)))
+    (drop (call $random_get (i32.const 20) (i32.const 4)))
+    (i32.store (i32.const 24) (call $random_get (i32.const 0xfffe) (i32.const 4)))))
+`)
+	mem := vm.Memory()
+	want := []byte{
+		0xf4, 0xcb, 0x9a, 0x3b, 0, 0, 0, 0, // realtime: 1000000500
+		0, 0, 0, 0, 0, 0, 0, 0, // monotonic
+		byte(wasi.ErrnoInval), 0, 0, 0,
+		'0', '1', '2', '3',
+		byte(wasi.ErrnoFault), 0, 0, 0,
+	}
+	if got := mem[:len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("memory = %v, want %v", got, want)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	fsys := wasi.NewMemFS()
+	if err := fsys.WriteFile("dir/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout := new(bytes.Buffer)
+	sys := wasi.New(wasi.Stdout(stdout), wasi.Preopen("/", fsys))
+	run(t, sys, `
+(module
+  (import "wasi_snapshot_preview1" "fd_prestat_get" (func $fd_prestat_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (memory 1)
+  (data (i32.const 100) "dir/file.txt")
+  (data (i32.const 120) "../dir/file.txt")
+  (func $check (param i32)
+    (if (local.get 0) (then (call $proc_exit (local.get 0)) unreachable)))
+  (func (export "_start")
+    ;; fd 3 is the preopened directory.
+    (call $check (call $fd_prestat_get (i32.const 3) (i32.const 0)))
+    (call $check (i32.sub (i32.load (i32.const 4)) (i32.const 1)))
+    ;; escaping the directory is not allowed.
+    (call $check (i32.sub
+      (call $path_open (i32.const 3) (i32.const 0) (i32.const 120) (i32.const 15)
+        (i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 8))
+      (i32.const 76)))
+    (call $check (call $path_open (i32.const 3) (i32.const 0) (i32.const 100) (i32.const 12)
+      (i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 8)))
+    (i32.store (i32.const 16) (i32.const 200))
+    (i32.store (i32.const 20) (i32.const 100))
+    (call $check (call $fd_read (i32.load (i32.const 8)) (i32.const 16) (i32.const 1) (i32.const 24)))
+    (i32.store (i32.const 20) (i32.load (i32.const 24)))
+    (call $check (call $fd_write (i32.const 1) (i32.const 16) (i32.const 1) (i32.const 24)))))
+`)
+	if code, exited := sys.ExitCode(); exited {
+		t.Fatalf("exit code = %d", code)
+	}
+	if got, want := stdout.String(), "content"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/go-interpreter/wagon/wasm"
@@ -48,3 +49,118 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestSegmentEncoding(t *testing.T) {
+	elems := []struct {
+		raw   []byte
+		mode  wasm.SegmentMode
+		index uint32
+		exprs bool
+	}{
+		{[]byte{0x00, 0x41, 0x01, 0x0b, 0x02, 0x00, 0x01}, wasm.SegmentActive, 0, false},
+		{[]byte{0x01, 0x00, 0x02, 0x00, 0x01}, wasm.SegmentPassive, 0, false},
+		{[]byte{0x02, 0x01, 0x41, 0x01, 0x0b, 0x00, 0x01, 0x03}, wasm.SegmentActive, 1, false},
+		{[]byte{0x03, 0x00, 0x01, 0x03}, wasm.SegmentDeclarative, 0, false},
+		{[]byte{0x04, 0x41, 0x00, 0x0b, 0x02, 0xd2, 0x00, 0x0b, 0xd0, 0x70, 0x0b}, wasm.SegmentActive, 0, true},
+		{[]byte{0x05, 0x70, 0x01, 0xd2, 0x02, 0x0b}, wasm.SegmentPassive, 0, true},
+		{[]byte{0x06, 0x02, 0x41, 0x00, 0x0b, 0x70, 0x01, 0xd0, 0x70, 0x0b}, wasm.SegmentActive, 2, true},
+		{[]byte{0x07, 0x70, 0x01, 0xd2, 0x01, 0x0b}, wasm.SegmentDeclarative, 0, true},
+	}
+	for i, test := range elems {
+		var s wasm.ElementSegment
+		if err := s.UnmarshalWASM(bytes.NewReader(test.raw)); err != nil {
+			t.Fatalf("element segment %d: unexpected error: %v", i, err)
+		}
+		if s.Mode != test.mode || s.Index != test.index || (s.Exprs != nil) != test.exprs {
+			t.Fatalf("element segment %d: unexpected segment: %+v", i, s)
+		}
+		buf := new(bytes.Buffer)
+		if err := s.MarshalWASM(buf); err != nil {
+			t.Fatalf("element segment %d: unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.raw) {
+			t.Fatalf("element segment %d: got=%x, want=%x", i, buf.Bytes(), test.raw)
+		}
+	}
+
+	var s wasm.ElementSegment
+	if err := s.UnmarshalWASM(bytes.NewReader(elems[4].raw)); err != nil {
+		t.Fatal(err)
+	}
+	if index, ok, err := s.Elem(0); err != nil || !ok || index != 0 {
+		t.Fatalf("unexpected element 0: %d, %v, %v", index, ok, err)
+	}
+	if _, ok, err := s.Elem(1); err != nil || ok {
+		t.Fatalf("unexpected element 1: %v, %v", ok, err)
+	}
+
+	data := []struct {
+		raw   []byte
+		mode  wasm.SegmentMode
+		index uint32
+	}{
+		{[]byte{0x00, 0x41, 0x01, 0x0b, 0x02, 'h', 'i'}, wasm.SegmentActive, 0},
+		{[]byte{0x01, 0x02, 'h', 'i'}, wasm.SegmentPassive, 0},
+		{[]byte{0x02, 0x01, 0x41, 0x01, 0x0b, 0x02, 'h', 'i'}, wasm.SegmentActive, 1},
+	}
+	for i, test := range data {
+		var s wasm.DataSegment
+		if err := s.UnmarshalWASM(bytes.NewReader(test.raw)); err != nil {
+			t.Fatalf("data segment %d: unexpected error: %v", i, err)
+		}
+		if s.Mode != test.mode || s.Index != test.index || string(s.Data) != "hi" {
+			t.Fatalf("data segment %d: unexpected segment: %+v", i, s)
+		}
+		buf := new(bytes.Buffer)
+		if err := s.MarshalWASM(buf); err != nil {
+			t.Fatalf("data segment %d: unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.raw) {
+			t.Fatalf("data segment %d: got=%x, want=%x", i, buf.Bytes(), test.raw)
+		}
+	}
+
+	var d wasm.DataSegment
+	if err := d.UnmarshalWASM(bytes.NewReader([]byte{0x03})); err == nil {
+		t.Fatal("expected an error for invalid data segment flags")
+	}
+}
+
+func TestNameSectionRoundTrip(t *testing.T) {
+	subs := map[wasm.NameType]wasm.NameSubsection{
+		wasm.NameModule:   &wasm.ModuleName{Name: "mod"},
+		wasm.NameFunction: &wasm.FunctionNames{Names: wasm.NameMap{0: "add", 3: "main"}},
+		wasm.NameLocal: &wasm.LocalNames{Funcs: map[uint32]wasm.NameMap{
+			0: {0: "x", 2: "sum"},
+			3: {1: "i"},
+		}},
+		wasm.NameGlobal: &wasm.GlobalNames{Names: wasm.NameMap{1: "g"}},
+	}
+	s := wasm.NameSection{Types: make(map[wasm.NameType][]byte)}
+	for typ, sub := range subs {
+		buf := new(bytes.Buffer)
+		if err := sub.MarshalWASM(buf); err != nil {
+			t.Fatal(err)
+		}
+		s.Types[typ] = buf.Bytes()
+	}
+	buf := new(bytes.Buffer)
+	if err := s.MarshalWASM(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var got wasm.NameSection
+	if err := got.UnmarshalWASM(buf); err != nil {
+		t.Fatal(err)
+	}
+	for typ, want := range subs {
+		sub, err := got.Decode(typ)
+		if err != nil {
+			t.Errorf("subsection %d: %v", typ, err)
+			continue
+		}
+		if !reflect.DeepEqual(sub, want) {
+			t.Errorf("subsection %d = %#v, want %#v", typ, sub, want)
+		}
+	}
+}
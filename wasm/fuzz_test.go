@@ -0,0 +1,38 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-interpreter/wagon/internal/fuzztest"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func FuzzDecodeModule(f *testing.F) {
+	fuzztest.AddSeeds(f, "..")
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		m, err := wasm.DecodeModuleWithOptions(bytes.NewReader(raw), fuzztest.DecodeOptions)
+		if err != nil {
+			return
+		}
+		// the decoded module can be encoded, and decoded again.
+		buf := new(bytes.Buffer)
+		if err := wasm.EncodeModule(buf, m); err != nil {
+			t.Fatalf("error encoding the decoded module: %v", err)
+		}
+		if _, err := wasm.DecodeModule(buf); err != nil {
+			t.Fatalf("error decoding the encoded module: %v", err)
+		}
+	})
+}
+
+func FuzzReadModule(f *testing.F) {
+	fuzztest.AddSeeds(f, "..")
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		wasm.ReadModuleWithOptions(bytes.NewReader(raw), nil, fuzztest.DecodeOptions)
+	})
+}
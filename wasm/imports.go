@@ -28,6 +28,7 @@ type ImportEntry struct {
 	// If Kind is Table, Type is a TableImport containing the type of the imported table
 	// If Kind is Memory, Type is a MemoryImport containing the type of the imported memory
 	// If the Kind is Global, Type is a GlobalVarImport
+	// If the Kind is Tag, Type is a TagImport
 	Type Import
 }
 
@@ -80,6 +81,18 @@ func (t GlobalVarImport) MarshalWASM(w io.Writer) error {
 	return t.Type.MarshalWASM(w)
 }
 
+type TagImport struct {
+	Type Tag
+}
+
+func (TagImport) isImport() {}
+func (TagImport) Kind() External {
+	return ExternalTag
+}
+func (t TagImport) MarshalWASM(w io.Writer) error {
+	return t.Type.MarshalWASM(w)
+}
+
 var (
 	ErrImportMutGlobal           = errors.New("wasm: cannot import global mutable variable")
 	ErrNoExportsInImportedModule = errors.New("wasm: imported module has no exports")
@@ -178,20 +191,24 @@ func (module *Module) resolveImports(resolve ResolveFunc) error {
 			module.GlobalIndexSpace = append(module.GlobalIndexSpace, *glb)
 			module.imports.Globals++
 
-			// In both cases below, index should be always 0 (according to the MVP)
-			// We check it against the length of the index space anyway.
 		case ExternalTable:
 			if int(index) >= len(importedModule.TableIndexSpace) {
 				return InvalidTableIndexError(index)
 			}
-			module.TableIndexSpace[0] = importedModule.TableIndexSpace[0]
+			module.TableIndexSpace = append(module.TableIndexSpace, importedModule.TableIndexSpace[index])
 			module.imports.Tables++
 		case ExternalMemory:
 			if int(index) >= len(importedModule.LinearMemoryIndexSpace) {
 				return InvalidLinearMemoryIndexError(index)
 			}
-			module.LinearMemoryIndexSpace[0] = importedModule.LinearMemoryIndexSpace[0]
+			module.LinearMemoryIndexSpace[module.imports.Memories] = importedModule.LinearMemoryIndexSpace[index]
 			module.imports.Memories++
+		case ExternalTag:
+			// the type of the tag is read from the import entry, there
+			// is nothing to add to an index space.
+			if importedModule.GetTag(int(index)) == nil {
+				return InvalidTagIndexError(index)
+			}
 		default:
 			return InvalidExternalError(exportEntry.Kind)
 		}
@@ -6,6 +6,7 @@ package wasm
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 )
 
@@ -30,6 +31,12 @@ func (e InvalidLinearMemoryIndexError) Error() string {
 	return fmt.Sprintf("wasm: Invalid linear memory index: %d", uint32(e))
 }
 
+type InvalidTagIndexError uint32
+
+func (e InvalidTagIndexError) Error() string {
+	return fmt.Sprintf("wasm: Invalid tag index: %d", uint32(e))
+}
+
 // Functions for populating and looking up entries in a module's index space.
 // More info: http://webassembly.org/docs/modules/#function-index-space
 
@@ -37,6 +44,9 @@ func (m *Module) populateFunctions() error {
 	if m.Types == nil || m.Function == nil {
 		return nil
 	}
+	if m.Code == nil && len(m.Function.Types) != 0 {
+		return MissingSectionError(SectionIDCode)
+	}
 
 	for codeIndex, typeIndex := range m.Function.Types {
 		if int(typeIndex) >= len(m.Types.Entries) {
@@ -88,14 +98,17 @@ func (m *Module) GetGlobal(i int) *GlobalEntry {
 	return &m.GlobalIndexSpace[i]
 }
 
-func (m *Module) populateTables() error {
-	if m.Table == nil || len(m.Table.Entries) == 0 || m.Elements == nil || len(m.Elements.Entries) == 0 {
+// populateTables initializes the tables with the active element segments,
+// up to max elements (0 for no limit).
+func (m *Module) populateTables(max int) error {
+	if m.Elements == nil || len(m.Elements.Entries) == 0 {
 		return nil
 	}
 
 	for _, elem := range m.Elements.Entries {
-		// the MVP dictates that index should always be zero, we shuold
-		// probably check this
+		if elem.Mode != SegmentActive {
+			continue
+		}
 		if int(elem.Index) >= len(m.TableIndexSpace) {
 			return InvalidTableIndexError(elem.Index)
 		}
@@ -106,25 +119,63 @@ func (m *Module) populateTables() error {
 		}
 		offset, ok := val.(int32)
 		if !ok {
-			return InvalidValueTypeInitExprError{reflect.Int32, reflect.TypeOf(val).Kind()}
+			return InvalidValueTypeInitExprError{reflect.Int32, reflect.ValueOf(val).Kind()}
 		}
 
+		// the offset is unsigned.
+		size := uint64(uint32(offset)) + uint64(elem.Len())
+		if err := checkLimit("table size", size, int64(max)); err != nil {
+			return err
+		}
 		table := m.TableIndexSpace[int(elem.Index)]
-		if int(offset)+len(elem.Elems) > len(table) {
-			data := make([]uint32, int(offset)+len(elem.Elems))
-			copy(data[offset:], elem.Elems)
+		if size > uint64(len(table)) {
+			data := make([]uint32, size)
 			copy(data, table)
-			m.TableIndexSpace[int(elem.Index)] = data
-		} else {
-			copy(table[int(offset):], elem.Elems)
-			m.TableIndexSpace[int(elem.Index)] = table
+			table = data
+		}
+		for i := 0; i < elem.Len(); i++ {
+			index, ok, err := elem.Elem(i)
+			if err != nil {
+				return err
+			}
+			if ok && m.GetFunction(int(index)) == nil {
+				return InvalidFunctionIndexError(index)
+			}
+			// null references leave the table entry unchanged
+			if ok {
+				table[int(uint32(offset))+i] = index
+			}
 		}
+		m.TableIndexSpace[int(elem.Index)] = table
 	}
 
 	logger.Printf("There are %d entries in the table index space.", len(m.TableIndexSpace))
 	return nil
 }
 
+// GetTable returns the type of a table, based on the table's index in the
+// table index space, which starts with the imported tables. Returns nil when
+// the index is invalid.
+func (m *Module) GetTable(i int) *Table {
+	if i < 0 {
+		return nil
+	}
+	if m.Import != nil {
+		for _, entry := range m.Import.Entries {
+			if imp, ok := entry.Type.(TableImport); ok {
+				if i == 0 {
+					return &imp.Type
+				}
+				i--
+			}
+		}
+	}
+	if m.Table == nil || i >= len(m.Table.Entries) {
+		return nil
+	}
+	return &m.Table.Entries[i]
+}
+
 // GetTableElement returns an element from the tableindex  space indexed
 // by the integer index. It returns an error if index is invalid.
 func (m *Module) GetTableElement(index int) (uint32, error) {
@@ -135,14 +186,17 @@ func (m *Module) GetTableElement(index int) (uint32, error) {
 	return m.TableIndexSpace[0][index], nil
 }
 
-func (m *Module) populateLinearMemory() error {
+// populateLinearMemory initializes the memories with the active data
+// segments, up to max bytes (0 for no limit).
+func (m *Module) populateLinearMemory(max int64) error {
 	if m.Data == nil || len(m.Data.Entries) == 0 {
 		return nil
 	}
-	// each module can only have a single linear memory in the MVP
-
 	for _, entry := range m.Data.Entries {
-		if entry.Index != 0 {
+		if entry.Mode != SegmentActive {
+			continue
+		}
+		if int(entry.Index) >= len(m.LinearMemoryIndexSpace) {
 			return InvalidLinearMemoryIndexError(entry.Index)
 		}
 
@@ -150,19 +204,37 @@ func (m *Module) populateLinearMemory() error {
 		if err != nil {
 			return err
 		}
-		offset, ok := val.(int32)
-		if !ok {
-			return InvalidValueTypeInitExprError{reflect.Int32, reflect.TypeOf(val).Kind()}
+		// the offset, which is unsigned, is an i64 for 64-bit memories.
+		var offset uint64
+		if mem := m.GetMemory(int(entry.Index)); mem != nil && mem.Limits.Is64() {
+			v, ok := val.(int64)
+			if !ok {
+				return InvalidValueTypeInitExprError{reflect.Int64, reflect.ValueOf(val).Kind()}
+			}
+			offset = uint64(v)
+		} else {
+			v, ok := val.(int32)
+			if !ok {
+				return InvalidValueTypeInitExprError{reflect.Int32, reflect.ValueOf(val).Kind()}
+			}
+			offset = uint64(uint32(v))
+		}
+		size := offset + uint64(len(entry.Data))
+		if size < offset {
+			size = math.MaxUint64
+		}
+		if err := checkLimit("memory size", size, max); err != nil {
+			return err
 		}
 
 		memory := m.LinearMemoryIndexSpace[int(entry.Index)]
-		if int(offset)+len(entry.Data) > len(memory) {
-			data := make([]byte, int(offset)+len(entry.Data))
+		if size > uint64(len(memory)) {
+			data := make([]byte, size)
 			copy(data, memory)
 			copy(data[offset:], entry.Data)
 			m.LinearMemoryIndexSpace[int(entry.Index)] = data
 		} else {
-			copy(memory[int(offset):], entry.Data)
+			copy(memory[offset:], entry.Data)
 			m.LinearMemoryIndexSpace[int(entry.Index)] = memory
 		}
 	}
@@ -170,11 +242,67 @@ func (m *Module) populateLinearMemory() error {
 	return nil
 }
 
+// GetMemory returns the type of a linear memory, based on the memory's index
+// in the memory index space, which starts with the imported memories. Returns
+// nil when the index is invalid.
+func (m *Module) GetMemory(i int) *Memory {
+	if i < 0 {
+		return nil
+	}
+	if m.Import != nil {
+		for _, entry := range m.Import.Entries {
+			if imp, ok := entry.Type.(MemoryImport); ok {
+				if i == 0 {
+					return &imp.Type
+				}
+				i--
+			}
+		}
+	}
+	if m.Memory == nil || i >= len(m.Memory.Entries) {
+		return nil
+	}
+	return &m.Memory.Entries[i]
+}
+
 func (m *Module) GetLinearMemoryData(index int) (byte, error) {
-	if index >= len(m.LinearMemoryIndexSpace[0]) {
+	if len(m.LinearMemoryIndexSpace) == 0 || index >= len(m.LinearMemoryIndexSpace[0]) {
 		return 0, InvalidLinearMemoryIndexError(uint32(index))
 
 	}
 
 	return m.LinearMemoryIndexSpace[0][index], nil
 }
+
+// GetTag returns a tag, based on its index in the tag index space, which
+// starts with the imported tags. Returns nil when the index is invalid.
+func (m *Module) GetTag(i int) *Tag {
+	if i < 0 {
+		return nil
+	}
+	if m.Import != nil {
+		for _, entry := range m.Import.Entries {
+			if imp, ok := entry.Type.(TagImport); ok {
+				if i == 0 {
+					return &imp.Type
+				}
+				i--
+			}
+		}
+	}
+	if m.Tag == nil || i >= len(m.Tag.Entries) {
+		return nil
+	}
+	return &m.Tag.Entries[i]
+}
+
+// GetTagType returns the type of the exceptions of a tag, based on its index
+// in the tag index space. Returns nil when the index, or the type of the tag,
+// is invalid.
+func (m *Module) GetTagType(i int) *FunctionSig {
+	tag := m.GetTag(i)
+	if tag == nil || m.Types == nil || int(tag.Type) >= len(m.Types.Entries) {
+		return nil
+	}
+	return &m.Types.Entries[tag.Type]
+}
@@ -20,11 +20,35 @@ const (
 	f32Const  byte = 0x43
 	f64Const  byte = 0x44
 	getGlobal byte = 0x23
+	refNull   byte = 0xd0
+	refFunc   byte = 0xd2
 	end       byte = 0x0b
+
+	// arithmetic operators allowed by the extended-const proposal
+	i32Add byte = 0x6a
+	i32Sub byte = 0x6b
+	i32Mul byte = 0x6c
+	i64Add byte = 0x7c
+	i64Sub byte = 0x7d
+	i64Mul byte = 0x7e
+
+	// v128.const is encoded as the SIMD prefix byte, followed by its
+	// sub-opcode and by 16 bytes.
+	simdPrefix byte = 0xfd
+	v128Const  byte = 0x0c
 )
 
 var ErrEmptyInitExpr = errors.New("wasm: Initializer expression produces no value")
 
+// ErrInvalidInitExprOperands is returned by ExecInitExpr when an operator
+// of an initializer expression is applied to operands that are missing or
+// have the wrong type.
+var ErrInvalidInitExprOperands = errors.New("wasm: Invalid operands in initializer expression")
+
+// ErrRecursiveInitExpr is returned by ExecInitExpr when a global is
+// initialized, directly or not, from its own value.
+var ErrRecursiveInitExpr = errors.New("wasm: Recursive initializer expression")
+
 type InvalidInitExprOpError byte
 
 func (e InvalidInitExprOpError) Error() string {
@@ -67,11 +91,28 @@ outer:
 			if _, err := readU64(r); err != nil {
 				return nil, err
 			}
-		case getGlobal:
+		case getGlobal, refFunc:
 			_, err := leb128.ReadVarUint32(r)
 			if err != nil {
 				return nil, err
 			}
+		case refNull:
+			var t ElemType
+			if err := t.UnmarshalWASM(r); err != nil {
+				return nil, err
+			}
+		case simdPrefix:
+			sub, err := leb128.ReadVarUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			if sub != uint32(v128Const) {
+				return nil, InvalidInitExprOpError(b[0])
+			}
+			if _, err := readBytes(r, 16); err != nil {
+				return nil, err
+			}
+		case i32Add, i32Sub, i32Mul, i64Add, i64Sub, i64Mul:
 		case end:
 			break outer
 		default:
@@ -86,13 +127,29 @@ outer:
 	return buf.Bytes(), nil
 }
 
+// RefValue is the value of an initializer expression producing a
+// reference, with ref.func or ref.null.
+type RefValue struct {
+	Type  ValueType // ValueTypeFuncRef or ValueTypeExternRef
+	Null  bool      // whether this is a null reference
+	Index uint32    // index of the referenced function in the function index space
+}
+
 // ExecInitExpr executes an initializer expression and returns an interface{} value
-// which can either be int32, int64, float32 or float64.
+// which can either be int32, int64, float32, float64, [16]byte (for v128
+// values) or RefValue (for references).
+// Besides constants and global.get, the expression may use the i32 and i64
+// add, sub and mul operators, as allowed by the extended-const proposal.
 // It returns an error if the expression is invalid, and nil when the expression
 // yields no value.
 func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
-	var stack []uint64
-	var lastVal ValueType
+	return m.execInitExpr(expr, 0)
+}
+
+// execInitExpr executes expr, which is nested in depth global.get
+// evaluations.
+func (m *Module) execInitExpr(expr []byte, depth int) (interface{}, error) {
+	var stack []interface{}
 	r := bytes.NewReader(expr)
 
 	if r.Len() == 0 {
@@ -112,29 +169,25 @@ func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
 			if err != nil {
 				return nil, err
 			}
-			stack = append(stack, uint64(i))
-			lastVal = ValueTypeI32
+			stack = append(stack, i)
 		case i64Const:
 			i, err := leb128.ReadVarint64(r)
 			if err != nil {
 				return nil, err
 			}
-			stack = append(stack, uint64(i))
-			lastVal = ValueTypeI64
+			stack = append(stack, i)
 		case f32Const:
 			i, err := readU32(r)
 			if err != nil {
 				return nil, err
 			}
-			stack = append(stack, uint64(i))
-			lastVal = ValueTypeF32
+			stack = append(stack, math.Float32frombits(i))
 		case f64Const:
 			i, err := readU64(r)
 			if err != nil {
 				return nil, err
 			}
-			stack = append(stack, i)
-			lastVal = ValueTypeF64
+			stack = append(stack, math.Float64frombits(i))
 		case getGlobal:
 			index, err := leb128.ReadVarUint32(r)
 			if err != nil {
@@ -144,7 +197,78 @@ func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
 			if globalVar == nil {
 				return nil, InvalidGlobalIndexError(index)
 			}
-			lastVal = globalVar.Type.Type
+			// a chain of global.get longer than the global index space
+			// necessarily goes through the same global twice.
+			if depth >= len(m.GlobalIndexSpace) {
+				return nil, ErrRecursiveInitExpr
+			}
+			val, err := m.execInitExpr(globalVar.Init, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, val)
+		case refNull:
+			var t ElemType
+			if err := t.UnmarshalWASM(r); err != nil {
+				return nil, err
+			}
+			stack = append(stack, RefValue{Type: ValueType(t), Null: true})
+		case refFunc:
+			index, err := leb128.ReadVarUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			if m.GetFunction(int(index)) == nil {
+				return nil, InvalidFunctionIndexError(index)
+			}
+			stack = append(stack, RefValue{Type: ValueTypeFuncRef, Index: index})
+		case simdPrefix:
+			if _, err := leb128.ReadVarUint32(r); err != nil {
+				return nil, err
+			}
+			var v128 [16]byte
+			if _, err := io.ReadFull(r, v128[:]); err != nil {
+				return nil, err
+			}
+			stack = append(stack, v128)
+		case i32Add, i32Sub, i32Mul:
+			if len(stack) < 2 {
+				return nil, ErrInvalidInitExprOperands
+			}
+			v1, ok1 := stack[len(stack)-2].(int32)
+			v2, ok2 := stack[len(stack)-1].(int32)
+			if !ok1 || !ok2 {
+				return nil, ErrInvalidInitExprOperands
+			}
+			var v int32
+			switch b {
+			case i32Add:
+				v = v1 + v2
+			case i32Sub:
+				v = v1 - v2
+			case i32Mul:
+				v = v1 * v2
+			}
+			stack = append(stack[:len(stack)-2], v)
+		case i64Add, i64Sub, i64Mul:
+			if len(stack) < 2 {
+				return nil, ErrInvalidInitExprOperands
+			}
+			v1, ok1 := stack[len(stack)-2].(int64)
+			v2, ok2 := stack[len(stack)-1].(int64)
+			if !ok1 || !ok2 {
+				return nil, ErrInvalidInitExprOperands
+			}
+			var v int64
+			switch b {
+			case i64Add:
+				v = v1 + v2
+			case i64Sub:
+				v = v1 - v2
+			case i64Mul:
+				v = v1 * v2
+			}
+			stack = append(stack[:len(stack)-2], v)
 		case end:
 			break
 		default:
@@ -156,17 +280,32 @@ func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
 		return nil, nil
 	}
 
-	v := stack[len(stack)-1]
-	switch lastVal {
-	case ValueTypeI32:
-		return int32(v), nil
-	case ValueTypeI64:
-		return int64(v), nil
-	case ValueTypeF32:
-		return math.Float32frombits(uint32(v)), nil
-	case ValueTypeF64:
-		return math.Float64frombits(uint64(v)), nil
+	return stack[len(stack)-1], nil
+}
+
+// Elem returns the index of the function referenced by the i-th element of
+// the segment, whether the elements are given as function indices or as
+// initializer expressions. ok is false if the element is a null reference.
+func (s *ElementSegment) Elem(i int) (index uint32, ok bool, err error) {
+	if s.Exprs == nil {
+		return s.Elems[i], true, nil
+	}
+
+	r := bytes.NewReader(s.Exprs[i])
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b {
+	case refFunc:
+		index, err = leb128.ReadVarUint32(r)
+		if err != nil {
+			return 0, false, err
+		}
+		return index, true, nil
+	case refNull:
+		return 0, false, nil
 	default:
-		panic(fmt.Sprintf("Invalid value type produced by initializer expression: %d", int8(lastVal)))
+		return 0, false, InvalidInitExprOpError(b)
 	}
 }
@@ -0,0 +1,56 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestExecInitExpr(t *testing.T) {
+	m := wasm.NewModule()
+	m.GlobalIndexSpace = []wasm.GlobalEntry{
+		{Type: wasm.GlobalVar{Type: wasm.ValueTypeI32}, Init: []byte{0x41, 0x08, 0x0b}},
+		// global.get 1
+		{Type: wasm.GlobalVar{Type: wasm.ValueTypeI32}, Init: []byte{0x23, 0x01, 0x0b}},
+	}
+	m.FunctionIndexSpace = []wasm.Function{{}}
+
+	for _, test := range []struct {
+		expr []byte
+		val  interface{}
+		err  error
+	}{
+		// i32.const 8; i32.const 2; i32.sub
+		{expr: []byte{0x41, 0x08, 0x41, 0x02, 0x6b, 0x0b}, val: int32(6)},
+		// global.get 0; i32.const -1; i32.mul
+		{expr: []byte{0x23, 0x00, 0x41, 0x7f, 0x6c, 0x0b}, val: int32(-8)},
+		// i64.const 1; i64.const 2; i64.add
+		{expr: []byte{0x42, 0x01, 0x42, 0x02, 0x7c, 0x0b}, val: int64(3)},
+		// ref.func 0
+		{expr: []byte{0xd2, 0x00, 0x0b}, val: wasm.RefValue{Type: wasm.ValueTypeFuncRef}},
+		// ref.null extern
+		{expr: []byte{0xd0, 0x6f, 0x0b}, val: wasm.RefValue{Type: wasm.ValueTypeExternRef, Null: true}},
+		// ref.func 1
+		{expr: []byte{0xd2, 0x01, 0x0b}, err: wasm.InvalidFunctionIndexError(1)},
+		// i32.const 1; i64.const 2; i64.add
+		{expr: []byte{0x41, 0x01, 0x42, 0x02, 0x7c, 0x0b}, err: wasm.ErrInvalidInitExprOperands},
+		// i32.const 1; i32.add
+		{expr: []byte{0x41, 0x01, 0x6a, 0x0b}, err: wasm.ErrInvalidInitExprOperands},
+		// global.get 1
+		{expr: []byte{0x23, 0x01, 0x0b}, err: wasm.ErrRecursiveInitExpr},
+	} {
+		val, err := m.ExecInitExpr(test.expr)
+		if err != test.err {
+			t.Errorf("%x: got error %v, want %v", test.expr, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(val, test.val) {
+			t.Errorf("%x: got %#v, want %#v", test.expr, val, test.val)
+		}
+	}
+}
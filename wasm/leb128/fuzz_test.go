@@ -0,0 +1,100 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package leb128
+
+import (
+	"bytes"
+	"testing"
+)
+
+// addSeeds adds the encodings of the test cases to the corpus of f.
+func addSeeds(f *testing.F) {
+	for _, c := range casesUint {
+		f.Add(c.b)
+	}
+	for _, c := range casesUint64 {
+		f.Add(c.b)
+	}
+	for _, c := range casesInt {
+		f.Add(c.b)
+	}
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})
+}
+
+// checkSize checks the size of a value read from b, which can't be more
+// than the bytes needed for its number of bits.
+func checkSize(t *testing.T, b []byte, size, bits uint) {
+	if size == 0 || size > uint(len(b)) || size > (bits+6)/7 {
+		t.Fatalf("%x: read %d bytes", b, size)
+	}
+}
+
+func FuzzReadVarUint32(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, size, err := ReadVarUint32Size(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		checkSize(t, b, size, 32)
+		buf := new(bytes.Buffer)
+		WriteVarUint32(buf, v)
+		if got, err := ReadVarUint32(buf); err != nil || got != v {
+			t.Fatalf("%x: read %d, then %d (%v) once written", b, v, got, err)
+		}
+	})
+}
+
+func FuzzReadVarUint64(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, size, err := ReadVarUint64Size(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		checkSize(t, b, size, 64)
+		buf := new(bytes.Buffer)
+		WriteVarUint64(buf, v)
+		if got, err := ReadVarUint64(buf); err != nil || got != v {
+			t.Fatalf("%x: read %d, then %d (%v) once written", b, v, got, err)
+		}
+	})
+}
+
+func FuzzReadVarint32(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, size, err := ReadVarint32Size(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		checkSize(t, b, size, 32)
+		v64, _, err := ReadVarint64Size(bytes.NewReader(b))
+		if err != nil || v64 != int64(v) {
+			t.Fatalf("%x: read %d as a signed 32-bit integer, %d (%v) as a 64-bit one", b, v, v64, err)
+		}
+		buf := new(bytes.Buffer)
+		WriteVarint64(buf, int64(v))
+		if got, err := ReadVarint32(buf); err != nil || got != v {
+			t.Fatalf("%x: read %d, then %d (%v) once written", b, v, got, err)
+		}
+	})
+}
+
+func FuzzReadVarint64(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, size, err := ReadVarint64Size(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		checkSize(t, b, size, 64)
+		buf := new(bytes.Buffer)
+		WriteVarint64(buf, v)
+		if got, err := ReadVarint64(buf); err != nil || got != v {
+			t.Fatalf("%x: read %d, then %d (%v) once written", b, v, got, err)
+		}
+	})
+}
@@ -7,13 +7,23 @@
 package leb128
 
 import (
+	"errors"
 	"io"
 )
 
-// ReadVarUint32Size reads a LEB128 encoded unsigned 32-bit integer from r.
-// It returns the integer value, the size of the encoded value (in bytes), and
-// the error (if any).
-func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
+var (
+	// ErrTooLong is returned when an integer is encoded with more bytes
+	// than allowed by its size.
+	ErrTooLong = errors.New("leb128: integer representation too long")
+	// ErrTooLarge is returned when the last byte of an encoded integer has
+	// bits set beyond its size. The unused bits of signed integers must
+	// extend their sign bit instead.
+	ErrTooLarge = errors.New("leb128: integer too large")
+)
+
+// readUnsigned reads a LEB128 encoded unsigned integer of the given number
+// of bits from r.
+func readUnsigned(r io.Reader, bits uint) (res uint64, size uint, err error) {
 	b := make([]byte, 1)
 	var shift uint
 	for {
@@ -23,8 +33,17 @@ func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
 
 		size++
 
-		cur := uint32(b[0])
-		res |= (cur & 0x7f) << (shift)
+		cur := uint64(b[0])
+		if shift+7 > bits {
+			// the last byte the integer can take.
+			if cur&0x80 != 0 {
+				return res, size, ErrTooLong
+			}
+			if cur>>(bits-shift) != 0 {
+				return res, size, ErrTooLarge
+			}
+		}
+		res |= (cur & 0x7f) << shift
 		if cur&0x80 == 0 {
 			return res, size, nil
 		}
@@ -32,6 +51,52 @@ func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
 	}
 }
 
+// readSigned reads a LEB128 encoded signed integer of the given number of
+// bits from r.
+func readSigned(r io.Reader, bits uint) (res int64, size uint, err error) {
+	b := make([]byte, 1)
+	var shift uint
+	var cur int64
+	for {
+		if _, err = io.ReadFull(r, b); err != nil {
+			return
+		}
+
+		size++
+
+		cur = int64(b[0])
+		if shift+7 > bits {
+			// the last byte the integer can take, whose sign bit and
+			// unused bits must be all zeros or all ones.
+			if cur&0x80 != 0 {
+				return res, size, ErrTooLong
+			}
+			used := bits - shift
+			if ext := (cur & 0x7f) >> (used - 1); ext != 0 && ext != 0x7f>>(used-1) {
+				return res, size, ErrTooLarge
+			}
+		}
+		res |= (cur & 0x7f) << shift
+		shift += 7
+		if cur&0x80 == 0 {
+			break
+		}
+	}
+
+	if shift < 64 && cur&0x40 != 0 {
+		res |= -1 << shift
+	}
+	return res, size, nil
+}
+
+// ReadVarUint32Size reads a LEB128 encoded unsigned 32-bit integer from r.
+// It returns the integer value, the size of the encoded value (in bytes), and
+// the error (if any).
+func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
+	res64, size, err := readUnsigned(r, 32)
+	return uint32(res64), size, err
+}
+
 // ReadVarUint32 reads a LEB128 encoded unsigned 32-bit integer from r, and
 // returns the integer value, and the error (if any).
 func ReadVarUint32(r io.Reader) (uint32, error) {
@@ -39,11 +104,25 @@ func ReadVarUint32(r io.Reader) (uint32, error) {
 	return n, err
 }
 
+// ReadVarUint64Size reads a LEB128 encoded unsigned 64-bit integer from r.
+// It returns the integer value, the size of the encoded value (in bytes), and
+// the error (if any).
+func ReadVarUint64Size(r io.Reader) (res uint64, size uint, err error) {
+	return readUnsigned(r, 64)
+}
+
+// ReadVarUint64 reads a LEB128 encoded unsigned 64-bit integer from r, and
+// returns the integer value, and the error (if any).
+func ReadVarUint64(r io.Reader) (uint64, error) {
+	n, _, err := ReadVarUint64Size(r)
+	return n, err
+}
+
 // ReadVarint32Size reads a LEB128 encoded signed 32-bit integer from r, and
 // returns the integer value, the size of the encoded value, and the error
 // (if any)
 func ReadVarint32Size(r io.Reader) (res int32, size uint, err error) {
-	res64, size, err := ReadVarint64Size(r)
+	res64, size, err := readSigned(r, 32)
 	res = int32(res64)
 	return
 }
@@ -59,29 +138,7 @@ func ReadVarint32(r io.Reader) (int32, error) {
 // returns the integer value, the size of the encoded value, and the error
 // (if any)
 func ReadVarint64Size(r io.Reader) (res int64, size uint, err error) {
-	var shift uint
-	var sign int64 = -1
-	b := make([]byte, 1)
-
-	for {
-		if _, err = io.ReadFull(r, b); err != nil {
-			return
-		}
-		size++
-
-		cur := int64(b[0])
-		res |= (cur & 0x7f) << shift
-		shift += 7
-		sign <<= 7
-		if cur&0x80 == 0 {
-			break
-		}
-	}
-
-	if ((sign >> 1) & res) != 0 {
-		res |= sign
-	}
-	return res, size, nil
+	return readSigned(r, 64)
 }
 
 // ReadVarint64 reads a LEB128 encoded signed 64-bit integer from r, and
@@ -33,6 +33,30 @@ func TestReadVarUint32(t *testing.T) {
 	}
 }
 
+var casesUint64 = []struct {
+	v uint64
+	b []byte
+}{
+	{b: []byte{0x08}, v: 8},
+	{b: []byte{0x80, 0x80, 0x80, 0xfd, 0x07}, v: 2141192192},
+	{b: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, v: 1 << 35},
+	{b: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, v: 1<<64 - 1},
+}
+
+func TestReadVarUint64(t *testing.T) {
+	for _, c := range casesUint64 {
+		t.Run(fmt.Sprint(c.v), func(t *testing.T) {
+			n, err := ReadVarUint64(bytes.NewReader(c.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != c.v {
+				t.Fatalf("got = %d; want = %d", n, c.v)
+			}
+		})
+	}
+}
+
 var casesInt = []struct {
 	v int64
 	b []byte
@@ -55,3 +79,33 @@ func TestReadVarint32(t *testing.T) {
 		})
 	}
 }
+
+func TestReadErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		read func(r *bytes.Reader) error
+		b    []byte
+		err  error
+	}{
+		{"uint32 too long", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, ErrTooLong},
+		{"uint32 too large", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x10}, ErrTooLarge},
+		{"uint64 too long", readUint64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, ErrTooLong},
+		{"uint64 too large", readUint64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x02}, ErrTooLarge},
+		{"int32 too long", readInt32, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, ErrTooLong},
+		{"int32 too large", readInt32, []byte{0x80, 0x80, 0x80, 0x80, 0x08}, ErrTooLarge},
+		{"int32 too small", readInt32, []byte{0xff, 0xff, 0xff, 0xff, 0x77}, ErrTooLarge},
+		{"int64 too large", readInt64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, ErrTooLarge},
+		{"int64 min", readInt64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.read(bytes.NewReader(c.b)); err != c.err {
+				t.Fatalf("err = %v; want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func readUint32(r *bytes.Reader) error { _, err := ReadVarUint32(r); return err }
+func readUint64(r *bytes.Reader) error { _, err := ReadVarUint64(r); return err }
+func readInt32(r *bytes.Reader) error  { _, err := ReadVarint32(r); return err }
+func readInt64(r *bytes.Reader) error  { _, err := ReadVarint64(r); return err }
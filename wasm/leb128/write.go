@@ -50,6 +50,14 @@ func WriteVarUint32(w io.Writer, cur uint32) (int, error) {
 	return w.Write(buf)
 }
 
+// WriteVarUint64 writes a LEB128 encoded unsigned 64-bit integer to w, and
+// returns the size of the encoded value, and the error (if any).
+func WriteVarUint64(w io.Writer, cur uint64) (int, error) {
+	var buf []byte
+	buf = AppendUleb128(buf, cur)
+	return w.Write(buf)
+}
+
 // WriteVarint64 writes a LEB128 encoded signed 64-bit integer to w, and
 // returns the integer value, the size of the encoded value, and the error
 // (if any)
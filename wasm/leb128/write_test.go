@@ -25,6 +25,21 @@ func TestWriteVarUint32(t *testing.T) {
 	}
 }
 
+func TestWriteVarUint64(t *testing.T) {
+	for _, c := range casesUint64 {
+		t.Run(fmt.Sprint(c.v), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			_, err := WriteVarUint64(buf, c.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.b) {
+				t.Fatalf("unexpected output: %x", buf.Bytes())
+			}
+		})
+	}
+}
+
 func TestWriteVarint64(t *testing.T) {
 	for _, c := range casesInt {
 		t.Run(fmt.Sprint(c.v), func(t *testing.T) {
@@ -0,0 +1,176 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-interpreter/wagon/wasm/leb128"
+)
+
+// DecodeOptions limits the resources used to decode a module, so that
+// untrusted binaries can be decoded without allocating much more memory
+// than their size. A zero field sets the default limit, and a negative one
+// removes it. The limits only apply to the functions taking options, such
+// as ReadModuleWithOptions: ReadModule, DecodeModule and DecodeModuleStream
+// don't limit the modules they decode.
+//
+// Regardless of these limits, counts and lengths larger than the number of
+// bytes remaining in a section are rejected before anything is allocated.
+type DecodeOptions struct {
+	// MaxModuleSize limits the size of the module, in bytes.
+	MaxModuleSize int64
+	// MaxSectionSize limits the size of the payload of each section, in
+	// bytes.
+	MaxSectionSize int
+	// MaxEntries limits the number of entries of every vector of the
+	// module: the entries of each section, the parameters and results of
+	// function types, the local declarations of function bodies and the
+	// elements of element segments.
+	MaxEntries int
+	// MaxStringLen limits the length of names, in bytes.
+	MaxStringLen int
+	// MaxFunctions limits the number of functions of the module, imported
+	// ones included.
+	MaxFunctions int
+
+	// MaxMemorySize and MaxTableSize limit the size, in bytes and in
+	// elements, up to which ReadModule initializes the memories and the
+	// tables of the module with its data and element segments.
+	MaxMemorySize int64
+	MaxTableSize  int
+
+	// Workers sets the number of goroutines decoding the function bodies
+	// of the code section, which are split sequentially. With less than 2
+	// workers, or when decoding a stream, they are decoded sequentially.
+	Workers int
+}
+
+// Default limits of DecodeOptions, which mostly follow the limits of the
+// JavaScript API. As the memories and the tables initialized by ReadModule
+// can be much larger than the module, lower limits may be needed for
+// untrusted modules.
+const (
+	DefaultMaxModuleSize  = 1 << 30
+	DefaultMaxSectionSize = 1 << 30
+	DefaultMaxEntries     = 1000000
+	DefaultMaxStringLen   = 100000
+	DefaultMaxFunctions   = 1000000
+	DefaultMaxMemorySize  = 1 << 30 // 16384 pages
+	DefaultMaxTableSize   = 10000000
+)
+
+// noLimits are the options of the functions not taking any.
+var noLimits = DecodeOptions{
+	MaxModuleSize:  -1,
+	MaxSectionSize: -1,
+	MaxEntries:     -1,
+	MaxStringLen:   -1,
+	MaxFunctions:   -1,
+	MaxMemorySize:  -1,
+	MaxTableSize:   -1,
+}
+
+// withDefaults returns the options with the default limits set, and with
+// negative limits, for no limit, replaced by 0.
+func (o DecodeOptions) withDefaults() DecodeOptions {
+	limit := func(n *int, def int) {
+		switch {
+		case *n == 0:
+			*n = def
+		case *n < 0:
+			*n = 0
+		}
+	}
+	limit64 := func(n *int64, def int64) {
+		switch {
+		case *n == 0:
+			*n = def
+		case *n < 0:
+			*n = 0
+		}
+	}
+	limit64(&o.MaxModuleSize, DefaultMaxModuleSize)
+	limit(&o.MaxSectionSize, DefaultMaxSectionSize)
+	limit(&o.MaxEntries, DefaultMaxEntries)
+	limit(&o.MaxStringLen, DefaultMaxStringLen)
+	limit(&o.MaxFunctions, DefaultMaxFunctions)
+	limit64(&o.MaxMemorySize, DefaultMaxMemorySize)
+	limit(&o.MaxTableSize, DefaultMaxTableSize)
+	return o
+}
+
+// LimitError is returned when a module exceeds one of the limits set by
+// DecodeOptions.
+type LimitError struct {
+	Limit      string // e.g. "module size" or "entries"
+	Value, Max uint64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("wasm: %s %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// checkLimit returns a LimitError if n exceeds max, which is 0 for no
+// limit.
+func checkLimit(limit string, n uint64, max int64) error {
+	if max > 0 && n > uint64(max) {
+		return LimitError{limit, n, uint64(max)}
+	}
+	return nil
+}
+
+// payload is the reader of the payload of a section, which carries the
+// options of the decoding to the ReadPayload methods. The payload of a
+// section read by itself, with another reader, is only limited by the
+// length of its reader when it is known.
+type payload struct {
+	io.LimitedReader
+	opts *DecodeOptions
+}
+
+func newPayload(r io.Reader, n int64, opts *DecodeOptions) *payload {
+	return &payload{io.LimitedReader{R: r, N: n}, opts}
+}
+
+// options returns the options of the decoding of r.
+func options(r io.Reader) *DecodeOptions {
+	if p, ok := r.(*payload); ok {
+		return p.opts
+	}
+	return &DecodeOptions{}
+}
+
+// remaining returns the number of bytes left to read from r, if known.
+func remaining(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case *payload:
+		return r.N, true
+	case interface {
+		Len() int
+	}:
+		return int64(r.Len()), true
+	}
+	return 0, false
+}
+
+// readCount reads the number of entries of a vector, each of which is
+// encoded with at least size bytes. It returns io.ErrUnexpectedEOF if r is
+// too short to hold the entries, and a LimitError if there are more than
+// allowed by MaxEntries.
+func readCount(r io.Reader, size int64) (uint32, error) {
+	n, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkLimit("entries", uint64(n), int64(options(r).MaxEntries)); err != nil {
+		return 0, err
+	}
+	if left, ok := remaining(r); ok && int64(n)*size > left {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
@@ -0,0 +1,273 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+)
+
+// module returns a binary module made of sections, each of which is an ID
+// followed by a payload.
+func module(sections ...[]byte) []byte {
+	raw := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	for _, s := range sections {
+		raw = append(raw, s[0], byte(len(s)-1))
+		raw = append(raw, s[1:]...)
+	}
+	return raw
+}
+
+// maxU32 is the LEB128 encoding of 2^32-1.
+var maxU32 = []byte{0xff, 0xff, 0xff, 0xff, 0x0f}
+
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestDecodeLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		opts wasm.DecodeOptions
+		err  error
+	}{
+		{
+			name: "types",
+			raw:  module(cat([]byte{1}, maxU32)),
+			opts: wasm.DecodeOptions{MaxEntries: -1},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "max entries",
+			raw:  module([]byte{1, 3, 0x60, 0, 0, 0x60, 0, 0, 0x60, 0, 0}),
+			opts: wasm.DecodeOptions{MaxEntries: 2},
+			err:  wasm.LimitError{Limit: "entries", Value: 3, Max: 2},
+		},
+		{
+			name: "params",
+			raw:  module(cat([]byte{1, 1, 0x60}, maxU32)),
+			opts: wasm.DecodeOptions{MaxEntries: -1},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "section size",
+			raw:  module([]byte{0, 3, 'a', 'b', 'c', 1, 2, 3, 4, 5, 6}),
+			opts: wasm.DecodeOptions{MaxSectionSize: 5},
+			err:  wasm.LimitError{Limit: "section size", Value: 10, Max: 5},
+		},
+		{
+			name: "module size",
+			raw:  module([]byte{0, 1, 'a', 1, 2}),
+			opts: wasm.DecodeOptions{MaxModuleSize: 12},
+			err:  wasm.LimitError{Limit: "module size", Value: 14, Max: 12},
+		},
+		{
+			name: "string length",
+			raw:  module([]byte{7, 1, 0xe8, 0x07}),
+			opts: wasm.DecodeOptions{MaxStringLen: 10},
+			err:  wasm.LimitError{Limit: "string length", Value: 1000, Max: 10},
+		},
+		{
+			name: "string",
+			raw:  module(cat([]byte{7, 1}, maxU32)),
+			opts: wasm.DecodeOptions{MaxStringLen: -1},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "functions",
+			raw:  module([]byte{3, 3, 0, 0, 0}),
+			opts: wasm.DecodeOptions{MaxFunctions: 2},
+			err:  wasm.LimitError{Limit: "functions", Value: 3, Max: 2},
+		},
+		{
+			name: "imported functions",
+			raw: module(
+				[]byte{2, 2, 1, 'm', 1, 'f', 0, 0, 1, 'm', 1, 'g', 0, 0},
+				[]byte{3, 1, 0},
+			),
+			opts: wasm.DecodeOptions{MaxFunctions: 2},
+			err:  wasm.LimitError{Limit: "functions", Value: 3, Max: 2},
+		},
+		{
+			name: "body size",
+			raw:  module(cat([]byte{10, 1}, maxU32)),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "elements",
+			raw:  module(cat([]byte{9, 1, 0, 0x41, 0, 0x0b}, maxU32)),
+			opts: wasm.DecodeOptions{MaxEntries: -1},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "data",
+			raw:  module(cat([]byte{11, 1, 0, 0x41, 0, 0x0b}, maxU32)),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "memory size",
+			raw: module(
+				[]byte{5, 1, 0, 1},
+				[]byte{11, 1, 0, 0x41, 0x7f, 0x0b, 2, 'h', 'i'},
+			),
+			opts: wasm.DecodeOptions{MaxMemorySize: 1 << 20},
+			err:  wasm.LimitError{Limit: "memory size", Value: 1<<32 + 1, Max: 1 << 20},
+		},
+		{
+			name: "table size",
+			raw: module(
+				[]byte{4, 1, 0x70, 0, 1},
+				[]byte{9, 1, 0, 0x41, 0x80, 0x80, 0x04, 0x0b, 1, 0},
+			),
+			opts: wasm.DecodeOptions{MaxTableSize: 1000},
+			err:  wasm.LimitError{Limit: "table size", Value: 1<<16 + 1, Max: 1000},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := wasm.ReadModuleWithOptions(bytes.NewReader(tc.raw), nil, tc.opts)
+			if err != tc.err {
+				t.Errorf("err = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+// TestDefaultLimits checks that the default limits only apply to the
+// functions taking options.
+func TestDefaultLimits(t *testing.T) {
+	// a custom section whose name is longer than DefaultMaxStringLen.
+	name := bytes.Repeat([]byte{'a'}, wasm.DefaultMaxStringLen+1)
+	payload := new(bytes.Buffer)
+	leb128.WriteVarUint32(payload, uint32(len(name)))
+	payload.Write(name)
+	raw := bytes.NewBuffer([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0})
+	leb128.WriteVarUint32(raw, uint32(payload.Len()))
+	raw.Write(payload.Bytes())
+	want := wasm.LimitError{Limit: "string length", Value: wasm.DefaultMaxStringLen + 1, Max: wasm.DefaultMaxStringLen}
+
+	for _, tc := range []struct {
+		name   string
+		decode func(r io.Reader, opts *wasm.DecodeOptions) error
+	}{
+		{"ReadModule", func(r io.Reader, opts *wasm.DecodeOptions) (err error) {
+			if opts == nil {
+				_, err = wasm.ReadModule(r, nil)
+			} else {
+				_, err = wasm.ReadModuleWithOptions(r, nil, *opts)
+			}
+			return err
+		}},
+		{"DecodeModule", func(r io.Reader, opts *wasm.DecodeOptions) (err error) {
+			if opts == nil {
+				_, err = wasm.DecodeModule(r)
+			} else {
+				_, err = wasm.DecodeModuleWithOptions(r, *opts)
+			}
+			return err
+		}},
+		{"DecodeModuleStream", func(r io.Reader, opts *wasm.DecodeOptions) (err error) {
+			if opts == nil {
+				_, err = wasm.DecodeModuleStream(r, wasm.StreamHandler{})
+			} else {
+				_, err = wasm.DecodeModuleStreamWithOptions(r, wasm.StreamHandler{}, *opts)
+			}
+			return err
+		}},
+	} {
+		if err := tc.decode(bytes.NewReader(raw.Bytes()), nil); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if err := tc.decode(bytes.NewReader(raw.Bytes()), &wasm.DecodeOptions{}); err != want {
+			t.Errorf("%sWithOptions: err = %v, want %v", tc.name, err, want)
+		}
+		if err := tc.decode(bytes.NewReader(raw.Bytes()), &wasm.DecodeOptions{MaxStringLen: -1}); err != nil {
+			t.Errorf("%sWithOptions without limit: %v", tc.name, err)
+		}
+	}
+}
+
+// TestDecodeAllocations decodes random mutations of the test modules, and
+// checks that the memory allocated is bounded by the size of the input.
+func TestDecodeAllocations(t *testing.T) {
+	var seeds [][]byte
+	for _, dir := range testPaths {
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fname := range fnames {
+			raw, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seeds = append(seeds, raw)
+		}
+	}
+	if len(seeds) == 0 {
+		t.Fatal("no test modules")
+	}
+
+	n := 5000
+	if testing.Short() {
+		n = 500
+	}
+	rnd := rand.New(rand.NewSource(1))
+	opts := wasm.DecodeOptions{MaxMemorySize: 1 << 16, MaxTableSize: 1 << 12}
+	for i := 0; i < n; i++ {
+		raw := mutate(rnd, seeds[rnd.Intn(len(seeds))])
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		wasm.ReadModuleWithOptions(bytes.NewReader(raw), nil, opts)
+		runtime.ReadMemStats(&after)
+
+		// the decoded module takes a few times the size of the binary.
+		max := 1<<20 + 256*uint64(len(raw))
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > max {
+			t.Fatalf("%d bytes allocated to decode %d bytes (%x)", alloc, len(raw), raw)
+		}
+	}
+}
+
+// mutate returns a copy of raw with a few random bytes changed, inserted
+// or removed. Inserted bytes are often the large LEB128 values most likely
+// to cause large allocations.
+func mutate(rnd *rand.Rand, raw []byte) []byte {
+	raw = append([]byte(nil), raw...)
+	for n := 1 + rnd.Intn(4); n > 0; n-- {
+		i := 8
+		if len(raw) > 8 {
+			i += rnd.Intn(len(raw) - 8)
+		}
+		switch rnd.Intn(4) {
+		case 0:
+			if i < len(raw) {
+				raw[i] = byte(rnd.Intn(256))
+			}
+		case 1:
+			if i < len(raw) {
+				raw = append(raw[:i], raw[i+1:]...)
+			}
+		case 2:
+			raw = append(raw[:i], append(append([]byte(nil), maxU32...), raw[i:]...)...)
+		case 3:
+			raw = append(raw[:i], append([]byte{byte(rnd.Intn(256))}, raw[i:]...)...)
+		}
+	}
+	return raw
+}
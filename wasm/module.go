@@ -27,7 +27,8 @@ type Function struct {
 }
 
 // IsHost indicates whether this function is a host function as defined in:
-//  https://webassembly.github.io/spec/core/exec/modules.html#host-functions
+//
+//	https://webassembly.github.io/spec/core/exec/modules.html#host-functions
 func (fct *Function) IsHost() bool {
 	return fct.Host != reflect.Value{}
 }
@@ -51,6 +52,11 @@ type Module struct {
 	Data     *SectionData
 	Customs  []*SectionCustom
 
+	// DataCount is only present in modules using the bulk memory operators.
+	DataCount *SectionDataCount
+	// Tag is only present in modules using the exception handling operators.
+	Tag *SectionTags
+
 	// The function index space of the module
 	FunctionIndexSpace []Function
 	GlobalIndexSpace   []GlobalEntry
@@ -100,6 +106,17 @@ type ResolveFunc func(name string) (*Module, error)
 // DecodeModule is the same as ReadModule, but it only decodes the module without
 // initializing the index space or resolving imports.
 func DecodeModule(r io.Reader) (*Module, error) {
+	return DecodeModuleWithOptions(r, noLimits)
+}
+
+// DecodeModuleWithOptions is the same as DecodeModule, with the limits set
+// by opts.
+func DecodeModuleWithOptions(r io.Reader, opts DecodeOptions) (*Module, error) {
+	return decodeModule(r, nil, opts)
+}
+
+func decodeModule(r io.Reader, h *StreamHandler, opts DecodeOptions) (*Module, error) {
+	opts = opts.withDefaults()
 	reader := &readpos.ReadPos{
 		R:      r,
 		CurPos: 0,
@@ -117,7 +134,7 @@ func DecodeModule(r io.Reader) (*Module, error) {
 	}
 
 	for {
-		done, err := m.readSection(reader)
+		done, err := m.readSection(reader, h, &opts)
 		if err != nil {
 			return nil, err
 		} else if done {
@@ -128,15 +145,32 @@ func DecodeModule(r io.Reader) (*Module, error) {
 
 // ReadModule reads a module from the reader r. resolvePath must take a string
 // and a return a reader to the module pointed to by the string.
+//
+// The function bodies are decoded sequentially, unless DecodeOptions.Workers
+// is set with ReadModuleWithOptions. Decoding a body only reads its local
+// declarations, while its code is disassembled by the validate and exec
+// packages, which can do it concurrently too (see
+// validate.VerifyModuleConcurrently and exec.CompileConcurrency).
 func ReadModule(r io.Reader, resolvePath ResolveFunc) (*Module, error) {
-	m, err := DecodeModule(r)
+	return ReadModuleWithOptions(r, resolvePath, noLimits)
+}
+
+// ReadModuleWithOptions is the same as ReadModule, with the limits set by
+// opts. They don't apply to the modules returned by resolvePath.
+func ReadModuleWithOptions(r io.Reader, resolvePath ResolveFunc, opts DecodeOptions) (*Module, error) {
+	m, err := DecodeModuleWithOptions(r, opts)
 	if err != nil {
 		return nil, err
 	}
-
-	m.LinearMemoryIndexSpace = make([][]byte, 1)
-	if m.Table != nil {
-		m.TableIndexSpace = make([][]uint32, int(len(m.Table.Entries)))
+	opts = opts.withDefaults()
+
+	// imported memories come first in the memory index space
+	if m.Import != nil {
+		for _, entry := range m.Import.Entries {
+			if entry.Type.Kind() == ExternalMemory {
+				m.LinearMemoryIndexSpace = append(m.LinearMemoryIndexSpace, nil)
+			}
+		}
 	}
 
 	if m.Import != nil && resolvePath != nil {
@@ -150,11 +184,19 @@ func ReadModule(r io.Reader, resolvePath ResolveFunc) (*Module, error) {
 		}
 	}
 
+	// imported tables come first in the table index space
+	if m.Table != nil {
+		m.TableIndexSpace = append(m.TableIndexSpace, make([][]uint32, len(m.Table.Entries))...)
+	}
+	if m.Memory != nil {
+		m.LinearMemoryIndexSpace = append(m.LinearMemoryIndexSpace, make([][]byte, len(m.Memory.Entries))...)
+	}
+
 	for _, fn := range []func() error{
 		m.populateGlobals,
 		m.populateFunctions,
-		m.populateTables,
-		m.populateLinearMemory,
+		func() error { return m.populateTables(opts.MaxTableSize) },
+		func() error { return m.populateLinearMemory(opts.MaxMemorySize) },
 	} {
 		if err := fn(); err != nil {
 			return nil, err
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/go-interpreter/wagon/wasm"
@@ -45,3 +46,97 @@ func TestReadModule(t *testing.T) {
 		}
 	}
 }
+
+func TestDuplicateExport(t *testing.T) {
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		// the export section, with the memory exported twice as "m".
+		0x07, 0x09, 0x02,
+		0x01, 'm', 0x02, 0x00,
+		0x01, 'm', 0x02, 0x00,
+	}
+	_, err := wasm.ReadModule(bytes.NewReader(raw), nil)
+	if _, ok := err.(wasm.DuplicateExportError); !ok {
+		t.Fatalf("err = %v, want a DuplicateExportError", err)
+	}
+	if got, want := err.Error(), "Duplicate export entry: m"; got != want {
+		t.Errorf("err = %q, want %q", got, want)
+	}
+}
+
+// TestDecodeWorkers checks that decoding the function bodies concurrently
+// gives the same modules and the same errors as decoding them sequentially.
+func TestDecodeWorkers(t *testing.T) {
+	var raws [][]byte
+	for _, dir := range testPaths {
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fname := range fnames {
+			raw, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raws = append(raws, raw)
+		}
+	}
+	// the second body declares too many locals, and the third one is
+	// truncated.
+	raws = append(raws, module([]byte{10, 3, 2, 0, 0x0b, 3, 5, 1, 0x7f, 16, 0}))
+	// the third body is truncated.
+	raws = append(raws, module([]byte{10, 3, 2, 0, 0x0b, 2, 0, 0x0b, 16, 0}))
+
+	for i, raw := range raws {
+		want, wantErr := wasm.DecodeModuleWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{})
+		for _, workers := range []int{2, 4, 64} {
+			got, err := wasm.DecodeModuleWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{Workers: workers})
+			if err != wantErr {
+				t.Errorf("module %d, %d workers: err = %v, want %v", i, workers, err, wantErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("module %d, %d workers: the modules differ", i, workers)
+			}
+		}
+	}
+}
+
+// TestReadModuleElements checks that the functions referenced by element
+// segments exist, as found by fuzzing the VM.
+func TestReadModuleElements(t *testing.T) {
+	// a table, and an element segment referencing the function 5.
+	raw := module([]byte{4, 1, 0x70, 0, 1}, []byte{9, 1, 0, 0x41, 0, 0x0b, 1, 5})
+	_, err := wasm.ReadModule(bytes.NewReader(raw), nil)
+	if want := wasm.InvalidFunctionIndexError(5); err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+}
+
+// TestReadModuleOffsets checks that the offsets of the segments which
+// evaluate to no value are rejected.
+func TestReadModuleOffsets(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+	}{
+		// a table, and an element segment whose offset is an empty expression.
+		{"element", module([]byte{4, 1, 0x70, 0, 1}, []byte{9, 1, 0, 0x0b, 0})},
+		// a memory, and a data segment whose offset is an empty expression.
+		{"data", module([]byte{5, 1, 0, 1}, []byte{11, 1, 0, 0x0b, 0})},
+	} {
+		_, err := wasm.ReadModule(bytes.NewReader(tc.raw), nil)
+		want := wasm.InvalidValueTypeInitExprError{Wanted: reflect.Int32, Got: reflect.Invalid}
+		if err != want {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, want)
+		}
+	}
+}
+
+func TestReadModuleNoTable(t *testing.T) {
+	// an element segment, without a table.
+	raw := module([]byte{9, 1, 0, 0x41, 0, 0x0b, 0})
+	_, err := wasm.ReadModule(bytes.NewReader(raw), nil)
+	if want := wasm.InvalidTableIndexError(0); err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+}
@@ -7,4 +7,7 @@ package operators
 var (
 	Call         = newPolymorphicOp(0x10, "call")
 	CallIndirect = newPolymorphicOp(0x11, "call_indirect")
+
+	ReturnCall         = newPolymorphicOp(0x12, "return_call")
+	ReturnCallIndirect = newPolymorphicOp(0x13, "return_call_indirect")
 )
@@ -21,3 +21,13 @@ var (
 	BrTable     = newPolymorphicOp(0x0e, "br_table")
 	Return      = newPolymorphicOp(0x0f, "return")
 )
+
+// exception handling operators
+var (
+	Try      = newOp(0x06, "try", nil, noReturn)
+	Catch    = newPolymorphicOp(0x07, "catch")
+	Throw    = newPolymorphicOp(0x08, "throw")
+	Rethrow  = newPolymorphicOp(0x09, "rethrow")
+	Delegate = newOp(0x18, "delegate", nil, noReturn)
+	CatchAll = newOp(0x19, "catch_all", nil, noReturn)
+)
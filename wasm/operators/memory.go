@@ -39,3 +39,11 @@ var (
 	CurrentMemory = newOp(0x3f, "memory.size", nil, wasm.ValueTypeI32)
 	GrowMemory    = newOp(0x40, "memory.grow", []wasm.ValueType{wasm.ValueTypeI32}, wasm.ValueTypeI32)
 )
+
+// Bulk memory operators, prefixed by MiscPrefix.
+var (
+	MemoryInit = newPrefixedOp(MiscPrefix, 0x08, "memory.init", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+	DataDrop   = newPrefixedOp(MiscPrefix, 0x09, "data.drop", nil, noReturn)
+	MemoryCopy = newPrefixedOp(MiscPrefix, 0x0a, "memory.copy", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+	MemoryFill = newPrefixedOp(MiscPrefix, 0x0b, "memory.fill", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+)
@@ -12,14 +12,37 @@ import (
 	"github.com/go-interpreter/wagon/wasm"
 )
 
+// MiscPrefix is the prefix byte of the operators introduced by the bulk
+// memory operations and non-trapping float-to-int conversions proposals.
+// Prefixed operators are encoded as the prefix byte followed by a varuint32
+// sub-opcode.
+const MiscPrefix byte = 0xfc
+
+// SIMDPrefix is the prefix byte of the operators introduced by the
+// fixed-width SIMD proposal.
+const SIMDPrefix byte = 0xfd
+
+// AtomicPrefix is the prefix byte of the atomic memory operators introduced
+// by the threads proposal.
+const AtomicPrefix byte = 0xfe
+
 var (
 	ops      [256]Op // an array of Op values mapped by wasm opcodes, used by New().
 	noReturn = wasm.ValueType(wasm.BlockTypeEmpty)
+
+	// Op values of prefixed operators, mapped by prefix byte and
+	// sub-opcode, used by NewPrefixed().
+	prefixedOps = map[byte]map[uint32]Op{
+		MiscPrefix:   {},
+		SIMDPrefix:   {},
+		AtomicPrefix: {},
+	}
 )
 
 // Op describes a WASM operator.
 type Op struct {
-	Code byte   // The single-byte opcode
+	Code byte   // The single-byte opcode, or the prefix byte for prefixed operators
+	Sub  uint32 // The sub-opcode following the prefix byte, only used by prefixed operators
 	Name string // The name of the operator
 
 	// Whether this operator is polymorphic.
@@ -34,6 +57,19 @@ func (o Op) IsValid() bool {
 	return o.Name != ""
 }
 
+// IsPrefixed returns whether the operator is encoded as a prefix byte
+// followed by a sub-opcode.
+func (o Op) IsPrefixed() bool {
+	return IsPrefix(o.Code)
+}
+
+// IsPrefix returns whether code is the prefix byte of a family of prefixed
+// operators, in which case NewPrefixed must be used instead of New.
+func IsPrefix(code byte) bool {
+	_, ok := prefixedOps[code]
+	return ok
+}
+
 func newOp(code byte, name string, args []wasm.ValueType, returns wasm.ValueType) byte {
 	if ops[code].IsValid() {
 		panic(fmt.Errorf("Opcode %#x is already assigned to %s", code, ops[code].Name))
@@ -64,6 +100,36 @@ func newPolymorphicOp(code byte, name string) byte {
 	return code
 }
 
+func newPrefixedOp(prefix byte, sub uint32, name string, args []wasm.ValueType, returns wasm.ValueType) uint32 {
+	if op, ok := prefixedOps[prefix][sub]; ok {
+		panic(fmt.Errorf("Opcode %#x %#x is already assigned to %s", prefix, sub, op.Name))
+	}
+
+	prefixedOps[prefix][sub] = Op{
+		Code:        prefix,
+		Sub:         sub,
+		Name:        name,
+		Polymorphic: false,
+		Args:        args,
+		Returns:     returns,
+	}
+	return sub
+}
+
+func newPrefixedPolymorphicOp(prefix byte, sub uint32, name string) uint32 {
+	if op, ok := prefixedOps[prefix][sub]; ok {
+		panic(fmt.Errorf("Opcode %#x %#x is already assigned to %s", prefix, sub, op.Name))
+	}
+
+	prefixedOps[prefix][sub] = Op{
+		Code:        prefix,
+		Sub:         sub,
+		Name:        name,
+		Polymorphic: true,
+	}
+	return sub
+}
+
 type InvalidOpcodeError byte
 
 func (e InvalidOpcodeError) Error() string {
@@ -84,3 +150,25 @@ func New(code byte) (Op, error) {
 	}
 	return op, nil
 }
+
+// InvalidPrefixedOpcodeError is returned by NewPrefixed for an unknown
+// prefix byte or sub-opcode.
+type InvalidPrefixedOpcodeError struct {
+	Prefix byte
+	Sub    uint32
+}
+
+func (e InvalidPrefixedOpcodeError) Error() string {
+	return fmt.Sprintf("Invalid opcode: %#x %#x", e.Prefix, e.Sub)
+}
+
+// NewPrefixed returns the Op object for a valid prefixed operator, given its
+// prefix byte and sub-opcode.
+// If either is invalid, an InvalidPrefixedOpcodeError is returned.
+func NewPrefixed(prefix byte, sub uint32) (Op, error) {
+	op, ok := prefixedOps[prefix][sub]
+	if !ok {
+		return op, InvalidPrefixedOpcodeError{prefix, sub}
+	}
+	return op, nil
+}
@@ -28,3 +28,29 @@ func TestNew(t *testing.T) {
 		t.Fatalf("0xff: operator %v is valid (should be invalid)", op2)
 	}
 }
+
+func TestNewPrefixed(t *testing.T) {
+	op, err := NewPrefixed(MiscPrefix, MemoryCopy)
+	if err != nil {
+		t.Fatalf("unexpected error from NewPrefixed: %v", err)
+	}
+	if op.Name != "memory.copy" {
+		t.Fatalf("0xfc 0x0a: unexpected Op name. got=%s, want=memory.copy", op.Name)
+	}
+	if !op.IsValid() || !op.IsPrefixed() {
+		t.Fatalf("0xfc 0x0a: operator %v should be a valid prefixed operator", op)
+	}
+	if op.Code != MiscPrefix || op.Sub != MemoryCopy {
+		t.Fatalf("0xfc 0x0a: unexpected opcode. got=%#x %#x", op.Code, op.Sub)
+	}
+
+	if _, err := New(MiscPrefix); err == nil {
+		t.Fatalf("0xfc: expected error while getting Op value")
+	}
+	if _, err = NewPrefixed(MiscPrefix, 0xffff); err == nil {
+		t.Fatalf("0xfc 0xffff: expected error while getting Op value")
+	}
+	if _, err = NewPrefixed(0xff, 0); err == nil {
+		t.Fatalf("0xff 0x00: expected error while getting Op value")
+	}
+}
@@ -7,4 +7,8 @@ package operators
 var (
 	Drop   = newPolymorphicOp(0x1a, "drop")
 	Select = newPolymorphicOp(0x1b, "select")
+
+	// TypedSelect is the select operator with explicit result types,
+	// required for reference typed operands.
+	TypedSelect = newPolymorphicOp(0x1c, "select")
 )
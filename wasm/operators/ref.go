@@ -0,0 +1,17 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package operators
+
+import (
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// Reference operators. The types of the values used by ref.null and
+// ref.is_null depend on their operands and immediates.
+var (
+	RefNull   = newPolymorphicOp(0xd0, "ref.null")
+	RefIsNull = newPolymorphicOp(0xd1, "ref.is_null")
+	RefFunc   = newOp(0xd2, "ref.func", nil, wasm.ValueTypeFuncRef)
+)
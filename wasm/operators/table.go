@@ -0,0 +1,24 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package operators
+
+import (
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+var (
+	TableGet = newPolymorphicOp(0x25, "table.get")
+	TableSet = newPolymorphicOp(0x26, "table.set")
+)
+
+// Bulk table operators, prefixed by MiscPrefix.
+var (
+	TableInit = newPrefixedOp(MiscPrefix, 0x0c, "table.init", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+	ElemDrop  = newPrefixedOp(MiscPrefix, 0x0d, "elem.drop", nil, noReturn)
+	TableCopy = newPrefixedOp(MiscPrefix, 0x0e, "table.copy", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+	TableGrow = newPrefixedPolymorphicOp(MiscPrefix, 0x0f, "table.grow")
+	TableSize = newPrefixedOp(MiscPrefix, 0x10, "table.size", nil, wasm.ValueTypeI32)
+	TableFill = newPrefixedPolymorphicOp(MiscPrefix, 0x11, "table.fill")
+)
@@ -5,13 +5,33 @@
 package wasm
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 
 	"github.com/go-interpreter/wagon/wasm/leb128"
 )
 
+// chunkSize is the size of the chunks in which readBytes reads byte strings
+// of unknown length, so that the memory allocated is bounded by the size of
+// the input.
+const chunkSize = 1 << 16
+
 func readBytes(r io.Reader, n int) ([]byte, error) {
+	left, ok := remaining(r)
+	switch {
+	case ok && int64(n) > left:
+		return nil, io.ErrUnexpectedEOF
+	case !ok && n > chunkSize:
+		buf := new(bytes.Buffer)
+		if _, err := io.CopyN(buf, r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf.Bytes(), err
+		}
+		return buf.Bytes(), nil
+	}
 	bytes := make([]byte, n)
 	_, err := io.ReadFull(r, bytes)
 	if err != nil {
@@ -37,11 +57,15 @@ func readString(r io.Reader, n int) (string, error) {
 	return string(bytes), nil
 }
 
+// readStringUint reads a name, whose length is limited by MaxStringLen.
 func readStringUint(r io.Reader) (string, error) {
 	n, err := leb128.ReadVarUint32(r)
 	if err != nil {
 		return "", err
 	}
+	if err := checkLimit("string length", uint64(n), int64(options(r).MaxStringLen)); err != nil {
+		return "", err
+	}
 	return readString(r, int(n))
 }
 
@@ -10,8 +10,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"sort"
 
+	"github.com/go-interpreter/wagon/internal/parallel"
 	"github.com/go-interpreter/wagon/wasm/internal/readpos"
 	"github.com/go-interpreter/wagon/wasm/leb128"
 )
@@ -45,22 +47,30 @@ const (
 	SectionIDElement  SectionID = 9
 	SectionIDCode     SectionID = 10
 	SectionIDData     SectionID = 11
+	// SectionIDDataCount is the ID of the data count section, introduced by
+	// the bulk memory operations proposal.
+	SectionIDDataCount SectionID = 12
+	// SectionIDTag is the ID of the tag section, introduced by the
+	// exception handling proposal.
+	SectionIDTag SectionID = 13
 )
 
 func (s SectionID) String() string {
 	n, ok := map[SectionID]string{
-		SectionIDCustom:   "custom",
-		SectionIDType:     "type",
-		SectionIDImport:   "import",
-		SectionIDFunction: "function",
-		SectionIDTable:    "table",
-		SectionIDMemory:   "memory",
-		SectionIDGlobal:   "global",
-		SectionIDExport:   "export",
-		SectionIDStart:    "start",
-		SectionIDElement:  "element",
-		SectionIDCode:     "code",
-		SectionIDData:     "data",
+		SectionIDCustom:    "custom",
+		SectionIDType:      "type",
+		SectionIDImport:    "import",
+		SectionIDFunction:  "function",
+		SectionIDTable:     "table",
+		SectionIDMemory:    "memory",
+		SectionIDGlobal:    "global",
+		SectionIDExport:    "export",
+		SectionIDStart:     "start",
+		SectionIDElement:   "element",
+		SectionIDCode:      "code",
+		SectionIDData:      "data",
+		SectionIDDataCount: "data count",
+		SectionIDTag:       "tag",
 	}[s]
 	if !ok {
 		return "unknown"
@@ -107,7 +117,10 @@ func (e MissingSectionError) Error() string {
 
 // reads a valid section from r. The first return value is true if and only if
 // the module has been completely read.
-func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
+// If h is not nil, the section is reported to it, and its raw bytes are not
+// kept in memory. opts are the limits of the decoding, with their defaults
+// set.
+func (m *Module) readSection(r *readpos.ReadPos, h *StreamHandler, opts *DecodeOptions) (bool, error) {
 	var err error
 	var id uint32
 
@@ -128,12 +141,30 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 	}
 
 	logger.Printf("Section payload length: %d", payloadDataLen)
+	if err := checkLimit("section size", uint64(payloadDataLen), int64(opts.MaxSectionSize)); err != nil {
+		return false, err
+	}
+	if err := checkLimit("module size", uint64(r.CurPos)+uint64(payloadDataLen), opts.MaxModuleSize); err != nil {
+		return false, err
+	}
 
 	s.Start = r.CurPos
 
-	sectionBytes := new(bytes.Buffer)
-	sectionBytes.Grow(int(payloadDataLen))
-	sectionReader := io.LimitReader(io.TeeReader(r, sectionBytes), int64(payloadDataLen))
+	if h != nil && h.SkipSection != nil && h.SkipSection(s.ID) {
+		logger.Printf("skipping section %s", s.ID)
+		if _, err := io.CopyN(ioutil.Discard, r, int64(payloadDataLen)); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	var sectionBytes *bytes.Buffer
+	var sectionReader io.Reader = r
+	if h == nil {
+		sectionBytes = new(bytes.Buffer)
+		sectionReader = io.TeeReader(r, sectionBytes)
+	}
+	sectionReader = newPayload(sectionReader, int64(payloadDataLen), opts)
 
 	var sec Section
 	switch s.ID {
@@ -186,18 +217,39 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 		logger.Println("section data")
 		m.Data = &SectionData{}
 		sec = m.Data
+	case SectionIDDataCount:
+		logger.Println("section data count")
+		m.DataCount = &SectionDataCount{}
+		sec = m.DataCount
+	case SectionIDTag:
+		logger.Println("section tag")
+		m.Tag = &SectionTags{}
+		sec = m.Tag
 	default:
 		return false, InvalidSectionIDError(s.ID)
 	}
-	err = sec.ReadPayload(sectionReader)
+	if code, ok := sec.(*SectionCode); ok && h != nil && h.FunctionBody != nil {
+		err = code.readPayload(sectionReader, func(i int, body *FunctionBody) error {
+			body.Module = m
+			return h.FunctionBody(m, i, body)
+		})
+	} else {
+		err = sec.ReadPayload(sectionReader)
+	}
 	if err != nil {
 		logger.Println(err)
 		return false, err
 	}
 	s.End = r.CurPos
-	s.Bytes = sectionBytes.Bytes()
+	if sectionBytes != nil {
+		s.Bytes = sectionBytes.Bytes()
+	}
 	*sec.GetRawSection() = s
 	switch s.ID {
+	case SectionIDImport, SectionIDFunction:
+		if err := checkLimit("functions", m.functionCount(), int64(opts.MaxFunctions)); err != nil {
+			return false, err
+		}
 	case SectionIDCode:
 		s := m.Code
 		if m.Function == nil || len(m.Function.Types) == 0 {
@@ -214,9 +266,31 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 		}
 	}
 	m.Sections = append(m.Sections, sec)
+	if h != nil && h.Section != nil {
+		if err := h.Section(m, sec); err != nil {
+			return false, err
+		}
+	}
 	return false, nil
 }
 
+// functionCount returns the number of functions of the sections decoded
+// so far, imported ones included.
+func (m *Module) functionCount() uint64 {
+	var n uint64
+	if m.Import != nil {
+		for _, entry := range m.Import.Entries {
+			if entry.Type.Kind() == ExternalFunction {
+				n++
+			}
+		}
+	}
+	if m.Function != nil {
+		n += uint64(len(m.Function.Types))
+	}
+	return n
+}
+
 var _ Section = (*SectionCustom)(nil)
 
 type SectionCustom struct {
@@ -264,7 +338,7 @@ func (*SectionTypes) SectionID() SectionID {
 }
 
 func (s *SectionTypes) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -303,7 +377,7 @@ func (*SectionImports) SectionID() SectionID {
 }
 
 func (s *SectionImports) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -377,6 +451,14 @@ func (i *ImportEntry) UnmarshalWASM(r io.Reader) error {
 		if err == nil {
 			i.Type = GlobalVarImport{gl}
 		}
+	case ExternalTag:
+		logger.Println("importing tag")
+		var tag Tag
+
+		err = tag.UnmarshalWASM(r)
+		if err == nil {
+			i.Type = TagImport{tag}
+		}
 	default:
 		return InvalidExternalError(kind)
 	}
@@ -409,7 +491,7 @@ func (*SectionFunctions) SectionID() SectionID {
 }
 
 func (s *SectionFunctions) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -447,7 +529,7 @@ func (*SectionTables) SectionID() SectionID {
 }
 
 func (s *SectionTables) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -484,7 +566,7 @@ func (*SectionMemories) SectionID() SectionID {
 }
 
 func (s *SectionMemories) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -510,6 +592,44 @@ func (s *SectionMemories) WritePayload(w io.Writer) error {
 	return nil
 }
 
+// SectionTags declares the tags defined by the module, with which
+// exceptions are thrown and caught.
+type SectionTags struct {
+	RawSection
+	Entries []Tag
+}
+
+func (*SectionTags) SectionID() SectionID {
+	return SectionIDTag
+}
+
+func (s *SectionTags) ReadPayload(r io.Reader) error {
+	count, err := readCount(r, 1)
+	if err != nil {
+		return err
+	}
+	s.Entries = make([]Tag, count)
+	for i := range s.Entries {
+		err = s.Entries[i].UnmarshalWASM(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SectionTags) WritePayload(w io.Writer) error {
+	if _, err := leb128.WriteVarUint32(w, uint32(len(s.Entries))); err != nil {
+		return err
+	}
+	for _, e := range s.Entries {
+		if err := e.MarshalWASM(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SectionGlobals defines the value of all global variables declared in a module.
 type SectionGlobals struct {
 	RawSection
@@ -521,7 +641,7 @@ func (*SectionGlobals) SectionID() SectionID {
 }
 
 func (s *SectionGlobals) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -585,7 +705,7 @@ func (*SectionExports) SectionID() SectionID {
 }
 
 func (s *SectionExports) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -614,8 +734,15 @@ func (s *SectionExports) WritePayload(w io.Writer) error {
 	for _, e := range s.Entries {
 		entries = append(entries, e)
 	}
+	// entries of different kinds may have the same index
 	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Index < entries[j].Index
+		if entries[i].Index != entries[j].Index {
+			return entries[i].Index < entries[j].Index
+		}
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		return entries[i].FieldStr < entries[j].FieldStr
 	})
 	for _, e := range entries {
 		if err := e.MarshalWASM(w); err != nil {
@@ -628,7 +755,7 @@ func (s *SectionExports) WritePayload(w io.Writer) error {
 type DuplicateExportError string
 
 func (e DuplicateExportError) Error() string {
-	return fmt.Sprintf("Duplicate export entry: %s", e)
+	return fmt.Sprintf("Duplicate export entry: %s", string(e))
 }
 
 // ExportEntry represents an exported entry by the module
@@ -699,7 +826,7 @@ func (*SectionElements) SectionID() SectionID {
 }
 
 func (s *SectionElements) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -725,27 +852,124 @@ func (s *SectionElements) WritePayload(w io.Writer) error {
 	return nil
 }
 
+// SegmentMode describes when the contents of a data or element segment are
+// used.
+type SegmentMode uint8
+
+const (
+	// SegmentActive segments are copied into a linear memory or table when
+	// the module is instantiated. They are the only kind of segments in the MVP.
+	SegmentActive SegmentMode = iota
+	// SegmentPassive segments are only copied by the memory.init and
+	// table.init operators.
+	SegmentPassive
+	// SegmentDeclarative element segments are never copied, they only declare
+	// references to functions. Data segments cannot be declarative.
+	SegmentDeclarative
+)
+
+func (m SegmentMode) String() string {
+	switch m {
+	case SegmentActive:
+		return "active"
+	case SegmentPassive:
+		return "passive"
+	case SegmentDeclarative:
+		return "declarative"
+	}
+	return fmt.Sprintf("<unknown segment mode %d>", uint8(m))
+}
+
+// InvalidSegmentFlagsError is returned while decoding a data or element
+// segment that starts with an unknown flags value.
+type InvalidSegmentFlagsError uint32
+
+func (e InvalidSegmentFlagsError) Error() string {
+	return fmt.Sprintf("wasm: invalid segment flags: %#x", uint32(e))
+}
+
+// flags used in the encoding of element segments
+const (
+	elemFlagNotActive   = 0x1 // the segment is passive or declarative
+	elemFlagTableIndex  = 0x2 // the active segment has an explicit table index, or the segment is declarative
+	elemFlagExpressions = 0x4 // elements are given as initializer expressions
+)
+
 // ElementSegment describes a group of repeated elements that begin at a specified offset
 type ElementSegment struct {
-	Index  uint32 // The index into the global table space, should always be 0 in the MVP.
-	Offset []byte // initializer expression for computing the offset for placing elements, should return an i32 value
-	Elems  []uint32
+	Mode   SegmentMode // How the segment is used, only active segments exist in the MVP.
+	Index  uint32      // The index into the global table space, should always be 0 in the MVP.
+	Offset []byte      // initializer expression for computing the offset for placing elements, should return an i32 value. Nil for non-active segments.
+	Type   ElemType    // The type of the elements, always ElemTypeAnyFunc when they are given as Elems.
+	Elems  []uint32    // function indices
+	Exprs  [][]byte    // initializer expressions producing the elements, used instead of Elems if not nil
 }
 
 func (s *ElementSegment) UnmarshalWASM(r io.Reader) error {
-	var err error
-
-	if s.Index, err = leb128.ReadVarUint32(r); err != nil {
+	flags, err := leb128.ReadVarUint32(r)
+	if err != nil {
 		return err
 	}
-	if s.Offset, err = readInitExpr(r); err != nil {
-		return err
+	if flags > elemFlagNotActive|elemFlagTableIndex|elemFlagExpressions {
+		return InvalidSegmentFlagsError(flags)
 	}
 
-	numElems, err := leb128.ReadVarUint32(r)
+	s.Index = 0
+	s.Offset = nil
+	s.Type = ElemTypeAnyFunc
+	switch {
+	case flags&elemFlagNotActive == 0:
+		s.Mode = SegmentActive
+		if flags&elemFlagTableIndex != 0 {
+			if s.Index, err = leb128.ReadVarUint32(r); err != nil {
+				return err
+			}
+		}
+		if s.Offset, err = readInitExpr(r); err != nil {
+			return err
+		}
+	case flags&elemFlagTableIndex == 0:
+		s.Mode = SegmentPassive
+	default:
+		s.Mode = SegmentDeclarative
+	}
+
+	// the element kind or type is only encoded when the segment is not
+	// an active segment for table 0 using the MVP encoding.
+	if flags&(elemFlagNotActive|elemFlagTableIndex) != 0 {
+		if flags&elemFlagExpressions != 0 {
+			if err = s.Type.UnmarshalWASM(r); err != nil {
+				return err
+			}
+		} else {
+			// the element kind, 0x00 (funcref) is the only valid one.
+			kind, err := readBytes(r, 1)
+			if err != nil {
+				return err
+			}
+			if kind[0] != 0 {
+				return InvalidSegmentFlagsError(flags)
+			}
+		}
+	}
+
+	numElems, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
+
+	if flags&elemFlagExpressions != 0 {
+		s.Elems = nil
+		s.Exprs = make([][]byte, numElems)
+		for i := range s.Exprs {
+			if s.Exprs[i], err = readInitExpr(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	s.Exprs = nil
 	s.Elems = make([]uint32, numElems)
 
 	for i := range s.Elems {
@@ -760,12 +984,57 @@ func (s *ElementSegment) UnmarshalWASM(r io.Reader) error {
 }
 
 func (s *ElementSegment) MarshalWASM(w io.Writer) error {
-	if _, err := leb128.WriteVarUint32(w, s.Index); err != nil {
-		return err
+	var flags uint32
+	if s.Exprs != nil {
+		flags |= elemFlagExpressions
+	}
+	switch s.Mode {
+	case SegmentActive:
+		if s.Index != 0 || (s.Exprs != nil && s.Type != ElemTypeAnyFunc) {
+			flags |= elemFlagTableIndex
+		}
+	case SegmentPassive:
+		flags |= elemFlagNotActive
+	case SegmentDeclarative:
+		flags |= elemFlagNotActive | elemFlagTableIndex
+	default:
+		return fmt.Errorf("wasm: invalid element segment mode %v", s.Mode)
 	}
-	if _, err := w.Write(s.Offset); err != nil {
+
+	if _, err := leb128.WriteVarUint32(w, flags); err != nil {
 		return err
 	}
+	if s.Mode == SegmentActive {
+		if flags&elemFlagTableIndex != 0 {
+			if _, err := leb128.WriteVarUint32(w, s.Index); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(s.Offset); err != nil {
+			return err
+		}
+	}
+	if flags&(elemFlagNotActive|elemFlagTableIndex) != 0 {
+		if s.Exprs != nil {
+			if err := s.Type.MarshalWASM(w); err != nil {
+				return err
+			}
+		} else if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	if s.Exprs != nil {
+		if _, err := leb128.WriteVarUint32(w, uint32(len(s.Exprs))); err != nil {
+			return err
+		}
+		for _, e := range s.Exprs {
+			if _, err := w.Write(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	if _, err := leb128.WriteVarUint32(w, uint32(len(s.Elems))); err != nil {
 		return err
@@ -778,6 +1047,14 @@ func (s *ElementSegment) MarshalWASM(w io.Writer) error {
 	return nil
 }
 
+// Len returns the number of elements in the segment.
+func (s *ElementSegment) Len() int {
+	if s.Exprs != nil {
+		return len(s.Exprs)
+	}
+	return len(s.Elems)
+}
+
 // SectionCode describes the body for every function declared inside a module.
 type SectionCode struct {
 	RawSection
@@ -789,22 +1066,58 @@ func (*SectionCode) SectionID() SectionID {
 }
 
 func (s *SectionCode) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	return s.readPayload(r, nil)
+}
+
+// readPayload reads the section payload, calling fn (if not nil) with each
+// function body as soon as it has been read.
+func (s *SectionCode) readPayload(r io.Reader, fn func(i int, body *FunctionBody) error) error {
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
 	s.Bodies = make([]FunctionBody, count)
 	logger.Printf("%d function bodies\n", count)
 
+	if workers := options(r).Workers; fn == nil && workers > 1 {
+		return s.readBodiesConcurrently(r, workers)
+	}
 	for i := range s.Bodies {
 		logger.Printf("Reading function %d\n", i)
 		if err = s.Bodies[i].UnmarshalWASM(r); err != nil {
 			return err
 		}
+		if fn != nil {
+			if err = fn(i, &s.Bodies[i]); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// readBodiesConcurrently reads the function bodies, which are split
+// sequentially but decoded by workers goroutines.
+func (s *SectionCode) readBodiesConcurrently(r io.Reader, workers int) error {
+	opts := options(r)
+	raw := make([][]byte, len(s.Bodies))
+	n := 0
+	var readErr error
+	for ; n < len(raw); n++ {
+		if raw[n], readErr = readBytesUint(r); readErr != nil {
+			break
+		}
+	}
+	// as when decoding sequentially, an invalid body is reported before
+	// the failure to read the following ones.
+	if err := parallel.Run(n, workers, func(i int) error {
+		return s.Bodies[i].decode(raw[i], opts)
+	}); err != nil {
+		return err
+	}
+	return readErr
+}
+
 func (s *SectionCode) WritePayload(w io.Writer) error {
 	if _, err := leb128.WriteVarUint32(w, uint32(len(s.Bodies))); err != nil {
 		return err
@@ -819,6 +1132,11 @@ func (s *SectionCode) WritePayload(w io.Writer) error {
 
 var ErrFunctionNoEnd = errors.New("Function body does not end with 0x0b (end)")
 
+// ErrTooManyLocals is returned when a function body declares more than
+// 2^32-1 local variables, or more entries of local variables than it has
+// bytes.
+var ErrTooManyLocals = errors.New("wasm: too many locals")
+
 type FunctionBody struct {
 	Module *Module // The parent module containing this function body, for execution purposes
 	Locals []LocalEntry
@@ -826,38 +1144,47 @@ type FunctionBody struct {
 }
 
 func (f *FunctionBody) UnmarshalWASM(r io.Reader) error {
-
-	bodySize, err := leb128.ReadVarUint32(r)
+	body, err := readBytesUint(r)
 	if err != nil {
 		return err
 	}
+	return f.decode(body, options(r))
+}
 
-	body := make([]byte, bodySize)
-
-	if _, err = io.ReadFull(r, body); err != nil {
-		return err
-	}
-
+// decode decodes the body of a function, without its size.
+func (f *FunctionBody) decode(body []byte, opts *DecodeOptions) error {
 	bytesReader := bytes.NewBuffer(body)
 
 	localCount, err := leb128.ReadVarUint32(bytesReader)
 	if err != nil {
 		return err
 	}
+	// each entry takes 2 bytes at least, which bounds the allocation.
+	if uint64(localCount) > uint64(bytesReader.Len())/2 {
+		return ErrTooManyLocals
+	}
+	if err := checkLimit("entries", uint64(localCount), int64(opts.MaxEntries)); err != nil {
+		return err
+	}
 	f.Locals = make([]LocalEntry, localCount)
 
+	var total uint64
 	for i := range f.Locals {
 		if err = f.Locals[i].UnmarshalWASM(bytesReader); err != nil {
 			return err
 		}
+		total += uint64(f.Locals[i].Count)
+	}
+	if total > math.MaxUint32 {
+		return ErrTooManyLocals
 	}
 
-	logger.Printf("bodySize: %d, localCount: %d\n", bodySize, localCount)
+	logger.Printf("bodySize: %d, localCount: %d\n", len(body), localCount)
 
 	code := bytesReader.Bytes()
 	logger.Printf("Read %d bytes for function body", len(code))
 
-	if code[len(code)-1] != end {
+	if len(code) == 0 || code[len(code)-1] != end {
 		return ErrFunctionNoEnd
 	}
 
@@ -925,7 +1252,7 @@ func (*SectionData) SectionID() SectionID {
 }
 
 func (s *SectionData) ReadPayload(r io.Reader) error {
-	count, err := leb128.ReadVarUint32(r)
+	count, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -952,34 +1279,100 @@ func (s *SectionData) WritePayload(w io.Writer) error {
 
 // DataSegment describes a group of repeated elements that begin at a specified offset in the linear memory
 type DataSegment struct {
-	Index  uint32 // The index into the global linear memory space, should always be 0 in the MVP.
-	Offset []byte // initializer expression for computing the offset for placing elements, should return an i32 value
+	Mode   SegmentMode // How the segment is used, either active or passive. Only active segments exist in the MVP.
+	Index  uint32      // The index into the global linear memory space, always 0 in the MVP.
+	Offset []byte      // initializer expression for computing the offset for placing elements, should return an i32 value. Nil for passive segments.
 	Data   []byte
 }
 
-func (s *DataSegment) UnmarshalWASM(r io.Reader) error {
-	var err error
+// flags used in the encoding of data segments
+const (
+	dataFlagActive      = 0x0 // active segment for memory 0
+	dataFlagPassive     = 0x1 // passive segment
+	dataFlagMemoryIndex = 0x2 // active segment with an explicit memory index
+)
 
-	if s.Index, err = leb128.ReadVarUint32(r); err != nil {
+func (s *DataSegment) UnmarshalWASM(r io.Reader) error {
+	flags, err := leb128.ReadVarUint32(r)
+	if err != nil {
 		return err
 	}
-	if s.Offset, err = readInitExpr(r); err != nil {
-		return err
+
+	s.Index = 0
+	s.Offset = nil
+	switch flags {
+	case dataFlagActive:
+		s.Mode = SegmentActive
+	case dataFlagPassive:
+		s.Mode = SegmentPassive
+	case dataFlagMemoryIndex:
+		s.Mode = SegmentActive
+		if s.Index, err = leb128.ReadVarUint32(r); err != nil {
+			return err
+		}
+	default:
+		return InvalidSegmentFlagsError(flags)
+	}
+	if s.Mode == SegmentActive {
+		if s.Offset, err = readInitExpr(r); err != nil {
+			return err
+		}
 	}
 	s.Data, err = readBytesUint(r)
 	return err
 }
 
 func (s *DataSegment) MarshalWASM(w io.Writer) error {
-	if _, err := leb128.WriteVarUint32(w, s.Index); err != nil {
-		return err
+	switch {
+	case s.Mode == SegmentPassive:
+		if _, err := leb128.WriteVarUint32(w, dataFlagPassive); err != nil {
+			return err
+		}
+	case s.Mode != SegmentActive:
+		return fmt.Errorf("wasm: invalid data segment mode %v", s.Mode)
+	case s.Index == 0:
+		if _, err := leb128.WriteVarUint32(w, dataFlagActive); err != nil {
+			return err
+		}
+	default:
+		if _, err := leb128.WriteVarUint32(w, dataFlagMemoryIndex); err != nil {
+			return err
+		}
+		if _, err := leb128.WriteVarUint32(w, s.Index); err != nil {
+			return err
+		}
 	}
-	if _, err := w.Write(s.Offset); err != nil {
-		return err
+	if s.Mode == SegmentActive {
+		if _, err := w.Write(s.Offset); err != nil {
+			return err
+		}
 	}
 	return writeBytesUint(w, s.Data)
 }
 
+// SectionDataCount holds the number of data segments declared in the data
+// section. It is required by modules that use the memory.init and data.drop
+// operators, so that they can be validated before the data section is read.
+type SectionDataCount struct {
+	RawSection
+	Count uint32
+}
+
+func (*SectionDataCount) SectionID() SectionID {
+	return SectionIDDataCount
+}
+
+func (s *SectionDataCount) ReadPayload(r io.Reader) error {
+	var err error
+	s.Count, err = leb128.ReadVarUint32(r)
+	return err
+}
+
+func (s *SectionDataCount) WritePayload(w io.Writer) error {
+	_, err := leb128.WriteVarUint32(w, s.Count)
+	return err
+}
+
 // A list of well-known custom sections
 const (
 	CustomSectionName = "name"
@@ -997,6 +1390,10 @@ const (
 	NameModule   = NameType(0)
 	NameFunction = NameType(1)
 	NameLocal    = NameType(2)
+
+	// NameGlobal is the subsection of global names introduced by the
+	// extended name section proposal.
+	NameGlobal = NameType(7)
 )
 
 // NameSection is a custom section that stores names of modules, functions and locals for debugging purposes.
@@ -1052,6 +1449,8 @@ func (s *NameSection) Decode(typ NameType) (NameSubsection, error) {
 		sub = &FunctionNames{}
 	case NameLocal:
 		sub = &LocalNames{}
+	case NameGlobal:
+		sub = &GlobalNames{}
 	default:
 		return nil, fmt.Errorf("unsupported name subsection: %x", typ)
 	}
@@ -1068,9 +1467,10 @@ func (s *NameSection) Decode(typ NameType) (NameSubsection, error) {
 // NameSubsection is an interface for subsections of NameSection.
 //
 // Valid types:
-//	* ModuleName
-//	* FunctionNames
-//	* LocalNames
+//   - ModuleName
+//   - FunctionNames
+//   - LocalNames
+//   - GlobalNames
 type NameSubsection interface {
 	Marshaler
 	Unmarshaler
@@ -1146,6 +1546,9 @@ func (s *LocalNames) MarshalWASM(w io.Writer) error {
 	sort.Slice(keys, func(i, j int) bool {
 		return keys[i] < keys[j]
 	})
+	if _, err := leb128.WriteVarUint32(w, uint32(len(keys))); err != nil {
+		return err
+	}
 	for _, k := range keys {
 		m := s.Funcs[k]
 		if _, err := leb128.WriteVarUint32(w, k); err != nil {
@@ -1158,6 +1561,22 @@ func (s *LocalNames) MarshalWASM(w io.Writer) error {
 	return nil
 }
 
+// GlobalNames is a set of names for globals.
+type GlobalNames struct {
+	Names NameMap
+}
+
+func (*GlobalNames) isNameSubsection() {}
+
+func (s *GlobalNames) UnmarshalWASM(r io.Reader) error {
+	s.Names = make(NameMap)
+	return s.Names.UnmarshalWASM(r)
+}
+
+func (s *GlobalNames) MarshalWASM(w io.Writer) error {
+	return s.Names.MarshalWASM(w)
+}
+
 var (
 	_ Marshaler   = (NameMap)(nil)
 	_ Unmarshaler = (NameMap)(nil)
@@ -1192,6 +1611,9 @@ func (m NameMap) MarshalWASM(w io.Writer) error {
 	sort.Slice(keys, func(i, j int) bool {
 		return keys[i] < keys[j]
 	})
+	if _, err := leb128.WriteVarUint32(w, uint32(len(keys))); err != nil {
+		return err
+	}
 	for _, k := range keys {
 		name := m[k]
 		if _, err := leb128.WriteVarUint32(w, k); err != nil {
@@ -0,0 +1,46 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import "io"
+
+// StreamHandler holds the callbacks used by DecodeModuleStream to report
+// the parts of a module as they are decoded. Any of them may be nil.
+// An error returned by a callback stops the decoding, and is returned by
+// DecodeModuleStream.
+type StreamHandler struct {
+	// SkipSection is called with the ID of every section before its payload
+	// is read. If it returns true, the payload is discarded without being
+	// decoded, and the section is missing from the decoded module.
+	// Skipping the type or function sections while keeping the code
+	// section makes the decoding of the latter fail.
+	SkipSection func(id SectionID) bool
+
+	// Section is called with every section once its payload has been
+	// decoded. Earlier sections are available through m.
+	Section func(m *Module, s Section) error
+
+	// FunctionBody is called with every function body of the code section
+	// as soon as it has been decoded, before the remaining bodies are read.
+	// index is the index of the body in the code section, which is offset
+	// by the number of imported functions in the function index space.
+	FunctionBody func(m *Module, index int, body *FunctionBody) error
+}
+
+// DecodeModuleStream decodes a module from r, in the same way as DecodeModule,
+// but reports each section and function body to h as soon as it has been
+// read, so that a module can be processed while it is still being received.
+//
+// Unlike DecodeModule, DecodeModuleStream does not keep a copy of the raw
+// contents of each section: the Bytes field of their RawSection is nil.
+func DecodeModuleStream(r io.Reader, h StreamHandler) (*Module, error) {
+	return DecodeModuleStreamWithOptions(r, h, noLimits)
+}
+
+// DecodeModuleStreamWithOptions is the same as DecodeModuleStream, with the
+// limits set by opts.
+func DecodeModuleStreamWithOptions(r io.Reader, h StreamHandler, opts DecodeOptions) (*Module, error) {
+	return decodeModule(r, &h, opts)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestDecodeModuleStream(t *testing.T) {
+	for _, dir := range testPaths {
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fname := range fnames {
+			name := fname
+			t.Run(filepath.Base(name), func(t *testing.T) {
+				raw, err := ioutil.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := wasm.DecodeModule(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("error reading module %v", err)
+				}
+
+				var (
+					ids    []wasm.SectionID
+					bodies [][]byte
+				)
+				m, err := wasm.DecodeModuleStream(iotest.OneByteReader(bytes.NewReader(raw)), wasm.StreamHandler{
+					Section: func(m *wasm.Module, s wasm.Section) error {
+						ids = append(ids, s.SectionID())
+						return nil
+					},
+					FunctionBody: func(m *wasm.Module, i int, body *wasm.FunctionBody) error {
+						if i != len(bodies) {
+							t.Fatalf("function body %d reported out of order", i)
+						}
+						if body.Module != m {
+							t.Fatalf("function body %d has no parent module", i)
+						}
+						bodies = append(bodies, body.Code)
+						return nil
+					},
+				})
+				if err != nil {
+					t.Fatalf("error streaming module %v", err)
+				}
+
+				if len(ids) != len(want.Sections) {
+					t.Fatalf("got %d sections, want %d", len(ids), len(want.Sections))
+				}
+				for i, s := range want.Sections {
+					if ids[i] != s.SectionID() {
+						t.Fatalf("section %d: got id %v, want %v", i, ids[i], s.SectionID())
+					}
+				}
+				if want.Code != nil {
+					if len(bodies) != len(want.Code.Bodies) {
+						t.Fatalf("got %d function bodies, want %d", len(bodies), len(want.Code.Bodies))
+					}
+					for i, b := range want.Code.Bodies {
+						if !bytes.Equal(bodies[i], b.Code) {
+							t.Fatalf("function body %d differs", i)
+						}
+					}
+				}
+				if want.Types != nil && !reflect.DeepEqual(m.Types.Entries, want.Types.Entries) {
+					t.Fatal("type sections are different")
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeModuleStreamSkip(t *testing.T) {
+	raw, err := ioutil.ReadFile("../exec/testdata/basic.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.DecodeModuleStream(bytes.NewReader(raw), wasm.StreamHandler{
+		SkipSection: func(id wasm.SectionID) bool {
+			return id == wasm.SectionIDCode || id == wasm.SectionIDExport
+		},
+		FunctionBody: func(*wasm.Module, int, *wasm.FunctionBody) error {
+			t.Fatal("function body of a skipped code section was decoded")
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("error streaming module: %v", err)
+	}
+	if m.Code != nil || m.Export != nil {
+		t.Fatal("skipped sections were decoded")
+	}
+	if m.Types == nil || m.Function == nil {
+		t.Fatal("sections were wrongly skipped")
+	}
+}
+
+func TestDecodeModuleStreamError(t *testing.T) {
+	raw, err := ioutil.ReadFile("../exec/testdata/basic.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errStop := errors.New("stop")
+	_, err = wasm.DecodeModuleStream(bytes.NewReader(raw), wasm.StreamHandler{
+		FunctionBody: func(*wasm.Module, int, *wasm.FunctionBody) error {
+			return errStop
+		},
+	})
+	if err != errStop {
+		t.Fatalf("unexpected error: got=%v, want=%v", err, errStop)
+	}
+}
@@ -5,8 +5,10 @@
 package wasm
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/go-interpreter/wagon/wasm/leb128"
 )
@@ -29,6 +31,13 @@ const (
 	ValueTypeI64 ValueType = -0x02
 	ValueTypeF32 ValueType = -0x03
 	ValueTypeF64 ValueType = -0x04
+
+	// ValueTypeV128 is the 128-bit vector type of the SIMD proposal.
+	ValueTypeV128 ValueType = -0x05
+
+	// Reference types, introduced by the reference types proposal.
+	ValueTypeFuncRef   ValueType = -0x10
+	ValueTypeExternRef ValueType = -0x11
 )
 
 var valueTypeStrMap = map[ValueType]string{
@@ -36,6 +45,11 @@ var valueTypeStrMap = map[ValueType]string{
 	ValueTypeI64: "i64",
 	ValueTypeF32: "f32",
 	ValueTypeF64: "f64",
+
+	ValueTypeV128: "v128",
+
+	ValueTypeFuncRef:   "funcref",
+	ValueTypeExternRef: "externref",
 }
 
 func (t ValueType) String() string {
@@ -46,6 +60,11 @@ func (t ValueType) String() string {
 	return str
 }
 
+// IsRef returns whether t is a reference type.
+func (t ValueType) IsRef() bool {
+	return t == ValueTypeFuncRef || t == ValueTypeExternRef
+}
+
 // TypeFunc represents the value type of a function
 const TypeFunc int = -0x20
 
@@ -76,8 +95,14 @@ func (b BlockType) String() string {
 
 // ElemType describes the type of a table's elements
 type ElemType int // varint7
-// ElemTypeAnyFunc descibres an any_func value
-const ElemTypeAnyFunc ElemType = -0x10
+
+const (
+	// ElemTypeAnyFunc descibres an any_func value
+	ElemTypeAnyFunc ElemType = -0x10
+	// ElemTypeExternRef describes an externref value, an opaque reference
+	// to a host value.
+	ElemTypeExternRef ElemType = -0x11
+)
 
 func (t *ElemType) UnmarshalWASM(r io.Reader) error {
 	b, err := leb128.ReadVarint32(r)
@@ -94,8 +119,11 @@ func (t ElemType) MarshalWASM(w io.Writer) error {
 }
 
 func (t ElemType) String() string {
-	if t == ElemTypeAnyFunc {
+	switch t {
+	case ElemTypeAnyFunc:
 		return "anyfunc"
+	case ElemTypeExternRef:
+		return "externref"
 	}
 
 	return "<unknown elem_type>"
@@ -130,7 +158,7 @@ func (f *FunctionSig) UnmarshalWASM(r io.Reader) error {
 	}
 	f.Form = int8(form)
 
-	paramCount, err := leb128.ReadVarUint32(r)
+	paramCount, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -143,7 +171,7 @@ func (f *FunctionSig) UnmarshalWASM(r io.Reader) error {
 		}
 	}
 
-	returnCount, err := leb128.ReadVarUint32(r)
+	returnCount, err := readCount(r, 1)
 	if err != nil {
 		return err
 	}
@@ -269,6 +297,41 @@ func (m *Memory) MarshalWASM(w io.Writer) error {
 	return m.Limits.MarshalWASM(w)
 }
 
+// ErrInvalidTagAttribute is returned when decoding a tag whose attribute
+// is not TagAttributeException.
+var ErrInvalidTagAttribute = errors.New("wasm: invalid tag attribute")
+
+// TagAttributeException is the attribute of the tags of exceptions, which
+// are the only kind of tags for now.
+const TagAttributeException uint8 = 0
+
+// Tag declares the type of the exceptions thrown and caught with it.
+type Tag struct {
+	Attribute uint8
+	Type      uint32 // index into the type section, of a function type without results
+}
+
+func (t *Tag) UnmarshalWASM(r io.Reader) error {
+	attr, err := readBytes(r, 1)
+	if err != nil {
+		return err
+	}
+	t.Attribute = attr[0]
+	if t.Attribute != TagAttributeException {
+		return ErrInvalidTagAttribute
+	}
+	t.Type, err = leb128.ReadVarUint32(r)
+	return err
+}
+
+func (t *Tag) MarshalWASM(w io.Writer) error {
+	if _, err := w.Write([]byte{t.Attribute}); err != nil {
+		return err
+	}
+	_, err := leb128.WriteVarUint32(w, t.Type)
+	return err
+}
+
 // External describes the kind of the entry being imported or exported.
 type External uint8
 
@@ -277,6 +340,9 @@ const (
 	ExternalTable    External = 1
 	ExternalMemory   External = 2
 	ExternalGlobal   External = 3
+	// ExternalTag is the kind of tags, introduced by the exception
+	// handling proposal.
+	ExternalTag External = 4
 )
 
 func (e External) String() string {
@@ -289,6 +355,8 @@ func (e External) String() string {
 		return "memory"
 	case ExternalGlobal:
 		return "global"
+	case ExternalTag:
+		return "tag"
 	default:
 		return "<unknown external_kind>"
 	}
@@ -308,9 +376,55 @@ func (e External) MarshalWASM(w io.Writer) error {
 
 // ResizableLimits describe the limit of a table or linear memory.
 type ResizableLimits struct {
-	Flags   uint32 // 1 if the Maximum field is valid
+	Flags   uint32 // bit 0 is set if the Maximum field is valid, bit 1 if the memory is shared, bit 2 if it is 64-bit
 	Initial uint32 // initial length (in units of table elements or wasm pages)
-	Maximum uint32 // If flags is 1, it describes the maximum size of the table or memory
+	Maximum uint32 // If bit 0 of flags is set, it describes the maximum size of the table or memory
+}
+
+// ErrSharedMemoryNoMax is returned while decoding the limits of a shared
+// memory without a maximum size.
+var ErrSharedMemoryNoMax = errors.New("wasm: shared memory must have a maximum size")
+
+// Shared returns whether the limits are those of a shared linear memory,
+// as introduced by the threads proposal.
+func (lim ResizableLimits) Shared() bool {
+	return lim.Flags&0x2 != 0
+}
+
+// ErrMemory64LimitTooLarge is returned while decoding the limits of a
+// 64-bit memory whose initial or maximum size doesn't fit in 32 bits.
+var ErrMemory64LimitTooLarge = errors.New("wasm: memory64 limits of more than 2^32 pages are not supported")
+
+// Is64 returns whether the limits are those of a linear memory indexed by
+// i64 addresses, as introduced by the memory64 proposal.
+func (lim ResizableLimits) Is64() bool {
+	return lim.Flags&0x4 != 0
+}
+
+// readLimit reads the initial or maximum size of the limits, which is
+// encoded as a varuint64 for 64-bit memories.
+func (lim ResizableLimits) readLimit(r io.Reader) (uint32, error) {
+	if !lim.Is64() {
+		return leb128.ReadVarUint32(r)
+	}
+	v, err := leb128.ReadVarUint64(r)
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint32 {
+		return 0, ErrMemory64LimitTooLarge
+	}
+	return uint32(v), nil
+}
+
+func (lim ResizableLimits) writeLimit(w io.Writer, v uint32) error {
+	var err error
+	if lim.Is64() {
+		_, err = leb128.WriteVarUint64(w, uint64(v))
+	} else {
+		_, err = leb128.WriteVarUint32(w, v)
+	}
+	return err
 }
 
 func (lim *ResizableLimits) UnmarshalWASM(r io.Reader) error {
@@ -320,14 +434,17 @@ func (lim *ResizableLimits) UnmarshalWASM(r io.Reader) error {
 		return err
 	}
 	lim.Flags = f
+	if lim.Shared() && lim.Flags&0x1 == 0 {
+		return ErrSharedMemoryNoMax
+	}
 
-	lim.Initial, err = leb128.ReadVarUint32(r)
+	lim.Initial, err = lim.readLimit(r)
 	if err != nil {
 		return err
 	}
 
 	if lim.Flags&0x1 != 0 {
-		m, err := leb128.ReadVarUint32(r)
+		m, err := lim.readLimit(r)
 		if err != nil {
 			return err
 		}
@@ -340,11 +457,11 @@ func (lim *ResizableLimits) MarshalWASM(w io.Writer) error {
 	if _, err := leb128.WriteVarUint32(w, uint32(lim.Flags)); err != nil {
 		return err
 	}
-	if _, err := leb128.WriteVarUint32(w, uint32(lim.Initial)); err != nil {
+	if err := lim.writeLimit(w, lim.Initial); err != nil {
 		return err
 	}
 	if lim.Flags&0x1 != 0 {
-		if _, err := leb128.WriteVarUint32(w, uint32(lim.Maximum)); err != nil {
+		if err := lim.writeLimit(w, lim.Maximum); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,756 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"math"
+	"strings"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// opNames maps the names of the operators to their Op values. Operators
+// are known both by the names given by the operators package and by their
+// names in the current text format, such as local.get for get_local or
+// i32.wrap_i64 for i32.wrap/i64.
+var opNames = make(map[string]ops.Op)
+
+func init() {
+	add := func(name string, op ops.Op) {
+		if _, ok := opNames[name]; !ok {
+			opNames[name] = op
+		}
+	}
+	for code := 0; code < 256; code++ {
+		op, err := ops.New(byte(code))
+		if err != nil {
+			continue
+		}
+		add(op.Name, op)
+		// i32.trunc_s/f32 is i32.trunc_f32_s, f32.demote/f64 is
+		// f32.demote_f64.
+		if i := strings.IndexByte(op.Name, '/'); i >= 0 {
+			name, from, sign := op.Name[:i], op.Name[i+1:], ""
+			if strings.HasSuffix(name, "_s") || strings.HasSuffix(name, "_u") {
+				name, sign = name[:len(name)-2], name[len(name)-2:]
+			}
+			add(name+"_"+from+sign, op)
+		}
+	}
+	for _, prefix := range []byte{ops.MiscPrefix, ops.SIMDPrefix, ops.AtomicPrefix} {
+		for sub := uint32(0); sub < 0x200; sub++ {
+			if op, err := ops.NewPrefixed(prefix, sub); err == nil {
+				add(op.Name, op)
+			}
+		}
+	}
+	for name, alias := range map[string]string{
+		"local.get":      "get_local",
+		"local.set":      "set_local",
+		"local.tee":      "tee_local",
+		"global.get":     "get_global",
+		"global.set":     "set_global",
+		"current_memory": "memory.size",
+		"grow_memory":    "memory.grow",
+	} {
+		add(name, opNames[alias])
+	}
+}
+
+// opcode returns the Op value of a non-prefixed operator.
+func opcode(code byte) ops.Op {
+	op, err := ops.New(code)
+	if err != nil {
+		panic(err)
+	}
+	return op
+}
+
+// codeParser assembles the instructions of a function body or of an
+// initializer expression.
+type codeParser struct {
+	p      *moduleParser
+	locals map[string]uint32 // the indices of the named parameters and locals
+	labels []string          // the labels of the enclosing blocks, innermost last
+	instrs []disasm.Instr
+}
+
+func (c *codeParser) emit(op ops.Op, imms ...interface{}) {
+	c.instrs = append(c.instrs, disasm.Instr{Op: op, Immediates: imms})
+}
+
+// parseInstrs parses a sequence of plain and folded instructions.
+func (c *codeParser) parseInstrs(nodes []*node) error {
+	for len(nodes) > 0 {
+		var err error
+		if nodes[0].isList {
+			err = c.parseFolded(nodes[0])
+			nodes = nodes[1:]
+		} else {
+			nodes, err = c.parsePlain(nodes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePlain parses the plain instruction starting the sequence nodes,
+// and returns the rest of the sequence.
+func (c *codeParser) parsePlain(nodes []*node) ([]*node, error) {
+	n, args := nodes[0], nodes[1:]
+	if n.isStr {
+		return nil, n.errorf("expected an instruction")
+	}
+	switch n.atom {
+	case "block", "loop", "if", "try":
+		label, args := takeID(args)
+		bt, args, err := c.parseBlockType(args)
+		if err != nil {
+			return nil, err
+		}
+		c.emit(opNames[n.atom], bt)
+		c.labels = append(c.labels, label)
+		return args, nil
+	case "end", "else", "catch", "catch_all", "delegate":
+		if len(c.labels) == 0 {
+			return nil, n.errorf("unexpected %s", n.atom)
+		}
+	}
+
+	switch n.atom {
+	case "end", "else":
+		if n.atom == "end" {
+			c.emit(opcode(ops.End))
+		} else {
+			c.emit(opcode(ops.Else))
+		}
+		label := c.labels[len(c.labels)-1]
+		if n.atom == "end" {
+			c.labels = c.labels[:len(c.labels)-1]
+		}
+		if len(args) > 0 && args[0].isID() {
+			if args[0].atom != label {
+				return nil, args[0].errorf("mismatched label %s", args[0].atom)
+			}
+			args = args[1:]
+		}
+		return args, nil
+	case "delegate":
+		// the label of delegate is outside of the try block it ends.
+		c.labels = c.labels[:len(c.labels)-1]
+		if len(args) == 0 {
+			return nil, n.errorf("expected a label")
+		}
+		depth, err := c.label(args[0])
+		if err != nil {
+			return nil, err
+		}
+		c.emit(opcode(ops.Delegate), depth)
+		return args[1:], nil
+	}
+
+	ins, args, err := c.parseOp(n, args)
+	if err != nil {
+		return nil, err
+	}
+	c.instrs = append(c.instrs, ins)
+	return args, nil
+}
+
+// parseFolded parses a folded instruction, whose operands are themselves
+// folded instructions.
+func (c *codeParser) parseFolded(n *node) error {
+	kw := n.keyword()
+	if kw == "" {
+		return n.errorf("expected an instruction")
+	}
+	args := n.list[1:]
+
+	switch kw {
+	case "block", "loop", "if", "try":
+		label, args := takeID(args)
+		bt, args, err := c.parseBlockType(args)
+		if err != nil {
+			return err
+		}
+		op := opNames[kw]
+		switch kw {
+		case "block", "loop":
+			return c.parseBlock(op, bt, label, args, nil)
+		case "if":
+			// the condition is outside of the block.
+			for len(args) > 0 && args[0].keyword() != "then" {
+				if !args[0].isList {
+					return args[0].errorf("expected the condition of if")
+				}
+				if err := c.parseFolded(args[0]); err != nil {
+					return err
+				}
+				args = args[1:]
+			}
+			if len(args) == 0 {
+				return n.errorf("expected then")
+			}
+			return c.parseBlock(op, bt, label, args[0].list[1:], args[1:])
+		default:
+			if len(args) == 0 || args[0].keyword() != "do" {
+				return n.errorf("expected do")
+			}
+			return c.parseBlock(op, bt, label, args[0].list[1:], args[1:])
+		}
+	case "then", "else", "do", "catch", "catch_all", "delegate":
+		return n.errorf("unexpected %s", kw)
+	}
+
+	ins, args, err := c.parseOp(n.list[0], args)
+	if err != nil {
+		return err
+	}
+	for len(args) > 0 && args[0].isList {
+		if err := c.parseFolded(args[0]); err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+	c.instrs = append(c.instrs, ins)
+	// initializer expressions are also written as the instructions of a
+	// sequence following the first one, as in (i32.const 1 i32.const 2
+	// i32.add).
+	return c.parseInstrs(args)
+}
+
+// parseBlock parses the body of a folded block, loop, if or try, and the
+// clauses following its body: else for if, and catch, catch_all or
+// delegate for try.
+func (c *codeParser) parseBlock(op ops.Op, bt wasm.BlockType, label string, body, clauses []*node) error {
+	c.emit(op, bt)
+	c.labels = append(c.labels, label)
+	if err := c.parseInstrs(body); err != nil {
+		return err
+	}
+	for i, cl := range clauses {
+		kw := cl.keyword()
+		args := cl.list
+		switch {
+		case kw == "else" && op.Code == ops.If && i == 0:
+			// like wabt, an empty else clause is omitted.
+			if len(args) > 1 {
+				c.emit(opcode(ops.Else))
+			}
+		case kw == "catch" && op.Code == ops.Try:
+			if len(args) < 2 {
+				return cl.errorf("expected a tag")
+			}
+			tag, err := c.p.tags.index(args[1])
+			if err != nil {
+				return err
+			}
+			c.emit(opcode(ops.Catch), tag)
+			args = args[1:]
+		case kw == "catch_all" && op.Code == ops.Try:
+			c.emit(opcode(ops.CatchAll))
+		case kw == "delegate" && op.Code == ops.Try && i == len(clauses)-1:
+			c.labels = c.labels[:len(c.labels)-1]
+			if len(args) != 2 {
+				return cl.errorf("expected a label")
+			}
+			depth, err := c.label(args[1])
+			if err != nil {
+				return err
+			}
+			c.emit(opcode(ops.Delegate), depth)
+			return nil
+		default:
+			return cl.errorf("unexpected %v", cl)
+		}
+		if err := c.parseInstrs(args[1:]); err != nil {
+			return err
+		}
+	}
+	c.labels = c.labels[:len(c.labels)-1]
+	c.emit(opcode(ops.End))
+	return nil
+}
+
+// parseBlockType parses the type of a block, loop, if or try. Only block
+// types with at most one result and no parameters can be encoded.
+func (c *codeParser) parseBlockType(args []*node) (wasm.BlockType, []*node, error) {
+	if len(args) > 0 && args[0].keyword() == "type" {
+		n := args[0]
+		t, _, args, err := c.p.parseTypeUse(args)
+		if err != nil {
+			return 0, nil, err
+		}
+		sig := c.p.m.Types.Entries[t]
+		switch {
+		case len(sig.ParamTypes) != 0 || len(sig.ReturnTypes) > 1:
+			return 0, nil, n.errorf("block types with parameters or several results are not supported")
+		case len(sig.ReturnTypes) == 1:
+			return wasm.BlockType(sig.ReturnTypes[0]), args, nil
+		}
+		return wasm.BlockTypeEmpty, args, nil
+	}
+
+	var results []wasm.ValueType
+	for len(args) > 0 && (args[0].keyword() == "result" || args[0].keyword() == "param") {
+		if args[0].keyword() == "param" {
+			return 0, nil, args[0].errorf("block types with parameters are not supported")
+		}
+		types, err := parseValueTypes(args[0].list[1:])
+		if err != nil {
+			return 0, nil, err
+		}
+		results = append(results, types...)
+		args = args[1:]
+	}
+	switch len(results) {
+	case 0:
+		return wasm.BlockTypeEmpty, args, nil
+	case 1:
+		return wasm.BlockType(results[0]), args, nil
+	}
+	return 0, nil, args[0].errorf("block types with several results are not supported")
+}
+
+// label returns the relative depth of the block with the label n, given
+// either as an identifier or as a depth.
+func (c *codeParser) label(n *node) (uint32, error) {
+	if !n.isID() {
+		v, err := parseUint(n.atom, 32)
+		if err != nil || n.isList || n.isStr {
+			return 0, n.errorf("expected a label")
+		}
+		return uint32(v), nil
+	}
+	for i := len(c.labels) - 1; i >= 0; i-- {
+		if c.labels[i] == n.atom {
+			return uint32(len(c.labels) - 1 - i), nil
+		}
+	}
+	return 0, n.errorf("unknown label %s", n.atom)
+}
+
+// local returns the index of the parameter or local n.
+func (c *codeParser) local(n *node) (uint32, error) {
+	if n.isID() {
+		i, ok := c.locals[n.atom]
+		if !ok {
+			return 0, n.errorf("unknown local %s", n.atom)
+		}
+		return i, nil
+	}
+	return parseIndex(n, "local")
+}
+
+// memory returns the index of the linear memory n, which is encoded as an
+// uint8 value.
+func (c *codeParser) memory(n *node) (uint8, error) {
+	i, err := c.p.mems.index(n)
+	if err != nil {
+		return 0, err
+	}
+	if i > math.MaxUint8 {
+		return 0, n.errorf("memory index %d out of range", i)
+	}
+	return uint8(i), nil
+}
+
+// parseOp parses the operator name and its immediates, which are at the
+// beginning of args, and returns the rest of args.
+func (c *codeParser) parseOp(name *node, args []*node) (disasm.Instr, []*node, error) {
+	op, ok := opNames[name.atom]
+	if !ok || name.isStr || name.isList {
+		return disasm.Instr{}, nil, name.errorf("unknown operator %v", name)
+	}
+	ins := disasm.Instr{Op: op}
+	// arg returns the next immediate, which must be an atom.
+	arg := func() (*node, error) {
+		if len(args) == 0 || args[0].isList || args[0].isStr {
+			return nil, name.errorf("missing immediate of %s", name.atom)
+		}
+		n := args[0]
+		args = args[1:]
+		return n, nil
+	}
+	// index resolves the next immediate in the index space s.
+	index := func(s *space) (uint32, error) {
+		n, err := arg()
+		if err != nil {
+			return 0, err
+		}
+		return s.index(n)
+	}
+	// optIndex resolves the next immediate in the index space s, or
+	// returns 0 if it is omitted.
+	optIndex := func(s *space) (uint32, error) {
+		if len(args) == 0 || !isIndex(args[0]) {
+			return 0, nil
+		}
+		return index(s)
+	}
+
+	var err error
+	switch op.Code {
+	case ops.Br, ops.BrIf, ops.Rethrow:
+		var n *node
+		if n, err = arg(); err == nil {
+			var depth uint32
+			depth, err = c.label(n)
+			ins.Immediates = append(ins.Immediates, depth)
+		}
+	case ops.BrTable:
+		var depths []interface{}
+		for len(args) > 0 && isIndex(args[0]) {
+			depth, err := c.label(args[0])
+			if err != nil {
+				return ins, nil, err
+			}
+			depths = append(depths, depth)
+			args = args[1:]
+		}
+		if len(depths) == 0 {
+			return ins, nil, name.errorf("expected a label")
+		}
+		ins.Immediates = append([]interface{}{uint32(len(depths) - 1)}, depths...)
+	case ops.Call, ops.ReturnCall, ops.RefFunc:
+		var f uint32
+		f, err = index(&c.p.funcs)
+		ins.Immediates = append(ins.Immediates, f)
+	case ops.CallIndirect, ops.ReturnCallIndirect:
+		var t, table uint32
+		if len(args) > 0 && isIndex(args[0]) && (len(args) < 2 || args[1].keyword() != "type") {
+			// the MVP syntax, with the type index only.
+			t, err = index(&c.p.types)
+		} else {
+			if table, err = optIndex(&c.p.tables); err == nil {
+				t, _, args, err = c.p.parseTypeUse(args)
+			}
+		}
+		ins.Immediates = append(ins.Immediates, t, table)
+	case ops.GetLocal, ops.SetLocal, ops.TeeLocal:
+		var n *node
+		if n, err = arg(); err == nil {
+			var l uint32
+			l, err = c.local(n)
+			ins.Immediates = append(ins.Immediates, l)
+		}
+	case ops.GetGlobal, ops.SetGlobal:
+		var g uint32
+		g, err = index(&c.p.globals)
+		ins.Immediates = append(ins.Immediates, g)
+	case ops.TableGet, ops.TableSet:
+		var table uint32
+		table, err = optIndex(&c.p.tables)
+		ins.Immediates = append(ins.Immediates, table)
+	case ops.Throw, ops.Catch:
+		var tag uint32
+		tag, err = index(&c.p.tags)
+		ins.Immediates = append(ins.Immediates, tag)
+	case ops.RefNull:
+		var n *node
+		if n, err = arg(); err == nil {
+			t, ok := refTypes[n.atom]
+			if !ok {
+				return ins, nil, n.errorf("invalid heap type %s", n.atom)
+			}
+			ins.Immediates = append(ins.Immediates, t)
+		}
+	case ops.Select:
+		var types []interface{}
+		for len(args) > 0 && args[0].keyword() == "result" {
+			ts, err := parseValueTypes(args[0].list[1:])
+			if err != nil {
+				return ins, nil, err
+			}
+			for _, t := range ts {
+				types = append(types, t)
+			}
+			args = args[1:]
+		}
+		if types != nil {
+			ins.Op = opcode(ops.TypedSelect)
+			ins.Immediates = append([]interface{}{uint32(len(types))}, types...)
+		}
+	case ops.I32Const, ops.I64Const, ops.F32Const, ops.F64Const:
+		var n *node
+		if n, err = arg(); err != nil {
+			break
+		}
+		var bits uint64
+		switch op.Code {
+		case ops.I32Const:
+			if bits, err = parseInt(n.atom, 32); err == nil {
+				ins.Immediates = append(ins.Immediates, int32(bits))
+			}
+		case ops.I64Const:
+			if bits, err = parseInt(n.atom, 64); err == nil {
+				ins.Immediates = append(ins.Immediates, int64(bits))
+			}
+		case ops.F32Const:
+			if bits, err = parseFloat(n.atom, 32); err == nil {
+				ins.Immediates = append(ins.Immediates, math.Float32frombits(uint32(bits)))
+			}
+		case ops.F64Const:
+			if bits, err = parseFloat(n.atom, 64); err == nil {
+				ins.Immediates = append(ins.Immediates, math.Float64frombits(bits))
+			}
+		}
+		if err != nil {
+			err = n.errorf("invalid constant %s", n.atom)
+		}
+	case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load,
+		ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u,
+		ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u,
+		ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store,
+		ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
+		ins.Immediates, args, err = c.parseMemArg(args, naturalAlignment(op.Code), false)
+	case ops.CurrentMemory, ops.GrowMemory:
+		var mem uint8
+		if len(args) > 0 && isIndex(args[0]) {
+			mem, err = c.memory(args[0])
+			args = args[1:]
+		}
+		ins.Immediates = append(ins.Immediates, mem)
+	case ops.MiscPrefix:
+		ins.Immediates, args, err = c.parseMiscImmediates(name, op.Sub, args)
+	case ops.SIMDPrefix:
+		ins.Immediates, args, err = c.parseSIMDImmediates(name, op.Sub, args)
+	case ops.AtomicPrefix:
+		if op.Sub == ops.AtomicFence {
+			ins.Immediates = append(ins.Immediates, uint8(0))
+			break
+		}
+		ins.Immediates, args, err = c.parseMemArg(args, disasm.AtomicAlignment(op.Sub), false)
+	}
+	if err != nil {
+		return ins, nil, err
+	}
+	return ins, args, nil
+}
+
+// parseMemArg parses the optional memory index, offset and alignment of
+// an operator accessing a linear memory, and returns them as the
+// immediates expected by disasm.Assemble. If lane is set, the memory
+// access is followed by a lane index, so that a single index is the lane
+// index and not the memory index.
+func (c *codeParser) parseMemArg(args []*node, natural uint32, lane bool) ([]interface{}, []*node, error) {
+	var mem uint8
+	if len(args) > 0 && isIndex(args[0]) &&
+		(!lane || args[0].isID() || len(args) > 1 && (isIndex(args[1]) || isMemArg(args[1]))) {
+		var err error
+		if mem, err = c.memory(args[0]); err != nil {
+			return nil, nil, err
+		}
+		args = args[1:]
+	}
+	offset, align := uint64(0), natural
+	if len(args) > 0 && isMemArg(args[0]) && strings.HasPrefix(args[0].atom, "offset=") {
+		v, err := parseUint(args[0].atom[len("offset="):], 64)
+		if err != nil {
+			return nil, nil, args[0].errorf("invalid offset %s", args[0].atom)
+		}
+		offset = v
+		args = args[1:]
+	}
+	if len(args) > 0 && isMemArg(args[0]) && strings.HasPrefix(args[0].atom, "align=") {
+		v, err := parseUint(args[0].atom[len("align="):], 32)
+		if err != nil || v == 0 || v&(v-1) != 0 {
+			return nil, nil, args[0].errorf("invalid alignment %s", args[0].atom)
+		}
+		for align = 0; v > 1; v >>= 1 {
+			align++
+		}
+		args = args[1:]
+	}
+	return []interface{}{align, offset, mem}, args, nil
+}
+
+// parseMiscImmediates parses the immediates of the operator prefixed by
+// ops.MiscPrefix with the sub-opcode sub. The text format puts the
+// optional memory or table index first, unlike the binary format.
+func (c *codeParser) parseMiscImmediates(name *node, sub uint32, args []*node) ([]interface{}, []*node, error) {
+	// indices returns the indices at the beginning of args, which has at
+	// most max of them.
+	indices := func(max int) []*node {
+		n := 0
+		for n < max && n < len(args) && isIndex(args[n]) {
+			n++
+		}
+		idx := args[:n]
+		args = args[n:]
+		return idx
+	}
+	var imms []interface{}
+	switch sub {
+	case ops.MemoryInit:
+		c.p.dataCount = true
+		idx := indices(2)
+		if len(idx) == 0 {
+			return nil, nil, name.errorf("missing immediate of %s", name.atom)
+		}
+		data, err := c.p.datas.index(idx[len(idx)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		var mem uint8
+		if len(idx) == 2 {
+			if mem, err = c.memory(idx[0]); err != nil {
+				return nil, nil, err
+			}
+		}
+		imms = append(imms, data, mem)
+	case ops.DataDrop, ops.ElemDrop:
+		s := &c.p.elems
+		if sub == ops.DataDrop {
+			c.p.dataCount = true
+			s = &c.p.datas
+		}
+		idx := indices(1)
+		if len(idx) == 0 {
+			return nil, nil, name.errorf("missing immediate of %s", name.atom)
+		}
+		i, err := s.index(idx[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		imms = append(imms, i)
+	case ops.MemoryCopy, ops.MemoryFill:
+		n := 2
+		if sub == ops.MemoryFill {
+			n = 1
+		}
+		idx := indices(n)
+		for i := 0; i < n; i++ {
+			var mem uint8
+			if len(idx) == n {
+				var err error
+				if mem, err = c.memory(idx[i]); err != nil {
+					return nil, nil, err
+				}
+			}
+			imms = append(imms, mem)
+		}
+	case ops.TableInit:
+		idx := indices(2)
+		if len(idx) == 0 {
+			return nil, nil, name.errorf("missing immediate of %s", name.atom)
+		}
+		elem, err := c.p.elems.index(idx[len(idx)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		var table uint32
+		if len(idx) == 2 {
+			if table, err = c.p.tables.index(idx[0]); err != nil {
+				return nil, nil, err
+			}
+		}
+		imms = append(imms, elem, table)
+	case ops.TableCopy, ops.TableGrow, ops.TableSize, ops.TableFill:
+		n := 1
+		if sub == ops.TableCopy {
+			n = 2
+		}
+		idx := indices(n)
+		for i := 0; i < n; i++ {
+			var table uint32
+			if len(idx) == n {
+				var err error
+				if table, err = c.p.tables.index(idx[i]); err != nil {
+					return nil, nil, err
+				}
+			}
+			imms = append(imms, table)
+		}
+	}
+	return imms, args, nil
+}
+
+// parseSIMDImmediates parses the immediates of the operator prefixed by
+// ops.SIMDPrefix with the sub-opcode sub.
+func (c *codeParser) parseSIMDImmediates(name *node, sub uint32, args []*node) ([]interface{}, []*node, error) {
+	laneOp := sub >= ops.V128Load8Lane && sub <= ops.V128Store64Lane
+	var imms []interface{}
+	if disasm.IsSIMDMemoryOp(sub) {
+		var err error
+		imms, args, err = c.parseMemArg(args, simdNaturalAlignment(sub), laneOp)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case sub == ops.V128Const:
+		if len(args) == 0 {
+			return nil, nil, name.errorf("expected the shape of the lanes")
+		}
+		shape, ok := laneShapes[args[0].atom]
+		if !ok || len(args) < shape.count+1 {
+			return nil, nil, args[0].errorf("invalid constant")
+		}
+		v, err := parseV128(args[0], args[1:shape.count+1], false)
+		if err != nil {
+			return nil, nil, err
+		}
+		imms = append(imms, v.V128)
+		args = args[shape.count+1:]
+	case sub == ops.I8x16Shuffle:
+		var lanes [16]byte
+		for i := range lanes {
+			if len(args) == 0 || !isIndex(args[0]) {
+				return nil, nil, name.errorf("expected 16 lane indices")
+			}
+			v, err := parseUint(args[0].atom, 8)
+			if err != nil {
+				return nil, nil, args[0].errorf("invalid lane index %s", args[0].atom)
+			}
+			lanes[i] = byte(v)
+			args = args[1:]
+		}
+		imms = append(imms, lanes)
+	case sub >= ops.I8x16ExtractLaneS && sub <= ops.F64x2ReplaceLane, laneOp:
+		if len(args) == 0 || !isIndex(args[0]) {
+			return nil, nil, name.errorf("expected a lane index")
+		}
+		v, err := parseUint(args[0].atom, 8)
+		if err != nil {
+			return nil, nil, args[0].errorf("invalid lane index %s", args[0].atom)
+		}
+		imms = append(imms, uint8(v))
+		args = args[1:]
+	}
+	return imms, args, nil
+}
+
+// isIndex reports whether n is an index, either numeric or symbolic.
+func isIndex(n *node) bool {
+	return !n.isList && !n.isStr && (n.isID() || n.atom != "" && '0' <= n.atom[0] && n.atom[0] <= '9')
+}
+
+// isMemArg reports whether n is the offset or the alignment of a memory
+// access.
+func isMemArg(n *node) bool {
+	return !n.isList && !n.isStr && (strings.HasPrefix(n.atom, "offset=") || strings.HasPrefix(n.atom, "align="))
+}
+
+// naturalAlignment returns the natural alignment, in log 2, of the
+// non-prefixed load or store operator with the opcode code.
+func naturalAlignment(code byte) uint32 {
+	switch code {
+	case ops.I64Load, ops.I64Store, ops.F64Load, ops.F64Store:
+		return 3
+	case ops.I32Load, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store32,
+		ops.F32Load, ops.F32Store:
+		return 2
+	case ops.I32Load16u, ops.I32Load16s, ops.I64Load16u, ops.I64Load16s,
+		ops.I32Store16, ops.I64Store16:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,1038 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// ParseModule reads a module in the text format, given either as a module
+// or as the sequence of its fields. The module is returned as it would be
+// by wasm.DecodeModule: its sections are set, but its index spaces are not
+// populated. It can be encoded in the binary format by wasm.EncodeModule.
+//
+// Symbolic identifiers are resolved, but they are not kept in a name
+// section.
+func ParseModule(r io.Reader) (*wasm.Module, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseSExprs(src)
+	if err != nil {
+		return nil, err
+	}
+	return parseModule(nodes)
+}
+
+// assemble parses a module in the text format, and encodes it in the
+// binary format.
+func assemble(nodes []*node) ([]byte, error) {
+	m, err := parseModule(nodes)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseModule(nodes []*node) (*wasm.Module, error) {
+	fields := nodes
+	if len(nodes) == 1 && nodes[0].keyword() == "module" {
+		_, fields = takeID(nodes[0].list[1:])
+	}
+	p := &moduleParser{
+		m:       &wasm.Module{Version: 1},
+		types:   space{kind: "type"},
+		funcs:   space{kind: "function"},
+		tables:  space{kind: "table"},
+		mems:    space{kind: "memory"},
+		globals: space{kind: "global"},
+		tags:    space{kind: "tag"},
+		elems:   space{kind: "elem segment"},
+		datas:   space{kind: "data segment"},
+		next:    make(map[string]uint32),
+	}
+	if err := p.declare(fields); err != nil {
+		return nil, err
+	}
+	if err := p.define(fields); err != nil {
+		return nil, err
+	}
+	return p.module(), nil
+}
+
+// space is an index space of a module, such as its functions or its
+// globals, with the identifiers of its elements.
+type space struct {
+	kind  string
+	ids   map[string]uint32
+	count uint32
+}
+
+// add adds an element to the space, with the identifier id if it isn't
+// the empty string.
+func (s *space) add(id string, n *node) error {
+	if id != "" {
+		if _, ok := s.ids[id]; ok {
+			return n.errorf("duplicate %s %s", s.kind, id)
+		}
+		if s.ids == nil {
+			s.ids = make(map[string]uint32)
+		}
+		s.ids[id] = s.count
+	}
+	s.count++
+	return nil
+}
+
+// index returns the index of the element n of the space, given either as
+// an identifier or as an index.
+func (s *space) index(n *node) (uint32, error) {
+	if n.isID() {
+		i, ok := s.ids[n.atom]
+		if !ok {
+			return 0, n.errorf("unknown %s %s", s.kind, n.atom)
+		}
+		return i, nil
+	}
+	return parseIndex(n, s.kind)
+}
+
+// parseIndex parses the numeric index n of an element of the given kind.
+func parseIndex(n *node, kind string) (uint32, error) {
+	if n.isList || n.isStr {
+		return 0, n.errorf("expected a %s index", kind)
+	}
+	v, err := parseUint(n.atom, 32)
+	if err != nil {
+		return 0, n.errorf("invalid %s index %s", kind, n.atom)
+	}
+	return uint32(v), nil
+}
+
+// takeID returns the identifier at the beginning of args, if any, and the
+// rest of args.
+func takeID(args []*node) (string, []*node) {
+	if len(args) > 0 && args[0].isID() {
+		return args[0].atom, args[1:]
+	}
+	return "", args
+}
+
+// valueTypes maps the names of the value types to their values. anyfunc
+// is the former name of funcref.
+var valueTypes = map[string]wasm.ValueType{
+	"i32":       wasm.ValueTypeI32,
+	"i64":       wasm.ValueTypeI64,
+	"f32":       wasm.ValueTypeF32,
+	"f64":       wasm.ValueTypeF64,
+	"v128":      wasm.ValueTypeV128,
+	"funcref":   wasm.ValueTypeFuncRef,
+	"anyfunc":   wasm.ValueTypeFuncRef,
+	"externref": wasm.ValueTypeExternRef,
+}
+
+func parseValueType(n *node) (wasm.ValueType, error) {
+	t, ok := valueTypes[n.atom]
+	if !ok || n.isList || n.isStr {
+		return 0, n.errorf("expected a value type")
+	}
+	return t, nil
+}
+
+func parseValueTypes(nodes []*node) ([]wasm.ValueType, error) {
+	types := make([]wasm.ValueType, len(nodes))
+	for i, n := range nodes {
+		t, err := parseValueType(n)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = t
+	}
+	return types, nil
+}
+
+// parseRefType parses the element type of a table or of an element
+// segment.
+func parseRefType(n *node) (wasm.ElemType, error) {
+	t, err := parseValueType(n)
+	if err != nil || !t.IsRef() {
+		return 0, n.errorf("expected a reference type")
+	}
+	return wasm.ElemType(t), nil
+}
+
+// moduleParser builds a module from its fields in two passes: the first
+// one assigns an index to every identifier, as fields can refer to the
+// ones defined after them, and the second one builds the sections.
+type moduleParser struct {
+	m *wasm.Module
+
+	types, funcs, tables, mems, globals, tags, elems, datas space
+
+	defined   bool              // whether a function, table, memory, global or tag was defined
+	next      map[string]uint32 // the index of the next element of each kind, while defining them
+	dataCount bool              // whether the data count section is needed
+}
+
+// spaceOf returns the index space of the imports and exports of the given
+// kind, or nil for an invalid kind.
+func (p *moduleParser) spaceOf(kind string) *space {
+	switch kind {
+	case "func":
+		return &p.funcs
+	case "table":
+		return &p.tables
+	case "memory":
+		return &p.mems
+	case "global":
+		return &p.globals
+	case "tag":
+		return &p.tags
+	}
+	return nil
+}
+
+var externals = map[string]wasm.External{
+	"func":   wasm.ExternalFunction,
+	"table":  wasm.ExternalTable,
+	"memory": wasm.ExternalMemory,
+	"global": wasm.ExternalGlobal,
+	"tag":    wasm.ExternalTag,
+}
+
+// declare assigns the indices of the elements of the module, and parses
+// its types.
+func (p *moduleParser) declare(fields []*node) error {
+	for _, f := range fields {
+		kw := f.keyword()
+		if kw == "" {
+			return f.errorf("expected a module field")
+		}
+		id, args := takeID(f.list[1:])
+		switch kw {
+		case "type":
+			if err := p.types.add(id, f); err != nil {
+				return err
+			}
+			if len(args) != 1 || args[0].keyword() != "func" {
+				return f.errorf("expected a function type")
+			}
+			_, args := takeID(args[0].list[1:])
+			sig, _, rest, err := parseFuncType(args)
+			if err != nil {
+				return err
+			}
+			if len(rest) != 0 {
+				return rest[0].errorf("unexpected %v", rest[0])
+			}
+			p.addType(sig)
+		case "import":
+			if len(args) != 3 || !args[0].isStr || !args[1].isStr || !args[2].isList {
+				return f.errorf("expected an import")
+			}
+			desc := args[2]
+			s := p.spaceOf(desc.keyword())
+			if s == nil {
+				return desc.errorf("unknown import kind %v", desc)
+			}
+			if p.defined {
+				return f.errorf("imports must occur before the definitions")
+			}
+			id, _ := takeID(desc.list[1:])
+			if err := s.add(id, f); err != nil {
+				return err
+			}
+		case "func", "table", "memory", "global", "tag":
+			if err := p.spaceOf(kw).add(id, f); err != nil {
+				return err
+			}
+			args = skipExports(args)
+			if len(args) > 0 && args[0].keyword() == "import" {
+				if p.defined {
+					return f.errorf("imports must occur before the definitions")
+				}
+				continue
+			}
+			p.defined = true
+			for _, arg := range args {
+				switch {
+				case kw == "table" && arg.keyword() == "elem":
+					p.elems.add("", arg)
+				case kw == "memory" && arg.keyword() == "data":
+					p.datas.add("", arg)
+				}
+			}
+		case "elem":
+			if err := p.elems.add(id, f); err != nil {
+				return err
+			}
+		case "data":
+			if err := p.datas.add(id, f); err != nil {
+				return err
+			}
+		case "export", "start":
+		default:
+			return f.errorf("unknown module field %s", kw)
+		}
+	}
+	return nil
+}
+
+// define builds the sections of the module.
+func (p *moduleParser) define(fields []*node) error {
+	for _, f := range fields {
+		kw := f.keyword()
+		args := f.list[1:]
+		if kw != "start" {
+			_, args = takeID(args)
+		}
+		var err error
+		switch kw {
+		case "import":
+			desc := args[2]
+			_, rest := takeID(desc.list[1:])
+			err = p.parseDesc(desc.keyword(), f, rest, args[:2])
+		case "func", "table", "memory", "global", "tag":
+			args, err = p.parseExports(kw, args)
+			if err != nil {
+				return err
+			}
+			var imp []*node
+			if len(args) > 0 && args[0].keyword() == "import" {
+				imp = args[0].list[1:]
+				if len(imp) != 2 || !imp[0].isStr || !imp[1].isStr {
+					return args[0].errorf("expected an import")
+				}
+				args = args[1:]
+			}
+			err = p.parseDesc(kw, f, args, imp)
+		case "export":
+			err = p.parseExport(f, args)
+		case "start":
+			if p.m.Start != nil {
+				return f.errorf("multiple start functions")
+			}
+			if len(args) != 1 {
+				return f.errorf("expected a function")
+			}
+			var i uint32
+			if i, err = p.funcs.index(args[0]); err == nil {
+				p.m.Start = &wasm.SectionStartFunction{Index: i}
+			}
+		case "elem":
+			err = p.parseElem(f, args)
+		case "data":
+			err = p.parseData(f, args)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// module returns the module, with its sections in the order of the
+// binary format.
+func (p *moduleParser) module() *wasm.Module {
+	m := p.m
+	if p.dataCount {
+		m.DataCount = &wasm.SectionDataCount{}
+		if m.Data != nil {
+			m.DataCount.Count = uint32(len(m.Data.Entries))
+		}
+	}
+	for _, s := range []wasm.Section{
+		m.Types, m.Import, m.Function, m.Table, m.Memory, m.Tag, m.Global,
+		m.Export, m.Start, m.Elements, m.DataCount, m.Code, m.Data,
+	} {
+		// the missing sections are typed nil pointers.
+		if !reflect.ValueOf(s).IsNil() {
+			m.Sections = append(m.Sections, s)
+		}
+	}
+	return m
+}
+
+// addType returns the index of the type sig, which is added to the types
+// of the module.
+func (p *moduleParser) addType(sig wasm.FunctionSig) uint32 {
+	if p.m.Types == nil {
+		p.m.Types = &wasm.SectionTypes{}
+	}
+	p.m.Types.Entries = append(p.m.Types.Entries, sig)
+	return uint32(len(p.m.Types.Entries) - 1)
+}
+
+// parseFuncType parses the parameters and the results of a function type,
+// and returns the identifiers of the parameters, or the empty string for
+// the parameters without an identifier.
+func parseFuncType(args []*node) (wasm.FunctionSig, []string, []*node, error) {
+	sig := wasm.FunctionSig{Form: int8(wasm.TypeFunc)}
+	var names []string
+	for len(args) > 0 && args[0].keyword() == "param" {
+		l := args[0].list[1:]
+		if len(l) > 0 && l[0].isID() {
+			if len(l) != 2 {
+				return sig, nil, nil, args[0].errorf("expected a single parameter")
+			}
+			t, err := parseValueType(l[1])
+			if err != nil {
+				return sig, nil, nil, err
+			}
+			sig.ParamTypes = append(sig.ParamTypes, t)
+			names = append(names, l[0].atom)
+		} else {
+			types, err := parseValueTypes(l)
+			if err != nil {
+				return sig, nil, nil, err
+			}
+			sig.ParamTypes = append(sig.ParamTypes, types...)
+			names = append(names, make([]string, len(types))...)
+		}
+		args = args[1:]
+	}
+	for len(args) > 0 && args[0].keyword() == "result" {
+		types, err := parseValueTypes(args[0].list[1:])
+		if err != nil {
+			return sig, nil, nil, err
+		}
+		sig.ReturnTypes = append(sig.ReturnTypes, types...)
+		args = args[1:]
+	}
+	return sig, names, args, nil
+}
+
+// parseTypeUse parses a reference to a function type, given by its index,
+// by its parameters and results, or both. The types which are only given
+// by their parameters and results are added to the module if it doesn't
+// have them yet. parseTypeUse returns the identifiers of the parameters,
+// as parseFuncType.
+func (p *moduleParser) parseTypeUse(args []*node) (uint32, []string, []*node, error) {
+	var t uint32
+	var typeNode *node
+	if len(args) > 0 && args[0].keyword() == "type" {
+		typeNode = args[0]
+		if len(typeNode.list) != 2 {
+			return 0, nil, nil, typeNode.errorf("expected a type index")
+		}
+		var err error
+		if t, err = p.types.index(typeNode.list[1]); err != nil {
+			return 0, nil, nil, err
+		}
+		if p.m.Types == nil || int(t) >= len(p.m.Types.Entries) {
+			return 0, nil, nil, typeNode.errorf("unknown type %d", t)
+		}
+		args = args[1:]
+	}
+	sig, names, args, err := parseFuncType(args)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	if typeNode != nil {
+		def := p.m.Types.Entries[t]
+		if len(sig.ParamTypes) == 0 && len(sig.ReturnTypes) == 0 {
+			return t, make([]string, len(def.ParamTypes)), args, nil
+		}
+		if !sameSig(sig, def) {
+			return 0, nil, nil, typeNode.errorf("inline function type does not match type %d", t)
+		}
+		return t, names, args, nil
+	}
+	if p.m.Types != nil {
+		for i, def := range p.m.Types.Entries {
+			if sameSig(sig, def) {
+				return uint32(i), names, args, nil
+			}
+		}
+	}
+	return p.addType(sig), names, args, nil
+}
+
+func sameSig(a, b wasm.FunctionSig) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) || len(a.ReturnTypes) != len(b.ReturnTypes) {
+		return false
+	}
+	for i, t := range a.ParamTypes {
+		if b.ParamTypes[i] != t {
+			return false
+		}
+	}
+	for i, t := range a.ReturnTypes {
+		if b.ReturnTypes[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// skipExports returns args without its leading inline exports.
+func skipExports(args []*node) []*node {
+	for len(args) > 0 && args[0].keyword() == "export" {
+		args = args[1:]
+	}
+	return args
+}
+
+// parseExports parses the inline exports of the next element of the given
+// kind, and returns the rest of args.
+func (p *moduleParser) parseExports(kind string, args []*node) ([]*node, error) {
+	index := p.next[kind]
+	for len(args) > 0 && args[0].keyword() == "export" {
+		e := args[0]
+		if len(e.list) != 2 || !e.list[1].isStr {
+			return nil, e.errorf("expected an export name")
+		}
+		if err := p.addExport(e, e.list[1].atom, externals[kind], index); err != nil {
+			return nil, err
+		}
+		args = args[1:]
+	}
+	return args, nil
+}
+
+func (p *moduleParser) addExport(n *node, name string, kind wasm.External, index uint32) error {
+	if p.m.Export == nil {
+		p.m.Export = &wasm.SectionExports{Entries: make(map[string]wasm.ExportEntry)}
+	}
+	if _, ok := p.m.Export.Entries[name]; ok {
+		return n.errorf("duplicate export %q", name)
+	}
+	p.m.Export.Entries[name] = wasm.ExportEntry{FieldStr: name, Kind: kind, Index: index}
+	p.m.Export.Names = append(p.m.Export.Names, name)
+	return nil
+}
+
+// parseExport parses an export field.
+func (p *moduleParser) parseExport(f *node, args []*node) error {
+	if len(args) != 2 || !args[0].isStr || !args[1].isList || len(args[1].list) != 2 {
+		return f.errorf("expected an export")
+	}
+	desc := args[1]
+	s := p.spaceOf(desc.keyword())
+	if s == nil {
+		return desc.errorf("unknown export kind %v", desc)
+	}
+	index, err := s.index(desc.list[1])
+	if err != nil {
+		return err
+	}
+	return p.addExport(f, args[0].atom, externals[desc.keyword()], index)
+}
+
+// parseDesc parses the description of a function, table, memory, global
+// or tag, after its identifier and exports. If imp holds the module and
+// field names of an import, the element is imported.
+func (p *moduleParser) parseDesc(kind string, f *node, args []*node, imp []*node) error {
+	p.next[kind]++
+
+	var im wasm.Import
+	switch kind {
+	case "func":
+		t, names, args, err := p.parseTypeUse(args)
+		if err != nil {
+			return err
+		}
+		if imp != nil {
+			im = wasm.FuncImport{Type: t}
+			break
+		}
+		return p.parseFunc(f, t, names, args)
+	case "table":
+		if imp == nil && len(args) == 2 && args[1].keyword() == "elem" {
+			return p.parseInlineElem(args)
+		}
+		t, args, err := parseTableType(f, args)
+		if err != nil {
+			return err
+		}
+		if len(args) != 0 {
+			return args[0].errorf("unexpected %v", args[0])
+		}
+		if imp != nil {
+			im = wasm.TableImport{Type: t}
+			break
+		}
+		if p.m.Table == nil {
+			p.m.Table = &wasm.SectionTables{}
+		}
+		p.m.Table.Entries = append(p.m.Table.Entries, t)
+	case "memory":
+		if imp == nil && len(args) > 0 && args[len(args)-1].keyword() == "data" {
+			return p.parseInlineData(args)
+		}
+		lim, err := parseMemoryType(f, args)
+		if err != nil {
+			return err
+		}
+		if imp != nil {
+			im = wasm.MemoryImport{Type: wasm.Memory{Limits: lim}}
+			break
+		}
+		if p.m.Memory == nil {
+			p.m.Memory = &wasm.SectionMemories{}
+		}
+		p.m.Memory.Entries = append(p.m.Memory.Entries, wasm.Memory{Limits: lim})
+	case "global":
+		if len(args) == 0 {
+			return f.errorf("expected a global type")
+		}
+		var g wasm.GlobalVar
+		t := args[0]
+		if t.keyword() == "mut" {
+			if len(t.list) != 2 {
+				return t.errorf("expected a value type")
+			}
+			g.Mutable = true
+			t = t.list[1]
+		}
+		var err error
+		if g.Type, err = parseValueType(t); err != nil {
+			return err
+		}
+		if imp != nil {
+			if len(args) != 1 {
+				return args[1].errorf("unexpected %v", args[1])
+			}
+			im = wasm.GlobalVarImport{Type: g}
+			break
+		}
+		init, err := p.parseExpr(args[1:])
+		if err != nil {
+			return err
+		}
+		if p.m.Global == nil {
+			p.m.Global = &wasm.SectionGlobals{}
+		}
+		p.m.Global.Globals = append(p.m.Global.Globals, wasm.GlobalEntry{Type: g, Init: init})
+	case "tag":
+		t, _, args, err := p.parseTypeUse(args)
+		if err != nil {
+			return err
+		}
+		if len(args) != 0 {
+			return args[0].errorf("unexpected %v", args[0])
+		}
+		if imp != nil {
+			im = wasm.TagImport{Type: wasm.Tag{Type: t}}
+			break
+		}
+		if p.m.Tag == nil {
+			p.m.Tag = &wasm.SectionTags{}
+		}
+		p.m.Tag.Entries = append(p.m.Tag.Entries, wasm.Tag{Type: t})
+	}
+
+	if imp != nil {
+		if p.m.Import == nil {
+			p.m.Import = &wasm.SectionImports{}
+		}
+		p.m.Import.Entries = append(p.m.Import.Entries, wasm.ImportEntry{
+			ModuleName: imp[0].atom,
+			FieldName:  imp[1].atom,
+			Type:       im,
+		})
+	}
+	return nil
+}
+
+// parseFunc parses the locals and the body of a function of type t,
+// whose parameters have the identifiers names.
+func (p *moduleParser) parseFunc(f *node, t uint32, names []string, args []*node) error {
+	c := &codeParser{p: p, locals: make(map[string]uint32)}
+	var body wasm.FunctionBody
+	addLocal := func(n *node, id string, t wasm.ValueType, local bool) error {
+		index := uint32(len(names))
+		if id != "" {
+			if _, ok := c.locals[id]; ok {
+				return n.errorf("duplicate local %s", id)
+			}
+			c.locals[id] = index
+		}
+		names = append(names, id)
+		if !local {
+			return nil
+		}
+		if l := len(body.Locals); l > 0 && body.Locals[l-1].Type == t {
+			body.Locals[l-1].Count++
+		} else {
+			body.Locals = append(body.Locals, wasm.LocalEntry{Count: 1, Type: t})
+		}
+		return nil
+	}
+	params := names
+	names = nil
+	for _, id := range params {
+		if err := addLocal(f, id, 0, false); err != nil {
+			return err
+		}
+	}
+
+	for len(args) > 0 && args[0].keyword() == "local" {
+		l := args[0].list[1:]
+		if len(l) > 0 && l[0].isID() {
+			if len(l) != 2 {
+				return args[0].errorf("expected a single local")
+			}
+			t, err := parseValueType(l[1])
+			if err != nil {
+				return err
+			}
+			if err := addLocal(l[0], l[0].atom, t, true); err != nil {
+				return err
+			}
+		} else {
+			types, err := parseValueTypes(l)
+			if err != nil {
+				return err
+			}
+			for _, t := range types {
+				addLocal(args[0], "", t, true)
+			}
+		}
+		args = args[1:]
+	}
+
+	code, err := c.assemble(args)
+	if err != nil {
+		return err
+	}
+	body.Code = code
+
+	if p.m.Function == nil {
+		p.m.Function = &wasm.SectionFunctions{}
+		p.m.Code = &wasm.SectionCode{}
+	}
+	p.m.Function.Types = append(p.m.Function.Types, t)
+	p.m.Code.Bodies = append(p.m.Code.Bodies, body)
+	return nil
+}
+
+// assemble parses the instructions nodes, and encodes them in the binary
+// format, without the final end.
+func (c *codeParser) assemble(nodes []*node) ([]byte, error) {
+	if err := c.parseInstrs(nodes); err != nil {
+		return nil, err
+	}
+	if len(c.labels) != 0 {
+		return nil, nodes[len(nodes)-1].errorf("missing end")
+	}
+	return disasm.Assemble(c.instrs)
+}
+
+// parseExpr parses and encodes an initializer expression, including its
+// final end.
+func (p *moduleParser) parseExpr(nodes []*node) ([]byte, error) {
+	c := &codeParser{p: p}
+	code, err := c.assemble(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return append(code, ops.End), nil
+}
+
+// parseLimits parses the initial and maximum sizes of a table or memory.
+func parseLimits(f *node, args []*node) (wasm.ResizableLimits, []*node, error) {
+	var lim wasm.ResizableLimits
+	if len(args) == 0 || !isIndex(args[0]) || args[0].isID() {
+		return lim, nil, f.errorf("expected limits")
+	}
+	v, err := parseUint(args[0].atom, 32)
+	if err != nil {
+		return lim, nil, args[0].errorf("invalid limit %s", args[0].atom)
+	}
+	lim.Initial = uint32(v)
+	args = args[1:]
+	if len(args) > 0 && isIndex(args[0]) && !args[0].isID() {
+		v, err := parseUint(args[0].atom, 32)
+		if err != nil {
+			return lim, nil, args[0].errorf("invalid limit %s", args[0].atom)
+		}
+		lim.Flags |= 0x1
+		lim.Maximum = uint32(v)
+		args = args[1:]
+	}
+	return lim, args, nil
+}
+
+func parseTableType(f *node, args []*node) (wasm.Table, []*node, error) {
+	var t wasm.Table
+	lim, args, err := parseLimits(f, args)
+	if err != nil {
+		return t, nil, err
+	}
+	if len(args) == 0 {
+		return t, nil, f.errorf("expected a reference type")
+	}
+	elem, err := parseRefType(args[0])
+	if err != nil {
+		return t, nil, err
+	}
+	return wasm.Table{ElementType: elem, Limits: lim}, args[1:], nil
+}
+
+func parseMemoryType(f *node, args []*node) (wasm.ResizableLimits, error) {
+	is64 := len(args) > 0 && args[0].isKeyword("i64")
+	if is64 || len(args) > 0 && args[0].isKeyword("i32") {
+		args = args[1:]
+	}
+	lim, args, err := parseLimits(f, args)
+	if err != nil {
+		return lim, err
+	}
+	if len(args) > 0 && args[0].isKeyword("shared") {
+		lim.Flags |= 0x2
+		args = args[1:]
+	}
+	if is64 {
+		lim.Flags |= 0x4
+	}
+	if len(args) != 0 {
+		return lim, args[0].errorf("unexpected %v", args[0])
+	}
+	return lim, nil
+}
+
+// parseInlineElem parses a table given with its elements, as in
+// (table funcref (elem $f $g)), which is the abbreviation of a table and
+// of an active element segment.
+func (p *moduleParser) parseInlineElem(args []*node) error {
+	elem, err := parseRefType(args[0])
+	if err != nil {
+		return err
+	}
+	seg := wasm.ElementSegment{
+		Mode:   wasm.SegmentActive,
+		Index:  p.next["table"] - 1,
+		Offset: []byte{ops.I32Const, 0, ops.End},
+	}
+	items, kind := args[1].list[1:], elem
+	if len(items) == 0 || !items[0].isList {
+		// the elements are function indices
+		kind = 0
+	}
+	if err := p.parseElemList(&seg, items, kind); err != nil {
+		return err
+	}
+	n := uint32(len(seg.Elems) + len(seg.Exprs))
+	if p.m.Table == nil {
+		p.m.Table = &wasm.SectionTables{}
+	}
+	p.m.Table.Entries = append(p.m.Table.Entries, wasm.Table{
+		ElementType: elem,
+		Limits:      wasm.ResizableLimits{Flags: 0x1, Initial: n, Maximum: n},
+	})
+	p.addElem(seg)
+	return nil
+}
+
+// parseInlineData parses a memory given with its data, as in
+// (memory (data "...")), which is the abbreviation of a memory and of an
+// active data segment.
+func (p *moduleParser) parseInlineData(args []*node) error {
+	seg := wasm.DataSegment{
+		Mode:   wasm.SegmentActive,
+		Index:  p.next["memory"] - 1,
+		Offset: []byte{ops.I32Const, 0, ops.End},
+	}
+	lim := wasm.ResizableLimits{Flags: 0x1}
+	switch {
+	case len(args) == 2 && args[0].isKeyword("i64"):
+		lim.Flags |= 0x4
+		seg.Offset[0] = ops.I64Const
+	case len(args) == 2 && args[0].isKeyword("i32"), len(args) == 1:
+	default:
+		return args[0].errorf("unexpected %v", args[0])
+	}
+	data, err := parseStrings(args[len(args)-1].list[1:])
+	if err != nil {
+		return err
+	}
+	seg.Data = data
+	pages := uint32((len(data) + 65535) / 65536)
+	lim.Initial, lim.Maximum = pages, pages
+	if p.m.Memory == nil {
+		p.m.Memory = &wasm.SectionMemories{}
+	}
+	p.m.Memory.Entries = append(p.m.Memory.Entries, wasm.Memory{Limits: lim})
+	p.addData(seg)
+	return nil
+}
+
+// parseElem parses an element segment.
+func (p *moduleParser) parseElem(f *node, args []*node) error {
+	seg := wasm.ElementSegment{Mode: wasm.SegmentPassive}
+	switch {
+	case len(args) > 0 && args[0].isKeyword("declare"):
+		seg.Mode = wasm.SegmentDeclarative
+		args = args[1:]
+	case len(args) > 0 && (args[0].isList || isIndex(args[0])):
+		seg.Mode = wasm.SegmentActive
+		var table *node
+		if args[0].keyword() == "table" && len(args[0].list) == 2 {
+			table = args[0].list[1]
+		} else if isIndex(args[0]) {
+			// the table index of the MVP syntax.
+			table = args[0]
+		}
+		if table != nil {
+			var err error
+			if seg.Index, err = p.tables.index(table); err != nil {
+				return err
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 || !args[0].isList {
+			return f.errorf("expected an offset")
+		}
+		offset, err := p.parseOffset(args[0])
+		if err != nil {
+			return err
+		}
+		seg.Offset = offset
+		args = args[1:]
+	}
+
+	// the elements are function indices, or expressions following their
+	// type.
+	var elem wasm.ElemType
+	switch {
+	case len(args) > 0 && args[0].isKeyword("func"):
+		args = args[1:]
+	case len(args) > 0 && !args[0].isList && !isIndex(args[0]):
+		var err error
+		if elem, err = parseRefType(args[0]); err != nil {
+			return err
+		}
+		args = args[1:]
+	case seg.Mode != wasm.SegmentActive:
+		return f.errorf("expected the type of the elements")
+	}
+	if err := p.parseElemList(&seg, args, elem); err != nil {
+		return err
+	}
+	p.addElem(seg)
+	return nil
+}
+
+// parseElemList parses the elements of the segment seg: function indices
+// if elem is 0, or expressions producing elements of type elem.
+func (p *moduleParser) parseElemList(seg *wasm.ElementSegment, args []*node, elem wasm.ElemType) error {
+	seg.Type = wasm.ElemTypeAnyFunc
+	if elem == 0 && (len(args) == 0 || !args[0].isList) {
+		seg.Elems = []uint32{}
+		for _, n := range args {
+			i, err := p.funcs.index(n)
+			if err != nil {
+				return err
+			}
+			seg.Elems = append(seg.Elems, i)
+		}
+		return nil
+	}
+	if elem != 0 {
+		seg.Type = elem
+	}
+	seg.Exprs = [][]byte{}
+	for _, n := range args {
+		if !n.isList {
+			return n.errorf("expected an expression")
+		}
+		nodes := []*node{n}
+		if n.keyword() == "item" {
+			nodes = n.list[1:]
+		}
+		expr, err := p.parseExpr(nodes)
+		if err != nil {
+			return err
+		}
+		seg.Exprs = append(seg.Exprs, expr)
+	}
+	return nil
+}
+
+func (p *moduleParser) addElem(seg wasm.ElementSegment) {
+	if p.m.Elements == nil {
+		p.m.Elements = &wasm.SectionElements{}
+	}
+	p.m.Elements.Entries = append(p.m.Elements.Entries, seg)
+}
+
+// parseData parses a data segment.
+func (p *moduleParser) parseData(f *node, args []*node) error {
+	seg := wasm.DataSegment{Mode: wasm.SegmentPassive}
+	if len(args) > 0 && (args[0].isList || isIndex(args[0])) {
+		seg.Mode = wasm.SegmentActive
+		var mem *node
+		if args[0].keyword() == "memory" && len(args[0].list) == 2 {
+			mem = args[0].list[1]
+		} else if isIndex(args[0]) {
+			mem = args[0]
+		}
+		if mem != nil {
+			var err error
+			if seg.Index, err = p.mems.index(mem); err != nil {
+				return err
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 || !args[0].isList {
+			return f.errorf("expected an offset")
+		}
+		offset, err := p.parseOffset(args[0])
+		if err != nil {
+			return err
+		}
+		seg.Offset = offset
+		args = args[1:]
+	}
+	data, err := parseStrings(args)
+	if err != nil {
+		return err
+	}
+	seg.Data = data
+	p.addData(seg)
+	return nil
+}
+
+func (p *moduleParser) addData(seg wasm.DataSegment) {
+	if p.m.Data == nil {
+		p.m.Data = &wasm.SectionData{}
+	}
+	p.m.Data.Entries = append(p.m.Data.Entries, seg)
+}
+
+// parseOffset parses the offset of an active segment, given either as
+// (offset instr*) or as a single folded instruction.
+func (p *moduleParser) parseOffset(n *node) ([]byte, error) {
+	if n.keyword() == "offset" {
+		return p.parseExpr(n.list[1:])
+	}
+	return p.parseExpr([]*node{n})
+}
+
+// parseStrings returns the concatenation of the strings nodes.
+func parseStrings(nodes []*node) ([]byte, error) {
+	data := []byte{}
+	for _, n := range nodes {
+		if !n.isStr {
+			return nil, n.errorf("expected a string")
+		}
+		data = append(data, n.atom...)
+	}
+	return data, nil
+}
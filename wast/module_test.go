@@ -0,0 +1,236 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+// roundTrip parses the module src, encodes it and decodes it back.
+func roundTrip(t *testing.T, src []byte) *wasm.Module {
+	m, err := wast.ParseModule(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.EncodeModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.DecodeModule(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func writeText(t *testing.T, m *wasm.Module) string {
+	buf := new(bytes.Buffer)
+	if err := wast.WriteTo(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// TestParseWritten checks that parsing the output of WriteTo gives back
+// the same module.
+func TestParseWritten(t *testing.T) {
+	for _, dir := range testPaths {
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.wat"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fname := range fnames {
+			name := fname
+			t.Run(filepath.Base(name), func(t *testing.T) {
+				src, err := ioutil.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := writeText(t, roundTrip(t, src)); got != string(src) {
+					t.Fatalf("got:\n%s\nwant:\n%s", got, src)
+				}
+			})
+		}
+	}
+}
+
+// TestParseSpecModules compares the modules of the specification tests
+// to their binary encoding.
+func TestParseSpecModules(t *testing.T) {
+	fnames, err := filepath.Glob("../exec/testdata/spec/*.wast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range fnames {
+		name := fname
+		bname := strings.TrimSuffix(name, ".wast") + ".wasm"
+		if _, err := os.Stat(bname); err != nil {
+			continue
+		}
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			src, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := ioutil.ReadFile(bname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := wasm.DecodeModule(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.Customs = nil
+			if got, want := writeText(t, roundTrip(t, src)), writeText(t, want); got != want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseModule(t *testing.T) {
+	const src = `
+(module $m
+  (type $v (func))
+  (func $f (import "env" "f") (param i32))
+  (global $g i32 (i32.const 7))
+  (memory $mem (export "mem") 1)
+  (table $t 1 funcref)
+  (global $counter (mut i32) (i32.const 0))
+  (func $add (export "add") (param $x i32) (param $y i32) (result i32)
+    (local $sum i32)
+    (local.set $sum (i32.add (local.get $x) (local.get $y)))
+    (block $done
+      (loop $loop
+        (br_if $done (i32.eqz (local.get $sum)))
+        local.get $sum
+        i32.const 1
+        i32.sub
+        local.set $sum
+        br $loop
+      )
+    )
+    (if (result i32) (local.get $x)
+      (then (call $start) (global.get $g))
+      (else (i32.load offset=4 align=2 (local.get $y))))
+  )
+  (func $start (type $v)
+    block $b
+      i32.const 1
+      br_if $b
+      i32.const 0
+      call_indirect $t (type $v)
+    end $b
+    (global.set $counter (i32.const 1)))
+  (start $start)
+  (elem (i32.const 0) $add)
+  (data (i32.const 8) "hello\00" "\ff")
+)
+`
+	const want = `(module
+  (type (;0;) (func))
+  (type (;1;) (func (param i32)))
+  (type (;2;) (func (param i32 i32) (result i32)))
+  (import "env" "f" (func $env.f (type 1)))
+  (func (;1;) (type 2) (param i32 i32) (result i32)
+    (local i32)
+    get_local 0
+    get_local 1
+    i32.add
+    set_local 2
+    block  ;; label = @1
+      loop  ;; label = @2
+        get_local 2
+        i32.eqz
+        br_if 1 (;@1;)
+        get_local 2
+        i32.const 1
+        i32.sub
+        set_local 2
+        br 0 (;@2;)
+      end
+    end
+    get_local 0
+    if (result i32)  ;; label = @1
+      call 2
+      get_global 0
+    else
+      get_local 1
+      i32.load offset=4 align=2
+    end)
+  (func (;2;) (type 0)
+    block  ;; label = @1
+      i32.const 1
+      br_if 0 (;@1;)
+      i32.const 0
+      call_indirect (type 0)
+    end
+    i32.const 1
+    set_global 1)
+  (global (;0;) i32 (i32.const 7))
+  (global (;1;) (mut i32) (i32.const 0))
+  (table (;0;) 1 funcref)
+  (memory (;0;) 1)
+  (export "mem" (memory 0))
+  (export "add" (func 1))
+  (start 2)
+  (elem (i32.const 0) 1)
+  (data (i32.const 8) "hello\00\ff"))
+`
+	m := roundTrip(t, []byte(src))
+	if got := writeText(t, m); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if m.Start == nil || m.Start.Index != 2 {
+		t.Errorf("got start function %v, want 2", m.Start)
+	}
+}
+
+func TestParseModuleFields(t *testing.T) {
+	// a module can also be given as the sequence of its fields.
+	m := roundTrip(t, []byte(`(func (export "f") (result i32) (i32.const 42)) (memory (data "abc"))`))
+	if len(m.Code.Bodies) != 1 || len(m.Data.Entries) != 1 || string(m.Data.Entries[0].Data) != "abc" {
+		t.Fatalf("unexpected module %v", m)
+	}
+	if lim := m.Memory.Entries[0].Limits; lim.Initial != 1 || lim.Maximum != 1 {
+		t.Errorf("got memory limits %+v, want 1 1", lim)
+	}
+}
+
+func TestParseModuleErrors(t *testing.T) {
+	for _, src := range []string{
+		`(module (func (unknown.op)))`,
+		`(module (func (br $l)))`,
+		`(module (func (call $f)))`,
+		`(module (func (local $x i32) (local $x i32)))`,
+		`(module (func $f) (func $f))`,
+		`(module (func block))`,
+		`(module (func end))`,
+		`(module (func block $a end $b))`,
+		`(module (func (i32.const 0x1_0000_0000)))`,
+		`(module (func (i32.load align=3 (i32.const 0))))`,
+		`(module (func) (import "m" "f" (func)))`,
+		`(module (export "f" (func 0)) (export "f" (func 0)) (func))`,
+		`(module (type (func)) (func (type 0) (param i32)))`,
+		`(module (func (block (result i32 i32) (unreachable))))`,
+		`(module (memory 1) (data (i32.const 0) 1))`,
+		`(module (start 0) (start 0) (func))`,
+		`(module (elem))`,
+		`(module (table 1 i32))`,
+		`(module (unknown))`,
+	} {
+		if _, err := wast.ParseModule(strings.NewReader(src)); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
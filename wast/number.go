@@ -0,0 +1,165 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errInvalidNumber = errors.New("invalid number")
+
+// digits strips the underscores separating the digits of s, which must be
+// a non-empty sequence of digits of the given base.
+func digits(s string, base int) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' {
+			if i == 0 || i == len(s)-1 || s[i-1] == '_' {
+				return "", false
+			}
+			continue
+		}
+		if !isDigit(c, base) {
+			return "", false
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), true
+}
+
+func isDigit(c byte, base int) bool {
+	switch {
+	case '0' <= c && c <= '9':
+		return true
+	case base == 16 && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F'):
+		return true
+	}
+	return false
+}
+
+// parseNat parses a sequence of digits of the given base, possibly
+// separated by underscores.
+func parseNat(s string, base, bitSize int) (uint64, error) {
+	d, ok := digits(s, base)
+	if !ok {
+		return 0, errInvalidNumber
+	}
+	return strconv.ParseUint(d, base, bitSize)
+}
+
+// parseUint parses an unsigned decimal or hexadecimal integer.
+func parseUint(s string, bitSize int) (uint64, error) {
+	if strings.HasPrefix(s, "0x") {
+		return parseNat(s[2:], 16, bitSize)
+	}
+	return parseNat(s, 10, bitSize)
+}
+
+// parseInt parses the integer operand of an i32.const or an i64.const,
+// which is either signed or unsigned, and returns its bits.
+func parseInt(s string, bitSize int) (uint64, error) {
+	neg := strings.HasPrefix(s, "-")
+	if neg || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	v, err := parseUint(s, bitSize)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		if v > 1<<uint(bitSize-1) {
+			return 0, errInvalidNumber
+		}
+		v = -v
+	}
+	if bitSize < 64 {
+		v &= 1<<uint(bitSize) - 1
+	}
+	return v, nil
+}
+
+// parseFloat parses the operand of an f32.const or an f64.const, and
+// returns its bits.
+func parseFloat(s string, bitSize int) (uint64, error) {
+	mantBits, expMask := uint(52), uint64(0x7ff)<<52
+	if bitSize == 32 {
+		mantBits, expMask = 23, uint64(0xff)<<23
+	}
+	signBit := uint64(1) << uint(bitSize-1)
+
+	var sign uint64
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = signBit
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	switch {
+	case s == "inf":
+		return sign | expMask, nil
+	case s == "nan":
+		return sign | expMask | 1<<(mantBits-1), nil
+	case strings.HasPrefix(s, "nan:0x"):
+		payload, err := parseNat(s[len("nan:0x"):], 16, 64)
+		if err != nil || payload == 0 || payload >= 1<<mantBits {
+			return 0, errInvalidNumber
+		}
+		return sign | expMask | payload, nil
+	}
+
+	base, text := 10, s
+	if strings.HasPrefix(s, "0x") {
+		base, text = 16, s[2:]
+	}
+	// check the digits of the mantissa and of the exponent, since
+	// strconv.ParseFloat accepts more forms than the text format.
+	expChar := "eE"
+	if base == 16 {
+		expChar = "pP"
+	}
+	mant, exp := text, ""
+	if i := strings.IndexAny(text, expChar); i >= 0 {
+		mant, exp = text[:i], text[i+1:]
+		if strings.HasPrefix(exp, "+") || strings.HasPrefix(exp, "-") {
+			exp = exp[1:]
+		}
+		if _, ok := digits(exp, 10); !ok {
+			return 0, errInvalidNumber
+		}
+	}
+	intPart, fracPart := mant, ""
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		intPart, fracPart = mant[:i], mant[i+1:]
+	}
+	if _, ok := digits(intPart, base); !ok {
+		return 0, errInvalidNumber
+	}
+	if _, ok := digits(fracPart, base); !ok && fracPart != "" {
+		return 0, errInvalidNumber
+	}
+
+	text = strings.Replace(s, "_", "", -1)
+	if base == 16 && !strings.ContainsAny(text, expChar) {
+		text += "p0"
+	}
+	f, err := strconv.ParseFloat(text, bitSize)
+	if err != nil {
+		return 0, errInvalidNumber
+	}
+	if bitSize == 32 {
+		return sign | uint64(math.Float32bits(float32(f))), nil
+	}
+	return sign | math.Float64bits(f), nil
+}
@@ -0,0 +1,445 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// See https://github.com/WebAssembly/spec/tree/master/interpreter#scripts
+
+// Script is a WebAssembly script, as used by the files of the specification
+// test suite: a sequence of modules, actions and assertions.
+type Script struct {
+	Commands []*Command
+}
+
+// CommandType is the type of a command of a script.
+type CommandType string
+
+const (
+	CommandModule           CommandType = "module"
+	CommandRegister         CommandType = "register"
+	CommandAction           CommandType = "action"
+	CommandAssertReturn     CommandType = "assert_return"
+	CommandAssertTrap       CommandType = "assert_trap"
+	CommandAssertExhaustion CommandType = "assert_exhaustion"
+	CommandAssertException  CommandType = "assert_exception"
+	CommandAssertInvalid    CommandType = "assert_invalid"
+	CommandAssertMalformed  CommandType = "assert_malformed"
+	CommandAssertUnlinkable CommandType = "assert_unlinkable"
+)
+
+// Command is a command of a script. Commands of an unknown type are kept
+// with only their Type and Line set.
+type Command struct {
+	Type CommandType
+	Line int
+
+	// Module is the module defined by a module command, or the module an
+	// assertion is about: assert_invalid, assert_malformed,
+	// assert_unlinkable, and assert_trap when it checks the
+	// instantiation of a module rather than an action.
+	Module *ScriptModule
+
+	// Name is the name under which a module is registered by a register
+	// command, and ModuleID the identifier of that module, or the empty
+	// string for the last defined module.
+	Name     string
+	ModuleID string
+
+	// Action is the action performed by an action command, or checked by
+	// an assertion.
+	Action *Action
+
+	// Expected holds the results expected by assert_return.
+	Expected []Value
+
+	// Failure is the failure message expected by assertions of errors.
+	Failure string
+}
+
+// ScriptModule is a module of a script, in the binary format, in the text
+// format, or quoted in the text format.
+type ScriptModule struct {
+	ID   string // identifier of the module, or the empty string
+	Line int
+
+	Binary []byte // the module, for modules in the binary format
+	Quote  string // the source of the module, for quoted modules
+
+	text *node
+}
+
+// IsBinary reports whether m is given in the binary format.
+func (m *ScriptModule) IsBinary() bool {
+	return m.text == nil && m.Quote == "" && m.Binary != nil
+}
+
+// Bytes returns the module in the binary format, assembling the modules
+// given in the text format. The errors of quoted modules which can't be
+// parsed are the ones of malformed modules.
+func (m *ScriptModule) Bytes() ([]byte, error) {
+	switch {
+	case m.IsBinary():
+		return m.Binary, nil
+	case m.text != nil:
+		return assemble([]*node{m.text})
+	}
+	nodes, err := parseSExprs([]byte(m.Quote))
+	if err != nil {
+		return nil, err
+	}
+	return assemble(nodes)
+}
+
+// ActionType is the type of an action of a script.
+type ActionType string
+
+const (
+	ActionInvoke ActionType = "invoke"
+	ActionGet    ActionType = "get"
+)
+
+// Action is the invocation of an exported function, or the read of an
+// exported global.
+type Action struct {
+	Type     ActionType
+	ModuleID string // identifier of the module, or the empty string for the last defined module
+	Field    string // name of the export
+	Args     []Value
+}
+
+// NaNPattern is a pattern matching a set of floating point NaN values,
+// expected as the result of an assertion.
+type NaNPattern int
+
+const (
+	// NaNNone matches only the given value.
+	NaNNone NaNPattern = iota
+	// NaNCanonical matches the canonical NaNs, of either sign.
+	NaNCanonical
+	// NaNArithmetic matches the NaNs whose most significant bit of the
+	// payload is set, of either sign.
+	NaNArithmetic
+)
+
+// Value is a value used as an argument of an action, or expected as its
+// result.
+type Value struct {
+	Type wasm.ValueType
+
+	// Bits holds the bits of numeric values, or the host value referenced
+	// by a non-null externref.
+	Bits uint64
+	// V128 holds the bytes of v128 values.
+	V128 [16]byte
+
+	Null   bool // whether the value is a null reference
+	AnyRef bool // whether the value matches any non-null reference of its type
+
+	// Shape is the shape of the lanes of a v128 value, such as "f32x4".
+	Shape string
+	// NaN holds the patterns of the lanes of expected floating point
+	// results; f32 and f64 values have a single lane. It is nil when the
+	// exact value is expected.
+	NaN []NaNPattern
+}
+
+// ParseScript parses a script read from r.
+func ParseScript(r io.Reader) (*Script, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseSExprs(src)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{}
+	for _, n := range nodes {
+		cmd, err := parseCommand(n)
+		if err != nil {
+			return nil, err
+		}
+		s.Commands = append(s.Commands, cmd)
+	}
+	return s, nil
+}
+
+func parseCommand(n *node) (*Command, error) {
+	kw := n.keyword()
+	if kw == "" {
+		return nil, n.errorf("expected a command")
+	}
+	cmd := &Command{Type: CommandType(kw), Line: n.line}
+	args := n.list[1:]
+	var err error
+	switch cmd.Type {
+	case CommandModule:
+		cmd.Module, err = parseScriptModule(n)
+	case CommandRegister:
+		if len(args) == 0 || !args[0].isStr {
+			return nil, n.errorf("expected the name of the registered module")
+		}
+		cmd.Name = args[0].atom
+		switch {
+		case len(args) == 2 && args[1].isID():
+			cmd.ModuleID = args[1].atom
+		case len(args) != 1:
+			return nil, n.errorf("unexpected %v", args[1])
+		}
+	case CommandType(ActionInvoke), CommandType(ActionGet):
+		cmd.Type = CommandAction
+		cmd.Action, err = parseAction(n)
+	case CommandAssertReturn:
+		if len(args) == 0 {
+			return nil, n.errorf("expected an action")
+		}
+		cmd.Action, err = parseAction(args[0])
+		for _, arg := range args[1:] {
+			if err != nil {
+				break
+			}
+			var v Value
+			v, err = parseResult(arg)
+			cmd.Expected = append(cmd.Expected, v)
+		}
+	case CommandAssertTrap, CommandAssertExhaustion, CommandAssertException,
+		CommandAssertInvalid, CommandAssertMalformed, CommandAssertUnlinkable:
+		if len(args) == 0 {
+			return nil, n.errorf("expected an action or a module")
+		}
+		if cmd.Type != CommandAssertException {
+			if len(args) != 2 || !args[1].isStr {
+				return nil, n.errorf("expected a failure message")
+			}
+			cmd.Failure = args[1].atom
+		}
+		if args[0].keyword() == string(CommandModule) {
+			cmd.Module, err = parseScriptModule(args[0])
+		} else {
+			cmd.Action, err = parseAction(args[0])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func parseScriptModule(n *node) (*ScriptModule, error) {
+	if n.keyword() != string(CommandModule) {
+		return nil, n.errorf("expected a module")
+	}
+	m := &ScriptModule{Line: n.line}
+	args := n.list[1:]
+	if len(args) > 0 && args[0].isID() {
+		m.ID = args[0].atom
+		args = args[1:]
+	}
+	if len(args) > 0 && (args[0].isKeyword("binary") || args[0].isKeyword("quote")) {
+		var b bytes.Buffer
+		for _, s := range args[1:] {
+			if !s.isStr {
+				return nil, s.errorf("expected a string")
+			}
+			if args[0].atom == "quote" {
+				b.WriteByte(' ')
+			}
+			b.WriteString(s.atom)
+		}
+		if args[0].atom == "binary" {
+			m.Binary = []byte(b.String())
+		} else {
+			m.Quote = b.String()
+		}
+		return m, nil
+	}
+	m.text = n
+	return m, nil
+}
+
+func parseAction(n *node) (*Action, error) {
+	a := &Action{Type: ActionType(n.keyword())}
+	if a.Type != ActionInvoke && a.Type != ActionGet {
+		return nil, n.errorf("expected an action")
+	}
+	args := n.list[1:]
+	if len(args) > 0 && args[0].isID() {
+		a.ModuleID = args[0].atom
+		args = args[1:]
+	}
+	if len(args) == 0 || !args[0].isStr {
+		return nil, n.errorf("expected the name of an export")
+	}
+	a.Field = args[0].atom
+	args = args[1:]
+	if a.Type == ActionGet && len(args) > 0 {
+		return nil, n.errorf("unexpected %v", args[0])
+	}
+	for _, arg := range args {
+		v, err := parseValue(arg, false)
+		if err != nil {
+			return nil, err
+		}
+		a.Args = append(a.Args, v)
+	}
+	return a, nil
+}
+
+func parseResult(n *node) (Value, error) {
+	return parseValue(n, true)
+}
+
+var refTypes = map[string]wasm.ValueType{
+	"func":      wasm.ValueTypeFuncRef,
+	"funcref":   wasm.ValueTypeFuncRef,
+	"extern":    wasm.ValueTypeExternRef,
+	"externref": wasm.ValueTypeExternRef,
+}
+
+var laneShapes = map[string]struct {
+	count, bits int
+	float       bool
+}{
+	"i8x16": {16, 8, false},
+	"i16x8": {8, 16, false},
+	"i32x4": {4, 32, false},
+	"i64x2": {2, 64, false},
+	"f32x4": {4, 32, true},
+	"f64x2": {2, 64, true},
+}
+
+// parseValue parses a constant, which may be a pattern if it is an
+// expected result.
+func parseValue(n *node, result bool) (Value, error) {
+	var v Value
+	kw := n.keyword()
+	if kw == "" {
+		return v, n.errorf("expected a constant")
+	}
+	args := n.list[1:]
+	for _, arg := range args {
+		if arg.isList || arg.isStr {
+			return v, arg.errorf("unexpected %v", arg)
+		}
+	}
+
+	switch kw {
+	case "i32.const", "i64.const", "f32.const", "f64.const":
+		if len(args) != 1 {
+			return v, n.errorf("expected a single operand")
+		}
+		v.Type = map[string]wasm.ValueType{
+			"i32.const": wasm.ValueTypeI32,
+			"i64.const": wasm.ValueTypeI64,
+			"f32.const": wasm.ValueTypeF32,
+			"f64.const": wasm.ValueTypeF64,
+		}[kw]
+		bits, nan, err := parseLane(args[0].atom, kw[0] == 'f', int(kw[1]-'0')*10+int(kw[2]-'0'), result)
+		if err != nil {
+			return v, args[0].errorf("invalid constant %s", args[0].atom)
+		}
+		v.Bits = bits
+		if nan != NaNNone {
+			v.NaN = []NaNPattern{nan}
+		}
+	case "v128.const":
+		if len(args) == 0 {
+			return v, n.errorf("expected the shape of the lanes")
+		}
+		return parseV128(args[0], args[1:], result)
+	case "ref.null":
+		if len(args) != 1 {
+			return v, n.errorf("expected a reference type")
+		}
+		t, ok := refTypes[args[0].atom]
+		if !ok {
+			return v, args[0].errorf("invalid reference type %s", args[0].atom)
+		}
+		v.Type = t
+		v.Null = true
+	case "ref.extern", "ref.host", "ref.func":
+		v.Type = wasm.ValueTypeExternRef
+		if kw == "ref.func" {
+			v.Type = wasm.ValueTypeFuncRef
+		}
+		switch {
+		case len(args) == 0 && result:
+			v.AnyRef = true
+		case len(args) == 1 && kw != "ref.func":
+			bits, err := parseUint(args[0].atom, 32)
+			if err != nil {
+				return v, args[0].errorf("invalid reference %s", args[0].atom)
+			}
+			v.Bits = bits
+		default:
+			return v, n.errorf("invalid reference")
+		}
+	default:
+		return v, n.errorf("unknown constant %s", kw)
+	}
+	return v, nil
+}
+
+// parseV128 parses the lanes of a v128 constant with the given shape.
+func parseV128(shapeNode *node, args []*node, result bool) (Value, error) {
+	var v Value
+	shape, ok := laneShapes[shapeNode.atom]
+	if !ok {
+		return v, shapeNode.errorf("invalid shape %s", shapeNode.atom)
+	}
+	if len(args) != shape.count {
+		return v, shapeNode.errorf("expected %d lanes", shape.count)
+	}
+	v.Type = wasm.ValueTypeV128
+	v.Shape = shapeNode.atom
+	lanes := make([]NaNPattern, shape.count)
+	for i, arg := range args {
+		if arg.isList || arg.isStr {
+			return v, arg.errorf("invalid lane %v", arg)
+		}
+		bits, nan, err := parseLane(arg.atom, shape.float, shape.bits, result)
+		if err != nil {
+			return v, arg.errorf("invalid lane %s", arg.atom)
+		}
+		if nan != NaNNone {
+			lanes[i] = nan
+			v.NaN = lanes
+		}
+		size := shape.bits / 8
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], bits)
+		copy(v.V128[i*size:], b[:size])
+	}
+	return v, nil
+}
+
+// parseLane parses a number of the given size, which can be a NaN
+// pattern for expected floating point results.
+func parseLane(s string, float bool, bitSize int, result bool) (uint64, NaNPattern, error) {
+	if float && result {
+		switch s {
+		case "nan:canonical":
+			return 0, NaNCanonical, nil
+		case "nan:arithmetic":
+			return 0, NaNArithmetic, nil
+		}
+	}
+	var bits uint64
+	var err error
+	if float {
+		bits, err = parseFloat(s, bitSize)
+	} else {
+		bits, err = parseInt(s, bitSize)
+	}
+	return bits, NaNNone, err
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestParseNumbers(t *testing.T) {
+	for _, test := range []struct {
+		s     string
+		float bool
+		size  int
+		bits  uint64
+		ok    bool
+	}{
+		{"0", false, 32, 0, true},
+		{"-1", false, 32, 0xffffffff, true},
+		{"4294967295", false, 32, 0xffffffff, true},
+		{"4294967296", false, 32, 0, false},
+		{"-2147483648", false, 32, 0x80000000, true},
+		{"-2147483649", false, 32, 0, false},
+		{"0x7fff_ffff", false, 32, 0x7fffffff, true},
+		{"1__0", false, 32, 0, false},
+		{"_1", false, 32, 0, false},
+		{"-0x8000000000000000", false, 64, 0x8000000000000000, true},
+		{"1.5", true, 32, 0x3fc00000, true},
+		{"-0x1p-1", true, 32, 0xbf000000, true},
+		{"0x1.8", true, 64, 0x3ff8000000000000, true},
+		{"1e3", true, 64, 0x408f400000000000, true},
+		{"1_000.0", true, 64, 0x408f400000000000, true},
+		{"inf", true, 32, 0x7f800000, true},
+		{"-inf", true, 64, 0xfff0000000000000, true},
+		{"nan", true, 32, 0x7fc00000, true},
+		{"-nan:0x200000", true, 32, 0xffa00000, true},
+		{"nan:0x0", true, 32, 0, false},
+		{"nan:0x800000", true, 32, 0, false},
+		{"1e39", true, 32, 0, false},
+		{".5", true, 32, 0, false},
+		{"0x", true, 32, 0, false},
+	} {
+		var bits uint64
+		var err error
+		if test.float {
+			bits, err = parseFloat(test.s, test.size)
+		} else {
+			bits, err = parseInt(test.s, test.size)
+		}
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: got error %v", test.s, err)
+			continue
+		}
+		if bits != test.bits {
+			t.Errorf("%s: got %#x, want %#x", test.s, bits, test.bits)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	const src = `
+(module $m binary "\00asm" "\01\00\00\00")
+(module quote "(func)") ;; comment
+(register "m" $m)
+(; block (; nested ;) comment ;)
+(invoke "f" (i64.const -1) (ref.extern 2) (v128.const i16x8 0 1 2 3 4 5 6 -1))
+(assert_return (get $m "g") (f64.const nan:arithmetic))
+(assert_return (invoke "v") (v128.const f32x4 1 nan:canonical 0 -0))
+(assert_trap (module (func)) "trap")
+(assert_malformed (module quote "\u{1F600}\t") "malformed")
+(unknown_command)
+`
+	s, err := ParseScript(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v128 [16]byte
+	for i := 0; i < 7; i++ {
+		v128[2*i] = byte(i)
+	}
+	v128[14], v128[15] = 0xff, 0xff
+	var f32x4 [16]byte
+	copy(f32x4[:], []byte{0, 0, 0x80, 0x3f})
+	f32x4[15] = 0x80
+
+	want := []*Command{
+		{Type: CommandModule, Line: 2, Module: &ScriptModule{ID: "$m", Line: 2, Binary: []byte("\x00asm\x01\x00\x00\x00")}},
+		{Type: CommandModule, Line: 3, Module: &ScriptModule{Line: 3, Quote: " (func)"}},
+		{Type: CommandRegister, Line: 4, Name: "m", ModuleID: "$m"},
+		{Type: CommandAction, Line: 6, Action: &Action{Type: ActionInvoke, Field: "f", Args: []Value{
+			{Type: wasm.ValueTypeI64, Bits: 0xffffffffffffffff},
+			{Type: wasm.ValueTypeExternRef, Bits: 2},
+			{Type: wasm.ValueTypeV128, V128: v128, Shape: "i16x8"},
+		}}},
+		{Type: CommandAssertReturn, Line: 7, Action: &Action{Type: ActionGet, ModuleID: "$m", Field: "g"}, Expected: []Value{
+			{Type: wasm.ValueTypeF64, NaN: []NaNPattern{NaNArithmetic}},
+		}},
+		{Type: CommandAssertReturn, Line: 8, Action: &Action{Type: ActionInvoke, Field: "v"}, Expected: []Value{
+			{Type: wasm.ValueTypeV128, V128: f32x4, Shape: "f32x4", NaN: []NaNPattern{NaNNone, NaNCanonical, NaNNone, NaNNone}},
+		}},
+		{Type: CommandAssertTrap, Line: 9, Failure: "trap"},
+		{Type: CommandAssertMalformed, Line: 10, Module: &ScriptModule{Line: 10, Quote: " \U0001F600\t"}, Failure: "malformed"},
+		{Type: "unknown_command", Line: 11},
+	}
+	if len(s.Commands) != len(want) {
+		t.Fatalf("got %d commands, want %d", len(s.Commands), len(want))
+	}
+	for i, cmd := range s.Commands {
+		if cmd.Type == CommandAssertTrap {
+			if cmd.Module == nil || cmd.Module.IsBinary() {
+				t.Errorf("command %d: got module %v, want a text module", i, cmd.Module)
+			}
+			cmd.Module = nil
+		}
+		if !reflect.DeepEqual(cmd, want[i]) {
+			t.Errorf("command %d: got %+v, want %+v", i, cmd, want[i])
+		}
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	for _, src := range []string{
+		`(module`,
+		`(module))`,
+		`(; comment`,
+		`(module binary "\0")`,
+		`(module binary "abc`,
+		`(module binary 1)`,
+		`(register $m)`,
+		`(invoke "f" (i32.const 1 2))`,
+		`(invoke "f" (i32.const 0x1_0000_0000))`,
+		`(assert_return (get "g" (i32.const 1)))`,
+		`(assert_trap (invoke "f"))`,
+		`"string"`,
+	} {
+		if _, err := ParseScript(strings.NewReader(src)); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for _, test := range []struct {
+		bits uint64
+		size int
+		s    string
+	}{
+		{0x3f800000, 32, "0x1p+0 (;=1;)"},
+		{0x80000000, 32, "-0x0p+0 (;=-0;)"},
+		{0x00000001, 32, "0x1p-149 (;=1e-45;)"},
+		{0x7f7fffff, 32, "0x1.fffffep+127 (;=3.4028235e+38;)"},
+		{0x7fc00000, 32, "nan"},
+		{0xff800001, 32, "-nan:0x1"},
+		{0x3ff8000000000000, 64, "0x1.8p+0 (;=1.5;)"},
+		{0xfff0000000000000, 64, "-inf"},
+		{0x7ff4000000000000, 64, "nan:0x4000000000000"},
+	} {
+		s := formatFloat(test.bits, test.size)
+		if s != test.s {
+			t.Errorf("%#x: got %s, want %s", test.bits, s, test.s)
+		}
+		// the text before the comment parses back to the same bits.
+		bits, err := parseFloat(strings.Fields(s)[0], test.size)
+		if err != nil || bits != test.bits {
+			t.Errorf("%s: got %#x, %v", s, bits, err)
+		}
+	}
+}
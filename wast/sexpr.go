@@ -0,0 +1,310 @@
+// Copyright 2018 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned when a text file isn't a valid sequence of
+// s-expressions, or when an s-expression doesn't have the expected form.
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("wast: %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// node is a node of the s-expression tree of a text file: either a
+// parenthesized list of nodes, or an atom.
+type node struct {
+	line, col int
+
+	list   []*node // the elements of a list
+	isList bool
+
+	atom   string // the text of an atom, or the decoded bytes of a string
+	isStr  bool   // whether the atom is a string
+	source string // the source text of a string atom, with its quotes
+}
+
+func (n *node) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: n.line, Col: n.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// keyword returns the keyword at the head of a list, or the empty string
+// if n isn't a list starting with a keyword.
+func (n *node) keyword() string {
+	if !n.isList || len(n.list) == 0 {
+		return ""
+	}
+	head := n.list[0]
+	if head.isList || head.isStr {
+		return ""
+	}
+	return head.atom
+}
+
+// isKeyword reports whether n is the (non-string) atom kw.
+func (n *node) isKeyword(kw string) bool {
+	return !n.isList && !n.isStr && n.atom == kw
+}
+
+// isID reports whether n is an identifier, such as $x.
+func (n *node) isID() bool {
+	return !n.isList && !n.isStr && len(n.atom) > 1 && n.atom[0] == '$'
+}
+
+func (n *node) String() string {
+	switch {
+	case n.isList:
+		s := "("
+		for i, c := range n.list {
+			if i > 0 {
+				s += " "
+			}
+			s += c.String()
+		}
+		return s + ")"
+	case n.isStr:
+		return n.source
+	default:
+		return n.atom
+	}
+}
+
+// parser reads the s-expressions of a text file.
+type parser struct {
+	src       []byte
+	off       int
+	line, col int
+}
+
+// parseSExprs parses all the s-expressions of src.
+func parseSExprs(src []byte) ([]*node, error) {
+	p := &parser{src: src, line: 1, col: 1}
+	var nodes []*node
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.off == len(p.src) {
+			return nodes, nil
+		}
+		n, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.line, Col: p.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) next() byte {
+	c := p.src[p.off]
+	p.off++
+	if c == '\n' {
+		p.line++
+		p.col = 1
+	} else {
+		p.col++
+	}
+	return c
+}
+
+func (p *parser) peek(s string) bool {
+	return len(p.src)-p.off >= len(s) && string(p.src[p.off:p.off+len(s)]) == s
+}
+
+// skipSpace skips white space and comments.
+func (p *parser) skipSpace() error {
+	for p.off < len(p.src) {
+		switch {
+		case p.peek(";;"):
+			for p.off < len(p.src) && p.src[p.off] != '\n' {
+				p.next()
+			}
+		case p.peek("(;"):
+			if err := p.skipBlockComment(); err != nil {
+				return err
+			}
+		case isSpace(p.src[p.off]):
+			p.next()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// skipBlockComment skips a block comment, which can be nested.
+func (p *parser) skipBlockComment() error {
+	line, col := p.line, p.col
+	depth := 0
+	for p.off < len(p.src) {
+		switch {
+		case p.peek("(;"):
+			p.next()
+			p.next()
+			depth++
+		case p.peek(";)"):
+			p.next()
+			p.next()
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		default:
+			p.next()
+		}
+	}
+	return &SyntaxError{Line: line, Col: col, Msg: "unterminated block comment"}
+}
+
+func (p *parser) parseNode() (*node, error) {
+	n := &node{line: p.line, col: p.col}
+	switch c := p.src[p.off]; {
+	case c == '(':
+		p.next()
+		n.isList = true
+		for {
+			if err := p.skipSpace(); err != nil {
+				return nil, err
+			}
+			if p.off == len(p.src) {
+				return nil, n.errorf("unclosed parenthesis")
+			}
+			if p.src[p.off] == ')' {
+				p.next()
+				return n, nil
+			}
+			child, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, child)
+		}
+	case c == ')':
+		return nil, p.errorf("unexpected )")
+	case c == '"':
+		start := p.off
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		n.isStr = true
+		n.atom = s
+		n.source = string(p.src[start:p.off])
+		return n, nil
+	default:
+		start := p.off
+		for p.off < len(p.src) && isIDChar(p.src[p.off]) {
+			p.next()
+		}
+		if p.off == start {
+			return nil, p.errorf("unexpected character %q", c)
+		}
+		n.atom = string(p.src[start:p.off])
+		return n, nil
+	}
+}
+
+// parseString parses a string literal, and returns the bytes it denotes.
+func (p *parser) parseString() (string, error) {
+	p.next()
+	var buf []byte
+	for {
+		if p.off == len(p.src) || p.src[p.off] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.next()
+		switch {
+		case c == '"':
+			return string(buf), nil
+		case c == '\\':
+			if p.off == len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.next()
+			switch e {
+			case 't':
+				buf = append(buf, '\t')
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case '"', '\'', '\\':
+				buf = append(buf, e)
+			case 'u':
+				r, err := p.parseUnicodeEscape()
+				if err != nil {
+					return "", err
+				}
+				var b [utf8.UTFMax]byte
+				buf = append(buf, b[:utf8.EncodeRune(b[:], r)]...)
+			default:
+				if p.off == len(p.src) {
+					return "", p.errorf("unterminated string")
+				}
+				v, err := strconv.ParseUint(string([]byte{e, p.next()}), 16, 8)
+				if err != nil {
+					return "", p.errorf("invalid escape sequence in string")
+				}
+				buf = append(buf, byte(v))
+			}
+		case c < 0x20 || c == 0x7f:
+			return "", p.errorf("invalid control character in string")
+		default:
+			buf = append(buf, c)
+		}
+	}
+}
+
+// parseUnicodeEscape parses the {hexnum} part of a \u{hexnum} escape.
+func (p *parser) parseUnicodeEscape() (rune, error) {
+	if !p.peek("{") {
+		return 0, p.errorf("invalid unicode escape in string")
+	}
+	p.next()
+	start := p.off
+	for p.off < len(p.src) && p.src[p.off] != '}' && p.src[p.off] != '"' {
+		p.next()
+	}
+	if !p.peek("}") {
+		return 0, p.errorf("invalid unicode escape in string")
+	}
+	v, err := parseNat(string(p.src[start:p.off]), 16, 32)
+	p.next()
+	if err != nil || v >= 0xd800 && v < 0xe000 || v > utf8.MaxRune {
+		return 0, p.errorf("invalid unicode escape in string")
+	}
+	return rune(v), nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isIDChar reports whether c can be part of a keyword, an identifier or a
+// number.
+func isIDChar(c byte) bool {
+	switch {
+	case '0' <= c && c <= '9', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '/',
+		':', '<', '=', '>', '?', '@', '\\', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
@@ -10,10 +10,13 @@ package wast
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/wasm"
@@ -22,12 +25,39 @@ import (
 
 const tab = `  `
 
+// WriteOption configures the text written by WriteTo.
+type WriteOption func(w *writer)
+
+// Folded sets whether the instructions of function bodies are written as
+// folded expressions, like (i32.add (get_local 0) (i32.const 1)), instead
+// of a flat sequence of instructions.
+func Folded(v bool) WriteOption {
+	return func(w *writer) {
+		w.folded = v
+	}
+}
+
+// Labels sets whether blocks are given labels, such as $B1 for a block at
+// depth 1, which branches then refer to instead of relative depths.
+func Labels(v bool) WriteOption {
+	return func(w *writer) {
+		w.labels = v
+	}
+}
+
 // WriteTo writes a WASM module in a text representation.
-func WriteTo(w io.Writer, m *wasm.Module) error {
+//
+// The names of functions, locals and globals found in the "name" custom
+// section are written as identifiers, and imported functions without a
+// name are given one from the names of their module and field.
+func WriteTo(w io.Writer, m *wasm.Module, opts ...WriteOption) error {
 	wr, err := newWriter(w, m)
 	if err != nil {
 		return err
 	}
+	for _, opt := range opts {
+		opt(wr)
+	}
 	return wr.writeModule()
 }
 
@@ -35,25 +65,123 @@ type writer struct {
 	bw *bufio.Writer
 	m  *wasm.Module
 
-	fnames  wasm.NameMap
-	funcOff int
-	err     error
+	folded bool // write function bodies as folded expressions
+	labels bool // give labels to blocks
+
+	fnames  map[uint32]string // identifiers of functions
+	gnames  map[uint32]string // identifiers of globals
+	lnames  map[uint32]wasm.NameMap
+	locals  map[uint32]string // identifiers of the locals of the current function
+	funcs   []uint32          // type indices of functions, imports included
+	results int               // number of results of the current function
+
+	funcOff   int
+	tableOff  int
+	memOff    int
+	globalOff int
+	tagOff    int
+
+	block int // depth of the current block
+	err   error
 }
 
 func newWriter(w io.Writer, m *wasm.Module) (*writer, error) {
 	wr := &writer{bw: bufio.NewWriter(w), m: m}
+	fnames := make(wasm.NameMap)
 	if s := m.Custom(wasm.CustomSectionName); s != nil {
 		var names wasm.NameSection
 		_ = names.UnmarshalWASM(bytes.NewReader(s.Data))
-		sub, _ := names.Decode(wasm.NameFunction)
-		funcs, ok := sub.(*wasm.FunctionNames)
-		if ok {
-			wr.fnames = funcs.Names
+		if sub, _ := names.Decode(wasm.NameFunction); sub != nil {
+			for i, name := range sub.(*wasm.FunctionNames).Names {
+				fnames[i] = name
+			}
+		}
+		if sub, _ := names.Decode(wasm.NameLocal); sub != nil {
+			wr.lnames = sub.(*wasm.LocalNames).Funcs
+		}
+		if sub, _ := names.Decode(wasm.NameGlobal); sub != nil {
+			wr.gnames = identifiers(sub.(*wasm.GlobalNames).Names)
 		}
 	}
+	if m.Import != nil {
+		for _, e := range m.Import.Entries {
+			switch im := e.Type.(type) {
+			case wasm.FuncImport:
+				i := uint32(wr.funcOff)
+				if _, ok := fnames[i]; !ok {
+					fnames[i] = e.ModuleName + "." + e.FieldName
+				}
+				wr.funcs = append(wr.funcs, im.Type)
+				wr.funcOff++
+			case wasm.TableImport:
+				wr.tableOff++
+			case wasm.MemoryImport:
+				wr.memOff++
+			case wasm.GlobalVarImport:
+				wr.globalOff++
+			case wasm.TagImport:
+				wr.tagOff++
+			}
+		}
+	}
+	if m.Function != nil {
+		wr.funcs = append(wr.funcs, m.Function.Types...)
+	}
+	wr.fnames = identifiers(fnames)
 	return wr, nil
 }
 
+// identifiers returns the identifiers of the entries named in names. The
+// characters that can't be part of an identifier are replaced by '_', and
+// a suffix is added to the names already in use.
+func identifiers(names wasm.NameMap) map[uint32]string {
+	keys := make([]uint32, 0, len(names))
+	for k := range names {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	ids := make(map[uint32]string, len(names))
+	used := make(map[string]bool, len(names))
+	for _, k := range keys {
+		b := []byte(names[k])
+		for i, c := range b {
+			if !isIDChar(c) {
+				b[i] = '_'
+			}
+		}
+		id := "$" + string(b)
+		if len(b) == 0 {
+			id = "$_"
+		}
+		for i := 1; used[id]; i++ {
+			id = fmt.Sprintf("$%s.%d", b, i)
+		}
+		used[id] = true
+		ids[k] = id
+	}
+	return ids
+}
+
+// ref returns the identifier of the entry i of an index space, or its
+// index if it has none.
+func ref(ids map[uint32]string, i uint32) string {
+	if id, ok := ids[i]; ok {
+		return id
+	}
+	return strconv.FormatUint(uint64(i), 10)
+}
+
+// decl returns the identifier of the entry i of an index space, or a
+// comment with its index if it has none.
+func decl(ids map[uint32]string, i int) string {
+	if id, ok := ids[uint32(i)]; ok {
+		return id
+	}
+	return fmt.Sprintf("(;%d;)", i)
+}
+
 func (w *writer) writeModule() error {
 	bw := w.bw
 	bw.WriteString("(module")
@@ -64,7 +192,9 @@ func (w *writer) writeModule() error {
 	w.writeGlobals()
 	w.writeTables()
 	w.writeMemory()
+	w.writeTags()
 	w.writeExports()
+	w.writeStart()
 	w.writeElements()
 	w.writeData()
 
@@ -97,14 +227,7 @@ func (w *writer) writeFuncSignature(t wasm.FunctionSig) error {
 }
 
 func (w *writer) writeFuncType(t wasm.FunctionSig) error {
-	if len(t.ParamTypes) != 0 {
-		w.WriteString(" (param")
-		for _, p := range t.ParamTypes {
-			w.WriteString(" ")
-			w.WriteString(p.String())
-		}
-		w.WriteString(")")
-	}
+	w.writeVars(" ", "param", t.ParamTypes, 0)
 	if len(t.ReturnTypes) != 0 {
 		w.WriteString(" (result")
 		for _, p := range t.ReturnTypes {
@@ -116,32 +239,69 @@ func (w *writer) writeFuncType(t wasm.FunctionSig) error {
 	return nil
 }
 
+// writeVars writes the declarations of the params or locals of the given
+// kind, numbered from off. Named variables are declared on their own, as
+// in (param $x i32), and the others are grouped. sep is written before
+// the first declaration.
+func (w *writer) writeVars(sep, kind string, types []wasm.ValueType, off int) {
+	group := false // whether a list of unnamed variables is open
+	for i, t := range types {
+		id, named := w.locals[uint32(off+i)]
+		switch {
+		case named:
+			if group {
+				w.WriteString(")")
+				group = false
+			}
+			w.Print("%s(%s %s %v)", sep, kind, id, t)
+			sep = " "
+		case !group:
+			w.Print("%s(%s %v", sep, kind, t)
+			group = true
+			sep = " "
+		default:
+			w.Print(" %v", t)
+		}
+	}
+	if group {
+		w.WriteString(")")
+	}
+}
+
 func (w *writer) writeImports() {
-	w.funcOff = 0
 	if w.m.Import == nil {
 		return
 	}
+	var funcs, tables, mems, globals, tags int
 	w.WriteString("\n")
 	for i, e := range w.m.Import.Entries {
 		if i != 0 {
 			w.WriteString("\n")
 		}
 		w.WriteString(tab + "(import ")
-		w.Print("%q %q ", e.ModuleName, e.FieldName)
+		w.Print("%s %s ", quoteData([]byte(e.ModuleName)), quoteData([]byte(e.FieldName)))
 		switch im := e.Type.(type) {
 		case wasm.FuncImport:
-			w.Print("(func (;%d;) (type %d))", w.funcOff, im.Type)
-			if w.fnames == nil {
-				w.fnames = make(wasm.NameMap)
-			}
-			w.fnames[uint32(w.funcOff)] = e.ModuleName + "." + e.FieldName
-			w.funcOff++
+			w.Print("(func %s (type %d))", decl(w.fnames, funcs), im.Type)
+			funcs++
 		case wasm.TableImport:
-			// TODO
+			w.Print("(table (;%d;)", tables)
+			w.writeTableType(im.Type)
+			w.WriteString(")")
+			tables++
 		case wasm.MemoryImport:
-			// TODO
+			w.Print("(memory (;%d;)", mems)
+			w.writeLimits(im.Type.Limits)
+			w.WriteString(")")
+			mems++
 		case wasm.GlobalVarImport:
-			// TODO
+			w.Print("(global %s", decl(w.gnames, globals))
+			w.writeGlobalType(im.Type)
+			w.WriteString(")")
+			globals++
+		case wasm.TagImport:
+			w.Print("(tag (;%d;) (type %d))", tags, im.Type.Type)
+			tags++
 		}
 		w.WriteString(")")
 	}
@@ -157,33 +317,38 @@ func (w *writer) writeFunctions() {
 			w.WriteString("\n")
 		}
 		ind := w.funcOff + i
-		w.bw.WriteString(tab + "(func ")
-		if name, ok := w.fnames[uint32(ind)]; ok {
-			w.bw.WriteString("$" + name)
-		} else {
-			fmt.Fprintf(w.bw, "(;%d;)", ind)
-		}
-		fmt.Fprintf(w.bw, " (type %d)", int(t))
-		if int(t) < len(w.m.Types.Entries) {
-			sig := w.m.Types.Entries[t]
+		w.locals = identifiers(w.lnames[uint32(ind)])
+		w.results = 0
+		w.WriteString(tab + "(func " + decl(w.fnames, ind))
+		w.Print(" (type %d)", int(t))
+		var sig wasm.FunctionSig
+		if w.m.Types != nil && int(t) < len(w.m.Types.Entries) {
+			sig = w.m.Types.Entries[t]
 			w.writeFuncType(sig)
+			w.results = len(sig.ReturnTypes)
 		}
 		if w.m.Code != nil && i < len(w.m.Code.Bodies) {
 			b := w.m.Code.Bodies[i]
-			if len(b.Locals) > 0 {
-				w.WriteString("\n" + tab + tab + "(local")
-				for _, l := range b.Locals {
-					for i := 0; i < int(l.Count); i++ {
-						w.WriteString(" ")
-						w.WriteString(l.Type.String())
-					}
+			var locals []wasm.ValueType
+			for _, l := range b.Locals {
+				for i := 0; i < int(l.Count); i++ {
+					locals = append(locals, l.Type)
 				}
-				w.WriteString(")")
 			}
-			w.writeCode(b.Code, false)
+			w.writeVars("\n"+tab+tab, "local", locals, len(sig.ParamTypes))
+			w.writeBody(b.Code)
 		}
 		w.WriteString(")")
 	}
+	w.locals = nil
+}
+
+func (w *writer) writeGlobalType(t wasm.GlobalVar) {
+	if t.Mutable {
+		w.Print(" (mut %v)", t.Type)
+	} else {
+		w.Print(" %v", t.Type)
+	}
 }
 
 func (w *writer) writeGlobals() {
@@ -192,18 +357,35 @@ func (w *writer) writeGlobals() {
 	}
 	for i, e := range w.m.Global.Globals {
 		w.WriteString("\n")
-		w.WriteString(tab + "(global ")
-		w.Print("(;%d;)", i)
-		if e.Type.Mutable {
-			w.WriteString(" (mut")
-		}
-		w.Print(" %v", e.Type.Type)
-		if e.Type.Mutable {
-			w.WriteString(")")
-		}
-		w.WriteString(" (")
-		w.writeCode(e.Init, true)
-		w.WriteString("))")
+		w.WriteString(tab + "(global " + decl(w.gnames, w.globalOff+i))
+		w.writeGlobalType(e.Type)
+		w.writeInitExpr(e.Init, "")
+		w.WriteString(")")
+	}
+}
+
+// writeLimits writes the limits of a table or of a memory, with the index
+// type and the sharing of memories.
+func (w *writer) writeLimits(lim wasm.ResizableLimits) {
+	if lim.Is64() {
+		w.WriteString(" i64")
+	}
+	w.Print(" %d", lim.Initial)
+	if lim.Flags&0x1 != 0 {
+		w.Print(" %d", lim.Maximum)
+	}
+	if lim.Shared() {
+		w.WriteString(" shared")
+	}
+}
+
+func (w *writer) writeTableType(t wasm.Table) {
+	w.writeLimits(t.Limits)
+	switch t.ElementType {
+	case wasm.ElemTypeAnyFunc:
+		w.WriteString(" funcref")
+	case wasm.ElemTypeExternRef:
+		w.WriteString(" externref")
 	}
 }
 
@@ -213,13 +395,11 @@ func (w *writer) writeTables() {
 	}
 	w.WriteString("\n")
 	for i, t := range w.m.Table.Entries {
-		w.WriteString(tab + "(table ")
-		w.Print("(;%d;)", i)
-		w.Print(" %d %d ", t.Limits.Initial, t.Limits.Maximum)
-		switch t.ElementType {
-		case wasm.ElemTypeAnyFunc:
-			w.WriteString("anyfunc")
+		if i != 0 {
+			w.WriteString("\n")
 		}
+		w.Print(tab+"(table (;%d;)", w.tableOff+i)
+		w.writeTableType(t)
 		w.WriteString(")")
 	}
 }
@@ -230,11 +410,24 @@ func (w *writer) writeMemory() {
 	}
 	w.WriteString("\n")
 	for i, e := range w.m.Memory.Entries {
-		w.WriteString(tab + "(memory ")
-		w.Print("(;%d;)", i)
-		w.Print(" %d", e.Limits.Initial)
-		if e.Limits.Maximum != 0 {
-			w.Print(" %d", e.Limits.Initial)
+		if i != 0 {
+			w.WriteString("\n")
+		}
+		w.Print(tab+"(memory (;%d;)", w.memOff+i)
+		w.writeLimits(e.Limits)
+		w.WriteString(")")
+	}
+}
+
+func (w *writer) writeTags() {
+	if w.m.Tag == nil {
+		return
+	}
+	for i, t := range w.m.Tag.Entries {
+		w.WriteString("\n")
+		w.Print(tab+"(tag (;%d;) (type %d)", w.tagOff+i, t.Type)
+		if w.m.Types != nil && int(t.Type) < len(w.m.Types.Entries) {
+			w.writeFuncType(w.m.Types.Entries[t.Type])
 		}
 		w.WriteString(")")
 	}
@@ -250,21 +443,34 @@ func (w *writer) writeExports() {
 		if i != 0 {
 			w.WriteString("\n")
 		}
-		w.Print(tab+"(export %q (", e.FieldStr)
+		w.Print(tab+"(export %s (", quoteData([]byte(e.FieldStr)))
+		index := strconv.FormatUint(uint64(e.Index), 10)
 		switch e.Kind {
 		case wasm.ExternalFunction:
 			w.WriteString("func")
+			index = ref(w.fnames, e.Index)
 		case wasm.ExternalMemory:
 			w.WriteString("memory")
 		case wasm.ExternalTable:
 			w.WriteString("table")
 		case wasm.ExternalGlobal:
 			w.WriteString("global")
+			index = ref(w.gnames, e.Index)
+		case wasm.ExternalTag:
+			w.WriteString("tag")
 		}
-		w.Print(" %d))", e.Index)
+		w.Print(" %s))", index)
 	}
 }
 
+func (w *writer) writeStart() {
+	if w.m.Start == nil {
+		return
+	}
+	w.WriteString("\n")
+	w.Print(tab+"(start %s)", ref(w.fnames, w.m.Start.Index))
+}
+
 func (w *writer) writeElements() {
 	if w.m.Elements == nil {
 		return
@@ -272,19 +478,53 @@ func (w *writer) writeElements() {
 	for _, d := range w.m.Elements.Entries {
 		w.WriteString("\n")
 		w.WriteString(tab + "(elem")
-		if d.Index != 0 {
-			w.Print(" %d", d.Index)
+		switch d.Mode {
+		case wasm.SegmentActive:
+			if d.Index != 0 {
+				w.Print(" (table %d)", d.Index)
+			}
+			w.writeInitExpr(d.Offset, "offset")
+			if d.Index != 0 || d.Exprs != nil {
+				w.writeElemType(d)
+			}
+		case wasm.SegmentPassive:
+			w.writeElemType(d)
+		case wasm.SegmentDeclarative:
+			w.WriteString(" declare")
+			w.writeElemType(d)
 		}
-		w.WriteString(" (")
-		w.writeCode(d.Offset, true)
-		w.WriteString(")")
-		for _, v := range d.Elems {
-			w.Print(" %d", v)
+		if d.Exprs == nil {
+			for _, v := range d.Elems {
+				w.WriteString(" " + ref(w.fnames, v))
+			}
+		} else {
+			for _, e := range d.Exprs {
+				w.writeInitExpr(e, "item")
+			}
 		}
 		w.WriteString(")")
 	}
 }
 
+// writeElemType writes the element type of a segment that does not use
+// the MVP syntax.
+func (w *writer) writeElemType(d wasm.ElementSegment) {
+	if d.Exprs == nil {
+		w.WriteString(" func")
+	} else {
+		w.WriteString(" " + wasm.ValueType(d.Type).String())
+	}
+}
+
+// heapType returns the name of the heap type of the reference type t, as
+// used by ref.null.
+func heapType(t wasm.ValueType) string {
+	if t == wasm.ValueTypeExternRef {
+		return "extern"
+	}
+	return "func"
+}
+
 func (w *writer) writeData() {
 	if w.m.Data == nil {
 		return
@@ -292,12 +532,13 @@ func (w *writer) writeData() {
 	for _, d := range w.m.Data.Entries {
 		w.WriteString("\n")
 		w.WriteString(tab + "(data")
-		if d.Index != 0 {
-			w.Print(" %d", d.Index)
+		if d.Mode == wasm.SegmentActive {
+			if d.Index != 0 {
+				w.Print(" %d", d.Index)
+			}
+			w.writeInitExpr(d.Offset, "offset")
 		}
-		w.WriteString(" (")
-		w.writeCode(d.Offset, true)
-		w.Print(") %s)", quoteData(d.Data))
+		w.Print(" %s)", quoteData(d.Data))
 	}
 }
 
@@ -333,161 +574,589 @@ func quoteData(p []byte) string {
 	return buf.String()
 }
 
-func (w *writer) writeCode(code []byte, isInit bool) {
+// writeBody writes the instructions of a function body, which doesn't
+// include the final end.
+func (w *writer) writeBody(code []byte) {
 	if w.err != nil {
 		return
 	}
-	instr, err := disasm.Disassemble(code)
+	instrs, err := disasm.Disassemble(code)
 	if err != nil {
 		w.err = err
 		return
 	}
-	tabs := 2
-	block := 0
-	writeBlock := func(d int) {
-		w.Print(" %d (;@%d;)", d, block-d)
+	w.block = 0
+	if w.folded {
+		pos := 0
+		exprs, _ := w.fold(instrs, &pos)
+		if pos == len(instrs) {
+			for _, e := range exprs {
+				w.WriteString("\n")
+				w.writeExpr(e, 2)
+			}
+			return
+		}
+		// the blocks aren't balanced, fall back to a flat sequence.
+		w.block = 0
 	}
-	hadEnd := false
-	for i, ins := range instr {
-		if !isInit {
-			w.WriteString("\n")
+	for _, ins := range instrs {
+		switch ins.Op.Code {
+		case operators.End, operators.Else, operators.Catch, operators.CatchAll, operators.Delegate:
+			w.block--
 		}
+		w.WriteString("\n")
+		w.indent(2 + w.block)
 		switch ins.Op.Code {
-		case operators.End, operators.Else:
-			tabs--
-			block--
+		case operators.Block, operators.Loop, operators.If, operators.Try:
+			w.block++
 		}
-		if isInit && !hadEnd && ins.Op.Code == operators.End {
-			hadEnd = true
-			continue
+		w.writeInstr(ins)
+		switch ins.Op.Code {
+		case operators.Else, operators.Catch, operators.CatchAll:
+			w.block++
 		}
-		if isInit {
-			if i > 0 {
-				w.WriteString(" ")
-			}
-		} else {
-			for i := 0; i < tabs; i++ {
-				w.WriteString(tab)
-			}
+	}
+}
+
+// writeInitExpr writes an initializer expression, which ends with an end
+// instruction. A single instruction, or instructions that fold into a
+// single expression, are written as a folded expression, and the others
+// in a list starting with the keyword wrap, if any.
+func (w *writer) writeInitExpr(code []byte, wrap string) {
+	if w.err != nil {
+		return
+	}
+	instrs, err := disasm.Disassemble(code)
+	if err != nil {
+		w.err = err
+		return
+	}
+	if n := len(instrs); n > 0 && instrs[n-1].Op.Code == operators.End {
+		instrs = instrs[:n-1]
+	}
+	w.block = 0
+	pos := 0
+	if exprs, _ := w.fold(instrs, &pos); pos == len(instrs) && len(exprs) == 1 {
+		w.WriteString(" ")
+		w.writeExpr(exprs[0], 0)
+		return
+	}
+	if wrap != "" {
+		w.WriteString(" (" + wrap)
+	}
+	for _, ins := range instrs {
+		w.WriteString(" ")
+		w.writeInstr(ins)
+	}
+	if wrap != "" {
+		w.WriteString(")")
+	}
+}
+
+func (w *writer) indent(n int) {
+	for i := 0; i < n; i++ {
+		w.WriteString(tab)
+	}
+}
+
+// An expr is an instruction written as a folded expression, together with
+// the expressions of its operands and, for structured instructions, the
+// instructions of its blocks.
+type expr struct {
+	ins     disasm.Instr
+	args    []*expr
+	results int // number of results, 0 when unknown
+
+	body    []*expr  // instructions of a block, loop, the then branch of an if, or of a try
+	clauses []clause // else, catch, catch_all and delegate clauses
+}
+
+type clause struct {
+	ins  disasm.Instr
+	body []*expr
+}
+
+// fold reads the instructions of instrs from *pos up to the end of the
+// current block, and returns them as folded expressions, with the
+// instruction that ended the block, if any.
+//
+// An instruction takes as operands the expressions preceding it that push
+// a single value, in the limit of its number of operands. Since a folded
+// expression is written in the same order as the instructions it stands
+// for, an instruction with unknown operands is simply left unfolded.
+func (w *writer) fold(instrs []disasm.Instr, pos *int) ([]*expr, *disasm.Instr) {
+	var exprs []*expr
+	for *pos < len(instrs) {
+		ins := instrs[*pos]
+		*pos++
+		switch ins.Op.Code {
+		case operators.End, operators.Else, operators.Catch, operators.CatchAll, operators.Delegate:
+			return exprs, &ins
 		}
-		w.WriteString(ins.Op.Name)
+		e := &expr{ins: ins}
+		params, results := w.arity(ins)
 		switch ins.Op.Code {
-		case operators.Else:
-			tabs++
-			block++
-		case operators.Block, operators.Loop, operators.If:
-			tabs++
-			block++
-			b := ins.Immediates[0].(wasm.BlockType)
-			if b != wasm.BlockTypeEmpty {
-				w.WriteString(" (result ")
-				w.WriteString(b.String())
-				w.WriteString(")")
-			}
-			w.Print("  ;; label = @%d", block)
-			continue
-		case operators.F32Const:
-			i1 := ins.Immediates[0].(float32)
-			w.WriteString(" " + formatFloat32(i1))
-			continue
-		case operators.F64Const:
-			i1 := ins.Immediates[0].(float64)
-			w.WriteString(" " + formatFloat64(i1))
-			continue
-		case operators.BrIf, operators.Br:
-			i1 := ins.Immediates[0].(uint32)
-			writeBlock(int(i1))
-			continue
-		case operators.BrTable:
-			n := ins.Immediates[0].(uint32)
-			for i := 0; i < int(n); i++ {
-				v := ins.Immediates[i+1].(uint32)
-				writeBlock(int(v))
-			}
-			def := ins.Immediates[n+1].(uint32)
-			writeBlock(int(def))
-			continue
-		case operators.Call:
-			i1 := ins.Immediates[0].(uint32)
-			if name, ok := w.fnames[i1]; ok {
-				w.WriteString(" $")
-				w.WriteString(name)
-			} else {
-				w.Print(" %v", i1)
-			}
-			continue
-		case operators.CallIndirect:
-			i1 := ins.Immediates[0].(uint32)
-			w.Print(" (type %d)", i1)
-			continue
-		case operators.CurrentMemory, operators.GrowMemory:
-			r := ins.Immediates[0].(uint8)
-			if r == 0 {
-				continue
+		case operators.Block, operators.Loop, operators.If, operators.Try:
+			var end *disasm.Instr
+			e.body, end = w.fold(instrs, pos)
+			for end != nil && end.Op.Code != operators.End {
+				c := clause{ins: *end}
+				if end.Op.Code == operators.Delegate {
+					e.clauses = append(e.clauses, c)
+					break
+				}
+				c.body, end = w.fold(instrs, pos)
+				e.clauses = append(e.clauses, c)
 			}
-		case operators.I32Store, operators.I64Store,
-			operators.I32Store8, operators.I64Store8,
-			operators.I32Store16, operators.I64Store16,
-			operators.I64Store32,
-			operators.F32Store, operators.F64Store,
-			operators.I32Load, operators.I64Load,
-			operators.I32Load8u, operators.I32Load8s,
-			operators.I32Load16u, operators.I32Load16s,
-			operators.I64Load8u, operators.I64Load8s,
-			operators.I64Load16u, operators.I64Load16s,
-			operators.I64Load32u, operators.I64Load32s,
-			operators.F32Load, operators.F64Load:
-
-			i1 := ins.Immediates[0].(uint32)
-			i2 := ins.Immediates[1].(uint32)
-			dst := 0 // in log 2 (i8)
-			switch ins.Op.Code {
-			case operators.I64Load, operators.I64Store,
-				operators.F64Load, operators.F64Store:
-				dst = 3
-			case operators.I32Load, operators.I64Load32s, operators.I64Load32u,
-				operators.I32Store, operators.I64Store32,
-				operators.F32Load, operators.F32Store:
-				dst = 2
-			case operators.I32Load16u, operators.I32Load16s, operators.I64Load16u, operators.I64Load16s,
-				operators.I32Store16, operators.I64Store16:
-				dst = 1
-			case operators.I32Load8u, operators.I32Load8s, operators.I64Load8u, operators.I64Load8s,
-				operators.I32Store8, operators.I64Store8:
-				dst = 0
+		}
+		e.results = results
+		n := 0
+		for n < params && n < len(exprs) && exprs[len(exprs)-1-n].results == 1 {
+			n++
+		}
+		e.args = append([]*expr(nil), exprs[len(exprs)-n:]...)
+		exprs = append(exprs[:len(exprs)-n], e)
+	}
+	return exprs, nil
+}
+
+// arity returns the number of operands and of results of ins, as far as
+// they can be known without validating the code. The results of branches
+// and of polymorphic operators are unknown.
+func (w *writer) arity(ins disasm.Instr) (params, results int) {
+	switch ins.Op.Code {
+	case operators.Block, operators.Loop, operators.If, operators.Try:
+		if ins.Op.Code == operators.If {
+			params = 1
+		}
+		if ins.Immediates[0].(wasm.BlockType) != wasm.BlockTypeEmpty {
+			results = 1
+		}
+		return params, results
+	case operators.Call, operators.ReturnCall:
+		sig, ok := w.funcSig(ins.Immediates[0].(uint32))
+		if !ok {
+			return 0, 0
+		}
+		if ins.Op.Code == operators.Call && len(sig.ReturnTypes) == 1 {
+			results = 1
+		}
+		return len(sig.ParamTypes), results
+	case operators.CallIndirect, operators.ReturnCallIndirect:
+		sig, ok := w.typeSig(ins.Immediates[0].(uint32))
+		if !ok {
+			return 1, 0
+		}
+		if ins.Op.Code == operators.CallIndirect && len(sig.ReturnTypes) == 1 {
+			results = 1
+		}
+		return len(sig.ParamTypes) + 1, results
+	case operators.Throw:
+		var params int
+		if w.m.Tag != nil {
+			// tag indices count the imported tags first.
+			i := int(ins.Immediates[0].(uint32)) - w.tagOff
+			if i >= 0 && i < len(w.m.Tag.Entries) {
+				sig, _ := w.typeSig(w.m.Tag.Entries[i].Type)
+				params = len(sig.ParamTypes)
 			}
-			if i2 != 0 {
-				w.Print(" offset=%d", i2)
+		}
+		return params, 0
+	case operators.Return:
+		return w.results, 0
+	case operators.GetLocal, operators.GetGlobal, operators.RefNull:
+		return 0, 1
+	case operators.SetLocal, operators.SetGlobal, operators.Drop, operators.BrIf, operators.BrTable:
+		return 1, 0
+	case operators.TeeLocal, operators.RefIsNull, operators.TableGet:
+		return 1, 1
+	case operators.Select, operators.TypedSelect:
+		return 3, 1
+	case operators.TableSet:
+		return 2, 0
+	case operators.MiscPrefix:
+		switch ins.Op.Sub {
+		case operators.TableGrow:
+			return 2, 1
+		case operators.TableFill:
+			return 3, 0
+		}
+	}
+	if ins.Op.Polymorphic {
+		return 0, 0
+	}
+	if ins.Op.Returns != wasm.ValueType(wasm.BlockTypeEmpty) {
+		results = 1
+	}
+	return len(ins.Op.Args), results
+}
+
+// funcSig returns the signature of the function i.
+func (w *writer) funcSig(i uint32) (wasm.FunctionSig, bool) {
+	if int(i) >= len(w.funcs) {
+		return wasm.FunctionSig{}, false
+	}
+	return w.typeSig(w.funcs[i])
+}
+
+// typeSig returns the signature of the type i.
+func (w *writer) typeSig(i uint32) (wasm.FunctionSig, bool) {
+	if w.m.Types == nil || int(i) >= len(w.m.Types.Entries) {
+		return wasm.FunctionSig{}, false
+	}
+	return w.m.Types.Entries[i], true
+}
+
+// inline reports whether e is written on a single line, which is the case
+// when it doesn't contain any block.
+func (e *expr) inline() bool {
+	if e.body != nil || e.clauses != nil {
+		return false
+	}
+	switch e.ins.Op.Code {
+	case operators.Block, operators.Loop, operators.If, operators.Try:
+		return false
+	}
+	for _, a := range e.args {
+		if !a.inline() {
+			return false
+		}
+	}
+	return true
+}
+
+// writeExpr writes the folded expression e at the indentation level n.
+// The expressions of its operands are written on the same line when they
+// are all inline, and on their own lines otherwise.
+func (w *writer) writeExpr(e *expr, n int) {
+	if n > 0 {
+		w.indent(n)
+	}
+	w.WriteString("(")
+	switch e.ins.Op.Code {
+	case operators.Block, operators.Loop, operators.If, operators.Try:
+	default:
+		w.writeInstr(e.ins)
+		if e.inline() {
+			for _, a := range e.args {
+				w.WriteString(" ")
+				w.writeExpr(a, 0)
 			}
-			if int(i1) != dst {
-				w.Print(" align=%d", 1<<i1)
+		} else {
+			for _, a := range e.args {
+				w.WriteString("\n")
+				w.writeExpr(a, n+1)
 			}
-			continue
 		}
+		w.WriteString(")")
+		return
+	}
+
+	// the label of a block is written before its operands, which are
+	// evaluated outside of it.
+	w.block++
+	w.writeInstr(e.ins)
+	w.block--
+	for _, a := range e.args {
+		w.WriteString("\n")
+		w.writeExpr(a, n+1)
+	}
+	w.block++
+	switch e.ins.Op.Code {
+	case operators.If:
+		w.WriteString("\n")
+		w.indent(n + 1)
+		w.WriteString("(then")
+		w.writeExprs(e.body, n+2)
+		w.WriteString(")")
+	case operators.Try:
+		w.WriteString("\n")
+		w.indent(n + 1)
+		w.WriteString("(do")
+		w.writeExprs(e.body, n+2)
+		w.WriteString(")")
+	default:
+		w.writeExprs(e.body, n+1)
+	}
+	for _, c := range e.clauses {
+		w.WriteString("\n")
+		w.indent(n + 1)
+		w.WriteString("(")
+		if c.ins.Op.Code == operators.Delegate {
+			// the label of a delegate is relative to the outside of the try.
+			w.block--
+			w.writeInstr(c.ins)
+			w.block++
+		} else {
+			w.writeInstr(c.ins)
+			w.writeExprs(c.body, n+2)
+		}
+		w.WriteString(")")
+	}
+	w.block--
+	w.WriteString(")")
+}
+
+func (w *writer) writeExprs(exprs []*expr, n int) {
+	for _, e := range exprs {
+		w.WriteString("\n")
+		w.writeExpr(e, n)
+	}
+}
+
+// writeLabel writes a reference to the label at the relative depth d.
+func (w *writer) writeLabel(d uint32) {
+	block := w.block - int(d)
+	if w.labels && block > 0 {
+		w.Print(" $B%d", block)
+		return
+	}
+	w.Print(" %d (;@%d;)", d, block)
+}
+
+// writeInstr writes the instruction ins with its immediates. The label of
+// a structured instruction is w.block.
+func (w *writer) writeInstr(ins disasm.Instr) {
+	w.WriteString(ins.Op.Name)
+	switch ins.Op.Code {
+	case operators.Catch:
+		w.Print(" %d", ins.Immediates[0].(uint32))
+	case operators.Block, operators.Loop, operators.If, operators.Try:
+		if w.labels {
+			w.Print(" $B%d", w.block)
+		}
+		b := ins.Immediates[0].(wasm.BlockType)
+		if b != wasm.BlockTypeEmpty {
+			w.WriteString(" (result ")
+			w.WriteString(b.String())
+			w.WriteString(")")
+		}
+		switch {
+		case w.labels:
+		case w.folded:
+			w.Print(" (;@%d;)", w.block)
+		default:
+			w.Print("  ;; label = @%d", w.block)
+		}
+	case operators.F32Const:
+		i1 := ins.Immediates[0].(float32)
+		w.WriteString(" " + formatFloat32(i1))
+	case operators.F64Const:
+		i1 := ins.Immediates[0].(float64)
+		w.WriteString(" " + formatFloat64(i1))
+	case operators.BrIf, operators.Br, operators.Rethrow, operators.Delegate:
+		w.writeLabel(ins.Immediates[0].(uint32))
+	case operators.BrTable:
+		n := ins.Immediates[0].(uint32)
+		for i := 0; i < int(n); i++ {
+			w.writeLabel(ins.Immediates[i+1].(uint32))
+		}
+		w.writeLabel(ins.Immediates[n+1].(uint32))
+	case operators.Call, operators.ReturnCall, operators.RefFunc:
+		w.WriteString(" " + ref(w.fnames, ins.Immediates[0].(uint32)))
+	case operators.GetLocal, operators.SetLocal, operators.TeeLocal:
+		w.WriteString(" " + ref(w.locals, ins.Immediates[0].(uint32)))
+	case operators.GetGlobal, operators.SetGlobal:
+		w.WriteString(" " + ref(w.gnames, ins.Immediates[0].(uint32)))
+	case operators.CallIndirect, operators.ReturnCallIndirect:
+		i1 := ins.Immediates[0].(uint32)
+		if table := ins.Immediates[1].(uint32); table != 0 {
+			w.Print(" %d", table)
+		}
+		w.Print(" (type %d)", i1)
+	case operators.RefNull:
+		w.WriteString(" " + heapType(ins.Immediates[0].(wasm.ValueType)))
+	case operators.TypedSelect:
+		w.WriteString(" (result")
+		for _, t := range ins.Immediates[1:] {
+			w.WriteString(" " + t.(wasm.ValueType).String())
+		}
+		w.WriteString(")")
+	case operators.CurrentMemory, operators.GrowMemory:
+		if r := ins.Immediates[0].(uint8); r != 0 {
+			w.Print(" %d", r)
+		}
+	case operators.MiscPrefix:
+		w.writeMiscImmediates(ins)
+	case operators.SIMDPrefix:
+		w.writeSIMDImmediates(ins)
+	case operators.AtomicPrefix:
+		if ins.Op.Sub != operators.AtomicFence {
+			w.writeMemoryImmediate(ins.Immediates, disasm.AtomicAlignment(ins.Op.Sub))
+		}
+	case operators.I32Store, operators.I64Store,
+		operators.I32Store8, operators.I64Store8,
+		operators.I32Store16, operators.I64Store16,
+		operators.I64Store32,
+		operators.F32Store, operators.F64Store,
+		operators.I32Load, operators.I64Load,
+		operators.I32Load8u, operators.I32Load8s,
+		operators.I32Load16u, operators.I32Load16s,
+		operators.I64Load8u, operators.I64Load8s,
+		operators.I64Load16u, operators.I64Load16s,
+		operators.I64Load32u, operators.I64Load32s,
+		operators.F32Load, operators.F64Load:
+		w.writeMemoryImmediate(ins.Immediates, naturalAlignment(ins.Op.Code))
+	default:
 		for _, a := range ins.Immediates {
-			w.WriteString(" ")
-			w.Print("%v", a)
+			w.Print(" %v", a)
 		}
 	}
 }
 
-func formatFloat32(v float32) string {
-	s := ""
-	if v == float32(int32(v)) {
-		s = strconv.FormatInt(int64(v), 10)
-	} else {
-		s = strconv.FormatFloat(float64(v), 'g', -1, 32)
+// writeMiscImmediates writes the immediates of an operator prefixed by
+// operators.MiscPrefix, omitting the memory and table indices when they
+// are all zero.
+func (w *writer) writeMiscImmediates(ins disasm.Instr) {
+	switch ins.Op.Sub {
+	case operators.MemoryInit:
+		// the text format puts the memory index first
+		data, mem := ins.Immediates[0].(uint32), ins.Immediates[1].(uint8)
+		if mem != 0 {
+			w.Print(" %d", mem)
+		}
+		w.Print(" %d", data)
+	case operators.MemoryCopy:
+		dst, src := ins.Immediates[0].(uint8), ins.Immediates[1].(uint8)
+		if dst != 0 || src != 0 {
+			w.Print(" %d %d", dst, src)
+		}
+	case operators.MemoryFill:
+		if mem := ins.Immediates[0].(uint8); mem != 0 {
+			w.Print(" %d", mem)
+		}
+	case operators.TableInit:
+		// the text format puts the table index first
+		elem, table := ins.Immediates[0].(uint32), ins.Immediates[1].(uint32)
+		if table != 0 {
+			w.Print(" %d", table)
+		}
+		w.Print(" %d", elem)
+	case operators.TableCopy:
+		dst, src := ins.Immediates[0].(uint32), ins.Immediates[1].(uint32)
+		if dst != 0 || src != 0 {
+			w.Print(" %d %d", dst, src)
+		}
+	default:
+		for _, a := range ins.Immediates {
+			w.Print(" %v", a)
+		}
 	}
-	return fmt.Sprintf("%#0x (;=%s;)", math.Float32bits(v), s)
 }
 
-func formatFloat64(v float64) string {
-	// TODO: https://github.com/WebAssembly/wabt/blob/master/src/literal.cc (FloatWriter<T>::WriteHex)
-	s := ""
-	if v == float64(int64(v)) {
-		s = strconv.FormatInt(int64(v), 10)
+// writeSIMDImmediates writes the immediates of an operator prefixed by
+// operators.SIMDPrefix. The constant of v128.const is written as four
+// i32 lanes.
+func (w *writer) writeSIMDImmediates(ins disasm.Instr) {
+	imms := ins.Immediates
+	if disasm.IsSIMDMemoryOp(ins.Op.Sub) {
+		w.writeMemoryImmediate(imms, simdNaturalAlignment(ins.Op.Sub))
+		imms = imms[3:]
+	}
+	for _, imm := range imms {
+		switch v := imm.(type) {
+		case [16]byte:
+			if ins.Op.Sub == operators.V128Const {
+				w.WriteString(" i32x4")
+				for i := 0; i < 16; i += 4 {
+					w.Print(" 0x%08x", binary.LittleEndian.Uint32(v[i:]))
+				}
+			} else {
+				for _, lane := range v {
+					w.Print(" %d", lane)
+				}
+			}
+		default:
+			w.Print(" %v", v)
+		}
+	}
+}
+
+// writeMemoryImmediate writes the memory index, alignment and offset of
+// a memory_immediate, omitting the memory index when it is 0, and the
+// alignment when it is the natural alignment of the access, in log 2.
+func (w *writer) writeMemoryImmediate(imms []interface{}, natural uint32) {
+	align, offset := imms[0].(uint32), imms[1].(uint64)
+	if mem := imms[2].(uint8); mem != 0 {
+		w.Print(" %d", mem)
+	}
+	if offset != 0 {
+		w.Print(" offset=%d", offset)
+	}
+	if align != natural {
+		w.Print(" align=%d", 1<<align)
+	}
+}
+
+// simdNaturalAlignment returns the natural alignment, in log 2, of the
+// SIMD memory operator with the sub-opcode sub.
+func simdNaturalAlignment(sub uint32) uint32 {
+	switch sub {
+	case operators.V128Load8Splat, operators.V128Load8Lane, operators.V128Store8Lane:
+		return 0
+	case operators.V128Load16Splat, operators.V128Load16Lane, operators.V128Store16Lane:
+		return 1
+	case operators.V128Load32Splat, operators.V128Load32Lane, operators.V128Store32Lane, operators.V128Load32Zero:
+		return 2
+	case operators.V128Load, operators.V128Store:
+		return 4
+	}
+	// v128.load64_*, and v128.loadNxM which load 8 bytes.
+	return 3
+}
+
+// formatFloat formats the bits of a float constant of the given size as
+// in the wabt text output: nan and inf with their sign, nan:0x with the
+// payload of non-canonical NaNs, and a hexadecimal float otherwise. The
+// decimal value of finite numbers follows in a comment.
+func formatFloat(bits uint64, bitSize int) string {
+	mantBits, expMask := uint(52), uint64(0x7ff)<<52
+	if bitSize == 32 {
+		mantBits, expMask = 23, uint64(0xff)<<23
+	}
+	sign := ""
+	if bits&(1<<uint(bitSize-1)) != 0 {
+		sign = "-"
+	}
+	mant := bits & (1<<mantBits - 1)
+	if bits&expMask == expMask {
+		switch {
+		case mant == 0:
+			return sign + "inf"
+		case mant == 1<<(mantBits-1):
+			return sign + "nan"
+		}
+		return fmt.Sprintf("%snan:0x%x", sign, mant)
+	}
+
+	var v float64
+	if bitSize == 32 {
+		v = float64(math.Float32frombits(uint32(bits)))
+	} else {
+		v = math.Float64frombits(bits)
+	}
+	s := strconv.FormatFloat(math.Abs(v), 'x', -1, bitSize)
+	// strconv writes the exponent with at least two digits.
+	i := strings.IndexByte(s, 'p')
+	exp, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		panic(err)
+	}
+	s = fmt.Sprintf("%s%sp%+d", sign, s[:i], exp)
+
+	d := ""
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		d = strconv.FormatFloat(v, 'f', -1, 64)
 	} else {
-		s = strconv.FormatFloat(float64(v), 'g', -1, 64)
+		d = strconv.FormatFloat(v, 'g', -1, bitSize)
 	}
-	return fmt.Sprintf("%#0x (;=%v;)", math.Float64bits(v), s)
+	return fmt.Sprintf("%s (;=%s;)", s, d)
+}
+
+func formatFloat32(v float32) string {
+	return formatFloat(uint64(math.Float32bits(v)), 32)
+}
+
+func formatFloat64(v float64) string {
+	return formatFloat(math.Float64bits(v), 64)
 }
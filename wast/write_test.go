@@ -64,3 +64,159 @@ func TestAssemble(t *testing.T) {
 		}
 	}
 }
+
+// TestWriteRoundTrip checks that the modules of the scripts of
+// exec/testdata are the same after being written with the options of
+// WriteTo and parsed back.
+func TestWriteRoundTrip(t *testing.T) {
+	fnames, err := filepath.Glob("../exec/testdata/*.wast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := filepath.Glob("../exec/testdata/spec/*.wast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range append(fnames, spec...) {
+		name := fname
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			s, err := wast.ParseScript(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range s.Commands {
+				if c.Type != wast.CommandModule {
+					continue
+				}
+				raw, err := c.Module.Bytes()
+				if err != nil {
+					t.Fatalf("line %d: %v", c.Line, err)
+				}
+				m, err := wasm.DecodeModule(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("line %d: %v", c.Line, err)
+				}
+				want := writeText(t, m)
+				for _, opts := range [][]wast.WriteOption{
+					{wast.Folded(true)},
+					{wast.Labels(true)},
+					{wast.Folded(true), wast.Labels(true)},
+				} {
+					buf := new(bytes.Buffer)
+					if err := wast.WriteTo(buf, m, opts...); err != nil {
+						t.Fatal(err)
+					}
+					if got := writeText(t, roundTrip(t, buf.Bytes())); got != want {
+						t.Fatalf("line %d: got:\n%s\nwant:\n%s\nfrom:\n%s", c.Line, got, want, buf)
+					}
+				}
+			}
+		})
+	}
+}
+
+// nameSection returns a name section with the given subsections.
+func nameSection(t *testing.T, subs map[wasm.NameType]wasm.NameSubsection) *wasm.SectionCustom {
+	names := wasm.NameSection{Types: make(map[wasm.NameType][]byte)}
+	for typ, sub := range subs {
+		buf := new(bytes.Buffer)
+		if err := sub.MarshalWASM(buf); err != nil {
+			t.Fatal(err)
+		}
+		names.Types[typ] = buf.Bytes()
+	}
+	buf := new(bytes.Buffer)
+	if err := names.MarshalWASM(buf); err != nil {
+		t.Fatal(err)
+	}
+	return &wasm.SectionCustom{Name: wasm.CustomSectionName, Data: buf.Bytes()}
+}
+
+func TestWriteNames(t *testing.T) {
+	const src = `(module
+  (import "env" "g" (global (mut i32)))
+  (import "env" "t" (table 1 2 funcref))
+  (import "env" "m" (memory 1))
+  (global i32 (i32.const 1))
+  (func (export "add") (param i32 i32) (result i32) (local i32)
+    (block (result i32)
+      (br_if 0 (local.get 0) (local.get 1))
+      (local.set 2)
+      (i32.add (local.get 2) (global.get 0))))
+  (func (drop (call 0 (global.get 1) (i32.const 2))))
+  (start 1))
+`
+	m := roundTrip(t, []byte(src))
+	m.Customs = append(m.Customs, nameSection(t, map[wasm.NameType]wasm.NameSubsection{
+		wasm.NameFunction: &wasm.FunctionNames{Names: wasm.NameMap{0: "add", 1: "main"}},
+		wasm.NameLocal:    &wasm.LocalNames{Funcs: map[uint32]wasm.NameMap{0: {0: "x", 2: "sum"}}},
+		wasm.NameGlobal:   &wasm.GlobalNames{Names: wasm.NameMap{0: "g", 1: "one two"}},
+	}))
+
+	for _, test := range []struct {
+		opts []wast.WriteOption
+		want string
+	}{
+		{nil, `(module
+  (type (;0;) (func (param i32 i32) (result i32)))
+  (type (;1;) (func))
+  (import "env" "g" (global $g (mut i32)))
+  (import "env" "t" (table (;0;) 1 2 funcref))
+  (import "env" "m" (memory (;0;) 1))
+  (func $add (type 0) (param $x i32) (param i32) (result i32)
+    (local $sum i32)
+    block (result i32)  ;; label = @1
+      get_local $x
+      get_local 1
+      br_if 0 (;@1;)
+      set_local $sum
+      get_local $sum
+      get_global $g
+      i32.add
+    end)
+  (func $main (type 1)
+    get_global $one_two
+    i32.const 2
+    call $add
+    drop)
+  (global $one_two i32 (i32.const 1))
+  (export "add" (func $add))
+  (start $main))
+`},
+		{[]wast.WriteOption{wast.Folded(true), wast.Labels(true)}, `(module
+  (type (;0;) (func (param i32 i32) (result i32)))
+  (type (;1;) (func))
+  (import "env" "g" (global $g (mut i32)))
+  (import "env" "t" (table (;0;) 1 2 funcref))
+  (import "env" "m" (memory (;0;) 1))
+  (func $add (type 0) (param $x i32) (param i32) (result i32)
+    (local $sum i32)
+    (block $B1 (result i32)
+      (get_local $x)
+      (br_if $B1 (get_local 1))
+      (set_local $sum)
+      (i32.add (get_local $sum) (get_global $g))))
+  (func $main (type 1)
+    (drop (call $add (get_global $one_two) (i32.const 2))))
+  (global $one_two i32 (i32.const 1))
+  (export "add" (func $add))
+  (start $main))
+`},
+	} {
+		buf := new(bytes.Buffer)
+		if err := wast.WriteTo(buf, m, test.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+		}
+		if got, want := writeText(t, roundTrip(t, buf.Bytes())), writeText(t, roundTrip(t, []byte(src))); got != want {
+			t.Errorf("parsing the output gives:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}